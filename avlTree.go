@@ -16,6 +16,7 @@ type (
 		right   *avlNode[T]
 		parent  *avlNode[T]
 		balance int
+		size    int // the number of nodes in the subtree, for rank lookups
 	}
 
 	avlTree[T avlKeyType] struct {
@@ -81,6 +82,7 @@ func (op *avlOperation[T]) insertNode(parent *avlNode[T], node *avlNode[T]) (out
 		out = &avlNode[T]{
 			key:    op.key,
 			parent: parent,
+			size:   1,
 		}
 		op.leaf = out
 		op.added = true
@@ -93,6 +95,7 @@ func (op *avlOperation[T]) insertNode(parent *avlNode[T], node *avlNode[T]) (out
 	} else {
 		if op.key < node.key {
 			node.left, balanced = op.insertNode(node, node.left)
+			node.resize()
 			if !balanced {
 				node.balance--
 				if node.balance < -1 {
@@ -102,6 +105,7 @@ func (op *avlOperation[T]) insertNode(parent *avlNode[T], node *avlNode[T]) (out
 			}
 		} else {
 			node.right, balanced = op.insertNode(node, node.right)
+			node.resize()
 			if !balanced {
 				node.balance++
 				if node.balance > 1 {
@@ -148,6 +152,7 @@ func (op *avlOperation[T]) deleteNode(node *avlNode[T]) (out *avlNode[T], rebala
 
 	if op.key <= node.key {
 		node.left, rebalanced = op.deleteNode(node.left)
+		node.resize()
 		if !rebalanced {
 			node.balance++
 			if node.balance > 1 {
@@ -158,6 +163,7 @@ func (op *avlOperation[T]) deleteNode(node *avlNode[T]) (out *avlNode[T], rebala
 		}
 	} else {
 		node.right, rebalanced = op.deleteNode(node.right)
+		node.resize()
 		if !rebalanced {
 			node.balance--
 			if node.balance < -1 {
@@ -172,6 +178,19 @@ func (op *avlOperation[T]) deleteNode(node *avlNode[T]) (out *avlNode[T], rebala
 	return
 }
 
+// the number of nodes in a subtree, which is zero for nil
+func (node *avlNode[T]) sizeOf() int {
+	if node == nil {
+		return 0
+	}
+	return node.size
+}
+
+// worker to recompute the subtree size after its children change
+func (node *avlNode[T]) resize() {
+	node.size = 1 + node.left.sizeOf() + node.right.sizeOf()
+}
+
 // worker to update the balance factor
 func (node *avlNode[T]) adjustBalance(second *avlNode[T], third *avlNode[T], direction int) {
 	switch third.balance {
@@ -200,6 +219,8 @@ func (node *avlNode[T]) rotateLeft(middle *avlNode[T]) *avlNode[T] {
 		middle.parent = nodeParent
 		node.balance = 0
 		middle.balance = 0
+		node.resize()
+		middle.resize()
 		return middle
 	} else {
 		// left-right rotation
@@ -218,6 +239,9 @@ func (node *avlNode[T]) rotateLeft(middle *avlNode[T]) *avlNode[T] {
 		node.parent = third
 		middle.parent = third
 		third.parent = nodeParent
+		middle.resize()
+		node.resize()
+		third.resize()
 		return third
 	}
 }
@@ -234,6 +258,8 @@ func (node *avlNode[T]) rotateRight(middle *avlNode[T]) *avlNode[T] {
 		middle.parent = nodeParent
 		node.balance = 0
 		middle.balance = 0
+		node.resize()
+		middle.resize()
 		return middle
 	} else {
 		// right-left rotation
@@ -252,6 +278,9 @@ func (node *avlNode[T]) rotateRight(middle *avlNode[T]) *avlNode[T] {
 		node.parent = third
 		middle.parent = third
 		third.parent = nodeParent
+		node.resize()
+		middle.resize()
+		third.resize()
 		return third
 	}
 }
@@ -267,6 +296,8 @@ func (node *avlNode[T]) deleteRotateLeft(middle *avlNode[T]) (out *avlNode[T], r
 		middle.parent = nodeParent
 		node.balance = -1
 		middle.balance = 1
+		node.resize()
+		middle.resize()
 		return middle, true
 	} else {
 		return node.rotateLeft(middle), false
@@ -284,6 +315,8 @@ func (node *avlNode[T]) deleteRotateRight(middle *avlNode[T]) (out *avlNode[T],
 		middle.parent = nodeParent
 		node.balance = 1
 		middle.balance = -1
+		node.resize()
+		middle.resize()
 		return middle, true
 	} else {
 		return node.rotateRight(middle), false
@@ -349,6 +382,18 @@ func (node *avlNode[T]) checkParentLinks() bool {
 	return true
 }
 
+// testing function
+func (node *avlNode[T]) checkSizes() bool {
+	if node == nil {
+		return true
+	}
+
+	if !node.left.checkSizes() || !node.right.checkSizes() {
+		return false
+	}
+	return node.size == 1+node.left.sizeOf()+node.right.sizeOf()
+}
+
 // testing function
 func (tree *avlTree[T]) isValid() bool {
 	if !tree.root.checkBalanceFactors() {
@@ -357,6 +402,9 @@ func (tree *avlTree[T]) isValid() bool {
 	if !tree.root.checkParentLinks() {
 		return false
 	}
+	if !tree.root.checkSizes() {
+		return false
+	}
 	return tree.root.isBalanced()
 }
 
@@ -386,6 +434,161 @@ func (node *avlNode[T]) iterateNext(iter AvlIterator[T]) bool {
 	return true
 }
 
+// iterates the AVL tree in reverse sorted order
+func (tree *avlTree[T]) IterateReverse(iter AvlIterator[T]) {
+	tree.root.iteratePrev(iter)
+}
+
+func (node *avlNode[T]) iteratePrev(iter AvlIterator[T]) bool {
+	if node == nil {
+		return true
+	}
+
+	if node.right != nil {
+		if !node.right.iteratePrev(iter) {
+			return false
+		}
+	}
+	if !iter(node) {
+		return false
+	}
+	if node.left != nil {
+		if !node.left.iteratePrev(iter) {
+			return false
+		}
+	}
+	return true
+}
+
+// iterates the AVL tree in sorted order, starting at the first key where
+// atOrAfter returns true; atOrAfter must return false for the low keys and
+// true for the remaining keys
+func (tree *avlTree[T]) IterateFrom(atOrAfter func(key T) bool, iter AvlIterator[T]) {
+	tree.root.iterateNextFrom(atOrAfter, iter)
+}
+
+func (node *avlNode[T]) iterateNextFrom(atOrAfter func(key T) bool, iter AvlIterator[T]) bool {
+	if node == nil {
+		return true
+	}
+
+	if !atOrAfter(node.key) {
+		// the starting point is to the right
+		return node.right.iterateNextFrom(atOrAfter, iter)
+	}
+
+	if !node.left.iterateNextFrom(atOrAfter, iter) {
+		return false
+	}
+	if !iter(node) {
+		return false
+	}
+	return node.right.iterateNext(iter)
+}
+
+// iterates the AVL tree in reverse sorted order, starting at the last key
+// where atOrBefore returns true; atOrBefore must return true for the low
+// keys and false for the remaining keys
+func (tree *avlTree[T]) IterateReverseFrom(atOrBefore func(key T) bool, iter AvlIterator[T]) {
+	tree.root.iteratePrevFrom(atOrBefore, iter)
+}
+
+func (node *avlNode[T]) iteratePrevFrom(atOrBefore func(key T) bool, iter AvlIterator[T]) bool {
+	if node == nil {
+		return true
+	}
+
+	if !atOrBefore(node.key) {
+		// the starting point is to the left
+		return node.left.iteratePrevFrom(atOrBefore, iter)
+	}
+
+	if !node.right.iteratePrevFrom(atOrBefore, iter) {
+		return false
+	}
+	if !iter(node) {
+		return false
+	}
+	return node.left.iteratePrev(iter)
+}
+
+// the number of keys in the AVL tree
+func (tree *avlTree[T]) Count() int {
+	return tree.root.sizeOf()
+}
+
+// provides the zero-based position of a key in sorted order
+func (tree *avlTree[T]) Rank(key T) (rank int, found bool) {
+	n := tree.root
+	for n != nil {
+		if n.key == key {
+			rank += n.left.sizeOf()
+			found = true
+			return
+		}
+
+		if n.key > key {
+			n = n.left
+		} else {
+			rank += n.left.sizeOf() + 1
+			n = n.right
+		}
+	}
+	return
+}
+
+// iterates the AVL tree in sorted order, starting at the key of a
+// zero-based position
+func (tree *avlTree[T]) IterateFromRank(rank int, iter AvlIterator[T]) {
+	tree.root.iterateNextFromRank(rank, iter)
+}
+
+func (node *avlNode[T]) iterateNextFromRank(rank int, iter AvlIterator[T]) bool {
+	if node == nil {
+		return true
+	}
+
+	leftSize := node.left.sizeOf()
+	if rank > leftSize {
+		// the starting point is to the right
+		return node.right.iterateNextFromRank(rank-leftSize-1, iter)
+	}
+
+	if !node.left.iterateNextFromRank(rank, iter) {
+		return false
+	}
+	if !iter(node) {
+		return false
+	}
+	return node.right.iterateNext(iter)
+}
+
+// iterates the AVL tree in reverse sorted order, starting at the key of a
+// zero-based position from the end
+func (tree *avlTree[T]) IterateReverseFromRank(rank int, iter AvlIterator[T]) {
+	tree.root.iteratePrevFromRank(rank, iter)
+}
+
+func (node *avlNode[T]) iteratePrevFromRank(rank int, iter AvlIterator[T]) bool {
+	if node == nil {
+		return true
+	}
+
+	rightSize := node.right.sizeOf()
+	if rank > rightSize {
+		// the starting point is to the left
+		return node.left.iteratePrevFromRank(rank-rightSize-1, iter)
+	}
+
+	if !node.right.iteratePrevFromRank(rank, iter) {
+		return false
+	}
+	if !iter(node) {
+		return false
+	}
+	return node.left.iteratePrev(iter)
+}
+
 // testing function
 func (tree *avlTree[T]) countEach() int {
 	count := 0
@@ -738,3 +738,127 @@ func TestAvlInsertDeleteRandom(t *testing.T) {
 		t.Fatal("imbalanced")
 	}
 }
+
+func TestAvlIterateReverse(t *testing.T) {
+	tree := NewAvlTree[float64]()
+	for _, v := range rand.Perm(100) {
+		tree.Add(float64(v))
+	}
+
+	expected := 99.0
+	tree.IterateReverse(func(node *avlNode[float64]) bool {
+		if node.key != expected {
+			t.Fatalf("expected %v, got %v", expected, node.key)
+		}
+		expected--
+		return true
+	})
+	if expected != -1 {
+		t.Fatal("reverse iteration incomplete")
+	}
+}
+
+func TestAvlIterateFrom(t *testing.T) {
+	tree := NewAvlTree[float64]()
+	for _, v := range rand.Perm(100) {
+		tree.Add(float64(v * 2))
+	}
+
+	for start := -1; start <= 200; start++ {
+		expected := float64(start)
+		if start < 0 {
+			expected = 0
+		} else if start%2 != 0 {
+			expected++
+		}
+
+		tree.IterateFrom(func(key float64) bool { return key >= float64(start) }, func(node *avlNode[float64]) bool {
+			if node.key != expected {
+				t.Fatalf("start %d: expected %v, got %v", start, expected, node.key)
+			}
+			expected += 2
+			return true
+		})
+		if expected < 200 {
+			t.Fatalf("start %d: iteration incomplete", start)
+		}
+
+		expected = float64(start)
+		if start > 198 {
+			expected = 198
+		} else if start%2 != 0 {
+			expected--
+		}
+
+		tree.IterateReverseFrom(func(key float64) bool { return key <= float64(start) }, func(node *avlNode[float64]) bool {
+			if node.key != expected {
+				t.Fatalf("start %d: expected %v, got %v", start, expected, node.key)
+			}
+			expected -= 2
+			return true
+		})
+		if expected > -2 {
+			t.Fatalf("start %d: reverse iteration incomplete", start)
+		}
+	}
+
+	count := 0
+	tree.IterateFrom(func(key float64) bool { return key >= 50 }, func(node *avlNode[float64]) bool {
+		count++
+		return count < 3
+	})
+	if count != 3 {
+		t.Fatal("early stop failed")
+	}
+}
+
+func TestAvlRank(t *testing.T) {
+	tree := NewAvlTree[float64]()
+	for _, v := range rand.Perm(200) {
+		tree.Add(float64(v * 2))
+	}
+	for _, v := range rand.Perm(100) {
+		tree.Delete(float64(v * 4))
+	}
+	if !tree.isValid() || tree.Count() != 100 {
+		t.Fatal("tree invalid")
+	}
+
+	// the remaining keys are 2, 6, 10, ...
+	for rank := 0; rank < 100; rank++ {
+		key := float64(rank*4 + 2)
+		r, found := tree.Rank(key)
+		if !found || r != rank {
+			t.Fatalf("rank of %v: expected %d, got %d", key, rank, r)
+		}
+		if _, found = tree.Rank(key + 1); found {
+			t.Fatalf("rank of %v shouldn't be found", key+1)
+		}
+	}
+
+	for start := 0; start <= 100; start++ {
+		expected := float64(start*4 + 2)
+		tree.IterateFromRank(start, func(node *avlNode[float64]) bool {
+			if node.key != expected {
+				t.Fatalf("start %d: expected %v, got %v", start, expected, node.key)
+			}
+			expected += 4
+			return true
+		})
+		if expected != 402 {
+			t.Fatalf("start %d: iteration incomplete", start)
+		}
+
+		expected = float64((99-start)*4 + 2)
+		tree.IterateReverseFromRank(start, func(node *avlNode[float64]) bool {
+			if node.key != expected {
+				t.Fatalf("start %d: expected %v, got %v", start, expected, node.key)
+			}
+			expected -= 4
+			return true
+		})
+		if expected != -2 {
+			t.Fatalf("start %d: reverse iteration incomplete", start)
+		}
+	}
+}
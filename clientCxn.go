@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"sync"
 	"time"
 
@@ -33,6 +34,7 @@ type (
 		cs          *clientState
 		started     time.Time
 		mu          sync.Mutex // synchronizes access to waiting, closing flags
+		wmu         sync.Mutex // serializes writes to the socket
 		cxn         net.Conn
 		socketState cxnState
 		csceCh      chan *clientStateEvent
@@ -115,10 +117,8 @@ func (cc *clientCxn) IsCloseRequested() bool {
 	return cc.closing
 }
 
-var _ = requestAllCxnClose
-
-func requestAllCxnClose() {
-	processAllClients(func(id int64, cs *clientState) {
+func (dss *dataStoreSet) requestAllCxnClose() {
+	dss.processAllClients(func(id int64, cs *clientState) {
 		cc, ok := cs.client.(*clientCxn)
 		if ok {
 			cc.RequestClose()
@@ -126,11 +126,11 @@ func requestAllCxnClose() {
 	})
 }
 
-var _ = waitForAllCxnClose
+var _ = (*dataStoreSet).waitForAllCxnClose
 
-func waitForAllCxnClose() {
+func (dss *dataStoreSet) waitForAllCxnClose() {
 	for {
-		if !isClientActive() {
+		if !dss.isClientActive() {
 			break
 		}
 		time.Sleep(50 * time.Millisecond)
@@ -179,12 +179,31 @@ func (cc *clientCxn) onWaitForCommand() {
 		cc.waiting = true
 		cc.mu.Unlock()
 
+		// an idle client is closed after the timeout, unless it is subscribed
+		// or monitoring
+		timeout := cc.cs.dss.config.intValue("timeout")
+		if timeout > 0 && !cc.cs.isSubscribed() && !cc.cs.isMonitoring() {
+			cc.cxn.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+		} else {
+			cc.cxn.SetReadDeadline(time.Time{})
+		}
+
 		n, err := cc.cxn.Read(buffer)
 
 		cc.mu.Lock()
 		cc.waiting = false
 		cc.mu.Unlock()
 
+		if errors.Is(err, os.ErrDeadlineExceeded) && n == 0 {
+			if cc.cs.isSubscribed() || cc.cs.isMonitoring() {
+				cc.queueStateChange(csWaitForCommand, nil)
+				return
+			}
+			cc.cs.l.Debugf("closing idle client %s", cc.cxn.RemoteAddr().String())
+			cc.queueStateChange(csTerminate, nil)
+			return
+		}
+
 		if err != nil {
 			if !errors.Is(err, io.EOF) {
 				cc.cs.l.Debugf("read error from %s: %s", cc.cxn.RemoteAddr().String(), err)
@@ -206,10 +225,7 @@ func (cc *clientCxn) onWaitForCommand() {
 	if length == 0 {
 		cc.queueStateChange(csWaitForCommand, nil)
 	} else {
-		infoMu.Lock()
-		info.total_net_input_bytes += int64(length)
-		info.total_reads_processed++
-		infoMu.Unlock()
+		cc.cs.dss.info.addInput(length)
 		cc.inbound = cc.inbound[length:]
 		cc.queueStateChange(csDispatchCommand, cmd)
 	}
@@ -230,23 +246,43 @@ func (cc *clientCxn) parseCommand() (cmd respValue, length int) {
 func (cc *clientCxn) onDispatchCommand(cmd respValue) {
 	go func() {
 		returnVal := cc.cs.dispatch(cmd)
-		sendData := returnVal.serialize()
-		n, err := cc.cxn.Write(sendData)
+		if cc.cs.noReply {
+			cc.RequestClose()
+			return
+		}
+		n, err := cc.write(returnVal)
 		if err != nil {
 			cc.cs.l.Debugf("write error: %s", err)
 			cc.cxn.Close()
 		} else {
 			cc.cs.l.Tracef("wrote %d bytes", n)
-			infoMu.Lock()
-			info.total_net_output_bytes += int64(n)
-			info.total_writes_processed++
-			info.total_commands_processed++
-			infoMu.Unlock()
+			cc.cs.dss.info.addOutput(n, true)
 			cc.queueStateChange(csWaitForCommand, nil)
 		}
 	}()
 }
 
+// writes a command response or an out-of-band message to the socket
+func (cc *clientCxn) write(output respValue) (n int, err error) {
+	sendData := output.serialize()
+
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+	return cc.cxn.Write(sendData)
+}
+
+// sends a message that isn't a command response, such as a RESP3 push
+func (cc *clientCxn) SendOutOfBand(output respValue) {
+	n, err := cc.write(output)
+	if err != nil {
+		cc.cs.l.Debugf("out-of-band write error: %s", err)
+		return
+	}
+
+	cc.cs.l.Tracef("wrote %d out-of-band bytes", n)
+	cc.cs.dss.info.addOutput(n, false)
+}
+
 func (cc *clientCxn) ServerAddr() string {
 	return cc.cxn.LocalAddr().String()
 }
@@ -41,6 +41,8 @@ type (
 		client          RedisClient
 		disp            *cmdDispatcher
 		cmdQueue        *[]*cmdContext
+		cmdQueueErr     bool // a command could not be queued
+		noReply         bool // the connection closes without replying to the command
 		watches         map[watchKey]uint64
 		blocked         int32
 		unblockPending  int32
@@ -50,53 +52,84 @@ type (
 		multiInProgress bool
 		libName         string
 		libVer          string
+		tracking        *clientTracking
+		channels        map[string]struct{}
+		patterns        map[string]struct{}
+		shardChannels   map[string]struct{}
 	}
-)
 
-var clientId int64
-var clientsMu sync.Mutex
-var clients = map[int64]*clientState{}
+	// clientRegistry is the table of the clients of an emulator, by id
+	clientRegistry struct {
+		mu      sync.Mutex
+		lastId  int64
+		clients map[int64]*clientState
+	}
+)
 
 func newClientState(l lane.Lane, client RedisClient, dispatcher *cmdDispatcher) *clientState {
 	cs := &clientState{
-		l:           l,
-		user:        "default",
-		client:      client,
-		disp:        dispatcher,
-		dss:         dispatcher.dss,
-		respVersion: 2,
-		unblockCh:   make(chan unblockReason, 1),
-		watches:     map[watchKey]uint64{},
+		l:             l,
+		user:          "default",
+		client:        client,
+		disp:          dispatcher,
+		dss:           dispatcher.dss,
+		respVersion:   2,
+		unblockCh:     make(chan unblockReason, 1),
+		watches:       map[watchKey]uint64{},
+		channels:      map[string]struct{}{},
+		patterns:      map[string]struct{}{},
+		shardChannels: map[string]struct{}{},
 	}
 
 	cs.ds, _ = cs.dss.getDb(0, true)
+	cs.dss.registerClient(cs)
 
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
-	clientId++
-	cs.id = clientId
-	clients[clientId] = cs
+	return cs
+}
 
-	infoMu.Lock()
-	info.connected_clients++
-	info.total_connections_received++
-	infoMu.Unlock()
+// adds a client to the table of the emulator, assigning its id
+func (dss *dataStoreSet) registerClient(cs *clientState) {
+	cr := &dss.clients
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.lastId++
+	cs.id = cr.lastId
+	cr.clients[cs.id] = cs
+
+	dss.info.mu.Lock()
+	dss.info.connected_clients++
+	dss.info.total_connections_received++
+	dss.info.mu.Unlock()
+}
 
-	return cs
+func (dss *dataStoreSet) clientCount() int {
+	dss.clients.mu.Lock()
+	defer dss.clients.mu.Unlock()
+
+	return len(dss.clients.clients)
+}
+
+func (dss *dataStoreSet) isClientActive() bool {
+	dss.clients.mu.Lock()
+	defer dss.clients.mu.Unlock()
+
+	return len(dss.clients.clients) > 0
 }
 
-func isClientActive() bool {
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
+func (dss *dataStoreSet) clientById(id int64) (cs *clientState, exists bool) {
+	dss.clients.mu.Lock()
+	defer dss.clients.mu.Unlock()
 
-	return len(clients) > 0
+	cs, exists = dss.clients.clients[id]
+	return
 }
 
-func processAllClients(op func(id int64, cs *clientState)) {
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
+// calls op for each client of the emulator that isn't closing
+func (dss *dataStoreSet) processAllClients(op func(id int64, cs *clientState)) {
+	dss.clients.mu.Lock()
+	defer dss.clients.mu.Unlock()
 
-	for id, cs := range clients {
+	for id, cs := range dss.clients.clients {
 		if !cs.client.IsCloseRequested() {
 			op(id, cs)
 		}
@@ -104,18 +137,24 @@ func processAllClients(op func(id int64, cs *clientState)) {
 }
 
 func (cs *clientState) unregister() {
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
+	cs.unregisterTracking()
+	cs.unsubscribeAll()
+	cs.stopMonitoring()
+
+	cr := &cs.dss.clients
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
 
-	delete(clients, cs.id)
+	delete(cr.clients, cs.id)
 
-	infoMu.Lock()
-	info.connected_clients--
+	ri := &cs.dss.info
+	ri.mu.Lock()
+	ri.connected_clients--
 
-	if info.connected_clients != int64(len(clients)) {
+	if ri.connected_clients != int64(len(cr.clients)) {
 		panic("statistics out of sync with client table")
 	}
-	infoMu.Unlock()
+	ri.mu.Unlock()
 }
 
 func (cs *clientState) setLock(from, to int32) {
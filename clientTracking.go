@@ -0,0 +1,522 @@
+package redisemu
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type (
+	// clientTracking holds the server-assisted client-side caching settings
+	// of a client that turned on CLIENT TRACKING.
+	clientTracking struct {
+		redirect       *clientState // nil when invalidations go to the tracking client
+		redirectId     int64
+		redirectBroken bool
+		bcast          bool
+		optIn          bool
+		optOut         bool
+		noLoop         bool
+		caching        bool // CLIENT CACHING was issued for the next command
+		prefixes       []string
+	}
+
+	// trackingRegistry holds the tracking tables of the clients of an
+	// emulator. The tables are shared by the databases, because (like
+	// redis) tracked keys are not associated with a database. All tracking
+	// state, including clientState.tracking, is protected by mu.
+	trackingRegistry struct {
+		mu       sync.Mutex
+		clients  map[*clientState]struct{}
+		keys     map[string]map[*clientState]struct{}
+		prefixes map[string]map[*clientState]struct{}
+	}
+
+	// trackingInvalidation is an invalidation message for a client, made
+	// under the tracking lock and sent after it is released
+	trackingInvalidation struct {
+		target  *clientState
+		msg     respValue
+		channel bool // a RESP2 message of trackingChannelName, sent if subscribed
+	}
+)
+
+const trackingChannelName = "__redis__:invalidate"
+
+func newTrackingRegistry() trackingRegistry {
+	return trackingRegistry{
+		clients:  map[*clientState]struct{}{},
+		keys:     map[string]map[*clientState]struct{}{},
+		prefixes: map[string]map[*clientState]struct{}{},
+	}
+}
+
+func fnClientTracking(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	if _, off := args["status.off"]; off {
+		ctx.cs.disableTracking()
+		output.data = rstrOK
+		return
+	}
+
+	t := &clientTracking{}
+	_, t.bcast = args["bcast"]
+	_, t.optIn = args["optin"]
+	_, t.optOut = args["optout"]
+	_, t.noLoop = args["noloop"]
+
+	prefixes, _ := args["prefix"].([]any)
+	for _, prefix := range prefixes {
+		t.prefixes = append(t.prefixes, prefix.(string))
+	}
+
+	redirectId, hasRedirect := args["client-id"].(int64)
+	if hasRedirect && redirectId != 0 {
+		target, exists := ctx.cs.dss.clientById(redirectId)
+		if !exists {
+			output.data = respErrorString("ERR The client ID you want redirect to does not exist")
+			return
+		}
+		t.redirect = target
+		t.redirectId = redirectId
+	}
+
+	if errText := ctx.cs.enableTracking(t); errText != "" {
+		output.data = errText
+		return
+	}
+
+	output.data = rstrOK
+	return
+}
+
+func fnClientCaching(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	_, yes := args["mode.yes"]
+
+	ctx.cs.dss.tracking.mu.Lock()
+	defer ctx.cs.dss.tracking.mu.Unlock()
+
+	t := ctx.cs.tracking
+	if t == nil {
+		output.data = respErrorString("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+		return
+	}
+
+	if yes && !t.optIn {
+		output.data = respErrorString("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+		return
+	}
+	if !yes && !t.optOut {
+		output.data = respErrorString("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+		return
+	}
+
+	t.caching = true
+	output.data = rstrOK
+	return
+}
+
+func fnClientGetRedir(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	ctx.cs.dss.tracking.mu.Lock()
+	defer ctx.cs.dss.tracking.mu.Unlock()
+
+	t := ctx.cs.tracking
+	if t == nil {
+		output.data = respInt(-1)
+	} else {
+		output.data = respInt(t.redirectId)
+	}
+	return
+}
+
+func fnClientTrackingInfo(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	ctx.cs.dss.tracking.mu.Lock()
+	defer ctx.cs.dss.tracking.mu.Unlock()
+
+	flags := []string{}
+	redirect := int64(-1)
+	prefixes := respArray{}
+
+	t := ctx.cs.tracking
+	if t == nil {
+		flags = append(flags, "off")
+	} else {
+		flags = append(flags, "on")
+		if t.bcast {
+			flags = append(flags, "bcast")
+		}
+		if t.optIn {
+			flags = append(flags, "optin")
+			if t.caching {
+				flags = append(flags, "caching-yes")
+			}
+		}
+		if t.optOut {
+			flags = append(flags, "optout")
+			if t.caching {
+				flags = append(flags, "caching-no")
+			}
+		}
+		if t.noLoop {
+			flags = append(flags, "noloop")
+		}
+		if t.redirectBroken {
+			flags = append(flags, "broken_redirect")
+		}
+
+		redirect = t.redirectId
+		for _, prefix := range t.prefixes {
+			prefixes = append(prefixes, respValue{data: respBulkString(prefix)})
+		}
+	}
+
+	flagSet := respSet{}
+	for _, flag := range flags {
+		flagSet[respValue{data: respBulkString(flag)}] = struct{}{}
+	}
+
+	m := newRespMapSized(3)
+	m.set(respValue{data: respBulkString("flags")}, respValue{data: flagSet})
+	m.set(respValue{data: respBulkString("redirect")}, respValue{data: respInt(redirect)})
+	m.set(respValue{data: respBulkString("prefixes")}, respValue{data: prefixes})
+	output.data = m
+	return
+}
+
+// applies CLIENT TRACKING ON, validating the change against the current mode
+func (cs *clientState) enableTracking(t *clientTracking) (errText respErrorString) {
+	cs.dss.tracking.mu.Lock()
+	defer cs.dss.tracking.mu.Unlock()
+
+	prior := cs.tracking
+
+	if !t.bcast && len(t.prefixes) > 0 {
+		return "ERR PREFIX option requires BCAST mode to be enabled"
+	}
+	if prior != nil && prior.bcast != t.bcast {
+		return "ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode."
+	}
+	if t.bcast && (t.optIn || t.optOut) {
+		return "ERR OPTIN and OPTOUT are not compatible with BCAST"
+	}
+	if t.optIn && t.optOut {
+		return "ERR You can't use both OPTIN and OPTOUT"
+	}
+	if prior != nil && (prior.optIn != t.optIn || prior.optOut != t.optOut) {
+		return "ERR You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode."
+	}
+
+	if t.bcast {
+		if len(t.prefixes) == 0 {
+			t.prefixes = []string{""}
+		}
+
+		// prefixes of a single client must not overlap, otherwise a key
+		// would be invalidated more than once
+		for idx, prefix := range t.prefixes {
+			if prior != nil {
+				for _, existing := range prior.prefixes {
+					if existing != prefix && (strings.HasPrefix(existing, prefix) || strings.HasPrefix(prefix, existing)) {
+						return respErrorString(fmt.Sprintf("ERR Prefix '%s' overlaps with an existing prefix '%s'. Prefixes for a single client must not overlap.", prefix, existing))
+					}
+				}
+			}
+			for _, other := range t.prefixes[idx+1:] {
+				if other != prefix && (strings.HasPrefix(other, prefix) || strings.HasPrefix(prefix, other)) {
+					return respErrorString(fmt.Sprintf("ERR Prefix '%s' overlaps with another provided prefix '%s'. Prefixes for a single client must not overlap.", prefix, other))
+				}
+			}
+		}
+
+		// prefixes accumulate while tracking stays on
+		if prior != nil {
+			for _, existing := range prior.prefixes {
+				found := false
+				for _, prefix := range t.prefixes {
+					if prefix == existing {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.prefixes = append(t.prefixes, existing)
+				}
+			}
+		}
+
+		for _, prefix := range t.prefixes {
+			subscribers, exists := cs.dss.tracking.prefixes[prefix]
+			if !exists {
+				subscribers = map[*clientState]struct{}{}
+				cs.dss.tracking.prefixes[prefix] = subscribers
+			}
+			subscribers[cs] = struct{}{}
+		}
+	}
+
+	cs.tracking = t
+	cs.dss.tracking.clients[cs] = struct{}{}
+	return
+}
+
+// applies CLIENT TRACKING OFF
+func (cs *clientState) disableTracking() {
+	cs.dss.tracking.mu.Lock()
+	defer cs.dss.tracking.mu.Unlock()
+
+	cs.disableTrackingUnlocked()
+}
+
+func (cs *clientState) disableTrackingUnlocked() {
+	t := cs.tracking
+	if t == nil {
+		return
+	}
+
+	for _, prefix := range t.prefixes {
+		subscribers := cs.dss.tracking.prefixes[prefix]
+		delete(subscribers, cs)
+		if len(subscribers) == 0 {
+			delete(cs.dss.tracking.prefixes, prefix)
+		}
+	}
+
+	// entries in cs.dss.tracking.keys are discarded lazily, upon invalidation
+	cs.tracking = nil
+	delete(cs.dss.tracking.clients, cs)
+}
+
+// stops tracking for a departing client, and marks the redirection of
+// clients that were sending their invalidations to it as broken
+func (cs *clientState) unregisterTracking() {
+	cs.dss.tracking.mu.Lock()
+	defer cs.dss.tracking.mu.Unlock()
+
+	cs.disableTrackingUnlocked()
+
+	for tcs := range cs.dss.tracking.clients {
+		if tcs.tracking.redirect == cs {
+			tcs.tracking.redirectBroken = true
+		}
+	}
+}
+
+// clears the CLIENT CACHING setting that applies to a single command
+func (cs *clientState) resetTrackingCaching() {
+	cs.dss.tracking.mu.Lock()
+	defer cs.dss.tracking.mu.Unlock()
+
+	if cs.tracking != nil {
+		cs.tracking.caching = false
+	}
+}
+
+// provides the tracking counts of INFO: the clients with tracking turned
+// on, the tracked keys, the clients that track each of the keys summed
+// over the keys, and the BCAST prefixes
+func (dss *dataStoreSet) trackingCounts() (clients, keys, items, prefixes int) {
+	dss.tracking.mu.Lock()
+	defer dss.tracking.mu.Unlock()
+
+	for _, subscribers := range dss.tracking.keys {
+		items += len(subscribers)
+	}
+	return len(dss.tracking.clients), len(dss.tracking.keys), items, len(dss.tracking.prefixes)
+}
+
+// indicates if the keys read by the current command are to be tracked
+func (cs *clientState) isTrackingKeys() bool {
+	cs.dss.tracking.mu.Lock()
+	defer cs.dss.tracking.mu.Unlock()
+
+	t := cs.tracking
+	if t == nil || t.bcast {
+		return false
+	}
+
+	if t.optIn {
+		return t.caching
+	}
+	if t.optOut {
+		return !t.caching
+	}
+	return true
+}
+
+func (cs *clientState) trackingRememberKeys(keyNames []string) {
+	cs.dss.tracking.mu.Lock()
+	defer cs.dss.tracking.mu.Unlock()
+
+	for _, keyName := range keyNames {
+		subscribers, exists := cs.dss.tracking.keys[keyName]
+		if !exists {
+			subscribers = map[*clientState]struct{}{}
+			cs.dss.tracking.keys[keyName] = subscribers
+		}
+		subscribers[cs] = struct{}{}
+	}
+}
+
+// makes the invalidation message for the tracking client, addressed to the
+// client or its redirect target; the message is sent after trackingMu is
+// released, like a pub/sub message
+func (cs *clientState) invalidationUnlocked(keys respValue) (inv trackingInvalidation, send bool) {
+	t := cs.tracking
+	target := cs
+
+	if t.redirect != nil {
+		if t.redirectBroken {
+			// let the tracking client know its invalidations are being lost
+			if cs.respVersion < 3 {
+				return
+			}
+			inv.target = cs
+			inv.msg = respValue{data: respPush{
+				kind: "tracking-redir-broken",
+				data: []respValue{{data: respInt(t.redirectId)}},
+			}}
+			send = true
+			return
+		}
+		target = t.redirect
+	}
+
+	inv.target = target
+	if target.respVersion < 3 {
+		// RESP2 doesn't have push messages; a RESP2 redirect target
+		// receives invalidations as pub/sub messages of trackingChannelName,
+		// and a RESP2 client that doesn't redirect receives nothing
+		if t.redirect == nil {
+			return
+		}
+		inv.msg = pubsubMessage("message", respValue{data: respBulkString(trackingChannelName)}, keys)
+		inv.channel = true
+	} else {
+		inv.msg = respValue{data: respPush{kind: "invalidate", data: []respValue{keys}}}
+	}
+	send = true
+	return
+}
+
+// sends an invalidation message made by invalidationUnlocked
+func (inv trackingInvalidation) send() {
+	if inv.channel {
+		if inv.target.isSubscribedTo(trackingChannelName) {
+			inv.target.sendMessage(inv.msg)
+		}
+		return
+	}
+	inv.target.client.SendOutOfBand(inv.msg)
+}
+
+// notifies tracking clients that a key has been modified; origin is the
+// client that made the modification (if any), for NOLOOP
+func (dss *dataStoreSet) trackingInvalidateKey(keyName string, origin *clientState) {
+	keys := respValue{data: respArray{{data: respBulkString(keyName)}}}
+	invalidations := []trackingInvalidation{}
+
+	dss.tracking.mu.Lock()
+
+	if len(dss.tracking.clients) == 0 {
+		dss.tracking.mu.Unlock()
+		return
+	}
+
+	subscribers, exists := dss.tracking.keys[keyName]
+	if exists {
+		// the client must read the key again to be notified again
+		delete(dss.tracking.keys, keyName)
+
+		for cs := range subscribers {
+			t := cs.tracking
+			if t == nil || t.bcast {
+				continue
+			}
+			if cs == origin && t.noLoop {
+				continue
+			}
+			if inv, send := cs.invalidationUnlocked(keys); send {
+				invalidations = append(invalidations, inv)
+			}
+		}
+	}
+
+	for prefix, subscribers := range dss.tracking.prefixes {
+		if !strings.HasPrefix(keyName, prefix) {
+			continue
+		}
+		for cs := range subscribers {
+			if cs == origin && cs.tracking.noLoop {
+				continue
+			}
+			if inv, send := cs.invalidationUnlocked(keys); send {
+				invalidations = append(invalidations, inv)
+			}
+		}
+	}
+
+	dss.tracking.mu.Unlock()
+
+	// the socket writes are made outside of the lock, so that a slow
+	// client doesn't hold up the others
+	for _, inv := range invalidations {
+		inv.send()
+	}
+}
+
+// notifies the tracking clients of the emulator that a key of a database
+// has been modified; a standalone data store has no clients
+func (ds *dataStore) trackingInvalidateKey(keyName string) {
+	if ds.dss != nil {
+		ds.dss.trackingInvalidateKey(keyName, nil)
+	}
+}
+
+// notifies all tracking clients that every key is invalid
+func (dss *dataStoreSet) trackingInvalidateAll() {
+	invalidations := []trackingInvalidation{}
+
+	dss.tracking.mu.Lock()
+	dss.tracking.keys = map[string]map[*clientState]struct{}{}
+	for cs := range dss.tracking.clients {
+		if inv, send := cs.invalidationUnlocked(respValue{data: respNull{}}); send {
+			invalidations = append(invalidations, inv)
+		}
+	}
+	dss.tracking.mu.Unlock()
+
+	for _, inv := range invalidations {
+		inv.send()
+	}
+}
+
+// remembers the keys read by a tracking client; the keys changed by a
+// command are invalidated by the data store as it reports the change
+func (cd *cmdDispatcher) trackKeys(ctx *cmdContext, result respValue) {
+	if result.isErrorType() || !ctx.cs.isTrackingKeys() {
+		return
+	}
+
+	info, exists := cd.infoTable.table[ctx.cmdToken]
+	if !exists || !info.hasFlag("readonly") {
+		return
+	}
+
+	keys, flags, errText := cd.getKeysFromSpecs(ctx.rawArgs)
+	if errText != "" {
+		return
+	}
+
+	readKeys := make([]string, 0, len(keys))
+	for idx, keyName := range keys {
+		for _, flag := range flags[idx] {
+			if flag == "RO" {
+				readKeys = append(readKeys, keyName)
+				break
+			}
+		}
+	}
+
+	if len(readKeys) > 0 {
+		ctx.cs.trackingRememberKeys(readKeys)
+	}
+}
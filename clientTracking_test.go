@@ -0,0 +1,685 @@
+package redisemu
+
+import (
+	"testing"
+	"time"
+)
+
+// checks that the pushes are invalidation messages for the specified keys, one key
+// per message; a nil key expects the flush (null) invalidation
+func isInvalidations(pushes []respValue, keys ...any) bool {
+	if len(pushes) != len(keys) {
+		return false
+	}
+
+	for idx, push := range pushes {
+		p, valid := push.data.(respPush)
+		if !valid || p.kind != "invalidate" || len(p.data) != 1 {
+			return false
+		}
+		if keys[idx] == nil {
+			if !p.data[0].isNull() {
+				return false
+			}
+		} else if !p.data[0].isArray(keys[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestClientTrackingDefault(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	output := ts.ProcessCommand("client", "tracking", "on")
+	if !output.isString("OK") {
+		t.Fatal("tracking on fail")
+	}
+
+	output = ts.ProcessCommand("get", "k1")
+	if !output.isNull() {
+		t.Fatal("tracking get k1 fail")
+	}
+
+	output = ts.ProcessCommand("mget", "k2", "k3")
+	if !output.isArray(nil, nil) {
+		t.Fatal("tracking mget fail")
+	}
+
+	// keys that were not read are not tracked
+	output = ts2.ProcessCommand("set", "k4", "v")
+	if !output.isString("OK") {
+		t.Fatal("tracking set k4 fail")
+	}
+
+	if len(ts.PendingPushes()) != 0 {
+		t.Fatal("tracking untracked key fail")
+	}
+
+	output = ts2.ProcessCommand("mset", "k1", "v", "k3", "v")
+	if !output.isString("OK") {
+		t.Fatal("tracking mset fail")
+	}
+
+	if !isInvalidations(ts.PendingPushes(), "k1", "k3") {
+		t.Fatal("tracking invalidation fail")
+	}
+
+	// invalidation is sent once per read
+	output = ts2.ProcessCommand("del", "k1")
+	if !output.isInt(1) {
+		t.Fatal("tracking del fail")
+	}
+
+	if len(ts.PendingPushes()) != 0 {
+		t.Fatal("tracking repeat invalidation fail")
+	}
+
+	// the tracking client's own changes are included
+	output = ts.ProcessCommand("get", "k2")
+	if !output.isNull() {
+		t.Fatal("tracking get k2 fail")
+	}
+
+	output = ts.ProcessCommand("set", "k2", "v")
+	if !output.isString("OK") {
+		t.Fatal("tracking own set fail")
+	}
+
+	if !isInvalidations(ts.PendingPushes(), "k2") {
+		t.Fatal("tracking own invalidation fail")
+	}
+
+	// turned off, nothing is sent
+	output = ts.ProcessCommand("get", "k3")
+	if !output.isString("v") {
+		t.Fatal("tracking get k3 fail")
+	}
+
+	output = ts.ProcessCommand("client", "tracking", "off")
+	if !output.isString("OK") {
+		t.Fatal("tracking off fail")
+	}
+
+	output = ts2.ProcessCommand("set", "k3", "v2")
+	if !output.isString("OK") {
+		t.Fatal("tracking set k3 fail")
+	}
+
+	if len(ts.PendingPushes()) != 0 {
+		t.Fatal("tracking off invalidation fail")
+	}
+}
+
+func TestClientTrackingKeywordKeys(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	output := ts.ProcessCommand("client", "tracking", "on")
+	if !output.isString("OK") {
+		t.Fatal("tracking on fail")
+	}
+
+	// the keys of XREAD follow the STREAMS keyword
+	output = ts.ProcessCommand("xread", "count", "2", "streams", "s1", "s2", "0", "0")
+	if !output.isNull() {
+		t.Fatal("tracking xread fail")
+	}
+
+	output = ts2.ProcessCommand("xadd", "s1", "1-0", "f", "v")
+	if !output.isString("1-0") {
+		t.Fatal("tracking xadd s1 fail")
+	}
+
+	if !isInvalidations(ts.PendingPushes(), "s1") {
+		t.Fatal("tracking xadd s1 invalidation fail")
+	}
+
+	output = ts2.ProcessCommand("xadd", "s2", "1-0", "f", "v")
+	if !output.isString("1-0") {
+		t.Fatal("tracking xadd s2 fail")
+	}
+
+	if !isInvalidations(ts.PendingPushes(), "s2") {
+		t.Fatal("tracking xadd s2 invalidation fail")
+	}
+}
+
+func TestClientTrackingUnchangedKey(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	ts2.ProcessCommand("set", "k1", "v")
+	ts2.ProcessCommand("rpush", "k2", "a")
+
+	output := ts.ProcessCommand("client", "tracking", "on")
+	if !output.isString("OK") {
+		t.Fatal("tracking on fail")
+	}
+
+	ts.ProcessCommand("get", "k1")
+	ts.ProcessCommand("lrange", "k2", "0", "-1")
+
+	// write commands that don't modify the keys don't invalidate them
+	output = ts2.ProcessCommand("set", "k1", "v2", "nx")
+	if !output.isNull() {
+		t.Fatal("tracking set nx fail")
+	}
+
+	output = ts2.ProcessCommand("lrem", "k2", "0", "b")
+	if !output.isInt(0) {
+		t.Fatal("tracking lrem fail")
+	}
+
+	if len(ts.PendingPushes()) != 0 {
+		t.Fatal("tracking unchanged invalidation fail")
+	}
+
+	output = ts2.ProcessCommand("lrem", "k2", "0", "a")
+	if !output.isInt(1) {
+		t.Fatal("tracking lrem change fail")
+	}
+
+	if !isInvalidations(ts.PendingPushes(), "k2") {
+		t.Fatal("tracking changed invalidation fail")
+	}
+}
+
+func TestClientTrackingNoLoop(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	output := ts.ProcessCommand("client", "tracking", "on", "noloop")
+	if !output.isString("OK") {
+		t.Fatal("noloop tracking on fail")
+	}
+
+	output = ts.ProcessCommand("hget", "h", "f")
+	if !output.isNull() {
+		t.Fatal("noloop hget fail")
+	}
+
+	output = ts.ProcessCommand("hset", "h", "f", "v")
+	if !output.isInt(1) {
+		t.Fatal("noloop own hset fail")
+	}
+
+	if len(ts.PendingPushes()) != 0 {
+		t.Fatal("noloop own invalidation fail")
+	}
+
+	// the own change still ends tracking of the key
+	output = ts2.ProcessCommand("hset", "h", "f", "v2")
+	if !output.isInt(0) {
+		t.Fatal("noloop untracked hset fail")
+	}
+
+	if len(ts.PendingPushes()) != 0 {
+		t.Fatal("noloop untracked invalidation fail")
+	}
+
+	output = ts.ProcessCommand("hget", "h", "f")
+	if !output.isString("v2") {
+		t.Fatal("noloop hget again fail")
+	}
+
+	output = ts2.ProcessCommand("hset", "h", "f", "v3")
+	if !output.isInt(0) {
+		t.Fatal("noloop other hset fail")
+	}
+
+	if !isInvalidations(ts.PendingPushes(), "h") {
+		t.Fatal("noloop other invalidation fail")
+	}
+}
+
+func TestClientTrackingOptInOptOut(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	output := ts.ProcessCommand("client", "caching", "yes")
+	if !output.isErrorType() {
+		t.Fatal("caching without tracking fail")
+	}
+
+	output = ts.ProcessCommand("client", "tracking", "on", "optin")
+	if !output.isString("OK") {
+		t.Fatal("optin tracking on fail")
+	}
+
+	output = ts.ProcessCommand("client", "caching", "no")
+	if !output.isErrorType() {
+		t.Fatal("optin caching no fail")
+	}
+
+	output = ts.ProcessCommand("get", "a")
+	if !output.isNull() {
+		t.Fatal("optin get a fail")
+	}
+
+	output = ts.ProcessCommand("client", "caching", "yes")
+	if !output.isString("OK") {
+		t.Fatal("optin caching yes fail")
+	}
+
+	output = ts.ProcessCommand("get", "b")
+	if !output.isNull() {
+		t.Fatal("optin get b fail")
+	}
+
+	// caching applies to one command only
+	output = ts.ProcessCommand("get", "c")
+	if !output.isNull() {
+		t.Fatal("optin get c fail")
+	}
+
+	output = ts2.ProcessCommand("mset", "a", "1", "b", "2", "c", "3")
+	if !output.isString("OK") {
+		t.Fatal("optin mset fail")
+	}
+
+	if !isInvalidations(ts.PendingPushes(), "b") {
+		t.Fatal("optin invalidation fail")
+	}
+
+	// switching modes requires turning tracking off first
+	output = ts.ProcessCommand("client", "tracking", "on", "optout")
+	if !output.isErrorType() {
+		t.Fatal("optin to optout switch fail")
+	}
+
+	output = ts.ProcessCommand("client", "tracking", "off")
+	if !output.isString("OK") {
+		t.Fatal("optin tracking off fail")
+	}
+
+	output = ts.ProcessCommand("client", "tracking", "on", "optout")
+	if !output.isString("OK") {
+		t.Fatal("optout tracking on fail")
+	}
+
+	output = ts.ProcessCommand("client", "caching", "no")
+	if !output.isString("OK") {
+		t.Fatal("optout caching no fail")
+	}
+
+	output = ts.ProcessCommand("get", "a")
+	if !output.isString("1") {
+		t.Fatal("optout get a fail")
+	}
+
+	output = ts.ProcessCommand("get", "b")
+	if !output.isString("2") {
+		t.Fatal("optout get b fail")
+	}
+
+	output = ts2.ProcessCommand("mset", "a", "4", "b", "5")
+	if !output.isString("OK") {
+		t.Fatal("optout mset fail")
+	}
+
+	if !isInvalidations(ts.PendingPushes(), "b") {
+		t.Fatal("optout invalidation fail")
+	}
+}
+
+func TestClientTrackingBcast(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	output := ts.ProcessCommand("client", "tracking", "on", "prefix", "user:")
+	if !output.isErrorType() {
+		t.Fatal("prefix without bcast fail")
+	}
+
+	output = ts.ProcessCommand("client", "tracking", "on", "bcast", "optin")
+	if !output.isErrorType() {
+		t.Fatal("bcast optin fail")
+	}
+
+	output = ts.ProcessCommand("client", "tracking", "on", "bcast", "prefix", "user:", "prefix", "user:1")
+	if !output.isErrorType() {
+		t.Fatal("bcast overlapping prefix fail")
+	}
+
+	output = ts.ProcessCommand("client", "tracking", "on", "bcast", "prefix", "user:", "prefix", "item:")
+	if !output.isString("OK") {
+		t.Fatal("bcast tracking on fail")
+	}
+
+	// keys don't have to be read
+	output = ts2.ProcessCommand("set", "user:100", "v")
+	if !output.isString("OK") {
+		t.Fatal("bcast set user fail")
+	}
+
+	output = ts2.ProcessCommand("set", "other", "v")
+	if !output.isString("OK") {
+		t.Fatal("bcast set other fail")
+	}
+
+	output = ts2.ProcessCommand("rpush", "item:7", "a")
+	if !output.isInt(1) {
+		t.Fatal("bcast rpush item fail")
+	}
+
+	if !isInvalidations(ts.PendingPushes(), "user:100", "item:7") {
+		t.Fatal("bcast invalidation fail")
+	}
+
+	// reads aren't tracked
+	output = ts.ProcessCommand("get", "other")
+	if !output.isString("v") {
+		t.Fatal("bcast get other fail")
+	}
+
+	output = ts2.ProcessCommand("set", "other", "v2")
+	if !output.isString("OK") {
+		t.Fatal("bcast set other again fail")
+	}
+
+	if len(ts.PendingPushes()) != 0 {
+		t.Fatal("bcast read tracking fail")
+	}
+
+	output = ts.ProcessCommand("client", "tracking", "on")
+	if !output.isErrorType() {
+		t.Fatal("bcast switch fail")
+	}
+
+	output = ts.ProcessCommand("client", "trackinginfo")
+	m, valid := output.toMap()
+	if !valid {
+		t.Fatal("bcast trackinginfo fail")
+	}
+
+	flags := m[respValue{data: respBulkString("flags")}]
+	if !flags.isArray("on", "bcast") {
+		t.Fatal("bcast trackinginfo flags fail")
+	}
+
+	redirect := m[respValue{data: respBulkString("redirect")}]
+	if !redirect.isInt(0) {
+		t.Fatal("bcast trackinginfo redirect fail")
+	}
+
+	prefixes := m[respValue{data: respBulkString("prefixes")}]
+	if !prefixes.isArraySet("user:", "item:") {
+		t.Fatal("bcast trackinginfo prefixes fail")
+	}
+}
+
+func TestClientTrackingRedirect(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+	ts3 := ts.AdditionalClient()
+
+	output := ts.ProcessCommand("client", "getredir")
+	if !output.isInt(-1) {
+		t.Fatal("getredir off fail")
+	}
+
+	output = ts.ProcessCommand("client", "tracking", "on", "redirect", "999999")
+	if !output.isErrorType() {
+		t.Fatal("redirect to missing client fail")
+	}
+
+	// redirect target needs resp3 to receive push messages
+	output = ts3.ProcessCommand("hello", "3")
+	if output.isErrorType() {
+		t.Fatal("redirect target hello fail")
+	}
+
+	output = ts.ProcessCommand("client", "tracking", "on", "redirect", ts3.ClientID())
+	if !output.isString("OK") {
+		t.Fatal("redirect tracking on fail")
+	}
+
+	output = ts.ProcessCommand("client", "getredir")
+	if !output.isInt64(ts3.ClientID()) {
+		t.Fatal("getredir fail")
+	}
+
+	output = ts.ProcessCommand("get", "k")
+	if !output.isNull() {
+		t.Fatal("redirect get fail")
+	}
+
+	output = ts2.ProcessCommand("set", "k", "v")
+	if !output.isString("OK") {
+		t.Fatal("redirect set fail")
+	}
+
+	if len(ts.PendingPushes()) != 0 {
+		t.Fatal("redirect source invalidation fail")
+	}
+
+	if !isInvalidations(ts3.PendingPushes(), "k") {
+		t.Fatal("redirect target invalidation fail")
+	}
+
+	// the tracking client is told when the target goes away
+	ts3.Close()
+
+	output = ts.ProcessCommand("get", "k")
+	if !output.isString("v") {
+		t.Fatal("redirect get again fail")
+	}
+
+	output = ts2.ProcessCommand("set", "k", "v2")
+	if !output.isString("OK") {
+		t.Fatal("redirect set again fail")
+	}
+
+	pushes := ts.PendingPushes()
+	if len(pushes) != 1 {
+		t.Fatal("redirect broken push fail")
+	}
+	p, valid := pushes[0].data.(respPush)
+	if !valid || p.kind != "tracking-redir-broken" || len(p.data) != 1 || !p.data[0].isInt64(ts3.ClientID()) {
+		t.Fatal("redirect broken push content fail")
+	}
+
+	output = ts.ProcessCommand("client", "trackinginfo")
+	m, _ := output.toMap()
+	flags := m[respValue{data: respBulkString("flags")}]
+	if !flags.isArray("on", "broken_redirect") {
+		t.Fatal("redirect trackinginfo flags fail")
+	}
+}
+
+func TestClientTrackingFlushAndExpire(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	output := ts.ProcessCommand("client", "tracking", "on")
+	if !output.isString("OK") {
+		t.Fatal("flush tracking on fail")
+	}
+
+	output = ts.ProcessCommand("set", "k", "v", "px", "20")
+	if !output.isString("OK") {
+		t.Fatal("expire set fail")
+	}
+
+	output = ts.ProcessCommand("get", "k")
+	if !output.isString("v") {
+		t.Fatal("expire get fail")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	output = ts2.ProcessCommand("exists", "k")
+	if !output.isInt(0) {
+		t.Fatal("expire exists fail")
+	}
+
+	if !isInvalidations(ts.PendingPushes(), "k") {
+		t.Fatal("expire invalidation fail")
+	}
+
+	output = ts2.ProcessCommand("flushall")
+	if !output.isString("OK") {
+		t.Fatal("flushall fail")
+	}
+
+	if !isInvalidations(ts.PendingPushes(), nil) {
+		t.Fatal("flushall invalidation fail")
+	}
+
+	output = ts2.ProcessCommand("flushdb")
+	if !output.isString("OK") {
+		t.Fatal("flushdb fail")
+	}
+
+	if !isInvalidations(ts.PendingPushes(), nil) {
+		t.Fatal("flushdb invalidation fail")
+	}
+}
+
+func TestClientTrackingMulti(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	output := ts.ProcessCommand("client", "tracking", "on", "optin")
+	if !output.isString("OK") {
+		t.Fatal("multi tracking on fail")
+	}
+
+	// caching yes carries through the transaction
+	output = ts.ProcessCommand("client", "caching", "yes")
+	if !output.isString("OK") {
+		t.Fatal("multi caching yes fail")
+	}
+
+	output = ts.ProcessCommand("multi")
+	if !output.isString("OK") {
+		t.Fatal("multi fail")
+	}
+
+	output = ts.ProcessCommand("get", "a")
+	if !output.isString("QUEUED") {
+		t.Fatal("multi queue get a fail")
+	}
+
+	output = ts.ProcessCommand("strlen", "b")
+	if !output.isString("QUEUED") {
+		t.Fatal("multi queue strlen b fail")
+	}
+
+	output = ts.ProcessCommand("exec")
+	if !output.isArray(nil, 0) {
+		t.Fatal("multi exec fail")
+	}
+
+	output = ts2.ProcessCommand("mset", "a", "1", "b", "2")
+	if !output.isString("OK") {
+		t.Fatal("multi mset fail")
+	}
+
+	if !isInvalidations(ts.PendingPushes(), "a", "b") {
+		t.Fatal("multi invalidation fail")
+	}
+}
+
+func TestClientTrackingInfo(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("client", "trackinginfo")
+	if !output.isMap(map[any]any{"flags": []any{"off"}, "redirect": -1, "prefixes": []any{}}) {
+		t.Fatal("trackinginfo off fail")
+	}
+
+	output = ts.ProcessCommand("client", "tracking", "on", "optin", "optout")
+	if !output.isErrorType() {
+		t.Fatal("tracking optin optout fail")
+	}
+
+	output = ts.ProcessCommand("client", "tracking", "on", "optout", "noloop")
+	if !output.isString("OK") {
+		t.Fatal("tracking optout noloop fail")
+	}
+
+	output = ts.ProcessCommand("client", "caching", "no")
+	if !output.isString("OK") {
+		t.Fatal("trackinginfo caching no fail")
+	}
+
+	output = ts.ProcessCommand("client", "trackinginfo")
+	if !output.isMap(map[any]any{"flags": []any{"on", "optout", "caching-no", "noloop"}, "redirect": 0, "prefixes": []any{}}) {
+		t.Fatal("trackinginfo on fail")
+	}
+
+	output = ts.ProcessCommand("client", "getredir")
+	if !output.isInt(0) {
+		t.Fatal("getredir no redirect fail")
+	}
+}
+
+func TestClientTrackingResp2(t *testing.T) {
+	ts := NewRedisTestClientResp2(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	// resp2 clients can turn on tracking, but have no way to receive pushes
+	output := ts.ProcessCommand("client", "tracking", "on")
+	if !output.isString("OK") {
+		t.Fatal("resp2 tracking on fail")
+	}
+
+	output = ts.ProcessCommand("get", "k")
+	if !output.isNull() {
+		t.Fatal("resp2 get fail")
+	}
+
+	output = ts2.ProcessCommand("set", "k", "v")
+	if !output.isString("OK") {
+		t.Fatal("resp2 set fail")
+	}
+
+	if len(ts.PendingPushes()) != 0 {
+		t.Fatal("resp2 push fail")
+	}
+
+	// without REDIRECT, the invalidation channel isn't used either, even
+	// when the client subscribes to it
+	output = ts.ProcessCommand("get", "k")
+	if !output.isString("v") {
+		t.Fatal("resp2 get again fail")
+	}
+
+	output = ts.ProcessCommand("subscribe", "__redis__:invalidate")
+	if !output.isArray("subscribe", "__redis__:invalidate", 1) {
+		t.Fatal("resp2 subscribe fail")
+	}
+
+	output = ts2.ProcessCommand("set", "k", "v2")
+	if !output.isString("OK") {
+		t.Fatal("resp2 set again fail")
+	}
+
+	if len(ts.PendingPushes()) != 0 {
+		t.Fatal("resp2 channel message fail")
+	}
+}
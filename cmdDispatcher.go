@@ -3,8 +3,10 @@ package redisemu
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/jimsnab/go-lane"
 )
@@ -21,6 +23,8 @@ type (
 		args     *orderedMap
 		rawArgs  respArray
 		multi    bool
+		script   bool          // the command is called by a script or function
+		blocked  time.Duration // the time that a blocking command waited
 	}
 	cmdHandler func(ctx *cmdContext, args map[string]any) (respValue, error)
 
@@ -41,12 +45,15 @@ const rstrQueued = respSimpleString(strQueued)
 const rstrOK = respSimpleString(strOK)
 const rstrSyntaxError = respErrorString("ERR Syntax error")
 const rstrNumKeysGreater = respErrorString("ERR Number of keys can't be greater than number of args")
+const rstrNumFieldsZero = respErrorString("ERR Parameter `numFields` should be greater than 0")
+const rstrNumFieldsMismatch = respErrorString("ERR The `numfields` parameter must match the number of arguments")
 
 var errInvalidCmdInput = respErrorString("ERR Invalid command input")
 var errMissingCmdName = respErrorString("ERR Missing command name")
 
 var handlerTable = map[string]cmdHandler{
 	"append":                  fnAppend,
+	"bgsave":                  fnBgSave,
 	"bitcount":                fnBitCount,
 	"bitfield":                fnBitfield,
 	"bitfield_ro":             fnBitfield,
@@ -57,7 +64,12 @@ var handlerTable = map[string]cmdHandler{
 	"blpop":                   fnBLPop,
 	"brpop":                   fnBRPop,
 	"brpoplpush":              fnBRPopLPush,
+	"bzmpop":                  fnBZMPop,
+	"bzpopmax":                fnBZPopMax,
+	"bzpopmin":                fnBZPopMin,
+	"client|caching":          fnClientCaching,
 	"client|getname":          fnClientGetName,
+	"client|getredir":         fnClientGetRedir,
 	"client|id":               fnClientGetId,
 	"client|info":             fnClientInfo,
 	"client|list":             fnClientList,
@@ -65,6 +77,8 @@ var handlerTable = map[string]cmdHandler{
 	"client|no-evict":         fnClientNoEvict,
 	"client|setinfo":          fnClientSetInfo,
 	"client|setname":          fnClientSetName,
+	"client|tracking":         fnClientTracking,
+	"client|trackinginfo":     fnClientTrackingInfo,
 	"client|unblock":          fnClientUnblock,
 	"command|count":           fnCommandCount,
 	"command|docs":            fnCommandDocs,
@@ -73,21 +87,50 @@ var handlerTable = map[string]cmdHandler{
 	"command|help":            fnCommandHelp,
 	"command|info":            fnCommandInfo,
 	"command|list":            fnCommandList,
+	"config|get":              fnConfigGet,
+	"config|resetstat":        fnConfigResetStat,
+	"config|rewrite":          fnConfigRewrite,
+	"config|set":              fnConfigSet,
 	"copy":                    fnCopy,
 	"dbsize":                  fnDbSize,
+	"debug":                   fnDebug,
 	"decr":                    fnDecr,
 	"decrby":                  fnDecrBy,
 	"del":                     fnDel,
 	"discard":                 fnDiscard,
 	"dump":                    fnDump,
 	"echo":                    fnEcho,
+	"eval":                    fnEval,
+	"eval_ro":                 fnEval,
+	"evalsha":                 fnEvalSha,
+	"evalsha_ro":              fnEvalSha,
 	"exec":                    fnExec,
 	"exists":                  fnExists,
 	"expire":                  fnExpire,
 	"expireat":                fnExpireAt,
 	"expiretime":              fnExpireTime,
+	"fcall":                   fnFCall,
+	"fcall_ro":                fnFCall,
 	"flushall":                fnFlushAll,
 	"flushdb":                 fnFlushDb,
+	"function|delete":         fnFunctionDelete,
+	"function|dump":           fnFunctionDump,
+	"function|flush":          fnFunctionFlush,
+	"function|kill":           fnFunctionKill,
+	"function|list":           fnFunctionList,
+	"function|load":           fnFunctionLoad,
+	"function|restore":        fnFunctionRestore,
+	"function|stats":          fnFunctionStats,
+	"geoadd":                  fnGeoAdd,
+	"geodist":                 fnGeoDist,
+	"geohash":                 fnGeoHash,
+	"geopos":                  fnGeoPos,
+	"georadius":               fnGeoRadius,
+	"georadius_ro":            fnGeoRadius,
+	"georadiusbymember":       fnGeoRadiusByMember,
+	"georadiusbymember_ro":    fnGeoRadiusByMember,
+	"geosearch":               fnGeoSearch,
+	"geosearchstore":          fnGeoSearchStore,
 	"get":                     fnGet,
 	"getbit":                  fnGetBit,
 	"getdel":                  fnGetDel,
@@ -100,21 +143,41 @@ var handlerTable = map[string]cmdHandler{
 	"info":                    fnInfo,
 	"hdel":                    fnHDel,
 	"hexists":                 fnHExists,
+	"hexpire":                 fnHExpire,
+	"hexpireat":               fnHExpireAt,
+	"hexpiretime":             fnHExpireTime,
 	"hello":                   fnHello,
 	"hget":                    fnHGet,
 	"hgetall":                 fnHGetAll,
+	"hgetdel":                 fnHGetDel,
+	"hgetex":                  fnHGetEx,
 	"hincrby":                 fnHIncrBy,
 	"hincrbyfloat":            fnHIncrByFloat,
 	"hkeys":                   fnHKeys,
 	"hlen":                    fnHLen,
 	"hmget":                   fnHMGet,
 	"hmset":                   fnHMSet,
+	"hpersist":                fnHPersist,
+	"hpexpire":                fnHPExpire,
+	"hpexpireat":              fnHPExpireAt,
+	"hpexpiretime":            fnHPExpireTime,
+	"hpttl":                   fnHPTtl,
 	"hrandfield":              fnHRandField,
 	"hscan":                   fnHScan,
 	"hset":                    fnHSet,
+	"hsetex":                  fnHSetEx,
 	"hsetnx":                  fnHSetNx,
 	"hstrlen":                 fnHStrLen,
+	"httl":                    fnHTtl,
 	"hvals":                   fnHVals,
+	"lastsave":                fnLastSave,
+	"latency|doctor":          fnLatencyDoctor,
+	"latency|graph":           fnLatencyGraph,
+	"latency|help":            fnLatencyHelp,
+	"latency|histogram":       fnLatencyHistogram,
+	"latency|history":         fnLatencyHistory,
+	"latency|latest":          fnLatencyLatest,
+	"latency|reset":           fnLatencyReset,
 	"lcs":                     fnLcs,
 	"lindex":                  fnLIndex,
 	"linsert":                 fnLInsert,
@@ -129,22 +192,47 @@ var handlerTable = map[string]cmdHandler{
 	"lrem":                    fnLRem,
 	"lset":                    fnLSet,
 	"ltrim":                   fnLTrim,
+	"memory|doctor":           fnMemoryDoctor,
+	"memory|help":             fnMemoryHelp,
+	"memory|malloc-stats":     fnMemoryMallocStats,
+	"memory|purge":            fnMemoryPurge,
+	"memory|stats":            fnMemoryStats,
+	"memory|usage":            fnMemoryUsage,
 	"mget":                    fnMget,
+	"monitor":                 fnMonitor,
+	"move":                    fnMove,
 	"mset":                    fnMset,
 	"msetnx":                  fnMset,
 	"multi":                   fnMulti,
+	"object|encoding":         fnObjectEncoding,
+	"object|freq":             fnObjectFreq,
+	"object|help":             fnObjectHelp,
+	"object|idletime":         fnObjectIdleTime,
+	"object|refcount":         fnObjectRefCount,
 	"keys":                    fnKeys,
 	"pexpire":                 fnPExpire,
 	"pexpireat":               fnPExpireAt,
 	"pexpiretime":             fnPExpireTime,
 	"persist":                 fnPersist,
+	"pfadd":                   fnPfAdd,
+	"pfcount":                 fnPfCount,
+	"pfmerge":                 fnPfMerge,
 	"psetex":                  fnSet,
+	"psubscribe":              fnPSubscribe,
 	"ping":                    fnPing,
 	"pttl":                    fnPTtl,
+	"publish":                 fnPublish,
+	"pubsub|channels":         fnPubSubChannels,
+	"pubsub|numpat":           fnPubSubNumPat,
+	"pubsub|numsub":           fnPubSubNumSub,
+	"pubsub|shardchannels":    fnPubSubShardChannels,
+	"pubsub|shardnumsub":      fnPubSubShardNumSub,
+	"punsubscribe":            fnPUnsubscribe,
 	"quit":                    fnQuit,
 	"randomkey":               fnRandomKey,
 	"rename":                  fnRename,
 	"renamenx":                fnRenameNx,
+	"reset":                   fnReset,
 	"restore":                 fnRestore,
 	"rpush":                   fnRPush,
 	"rpushx":                  fnRPushX,
@@ -153,41 +241,111 @@ var handlerTable = map[string]cmdHandler{
 	"sadd":                    fnSAdd,
 	"scard":                   fnSCard,
 	"scan":                    fnScan,
+	"script|exists":           fnScriptExists,
+	"script|flush":            fnScriptFlush,
+	"script|kill":             fnScriptKill,
+	"script|load":             fnScriptLoad,
 	"sdiff":                   fnSDiff,
 	"sdiffstore":              fnSDiffStore,
+	"save":                    fnSave,
 	"select":                  fnSelect,
 	"set":                     fnSet,
 	"setbit":                  fnSetBit,
 	"setex":                   fnSet,
 	"setnx":                   fnSet,
 	"setrange":                fnSetRange,
+	"shutdown":                fnShutdown,
 	"sinter":                  fnSInter,
 	"sintercard":              fnSInterCard,
 	"sinterstore":             fnSInterStore,
 	"sismember":               fnSIsMember,
+	"slowlog|get":             fnSlowLogGet,
+	"slowlog|help":            fnSlowLogHelp,
+	"slowlog|len":             fnSlowLogLen,
+	"slowlog|reset":           fnSlowLogReset,
 	"smembers":                fnSMembers,
 	"smismember":              fnSMIsMember,
 	"smove":                   fnSMove,
 	"sort":                    fnSort,
+	"spublish":                fnSPublish,
 	"srandmember":             fnSRandMember,
 	"srem":                    fnSRem,
 	"strlen":                  fnStrLen,
+	"subscribe":               fnSubscribe,
 	"substr":                  fnGetRange,
 	"sscan":                   fnSScan,
+	"ssubscribe":              fnSSubscribe,
 	"sunion":                  fnSUnion,
+	"sunsubscribe":            fnSUnsubscribe,
 	"sunionstore":             fnSUnionStore,
+	"swapdb":                  fnSwapDb,
 	"touch":                   fnTouch,
 	"ttl":                     fnTtl,
 	"type":                    fnType,
 	"unlink":                  fnUnlink,
+	"unsubscribe":             fnUnsubscribe,
 	"unwatch":                 fnUnwatch,
 	"watch":                   fnWatch,
+	"xack":                    fnXAck,
+	"xadd":                    fnXAdd,
+	"xautoclaim":              fnXAutoClaim,
+	"xclaim":                  fnXClaim,
+	"xdel":                    fnXDel,
+	"xgroup|create":           fnXGroupCreate,
+	"xgroup|createconsumer":   fnXGroupCreateConsumer,
+	"xgroup|delconsumer":      fnXGroupDelConsumer,
+	"xgroup|destroy":          fnXGroupDestroy,
+	"xgroup|setid":            fnXGroupSetId,
+	"xinfo|consumers":         fnXInfoConsumers,
+	"xinfo|groups":            fnXInfoGroups,
+	"xinfo|stream":            fnXInfoStream,
+	"xlen":                    fnXLen,
+	"xpending":                fnXPending,
+	"xrange":                  fnXRange,
+	"xread":                   fnXRead,
+	"xreadgroup":              fnXReadGroup,
+	"xrevrange":               fnXRevRange,
+	"xsetid":                  fnXSetId,
+	"xtrim":                   fnXTrim,
+	"zadd":                    fnZAdd,
+	"zcard":                   fnZCard,
+	"zcount":                  fnZCount,
+	"zdiff":                   fnZDiff,
+	"zdiffstore":              fnZDiffStore,
+	"zincrby":                 fnZIncrBy,
+	"zinter":                  fnZInter,
+	"zintercard":              fnZInterCard,
+	"zinterstore":             fnZInterStore,
+	"zlexcount":               fnZLexCount,
+	"zmpop":                   fnZMPop,
+	"zmscore":                 fnZMScore,
+	"zpopmax":                 fnZPopMax,
+	"zpopmin":                 fnZPopMin,
+	"zrandmember":             fnZRandMember,
+	"zrange":                  fnZRange,
+	"zrangebylex":             fnZRangeByLex,
+	"zrangebyscore":           fnZRangeByScore,
+	"zrangestore":             fnZRangeStore,
+	"zrank":                   fnZRank,
+	"zrem":                    fnZRem,
+	"zremrangebylex":          fnZRemRangeByLex,
+	"zremrangebyrank":         fnZRemRangeByRank,
+	"zremrangebyscore":        fnZRemRangeByScore,
+	"zrevrange":               fnZRevRange,
+	"zrevrangebylex":          fnZRevRangeByLex,
+	"zrevrangebyscore":        fnZRevRangeByScore,
+	"zrevrank":                fnZRevRank,
+	"zscan":                   fnZScan,
+	"zscore":                  fnZScore,
+	"zunion":                  fnZUnion,
+	"zunionstore":             fnZUnionStore,
 }
 
 var unqueuedCmdTable = map[string]bool{
 	"multi":   true,
 	"discard": true,
 	"exec":    true,
+	"reset":   true,
 	"watch":   true,
 }
 
@@ -202,6 +360,8 @@ func (ctx *cmdContext) info(cs *clientState) string {
 func (ctx *cmdContext) infoUnlocked(cs *clientState) string {
 	info := cs.client.ClientInfo()
 
+	channels, patterns, shardChannels := cs.subscriptionCounts()
+
 	multi := -1
 	if ctx.multi {
 		multi = 1
@@ -215,9 +375,15 @@ func (ctx *cmdContext) infoUnlocked(cs *clientState) string {
 	if cs.client.IsCloseRequested() {
 		flags.WriteRune('c')
 	}
-	if isAbortedExecUnlocked(cs) {
+	if isAbortedExecUnlocked(cs, ctx.dsc) {
 		flags.WriteRune('d')
 	}
+	if cs.isMonitoring() {
+		flags.WriteRune('O')
+	}
+	if cs.isSubscribed() {
+		flags.WriteRune('P')
+	}
 	if cs.isMultiInProgress() {
 		flags.WriteRune('x')
 	}
@@ -230,6 +396,9 @@ func (ctx *cmdContext) infoUnlocked(cs *clientState) string {
 		fmt.Sprintf("id=%d", cs.id),
 		"name="+cs.name,
 		fmt.Sprintf("db=%d", cs.selectedDb),
+		fmt.Sprintf("sub=%d", channels),
+		fmt.Sprintf("psub=%d", patterns),
+		fmt.Sprintf("ssub=%d", shardChannels),
 		fmt.Sprintf("multi=%d", multi),
 		fmt.Sprintf("flags=%s", flags.String()),
 		"cmd="+ctx.cmdToken,
@@ -303,6 +472,42 @@ func (cd *cmdDispatcher) addHandler(cmdToken string, handler cmdHandler) {
 }
 
 func (cd *cmdDispatcher) prepare(cs *clientState, input respValue) (ctx *cmdContext, response any) {
+	if ctx, response = cd.newCmdContext(cs, input); response != nil {
+		if errText, isError := response.(respErrorString); isError {
+			cd.dss.recordRejectedCall(cd.rejectedCmdToken(input), errText, false)
+		}
+
+		// a command that can't be queued causes EXEC to discard the transaction
+		if cs.cmdQueue != nil {
+			cs.cmdQueueErr = true
+		}
+		return
+	}
+
+	// if multi was specified, queue the command (unless it is a transaction command)
+	if cs.cmdQueue != nil {
+		if cd.hasFlag(ctx.cmdToken, "no_multi") {
+			errText := respErrorString("ERR Command not allowed inside a transaction")
+			cd.dss.recordRejectedCall(ctx.cmdToken, errText, false)
+			cs.cmdQueueErr = true
+			response = errText
+			return
+		}
+
+		ctx.multi = true
+		_, multiControl := unqueuedCmdTable[ctx.cmdToken]
+		if !multiControl {
+			*cs.cmdQueue = append(*cs.cmdQueue, ctx)
+			response = rstrQueued
+			return
+		}
+	}
+
+	return
+}
+
+// parses the command input and makes the context for its handler
+func (cd *cmdDispatcher) newCmdContext(cs *clientState, input respValue) (ctx *cmdContext, response any) {
 	l := cs.l
 
 	traceJson, _ := json.Marshal(input.toNative())
@@ -341,6 +546,13 @@ func (cd *cmdDispatcher) prepare(cs *clientState, input respValue) (ctx *cmdCont
 		return
 	}
 
+	// a RESP2 connection with subscriptions can only manage its subscriptions
+	if cs.respVersion == 2 && !subscribedCmdTable[cmdNameLower] && cs.isSubscribed() {
+		l.Infof("command '%s' rejected in subscribed mode", cmdNameLower)
+		response = subscribedCmdError(cmdNameArg)
+		return
+	}
+
 	// parse the input using the command definition
 	cmdArgs := args[1:]
 
@@ -348,15 +560,23 @@ func (cd *cmdDispatcher) prepare(cs *clientState, input respValue) (ctx *cmdCont
 	if cmdNameLower == "bitfield" || cmdNameLower == "bitfield_ro" {
 		// this command breaks integer parsing rules, and loses command order
 		opts |= PARSE_SAVE_INTEGERS_AS_STRINGS | PARSE_ADD_ARG_INDEX_TO_BLOCK
+	} else if cmdNameLower == "debug" {
+		// the command spec doesn't describe the subcommands; the handler
+		// parses the raw arguments
+		cmdArgs = nil
+	} else if cmdNameLower == "zadd" {
+		// the command spec orders the options, but redis doesn't
+		cmdArgs = zaddSortOptions(cmdArgs)
 	} else if cmdNameLower == "command" {
 		// special case for command getkeys and command getkeysandflags:
-		// the command has to be parsed without its arguments, because
-		// the redis command spec doesn't have a concept of "any"
+		// the command has to be parsed with only the name of the command
+		// to examine, because the redis command spec doesn't have a
+		// concept of "any"
 		if len(cmdArgs) > 0 {
 			str, _ := cmdArgs[0].toString()
 			subcmd := strings.ToLower(str)
 			if subcmd == "getkeys" || subcmd == "getkeysandflags" {
-				cmdArgs = args[1:2]
+				cmdArgs = args[1:min(len(args), 3)]
 			}
 		}
 	}
@@ -369,7 +589,19 @@ func (cd *cmdDispatcher) prepare(cs *clientState, input respValue) (ctx *cmdCont
 		l.Infof("can't parse arguments for command '%s'", cmdNameLower)
 		var text respErrorString
 		if keywords == 0 {
-			text = respErrorString(fmt.Sprintf("ERR Incorrect or wrong number of arguments for '%s'. Try COMMAND HELP.", cmdNameArg))
+			strs := make([]string, 0, len(cmdArgs))
+			for _, arg := range cmdArgs {
+				str, _ := arg.toString()
+				strs = append(strs, str)
+			}
+			if cmdNameLower == "zadd" {
+				text = zaddOptionsError(strs)
+			} else if argsPerField, exists := hashFieldsCmdTable[cmdNameLower]; exists {
+				text = hashFieldsError(strs, argsPerField)
+			}
+			if text == "" {
+				text = respErrorString(fmt.Sprintf("ERR Incorrect or wrong number of arguments for '%s'. Try COMMAND HELP.", cmdNameArg))
+			}
 		} else {
 			text = respErrorString(fmt.Sprintf("ERR unknown subcommand '%s'. Try CLIENT HELP.", cmdToken))
 		}
@@ -390,10 +622,14 @@ func (cd *cmdDispatcher) prepare(cs *clientState, input respValue) (ctx *cmdCont
 	}
 
 	// prepare a context structure for the handler
+	dsc := cs.ds.newDataStoreCommand()
+	dsc.readOnly = cd.isReadOnly(cmdToken)
+	dsc.origin = cs
+
 	ctx = &cmdContext{
 		l:        l,
 		cs:       cs,
-		dsc:      cs.ds.newDataStoreCommand(),
+		dsc:      dsc,
 		cmdName:  cmdNameArg,
 		cmdToken: cmdToken,
 		cmd:      cmd,
@@ -401,19 +637,34 @@ func (cd *cmdDispatcher) prepare(cs *clientState, input respValue) (ctx *cmdCont
 		args:     argTable,
 		rawArgs:  args,
 	}
+	return
+}
 
-	// if multi was specified, queue the command (unless it is a transaction command)
-	if cs.cmdQueue != nil {
-		ctx.multi = true
-		_, multiControl := unqueuedCmdTable[cmdNameLower]
-		if !multiControl {
-			*cs.cmdQueue = append(*cs.cmdQueue, ctx)
-			response = rstrQueued
-			return
-		}
+// provides the command token that a rejected command is counted under in INFO
+// commandstats, or an empty string if the command isn't known
+func (cd *cmdDispatcher) rejectedCmdToken(input respValue) string {
+	args, _ := input.data.(respArray)
+	if len(args) == 0 {
+		return ""
+	}
+	name, _ := args[0].toString()
+	name = strings.ToLower(name)
+	cmd, exists := cd.active[name]
+	if !exists {
+		return ""
+	}
+	if len(cmd.Subcommands) == 0 {
+		return name
 	}
 
-	return
+	if len(args) > 1 {
+		subName, _ := args[1].toString()
+		subCmdToken := name + "|" + strings.ToLower(subName)
+		if _, exists := cd.handlers[subCmdToken]; exists {
+			return subCmdToken
+		}
+	}
+	return ""
 }
 
 func (cd *cmdDispatcher) dispatch(cs *clientState, input respValue) (output respValue) {
@@ -422,7 +673,13 @@ func (cd *cmdDispatcher) dispatch(cs *clientState, input respValue) (output resp
 		output.data = response
 		return
 	}
-	return cd.dispatchHandler(ctx)
+	output = cd.dispatchHandler(ctx)
+
+	// CLIENT CACHING applies to the next command only, or to the whole transaction
+	if cs.cmdQueue == nil && !strings.HasPrefix(ctx.cmdToken, "client|") {
+		cs.resetTrackingCaching()
+	}
+	return
 }
 
 func (cd *cmdDispatcher) dispatchHandler(ctx *cmdContext) (output respValue) {
@@ -433,7 +690,9 @@ func (cd *cmdDispatcher) dispatchHandler(ctx *cmdContext) (output respValue) {
 	handler := cd.handlers[cmdToken]
 	if handler == nil {
 		l.Tracef("unsupported command '%s' rejected", cmdToken)
-		output.data = respErrorString(fmt.Sprintf("ERR Unsupported command '%s'", cmdToken))
+		errText := respErrorString(fmt.Sprintf("ERR Unsupported command '%s'", cmdToken))
+		cd.dss.recordRejectedCall(cmdToken, errText, ctx.script)
+		output.data = errText
 		return
 	}
 
@@ -450,25 +709,47 @@ func (cd *cmdDispatcher) dispatchHandler(ctx *cmdContext) (output respValue) {
 			if err != nil {
 				l.Warnf("hook error processing command '%s': %s", cmdToken, err)
 				output.data = respErrorString(fmt.Sprintf("ERR %s", err.Error()))
+				cd.dss.recordCommandCall(cmdToken, 0, output, ctx.script)
 				return
 			}
 
 			if hooked {
 				result = nativeValueToResp(r)
 				handler = nil
+				cd.dss.recordCommandCall(cmdToken, 0, result, ctx.script)
 			}
 		}
 	}
 
 	if handler != nil {
 		l.Tracef("calling handler for command '%s'", cmdToken)
+		started := time.Now()
 		var err error
 		result, err = handler(ctx, ctx.args.m)
+
+		// like redis, the time spent blocked isn't counted, and the commands
+		// called by a script are part of the script in the slow log
+		duration := time.Since(started) - ctx.blocked
+		cd.dss.recordCommandLatency(cmdToken, cd.hasFlag(cmdToken, "fast"), duration)
+		if !ctx.script && !cd.hasFlag(cmdToken, "skip_slowlog") {
+			cd.dss.logSlowCommand(ctx, started, duration)
+		}
+
 		if err != nil {
 			l.Warnf("error processing command '%s': %s", cmdToken, err)
 			output.data = respErrorString(fmt.Sprintf("ERR Unknown command or wrong number of arguments for '%s'. Try COMMAND HELP.", ctx.cmdName))
+			cd.dss.recordCommandCall(cmdToken, duration, output, ctx.script)
 			return
 		}
+		cd.dss.recordCommandCall(cmdToken, duration, result, ctx.script)
+	}
+
+	cd.trackKeys(ctx, result)
+
+	// like redis, the monitors don't see admin commands, and see a script
+	// before the commands it calls
+	if !cd.hasFlag(cmdToken, "admin") && !cd.hasFlag(cmdToken, "skip_monitor") {
+		feedMonitors(ctx)
 	}
 
 	if ctx.cs.respVersion == 2 {
@@ -517,43 +798,92 @@ func (cd *cmdDispatcher) cmdCount() (output respValue) {
 	return
 }
 
+// determines if the command (or subcommand) is flagged as read-only
+func (cd *cmdDispatcher) isReadOnly(cmdToken string) bool {
+	return cd.hasFlag(cmdToken, "readonly")
+}
+
+// determines if the command (or subcommand) info has the specified flag
+func (cd *cmdDispatcher) hasFlag(cmdToken, flag string) bool {
+	info, exists := cd.infoTable.table[cmdToken]
+	if !exists {
+		return false
+	}
+	return slices.Contains(info.Flags, flag)
+}
+
 func (cd *cmdDispatcher) cmdGetKeys(argArray respArray, includeFlags bool) (output respValue) {
+	keys, flags, errText := cd.getKeysFromSpecs(argArray)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	if !includeFlags {
+		output = nativeValueToResp(keys)
+	} else {
+		a := make([]any, 0, len(keys))
+		for idx, key := range keys {
+			inner := make([]any, 0, 2)
+			inner = append(inner, key)
+			inner = append(inner, flags[idx])
+			a = append(a, inner)
+		}
+		output = nativeValueToResp(a)
+	}
+	return
+}
+
+// finds the key names within a full command line (command name included), using
+// the key specs of the command info; the key spec flags are provided for each key
+func (cd *cmdDispatcher) getKeysFromSpecs(argArray respArray) (keys []string, flags [][]string, errText respErrorString) {
 	if len(argArray) == 0 {
-		output.data = respErrorString("ERR Invalid number of arguments specified for command")
+		errText = respErrorString("ERR Invalid number of arguments specified for command")
 		return
 	}
 
 	args := make([]string, 0, len(argArray))
 	for _, arg := range argArray {
-		str, ok := arg.toString()
-		if !ok {
-			panic("command line arg arraytype error")
-		}
-		args = append(args, str)
+		args = append(args, arg.String())
 	}
 
 	cmdName := args[0]
 	cmdNameLower := strings.ToLower(cmdName)
 	cmd, exists := cd.active[cmdNameLower]
 	if !exists {
-		output.data = respErrorString("ERR Invalid command specified")
+		errText = respErrorString("ERR Invalid command specified")
 		return
 	}
-	info, exists := cd.infoTable.table[cmdNameLower]
-	if !exists {
-		panic("info missing for command " + cmdNameLower)
-	}
 
 	cmdArgs := argArray[1:]
-	parsed, keywords, _ := parseCommand(cmdNameLower, cmd, bitflags(PARSE_ADD_TOKEN_INDEX_TO_ARGS), cmdArgs.toValues()...)
+	if cmdNameLower == "zadd" {
+		cmdArgs = zaddSortOptions(cmdArgs)
+	}
+	_, keywords, cmdToken := parseCommand(cmdNameLower, cmd, 0, cmdArgs.toValues()...)
 
 	if keywords <= 0 {
-		output.data = respErrorString("ERR Invalid number of arguments specified for command")
+		errText = respErrorString("ERR Invalid number of arguments specified for command")
 		return
 	}
 
-	keys := []string{}
-	flags := []any{}
+	// subcommands such as OBJECT ENCODING carry their own key specs
+	info, exists := cd.infoTable.table[cmdToken]
+	if !exists {
+		info, exists = cd.infoTable.table[cmdNameLower]
+		if !exists {
+			panic("info missing for command " + cmdNameLower)
+		}
+	}
+
+	keys = []string{}
+	flags = [][]string{}
+
+	if cmdNameLower == "sort" || cmdNameLower == "sort_ro" {
+		// the STORE key spec of SORT is unknown, because the keyword can
+		// appear anywhere; find it as Redis does
+		keys, flags = sortGetKeys(args, info)
+		return
+	}
 
 	for _, keySpec := range info.KeySpecs {
 		firstIndex := -1
@@ -564,23 +894,27 @@ func (cd *cmdDispatcher) cmdGetKeys(argArray respArray, includeFlags bool) (outp
 			firstIndex = bsi.Index
 
 		case "keyword":
+			// like Redis, the keyword is searched from its start position,
+			// backwards from the end when the position is negative, and
+			// the keys begin after it
 			bsk := keySpec.BeginSearch.Spec.(*redisInfoBeginSearchKeyword)
-			t, _ := parsed.get(fmt.Sprintf("%s-index", bsk.Keyword))
-			indicies, exists := t.([]int)
-			if !exists {
-				output.data = respErrorString("ERR Invalid number of arguments specified for command")
-				return
+			start, stop, dir := bsk.StartFrom, len(args), 1
+			if start < 0 {
+				start, stop, dir = len(args)+start, 0, -1
 			}
-
-			// no command reuses a keyword
-			if len(indicies) != 1 {
-				output.data = respErrorString("ERR Unexpected repetition of token " + bsk.Keyword)
-				return
+			for i := start; i != stop && i > 0 && i < len(args); i += dir {
+				if strings.EqualFold(args[i], bsk.Keyword) {
+					firstIndex = i + 1
+					break
+				}
+			}
+			if firstIndex < 0 {
+				// optional keyword (such as SORT ... STORE) is not present
+				continue
 			}
-			firstIndex = indicies[0]
 
 		case "unknown":
-			output.data = respErrorString("ERR Key info for " + info.Name + "unsupported")
+			errText = respErrorString("ERR Key info for " + info.Name + "unsupported")
 			return
 		}
 
@@ -596,8 +930,10 @@ func (cd *cmdDispatcher) cmdGetKeys(argArray respArray, includeFlags bool) (outp
 				if fkr.Limit < 2 {
 					end = len(argArray)
 				} else {
+					// keys occupy the first 1/limit of the remaining args,
+					// such as XREAD STREAMS key key id id
 					width := len(argArray) - firstIndex
-					end = (width / fkr.Limit) + 1
+					end = firstIndex + (width / fkr.Limit)
 				}
 			} else {
 				end = firstIndex + end + 1
@@ -609,7 +945,7 @@ func (cd *cmdDispatcher) cmdGetKeys(argArray respArray, includeFlags bool) (outp
 			countIndex := firstIndex + fkkn.KeyNumIdx
 			count, valid := argArray[countIndex].toInt()
 			if !valid {
-				output.data = respErrorString("ERR Invalid arguments specified for command")
+				errText = respErrorString("ERR Invalid arguments specified for command")
 				return
 			}
 
@@ -619,7 +955,7 @@ func (cd *cmdDispatcher) cmdGetKeys(argArray respArray, includeFlags bool) (outp
 		}
 
 		if firstIndex < 0 || end < firstIndex || step < 1 {
-			output.data = respErrorString("ERR Invalid arguments specified for command")
+			errText = respErrorString("ERR Invalid arguments specified for command")
 			return
 		}
 
@@ -632,17 +968,32 @@ func (cd *cmdDispatcher) cmdGetKeys(argArray respArray, includeFlags bool) (outp
 		}
 	}
 
-	if !includeFlags {
-		output = nativeValueToResp(keys)
-	} else {
-		a := make([]any, 0, len(keys))
-		for idx, key := range keys {
-			inner := make([]any, 0, 2)
-			inner = append(inner, key)
-			inner = append(inner, flags[idx])
-			a = append(a, inner)
+	return
+}
+
+// finds the keys of SORT: the key to sort, and the STORE destination, which
+// is the argument after the last STORE that isn't the value of another option
+func sortGetKeys(args []string, info *redisInfo) (keys []string, flags [][]string) {
+	keys = []string{args[1]}
+	flags = [][]string{info.KeySpecs[0].Flags}
+
+	store := 0
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "limit":
+			i += 2
+		case "get", "by":
+			i++
+		case "store":
+			if i+1 < len(args) {
+				store = i + 1
+			}
 		}
-		output = nativeValueToResp(a)
+	}
+
+	if store > 0 && len(info.KeySpecs) > 2 {
+		keys = append(keys, args[store])
+		flags = append(flags, info.KeySpecs[2].Flags)
 	}
 	return
 }
@@ -6,8 +6,6 @@ import (
 	"time"
 )
 
-var multiDataStoreLock sync.Mutex
-
 type (
 	dataStore struct {
 		dataObjectNumber uint64
@@ -18,6 +16,9 @@ type (
 		cursors          map[int64]*storeKey
 		cursorsSize      int
 		waitingClients   *waitTable
+		index            int
+		memory           int64         // the sum of the key memory estimates
+		dss              *dataStoreSet // nil for a standalone data store
 	}
 )
 
@@ -43,27 +44,97 @@ func (ds *dataStore) getStoreKey(keyName string) (sk *storeKey, exists bool) {
 	val, exists := ds.data.get(keyName)
 	if exists {
 		sk = val.(*storeKey)
-		sk.lastAccess = time.Now()
+		ds.touchUnlocked(sk)
 	}
 	return
 }
 
 func (ds *dataStore) hasChangedUnlocked(keyName string, id uint64) bool {
 	sk, exists := ds.getStoreKey(keyName)
-	if !exists {
+	if !exists || sk.isExpiredUnlocked() {
+		// a key that expired since it was watched has changed
 		return id != 0
 	} else {
 		return id != sk.id
 	}
 }
 
+// assigns the id of a new data object
+func (ds *dataStore) nextObjectIdUnlocked() uint64 {
+	if ds.dss != nil {
+		// ids are unique across the data stores of the set, so that WATCH
+		// sees a key replaced by a key of another data store as changed
+		ds.dataObjectNumber = atomic.AddUint64(&ds.dss.lastObjectId, 1)
+	} else {
+		ds.dataObjectNumber++
+	}
+	return ds.dataObjectNumber
+}
+
+// removes all of the keys; the data store object remains, so clients that
+// have it selected, and their watches, continue to refer to it
+func (ds *dataStore) flushUnlocked() {
+	if ds.dss != nil {
+		ds.dss.addChanges(int64(ds.data.count))
+	}
+	for keyName := range ds.waitingClients.table {
+		if val, exists := ds.data.get(keyName); exists {
+			ds.unblockDeletedStreamUnlocked(keyName, val.(*storeKey))
+		}
+	}
+	ds.addMemoryUnlocked(-ds.memory)
+	ds.data = newRedisDict()
+	ds.data.dirty = true // the empty data store replaces the saved one
+	ds.cursors = make(map[int64]*storeKey, 2)
+	ds.cursorsSize = 2
+}
+
+// exchanges the keys with another data store; like a flush, the data store
+// objects remain at their indexes, so the clients that have them selected,
+// watch them or are blocked on them see the swapped keys
+func (ds *dataStore) swapUnlocked(other *dataStore) {
+	ds.data, other.data = other.data, ds.data
+	ds.cursors, other.cursors = other.cursors, ds.cursors
+	ds.cursorsSize, other.cursorsSize = other.cursorsSize, ds.cursorsSize
+	ds.memory, other.memory = other.memory, ds.memory
+	ds.data.dirty = true
+	other.data.dirty = true
+
+	ds.unblockExistingUnlocked()
+	other.unblockExistingUnlocked()
+}
+
+// wakes the blocked clients that wait on keys that exist; a woken client
+// that can't take from the key waits again
+func (ds *dataStore) unblockExistingUnlocked() {
+	for keyName := range ds.waitingClients.table {
+		sk, exists := ds.getStoreKey(keyName)
+		if exists && !sk.isExpiredUnlocked() {
+			ds.waitingClients.unblock(keyName, unblockAllWaiters)
+		}
+	}
+}
+
+// wakes the clients blocked on a stream that is deleted or replaced; as in
+// redis, a blocked XREADGROUP then replies with an error, and a blocked XREAD
+// waits again
+func (ds *dataStore) unblockDeletedStreamUnlocked(keyName string, sk *storeKey) {
+	if flagHasOne(sk.flags, FLAG_KEY_TYPE_STREAM) {
+		ds.waitingClients.unblock(keyName, unblockAllWaiters)
+	}
+}
+
 func (ds *dataStore) newStoreKeyUnlocked(keyName string) *storeKey {
-	ds.dataObjectNumber++
+	ds.notifyNewUnlocked(keyName)
+
+	now := time.Now()
 	sk := &storeKey{
-		id:         ds.dataObjectNumber,
-		lastAccess: time.Now(),
+		id:           ds.nextObjectIdUnlocked(),
+		lastAccess:   now,
+		lfuCounter:   lfuInitVal,
+		lfuDecayedAt: now,
 	}
-	ds.data.store(keyName, sk)
+	ds.storeKeyUnlocked(keyName, sk)
 	return sk
 }
 
@@ -81,9 +152,9 @@ func (ds *dataStore) copyStoreKeyUnlocked(srcKeyName, destKeyName string, dds *d
 		}
 	}
 
-	dds.dataObjectNumber++
-	newSk = sk.clone(dds.dataObjectNumber)
-	dds.data.store(destKeyName, newSk)
+	dds.notifyNewUnlocked(destKeyName)
+	newSk = sk.clone(dds.nextObjectIdUnlocked())
+	dds.storeKeyUnlocked(destKeyName, newSk)
 	return
 }
 
@@ -102,17 +173,25 @@ func (ds *dataStore) moveStoreKeyUnlocked(srcKeyName, destKeyName string, dds *d
 	}
 
 	// detach sk from the source db
-	ds.data.remove(srcKeyName)
+	ds.removeKeyUnlocked(srcKeyName)
 
 	// give sk a new id and link it to the dest db
-	dds.dataObjectNumber++
-	sk.id = dds.dataObjectNumber
-	dds.data.store(destKeyName, sk)
+	dds.notifyNewUnlocked(destKeyName)
+	sk.id = dds.nextObjectIdUnlocked()
+	dds.storeKeyUnlocked(destKeyName, sk)
 
 	newSk = sk
 	return
 }
 
+// sends the "new" keyspace event if the key is about to be added
+func (ds *dataStore) notifyNewUnlocked(keyName string) {
+	val, exists := ds.data.get(keyName)
+	if !exists || val.(*storeKey).isExpiredUnlocked() {
+		ds.notifyUnlocked(NOTIFY_NEW, "new", keyName, nil)
+	}
+}
+
 func (ds *dataStore) enterListBlock(keyName string) (ws *wakeSignal) {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
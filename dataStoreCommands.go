@@ -3,6 +3,7 @@ package redisemu
 import (
 	byteUtils "bytes"
 	"encoding/binary"
+	"encoding/gob"
 	"fmt"
 	"math"
 	"math/bits"
@@ -56,8 +57,10 @@ var wrongTypeError = respErrorString("WRONGTYPE Operation against a key holding
 
 type (
 	dataStoreCommand struct {
-		id uint32 // command counter
-		ds *dataStore
+		id       uint32 // command counter
+		ds       *dataStore
+		readOnly bool         // lookups of a read-only command report keyspace misses
+		origin   *clientState // the client of the command, for the NOLOOP tracking option
 	}
 
 	bitfieldOperation int
@@ -111,6 +114,37 @@ func (dsc *dataStoreCommand) unlockAndUnblock(uk *unblockKey) {
 	}
 }
 
+// takes the multi data store lock to lock more than one data store; like
+// lock(), it is re-entrant for the commands of a transaction or script that
+// holds it; a standalone data store has no other data stores to lock
+func (dsc *dataStoreCommand) lockMulti() {
+	dss := dsc.ds.dss
+	if dss != nil && (dsc.origin == nil || dss.multiOwner.Load() != dsc.origin) {
+		dss.multiMu.Lock()
+	}
+}
+
+func (dsc *dataStoreCommand) unlockMulti() {
+	dss := dsc.ds.dss
+	if dss != nil && (dsc.origin == nil || dss.multiOwner.Load() != dsc.origin) {
+		dss.multiMu.Unlock()
+	}
+}
+
+// takes the multi data store lock for a transaction or script that may lock
+// other data stores; it must be taken before the data store of the command
+func (dsc *dataStoreCommand) acquireMultiExclusive() {
+	dss := dsc.ds.dss
+	dss.multiMu.Lock()
+	dss.multiOwner.Store(dsc.origin)
+}
+
+func (dsc *dataStoreCommand) releaseMultiExclusive() {
+	dss := dsc.ds.dss
+	dss.multiOwner.Store(nil)
+	dss.multiMu.Unlock()
+}
+
 func (dsc *dataStoreCommand) acquireExclusive() {
 	// give ownership to the caller
 	dsc.ds.mu.Lock()
@@ -203,6 +237,15 @@ func (dsc *dataStoreCommand) setKey(keyName, str string, options bitflags, expir
 	newSk.flags = FLAG_KEY_TYPE_STRING
 	newSk.payload = argBytes
 	newSk.expiresAt = expiration
+
+	if flagHasOne(options, SET_APPEND) {
+		dsc.notifyUnlocked(NOTIFY_STRING, "append", keyName)
+	} else {
+		dsc.notifyUnlocked(NOTIFY_STRING, "set", keyName)
+		if !flagHasOne(options, SET_KEEP_TTL) && !expiration.Equal(maxTime) {
+			dsc.notifyUnlocked(NOTIFY_GENERIC, "expire", keyName)
+		}
+	}
 	return
 }
 
@@ -230,11 +273,42 @@ func (dsc *dataStoreCommand) setKeys(keys []string, values []string, options bit
 		newSk.flags = FLAG_KEY_TYPE_STRING
 		newSk.payload = []byte(val)
 		newSk.expiresAt = maxTime
+		dsc.notifyUnlocked(NOTIFY_STRING, "set", keyName)
 	}
 
 	return
 }
 
+// creates string keys named <prefix>:<n> with the value value:<n>, as DEBUG
+// POPULATE does; a size pads or truncates the values, and keys that exist
+// are left alone
+func (dsc *dataStoreCommand) populate(count int64, prefix string, size int64) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	for n := int64(0); n < count; n++ {
+		keyName := fmt.Sprintf("%s:%d", prefix, n)
+		if sk, exists := dsc.ds.getStoreKey(keyName); exists && !sk.isExpiredUnlocked() {
+			continue
+		}
+
+		value := []byte(fmt.Sprintf("value:%d", n))
+		if size > 0 {
+			sized := make([]byte, size)
+			copy(sized, value)
+			value = sized
+		}
+
+		// like Redis, there's no keyspace event other than "new"
+		newSk := dsc.ds.newStoreKeyUnlocked(keyName)
+		newSk.flags = FLAG_KEY_TYPE_STRING
+		newSk.payload = value
+		newSk.expiresAt = maxTime
+		dsc.ds.accountKeyUnlocked(keyName, newSk)
+		dsc.ds.trackingInvalidateKey(keyName)
+	}
+}
+
 func (dsc *dataStoreCommand) setRange(keyName string, offset int, substring string) (result respValue) {
 	dsc.lock()
 	defer dsc.unlock()
@@ -275,6 +349,7 @@ func (dsc *dataStoreCommand) setRange(keyName string, offset int, substring stri
 	newSk.flags = FLAG_KEY_TYPE_STRING
 	newSk.expiresAt = expiration
 	newSk.payload = newStrBytes
+	dsc.notifyUnlocked(NOTIFY_STRING, "setrange", keyName)
 
 	result.data = respInt(len(newStrBytes))
 	return
@@ -287,17 +362,67 @@ func (sk *storeKey) isExpiredUnlocked() bool {
 func (dsc *dataStoreCommand) getKeyObjectUnlocked(keyName string) (sk *storeKey, exists bool) {
 	sk, exists = dsc.ds.getStoreKey(keyName)
 	if !exists {
+		dsc.notifyMissUnlocked(keyName)
 		return
 	}
 	if sk.isExpiredUnlocked() {
+		// the first access after expiration notifies tracking clients,
+		// and marks the key as deleted
+		if !sk.expiresAt.Equal(minTime) {
+			sk.expiresAt = minTime
+			if dsc.ds.dss != nil {
+				dsc.ds.dss.recordExpiredKey()
+			}
+			dsc.notifyUnlocked(NOTIFY_EXPIRED, "expired", keyName)
+		}
 		exists = false
 		sk = nil
+		dsc.notifyMissUnlocked(keyName)
 		return
 	}
 
+	if sk.fieldExpiresAt != nil && !dsc.expireHashFieldsUnlocked(keyName, sk) {
+		exists = false
+		sk = nil
+	}
+	if !exists {
+		dsc.notifyMissUnlocked(keyName)
+	} else if dsc.readOnly && dsc.ds.dss != nil {
+		dsc.ds.dss.recordKeyspaceLookup(true)
+	}
 	return
 }
 
+// removes hash fields whose TTL has passed, and deletes the key along with its
+// last field; returns false if the key was deleted
+func (dsc *dataStoreCommand) expireHashFieldsUnlocked(keyName string, sk *storeKey) bool {
+	m := sk.getHashTable()
+	now := time.Now()
+
+	expired := false
+	for fieldName, expiresAt := range sk.fieldExpiresAt {
+		if now.After(expiresAt) {
+			m.remove(fieldName)
+			sk.clearFieldExpiration(fieldName)
+			expired = true
+		}
+	}
+
+	if !expired {
+		return true
+	}
+
+	dsc.setDirty()
+	dsc.notifyUnlocked(NOTIFY_HASH, "hexpired", keyName)
+
+	if m.count == 0 {
+		dsc.ds.removeKeyUnlocked(keyName)
+		dsc.notifyUnlocked(NOTIFY_GENERIC, "del", keyName)
+		return false
+	}
+	return true
+}
+
 func (dsc *dataStoreCommand) setDirty() {
 	dsc.ds.data.dirty = true
 }
@@ -381,6 +506,11 @@ func (dsc *dataStoreCommand) getKeySetExpiration(keyName string, expiration time
 		strBytes := sk.getStringBytes()
 		if strBytes != nil {
 			val = string(strBytes)
+			if !expiration.Equal(maxTime) {
+				dsc.notifyUnlocked(NOTIFY_GENERIC, "expire", keyName)
+			} else if !sk.expiresAt.Equal(maxTime) {
+				dsc.notifyUnlocked(NOTIFY_GENERIC, "persist", keyName)
+			}
 			sk.expiresAt = expiration
 		} else {
 			exists = VALUE_WRONG_TYPE
@@ -400,7 +530,8 @@ func (dsc *dataStoreCommand) getDeleteKey(keyName string) (val string, exists va
 		strBytes := sk.getStringBytes()
 		if strBytes != nil {
 			val = string(strBytes)
-			dsc.ds.data.remove(keyName)
+			dsc.ds.removeKeyUnlocked(keyName)
+			dsc.notifyUnlocked(NOTIFY_GENERIC, "del", keyName)
 		} else {
 			exists = VALUE_WRONG_TYPE
 		}
@@ -469,6 +600,7 @@ func (dsc *dataStoreCommand) addInt(keyName string, delta int64) (value int64, e
 	newSk.flags = FLAG_KEY_TYPE_STRING
 	newSk.expiresAt = expiration
 	newSk.payload = []byte(fmt.Sprintf("%d", value))
+	dsc.notifyUnlocked(NOTIFY_STRING, "incrby", keyName)
 
 	return
 }
@@ -506,6 +638,7 @@ func (dsc *dataStoreCommand) addFloat(keyName string, delta float64) (value floa
 	newSk.flags = FLAG_KEY_TYPE_STRING
 	newSk.expiresAt = expiration
 	newSk.payload = bytes
+	dsc.notifyUnlocked(NOTIFY_STRING, "incrbyfloat", keyName)
 
 	return
 }
@@ -624,6 +757,7 @@ func (dsc *dataStoreCommand) bitfieldWrite(keyName string, ops []*bitfieldOp) (o
 		newSk.flags = FLAG_KEY_TYPE_STRING
 		newSk.expiresAt = expiration
 		newSk.payload = strBytes
+		dsc.notifyUnlocked(NOTIFY_STRING, "setbit", keyName)
 	}
 
 	return nativeValueToResp(results)
@@ -654,6 +788,7 @@ func (dsc *dataStoreCommand) invertBits(srcKeyName, destKeyName string) (output
 	newSk.flags = FLAG_KEY_TYPE_STRING
 	newSk.expiresAt = maxTime
 	newSk.payload = invertedBytes
+	dsc.notifyUnlocked(NOTIFY_STRING, "set", destKeyName)
 
 	output.data = respInt(len(invertedBytes))
 	return
@@ -705,7 +840,8 @@ func (dsc *dataStoreCommand) changeBits(destKeyName string, srcKeyNames []string
 	newSk.expiresAt = maxTime
 	newSk.payload = resultBytes
 
-	dsc.ds.data.store(destKeyName, newSk)
+	dsc.ds.storeKeyUnlocked(destKeyName, newSk)
+	dsc.notifyUnlocked(NOTIFY_STRING, "set", destKeyName)
 	output.data = respInt(len(resultBytes))
 	return
 }
@@ -717,10 +853,10 @@ func (dsc *dataStoreCommand) copy(srcKeyName, destKeyName string, dds *dataStore
 		defer dsc.unlock()
 		dds = dsc.ds
 	} else {
-		// to acquire two data store locks, the global lock must be held, to prevent
+		// to acquire two data store locks, the multi data store lock must be held, to prevent
 		// a deadlock from two conflicting multi-data store operations
-		multiDataStoreLock.Lock()
-		defer multiDataStoreLock.Unlock()
+		dsc.lockMulti()
+		defer dsc.unlockMulti()
 
 		dsc.lock()
 		defer dsc.unlock()
@@ -739,6 +875,7 @@ func (dsc *dataStoreCommand) copy(srcKeyName, destKeyName string, dds *dataStore
 			return RESULT_MISSING_SOURCE
 		}
 	} else {
+		dds.notifyUnlocked(NOTIFY_GENERIC, "copy_to", destKeyName, dsc.origin)
 		return RESULT_COMPLETED
 	}
 }
@@ -749,10 +886,10 @@ func (dsc *dataStoreCommand) move(srcKeyName, destKeyName string, dds *dataStore
 		dsc.lock()
 		defer dsc.unlock()
 	} else {
-		// to acquire two data store locks, the global lock must be held, to prevent
+		// to acquire two data store locks, the multi data store lock must be held, to prevent
 		// a deadlock from two conflicting multi-data store operations
-		multiDataStoreLock.Lock()
-		defer multiDataStoreLock.Unlock()
+		dsc.lockMulti()
+		defer dsc.unlockMulti()
 
 		dsc.lock()
 		defer dsc.unlock()
@@ -771,6 +908,16 @@ func (dsc *dataStoreCommand) move(srcKeyName, destKeyName string, dds *dataStore
 			return RESULT_MISSING_SOURCE
 		}
 	} else {
+		if dds == dsc.ds {
+			dsc.notifyUnlocked(NOTIFY_GENERIC, "rename_from", srcKeyName)
+			dsc.notifyUnlocked(NOTIFY_GENERIC, "rename_to", destKeyName)
+		} else {
+			dsc.notifyUnlocked(NOTIFY_GENERIC, "move_from", srcKeyName)
+			dds.notifyUnlocked(NOTIFY_GENERIC, "move_to", destKeyName, dsc.origin)
+
+			// clients blocked in the other database may be able to take the key
+			dds.unblockListUnlocked(destKeyName, unblockAllWaiters)
+		}
 		return RESULT_COMPLETED
 	}
 }
@@ -786,13 +933,14 @@ func (dsc *dataStoreCommand) del(keyNames []string, reclaim bool) (output respVa
 			count++
 
 			if reclaim {
-				dsc.ds.data.remove(keyName)
+				dsc.ds.removeKeyUnlocked(keyName)
 			} else {
 				sk.expiresAt = minTime
 			}
+			dsc.notifyUnlocked(NOTIFY_GENERIC, "del", keyName)
 		} else if reclaim {
 			// remove expired now (if it exists)
-			dsc.ds.data.remove(keyName)
+			dsc.ds.removeKeyUnlocked(keyName)
 		}
 	}
 
@@ -838,14 +986,18 @@ func (dsc *dataStoreCommand) dump(keyName string) (output respValue) {
 
 	beLen := make([]byte, 4)
 
+	// a string is kept as is, other types in their persistable form
 	strBytes := sk.getStringBytes()
-	if strBytes != nil {
-		binary.BigEndian.PutUint32(beLen, uint32(len(strBytes))+1)
+	if !flagHasOne(sk.flags, FLAG_KEY_TYPE_STRING) {
+		var buf byteUtils.Buffer
+		if err := gob.NewEncoder(&buf).Encode(persistPayload(sk)); err != nil {
+			panic(err)
+		}
+		strBytes = buf.Bytes()
 	}
+	binary.BigEndian.PutUint32(beLen, uint32(len(strBytes))+1)
 	serial = append(serial, beLen...)
-	if strBytes != nil {
-		serial = append(serial, strBytes...)
-	}
+	serial = append(serial, strBytes...)
 
 	checksum := simpleChecksum(serial)
 	serial = append(serial, checksum...)
@@ -870,6 +1022,26 @@ func (dsc *dataStoreCommand) restore(keyName, serializedData string, ttl int64,
 		return
 	}
 
+	flags := bitflags(content[1])
+	payloadLen := binary.BigEndian.Uint32(content[2:6])
+	if payloadLen == 0 || int(payloadLen-1) != len(content)-6 {
+		output.data = respErrorString("ERR Bad data format")
+		return
+	}
+	serialBytes := content[6:]
+
+	var payload any
+	if flagHasOne(flags, FLAG_KEY_TYPE_STRING) {
+		payload = serialBytes
+	} else {
+		var err error
+		payload, err = readPersistPayload(gob.NewDecoder(byteUtils.NewReader(serialBytes)), flags)
+		if err != nil {
+			output.data = respErrorString("ERR Bad data format")
+			return
+		}
+	}
+
 	var expiration time.Time
 	if ttl != 0 {
 		if absttl {
@@ -892,16 +1064,11 @@ func (dsc *dataStoreCommand) restore(keyName, serializedData string, ttl int64,
 		}
 	}
 
-	len := binary.BigEndian.Uint32(content[2:6])
-	var serialBytes []byte
-	if len > 0 {
-		serialBytes = content[6 : 6+len-1]
-	}
-
 	newSk := dsc.ds.newStoreKeyUnlocked(keyName)
-	newSk.flags = bitflags(content[1])
+	newSk.flags = flags
 	newSk.expiresAt = expiration
-	newSk.payload = serialBytes
+	newSk.payload = payload
+	dsc.notifyUnlocked(NOTIFY_GENERIC, "restore", keyName)
 
 	output.data = rstrOK
 	return
@@ -939,7 +1106,14 @@ func (dsc *dataStoreCommand) expire(keyName string, expiration time.Time, nx, xx
 		}
 	}
 
-	sk.expiresAt = expiration
+	if expiration.After(time.Now()) {
+		sk.expiresAt = expiration
+		dsc.notifyUnlocked(NOTIFY_GENERIC, "expire", keyName)
+	} else {
+		// like redis, an expiration in the past deletes the key
+		sk.expiresAt = minTime
+		dsc.notifyUnlocked(NOTIFY_GENERIC, "del", keyName)
+	}
 	output.data = respInt(1)
 	return
 }
@@ -959,12 +1133,16 @@ func (dsc *dataStoreCommand) expireTime(keyName string) (expiration time.Time, v
 }
 
 func (dsc *dataStoreCommand) persist(keyName string) (output respValue) {
-	sk, exists := dsc.getKeyObject(keyName)
+	dsc.lock()
+	defer dsc.unlock()
+
+	sk, exists := dsc.getKeyObjectUnlocked(keyName)
 	if !exists || !sk.expiresAt.Before(maxTime) {
 		output.data = respInt(0)
 		return
 	}
 	sk.expiresAt = maxTime
+	dsc.notifyUnlocked(NOTIFY_GENERIC, "persist", keyName)
 	output.data = respInt(1)
 	return
 }
@@ -1100,7 +1278,7 @@ func (dsc *dataStoreCommand) ensureListUnlocked(keyName string) (list *storeList
 		newSk.expiresAt = maxTime
 		newSk.payload = list
 
-		dsc.ds.data.store(keyName, newSk)
+		dsc.ds.storeKeyUnlocked(keyName, newSk)
 	}
 	return
 }
@@ -1116,7 +1294,7 @@ func (dsc *dataStoreCommand) newListUnlocked(keyName string) (list *storeList) {
 		newSk.expiresAt = maxTime
 		newSk.payload = list
 
-		dsc.ds.data.store(keyName, newSk)
+		dsc.ds.storeKeyUnlocked(keyName, newSk)
 	}
 	return
 }
@@ -1152,6 +1330,7 @@ func (dsc *dataStoreCommand) lpush(keyName string, values [][]byte) (output resp
 		dsc.lpushUnlocked(keyName, list, element)
 	}
 	uk.elements = len(values)
+	dsc.notifyUnlocked(NOTIFY_LIST, "lpush", keyName)
 
 	output.data = respInt(list.count)
 	return
@@ -1178,6 +1357,7 @@ func (dsc *dataStoreCommand) lpushx(keyName string, values [][]byte) (output res
 		dsc.lpushUnlocked(keyName, list, element)
 	}
 	uk.elements = len(values)
+	dsc.notifyUnlocked(NOTIFY_LIST, "lpush", keyName)
 
 	output.data = respInt(list.count)
 	return
@@ -1198,7 +1378,7 @@ func (dsc *dataStoreCommand) lpopUnlocked(keyName string, list *storeList, item
 
 	// clean up if source list became empty
 	if list.count == 0 {
-		dsc.ds.data.remove(keyName)
+		dsc.ds.removeKeyUnlocked(keyName)
 	}
 
 	dsc.setDirty()
@@ -1223,6 +1403,10 @@ func (dsc *dataStoreCommand) lpop(keyName string, count int) (values [][]byte, e
 		values = append(values, item.element)
 		dsc.lpopUnlocked(keyName, list, item)
 	}
+
+	if len(values) > 0 {
+		dsc.notifyRemovalUnlocked(NOTIFY_LIST, "lpop", keyName, list.count)
+	}
 	return
 }
 
@@ -1257,6 +1441,7 @@ func (dsc *dataStoreCommand) rpush(keyName string, values [][]byte) (output resp
 		dsc.rpushUnlocked(keyName, list, element)
 	}
 	uk.elements = len(values)
+	dsc.notifyUnlocked(NOTIFY_LIST, "rpush", keyName)
 
 	output.data = respInt(list.count)
 	return
@@ -1283,6 +1468,7 @@ func (dsc *dataStoreCommand) rpushx(keyName string, values [][]byte) (output res
 		dsc.rpushUnlocked(keyName, list, element)
 	}
 	uk.elements = len(values)
+	dsc.notifyUnlocked(NOTIFY_LIST, "rpush", keyName)
 
 	output.data = respInt(list.count)
 	return
@@ -1303,7 +1489,7 @@ func (dsc *dataStoreCommand) rpopUnlocked(keyName string, list *storeList, item
 
 	// clean up if source list became empty
 	if list.count == 0 {
-		dsc.ds.data.remove(keyName)
+		dsc.ds.removeKeyUnlocked(keyName)
 	}
 
 	dsc.setDirty()
@@ -1328,6 +1514,10 @@ func (dsc *dataStoreCommand) rpop(keyName string, count int) (values [][]byte, e
 		values = append(values, item.element)
 		dsc.rpopUnlocked(keyName, list, item)
 	}
+
+	if len(values) > 0 {
+		dsc.notifyRemovalUnlocked(NOTIFY_LIST, "rpop", keyName, list.count)
+	}
 	return
 }
 
@@ -1442,6 +1632,7 @@ func (dsc *dataStoreCommand) linsert(keyName string, before bool, pivot, element
 	} else {
 		dsc.linsertAfterUnlocked(list, pivotItem, []byte(element))
 	}
+	dsc.notifyUnlocked(NOTIFY_LIST, "linsert", keyName)
 
 	output.data = respInt(list.count)
 	return
@@ -1540,23 +1731,29 @@ func (dsc *dataStoreCommand) lmove(srcKeyName, destKeyName string, srcLeft, dest
 
 	// remove the item from the source list
 	var item *listItem
+	popEvent := "lpop"
 	if srcLeft {
 		item = srcList.head
 		dsc.lpopUnlocked(srcKeyName, srcList, item)
 	} else {
 		item = srcList.tail
 		dsc.rpopUnlocked(srcKeyName, srcList, item)
+		popEvent = "rpop"
 	}
 	element := item.element
 
 	// place the item into the dest list
 	if destLeft {
 		dsc.lpushUnlocked(destKeyName, destList, element)
+		dsc.notifyUnlocked(NOTIFY_LIST, "lpush", destKeyName)
 	} else {
 		dsc.rpushUnlocked(destKeyName, destList, element)
+		dsc.notifyUnlocked(NOTIFY_LIST, "rpush", destKeyName)
 	}
 	uk.elements = 1
 
+	dsc.notifyRemovalUnlocked(NOTIFY_LIST, popEvent, srcKeyName, srcList.count)
+
 	output.data = respBulkString(element)
 	return
 }
@@ -1598,6 +1795,12 @@ func (dsc *dataStoreCommand) lmpop(keyNames []string, left bool, count int) (out
 				}
 			}
 
+			if left {
+				dsc.notifyRemovalUnlocked(NOTIFY_LIST, "lpop", keyName, list.count)
+			} else {
+				dsc.notifyRemovalUnlocked(NOTIFY_LIST, "rpop", keyName, list.count)
+			}
+
 			result = []any{keyName, elements}
 			break
 		}
@@ -1683,7 +1886,7 @@ func (dsc *dataStoreCommand) removeUnlocked(keyName string, list *storeList, ite
 
 	if list.count == 0 {
 		// remove the key
-		dsc.ds.data.remove(keyName)
+		dsc.ds.removeKeyUnlocked(keyName)
 	}
 
 	// item removed, dereference
@@ -1731,6 +1934,9 @@ func (dsc *dataStoreCommand) lremove(keyName string, element string, count int)
 		}
 	}
 
+	if removed > 0 {
+		dsc.notifyRemovalUnlocked(NOTIFY_LIST, "lrem", keyName, list.count)
+	}
 	return
 }
 
@@ -1787,6 +1993,7 @@ func (dsc *dataStoreCommand) lset(keyName string, element string, count int) (ou
 	}
 
 	item.element = []byte(element)
+	dsc.notifyUnlocked(NOTIFY_LIST, "lset", keyName)
 	output.data = rstrOK
 	return
 }
@@ -1838,6 +2045,7 @@ func (dsc *dataStoreCommand) ltrim(keyName string, start, stop int) (output resp
 	for stop < list.count {
 		dsc.rpopUnlocked(keyName, list, list.tail)
 	}
+	dsc.notifyRemovalUnlocked(NOTIFY_LIST, "ltrim", keyName, list.count)
 
 	output.data = rstrOK
 	return
@@ -1922,11 +2130,11 @@ func (dsc *dataStoreCommand) setHashTableWorker(keyName string, fieldNames, valu
 	dsc.lock()
 	defer dsc.unlock()
 
-	oldSk, exists := dsc.getKeyObjectUnlocked(keyName)
+	sk, exists := dsc.getKeyObjectUnlocked(keyName)
 
 	var m *redisDict
 	if exists {
-		m = oldSk.getHashTable()
+		m = sk.getHashTable()
 		if m == nil {
 			wrongType = true
 			return
@@ -1935,12 +2143,13 @@ func (dsc *dataStoreCommand) setHashTableWorker(keyName string, fieldNames, valu
 		// new key
 		m = newRedisDict()
 
-		newSk := dsc.ds.newStoreKeyUnlocked(keyName)
-		newSk.flags = FLAG_KEY_TYPE_HASH_TABLE
-		newSk.payload = m
-		newSk.expiresAt = maxTime
+		sk = dsc.ds.newStoreKeyUnlocked(keyName)
+		sk.flags = FLAG_KEY_TYPE_HASH_TABLE
+		sk.payload = m
+		sk.expiresAt = maxTime
 	}
 
+	stored := false
 	for idx, fieldName := range fieldNames {
 		_, exists := m.get(fieldName)
 		if exists {
@@ -1951,7 +2160,13 @@ func (dsc *dataStoreCommand) setHashTableWorker(keyName string, fieldNames, valu
 			added++
 		}
 		m.store(fieldName, values[idx])
+		sk.clearFieldExpiration(fieldName)
 		dsc.setDirty()
+		stored = true
+	}
+
+	if stored {
+		dsc.notifyUnlocked(NOTIFY_HASH, "hset", keyName)
 	}
 	return
 }
@@ -1975,15 +2190,20 @@ func (dsc *dataStoreCommand) deleteHashTableFields(keyName string, fieldNames []
 
 		for _, fieldName := range fieldNames {
 			if m.remove(fieldName) {
+				sk.clearFieldExpiration(fieldName)
 				removed++
 				dsc.setDirty()
 
 				if m.count == 0 {
-					dsc.ds.data.remove(keyName)
+					dsc.ds.removeKeyUnlocked(keyName)
 					break
 				}
 			}
 		}
+
+		if removed > 0 {
+			dsc.notifyRemovalUnlocked(NOTIFY_HASH, "hdel", keyName, m.count)
+		}
 	}
 	return
 }
@@ -2033,6 +2253,7 @@ func (dsc *dataStoreCommand) fieldAddInt(keyName, fieldName string, delta int64)
 	}
 	m.store(fieldName, fmt.Sprintf("%d", value))
 	dsc.setDirty()
+	dsc.notifyUnlocked(NOTIFY_HASH, "hincrby", keyName)
 
 	return
 }
@@ -2087,6 +2308,7 @@ func (dsc *dataStoreCommand) fieldAddFloat(keyName, fieldName string, delta floa
 	}
 
 	m.store(fieldName, strconv.FormatFloat(value, 'f', -1, 64))
+	dsc.notifyUnlocked(NOTIFY_HASH, "hincrbyfloat", keyName)
 	return
 }
 
@@ -2372,6 +2594,10 @@ func (dsc *dataStoreCommand) setAddWorkerUnlocked(keyName string, memberNames []
 		m.store(memberName, struct{}{})
 		dsc.setDirty()
 	}
+
+	if added > 0 {
+		dsc.notifyUnlocked(NOTIFY_SET, "sadd", keyName)
+	}
 	return
 }
 
@@ -2398,11 +2624,15 @@ func (dsc *dataStoreCommand) deleteSetMembers(keyName string, memberNames []stri
 				dsc.setDirty()
 
 				if m.count == 0 {
-					dsc.ds.data.remove(keyName)
+					dsc.ds.removeKeyUnlocked(keyName)
 					break
 				}
 			}
 		}
+
+		if removed > 0 {
+			dsc.notifyRemovalUnlocked(NOTIFY_SET, "srem", keyName, m.count)
+		}
 	}
 	return
 }
@@ -2597,7 +2827,7 @@ func (dsc *dataStoreCommand) setOperation(
 }
 
 func (dsc *dataStoreCommand) setOperationStore(
-	destination, keyName string,
+	destination, keyName, event string,
 	op func(firstKeyName string, keyNames ...string) (*redisDict, bool),
 	withKeyNames ...string) (output respValue) {
 	dsc.lock()
@@ -2609,10 +2839,22 @@ func (dsc *dataStoreCommand) setOperationStore(
 		return
 	}
 
+	if d.count == 0 {
+		// like redis, an empty result deletes the destination
+		if _, exists := dsc.getKeyObjectUnlocked(destination); exists {
+			dsc.ds.removeKeyUnlocked(destination)
+			dsc.setDirty()
+			dsc.notifyUnlocked(NOTIFY_GENERIC, "del", destination)
+		}
+		output.data = respInt(0)
+		return
+	}
+
 	newSk := dsc.ds.newStoreKeyUnlocked(destination)
 	newSk.flags = FLAG_KEY_TYPE_SET
 	newSk.payload = d
 	newSk.expiresAt = maxTime
+	dsc.notifyUnlocked(NOTIFY_SET, event, destination)
 
 	output.data = respInt(d.count)
 	return
@@ -2674,7 +2916,7 @@ func (dsc *dataStoreCommand) diffSet(keyName string, withKeyNames ...string) (ou
 }
 
 func (dsc *dataStoreCommand) diffSetStore(destination, keyName string, withKeyNames ...string) (output respValue) {
-	return dsc.setOperationStore(destination, keyName, dsc.diffWorker, withKeyNames...)
+	return dsc.setOperationStore(destination, keyName, "sdiffstore", dsc.diffWorker, withKeyNames...)
 }
 
 func (dsc *dataStoreCommand) intersectWorker(firstKey string, keyNames ...string) (d *redisDict, wrongType bool) {
@@ -2771,7 +3013,7 @@ func (dsc *dataStoreCommand) intersectSet(keyName string, withKeyNames ...string
 }
 
 func (dsc *dataStoreCommand) intersectSetStore(destination, keyName string, withKeyNames ...string) (output respValue) {
-	return dsc.setOperationStore(destination, keyName, dsc.intersectWorker, withKeyNames...)
+	return dsc.setOperationStore(destination, keyName, "sinterstore", dsc.intersectWorker, withKeyNames...)
 }
 
 func (dsc *dataStoreCommand) intersectSetCount(limit int, keyNames ...string) (output respValue) {
@@ -2818,7 +3060,7 @@ func (dsc *dataStoreCommand) unionSet(keyName string, withKeyNames ...string) (o
 }
 
 func (dsc *dataStoreCommand) unionSetStore(destination, keyName string, withKeyNames ...string) (output respValue) {
-	return dsc.setOperationStore(destination, keyName, dsc.unionWorker, withKeyNames...)
+	return dsc.setOperationStore(destination, keyName, "sunionstore", dsc.unionWorker, withKeyNames...)
 }
 
 func (dsc *dataStoreCommand) setMove(source, destination, memberName string) (output respValue) {
@@ -2850,6 +3092,11 @@ func (dsc *dataStoreCommand) setMove(source, destination, memberName string) (ou
 	}
 
 	ss.remove(memberName)
+	dsc.setDirty()
+	if ss.count == 0 {
+		dsc.ds.removeKeyUnlocked(source)
+	}
+	dsc.notifyRemovalUnlocked(NOTIFY_SET, "srem", source, ss.count)
 
 	output.data = respInt(added)
 	return
@@ -2878,199 +3125,2236 @@ func (dsc *dataStoreCommand) setRemove(keyName string, members []string) (output
 		}
 	}
 
+	if removals > 0 {
+		dsc.setDirty()
+		if m.count == 0 {
+			dsc.ds.removeKeyUnlocked(keyName)
+		}
+		dsc.notifyRemovalUnlocked(NOTIFY_SET, "srem", keyName, m.count)
+	}
+
 	output.data = respInt(removals)
 	return
 }
 
-func (dsc *dataStoreCommand) save(l lane.Lane, path string) (err error) {
-	if dsc.ds.data.dirty {
-		dsc.lock()
-		defer dsc.unlock()
-
-		if err = dsc.ds.save(path); err != nil {
-			l.Errorf("Unable to save to %s. Error: %s", path, err)
-			return
-		}
+func (dsc *dataStoreCommand) getZSetUnlocked(keyName string) (z *zset, err *respErrorString) {
+	sk, exists := dsc.getKeyObjectUnlocked(keyName)
 
-		dsc.ds.data.dirty = false
-		l.Tracef("Changes saved to %s", path)
+	if !exists {
+		return
 	}
 
+	z = sk.getZSet()
+	if z == nil {
+		err = &wrongTypeError
+	}
 	return
 }
 
-func (dsc *dataStoreCommand) load(l lane.Lane, path string) (err error) {
-	dsc.lock()
-	defer dsc.unlock()
+func (dsc *dataStoreCommand) newZSetUnlocked(keyName string, z *zset) {
+	newSk := dsc.ds.newStoreKeyUnlocked(keyName)
+	newSk.flags = FLAG_KEY_TYPE_ZSET
+	newSk.expiresAt = maxTime
+	newSk.payload = z
+	dsc.setDirty()
+}
 
-	if err = dsc.ds.load(path); err != nil {
-		l.Errorf("Failed to load saved data from %s. Error: %s", path, err)
+// gets a sorted set, or a set as a sorted set where each member has a score of 1
+func (dsc *dataStoreCommand) getZSetSourceUnlocked(keyName string) (z *zset, err *respErrorString) {
+	sk, exists := dsc.getKeyObjectUnlocked(keyName)
+
+	if !exists {
 		return
 	}
 
-	l.Infof("Loaded saved data from %s", path)
+	z = sk.getZSet()
+	if z == nil {
+		m := sk.getSet()
+		if m == nil {
+			err = &wrongTypeError
+			return
+		}
+
+		z = newZSet()
+		for it := m.createIterator(); it.next(); {
+			z.set(it.key, 1)
+		}
+	}
 	return
 }
 
-func (dsc *dataStoreCommand) sort(sourceKeyName, byPattern, destKeyName string, startAt, count int, getPatterns []string, limit, desc, alpha bool) (output respValue) {
+func (dsc *dataStoreCommand) zadd(keyName string, members []string, scores []float64, flags bitflags) (output respValue) {
+	uk := unblockKey{keyName: keyName}
+
 	dsc.lock()
-	defer dsc.unlock()
+	defer dsc.unlockAndUnblock(&uk)
 
-	// get the values to sort
-	var vals []sortVal
-	list, _ := dsc.getListUnlocked(sourceKeyName)
-	if list != nil {
-		// convert linked list into a value array
-		vals = make([]sortVal, 0, list.count)
-		for i := list.head; i != nil; i = i.next {
-			sv := sortVal{
-				data: string(i.element),
-			}
-			vals = append(vals, sv)
-		}
-	} else {
-		sk, objExists := dsc.getKeyObjectUnlocked(sourceKeyName)
-		if !objExists {
-			output = nativeValueToResp([]any{})
+	z, err := dsc.getZSetUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	isNew := (z == nil)
+	if isNew {
+		z = newZSet()
+	}
+
+	changed := 0
+	modified := false
+	for idx, member := range members {
+		newScore, result := z.add(member, scores[idx], flags)
+
+		switch result {
+		case ZADD_RESULT_NAN:
+			output.data = respErrorString("ERR resulting score is not a number (NaN)")
 			return
+		case ZADD_RESULT_ADDED:
+			uk.elements++
+			changed++
+			modified = true
+			dsc.setDirty()
+		case ZADD_RESULT_UPDATED:
+			if flagHasOne(flags, ZADD_CH) {
+				changed++
+			}
+			modified = true
+			dsc.setDirty()
 		}
 
-		ss := sk.getSet()
-		if ss != nil {
-			// convert set (a hash table) into a value array
-			vals = make([]sortVal, 0, ss.count)
-			for i := ss.createIterator(); i.next(); {
-				sv := sortVal{
-					data: i.value.(string),
-				}
-				vals = append(vals, sv)
-			}
-		} else {
-			output.data = wrongTypeError
-			return
+		if flagHasOne(flags, ZADD_INCR) && result != ZADD_RESULT_SKIPPED {
+			output.data = respDouble(newScore)
 		}
 	}
 
-	dontSort := false
-	if byPattern != "" {
-		if !strings.Contains(byPattern, "*") {
-			dontSort = true
-		} else {
-			for idx, val := range vals {
-				pat := strings.Replace(byPattern, "*", val.data, 1)
-				byVal, byValExists := dsc.getKeyUnlocked(pat)
-				if byValExists != VALUE_EXISTS {
-					val.sortByStr = "0"
-					val.sortByFloat = 0
-				} else {
-					val.sortByStr = byVal
-					if !alpha {
-						f64, parseErr := strconv.ParseFloat(byVal, 64)
-						if parseErr != nil {
-							output.data = respErrorString("ERR One or more scores can't be converted into double")
-							return
-						}
-						val.sortByFloat = f64
-					}
-				}
-				vals[idx] = val
-			}
-		}
+	if isNew && z.count() > 0 {
+		dsc.newZSetUnlocked(keyName, z)
 	}
 
-	if !dontSort {
-		// pick a sorting strategy
-		if alpha {
-			if !desc {
-				// asc alpha
-				sort.Slice(vals, func(i, j int) bool {
-					return vals[i].sortByStr < vals[j].sortByStr
-				})
-			} else {
-				// desc alpha
-				sort.Slice(vals, func(i, j int) bool {
-					return vals[j].sortByStr < vals[i].sortByStr
-				})
-			}
+	if modified {
+		if flagHasOne(flags, ZADD_INCR) {
+			dsc.notifyUnlocked(NOTIFY_ZSET, "zincr", keyName)
 		} else {
-			if !desc {
-				// asc numeric
-				sort.Slice(vals, func(i, j int) bool {
-					return vals[i].sortByFloat < vals[j].sortByFloat
-				})
-			} else {
-				// desc numeric
-				sort.Slice(vals, func(i, j int) bool {
-					return vals[j].sortByFloat < vals[i].sortByFloat
-				})
-			}
+			dsc.notifyUnlocked(NOTIFY_ZSET, "zadd", keyName)
 		}
 	}
 
-	if limit {
-		start := startAt
-		if start < 0 {
-			start = 0
-		}
+	if !flagHasOne(flags, ZADD_INCR) {
+		output.data = respInt(changed)
+	}
+	return
+}
 
-		if count < 0 {
-			count = len(vals)
-		}
+func (dsc *dataStoreCommand) zcard(keyName string) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
 
-		end := start + count
-		if start >= len(vals) {
-			start = 0
-			end = 0
-		} else if end < start {
-			end = start
-		} else if end >= len(vals) {
+	z, err := dsc.getZSetUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	if z == nil {
+		output.data = respInt(0)
+	} else {
+		output.data = respInt(z.count())
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) zscore(keyName string, memberNames []string) (scores []any, err *respErrorString) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	z, err := dsc.getZSetUnlocked(keyName)
+	if err != nil {
+		return
+	}
+
+	scores = make([]any, 0, len(memberNames))
+	for _, memberName := range memberNames {
+		var score float64
+		exists := false
+		if z != nil {
+			score, exists = z.score(memberName)
+		}
+
+		if exists {
+			scores = append(scores, score)
+		} else {
+			scores = append(scores, nil)
+		}
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) zrank(keyName, memberName string, reverse, withScore bool) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	z, err := dsc.getZSetUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	if z == nil {
+		return
+	}
+
+	rank, score, exists := z.rank(memberName, reverse)
+	if !exists {
+		return
+	}
+
+	if withScore {
+		output.data = respArray{
+			respValue{data: respInt(rank)},
+			respValue{data: respDouble(score)},
+		}
+	} else {
+		output.data = respInt(rank)
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) zrem(keyName string, memberNames []string) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	z, err := dsc.getZSetUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	removed := 0
+	if z != nil {
+		for _, memberName := range memberNames {
+			if z.remove(memberName) {
+				removed++
+			}
+		}
+
+		if removed > 0 {
+			dsc.setDirty()
+			if z.count() == 0 {
+				dsc.ds.removeKeyUnlocked(keyName)
+			}
+			dsc.notifyRemovalUnlocked(NOTIFY_ZSET, "zrem", keyName, z.count())
+		}
+	}
+
+	output.data = respInt(removed)
+	return
+}
+
+func (dsc *dataStoreCommand) zrange(keyName string, spec *zrangeSpec, withScores bool) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	z, err := dsc.getZSetUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	items := []zsetItem{}
+	if z != nil {
+		items = z.selectRange(spec)
+	}
+
+	output = zsetItemsToResp(items, withScores)
+	return
+}
+
+func (dsc *dataStoreCommand) zrangeStore(destKeyName, srcKeyName string, spec *zrangeSpec) (output respValue) {
+	uk := unblockKey{keyName: destKeyName}
+
+	dsc.lock()
+	defer dsc.unlockAndUnblock(&uk)
+
+	z, err := dsc.getZSetUnlocked(srcKeyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	result := newZSet()
+	if z != nil {
+		z.iterateRange(spec, func(member string, score float64) bool {
+			result.set(member, score)
+			return true
+		})
+	}
+
+	output.data = respInt(dsc.zstoreUnlocked(destKeyName, result, "zrangestore"))
+	uk.elements = result.count()
+	return
+}
+
+// replaces the destination key with a sorted set, or deletes it if the sorted set is empty
+func (dsc *dataStoreCommand) zstoreUnlocked(destKeyName string, z *zset, event string) int {
+	if z.count() == 0 {
+		if _, exists := dsc.getKeyObjectUnlocked(destKeyName); exists {
+			dsc.notifyUnlocked(NOTIFY_GENERIC, "del", destKeyName)
+		}
+		if dsc.ds.removeKeyUnlocked(destKeyName) {
+			dsc.setDirty()
+		}
+	} else {
+		dsc.newZSetUnlocked(destKeyName, z)
+		dsc.notifyUnlocked(NOTIFY_ZSET, event, destKeyName)
+	}
+	return z.count()
+}
+
+func (dsc *dataStoreCommand) zcount(keyName string, spec *zrangeSpec) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	z, err := dsc.getZSetUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	count := 0
+	if z != nil {
+		z.iterateRange(spec, func(member string, score float64) bool {
+			count++
+			return true
+		})
+	}
+
+	output.data = respInt(count)
+	return
+}
+
+func (dsc *dataStoreCommand) zremRange(keyName string, spec *zrangeSpec) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	z, err := dsc.getZSetUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	removed := 0
+	if z != nil {
+		for _, item := range z.selectRange(spec) {
+			z.remove(item.member)
+			removed++
+		}
+
+		if removed > 0 {
+			dsc.setDirty()
+			if z.count() == 0 {
+				dsc.ds.removeKeyUnlocked(keyName)
+			}
+
+			event := "zremrangebyrank"
+			if spec.scores != nil {
+				event = "zremrangebyscore"
+			} else if spec.lex != nil {
+				event = "zremrangebylex"
+			}
+			dsc.notifyRemovalUnlocked(NOTIFY_ZSET, event, keyName, z.count())
+		}
+	}
+
+	output.data = respInt(removed)
+	return
+}
+
+func (dsc *dataStoreCommand) zpopUnlocked(keyName string, z *zset, count int, max bool) (items []zsetItem) {
+	items = make([]zsetItem, 0, count)
+	z.iterate(max, func(member string, score float64) bool {
+		if len(items) >= count {
+			return false
+		}
+		items = append(items, zsetItem{member, score})
+		return true
+	})
+
+	for _, item := range items {
+		z.remove(item.member)
+	}
+
+	if len(items) > 0 {
+		dsc.setDirty()
+
+		// clean up if the sorted set became empty
+		if z.count() == 0 {
+			dsc.ds.removeKeyUnlocked(keyName)
+		}
+
+		if max {
+			dsc.notifyRemovalUnlocked(NOTIFY_ZSET, "zpopmax", keyName, z.count())
+		} else {
+			dsc.notifyRemovalUnlocked(NOTIFY_ZSET, "zpopmin", keyName, z.count())
+		}
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) zpop(keyName string, count int, max bool) (items []zsetItem, err *respErrorString) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	z, err := dsc.getZSetUnlocked(keyName)
+	if err != nil || z == nil {
+		return
+	}
+
+	items = dsc.zpopUnlocked(keyName, z, count, max)
+	return
+}
+
+func (dsc *dataStoreCommand) zmpop(keyNames []string, max bool, count int) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	for _, keyName := range keyNames {
+		z, err := dsc.getZSetUnlocked(keyName)
+		if err != nil {
+			output.data = *err
+			return
+		}
+
+		if z != nil && z.count() > 0 {
+			items := dsc.zpopUnlocked(keyName, z, count, max)
+
+			elements := make(respArray, 0, len(items))
+			for _, item := range items {
+				elements = append(elements, respValue{data: respArray{
+					respValue{data: respBulkString(item.member)},
+					respValue{data: respDouble(item.score)},
+				}})
+			}
+
+			output.data = respArray{
+				respValue{data: respBulkString(keyName)},
+				respValue{data: elements},
+			}
+			return
+		}
+	}
+
+	return
+}
+
+func (dsc *dataStoreCommand) zrandMember(keyName string, count *int, withScores bool) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	z, err := dsc.getZSetUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	if z == nil {
+		if count != nil {
+			output.data = respArray{}
+		}
+		return
+	}
+
+	var items []*redisDictItem
+	if count == nil || *count < 0 {
+		arraySize := 1
+		if count != nil {
+			arraySize = -(*count)
+		}
+
+		items = z.members.pickRandomItems(arraySize, 85)
+	} else {
+		items = z.members.pickUniqueRandomItems(*count, 85)
+	}
+
+	if count == nil {
+		output.data = respBulkString(items[0].key)
+		return
+	}
+
+	zitems := make([]zsetItem, 0, len(items))
+	for _, item := range items {
+		zitems = append(zitems, zsetItem{item.key, item.value.(float64)})
+	}
+	output = zsetItemsToResp(zitems, withScores)
+	return
+}
+
+func (dsc *dataStoreCommand) zscan(keyName string, cursor uint32, pattern string, count int) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	z, err := dsc.getZSetUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	if z == nil {
+		result := make([]any, 2)
+		result[0] = "0"
+		result[1] = []any{}
+		output = nativeValueToResp(result)
+		return
+	}
+
+	return dsc.dictScanUnlocked(
+		z.members, cursor, pattern, count,
+		func(item *redisDictItem) any {
+			return respDouble(item.value.(float64)).String()
+		})
+}
+
+func (dsc *dataStoreCommand) zsetSourcesUnlocked(keyNames []string) (sources []*zset, err *respErrorString) {
+	sources = make([]*zset, 0, len(keyNames))
+	for _, keyName := range keyNames {
+		var z *zset
+		z, err = dsc.getZSetSourceUnlocked(keyName)
+		if err != nil {
+			return
+		}
+		sources = append(sources, z)
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) zsetOperation(keyNames []string, op zsetCombiner, withScores bool) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	sources, err := dsc.zsetSourcesUnlocked(keyNames)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	result := op(sources)
+	output = zsetItemsToResp(result.selectRange(&zrangeSpec{start: 0, stop: -1, limit: -1}), withScores)
+	return
+}
+
+func (dsc *dataStoreCommand) zsetOperationStore(destination, event string, keyNames []string, op zsetCombiner) (output respValue) {
+	uk := unblockKey{keyName: destination}
+
+	dsc.lock()
+	defer dsc.unlockAndUnblock(&uk)
+
+	sources, err := dsc.zsetSourcesUnlocked(keyNames)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	result := op(sources)
+	output.data = respInt(dsc.zstoreUnlocked(destination, result, event))
+	uk.elements = result.count()
+	return
+}
+
+func (dsc *dataStoreCommand) zinterCard(keyNames []string, limit int) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	sources, err := dsc.zsetSourcesUnlocked(keyNames)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	output.data = respInt(zsetIntersectCount(sources, limit))
+	return
+}
+
+func (dsc *dataStoreCommand) save(l lane.Lane, path string) (err error) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	if dsc.ds.data.dirty {
+		if err = dsc.ds.save(path); err != nil {
+			l.Errorf("Unable to save to %s. Error: %s", path, err)
+			return
+		}
+
+		dsc.ds.data.dirty = false
+		l.Tracef("Changes saved to %s", path)
+	}
+
+	return
+}
+
+func (dsc *dataStoreCommand) load(l lane.Lane, path string) (err error) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	if err = dsc.ds.load(path); err != nil {
+		l.Errorf("Failed to load saved data from %s. Error: %s", path, err)
+		return
+	}
+
+	l.Infof("Loaded saved data from %s", path)
+	return
+}
+
+func (dsc *dataStoreCommand) sort(sourceKeyName, byPattern, destKeyName string, startAt, count int, getPatterns []string, limit, desc, alpha bool) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	// get the values to sort
+	var vals []sortVal
+	list, _ := dsc.getListUnlocked(sourceKeyName)
+	if list != nil {
+		// convert linked list into a value array
+		vals = make([]sortVal, 0, list.count)
+		for i := list.head; i != nil; i = i.next {
+			sv := sortVal{
+				data: string(i.element),
+			}
+			vals = append(vals, sv)
+		}
+	} else {
+		sk, objExists := dsc.getKeyObjectUnlocked(sourceKeyName)
+		if !objExists {
+			output = nativeValueToResp([]any{})
+			return
+		}
+
+		ss := sk.getSet()
+		if ss != nil {
+			// convert set (a hash table) into a value array
+			vals = make([]sortVal, 0, ss.count)
+			for i := ss.createIterator(); i.next(); {
+				sv := sortVal{
+					data: i.value.(string),
+				}
+				vals = append(vals, sv)
+			}
+		} else if z := sk.getZSet(); z != nil {
+			// convert sorted set into a value array
+			vals = make([]sortVal, 0, z.count())
+			z.iterate(false, func(member string, score float64) bool {
+				vals = append(vals, sortVal{data: member})
+				return true
+			})
+		} else {
+			output.data = wrongTypeError
+			return
+		}
+	}
+
+	dontSort := false
+	if byPattern != "" {
+		if !strings.Contains(byPattern, "*") {
+			dontSort = true
+		} else {
+			for idx, val := range vals {
+				pat := strings.Replace(byPattern, "*", val.data, 1)
+				byVal, byValExists := dsc.getKeyUnlocked(pat)
+				if byValExists != VALUE_EXISTS {
+					val.sortByStr = "0"
+					val.sortByFloat = 0
+				} else {
+					val.sortByStr = byVal
+					if !alpha {
+						f64, parseErr := strconv.ParseFloat(byVal, 64)
+						if parseErr != nil {
+							output.data = respErrorString("ERR One or more scores can't be converted into double")
+							return
+						}
+						val.sortByFloat = f64
+					}
+				}
+				vals[idx] = val
+			}
+		}
+	}
+
+	if !dontSort {
+		// pick a sorting strategy
+		if alpha {
+			if !desc {
+				// asc alpha
+				sort.Slice(vals, func(i, j int) bool {
+					return vals[i].sortByStr < vals[j].sortByStr
+				})
+			} else {
+				// desc alpha
+				sort.Slice(vals, func(i, j int) bool {
+					return vals[j].sortByStr < vals[i].sortByStr
+				})
+			}
+		} else {
+			if !desc {
+				// asc numeric
+				sort.Slice(vals, func(i, j int) bool {
+					return vals[i].sortByFloat < vals[j].sortByFloat
+				})
+			} else {
+				// desc numeric
+				sort.Slice(vals, func(i, j int) bool {
+					return vals[j].sortByFloat < vals[i].sortByFloat
+				})
+			}
+		}
+	}
+
+	if limit {
+		start := startAt
+		if start < 0 {
+			start = 0
+		}
+
+		if count < 0 {
+			count = len(vals)
+		}
+
+		end := start + count
+		if start >= len(vals) {
+			start = 0
+			end = 0
+		} else if end < start {
+			end = start
+		} else if end >= len(vals) {
 			end = len(vals)
 		}
-		vals = vals[start:end]
+		vals = vals[start:end]
+	}
+
+	// produce the output array
+	if len(getPatterns) == 0 {
+		getPatterns = []string{"#"}
+	}
+
+	a := make([]respValue, 0, len(vals)*len(getPatterns))
+	nilVal := respValue{}
+
+	for _, val := range vals {
+		for _, getPattern := range getPatterns {
+			if getPattern == "#" {
+				rv := respValue{data: respBulkString(val.data)}
+				a = append(a, rv)
+			} else {
+				// replace the first asterisk in the get pattern with the list item value
+				if !strings.Contains(getPattern, "*") {
+					a = append(a, nilVal)
+				} else {
+					pat := strings.Replace(getPattern, "*", val.data, 1)
+					sk, exists := dsc.getKeyUnlocked(pat)
+					if exists != VALUE_EXISTS {
+						a = append(a, nilVal)
+					} else {
+						str := respValue{data: respBulkString(sk)}
+						a = append(a, str)
+					}
+				}
+			}
+		}
+	}
+
+	if destKeyName != "" {
+		list := dsc.newListUnlocked(destKeyName)
+
+		for _, element := range a {
+			str, _ := element.toString()
+			dsc.rpushUnlocked(destKeyName, list, []byte(str))
+		}
+		dsc.notifyUnlocked(NOTIFY_LIST, "sortstore", destKeyName)
+
+		output.data = respInt(list.count)
+	} else {
+		output = nativeValueToResp(a)
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) getStreamUnlocked(keyName string) (s *stream, err *respErrorString) {
+	sk, exists := dsc.getKeyObjectUnlocked(keyName)
+
+	if !exists {
+		return
+	}
+
+	s = sk.getStream()
+	if s == nil {
+		err = &wrongTypeError
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) newStreamUnlocked(keyName string, s *stream) {
+	newSk := dsc.ds.newStoreKeyUnlocked(keyName)
+	newSk.flags = FLAG_KEY_TYPE_STREAM
+	newSk.expiresAt = maxTime
+	newSk.payload = s
+	dsc.setDirty()
+}
+
+// gets a stream and one of its consumer groups; s or g is nil if it doesn't exist
+func (dsc *dataStoreCommand) getStreamGroupUnlocked(keyName, groupName string) (s *stream, g *streamGroup, err *respErrorString) {
+	s, err = dsc.getStreamUnlocked(keyName)
+	if s != nil {
+		g = s.groups[groupName]
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) xadd(keyName string, idSpec streamAddId, noMkStream bool, fields []string, trim *streamTrimSpec) (output respValue) {
+	uk := unblockKey{keyName: keyName}
+
+	dsc.lock()
+	defer dsc.unlockAndUnblock(&uk)
+
+	s, err := dsc.getStreamUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	isNew := (s == nil)
+	if isNew {
+		if noMkStream {
+			return
+		}
+		s = newStream()
+	}
+
+	id, errText := s.newEntryId(idSpec, time.Now())
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	if isNew {
+		dsc.newStreamUnlocked(keyName, s)
+	}
+
+	s.add(id, fields)
+	dsc.setDirty()
+	dsc.notifyUnlocked(NOTIFY_STREAM, "xadd", keyName)
+	if trim != nil && s.trim(trim) > 0 {
+		dsc.notifyUnlocked(NOTIFY_STREAM, "xtrim", keyName)
+	}
+
+	// every reader can consume the new entry
+	uk.elements = unblockAllWaiters
+
+	output.data = respBulkString(id.String())
+	return
+}
+
+func (dsc *dataStoreCommand) xlen(keyName string) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, err := dsc.getStreamUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	if s == nil {
+		output.data = respInt(0)
+	} else {
+		output.data = respInt(s.length())
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) xrange(keyName string, start, end streamId, count int, reverse bool) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, err := dsc.getStreamUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	if s == nil {
+		output.data = respArray{}
+		return
+	}
+
+	output.data = streamEntriesToResp(s.selectRange(start, end, count, reverse))
+	return
+}
+
+func (dsc *dataStoreCommand) xdel(keyName string, ids []streamId) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, err := dsc.getStreamUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	deleted := 0
+	if s != nil {
+		deleted = s.delete(ids)
+		if deleted > 0 {
+			dsc.setDirty()
+			dsc.notifyUnlocked(NOTIFY_STREAM, "xdel", keyName)
+		}
+	}
+
+	output.data = respInt(deleted)
+	return
+}
+
+func (dsc *dataStoreCommand) xtrim(keyName string, trim *streamTrimSpec) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, err := dsc.getStreamUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	removed := 0
+	if s != nil {
+		removed = s.trim(trim)
+		if removed > 0 {
+			dsc.setDirty()
+			dsc.notifyUnlocked(NOTIFY_STREAM, "xtrim", keyName)
+		}
+	}
+
+	output.data = respInt(removed)
+	return
+}
+
+func (dsc *dataStoreCommand) xsetid(keyName string, lastId streamId, entriesAdded *int64, maxDeletedId *streamId) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, err := dsc.getStreamUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	if s == nil {
+		output.data = respErrorString("ERR no such key")
+		return
+	}
+
+	if s.length() > 0 && lastId.compare(s.lastId) < 0 {
+		output.data = respErrorString("ERR The ID specified in XSETID is smaller than the target stream top item")
+		return
+	}
+
+	if entriesAdded != nil && *entriesAdded < int64(s.length()) {
+		output.data = respErrorString("ERR The entries_added specified in XSETID is smaller than the target stream length")
+		return
+	}
+
+	s.lastId = lastId
+	if entriesAdded != nil {
+		s.entriesAdded = *entriesAdded
+	}
+	if maxDeletedId != nil {
+		s.maxDeletedId = *maxDeletedId
+	}
+	dsc.setDirty()
+	dsc.notifyUnlocked(NOTIFY_STREAM, "xsetid", keyName)
+
+	output.data = rstrOK
+	return
+}
+
+// resolves "$" in read requests to the last id of each stream
+func (dsc *dataStoreCommand) xreadResolve(reqs []streamReadRequest) (err *respErrorString) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	for i := range reqs {
+		if reqs[i].last {
+			var s *stream
+			s, err = dsc.getStreamUnlocked(reqs[i].keyName)
+			if err != nil {
+				return
+			}
+			if s != nil {
+				reqs[i].id = s.lastId
+			}
+			reqs[i].last = false
+		}
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) xread(reqs []streamReadRequest, count int) (results []streamReadResult, err *respErrorString) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	for _, req := range reqs {
+		var s *stream
+		s, err = dsc.getStreamUnlocked(req.keyName)
+		if err != nil {
+			return
+		}
+		if s == nil {
+			continue
+		}
+
+		entries := s.selectAfter(req.id, count)
+		if len(entries) > 0 {
+			results = append(results, streamReadResult{keyName: req.keyName, entries: streamEntriesToResp(entries)})
+		}
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) xreadgroup(groupName, consumerName string, reqs []streamReadRequest, count int, noAck bool) (results []streamReadResult, errText respErrorString) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	now := time.Now()
+
+	streams := make([]*stream, 0, len(reqs))
+	groups := make([]*streamGroup, 0, len(reqs))
+	for _, req := range reqs {
+		s, g, err := dsc.getStreamGroupUnlocked(req.keyName, groupName)
+		if err != nil {
+			errText = *err
+			return
+		}
+		if g == nil {
+			errText = respErrorString(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", req.keyName, groupName))
+			return
+		}
+		streams = append(streams, s)
+		groups = append(groups, g)
+	}
+
+	for i, req := range reqs {
+		s := streams[i]
+		g := groups[i]
+
+		c, created := g.consumer(consumerName, now)
+		c.seenTime = now
+		if created {
+			dsc.setDirty()
+			dsc.notifyUnlocked(NOTIFY_STREAM, "xgroup-createconsumer", req.keyName)
+		}
+
+		if req.newOnly {
+			entries := s.selectAfter(g.lastId, count)
+			if len(entries) == 0 {
+				continue
+			}
+
+			for _, entry := range entries {
+				s.advanceGroup(g, entry.id)
+				if !noAck {
+					g.deliver(entry.id, c, now)
+				}
+			}
+			c.activeTime = now
+			dsc.setDirty()
+
+			results = append(results, streamReadResult{keyName: req.keyName, entries: streamEntriesToResp(entries)})
+		} else {
+			// history of the consumer's pending entries
+			entries := respArray{}
+			start, ok := req.id.next()
+			if ok {
+				for index := g.searchPending(start); index < len(g.pending); index++ {
+					if count > 0 && len(entries) >= count {
+						break
+					}
+
+					pe := g.pending[index]
+					if pe.consumer != c {
+						continue
+					}
+
+					pe.deliveryTime = now
+					pe.deliveryCount++
+					dsc.setDirty()
+
+					entry := s.find(pe.id)
+					if entry != nil {
+						entries = append(entries, entry.toResp())
+					} else {
+						entries = append(entries, respValue{data: respArray{
+							respValue{data: respBulkString(pe.id.String())},
+							respValue{},
+						}})
+					}
+				}
+			}
+
+			results = append(results, streamReadResult{keyName: req.keyName, entries: entries})
+		}
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) xack(keyName, groupName string, ids []streamId) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	_, g, err := dsc.getStreamGroupUnlocked(keyName, groupName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	acked := 0
+	if g != nil {
+		for _, id := range ids {
+			if g.ack(id) {
+				acked++
+			}
+		}
+		if acked > 0 {
+			dsc.setDirty()
+		}
+	}
+
+	output.data = respInt(acked)
+	return
+}
+
+func noStreamGroupError(keyName, groupName string) respErrorString {
+	return respErrorString(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", keyName, groupName))
+}
+
+func (dsc *dataStoreCommand) xpending(keyName, groupName string, filter *streamPendingFilter) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	_, g, err := dsc.getStreamGroupUnlocked(keyName, groupName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+	if g == nil {
+		output.data = noStreamGroupError(keyName, groupName)
+		return
+	}
+
+	now := time.Now()
+
+	if filter == nil {
+		// summary form
+		if len(g.pending) == 0 {
+			output.data = respArray{respValue{data: respInt(0)}, respValue{}, respValue{}, respValue{}}
+			return
+		}
+
+		consumers := respArray{}
+		for _, c := range g.sortedConsumers() {
+			count := g.consumerPendingCount(c)
+			if count > 0 {
+				consumers = append(consumers, respValue{data: respArray{
+					respValue{data: respBulkString(c.name)},
+					respValue{data: respBulkString(strconv.Itoa(count))},
+				}})
+			}
+		}
+
+		output.data = respArray{
+			respValue{data: respInt(len(g.pending))},
+			respValue{data: respBulkString(g.pending[0].id.String())},
+			respValue{data: respBulkString(g.pending[len(g.pending)-1].id.String())},
+			respValue{data: consumers},
+		}
+		return
+	}
+
+	a := respArray{}
+	for index := g.searchPending(filter.start); index < len(g.pending) && len(a) < filter.count; index++ {
+		pe := g.pending[index]
+		if pe.id.compare(filter.end) > 0 {
+			break
+		}
+		if filter.consumer != "" && pe.consumer.name != filter.consumer {
+			continue
+		}
+		idle := now.Sub(pe.deliveryTime).Milliseconds()
+		if idle < filter.minIdle {
+			continue
+		}
+
+		a = append(a, respValue{data: respArray{
+			respValue{data: respBulkString(pe.id.String())},
+			respValue{data: respBulkString(pe.consumer.name)},
+			respValue{data: respInt(idle)},
+			respValue{data: respInt(pe.deliveryCount)},
+		}})
+	}
+
+	output.data = a
+	return
+}
+
+func (dsc *dataStoreCommand) xclaim(keyName, groupName, consumerName string, minIdle int64, ids []streamId, opts *streamClaimOptions) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, g, err := dsc.getStreamGroupUnlocked(keyName, groupName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+	if g == nil {
+		output.data = noStreamGroupError(keyName, groupName)
+		return
+	}
+
+	now := time.Now()
+	deliveryTime := now
+	if opts.deliveryTime != nil && opts.deliveryTime.Before(now) {
+		deliveryTime = *opts.deliveryTime
+	}
+
+	if opts.lastId != nil && opts.lastId.compare(g.lastId) > 0 {
+		g.lastId = *opts.lastId
+	}
+
+	c, created := g.consumer(consumerName, now)
+	if created {
+		dsc.notifyUnlocked(NOTIFY_STREAM, "xgroup-createconsumer", keyName)
+	}
+	c.seenTime = now
+	dsc.setDirty()
+
+	a := respArray{}
+	for _, id := range ids {
+		pe := g.findPending(id)
+
+		entry := s.find(id)
+		if entry == nil {
+			// the entry was deleted; it can't be claimed and no longer is pending
+			if pe != nil {
+				g.ack(id)
+			}
+			continue
+		}
+
+		if pe == nil {
+			if !opts.force {
+				continue
+			}
+			g.deliver(id, c, now)
+			pe = g.findPending(id)
+		} else if minIdle > 0 && now.Sub(pe.deliveryTime).Milliseconds() < minIdle {
+			continue
+		}
+
+		g.claim(pe, c, deliveryTime, opts.retryCount, opts.justId, now)
+
+		if opts.justId {
+			a = append(a, respValue{data: respBulkString(id.String())})
+		} else {
+			a = append(a, entry.toResp())
+		}
+	}
+
+	output.data = a
+	return
+}
+
+func (dsc *dataStoreCommand) xautoclaim(keyName, groupName, consumerName string, minIdle int64, start streamId, count int, justId bool) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, g, err := dsc.getStreamGroupUnlocked(keyName, groupName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+	if g == nil {
+		output.data = noStreamGroupError(keyName, groupName)
+		return
+	}
+
+	now := time.Now()
+	c, created := g.consumer(consumerName, now)
+	if created {
+		dsc.notifyUnlocked(NOTIFY_STREAM, "xgroup-createconsumer", keyName)
+	}
+	c.seenTime = now
+	dsc.setDirty()
+
+	claimed := respArray{}
+	deleted := respArray{}
+	attempts := count * 10
+
+	index := g.searchPending(start)
+	for index < len(g.pending) && attempts > 0 && count > 0 {
+		attempts--
+		pe := g.pending[index]
+
+		entry := s.find(pe.id)
+		if entry == nil {
+			// the entry was deleted; remove it from the PEL and report it
+			g.ack(pe.id)
+			deleted = append(deleted, respValue{data: respBulkString(pe.id.String())})
+			continue
+		}
+
+		index++
+		if minIdle > 0 && now.Sub(pe.deliveryTime).Milliseconds() < minIdle {
+			continue
+		}
+
+		g.claim(pe, c, now, -1, justId, now)
+		count--
+
+		if justId {
+			claimed = append(claimed, respValue{data: respBulkString(pe.id.String())})
+		} else {
+			claimed = append(claimed, entry.toResp())
+		}
+	}
+
+	cursor := streamIdMin
+	if index < len(g.pending) {
+		cursor = g.pending[index].id
+	}
+
+	output.data = respArray{
+		respValue{data: respBulkString(cursor.String())},
+		respValue{data: claimed},
+		respValue{data: deleted},
+	}
+	return
+}
+
+var errXGroupKeyMissing = respErrorString("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+
+// creates a consumer group; a nil id starts the group at the end of the stream
+func (dsc *dataStoreCommand) xgroupCreate(keyName, groupName string, id *streamId, mkStream bool, entriesRead int64) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, err := dsc.getStreamUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	if s == nil {
+		if !mkStream {
+			output.data = errXGroupKeyMissing
+			return
+		}
+		s = newStream()
+		dsc.newStreamUnlocked(keyName, s)
+	}
+
+	lastId := s.lastId
+	if id != nil {
+		lastId = *id
+	} else if entriesRead == streamEntriesReadInvalid {
+		entriesRead = s.entriesAdded
+	}
+
+	_, created := s.createGroup(groupName, lastId, entriesRead)
+	if !created {
+		output.data = respErrorString("BUSYGROUP Consumer Group name already exists")
+		return
+	}
+	dsc.setDirty()
+	dsc.notifyUnlocked(NOTIFY_STREAM, "xgroup-create", keyName)
+
+	output.data = rstrOK
+	return
+}
+
+func (dsc *dataStoreCommand) xgroupSetId(keyName, groupName string, id *streamId, entriesRead int64) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, g, err := dsc.getStreamGroupUnlocked(keyName, groupName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+	if s == nil {
+		output.data = errXGroupKeyMissing
+		return
+	}
+	if g == nil {
+		output.data = respErrorString(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", groupName, keyName))
+		return
+	}
+
+	if id != nil {
+		g.lastId = *id
+	} else {
+		g.lastId = s.lastId
+		if entriesRead == streamEntriesReadInvalid {
+			entriesRead = s.entriesAdded
+		}
+	}
+	g.entriesRead = entriesRead
+	dsc.setDirty()
+	dsc.notifyUnlocked(NOTIFY_STREAM, "xgroup-setid", keyName)
+
+	output.data = rstrOK
+	return
+}
+
+func (dsc *dataStoreCommand) xgroupDestroy(keyName, groupName string) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, g, err := dsc.getStreamGroupUnlocked(keyName, groupName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+	if s == nil {
+		output.data = errXGroupKeyMissing
+		return
+	}
+
+	if g == nil {
+		output.data = respInt(0)
+		return
+	}
+
+	delete(s.groups, groupName)
+	dsc.setDirty()
+	dsc.notifyUnlocked(NOTIFY_STREAM, "xgroup-destroy", keyName)
+	output.data = respInt(1)
+	return
+}
+
+func (dsc *dataStoreCommand) xgroupCreateConsumer(keyName, groupName, consumerName string) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, g, err := dsc.getStreamGroupUnlocked(keyName, groupName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+	if s == nil {
+		output.data = errXGroupKeyMissing
+		return
+	}
+	if g == nil {
+		output.data = respErrorString(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", groupName, keyName))
+		return
+	}
+
+	_, created := g.consumer(consumerName, time.Now())
+	if created {
+		dsc.setDirty()
+		dsc.notifyUnlocked(NOTIFY_STREAM, "xgroup-createconsumer", keyName)
+		output.data = respInt(1)
+	} else {
+		output.data = respInt(0)
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) xgroupDelConsumer(keyName, groupName, consumerName string) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, g, err := dsc.getStreamGroupUnlocked(keyName, groupName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+	if s == nil {
+		output.data = errXGroupKeyMissing
+		return
+	}
+	if g == nil {
+		output.data = respErrorString(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", groupName, keyName))
+		return
+	}
+
+	pending, exists := g.deleteConsumer(consumerName)
+	if exists {
+		dsc.setDirty()
+		dsc.notifyUnlocked(NOTIFY_STREAM, "xgroup-delconsumer", keyName)
+	}
+
+	output.data = respInt(pending)
+	return
+}
+
+func streamTimeToResp(t time.Time) respValue {
+	if t.IsZero() {
+		return respValue{data: respInt(-1)}
+	}
+	return respValue{data: respInt(t.UnixMilli())}
+}
+
+func streamEntryOrNil(entry *streamEntry) respValue {
+	if entry == nil {
+		return respValue{}
+	}
+	return entry.toResp()
+}
+
+func (dsc *dataStoreCommand) xinfoStream(keyName string, full bool, count int) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, err := dsc.getStreamUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+	if s == nil {
+		output.data = respErrorString("ERR no such key")
+		return
+	}
+
+	m := newRespMapSized(10)
+	field := func(name string, value respValue) {
+		setRespMapField(&m, name, value)
+	}
+
+	// the emulated radix tree has one key per node of entries
+	radixKeys := (s.length() + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+
+	field("length", respValue{data: respInt(s.length())})
+	field("radix-tree-keys", respValue{data: respInt(radixKeys)})
+	field("radix-tree-nodes", respValue{data: respInt(radixKeys + 1)})
+	field("last-generated-id", respValue{data: respBulkString(s.lastId.String())})
+	field("max-deleted-entry-id", respValue{data: respBulkString(s.maxDeletedId.String())})
+	field("entries-added", respValue{data: respInt(s.entriesAdded)})
+	field("recorded-first-entry-id", respValue{data: respBulkString(s.firstId().String())})
+
+	if !full {
+		field("groups", respValue{data: respInt(len(s.groups))})
+
+		var first, last *streamEntry
+		if s.length() > 0 {
+			first = s.entries[0]
+			last = s.entries[s.length()-1]
+		}
+		field("first-entry", streamEntryOrNil(first))
+		field("last-entry", streamEntryOrNil(last))
+
+		output.data = m
+		return
+	}
+
+	limited := func(n int) bool {
+		return count > 0 && n >= count
+	}
+
+	field("entries", respValue{data: streamEntriesToResp(s.selectRange(streamIdMin, streamIdMax, count, false))})
+
+	groups := respArray{}
+	for _, g := range s.sortedGroups() {
+		gm := newRespMapSized(7)
+		pending := respArray{}
+		for _, pe := range g.pending {
+			if limited(len(pending)) {
+				break
+			}
+			pending = append(pending, respValue{data: respArray{
+				respValue{data: respBulkString(pe.id.String())},
+				respValue{data: respBulkString(pe.consumer.name)},
+				respValue{data: respInt(pe.deliveryTime.UnixMilli())},
+				respValue{data: respInt(pe.deliveryCount)},
+			}})
+		}
+
+		consumers := respArray{}
+		for _, c := range g.sortedConsumers() {
+			cm := newRespMapSized(5)
+			consumerPending := respArray{}
+			for _, pe := range g.pending {
+				if pe.consumer != c {
+					continue
+				}
+				if limited(len(consumerPending)) {
+					break
+				}
+				consumerPending = append(consumerPending, respValue{data: respArray{
+					respValue{data: respBulkString(pe.id.String())},
+					respValue{data: respInt(pe.deliveryTime.UnixMilli())},
+					respValue{data: respInt(pe.deliveryCount)},
+				}})
+			}
+
+			setRespMapField(&cm, "name", respValue{data: respBulkString(c.name)})
+			setRespMapField(&cm, "seen-time", streamTimeToResp(c.seenTime))
+			setRespMapField(&cm, "active-time", streamTimeToResp(c.activeTime))
+			setRespMapField(&cm, "pel-count", respValue{data: respInt(g.consumerPendingCount(c))})
+			setRespMapField(&cm, "pending", respValue{data: consumerPending})
+			consumers = append(consumers, respValue{data: cm})
+		}
+
+		setRespMapField(&gm, "name", respValue{data: respBulkString(g.name)})
+		setRespMapField(&gm, "last-delivered-id", respValue{data: respBulkString(g.lastId.String())})
+		setRespMapField(&gm, "entries-read", streamEntriesReadToResp(g.entriesRead))
+		setRespMapField(&gm, "lag", streamLagToResp(s, g))
+		setRespMapField(&gm, "pel-count", respValue{data: respInt(len(g.pending))})
+		setRespMapField(&gm, "pending", respValue{data: pending})
+		setRespMapField(&gm, "consumers", respValue{data: consumers})
+		groups = append(groups, respValue{data: gm})
+	}
+	field("groups", respValue{data: groups})
+
+	output.data = m
+	return
+}
+
+func streamEntriesReadToResp(entriesRead int64) respValue {
+	if entriesRead == streamEntriesReadInvalid {
+		return respValue{}
+	}
+	return respValue{data: respInt(entriesRead)}
+}
+
+func streamLagToResp(s *stream, g *streamGroup) respValue {
+	lag, valid := s.lag(g)
+	if !valid {
+		return respValue{}
+	}
+	return respValue{data: respInt(lag)}
+}
+
+func (dsc *dataStoreCommand) xinfoGroups(keyName string) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, err := dsc.getStreamUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+	if s == nil {
+		output.data = respErrorString("ERR no such key")
+		return
+	}
+
+	groups := respArray{}
+	for _, g := range s.sortedGroups() {
+		m := newRespMapSized(6)
+		setRespMapField(&m, "name", respValue{data: respBulkString(g.name)})
+		setRespMapField(&m, "consumers", respValue{data: respInt(len(g.consumers))})
+		setRespMapField(&m, "pending", respValue{data: respInt(len(g.pending))})
+		setRespMapField(&m, "last-delivered-id", respValue{data: respBulkString(g.lastId.String())})
+		setRespMapField(&m, "entries-read", streamEntriesReadToResp(g.entriesRead))
+		setRespMapField(&m, "lag", streamLagToResp(s, g))
+		groups = append(groups, respValue{data: m})
+	}
+
+	output.data = groups
+	return
+}
+
+func (dsc *dataStoreCommand) xinfoConsumers(keyName, groupName string) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	s, g, err := dsc.getStreamGroupUnlocked(keyName, groupName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+	if s == nil {
+		output.data = respErrorString("ERR no such key")
+		return
+	}
+	if g == nil {
+		output.data = respErrorString(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", groupName, keyName))
+		return
+	}
+
+	now := time.Now()
+
+	consumers := respArray{}
+	for _, c := range g.sortedConsumers() {
+		inactive := int64(-1)
+		if !c.activeTime.IsZero() {
+			inactive = now.Sub(c.activeTime).Milliseconds()
+		}
+
+		m := newRespMapSized(4)
+		setRespMapField(&m, "name", respValue{data: respBulkString(c.name)})
+		setRespMapField(&m, "pending", respValue{data: respInt(g.consumerPendingCount(c))})
+		setRespMapField(&m, "idle", respValue{data: respInt(now.Sub(c.seenTime).Milliseconds())})
+		setRespMapField(&m, "inactive", respValue{data: respInt(inactive)})
+		consumers = append(consumers, respValue{data: m})
+	}
+
+	output.data = consumers
+	return
+}
+
+var errNotHyperLogLog = respErrorString("WRONGTYPE Key is not a valid HyperLogLog string value.")
+var errInvalidHyperLogLog = respErrorString("INVALIDOBJ Corrupted HLL object detected")
+
+// gets a private copy of a HyperLogLog value; h is nil if the key doesn't exist
+func (dsc *dataStoreCommand) getHyperLogLogUnlocked(keyName string) (h *hyperLogLog, sk *storeKey, err *respErrorString) {
+	sk, exists := dsc.getKeyObjectUnlocked(keyName)
+	if !exists {
+		return
+	}
+
+	strBytes := sk.getStringBytes()
+	if strBytes == nil {
+		err = &wrongTypeError
+		return
+	}
+
+	copied := make([]byte, len(strBytes))
+	copy(copied, strBytes)
+
+	var valid bool
+	if h, valid = asHyperLogLog(copied); !valid {
+		err = &errNotHyperLogLog
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) storeHyperLogLogUnlocked(keyName string, h *hyperLogLog, expiration time.Time) {
+	newSk := dsc.ds.newStoreKeyUnlocked(keyName)
+	newSk.flags = FLAG_KEY_TYPE_STRING
+	newSk.expiresAt = expiration
+	newSk.payload = h.b
+	dsc.notifyUnlocked(NOTIFY_STRING, "pfadd", keyName)
+}
+
+func (dsc *dataStoreCommand) pfadd(keyName string, elements []string) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	h, sk, err := dsc.getHyperLogLogUnlocked(keyName)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	// creating the key counts as an update
+	updated := false
+	expiration := maxTime
+	if h == nil {
+		h = newHyperLogLog()
+		updated = true
+	} else {
+		expiration = sk.expiresAt
+	}
+
+	for _, element := range elements {
+		changed, valid := h.add([]byte(element))
+		if !valid {
+			output.data = errInvalidHyperLogLog
+			return
+		}
+		updated = updated || changed
+	}
+
+	if updated {
+		h.invalidateCache()
+		dsc.storeHyperLogLogUnlocked(keyName, h, expiration)
+		output.data = respInt(1)
+	} else {
+		output.data = respInt(0)
+	}
+	return
+}
+
+func (dsc *dataStoreCommand) pfcount(keyNames []string) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	if len(keyNames) > 1 {
+		// estimate the union without modifying any of the keys
+		max := make([]uint8, hllRegisters)
+		for _, keyName := range keyNames {
+			h, _, err := dsc.getHyperLogLogUnlocked(keyName)
+			if err != nil {
+				output.data = *err
+				return
+			}
+			if h != nil && !h.mergeInto(max) {
+				output.data = errInvalidHyperLogLog
+				return
+			}
+		}
+
+		output.data = respInt(hllCountRegisters(max))
+		return
+	}
+
+	h, sk, err := dsc.getHyperLogLogUnlocked(keyNames[0])
+	if err != nil {
+		output.data = *err
+		return
+	}
+	if h == nil {
+		output.data = respInt(0)
+		return
+	}
+
+	card, valid := h.cachedCardinality()
+	if !valid {
+		if card, valid = h.count(); !valid {
+			output.data = errInvalidHyperLogLog
+			return
+		}
+
+		// the cache is part of the value; update it in place
+		h.setCachedCardinality(card)
+		sk.payload = h.b
+		dsc.setDirty()
+	}
+
+	output.data = respInt(card)
+	return
+}
+
+func (dsc *dataStoreCommand) pfmerge(destKeyName string, srcKeyNames []string) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	// the destination is one of the sources
+	max := make([]uint8, hllRegisters)
+	useDense := false
+	var dest *hyperLogLog
+	expiration := maxTime
+	for i, keyName := range append([]string{destKeyName}, srcKeyNames...) {
+		h, sk, err := dsc.getHyperLogLogUnlocked(keyName)
+		if err != nil {
+			output.data = *err
+			return
+		}
+		if h == nil {
+			continue
+		}
+		if i == 0 {
+			dest = h
+			expiration = sk.expiresAt
+		}
+		if h.isDense() {
+			useDense = true
+		}
+		if !h.mergeInto(max) {
+			output.data = errInvalidHyperLogLog
+			return
+		}
+	}
+
+	if dest == nil {
+		dest = newHyperLogLog()
+	}
+	if useDense && !dest.toDense() {
+		output.data = errInvalidHyperLogLog
+		return
+	}
+	if !dest.setRegisters(max) {
+		output.data = errInvalidHyperLogLog
+		return
+	}
+
+	dest.invalidateCache()
+	dsc.storeHyperLogLogUnlocked(destKeyName, dest, expiration)
+
+	output.data = rstrOK
+	return
+}
+
+// runs a geo search against the sorted set at the key; a missing key finds nothing
+func (dsc *dataStoreCommand) geoSearchUnlocked(keyName string, spec *geoSearchSpec) (points []geoPoint, err *respErrorString) {
+	z, err := dsc.getZSetUnlocked(keyName)
+	if err != nil || z == nil {
+		return
+	}
+
+	shape := spec.shape
+	if spec.fromMember != nil {
+		score, exists := z.score(*spec.fromMember)
+		if !exists {
+			err = &errGeoMemberNotFound
+			return
+		}
+		shape.longitude, shape.latitude = geoScoreToLongLat(score)
 	}
 
-	// produce the output array
-	if len(getPatterns) == 0 {
-		getPatterns = []string{"#"}
+	limit := 0
+	if spec.any {
+		limit = spec.count
 	}
 
-	a := make([]respValue, 0, len(vals)*len(getPatterns))
-	nilVal := respValue{}
+	points = spec.arrange(geoSearchZSet(z, &shape, limit))
+	return
+}
 
-	for _, val := range vals {
-		for _, getPattern := range getPatterns {
-			if getPattern == "#" {
-				rv := respValue{data: respBulkString(val.data)}
-				a = append(a, rv)
-			} else {
-				// replace the first asterisk in the get pattern with the list item value
-				if !strings.Contains(getPattern, "*") {
-					a = append(a, nilVal)
-				} else {
-					pat := strings.Replace(getPattern, "*", val.data, 1)
-					sk, exists := dsc.getKeyUnlocked(pat)
-					if exists != VALUE_EXISTS {
-						a = append(a, nilVal)
-					} else {
-						str := respValue{data: respBulkString(sk)}
-						a = append(a, str)
-					}
-				}
+func (dsc *dataStoreCommand) geosearch(keyName string, spec *geoSearchSpec) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	points, err := dsc.geoSearchUnlocked(keyName, spec)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	output = spec.pointsToResp(points)
+	return
+}
+
+func (dsc *dataStoreCommand) geosearchStore(destKeyName, srcKeyName, event string, spec *geoSearchSpec, storeDist bool) (output respValue) {
+	uk := unblockKey{keyName: destKeyName}
+
+	dsc.lock()
+	defer dsc.unlockAndUnblock(&uk)
+
+	points, err := dsc.geoSearchUnlocked(srcKeyName, spec)
+	if err != nil {
+		output.data = *err
+		return
+	}
+
+	result := newZSet()
+	for _, point := range points {
+		if storeDist {
+			result.set(point.member, point.distance/spec.shape.conversion)
+		} else {
+			result.set(point.member, point.score)
+		}
+	}
+
+	output.data = respInt(dsc.zstoreUnlocked(destKeyName, result, event))
+	uk.elements = result.count()
+	return
+}
+
+func (dsc *dataStoreCommand) hexpire(keyName string, fieldNames []string, expiration time.Time, nx, xx, gt, lt bool) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	results := make(respArray, 0, len(fieldNames))
+
+	sk, exists := dsc.getKeyObjectUnlocked(keyName)
+	if !exists {
+		for range fieldNames {
+			results = append(results, respValue{data: respInt(-2)})
+		}
+		output.data = results
+		return
+	}
+
+	m := sk.getHashTable()
+	if m == nil {
+		output.data = wrongTypeError
+		return
+	}
+
+	// an expiration that isn't in the future deletes the field
+	deleteNow := !expiration.After(time.Now())
+
+	updated := false
+	for _, fieldName := range fieldNames {
+		if _, exists := m.get(fieldName); !exists {
+			results = append(results, respValue{data: respInt(-2)})
+			continue
+		}
+
+		current, hasTtl := sk.fieldExpiresAt[fieldName]
+		if !hasTtl {
+			current = maxTime
+		}
+
+		if (nx && hasTtl) || (xx && !hasTtl) || (gt && !expiration.After(current)) || (lt && !expiration.Before(current)) {
+			results = append(results, respValue{data: respInt(0)})
+			continue
+		}
+
+		if deleteNow {
+			m.remove(fieldName)
+			sk.clearFieldExpiration(fieldName)
+			results = append(results, respValue{data: respInt(2)})
+		} else {
+			sk.setFieldExpiration(fieldName, expiration)
+			results = append(results, respValue{data: respInt(1)})
+		}
+		dsc.setDirty()
+		updated = true
+	}
+
+	if m.count == 0 {
+		dsc.ds.removeKeyUnlocked(keyName)
+	}
+
+	if updated {
+		if deleteNow {
+			dsc.notifyRemovalUnlocked(NOTIFY_HASH, "hexpired", keyName, m.count)
+		} else {
+			dsc.notifyUnlocked(NOTIFY_HASH, "hexpire", keyName)
+		}
+	}
+
+	output.data = results
+	return
+}
+
+// converts the expiration of each hash field, or replies -2 for a missing field and -1 for a field without a TTL
+func (dsc *dataStoreCommand) hexpireTime(keyName string, fieldNames []string, convert func(expiration time.Time) int64) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	results := make(respArray, 0, len(fieldNames))
+
+	sk, exists := dsc.getKeyObjectUnlocked(keyName)
+	if !exists {
+		for range fieldNames {
+			results = append(results, respValue{data: respInt(-2)})
+		}
+		output.data = results
+		return
+	}
+
+	m := sk.getHashTable()
+	if m == nil {
+		output.data = wrongTypeError
+		return
+	}
+
+	for _, fieldName := range fieldNames {
+		if _, exists := m.get(fieldName); !exists {
+			results = append(results, respValue{data: respInt(-2)})
+		} else if expiration, hasTtl := sk.fieldExpiresAt[fieldName]; !hasTtl {
+			results = append(results, respValue{data: respInt(-1)})
+		} else {
+			results = append(results, respValue{data: respInt(convert(expiration))})
+		}
+	}
+
+	output.data = results
+	return
+}
+
+func (dsc *dataStoreCommand) hpersist(keyName string, fieldNames []string) (output respValue) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	results := make(respArray, 0, len(fieldNames))
+
+	sk, exists := dsc.getKeyObjectUnlocked(keyName)
+	if !exists {
+		for range fieldNames {
+			results = append(results, respValue{data: respInt(-2)})
+		}
+		output.data = results
+		return
+	}
+
+	m := sk.getHashTable()
+	if m == nil {
+		output.data = wrongTypeError
+		return
+	}
+
+	persisted := false
+	for _, fieldName := range fieldNames {
+		if _, exists := m.get(fieldName); !exists {
+			results = append(results, respValue{data: respInt(-2)})
+		} else if !sk.clearFieldExpiration(fieldName) {
+			results = append(results, respValue{data: respInt(-1)})
+		} else {
+			results = append(results, respValue{data: respInt(1)})
+			dsc.setDirty()
+			persisted = true
+		}
+	}
+
+	if persisted {
+		dsc.notifyUnlocked(NOTIFY_HASH, "hpersist", keyName)
+	}
+
+	output.data = results
+	return
+}
+
+func (dsc *dataStoreCommand) hgetdel(keyName string, fieldNames []string) (vals []*string, wrongType bool) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	sk, exists := dsc.getKeyObjectUnlocked(keyName)
+	if !exists {
+		vals = make([]*string, len(fieldNames))
+		return
+	}
+
+	m := sk.getHashTable()
+	if m == nil {
+		wrongType = true
+		return
+	}
+
+	deleted := false
+	vals = make([]*string, 0, len(fieldNames))
+	for _, fieldName := range fieldNames {
+		var val *string
+		if dictVal, exists := m.get(fieldName); exists {
+			str := dictVal.(string)
+			val = &str
+			m.remove(fieldName)
+			sk.clearFieldExpiration(fieldName)
+			dsc.setDirty()
+			deleted = true
+		}
+		vals = append(vals, val)
+	}
+
+	if m.count == 0 {
+		dsc.ds.removeKeyUnlocked(keyName)
+	}
+
+	if deleted {
+		dsc.notifyRemovalUnlocked(NOTIFY_HASH, "hdel", keyName, m.count)
+	}
+	return
+}
+
+// reads hash fields, and when setTtl or persist is specified, changes the TTL of the fields that exist
+func (dsc *dataStoreCommand) hgetex(keyName string, fieldNames []string, expiration time.Time, setTtl, persist bool) (vals []*string, wrongType bool) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	sk, exists := dsc.getKeyObjectUnlocked(keyName)
+	if !exists {
+		vals = make([]*string, len(fieldNames))
+		return
+	}
+
+	m := sk.getHashTable()
+	if m == nil {
+		wrongType = true
+		return
+	}
+
+	// an expiration that isn't in the future deletes the field after it is read
+	deleteNow := setTtl && !expiration.After(time.Now())
+
+	updated := false
+	vals = make([]*string, 0, len(fieldNames))
+	for _, fieldName := range fieldNames {
+		dictVal, exists := m.get(fieldName)
+		if !exists {
+			vals = append(vals, nil)
+			continue
+		}
+
+		str := dictVal.(string)
+		vals = append(vals, &str)
+
+		if deleteNow {
+			m.remove(fieldName)
+			sk.clearFieldExpiration(fieldName)
+			dsc.setDirty()
+			updated = true
+		} else if setTtl {
+			sk.setFieldExpiration(fieldName, expiration)
+			dsc.setDirty()
+			updated = true
+		} else if persist && sk.clearFieldExpiration(fieldName) {
+			dsc.setDirty()
+			updated = true
+		}
+	}
+
+	if m.count == 0 {
+		dsc.ds.removeKeyUnlocked(keyName)
+	}
+
+	if updated {
+		if deleteNow {
+			dsc.notifyRemovalUnlocked(NOTIFY_HASH, "hexpired", keyName, m.count)
+		} else if setTtl {
+			dsc.notifyUnlocked(NOTIFY_HASH, "hexpire", keyName)
+		} else {
+			dsc.notifyUnlocked(NOTIFY_HASH, "hpersist", keyName)
+		}
+	}
+	return
+}
+
+// sets all of the hash fields or none of them, according to the SET_NOT_EXIST and SET_EXISTS
+// conditions; the fields get the expiration unless SET_KEEP_TTL is specified
+func (dsc *dataStoreCommand) hsetex(keyName string, fieldNames, values []string, options bitflags, expiration time.Time) (set bool, wrongType bool) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	sk, exists := dsc.getKeyObjectUnlocked(keyName)
+
+	var m *redisDict
+	if exists {
+		m = sk.getHashTable()
+		if m == nil {
+			wrongType = true
+			return
+		}
+	} else if flagHasOne(options, SET_EXISTS) {
+		return
+	}
+
+	if m != nil {
+		for _, fieldName := range fieldNames {
+			_, fieldExists := m.get(fieldName)
+			if fieldExists && flagHasOne(options, SET_NOT_EXIST) {
+				return
+			}
+			if !fieldExists && flagHasOne(options, SET_EXISTS) {
+				return
 			}
 		}
+	} else {
+		// new key
+		m = newRedisDict()
+
+		sk = dsc.ds.newStoreKeyUnlocked(keyName)
+		sk.flags = FLAG_KEY_TYPE_HASH_TABLE
+		sk.payload = m
+		sk.expiresAt = maxTime
 	}
 
-	if destKeyName != "" {
-		list := dsc.newListUnlocked(destKeyName)
+	deleteNow := expiration != maxTime && !expiration.After(time.Now())
 
-		for _, element := range a {
-			str, _ := element.toString()
-			dsc.rpushUnlocked(destKeyName, list, []byte(str))
+	for idx, fieldName := range fieldNames {
+		if deleteNow {
+			m.remove(fieldName)
+			sk.clearFieldExpiration(fieldName)
+		} else {
+			m.store(fieldName, values[idx])
+			if expiration != maxTime {
+				sk.setFieldExpiration(fieldName, expiration)
+			} else if !flagHasOne(options, SET_KEEP_TTL) {
+				sk.clearFieldExpiration(fieldName)
+			}
 		}
+	}
+	dsc.setDirty()
+	set = true
 
-		output.data = respInt(list.count)
+	if m.count == 0 {
+		dsc.ds.removeKeyUnlocked(keyName)
+	}
+
+	if deleteNow {
+		dsc.notifyRemovalUnlocked(NOTIFY_HASH, "hexpired", keyName, m.count)
 	} else {
-		output = nativeValueToResp(a)
+		dsc.notifyUnlocked(NOTIFY_HASH, "hset", keyName)
+		if expiration != maxTime {
+			dsc.notifyUnlocked(NOTIFY_HASH, "hexpire", keyName)
+		}
 	}
 	return
 }
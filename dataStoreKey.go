@@ -12,15 +12,23 @@ const (
 	FLAG_KEY_TYPE_HASH_TABLE
 	FLAG_KEY_TYPE_SET
 	FLAG_KEY_TYPE_LIST
+	FLAG_KEY_TYPE_ZSET
+	FLAG_KEY_TYPE_STREAM
 )
 
 type (
 	storeKey struct {
-		id         uint64
-		flags      bitflags
-		lastAccess time.Time
-		expiresAt  time.Time
-		payload    any
+		id             uint64
+		flags          bitflags
+		lastAccess     time.Time
+		expiresAt      time.Time
+		payload        any
+		fieldExpiresAt map[string]time.Time // hash fields that have a TTL
+		encoding       keyEncoding          // the encoding Redis would use, see keyEncoding.go
+		stringMode     keyStringMode
+		lfuCounter     uint8 // the logarithmic access counter of OBJECT FREQ
+		lfuDecayedAt   time.Time
+		memSize        int64 // the MEMORY USAGE estimate, see memoryUsage.go
 	}
 
 	storeList struct {
@@ -62,30 +70,37 @@ func (sk *storeKey) clone(newId uint64) *storeKey {
 			}
 			payload = &newSl
 		} else if flagHasOne(sk.flags, FLAG_KEY_TYPE_HASH_TABLE) {
-			m := sk.payload.(map[string]string)
-			newMap := make(map[string]string, len(m))
-			for k, v := range m {
-				newMap[k] = v
-			}
-			payload = newMap
+			payload = newRedisDictFromStringTable(sk.payload.(*redisDict).toStringTable())
 		} else if flagHasOne(sk.flags, FLAG_KEY_TYPE_SET) {
-			m := sk.payload.(map[string]struct{})
-			newMap := make(map[string]struct{}, len(m))
-			for k := range m {
-				newMap[k] = struct{}{}
-			}
-			payload = newMap
+			payload = newRedisDictFromKeyTable(sk.payload.(*redisDict).toKeyTable())
+		} else if flagHasOne(sk.flags, FLAG_KEY_TYPE_ZSET) {
+			payload = sk.payload.(*zset).clone()
+		} else if flagHasOne(sk.flags, FLAG_KEY_TYPE_STREAM) {
+			payload = sk.payload.(*stream).clone()
 		} else {
 			panic("unexpected payload type")
 		}
 	}
 
+	var fieldExpiresAt map[string]time.Time
+	if sk.fieldExpiresAt != nil {
+		fieldExpiresAt = make(map[string]time.Time, len(sk.fieldExpiresAt))
+		for fieldName, expiresAt := range sk.fieldExpiresAt {
+			fieldExpiresAt[fieldName] = expiresAt
+		}
+	}
+
 	return &storeKey{
-		id:         newId,
-		flags:      sk.flags,
-		lastAccess: sk.lastAccess,
-		expiresAt:  sk.expiresAt,
-		payload:    payload,
+		id:             newId,
+		flags:          sk.flags,
+		lastAccess:     sk.lastAccess,
+		expiresAt:      sk.expiresAt,
+		payload:        payload,
+		fieldExpiresAt: fieldExpiresAt,
+		encoding:       sk.encoding,
+		stringMode:     sk.stringMode,
+		lfuCounter:     sk.lfuCounter,
+		lfuDecayedAt:   sk.lfuDecayedAt,
 	}
 }
 
@@ -121,6 +136,42 @@ func (sk *storeKey) getSet() *redisDict {
 	}
 }
 
+func (sk *storeKey) getZSet() *zset {
+	if flagHasOne(sk.flags, FLAG_KEY_TYPE_ZSET) {
+		return sk.payload.(*zset)
+	} else {
+		return nil
+	}
+}
+
+func (sk *storeKey) getStream() *stream {
+	if flagHasOne(sk.flags, FLAG_KEY_TYPE_STREAM) {
+		return sk.payload.(*stream)
+	} else {
+		return nil
+	}
+}
+
+func (sk *storeKey) setFieldExpiration(fieldName string, expiresAt time.Time) {
+	if sk.fieldExpiresAt == nil {
+		sk.fieldExpiresAt = map[string]time.Time{}
+	}
+	sk.fieldExpiresAt[fieldName] = expiresAt
+}
+
+// removes the TTL of a hash field, returning false if it didn't have one
+func (sk *storeKey) clearFieldExpiration(fieldName string) bool {
+	if _, exists := sk.fieldExpiresAt[fieldName]; !exists {
+		return false
+	}
+
+	delete(sk.fieldExpiresAt, fieldName)
+	if len(sk.fieldExpiresAt) == 0 {
+		sk.fieldExpiresAt = nil
+	}
+	return true
+}
+
 func storeKeyTypeFlag(keyType string) bitflags {
 	switch strings.ToLower(keyType) {
 	case "string":
@@ -131,6 +182,10 @@ func storeKeyTypeFlag(keyType string) bitflags {
 		return FLAG_KEY_TYPE_SET
 	case "list":
 		return FLAG_KEY_TYPE_LIST
+	case "zset":
+		return FLAG_KEY_TYPE_ZSET
+	case "stream":
+		return FLAG_KEY_TYPE_STREAM
 	}
 
 	return 0
@@ -146,6 +201,10 @@ func storeKeyType(keyTypeFlag bitflags) string {
 		return "set"
 	case FLAG_KEY_TYPE_LIST:
 		return "list"
+	case FLAG_KEY_TYPE_ZSET:
+		return "zset"
+	case FLAG_KEY_TYPE_STREAM:
+		return "stream"
 	}
 
 	return "none"
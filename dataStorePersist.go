@@ -1,8 +1,12 @@
 package redisemu
 
 import (
+	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"time"
 )
@@ -16,14 +20,17 @@ type (
 	}
 
 	persistKeyHeader struct {
-		Key        string
-		Id         uint64
-		Flags      bitflags
-		LastAccess time.Time
-		ExpiresAt  time.Time
+		Key            string
+		Id             uint64
+		Flags          bitflags
+		LastAccess     time.Time
+		ExpiresAt      time.Time
+		FieldExpiresAt map[string]time.Time
 	}
 )
 
+var errPersistType = errors.New("invalid value type")
+
 func (ds *dataStore) save(fileName string) (err error) {
 	// open output file
 	f, err := os.Create(fileName)
@@ -38,7 +45,12 @@ func (ds *dataStore) save(fileName string) (err error) {
 		}
 	}()
 
-	enc := gob.NewEncoder(f)
+	return ds.write(f)
+}
+
+// writes the keys in the persistence format
+func (ds *dataStore) write(w io.Writer) (err error) {
+	enc := gob.NewEncoder(w)
 
 	// write the header
 	ph := persistHeader{
@@ -64,43 +76,104 @@ func (ds *dataStore) save(fileName string) (err error) {
 
 		// copy only persistable members
 		pkh := persistKeyHeader{
-			Key:        item.key,
-			Id:         sk.id,
-			Flags:      sk.flags,
-			LastAccess: sk.lastAccess,
-			ExpiresAt:  sk.expiresAt,
+			Key:            item.key,
+			Id:             sk.id,
+			Flags:          sk.flags,
+			LastAccess:     sk.lastAccess,
+			ExpiresAt:      sk.expiresAt,
+			FieldExpiresAt: sk.fieldExpiresAt,
 		}
 
 		if err = enc.Encode(pkh); err != nil {
 			return
 		}
 
-		if flagHasOne(sk.flags, FLAG_KEY_TYPE_STRING) {
-			err = enc.Encode(sk.payload.([]byte))
-		} else if flagHasOne(sk.flags, FLAG_KEY_TYPE_HASH_TABLE) {
-			table := sk.payload.(*redisDict)
-			err = enc.Encode(table.toStringTable())
-		} else if flagHasOne(sk.flags, FLAG_KEY_TYPE_SET) {
-			table := sk.payload.(*redisDict)
-			err = enc.Encode(table.toKeyTable())
-		} else if flagHasOne(sk.flags, FLAG_KEY_TYPE_LIST) {
-			// can't serialize pointers; extract the raw data
-			list := sk.payload.(*storeList)
-			payload := make([][]byte, 0, list.count)
-			for p := list.head; p != nil; p = p.next {
-				payload = append(payload, p.element)
-			}
-
-			err = enc.Encode(payload)
-		} else {
-			panic("should be unreachable")
+		if err = enc.Encode(persistPayload(sk)); err != nil {
+			return
 		}
+	}
 
-		if err != nil {
-			return
+	return
+}
+
+// provides the persistable form of a key's value
+func persistPayload(sk *storeKey) any {
+	if flagHasOne(sk.flags, FLAG_KEY_TYPE_STRING) {
+		return sk.payload.([]byte)
+	} else if flagHasOne(sk.flags, FLAG_KEY_TYPE_HASH_TABLE) {
+		table := sk.payload.(*redisDict)
+		return table.toStringTable()
+	} else if flagHasOne(sk.flags, FLAG_KEY_TYPE_SET) {
+		table := sk.payload.(*redisDict)
+		return table.toKeyTable()
+	} else if flagHasOne(sk.flags, FLAG_KEY_TYPE_LIST) {
+		// can't serialize pointers; extract the raw data
+		list := sk.payload.(*storeList)
+		payload := make([][]byte, 0, list.count)
+		for p := list.head; p != nil; p = p.next {
+			payload = append(payload, p.element)
 		}
+		return payload
+	} else if flagHasOne(sk.flags, FLAG_KEY_TYPE_ZSET) {
+		z := sk.payload.(*zset)
+		return z.toScoreTable()
+	} else if flagHasOne(sk.flags, FLAG_KEY_TYPE_STREAM) {
+		s := sk.payload.(*stream)
+		return s.toPersist()
 	}
+	panic("should be unreachable")
+}
+
+// decodes a key's value from its persistable form, see persistPayload
+func readPersistPayload(dec *gob.Decoder, flags bitflags) (payload any, err error) {
+	if flagHasOne(flags, FLAG_KEY_TYPE_STRING) {
+		var str []byte
+		err = dec.Decode(&str)
+		payload = str
+	} else if flagHasOne(flags, FLAG_KEY_TYPE_HASH_TABLE) {
+		var table map[string]string
+		err = dec.Decode(&table)
+		payload = newRedisDictFromStringTable(table)
+	} else if flagHasOne(flags, FLAG_KEY_TYPE_SET) {
+		var table map[string]struct{}
+		err = dec.Decode(&table)
+		payload = newRedisDictFromKeyTable(table)
+	} else if flagHasOne(flags, FLAG_KEY_TYPE_LIST) {
+		var rawList [][]byte
+		err = dec.Decode(&rawList)
+		if err == nil {
+			// make a linked list from raw data
+			list := &storeList{}
 
+			for _, element := range rawList {
+				item := &listItem{
+					prev:    list.tail,
+					element: element,
+				}
+				if list.head == nil {
+					list.head = item
+				} else {
+					list.tail.next = item
+				}
+				list.tail = item
+			}
+
+			list.count = len(rawList)
+			payload = list
+		}
+	} else if flagHasOne(flags, FLAG_KEY_TYPE_ZSET) {
+		var table map[string]float64
+		err = dec.Decode(&table)
+		payload = newZSetFromScoreTable(table)
+	} else if flagHasOne(flags, FLAG_KEY_TYPE_STREAM) {
+		var ps persistStream
+		err = dec.Decode(&ps)
+		if err == nil {
+			payload, err = newStreamFromPersist(&ps)
+		}
+	} else {
+		err = errPersistType
+	}
 	return
 }
 
@@ -118,7 +191,75 @@ func (ds *dataStore) load(fileName string) (err error) {
 		}
 	}()
 
-	dec := gob.NewDecoder(f)
+	data, err := ds.read(f)
+	if err != nil {
+		return
+	}
+
+	ds.data = data
+	ds.accountAllKeysUnlocked()
+	return
+}
+
+// saves the keys and loads them back, as DEBUG RELOAD does; without a file
+// name, the keys round trip through memory, and are always saved
+func (ds *dataStore) reloadUnlocked(fileName string, save, flush, merge bool) (err error) {
+	var data *redisDict
+	if fileName == "" {
+		var buf bytes.Buffer
+		if err = ds.write(&buf); err != nil {
+			return
+		}
+		if data, err = ds.read(&buf); err != nil {
+			return
+		}
+	} else {
+		if save {
+			if err = ds.save(fileName); err != nil {
+				return
+			}
+			ds.data.dirty = false
+		}
+
+		// a data store that was never saved has no keys to load
+		f, openErr := os.Open(fileName)
+		if errors.Is(openErr, fs.ErrNotExist) {
+			data = newRedisDict()
+		} else if openErr != nil {
+			return openErr
+		} else {
+			data, err = ds.read(f)
+			f.Close()
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	if flush {
+		ds.data = data
+		ds.cursors = make(map[int64]*storeKey, 2)
+		ds.cursorsSize = 2
+		ds.accountAllKeysUnlocked()
+		return
+	}
+
+	// the loaded keys are added to the current ones; a duplicate is an error
+	// unless they are merged
+	for iter := data.createIterator(); iter.next(); {
+		if _, exists := ds.data.get(iter.key); exists && !merge {
+			return fmt.Errorf("duplicate key %s in db %d", iter.key, ds.index)
+		}
+	}
+	for iter := data.createIterator(); iter.next(); {
+		ds.storeKeyUnlocked(iter.key, iter.value.(*storeKey))
+	}
+	return
+}
+
+// reads keys in the persistence format into a new dictionary
+func (ds *dataStore) read(r io.Reader) (data *redisDict, err error) {
+	dec := gob.NewDecoder(r)
 
 	// read the header
 	var ph persistHeader
@@ -131,7 +272,7 @@ func (ds *dataStore) load(fileName string) (err error) {
 	}
 
 	// make a new dictionary from the persisted data
-	data := newRedisDict()
+	data = newRedisDict()
 	for i := 0; i < int(ph.Count); i++ {
 		var pkh persistKeyHeader
 		if err = dec.Decode(&pkh); err != nil {
@@ -139,42 +280,8 @@ func (ds *dataStore) load(fileName string) (err error) {
 		}
 
 		var payload any
-		if flagHasOne(pkh.Flags, FLAG_KEY_TYPE_STRING) {
-			var str []byte
-			err = dec.Decode(&str)
-			payload = str
-		} else if flagHasOne(pkh.Flags, FLAG_KEY_TYPE_HASH_TABLE) {
-			var table map[string]string
-			err = dec.Decode(&table)
-			payload = newRedisDictFromStringTable(table)
-		} else if flagHasOne(pkh.Flags, FLAG_KEY_TYPE_SET) {
-			var table map[string]struct{}
-			err = dec.Decode(&table)
-			payload = newRedisDictFromKeyTable(table)
-		} else if flagHasOne(pkh.Flags, FLAG_KEY_TYPE_LIST) {
-			var rawList [][]byte
-			err = dec.Decode(&rawList)
-			if err == nil {
-				// make a linked list from raw data
-				list := &storeList{}
-
-				for _, element := range rawList {
-					item := &listItem{
-						prev:    list.tail,
-						element: element,
-					}
-					if list.head == nil {
-						list.head = item
-					} else {
-						list.tail.next = item
-					}
-					list.tail = item
-				}
-
-				list.count = len(rawList)
-				payload = list
-			}
-		} else {
+		payload, err = readPersistPayload(dec, pkh.Flags)
+		if errors.Is(err, errPersistType) {
 			err = fmt.Errorf("invalid value type - database file is corrupt")
 			return
 		}
@@ -185,20 +292,19 @@ func (ds *dataStore) load(fileName string) (err error) {
 		}
 
 		sk := &storeKey{
-			id:         pkh.Id,
-			flags:      pkh.Flags,
-			lastAccess: pkh.LastAccess,
-			expiresAt:  pkh.ExpiresAt,
-			payload:    payload,
+			id:             ds.nextObjectIdUnlocked(), // ids are unique across data stores
+			flags:          pkh.Flags,
+			lastAccess:     pkh.LastAccess,
+			expiresAt:      pkh.ExpiresAt,
+			payload:        payload,
+			fieldExpiresAt: pkh.FieldExpiresAt,
+			lfuCounter:     lfuInitVal,
+			lfuDecayedAt:   time.Now(),
 		}
 		data.store(pkh.Key, sk)
 	}
 
 	data.removals = int(ph.Removals)
 	data.dirty = false
-
-	ds.data = data
-	ds.dataObjectNumber = ph.DataObjectNumber
-
 	return
 }
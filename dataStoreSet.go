@@ -4,32 +4,92 @@ import (
 	"fmt"
 	"io/fs"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jimsnab/go-lane"
 )
 
 type (
 	dataStoreSet struct {
-		mu       sync.Mutex
-		basePath string
-		dbs      map[int]*dataStore
-		users    map[string]*dataStoreUser
-		phook    *DispatchHook
+		mu        sync.Mutex
+		basePath  string
+		dbs       map[int]*dataStore
+		users     map[string]*dataStoreUser
+		phook     *DispatchHook
+		scripts   map[string]*luaScript      // the script cache, by SHA1
+		running   map[*cmdContext]*scriptRun // the scripts in execution, by EVAL command
+		functions *functionRegistry
+		config    *serverConfig
+		shutdown  func() // terminates the server, set by RedisEmu
+		saveMu    sync.Mutex
+		persist   persistStats
+		slowLog   slowLog
+		latency   latencyMonitor
+		stats     serverStats
+		clients   clientRegistry // the connected clients
+		info      redisStats
+		pubsub    pubsubRegistry
+		tracking  trackingRegistry
+		monitors  monitorRegistry
+
+		// multiMu is held to lock more than one data store, before the data
+		// store locks are taken; multiOwner is the client whose transaction
+		// or script holds it, so that the commands it runs don't take it again
+		multiMu    sync.Mutex
+		multiOwner atomic.Pointer[clientState]
+
+		keyspaceEvents uint32 // notify-keyspace-events flags, accessed atomically
+		changes        int64  // changes since the last save, accessed atomically
+		noActiveExpire uint32 // set by DEBUG SET-ACTIVE-EXPIRE 0, accessed atomically
+		keyMemory      int64  // the memory estimate of all keys, accessed atomically
+		peakMemory     int64  // the highest used memory estimate, accessed atomically
+		lastObjectId   uint64 // the last data object id, accessed atomically
+	}
+
+	// persistStats holds the persistence fields of INFO, guarded by dataStoreSet.mu
+	persistStats struct {
+		lastSave         time.Time
+		saves            int64
+		bgSaveInProgress bool
+		bgSaveScheduled  bool
+		bgSaveStarted    time.Time
+		lastBgSaveFailed bool
+		lastBgSaveSec    int64 // -1 until a background save completes
+	}
+
+	// saveRule is a rule of the save parameter: save when there are at
+	// least the number of changes in the number of seconds
+	saveRule struct {
+		seconds int64
+		changes int64
 	}
 
 	DispatchHook func(cmd string, args map[string]any) (hooked bool, result any, err error)
 )
 
-func newDataStoreSet(l lane.Lane, basePath string, phook *DispatchHook) *dataStoreSet {
+func newDataStoreSet(l lane.Lane, basePath string, phook *DispatchHook, config *serverConfig) *dataStoreSet {
 	dss := &dataStoreSet{
-		basePath: basePath,
-		dbs:      map[int]*dataStore{},
-		users:    map[string]*dataStoreUser{"default": newDataStoreUser()},
-		phook:    phook,
+		basePath:  basePath,
+		dbs:       map[int]*dataStore{},
+		users:     map[string]*dataStoreUser{"default": newDataStoreUser()},
+		phook:     phook,
+		scripts:   map[string]*luaScript{},
+		running:   map[*cmdContext]*scriptRun{},
+		functions: newFunctionRegistry(),
+		config:    config,
+		persist:   persistStats{lastSave: time.Now(), lastBgSaveSec: -1},
+		clients:   clientRegistry{clients: map[int64]*clientState{}},
+		info:      newRedisStats(),
+		pubsub:    newPubsubRegistry(),
+		tracking:  newTrackingRegistry(),
+		monitors:  monitorRegistry{clients: map[*clientState]struct{}{}},
 	}
+	config.applyAll(dss)
 
 	dss.createDbUnlocked(0)
 	if basePath != "" {
@@ -68,17 +128,244 @@ func newDataStoreSet(l lane.Lane, basePath string, phook *DispatchHook) *dataSto
 	return dss
 }
 
-func (dss *dataStoreSet) save(l lane.Lane) error {
-	for index, ds := range dss.dbs {
-		dsc := ds.newDataStoreCommand()
-		err := dsc.save(l, dss.dataStoreFileName(index))
+// saves the data stores that have changed; without a persistence path, there
+// is nothing to write, but the save is still counted; the data store of dsc
+// may be owned by a transaction or script, and dsc is nil for a save that
+// isn't made by a command
+func (dss *dataStoreSet) save(l lane.Lane, dsc *dataStoreCommand) error {
+	dss.saveMu.Lock()
+	defer dss.saveMu.Unlock()
+
+	changes := atomic.LoadInt64(&dss.changes)
+	if dss.basePath != "" {
+		dss.mu.Lock()
+		dbs := make(map[int]*dataStore, len(dss.dbs))
+		for index, ds := range dss.dbs {
+			dbs[index] = ds
+		}
+		dss.mu.Unlock()
+
+		for index, ds := range dbs {
+			dbsc := dsc
+			if dsc == nil || ds != dsc.ds {
+				dbsc = ds.newDataStoreCommand()
+			}
+			err := dbsc.save(l, dss.dataStoreFileName(index))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	dss.completeSave(changes)
+	return nil
+}
+
+// updates the persistence stats after the changes have been saved
+func (dss *dataStoreSet) completeSave(changes int64) {
+	atomic.AddInt64(&dss.changes, -changes)
+	dss.mu.Lock()
+	dss.persist.lastSave = time.Now()
+	dss.persist.saves++
+	dss.mu.Unlock()
+}
+
+// saves every data store and loads it back, as DEBUG RELOAD does; the data
+// store of dsc may be owned by a transaction or script
+func (dss *dataStoreSet) reload(l lane.Lane, dsc *dataStoreCommand, save, flush, merge bool) error {
+	dss.saveMu.Lock()
+	defer dss.saveMu.Unlock()
+
+	changes := atomic.LoadInt64(&dss.changes)
+	for _, ds := range dss.sortedDbs() {
+		dbsc := dsc
+		if ds != dsc.ds {
+			dbsc = ds.newDataStoreCommand()
+		}
+		dbsc.lock()
+		err := ds.reloadUnlocked(dss.dataStoreFileName(ds.index), save, flush, merge)
+		dbsc.unlock()
 		if err != nil {
+			l.Errorf("reload of db %d failed: %s", ds.index, err)
 			return err
 		}
 	}
+
+	if save {
+		dss.completeSave(changes)
+	}
+	dss.trackingInvalidateAll()
 	return nil
 }
 
+// holds every data store lock for a duration, so that the commands of other
+// clients stall, as DEBUG SLEEP does
+func (dss *dataStoreSet) stall(dsc *dataStoreCommand, d time.Duration) {
+	dsc.lockMulti()
+	defer dsc.unlockMulti()
+
+	for _, ds := range dss.sortedDbs() {
+		dbsc := dsc
+		if ds != dsc.ds {
+			dbsc = ds.newDataStoreCommand()
+		}
+		dbsc.lock()
+		defer dbsc.unlock()
+	}
+
+	time.Sleep(d)
+}
+
+// describes the clients that are blocked, and the wait table of each data
+// store that has waiting clients
+func (dss *dataStoreSet) dumpWaitTables(dsc *dataStoreCommand) string {
+	var sb strings.Builder
+
+	type blockedClient struct {
+		id int64
+		db int
+	}
+	blocked := []blockedClient{}
+	dss.processAllClients(func(id int64, cs *clientState) {
+		if cs.isBlocked() {
+			blocked = append(blocked, blockedClient{id: id, db: cs.selectedDb})
+		}
+	})
+	slices.SortFunc(blocked, func(a, b blockedClient) int { return int(a.id - b.id) })
+
+	sb.WriteString("Blocked clients:\n")
+	for _, bc := range blocked {
+		fmt.Fprintf(&sb, " Client %d in db %d\n", bc.id, bc.db)
+	}
+
+	for _, ds := range dss.sortedDbs() {
+		dbsc := dsc
+		if ds != dsc.ds {
+			dbsc = ds.newDataStoreCommand()
+		}
+		dbsc.lock()
+		if len(ds.waitingClients.table) > 0 {
+			fmt.Fprintf(&sb, "\n# db %d\n", ds.index)
+			ds.waitingClients.dumpTo(&sb)
+		}
+		dbsc.unlock()
+	}
+	return sb.String()
+}
+
+// provides the data stores in index order
+func (dss *dataStoreSet) sortedDbs() []*dataStore {
+	dss.mu.Lock()
+	dbs := make([]*dataStore, 0, len(dss.dbs))
+	for _, ds := range dss.dbs {
+		dbs = append(dbs, ds)
+	}
+	dss.mu.Unlock()
+
+	slices.SortFunc(dbs, func(a, b *dataStore) int { return a.index - b.index })
+	return dbs
+}
+
+// starts a save in the background; when one is already in progress, another
+// save is scheduled to follow it if schedule is set
+func (dss *dataStoreSet) backgroundSave(l lane.Lane, schedule bool) (started, scheduled bool) {
+	dss.mu.Lock()
+	defer dss.mu.Unlock()
+
+	if dss.persist.bgSaveInProgress {
+		if schedule {
+			dss.persist.bgSaveScheduled = true
+			scheduled = true
+		}
+		return
+	}
+
+	dss.persist.bgSaveInProgress = true
+	go dss.runBackgroundSave(l)
+	started = true
+	return
+}
+
+func (dss *dataStoreSet) runBackgroundSave(l lane.Lane) {
+	for {
+		dss.mu.Lock()
+		dss.persist.bgSaveStarted = time.Now()
+		dss.mu.Unlock()
+
+		err := dss.save(l, nil)
+		if err != nil {
+			l.Errorf("background save failed: %s", err)
+		}
+
+		dss.mu.Lock()
+		dss.persist.lastBgSaveFailed = err != nil
+		dss.persist.lastBgSaveSec = int64(time.Since(dss.persist.bgSaveStarted).Seconds())
+		if !dss.persist.bgSaveScheduled {
+			dss.persist.bgSaveInProgress = false
+			dss.mu.Unlock()
+			return
+		}
+		dss.persist.bgSaveScheduled = false
+		dss.mu.Unlock()
+	}
+}
+
+// gets a copy of the persistence statistics
+func (dss *dataStoreSet) persistenceStats() persistStats {
+	dss.mu.Lock()
+	defer dss.mu.Unlock()
+	return dss.persist
+}
+
+// gets the number of changes since the last save
+func (dss *dataStoreSet) changesSinceSave() int64 {
+	return atomic.LoadInt64(&dss.changes)
+}
+
+// counts a change to the data
+func (dss *dataStoreSet) addChanges(n int64) {
+	atomic.AddInt64(&dss.changes, n)
+}
+
+// parses the save parameter, e.g., "3600 1 300 100"
+func parseSaveRules(value string) (rules []saveRule, valid bool) {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		return
+	}
+
+	rules = make([]saveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || seconds < 1 {
+			return
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return
+		}
+		rules = append(rules, saveRule{seconds: seconds, changes: changes})
+	}
+	return rules, true
+}
+
+// determines if a rule of the save parameter calls for a save
+func (dss *dataStoreSet) isSaveDue() bool {
+	rules, _ := parseSaveRules(dss.config.get("save"))
+	changes := atomic.LoadInt64(&dss.changes)
+
+	dss.mu.Lock()
+	elapsed := time.Since(dss.persist.lastSave)
+	dss.mu.Unlock()
+
+	for _, rule := range rules {
+		if changes > 0 && changes >= rule.changes && elapsed >= time.Duration(rule.seconds)*time.Second {
+			return true
+		}
+	}
+	return false
+}
+
 func (dss *dataStoreSet) dataStoreFileName(index int) string {
 	if dss.basePath == "" {
 		return ""
@@ -87,12 +374,14 @@ func (dss *dataStoreSet) dataStoreFileName(index int) string {
 }
 
 func (dss *dataStoreSet) createDbUnlocked(index int) (ds *dataStore, valid bool) {
-	if index < 0 || index > 15 {
+	if index < 0 || int64(index) >= dss.config.intValue("databases") {
 		return
 	}
 	ds, exists := dss.dbs[index]
 	if !exists {
 		ds = newDataStore()
+		ds.index = index
+		ds.dss = dss
 		dss.dbs[index] = ds
 	}
 
@@ -118,18 +407,66 @@ func (dss *dataStoreSet) getDb(index int, create bool) (ds *dataStore, valid boo
 	return
 }
 
-func (dss *dataStoreSet) flushDb(index int) {
-	dss.mu.Lock()
-	defer dss.mu.Unlock()
+func (dss *dataStoreSet) flushDb(dsc *dataStoreCommand) {
+	dsc.lock()
+	dsc.ds.flushUnlocked()
+	dsc.unlock()
 
-	delete(dss.dbs, index)
+	dss.trackingInvalidateAll()
 }
 
-func (dss *dataStoreSet) flushAll() {
+// flushes every data store; the data store of dsc may be owned by a
+// transaction or script
+func (dss *dataStoreSet) flushAll(dsc *dataStoreCommand) {
 	dss.mu.Lock()
-	defer dss.mu.Unlock()
+	dbs := make([]*dataStore, 0, len(dss.dbs))
+	for _, ds := range dss.dbs {
+		dbs = append(dbs, ds)
+	}
+	dss.mu.Unlock()
 
-	dss.dbs = map[int]*dataStore{}
+	for _, ds := range dbs {
+		dbsc := dsc
+		if ds != dsc.ds {
+			dbsc = ds.newDataStoreCommand()
+		}
+		dbsc.lock()
+		ds.flushUnlocked()
+		dbsc.unlock()
+	}
+
+	dss.trackingInvalidateAll()
+}
+
+// exchanges the keys of two databases; the data store of dsc may be owned by
+// a transaction or script
+func (dss *dataStoreSet) swapDb(dsc *dataStoreCommand, index1, index2 int) (valid bool) {
+	ds1, valid := dss.getDb(index1, true)
+	if !valid {
+		return
+	}
+	ds2, valid := dss.getDb(index2, true)
+	if !valid || ds1 == ds2 {
+		return
+	}
+
+	// to acquire two data store locks, the multi data store lock must be held, to prevent
+	// a deadlock from two conflicting multi-data store operations
+	dsc.lockMulti()
+	defer dsc.unlockMulti()
+
+	for _, ds := range []*dataStore{ds1, ds2} {
+		dbsc := dsc
+		if ds != dsc.ds {
+			dbsc = ds.newDataStoreCommand()
+		}
+		dbsc.lock()
+		defer dbsc.unlock()
+	}
+
+	ds1.swapUnlocked(ds2)
+	dss.addChanges(1)
+	return
 }
 
 func (dss *dataStoreSet) getUser(userName string) (dsu *dataStoreUser, exists bool) {
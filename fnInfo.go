@@ -4,45 +4,30 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// redisStats holds the server counters of INFO for an emulator
 type redisStats struct {
+	mu                         sync.Mutex
 	run_id                     string
-	update_in_seconds          int64
-	update_in_days             int64
+	started                    time.Time
 	connected_clients          int64
-	used_memory                int64
-	used_memory_rss            int64
-	used_memory_peak           int64
-	used_memory_peak_perc      int64
-	used_memory_overhead       int64
-	used_memory_startup        int64
-	used_memory_dataset        int64
-	used_memory_dataset_perc   int64
-	allocator_allocated        int64
-	allocator_active           int64
-	allocator_resident         int64
 	total_system_memory        int64
-	rdb_last_save_time         int64
-	rdb_saves                  int64
 	total_connections_received int64
 	total_commands_processed   int64
 	total_net_input_bytes      int64
 	total_net_output_bytes     int64
-	total_error_replies        int64
 	total_reads_processed      int64
 	total_writes_processed     int64
-	keys                       int64
 }
 
-var info redisStats = redisStats{
-	run_id: strings.ReplaceAll(uuid.NewString(), "-", ""),
-}
-var infoMu sync.Mutex
-var started time.Time = time.Now()
+// like redis, these sections are only provided when they are requested, or
+// for "all" and "everything"
+var infoNonDefaultSections = map[string]bool{"commandstats": true, "latencystats": true}
 
 func fnInfo(ctx *cmdContext, args map[string]any) (output respValue, err error) {
 	templates := map[string]string{}
@@ -68,59 +53,78 @@ func fnInfo(ctx *cmdContext, args map[string]any) (output respValue, err error)
 		}
 	}
 
-	uptime := time.Since(started)
-
 	data := map[string]any{}
-	data["run_id"] = info.run_id
+	ri := &ctx.cs.dss.info
+	ri.mu.Lock()
+	uptime := time.Since(ri.started)
+	data["run_id"] = ri.run_id
+	data["connected_clients"] = ri.connected_clients
+	data["total_system_memory"] = ri.total_system_memory
+	data["total_system_memory_human"] = ri.humanValue(ri.total_system_memory)
+	data["total_connections_received"] = ri.total_connections_received
+	data["total_commands_processed"] = ri.total_commands_processed
+	data["total_net_input_bytes"] = ri.total_net_input_bytes
+	data["total_net_output_bytes"] = ri.total_net_output_bytes
+	data["total_reads_processed"] = ri.total_reads_processed
+	data["total_writes_processed"] = ri.total_writes_processed
+	ri.mu.Unlock()
+
 	data["tcp_port"] = ctx.cd.port
-	data["server_time_usec"] = uptime.Microseconds()
-	data["update_in_seconds"] = info.update_in_seconds
-	data["update_in_days"] = info.update_in_days
-	data["connected_clients"] = info.connected_clients
-	data["used_memory"] = info.used_memory
-	data["used_memory_rss"] = info.used_memory_rss
-	data["used_memory_peak"] = info.used_memory_peak
-	data["used_memory_peak_perc"] = info.used_memory_peak_perc
-	data["used_memory_overhead"] = info.used_memory_overhead
-	data["used_memory_startup"] = info.used_memory_startup
-	data["used_memory_dataset"] = info.used_memory_dataset
-	data["used_memory_dataset_perc"] = info.used_memory_dataset_perc
-	data["allocator_allocated"] = info.allocator_allocated
-	data["allocator_active"] = info.allocator_active
-	data["allocator_resident"] = info.allocator_resident
-	data["total_system_memory"] = info.total_system_memory
-	data["rdb_last_save_time"] = info.rdb_last_save_time
-	data["rdb_saves"] = info.rdb_saves
-	data["total_connections_received"] = info.total_connections_received
-	data["total_commands_processed"] = info.total_commands_processed
-	data["total_net_input_bytes"] = info.total_net_input_bytes
-	data["total_net_output_bytes"] = info.total_net_output_bytes
-	data["total_error_replies"] = info.total_error_replies
-	data["total_reads_processed"] = info.total_reads_processed
-	data["total_writes_processed"] = info.total_writes_processed
-	data["keys"] = info.keys
-
-	data["used_memory_human"] = info.humanValue(info.used_memory)
-	data["used_memory_rss_human"] = info.humanValue(info.used_memory_rss)
-	data["used_memory_peak_human"] = info.humanValue(info.used_memory_peak)
-	data["total_system_memory_human"] = info.humanValue(info.total_system_memory)
+	data["server_time_usec"] = time.Now().UnixMicro()
+	data["update_in_seconds"] = int64(uptime.Seconds())
+	data["update_in_days"] = int64(uptime.Hours() / 24)
+	data["blocked_clients"] = ctx.cs.dss.blockedClients()
+	data["tracking_clients"], data["tracking_total_keys"], data["tracking_total_items"], data["tracking_total_prefixes"] = ctx.cs.dss.trackingCounts()
+	data["pubsub_channels"], data["pubsub_patterns"], data["pubsubshard_channels"] = ctx.cs.dss.pubsubCounts()
+	mem := ctx.cs.dss.memoryStats(ctx.dsc)
+	data["used_memory"] = mem.total
+	data["used_memory_rss"] = mem.total
+	data["used_memory_peak"] = mem.peak
+	data["used_memory_peak_perc"] = fmt.Sprintf("%.2f%%", mem.peakPercentage())
+	data["used_memory_overhead"] = mem.overhead
+	data["used_memory_startup"] = mem.startup
+	data["used_memory_dataset"] = mem.dataset
+	data["used_memory_dataset_perc"] = fmt.Sprintf("%.2f%%", mem.datasetPercentage())
+	data["allocator_allocated"] = mem.total
+	data["allocator_active"] = mem.total
+	data["allocator_resident"] = mem.total
+	data["mem_clients_normal"] = mem.clients
+
+	persist := ctx.cs.dss.persistenceStats()
+	data["rdb_changes_since_last_save"] = ctx.cs.dss.changesSinceSave()
+	data["rdb_bgsave_in_progress"] = 0
+	data["rdb_last_save_time"] = persist.lastSave.Unix()
+	data["rdb_saves"] = persist.saves
+	data["rdb_last_bgsave_time_sec"] = persist.lastBgSaveSec
+	data["rdb_current_bgsave_time_sec"] = int64(-1)
+	if persist.bgSaveInProgress {
+		data["rdb_bgsave_in_progress"] = 1
+		data["rdb_current_bgsave_time_sec"] = int64(time.Since(persist.bgSaveStarted).Seconds())
+	}
+	data["rdb_last_bgsave_status"] = "ok"
+	if persist.lastBgSaveFailed {
+		data["rdb_last_bgsave_status"] = "err"
+	}
+	data["total_error_replies"] = ctx.cs.dss.errorReplies()
+	data["expired_keys"] = atomic.LoadInt64(&ctx.cs.dss.stats.expiredKeys)
+	data["keyspace_hits"] = atomic.LoadInt64(&ctx.cs.dss.stats.keyspaceHits)
+	data["keyspace_misses"] = atomic.LoadInt64(&ctx.cs.dss.stats.keyspaceMisses)
+	data["commandstats"] = ctx.cs.dss.commandStatsInfo()
+	data["errorstats"] = ctx.cs.dss.errorStatsInfo()
+	data["latencystats"] = ctx.cs.dss.latencyStatsInfo()
+	data["keyspace"] = ctx.cs.dss.keyspaceInfo(ctx.dsc)
+
+	data["used_memory_human"] = ri.humanValue(mem.total)
+	data["used_memory_rss_human"] = ri.humanValue(mem.total)
+	data["used_memory_peak_human"] = ri.humanValue(mem.peak)
 
 	// construct output for the requested sections
 	var sb strings.Builder
 	filters, _ := args["section"].([]any)
 
 	for _, section := range order {
-		if filters != nil {
-			found := false
-			for _, filter := range filters {
-				if strings.EqualFold(section, filter.(string)) {
-					found = true
-					break
-				}
-			}
-			if !found {
-				continue
-			}
+		if !infoSectionRequested(section, filters) {
+			continue
 		}
 
 		if sb.Len() > 0 {
@@ -150,7 +154,21 @@ func fnInfo(ctx *cmdContext, args map[string]any) (output respValue, err error)
 				}
 			}
 
-			sb.WriteString(fmt.Sprintf("%v", data[keyword]))
+			if lines, isLines := data[keyword].([]string); isLines {
+				// a list of metrics fills its line of the template, and
+				// removes the line when it is empty
+				sb.WriteString(strings.Join(lines, "\r\n"))
+				if len(lines) == 0 {
+					if pos < len(t) && t[pos] == '\r' {
+						pos++
+					}
+					if pos < len(t) && t[pos] == '\n' {
+						pos++
+					}
+				}
+			} else {
+				sb.WriteString(fmt.Sprintf("%v", data[keyword]))
+			}
 		} else if ch == '\r' {
 			pos++
 		} else if ch == '\n' {
@@ -166,6 +184,69 @@ func fnInfo(ctx *cmdContext, args map[string]any) (output respValue, err error)
 	return
 }
 
+// checks if a section is selected by the arguments of INFO, which can also
+// be "default", "all" or "everything"
+func infoSectionRequested(section string, filters []any) bool {
+	name := strings.ToLower(section)
+	if filters == nil {
+		return !infoNonDefaultSections[name]
+	}
+
+	for _, filter := range filters {
+		switch strings.ToLower(filter.(string)) {
+		case name, "all", "everything":
+			return true
+		case "default":
+			if !infoNonDefaultSections[name] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newRedisStats() redisStats {
+	return redisStats{
+		run_id:  strings.ReplaceAll(uuid.NewString(), "-", ""),
+		started: time.Now(),
+	}
+}
+
+// counts a command received from a client
+func (ri *redisStats) addInput(length int) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	ri.total_net_input_bytes += int64(length)
+	ri.total_reads_processed++
+}
+
+// counts a write to a client, which is a command response or an
+// out-of-band message
+func (ri *redisStats) addOutput(length int, response bool) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	ri.total_net_output_bytes += int64(length)
+	ri.total_writes_processed++
+	if response {
+		ri.total_commands_processed++
+	}
+}
+
+// clears the counters, as CONFIG RESETSTAT does
+func (ri *redisStats) resetStats() {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	ri.total_connections_received = 0
+	ri.total_commands_processed = 0
+	ri.total_net_input_bytes = 0
+	ri.total_net_output_bytes = 0
+	ri.total_reads_processed = 0
+	ri.total_writes_processed = 0
+}
+
 func (ri *redisStats) humanValue(value int64) string {
 	v := float64(value)
 	if value < 1024 {
@@ -0,0 +1,184 @@
+package redisemu
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// parses the fields of an INFO reply
+func infoFields(output respValue) map[string]string {
+	text, _ := output.toString()
+	fields := map[string]string{}
+	for _, line := range strings.Split(text, "\r\n") {
+		if name, value, found := strings.Cut(line, ":"); found && !strings.HasPrefix(line, "#") {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+func TestInfoCommandStats(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("config", "resetstat")
+	ts.ProcessCommand("set", "key1", "cat")
+	ts.ProcessCommand("get", "key1")
+	ts.ProcessCommand("get")
+	ts.ProcessCommand("lpush", "key1", "dog")
+	ts.ProcessCommand("config", "get")
+	ts.ProcessCommand("bogus")
+
+	fields := infoFields(ts.ProcessCommand("info", "commandstats", "errorstats", "stats"))
+	if !strings.HasPrefix(fields["cmdstat_get"], "calls=1,usec=") || !strings.HasSuffix(fields["cmdstat_get"], ",rejected_calls=1,failed_calls=0") ||
+		!strings.HasSuffix(fields["cmdstat_lpush"], ",rejected_calls=0,failed_calls=1") ||
+		!strings.HasPrefix(fields["cmdstat_config|get"], "calls=0,") || !strings.HasSuffix(fields["cmdstat_config|get"], ",rejected_calls=1,failed_calls=0") {
+		t.Fatal("info commandstats fail")
+	}
+	if _, exists := fields["cmdstat_bogus"]; exists {
+		t.Fatal("info commandstats unknown command fail")
+	}
+
+	if fields["errorstat_ERR"] != "count=3" || fields["errorstat_WRONGTYPE"] != "count=1" || fields["total_error_replies"] != "4" {
+		t.Fatal("info errorstats fail")
+	}
+
+	// the errors of the commands called by a script are counted once
+	ts.ProcessCommand("eval", "return redis.call('lpush', KEYS[1], 'x')", "1", "key1")
+	ts.ProcessCommand("eval", "return redis.pcall('lpush', KEYS[1], 'x')", "1", "key1")
+	fields = infoFields(ts.ProcessCommand("info", "everything"))
+	if fields["total_error_replies"] != "6" || !strings.HasSuffix(fields["cmdstat_lpush"], ",failed_calls=3") {
+		t.Fatal("info script errorstats fail")
+	}
+
+	ts.ProcessCommand("config", "resetstat")
+	fields = infoFields(ts.ProcessCommand("info", "all"))
+	if len(fields["cmdstat_get"]) != 0 || len(fields["errorstat_ERR"]) != 0 || fields["total_error_replies"] != "0" {
+		t.Fatal("info resetstat fail")
+	}
+}
+
+func TestInfoKeyspace(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("config", "resetstat")
+	ts.ProcessCommand("set", "key1", "cat")
+	ts.ProcessCommand("set", "key2", "dog", "ex", "100")
+	ts.ProcessCommand("set", "key3", "bird", "px", "10")
+	ts.ProcessCommand("select", "2")
+	ts.ProcessCommand("set", "key1", "cow")
+	ts.ProcessCommand("select", "0")
+
+	time.Sleep(20 * time.Millisecond)
+	ts.ProcessCommand("get", "key1")
+	ts.ProcessCommand("get", "key3")
+	ts.ProcessCommand("get", "missing")
+	ts.ProcessCommand("set", "missing", "1") // writes aren't counted as hits or misses
+
+	fields := infoFields(ts.ProcessCommand("info", "stats", "keyspace"))
+	if fields["keyspace_hits"] != "1" || fields["keyspace_misses"] != "2" || fields["expired_keys"] != "1" {
+		t.Fatal("info keyspace stats fail")
+	}
+
+	ds0 := fields["db0"]
+	if !strings.HasPrefix(ds0, "keys=4,expires=2,avg_ttl=") || fields["db2"] != "keys=1,expires=0,avg_ttl=0" {
+		t.Fatal("info keyspace fail")
+	}
+	if _, exists := fields["db1"]; exists {
+		t.Fatal("info keyspace empty db fail")
+	}
+
+	ts.ProcessCommand("flushall")
+	output := ts.ProcessCommand("info", "keyspace")
+	if text, _ := output.toString(); text != "# Keyspace\r\n" {
+		t.Fatalf("info keyspace flushed fail: %q", text)
+	}
+}
+
+func TestInfoBlockedClients(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	done := make(chan struct{})
+	go func() {
+		ts2.ProcessCommand("blpop", "key1", "1")
+		close(done)
+	}()
+	time.Sleep(40 * time.Millisecond)
+
+	fields := infoFields(ts.ProcessCommand("info", "clients"))
+	if fields["blocked_clients"] != "1" {
+		t.Fatal("info blocked clients fail")
+	}
+
+	ts.ProcessCommand("rpush", "key1", "cat")
+	<-done
+	fields = infoFields(ts.ProcessCommand("info", "clients"))
+	if fields["blocked_clients"] != "0" {
+		t.Fatal("info unblocked clients fail")
+	}
+}
+
+func TestInfoSections(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("set", "key1", "cat")
+
+	// like redis, commandstats and latencystats aren't default sections
+	for _, output := range []respValue{ts.ProcessCommand("info"), ts.ProcessCommand("info", "default")} {
+		text := output.String()
+		if !strings.Contains(text, "# Keyspace") || strings.Contains(text, "# Commandstats") || strings.Contains(text, "# Latencystats") {
+			t.Fatal("info default sections fail")
+		}
+	}
+
+	output := ts.ProcessCommand("info", "everything")
+	text := output.String()
+	if !strings.Contains(text, "# Keyspace") || !strings.Contains(text, "# Commandstats") || !strings.Contains(text, "# Latencystats") {
+		t.Fatal("info everything sections fail")
+	}
+
+	output = ts.ProcessCommand("info", "CommandStats")
+	if text, _ := output.toString(); !strings.HasPrefix(text, "# Commandstats\r\ncmdstat_") || strings.Contains(text, "# Server") {
+		t.Fatal("info commandstats section fail")
+	}
+}
+
+func TestInfoPubSubTracking(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	fields := infoFields(ts.ProcessCommand("info", "clients", "stats"))
+	if fields["tracking_clients"] != "0" || fields["pubsub_channels"] != "0" || fields["pubsub_patterns"] != "0" || fields["tracking_total_keys"] != "0" {
+		t.Fatal("info initial pubsub and tracking fail")
+	}
+
+	ts.ProcessCommand("subscribe", "ch1", "ch2")
+	ts.ProcessCommand("psubscribe", "ch*")
+	ts.ProcessCommand("ssubscribe", "sh")
+	ts.ProcessCommand("client", "tracking", "on")
+	ts.ProcessCommand("mget", "k1", "k2")
+	ts2.ProcessCommand("client", "tracking", "on", "bcast", "prefix", "a", "prefix", "b")
+	ts2.ProcessCommand("get", "k1")
+
+	fields = infoFields(ts.ProcessCommand("info", "clients", "stats"))
+	if fields["tracking_clients"] != "2" || fields["tracking_total_keys"] != "2" || fields["tracking_total_items"] != "2" || fields["tracking_total_prefixes"] != "2" {
+		t.Fatal("info tracking fail")
+	}
+	if fields["pubsub_channels"] != "2" || fields["pubsub_patterns"] != "1" || fields["pubsubshard_channels"] != "1" {
+		t.Fatal("info pubsub fail")
+	}
+
+	ts.ProcessCommand("reset")
+	fields = infoFields(ts2.ProcessCommand("info", "clients", "stats"))
+	if fields["tracking_clients"] != "1" || fields["pubsub_channels"] != "0" || fields["pubsub_patterns"] != "0" {
+		t.Fatal("info reset pubsub and tracking fail")
+	}
+}
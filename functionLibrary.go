@@ -0,0 +1,384 @@
+package redisemu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// FunctionHandle runs commands on behalf of a library function, within
+	// the atomic section of FCALL or FCALL_RO.
+	FunctionHandle interface {
+		// Call runs a command like redis.call() of a Lua function. The reply is
+		// returned in its RESP2 form as native Go values. An error reply is
+		// returned as an error with the reply text.
+		Call(args ...any) (result any, err error)
+	}
+
+	// LibraryFunction is a Go function that can be invoked by FCALL. The
+	// result is converted the same way as a DispatchHook result. An error
+	// returned by FunctionHandle.Call is replied as-is; any other error is
+	// replied with an "ERR " prefix.
+	LibraryFunction struct {
+		Name     string
+		NoWrites bool // allows FCALL_RO, and makes write commands fail
+		Fn       func(h FunctionHandle, keys, args []string) (result any, err error)
+	}
+
+	// functionLibrary is a loaded library
+	functionLibrary struct {
+		name      string
+		code      string
+		functions []LibraryFunction
+	}
+
+	// functionRegistry holds the Go implementations of libraries, and the
+	// libraries that are loaded. A library is loaded by registering it, or by
+	// FUNCTION LOAD or FUNCTION RESTORE of code that names it.
+	functionRegistry struct {
+		mu              sync.Mutex
+		implementations map[string][]LibraryFunction
+		libraries       map[string]*functionLibrary
+	}
+
+	// functionHandle implements FunctionHandle for one FCALL
+	functionHandle struct {
+		ctx      *cmdContext
+		readOnly bool
+	}
+
+	// functionReplyError is an error reply of a command run by a library function
+	functionReplyError string
+)
+
+const functionDumpVersion = 1
+
+var errLibraryNameInvalid = errors.New("Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+
+func newFunctionRegistry() *functionRegistry {
+	return &functionRegistry{
+		implementations: map[string][]LibraryFunction{},
+		libraries:       map[string]*functionLibrary{},
+	}
+}
+
+func (fre functionReplyError) Error() string {
+	return string(fre)
+}
+
+func isValidLibraryName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		if !(ch >= 'a' && ch <= 'z') && !(ch >= 'A' && ch <= 'Z') && !(ch >= '0' && ch <= '9') && ch != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// registers the Go implementation of a library and loads it, replacing a
+// library of the same name
+func (fr *functionRegistry) register(libraryName string, functions []LibraryFunction) error {
+	if !isValidLibraryName(libraryName) {
+		return errLibraryNameInvalid
+	}
+	if len(functions) == 0 {
+		return errors.New("No functions registered")
+	}
+
+	names := map[string]struct{}{}
+	for _, function := range functions {
+		if !isValidLibraryName(function.Name) {
+			return errors.New("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+		}
+		if function.Fn == nil {
+			return fmt.Errorf("Function %s has no implementation", function.Name)
+		}
+		if _, exists := names[function.Name]; exists {
+			return fmt.Errorf("Function %s already exists", function.Name)
+		}
+		names[function.Name] = struct{}{}
+	}
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	lib := &functionLibrary{
+		name:      libraryName,
+		code:      fmt.Sprintf("#!lua name=%s\n", libraryName),
+		functions: functions,
+	}
+	if errText := fr.checkConflictsUnlocked(lib, true); errText != "" {
+		return errors.New(strings.TrimPrefix(string(errText), "ERR "))
+	}
+
+	fr.implementations[libraryName] = functions
+	fr.libraries[libraryName] = lib
+	return nil
+}
+
+// makes a library from library code; the first line of the code names
+// the library, e.g., "#!lua name=mylib"
+func (fr *functionRegistry) libraryFromCodeUnlocked(code string) (lib *functionLibrary, errText respErrorString) {
+	shebang, _, _ := strings.Cut(code, "\n")
+	if !strings.HasPrefix(shebang, "#!") {
+		errText = "ERR Missing library metadata"
+		return
+	}
+
+	fields := strings.Fields(shebang[2:])
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "lua") {
+		engine := ""
+		if len(fields) > 0 {
+			engine = fields[0]
+		}
+		errText = respErrorString(fmt.Sprintf("ERR Engine '%s' not found", engine))
+		return
+	}
+
+	name := ""
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		if key != "name" {
+			errText = respErrorString(fmt.Sprintf("ERR Invalid metadata value given: %s", field))
+			return
+		}
+		name = value
+	}
+	if name == "" {
+		errText = "ERR Library name was not given"
+		return
+	}
+	if !isValidLibraryName(name) {
+		errText = respErrorString("ERR " + errLibraryNameInvalid.Error())
+		return
+	}
+
+	functions, exists := fr.implementations[name]
+	if !exists {
+		errText = respErrorString(fmt.Sprintf("ERR Library '%s' does not have a registered Go implementation", name))
+		return
+	}
+
+	lib = &functionLibrary{name: name, code: code, functions: functions}
+	return
+}
+
+// checks that a library can be loaded
+func (fr *functionRegistry) checkConflictsUnlocked(lib *functionLibrary, replace bool) (errText respErrorString) {
+	if _, exists := fr.libraries[lib.name]; exists && !replace {
+		errText = respErrorString(fmt.Sprintf("ERR Library '%s' already exists", lib.name))
+		return
+	}
+
+	// function names are unique across all libraries
+	for _, other := range fr.libraries {
+		if other.name == lib.name {
+			continue
+		}
+		for _, function := range lib.functions {
+			if other.findFunction(function.Name) != nil {
+				errText = respErrorString(fmt.Sprintf("ERR Function %s already exists", function.Name))
+				return
+			}
+		}
+	}
+	return
+}
+
+func (fr *functionRegistry) load(code string, replace bool) (libraryName string, errText respErrorString) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	lib, errText := fr.libraryFromCodeUnlocked(code)
+	if errText != "" {
+		return
+	}
+	if errText = fr.checkConflictsUnlocked(lib, replace); errText != "" {
+		return
+	}
+
+	fr.libraries[lib.name] = lib
+	libraryName = lib.name
+	return
+}
+
+func (fr *functionRegistry) delete(libraryName string) bool {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	if _, exists := fr.libraries[libraryName]; !exists {
+		return false
+	}
+	delete(fr.libraries, libraryName)
+	return true
+}
+
+func (fr *functionRegistry) flush() {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	fr.libraries = map[string]*functionLibrary{}
+}
+
+// finds a function of the loaded libraries
+func (fr *functionRegistry) findFunction(name string) *LibraryFunction {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	for _, lib := range fr.libraries {
+		if function := lib.findFunction(name); function != nil {
+			return function
+		}
+	}
+	return nil
+}
+
+func (lib *functionLibrary) findFunction(name string) *LibraryFunction {
+	for idx := range lib.functions {
+		if lib.functions[idx].Name == name {
+			return &lib.functions[idx]
+		}
+	}
+	return nil
+}
+
+// gets the loaded libraries with names matching the pattern, ordered by name
+func (fr *functionRegistry) list(pattern string) []*functionLibrary {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	libs := make([]*functionLibrary, 0, len(fr.libraries))
+	for _, lib := range fr.libraries {
+		if pattern == "" || redisGlob([]rune(pattern), []rune(lib.name)) {
+			libs = append(libs, lib)
+		}
+	}
+	sort.Slice(libs, func(i, j int) bool {
+		return libs[i].name < libs[j].name
+	})
+	return libs
+}
+
+// serializes the code of the loaded libraries; the payload is like a DUMP
+// payload, with a version and a checksum
+func (fr *functionRegistry) dump() string {
+	serial := []byte{functionDumpVersion}
+	for _, lib := range fr.list("") {
+		beLen := make([]byte, 4)
+		binary.BigEndian.PutUint32(beLen, uint32(len(lib.code)))
+		serial = append(serial, beLen...)
+		serial = append(serial, lib.code...)
+	}
+
+	serial = append(serial, simpleChecksum(serial)...)
+	return string(serial)
+}
+
+// restores the libraries of a FUNCTION DUMP payload; the policy is
+// "append", "replace" or "flush"
+func (fr *functionRegistry) restore(payload string, policy string) (errText respErrorString) {
+	serial := []byte(payload)
+	if len(serial) < 9 || serial[0] != functionDumpVersion {
+		errText = "ERR payload version or checksum are wrong"
+		return
+	}
+	content := serial[:len(serial)-8]
+	if string(simpleChecksum(content)) != string(serial[len(serial)-8:]) {
+		errText = "ERR payload version or checksum are wrong"
+		return
+	}
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	libs := []*functionLibrary{}
+	for pos := 1; pos < len(content); {
+		if pos+4 > len(content) {
+			errText = "ERR payload version or checksum are wrong"
+			return
+		}
+		length := int(binary.BigEndian.Uint32(content[pos : pos+4]))
+		pos += 4
+		if pos+length > len(content) {
+			errText = "ERR payload version or checksum are wrong"
+			return
+		}
+
+		lib, libErr := fr.libraryFromCodeUnlocked(string(content[pos : pos+length]))
+		if libErr != "" {
+			errText = libErr
+			return
+		}
+		libs = append(libs, lib)
+		pos += length
+	}
+
+	// validate everything before changing the loaded libraries
+	current := fr.libraries
+	if policy == "flush" {
+		fr.libraries = map[string]*functionLibrary{}
+	}
+	for _, lib := range libs {
+		if errText = fr.checkConflictsUnlocked(lib, policy != "append"); errText != "" {
+			fr.libraries = current
+			return
+		}
+	}
+
+	restored := make(map[string]*functionLibrary, len(fr.libraries)+len(libs))
+	for name, lib := range fr.libraries {
+		restored[name] = lib
+	}
+	for _, lib := range libs {
+		restored[lib.name] = lib
+	}
+	fr.libraries = restored
+	return
+}
+
+// counts the loaded libraries and their functions
+func (fr *functionRegistry) counts() (libraries, functions int) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	for _, lib := range fr.libraries {
+		libraries++
+		functions += len(lib.functions)
+	}
+	return
+}
+
+func (fh *functionHandle) Call(args ...any) (result any, err error) {
+	if len(args) == 0 {
+		err = functionReplyError("ERR Please specify at least one argument for this redis lib call")
+		return
+	}
+
+	a := make(respArray, 0, len(args))
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			a = append(a, respValue{data: respBulkString(v)})
+		case []byte:
+			a = append(a, respValue{data: respBulkString(v)})
+		default:
+			a = append(a, respValue{data: respBulkString(fmt.Sprint(v))})
+		}
+	}
+
+	output := dispatchFromScript(fh.ctx, a, fh.readOnly, 2)
+	if errText, isError := output.data.(respErrorString); isError {
+		err = functionReplyError(errText)
+		return
+	}
+
+	result = output.toNative()
+	return
+}
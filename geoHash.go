@@ -0,0 +1,432 @@
+package redisemu
+
+import (
+	"math"
+)
+
+// Geo members are sorted set members with a score that is a 52-bit geohash,
+// interleaving 26 bits of latitude with 26 bits of longitude, computed the
+// same way as Redis so that scores and search results match a real server.
+
+type (
+	geoHashBits struct {
+		bits uint64
+		step uint
+	}
+
+	geoHashRange struct {
+		min float64
+		max float64
+	}
+
+	geoHashArea struct {
+		hash      geoHashBits
+		longitude geoHashRange
+		latitude  geoHashRange
+	}
+
+	geoHashNeighbors struct {
+		north     geoHashBits
+		east      geoHashBits
+		west      geoHashBits
+		south     geoHashBits
+		northEast geoHashBits
+		southEast geoHashBits
+		northWest geoHashBits
+		southWest geoHashBits
+	}
+
+	// the geohash boxes that cover a search shape
+	geoHashRadius struct {
+		hash      geoHashBits
+		area      geoHashArea
+		neighbors geoHashNeighbors
+	}
+
+	// a circle or rectangle to search, centered on a point; sizes are
+	// in the requested units, which conversion changes to meters
+	geoShape struct {
+		longitude  float64
+		latitude   float64
+		byBox      bool
+		radius     float64
+		width      float64
+		height     float64
+		conversion float64
+	}
+)
+
+const (
+	geoStepMax      = 26
+	geoLatMin       = -85.05112878
+	geoLatMax       = 85.05112878
+	geoLongMin      = -180.0
+	geoLongMax      = 180.0
+	geoMercatorMax  = 20037726.37
+	geoEarthRadiusM = 6372797.560856
+	geoAlphabet     = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+var geoLongRange = geoHashRange{min: geoLongMin, max: geoLongMax}
+var geoLatRange = geoHashRange{min: geoLatMin, max: geoLatMax}
+
+func geoDegToRad(degrees float64) float64 {
+	return degrees * (math.Pi / 180.0)
+}
+
+func geoRadToDeg(radians float64) float64 {
+	return radians / (math.Pi / 180.0)
+}
+
+// spreads the low 32 bits of x into the even bits of the result
+func geoSpreadBits(x uint32) uint64 {
+	v := uint64(x)
+	v = (v | (v << 16)) & 0x0000FFFF0000FFFF
+	v = (v | (v << 8)) & 0x00FF00FF00FF00FF
+	v = (v | (v << 4)) & 0x0F0F0F0F0F0F0F0F
+	v = (v | (v << 2)) & 0x3333333333333333
+	v = (v | (v << 1)) & 0x5555555555555555
+	return v
+}
+
+// collects the even bits of v into the low 32 bits of the result
+func geoSqueezeBits(v uint64) uint32 {
+	v &= 0x5555555555555555
+	v = (v | (v >> 1)) & 0x3333333333333333
+	v = (v | (v >> 2)) & 0x0F0F0F0F0F0F0F0F
+	v = (v | (v >> 4)) & 0x00FF00FF00FF00FF
+	v = (v | (v >> 8)) & 0x0000FFFF0000FFFF
+	v = (v | (v >> 16)) & 0x00000000FFFFFFFF
+	return uint32(v)
+}
+
+func geoLongLatValid(longitude, latitude float64) bool {
+	return longitude >= geoLongMin && longitude <= geoLongMax &&
+		latitude >= geoLatMin && latitude <= geoLatMax
+}
+
+func geoHashEncode(longRange, latRange geoHashRange, longitude, latitude float64, step uint) (hash geoHashBits, valid bool) {
+	if !geoLongLatValid(longitude, latitude) {
+		return
+	}
+	if latitude < latRange.min || latitude > latRange.max || longitude < longRange.min || longitude > longRange.max {
+		return
+	}
+
+	latOffset := (latitude - latRange.min) / (latRange.max - latRange.min)
+	longOffset := (longitude - longRange.min) / (longRange.max - longRange.min)
+
+	// convert to fixed point based on the step size
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+
+	hash.step = step
+	hash.bits = geoSpreadBits(uint32(latOffset)) | (geoSpreadBits(uint32(longOffset)) << 1)
+	valid = true
+	return
+}
+
+func geoHashDecode(longRange, latRange geoHashRange, hash geoHashBits) (area geoHashArea) {
+	area.hash = hash
+
+	latBits := float64(geoSqueezeBits(hash.bits))
+	longBits := float64(geoSqueezeBits(hash.bits >> 1))
+	divisor := float64(uint64(1) << hash.step)
+	latScale := latRange.max - latRange.min
+	longScale := longRange.max - longRange.min
+
+	area.latitude.min = latRange.min + (latBits/divisor)*latScale
+	area.latitude.max = latRange.min + ((latBits+1)/divisor)*latScale
+	area.longitude.min = longRange.min + (longBits/divisor)*longScale
+	area.longitude.max = longRange.min + ((longBits+1)/divisor)*longScale
+	return
+}
+
+func (area *geoHashArea) center() (longitude, latitude float64) {
+	longitude = (area.longitude.min + area.longitude.max) / 2
+	longitude = math.Min(math.Max(longitude, geoLongMin), geoLongMax)
+	latitude = (area.latitude.min + area.latitude.max) / 2
+	latitude = math.Min(math.Max(latitude, geoLatMin), geoLatMax)
+	return
+}
+
+// converts a coordinate to a sorted set score
+func geoScore(longitude, latitude float64) (score float64, valid bool) {
+	hash, valid := geoHashEncode(geoLongRange, geoLatRange, longitude, latitude, geoStepMax)
+	if valid {
+		score = float64(hash.align52())
+	}
+	return
+}
+
+// converts a sorted set score to the center of its geohash box
+func geoScoreToLongLat(score float64) (longitude, latitude float64) {
+	area := geoHashDecode(geoLongRange, geoLatRange, geoHashBits{bits: uint64(score), step: geoStepMax})
+	return area.center()
+}
+
+// makes the standard 11 character geohash string, which uses a latitude
+// range of +/-90 rather than the +/-85 used for scores
+func geoHashString(score float64) string {
+	longitude, latitude := geoScoreToLongLat(score)
+	hash, _ := geoHashEncode(geoHashRange{-180, 180}, geoHashRange{-90, 90}, longitude, latitude, geoStepMax)
+
+	var buf [11]byte
+	for i := range buf {
+		idx := 0
+		// only 52 bits are available; the last character is always zero
+		if i < 10 {
+			idx = int((hash.bits >> (52 - (uint(i)+1)*5)) & 0x1f)
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return string(buf[:])
+}
+
+func (hash geoHashBits) isZero() bool {
+	return hash.bits == 0 && hash.step == 0
+}
+
+func (hash geoHashBits) align52() uint64 {
+	return hash.bits << (52 - hash.step*2)
+}
+
+// the score range [min, max) of the members inside a geohash box
+func (hash geoHashBits) scoreRange() (min, max float64) {
+	min = float64(hash.align52())
+	hash.bits++
+	max = float64(hash.align52())
+	return
+}
+
+func (hash geoHashBits) moveX(d int) geoHashBits {
+	if d == 0 {
+		return hash
+	}
+
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - hash.step*2)
+
+	if d > 0 {
+		x = x + (zz + 1)
+	} else {
+		x = x | zz
+		x = x - (zz + 1)
+	}
+
+	x &= 0xaaaaaaaaaaaaaaaa >> (64 - hash.step*2)
+	hash.bits = x | y
+	return hash
+}
+
+func (hash geoHashBits) moveY(d int) geoHashBits {
+	if d == 0 {
+		return hash
+	}
+
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - hash.step*2)
+
+	if d > 0 {
+		y = y + (zz + 1)
+	} else {
+		y = y | zz
+		y = y - (zz + 1)
+	}
+
+	y &= 0x5555555555555555 >> (64 - hash.step*2)
+	hash.bits = x | y
+	return hash
+}
+
+func (hash geoHashBits) neighbors() (n geoHashNeighbors) {
+	n.east = hash.moveX(1)
+	n.west = hash.moveX(-1)
+	n.south = hash.moveY(-1)
+	n.north = hash.moveY(1)
+	n.northWest = hash.moveX(-1).moveY(1)
+	n.southWest = hash.moveX(-1).moveY(-1)
+	n.northEast = hash.moveX(1).moveY(1)
+	n.southEast = hash.moveX(1).moveY(-1)
+	return
+}
+
+func geoLatDistance(lat1, lat2 float64) float64 {
+	return geoEarthRadiusM * math.Abs(geoDegToRad(lat2)-geoDegToRad(lat1))
+}
+
+// computes the haversine distance in meters
+func geoDistance(long1, lat1, long2, lat2 float64) float64 {
+	long1r := geoDegToRad(long1)
+	long2r := geoDegToRad(long2)
+	v := math.Sin((long2r - long1r) / 2)
+
+	// the same longitude needs only the latitude distance
+	if v == 0 {
+		return geoLatDistance(lat1, lat2)
+	}
+
+	lat1r := geoDegToRad(lat1)
+	lat2r := geoDegToRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2.0 * geoEarthRadiusM * math.Asin(math.Sqrt(a))
+}
+
+// checks if a point is inside the shape, and provides its distance in
+// meters from the center
+func (shape *geoShape) contains(longitude, latitude float64) (distance float64, inside bool) {
+	if !shape.byBox {
+		distance = geoDistance(shape.longitude, shape.latitude, longitude, latitude)
+		inside = distance <= shape.radius*shape.conversion
+		return
+	}
+
+	// latitude distance is cheaper to compute, so check it first
+	if geoLatDistance(latitude, shape.latitude) > shape.height*shape.conversion/2 {
+		return
+	}
+	if geoDistance(longitude, latitude, shape.longitude, latitude) > shape.width*shape.conversion/2 {
+		return
+	}
+
+	distance = geoDistance(shape.longitude, shape.latitude, longitude, latitude)
+	inside = true
+	return
+}
+
+func geoEstimateStepsByRadius(rangeMeters, latitude float64) uint {
+	if rangeMeters == 0 {
+		return geoStepMax
+	}
+
+	step := 1
+	for rangeMeters < geoMercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	step -= 2 // make sure the range is included in most of the base cases
+
+	// boxes are wider towards the poles
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+
+	return uint(min(max(step, 1), geoStepMax))
+}
+
+// computes the bounding box of the shape as min longitude, min latitude,
+// max longitude, max latitude
+func (shape *geoShape) boundingBox() (bounds [4]float64) {
+	var height, width float64
+	if shape.byBox {
+		height = shape.conversion * shape.height / 2
+		width = shape.conversion * shape.width / 2
+	} else {
+		height = shape.conversion * shape.radius
+		width = height
+	}
+
+	latDelta := geoRadToDeg(height / geoEarthRadiusM)
+	longDeltaTop := geoRadToDeg(width / geoEarthRadiusM / math.Cos(geoDegToRad(shape.latitude+latDelta)))
+	longDeltaBottom := geoRadToDeg(width / geoEarthRadiusM / math.Cos(geoDegToRad(shape.latitude-latDelta)))
+
+	// the hemispheres are mirrored, so use the wider edge of the box
+	if shape.latitude < 0 {
+		bounds[0] = shape.longitude - longDeltaBottom
+		bounds[2] = shape.longitude + longDeltaBottom
+	} else {
+		bounds[0] = shape.longitude - longDeltaTop
+		bounds[2] = shape.longitude + longDeltaTop
+	}
+	bounds[1] = shape.latitude - latDelta
+	bounds[3] = shape.latitude + latDelta
+	return
+}
+
+// finds the center geohash box and its neighbors that cover the shape; the
+// neighbors that aren't needed are zeroed
+func (shape *geoShape) areas() (radius geoHashRadius) {
+	bounds := shape.boundingBox()
+	minLong, minLat, maxLong, maxLat := bounds[0], bounds[1], bounds[2], bounds[3]
+
+	// for a box, the radius is the distance from the center to a corner
+	radiusMeters := shape.radius
+	if shape.byBox {
+		radiusMeters = math.Sqrt((shape.width/2)*(shape.width/2) + (shape.height/2)*(shape.height/2))
+	}
+	radiusMeters *= shape.conversion
+
+	steps := geoEstimateStepsByRadius(radiusMeters, shape.latitude)
+
+	hash, _ := geoHashEncode(geoLongRange, geoLatRange, shape.longitude, shape.latitude, steps)
+	neighbors := hash.neighbors()
+	area := geoHashDecode(geoLongRange, geoLatRange, hash)
+
+	// the estimated step can be too large when the search area is near an
+	// edge of the center box
+	north := geoHashDecode(geoLongRange, geoLatRange, neighbors.north)
+	south := geoHashDecode(geoLongRange, geoLatRange, neighbors.south)
+	east := geoHashDecode(geoLongRange, geoLatRange, neighbors.east)
+	west := geoHashDecode(geoLongRange, geoLatRange, neighbors.west)
+
+	decreaseStep := north.latitude.max < maxLat || south.latitude.min > minLat ||
+		east.longitude.max < maxLong || west.longitude.min > minLong
+
+	if steps > 1 && decreaseStep {
+		steps--
+		hash, _ = geoHashEncode(geoLongRange, geoLatRange, shape.longitude, shape.latitude, steps)
+		neighbors = hash.neighbors()
+		area = geoHashDecode(geoLongRange, geoLatRange, hash)
+	}
+
+	// exclude the neighbors that are outside of the search area
+	if steps >= 2 {
+		if area.latitude.min < minLat {
+			neighbors.south = geoHashBits{}
+			neighbors.southWest = geoHashBits{}
+			neighbors.southEast = geoHashBits{}
+		}
+		if area.latitude.max > maxLat {
+			neighbors.north = geoHashBits{}
+			neighbors.northEast = geoHashBits{}
+			neighbors.northWest = geoHashBits{}
+		}
+		if area.longitude.min < minLong {
+			neighbors.west = geoHashBits{}
+			neighbors.southWest = geoHashBits{}
+			neighbors.northWest = geoHashBits{}
+		}
+		if area.longitude.max > maxLong {
+			neighbors.east = geoHashBits{}
+			neighbors.southEast = geoHashBits{}
+			neighbors.northEast = geoHashBits{}
+		}
+	}
+
+	radius.hash = hash
+	radius.neighbors = neighbors
+	radius.area = area
+	return
+}
+
+// lists the boxes to search, in the order Redis searches them
+func (radius *geoHashRadius) boxes() []geoHashBits {
+	n := &radius.neighbors
+	return []geoHashBits{
+		radius.hash,
+		n.north,
+		n.south,
+		n.east,
+		n.west,
+		n.northEast,
+		n.northWest,
+		n.southEast,
+		n.southWest,
+	}
+}
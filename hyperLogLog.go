@@ -0,0 +1,526 @@
+package redisemu
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// A HyperLogLog is stored as a string value, in the same byte format that
+// Redis uses, so that values can be exchanged with a real server and produce
+// the same estimates.
+//
+// The 16 byte header is "HYLL", the encoding, three unused bytes, and the
+// cached cardinality in little endian order. The high bit of the last
+// cardinality byte indicates the cached value is stale.
+//
+// The dense encoding is 16384 registers of 6 bits each. The sparse encoding
+// is a series of run length opcodes:
+//
+//	ZERO  00xxxxxx           xxxxxx+1 registers set to zero
+//	XZERO 01xxxxxx yyyyyyyy  xxxxxxyyyyyyyy+1 registers set to zero
+//	VAL   1vvvvvxx           xx+1 registers set to vvvvv+1
+
+const (
+	hllP           = 14
+	hllQ           = 64 - hllP
+	hllRegisters   = 1 << hllP
+	hllPMask       = hllRegisters - 1
+	hllBits        = 6
+	hllRegisterMax = (1 << hllBits) - 1
+	hllHeaderSize  = 16
+	hllDenseSize   = hllHeaderSize + (hllRegisters*hllBits+7)/8
+	hllHashSeed    = 0xadc83b19
+	hllAlphaInf    = 0.721347520444481703680
+
+	hllEncodingDense  = 0
+	hllEncodingSparse = 1
+
+	hllSparseValMaxValue = 32
+	hllSparseValMaxLen   = 4
+	hllSparseZeroMaxLen  = 64
+	hllSparseXZeroMaxLen = 16384
+
+	// the sparse encoding converts to dense when it grows beyond this
+	hllSparseMaxBytes = 3000
+)
+
+type (
+	hyperLogLog struct {
+		b []byte
+	}
+
+	hllHistogram [64]int
+)
+
+func newHyperLogLog() *hyperLogLog {
+	b := make([]byte, hllHeaderSize, hllHeaderSize+2)
+	copy(b, "HYLL")
+	b[4] = hllEncodingSparse
+
+	for remaining := hllRegisters; remaining > 0; {
+		length := min(remaining, hllSparseXZeroMaxLen)
+		b = hllSparseAppendZeros(b, length)
+		remaining -= length
+	}
+
+	return &hyperLogLog{b: b}
+}
+
+// interprets a string value as a HyperLogLog; the caller must pass a copy
+// of the stored bytes if the HyperLogLog will be modified
+func asHyperLogLog(b []byte) (h *hyperLogLog, valid bool) {
+	if len(b) < hllHeaderSize || string(b[0:4]) != "HYLL" {
+		return
+	}
+
+	switch b[4] {
+	case hllEncodingDense:
+		if len(b) != hllDenseSize {
+			return
+		}
+	case hllEncodingSparse:
+	default:
+		return
+	}
+
+	return &hyperLogLog{b: b}, true
+}
+
+func hllPatternLen(element []byte) (index int, count uint8) {
+	hash := murmurHash64A(element, hllHashSeed)
+	index = int(hash & hllPMask)
+
+	// count the run of zeros after the register index bits, plus one; the
+	// extra bit ensures the count can't exceed hllQ+1
+	hash >>= hllP
+	hash |= 1 << hllQ
+	count = uint8(bits.TrailingZeros64(hash) + 1)
+	return
+}
+
+func hllDenseGet(registers []byte, index int) uint8 {
+	bit := index * hllBits
+	pos := bit / 8
+	shift := uint(bit & 7)
+
+	v := uint(registers[pos]) >> shift
+	if pos+1 < len(registers) {
+		v |= uint(registers[pos+1]) << (8 - shift)
+	}
+	return uint8(v & hllRegisterMax)
+}
+
+func hllDenseSetRegister(registers []byte, index int, value uint8) {
+	bit := index * hllBits
+	pos := bit / 8
+	shift := uint(bit & 7)
+
+	registers[pos] &^= byte(uint(hllRegisterMax) << shift)
+	registers[pos] |= byte(uint(value) << shift)
+	if pos+1 < len(registers) {
+		registers[pos+1] &^= byte(uint(hllRegisterMax) >> (8 - shift))
+		registers[pos+1] |= byte(uint(value) >> (8 - shift))
+	}
+}
+
+func hllSparseIsZero(op byte) bool {
+	return op&0xc0 == 0x00
+}
+
+func hllSparseIsXZero(op byte) bool {
+	return op&0xc0 == 0x40
+}
+
+func hllSparseIsVal(op byte) bool {
+	return op&0x80 != 0
+}
+
+func hllSparseZeroLen(op byte) int {
+	return int(op&0x3f) + 1
+}
+
+func hllSparseXZeroLen(op, op2 byte) int {
+	return (int(op&0x3f)<<8 | int(op2)) + 1
+}
+
+func hllSparseValValue(op byte) uint8 {
+	return (op>>2)&0x1f + 1
+}
+
+func hllSparseValLen(op byte) int {
+	return int(op&0x3) + 1
+}
+
+func hllSparseVal(value uint8, length int) byte {
+	return byte(int(value-1)<<2|(length-1)) | 0x80
+}
+
+// appends a ZERO or XZERO opcode, according to the length
+func hllSparseAppendZeros(b []byte, length int) []byte {
+	if length > hllSparseZeroMaxLen {
+		length--
+		return append(b, byte(length>>8)|0x40, byte(length))
+	}
+	return append(b, byte(length-1))
+}
+
+// walks the sparse opcodes; valid is false if they don't describe exactly
+// the full set of registers
+func (h *hyperLogLog) sparseRuns(fn func(first, length int, value uint8)) (valid bool) {
+	sparse := h.b[hllHeaderSize:]
+	index := 0
+
+	for p := 0; p < len(sparse); {
+		op := sparse[p]
+		var length int
+		var value uint8
+
+		if hllSparseIsZero(op) {
+			length = hllSparseZeroLen(op)
+			p++
+		} else if hllSparseIsXZero(op) {
+			if p+1 >= len(sparse) {
+				return
+			}
+			length = hllSparseXZeroLen(op, sparse[p+1])
+			p += 2
+		} else {
+			length = hllSparseValLen(op)
+			value = hllSparseValValue(op)
+			p++
+		}
+
+		if index+length > hllRegisters {
+			return
+		}
+		fn(index, length, value)
+		index += length
+	}
+
+	valid = (index == hllRegisters)
+	return
+}
+
+func (h *hyperLogLog) isDense() bool {
+	return h.b[4] == hllEncodingDense
+}
+
+func (h *hyperLogLog) cachedCardinality() (card uint64, valid bool) {
+	if h.b[15]&0x80 != 0 {
+		return
+	}
+	return binary.LittleEndian.Uint64(h.b[8:16]), true
+}
+
+func (h *hyperLogLog) setCachedCardinality(card uint64) {
+	binary.LittleEndian.PutUint64(h.b[8:16], card)
+}
+
+func (h *hyperLogLog) invalidateCache() {
+	h.b[15] |= 0x80
+}
+
+// converts the sparse encoding to dense
+func (h *hyperLogLog) toDense() (valid bool) {
+	if h.isDense() {
+		return true
+	}
+
+	dense := make([]byte, hllDenseSize)
+	copy(dense, h.b[:hllHeaderSize])
+	dense[4] = hllEncodingDense
+	registers := dense[hllHeaderSize:]
+
+	valid = h.sparseRuns(func(first, length int, value uint8) {
+		if value != 0 {
+			for index := first; index < first+length; index++ {
+				hllDenseSetRegister(registers, index, value)
+			}
+		}
+	})
+
+	if valid {
+		h.b = dense
+	}
+	return
+}
+
+// hashes an element into its register; updated is true if the register
+// value increased
+func (h *hyperLogLog) add(element []byte) (updated, valid bool) {
+	index, count := hllPatternLen(element)
+	return h.set(index, count)
+}
+
+// raises a register to at least count; updated is true if the register
+// value increased
+func (h *hyperLogLog) set(index int, count uint8) (updated, valid bool) {
+	if h.isDense() {
+		return h.denseSet(index, count), true
+	}
+	return h.sparseSet(index, count)
+}
+
+func (h *hyperLogLog) denseSet(index int, count uint8) (updated bool) {
+	registers := h.b[hllHeaderSize:]
+	if hllDenseGet(registers, index) < count {
+		hllDenseSetRegister(registers, index, count)
+		updated = true
+	}
+	return
+}
+
+func (h *hyperLogLog) promoteAndSet(index int, count uint8) (updated, valid bool) {
+	if !h.toDense() {
+		return
+	}
+	return h.denseSet(index, count), true
+}
+
+func (h *hyperLogLog) sparseSet(index int, count uint8) (updated, valid bool) {
+	// a VAL opcode can't hold large counts
+	if count > hllSparseValMaxValue {
+		return h.promoteAndSet(index, count)
+	}
+
+	// find the opcode that covers the register
+	sparse := h.b[hllHeaderSize:]
+	p := 0
+	prev := -1
+	first := 0
+	span := 0
+	for p < len(sparse) {
+		op := sparse[p]
+		oplen := 1
+		if hllSparseIsZero(op) {
+			span = hllSparseZeroLen(op)
+		} else if hllSparseIsVal(op) {
+			span = hllSparseValLen(op)
+		} else {
+			if p+1 >= len(sparse) {
+				return
+			}
+			span = hllSparseXZeroLen(op, sparse[p+1])
+			oplen = 2
+		}
+
+		if index <= first+span-1 {
+			break
+		}
+		prev = p
+		p += oplen
+		first += span
+	}
+	if span == 0 || p >= len(sparse) {
+		return
+	}
+	valid = true
+
+	op := sparse[p]
+	isVal := hllSparseIsVal(op)
+	isXZero := hllSparseIsXZero(op)
+
+	// update single register opcodes in place
+	if isVal {
+		if hllSparseValValue(op) >= count {
+			return
+		}
+		if span == 1 {
+			sparse[p] = hllSparseVal(count, 1)
+			h.sparseMerge(prev)
+			updated = true
+			return
+		}
+	} else if !isXZero && span == 1 {
+		sparse[p] = hllSparseVal(count, 1)
+		h.sparseMerge(prev)
+		updated = true
+		return
+	}
+
+	// split the run into up to three opcodes
+	seq := make([]byte, 0, 5)
+	last := first + span - 1
+	if isVal {
+		value := hllSparseValValue(op)
+		if index != first {
+			seq = append(seq, hllSparseVal(value, index-first))
+		}
+		seq = append(seq, hllSparseVal(count, 1))
+		if index != last {
+			seq = append(seq, hllSparseVal(value, last-index))
+		}
+	} else {
+		if index != first {
+			seq = hllSparseAppendZeros(seq, index-first)
+		}
+		seq = append(seq, hllSparseVal(count, 1))
+		if index != last {
+			seq = hllSparseAppendZeros(seq, last-index)
+		}
+	}
+
+	oldLen := 1
+	if isXZero {
+		oldLen = 2
+	}
+	delta := len(seq) - oldLen
+	if delta > 0 && len(h.b)+delta > hllSparseMaxBytes {
+		return h.promoteAndSet(index, count)
+	}
+
+	pos := hllHeaderSize + p
+	b := make([]byte, 0, len(h.b)+delta)
+	b = append(b, h.b[:pos]...)
+	b = append(b, seq...)
+	b = append(b, h.b[pos+oldLen:]...)
+	h.b = b
+
+	h.sparseMerge(prev)
+	updated = true
+	return
+}
+
+// combines adjacent VAL opcodes of the same value, looking at up to five
+// opcodes starting at the one before the change, the same as Redis
+func (h *hyperLogLog) sparseMerge(prev int) {
+	p := hllHeaderSize
+	if prev >= 0 {
+		p += prev
+	}
+
+	for scan := 0; p < len(h.b) && scan < 5; scan++ {
+		op := h.b[p]
+		if hllSparseIsXZero(op) {
+			p += 2
+			continue
+		} else if hllSparseIsZero(op) {
+			p++
+			continue
+		}
+
+		if p+1 < len(h.b) && hllSparseIsVal(h.b[p+1]) {
+			value := hllSparseValValue(op)
+			if value == hllSparseValValue(h.b[p+1]) {
+				length := hllSparseValLen(op) + hllSparseValLen(h.b[p+1])
+				if length <= hllSparseValMaxLen {
+					h.b[p+1] = hllSparseVal(value, length)
+					h.b = append(h.b[:p], h.b[p+1:]...)
+					continue
+				}
+			}
+		}
+		p++
+	}
+}
+
+// folds the registers into max, keeping the larger of each
+func (h *hyperLogLog) mergeInto(max []uint8) (valid bool) {
+	if h.isDense() {
+		registers := h.b[hllHeaderSize:]
+		for index := range max {
+			if value := hllDenseGet(registers, index); value > max[index] {
+				max[index] = value
+			}
+		}
+		return true
+	}
+
+	return h.sparseRuns(func(first, length int, value uint8) {
+		if value != 0 {
+			for index := first; index < first+length; index++ {
+				if value > max[index] {
+					max[index] = value
+				}
+			}
+		}
+	})
+}
+
+// replaces registers that are smaller than the corresponding max value
+func (h *hyperLogLog) setRegisters(max []uint8) (valid bool) {
+	for index, value := range max {
+		if value != 0 {
+			if _, valid = h.set(index, value); !valid {
+				return
+			}
+		}
+	}
+	return true
+}
+
+func (h *hyperLogLog) count() (card uint64, valid bool) {
+	var histo hllHistogram
+	if h.isDense() {
+		registers := h.b[hllHeaderSize:]
+		for index := 0; index < hllRegisters; index++ {
+			histo[hllDenseGet(registers, index)]++
+		}
+	} else if !h.sparseRuns(func(first, length int, value uint8) {
+		histo[value] += length
+	}) {
+		return
+	}
+
+	return histo.estimate(), true
+}
+
+func hllCountRegisters(max []uint8) uint64 {
+	var histo hllHistogram
+	for _, value := range max {
+		histo[value]++
+	}
+	return histo.estimate()
+}
+
+// computes the cardinality with the estimator from Otmar Ertl's "New
+// cardinality estimation algorithms for HyperLogLog sketches", as Redis does
+func (histo *hllHistogram) estimate() uint64 {
+	m := float64(hllRegisters)
+
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
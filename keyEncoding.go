@@ -0,0 +1,341 @@
+package redisemu
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The emulator keeps every type in a single representation, but tracks the
+// encoding that Redis would use for a value, so that OBJECT ENCODING reports
+// the conversions from the compact encodings at the configured thresholds.
+// Like Redis, a hash, set or sorted set doesn't go back to a compact encoding
+// once it has been converted; a list does when it shrinks to half the limit.
+const (
+	encodingCompact  keyEncoding = iota // int or embstr, listpack, or intset
+	encodingListpack                    // a set that is no longer an intset
+	encodingFull                        // raw, quicklist, hashtable or skiplist
+)
+
+// a string that was modified in place, or made from a float, isn't
+// reencoded by its content
+const (
+	stringAuto keyStringMode = iota
+	stringRaw
+	stringNoInt
+)
+
+// the LFU counter of a new key, so that it isn't evicted right away
+const lfuInitVal = 5
+
+// the integers from 0 that Redis keeps as shared objects
+const sharedIntegers = 10000
+
+// the LRU clock of Redis is the time in seconds, in 24 bits
+const lruClockMax = 1<<24 - 1
+
+type (
+	keyEncoding   uint8
+	keyStringMode uint8
+
+	// keyObjectInfo holds the properties of a key reported by OBJECT
+	keyObjectInfo struct {
+		encoding string
+		idle     time.Duration
+		freq     int
+		refCount int
+	}
+)
+
+// the size limit in bytes of a list-max-listpack-size from -1 to -5
+var listpackSizeLimits = []int64{4096, 8192, 16384, 32768, 65536}
+
+// gets a configuration value; a standalone data store uses the defaults
+func (ds *dataStore) configString(name string) string {
+	if ds.dss != nil {
+		return ds.dss.config.get(name)
+	}
+	return configParams[name].def
+}
+
+func (ds *dataStore) configInt(name string) int64 {
+	n, _ := strconv.ParseInt(ds.configString(name), 10, 64)
+	return n
+}
+
+// determines if a string would be stored by Redis as an integer
+func isIntEncodable(value []byte) bool {
+	if len(value) == 0 || len(value) > 20 {
+		return false
+	}
+	n, err := strconv.ParseInt(string(value), 10, 64)
+	return err == nil && strconv.FormatInt(n, 10) == string(value)
+}
+
+// applies the conversion rules to the current content of a key, after a
+// command has changed it (event is the keyspace event), or before the
+// encoding is reported (event is empty)
+func (ds *dataStore) updateEncodingUnlocked(sk *storeKey, event string) {
+	switch {
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_STRING):
+		switch event {
+		case "append", "setrange", "setbit", "pfadd":
+			sk.stringMode = stringRaw
+		case "incrbyfloat":
+			sk.stringMode = stringNoInt
+		case "set", "incrby":
+			sk.stringMode = stringAuto
+		}
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_LIST):
+		sk.encoding = ds.listEncodingUnlocked(sk)
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_HASH_TABLE):
+		if sk.encoding == encodingCompact {
+			entries := ds.configInt("hash-max-listpack-entries")
+			maxLen := ds.configInt("hash-max-listpack-value")
+			hash := sk.getHashTable()
+			if int64(hash.count) > entries {
+				sk.encoding = encodingFull
+			} else {
+				iter := hash.createIterator()
+				for iter.next() {
+					if int64(len(iter.key)) > maxLen || int64(len(iter.value.(string))) > maxLen {
+						sk.encoding = encodingFull
+						break
+					}
+				}
+			}
+		}
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_SET):
+		set := sk.getSet()
+		if sk.encoding == encodingCompact {
+			if int64(set.count) > ds.configInt("set-max-intset-entries") {
+				sk.encoding = encodingListpack
+			} else {
+				iter := set.createIterator()
+				for iter.next() {
+					if !isIntEncodable([]byte(iter.key)) {
+						sk.encoding = encodingListpack
+						break
+					}
+				}
+			}
+		}
+		if sk.encoding == encodingListpack {
+			maxLen := ds.configInt("set-max-listpack-value")
+			if int64(set.count) > ds.configInt("set-max-listpack-entries") {
+				sk.encoding = encodingFull
+			} else {
+				iter := set.createIterator()
+				for iter.next() {
+					if int64(len(iter.key)) > maxLen {
+						sk.encoding = encodingFull
+						break
+					}
+				}
+			}
+		}
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_ZSET):
+		if sk.encoding == encodingCompact {
+			maxLen := ds.configInt("zset-max-listpack-value")
+			z := sk.getZSet()
+			if int64(z.count()) > ds.configInt("zset-max-listpack-entries") {
+				sk.encoding = encodingFull
+			} else {
+				z.iterate(false, func(member string, score float64) bool {
+					if int64(len(member)) > maxLen {
+						sk.encoding = encodingFull
+						return false
+					}
+					return true
+				})
+			}
+		}
+	}
+}
+
+// a list is a listpack while it is within list-max-listpack-size, which is an
+// element count when positive, or a size class when negative; a quicklist
+// converts back at half the limit
+func (ds *dataStore) listEncodingUnlocked(sk *storeKey) keyEncoding {
+	list := sk.getList()
+	limit := ds.configInt("list-max-listpack-size")
+
+	var size int64
+	if limit > 0 {
+		size = int64(list.count)
+	} else {
+		// approximates the listpack header and the per-element overhead
+		size = 7
+		for item := list.head; item != nil; item = item.next {
+			size += int64(len(item.element)) + 2
+		}
+		limit = listpackSizeLimits[min(max(-limit, 1), 5)-1]
+	}
+
+	if sk.encoding == encodingFull {
+		if size <= limit/2 {
+			return encodingCompact
+		}
+	} else if size > limit {
+		return encodingFull
+	}
+	return sk.encoding
+}
+
+// gets the OBJECT ENCODING name of a key
+func (ds *dataStore) encodingNameUnlocked(sk *storeKey) string {
+	ds.updateEncodingUnlocked(sk, "")
+
+	switch {
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_STRING):
+		value := sk.getStringBytes()
+		if sk.stringMode == stringAuto && isIntEncodable(value) {
+			return "int"
+		}
+		if sk.stringMode != stringRaw && len(value) <= 44 {
+			return "embstr"
+		}
+		return "raw"
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_LIST):
+		if sk.encoding == encodingFull {
+			return "quicklist"
+		}
+		return "listpack"
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_HASH_TABLE):
+		if sk.encoding == encodingFull {
+			return "hashtable"
+		}
+		if len(sk.fieldExpiresAt) > 0 {
+			return "listpackex"
+		}
+		return "listpack"
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_SET):
+		switch sk.encoding {
+		case encodingCompact:
+			return "intset"
+		case encodingListpack:
+			return "listpack"
+		}
+		return "hashtable"
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_ZSET):
+		if sk.encoding == encodingFull {
+			return "skiplist"
+		}
+		return "listpack"
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_STREAM):
+		return "stream"
+	}
+	return "raw"
+}
+
+// decays the LFU counter by one for every lfu-decay-time minutes since the
+// last decrement, like Redis
+func (ds *dataStore) lfuDecayUnlocked(sk *storeKey, now time.Time) uint8 {
+	decayTime := ds.configInt("lfu-decay-time")
+	if decayTime > 0 {
+		periods := int64(now.Sub(sk.lfuDecayedAt).Minutes()) / decayTime
+		if periods > 0 {
+			if periods >= int64(sk.lfuCounter) {
+				sk.lfuCounter = 0
+			} else {
+				sk.lfuCounter -= uint8(periods)
+			}
+			sk.lfuDecayedAt = sk.lfuDecayedAt.Add(time.Duration(periods*decayTime) * time.Minute)
+		}
+	}
+	return sk.lfuCounter
+}
+
+// records an access to a key for OBJECT IDLETIME and OBJECT FREQ; the LFU
+// counter grows logarithmically, so that it takes about a million accesses
+// to saturate it with the default lfu-log-factor
+func (ds *dataStore) touchUnlocked(sk *storeKey) {
+	now := time.Now()
+	sk.lastAccess = now
+
+	counter := ds.lfuDecayUnlocked(sk, now)
+	if counter < 255 {
+		base := float64(max(int(counter)-lfuInitVal, 0))
+		p := 1.0 / (base*float64(ds.configInt("lfu-log-factor")) + 1)
+		if rand.Float64() < p {
+			sk.lfuCounter++
+		}
+	}
+}
+
+// determines if the maxmemory-policy tracks access frequency rather than time
+func (ds *dataStore) isLfuPolicy() bool {
+	return strings.HasSuffix(ds.configString("maxmemory-policy"), "-lfu")
+}
+
+// provides the properties reported by OBJECT, without counting as an access
+func (dsc *dataStoreCommand) objectInfo(keyName string) (info keyObjectInfo, exists bool) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	val, exists := dsc.ds.data.get(keyName)
+	if !exists {
+		return
+	}
+	sk := val.(*storeKey)
+	if sk.isExpiredUnlocked() {
+		exists = false
+		return
+	}
+
+	info.encoding = dsc.ds.encodingNameUnlocked(sk)
+	info.idle = time.Since(sk.lastAccess)
+	info.freq = int(dsc.ds.lfuDecayUnlocked(sk, time.Now()))
+
+	// like Redis, small integers are shared objects, unless the eviction
+	// policy needs per-key access data
+	info.refCount = 1
+	if info.encoding == "int" {
+		n, _ := strconv.ParseInt(string(sk.getStringBytes()), 10, 64)
+		policy := dsc.ds.configString("maxmemory-policy")
+		perKey := dsc.ds.configInt("maxmemory") > 0 && (strings.HasSuffix(policy, "-lru") || strings.HasSuffix(policy, "-lfu"))
+		if n >= 0 && n < sharedIntegers && !perKey {
+			info.refCount = math.MaxInt32
+		}
+	}
+	return
+}
+
+// describes a key as DEBUG OBJECT does; the serialized length is the size of
+// the value in the persistence format, and the address is the object id
+func (dsc *dataStoreCommand) debugObject(keyName string) (text string, exists bool) {
+	info, exists := dsc.objectInfo(keyName)
+	if !exists {
+		return
+	}
+
+	dsc.lock()
+	defer dsc.unlock()
+
+	val, exists := dsc.ds.data.get(keyName)
+	if !exists {
+		return
+	}
+	sk := val.(*storeKey)
+
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(persistPayload(sk))
+
+	text = fmt.Sprintf("Value at:0x%x refcount:%d encoding:%s serializedlength:%d lru:%d lru_seconds_idle:%d",
+		sk.id, info.refCount, info.encoding, buf.Len(), sk.lastAccess.Unix()&lruClockMax, int64(info.idle.Seconds()))
+	return
+}
@@ -0,0 +1,220 @@
+package redisemu
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// keyspace event classes, as configured by notify-keyspace-events
+const (
+	NOTIFY_KEYSPACE bitflags = 1 << iota // K
+	NOTIFY_KEYEVENT                      // E
+	NOTIFY_GENERIC                       // g
+	NOTIFY_STRING                        // $
+	NOTIFY_LIST                          // l
+	NOTIFY_SET                           // s
+	NOTIFY_HASH                          // h
+	NOTIFY_ZSET                          // z
+	NOTIFY_EXPIRED                       // x
+	NOTIFY_EVICTED                       // e
+	NOTIFY_STREAM                        // t
+	NOTIFY_MODULE                        // d (accepted, but there are no module types)
+	NOTIFY_KEY_MISS                      // m
+	NOTIFY_NEW                           // n
+)
+
+// the classes selected by 'A'; like redis, key miss and new key events
+// must be requested explicitly
+const NOTIFY_ALL = NOTIFY_GENERIC | NOTIFY_STRING | NOTIFY_LIST | NOTIFY_SET | NOTIFY_HASH |
+	NOTIFY_ZSET | NOTIFY_EXPIRED | NOTIFY_EVICTED | NOTIFY_STREAM | NOTIFY_MODULE
+
+var keyspaceEventClasses = []struct {
+	ch   byte
+	flag bitflags
+}{
+	{'g', NOTIFY_GENERIC},
+	{'$', NOTIFY_STRING},
+	{'l', NOTIFY_LIST},
+	{'s', NOTIFY_SET},
+	{'h', NOTIFY_HASH},
+	{'z', NOTIFY_ZSET},
+	{'x', NOTIFY_EXPIRED},
+	{'e', NOTIFY_EVICTED},
+	{'t', NOTIFY_STREAM},
+	{'d', NOTIFY_MODULE},
+	{'K', NOTIFY_KEYSPACE},
+	{'E', NOTIFY_KEYEVENT},
+	{'m', NOTIFY_KEY_MISS},
+	{'n', NOTIFY_NEW},
+}
+
+// parses a notify-keyspace-events string such as "KEA" or "Kx"
+func parseKeyspaceEvents(text string) (flags bitflags, valid bool) {
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		if ch == 'A' {
+			flags |= NOTIFY_ALL
+			continue
+		}
+
+		found := false
+		for _, class := range keyspaceEventClasses {
+			if class.ch == ch {
+				flags |= class.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return
+		}
+	}
+
+	valid = true
+	return
+}
+
+// renders keyspace event flags in the canonical form CONFIG GET reports
+func keyspaceEventsString(flags bitflags) string {
+	var sb strings.Builder
+	for _, class := range keyspaceEventClasses {
+		if flagHasAll(flags, NOTIFY_ALL) && flagHasOne(NOTIFY_ALL, class.flag) {
+			if class.flag == NOTIFY_GENERIC {
+				sb.WriteByte('A')
+			}
+			continue
+		}
+		if flagHasOne(flags, class.flag) {
+			sb.WriteByte(class.ch)
+		}
+	}
+	return sb.String()
+}
+
+func (dss *dataStoreSet) keyspaceEventFlags() bitflags {
+	return bitflags(atomic.LoadUint32(&dss.keyspaceEvents))
+}
+
+func (dss *dataStoreSet) setKeyspaceEventFlags(flags bitflags) {
+	atomic.StoreUint32(&dss.keyspaceEvents, uint32(flags))
+}
+
+// publishes a keyspace event for a key of this data store to the
+// __keyspace@<db>__:<key> and __keyevent@<db>__:<event> channels,
+// according to the notify-keyspace-events setting; a change also
+// invalidates the key for tracking clients, where origin is the client
+// that made the change, for NOLOOP
+func (ds *dataStore) notifyUnlocked(class bitflags, event, keyName string, origin *clientState) {
+	// every event other than a key miss or key creation is a change to the data
+	isChange := !flagHasOne(class, NOTIFY_KEY_MISS|NOTIFY_NEW)
+	if isChange {
+		if val, exists := ds.data.get(keyName); exists {
+			ds.updateEncodingUnlocked(val.(*storeKey), event)
+			ds.accountKeyUnlocked(keyName, val.(*storeKey))
+		}
+	}
+
+	if ds.dss == nil {
+		return
+	}
+
+	if isChange {
+		ds.dss.addChanges(1)
+
+		// like redis, a key removed by expiration or eviction isn't
+		// changed by the client that found it
+		if flagHasOne(class, NOTIFY_EXPIRED|NOTIFY_EVICTED) {
+			origin = nil
+		}
+		ds.dss.trackingInvalidateKey(keyName, origin)
+	}
+
+	flags := ds.dss.keyspaceEventFlags()
+	if !flagHasOne(flags, class) {
+		return
+	}
+
+	if flagHasOne(flags, NOTIFY_KEYSPACE) {
+		ds.dss.publishMessage(fmt.Sprintf("__keyspace@%d__:%s", ds.index, keyName), event)
+	}
+	if flagHasOne(flags, NOTIFY_KEYEVENT) {
+		ds.dss.publishMessage(fmt.Sprintf("__keyevent@%d__:%s", ds.index, event), keyName)
+	}
+}
+
+func (dsc *dataStoreCommand) notifyUnlocked(class bitflags, event, keyName string) {
+	dsc.ds.notifyUnlocked(class, event, keyName, dsc.origin)
+}
+
+// like redis, key misses are reported and counted only for the lookups of
+// read-only commands
+func (dsc *dataStoreCommand) notifyMissUnlocked(keyName string) {
+	if dsc.readOnly {
+		if dsc.ds.dss != nil {
+			dsc.ds.dss.recordKeyspaceLookup(false)
+		}
+		dsc.ds.notifyUnlocked(NOTIFY_KEY_MISS, "keymiss", keyName, nil)
+	}
+}
+
+// sends the keyspace event for an element removal, followed by "del" if the
+// removal emptied the key
+func (dsc *dataStoreCommand) notifyRemovalUnlocked(class bitflags, event, keyName string, remaining int) {
+	dsc.notifyUnlocked(class, event, keyName)
+	if remaining == 0 {
+		dsc.notifyUnlocked(NOTIFY_GENERIC, "del", keyName)
+	}
+}
+
+// deletes the keys and hash fields whose TTL has passed, so that listeners
+// receive "expired" and "hexpired" events without waiting for an access;
+// the scan is skipped while keyspace notifications are off, or when DEBUG
+// SET-ACTIVE-EXPIRE has disabled it
+func (dss *dataStoreSet) activeExpire() {
+	if !flagHasOne(dss.keyspaceEventFlags(), NOTIFY_KEYSPACE|NOTIFY_KEYEVENT) || atomic.LoadUint32(&dss.noActiveExpire) != 0 {
+		return
+	}
+
+	dss.mu.Lock()
+	dbs := make([]*dataStore, 0, len(dss.dbs))
+	for _, ds := range dss.dbs {
+		dbs = append(dbs, ds)
+	}
+	dss.mu.Unlock()
+
+	started := time.Now()
+	for _, ds := range dbs {
+		ds.newDataStoreCommand().activeExpire()
+	}
+	dss.addLatencySampleIfNeeded("expire-cycle", time.Since(started))
+}
+
+func (dsc *dataStoreCommand) activeExpire() {
+	dsc.lock()
+	defer dsc.unlock()
+
+	now := time.Now()
+	keyNames := []string{}
+	for it := dsc.ds.data.createIterator(); it.next(); {
+		sk := it.value.(*storeKey)
+		if now.After(sk.expiresAt) {
+			keyNames = append(keyNames, it.key)
+			continue
+		}
+		for _, expiresAt := range sk.fieldExpiresAt {
+			if now.After(expiresAt) {
+				keyNames = append(keyNames, it.key)
+				break
+			}
+		}
+	}
+
+	// the lookup sends the events
+	for _, keyName := range keyNames {
+		if _, exists := dsc.getKeyObjectUnlocked(keyName); !exists {
+			dsc.ds.removeKeyUnlocked(keyName)
+		}
+	}
+}
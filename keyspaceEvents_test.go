@@ -0,0 +1,304 @@
+package redisemu
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makes a client subscribed to every keyspace and keyevent channel of db 0,
+// and enables the notify-keyspace-events classes
+func newKeyspaceListener(t *testing.T, ts RedisTestClient, classes string) RedisTestClient {
+	output := ts.ProcessCommand("config", "set", "notify-keyspace-events", classes)
+	if !output.isString("OK") {
+		t.Fatal("config set notify-keyspace-events fail")
+	}
+
+	listener := ts.AdditionalClient()
+	output = listener.ProcessCommand("psubscribe", "__key*@0__:*")
+	if !isPubSubMessage(output, "psubscribe", "__key*@0__:*", 1) {
+		t.Fatal("keyspace psubscribe fail")
+	}
+	return listener
+}
+
+// gets the pending keyspace notifications as "<channel> <message>" strings
+func keyspaceNotifications(listener RedisTestClient) []string {
+	notifications := []string{}
+	for _, push := range listener.PendingPushes() {
+		// RESP2 clients receive the push as an array
+		a, valid := push.data.(respArray)
+		if p, isPush := push.data.(respPush); isPush {
+			a = append(respArray{{data: respBulkString(p.kind)}}, p.data...)
+			valid = true
+		}
+		if !valid || len(a) != 4 {
+			continue
+		}
+		if kind, _ := a[0].toString(); kind != "pmessage" {
+			continue
+		}
+		channel, _ := a[2].toString()
+		message, _ := a[3].toString()
+		notifications = append(notifications, channel+" "+message)
+	}
+	return notifications
+}
+
+func expectNotifications(t *testing.T, listener RedisTestClient, what string, expected ...string) {
+	t.Helper()
+	notifications := keyspaceNotifications(listener)
+	if !slices.Equal(notifications, expected) {
+		t.Fatalf("%s notifications: got %s, expected %s", what, strings.Join(notifications, ", "), strings.Join(expected, ", "))
+	}
+}
+
+func TestKeyspaceEventsConfig(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("config", "get", "notify-keyspace-events")
+	if !output.isMap(map[any]any{"notify-keyspace-events": ""}) {
+		t.Fatal("config get default fail")
+	}
+
+	output = ts.ProcessCommand("config", "set", "notify-keyspace-events", "KEA")
+	if !output.isString("OK") {
+		t.Fatal("config set KEA fail")
+	}
+
+	// the value is reported in canonical form
+	output = ts.ProcessCommand("config", "get", "notify-*")
+	if !output.isMap(map[any]any{"notify-keyspace-events": "AKE"}) {
+		t.Fatal("config get AKE fail")
+	}
+
+	output = ts.ProcessCommand("config", "set", "notify-keyspace-events", "Ex$lmn")
+	if !output.isString("OK") {
+		t.Fatal("config set Ex$lmn fail")
+	}
+
+	output = ts.ProcessCommand("config", "get", "notify-keyspace-events")
+	if !output.isMap(map[any]any{"notify-keyspace-events": "$lxEmn"}) {
+		t.Fatal("config get $lxEmn fail")
+	}
+
+	output = ts.ProcessCommand("config", "set", "notify-keyspace-events", "KEq")
+	if !output.isErrorString("ERR CONFIG SET failed (possibly related to argument 'notify-keyspace-events') - Invalid event class character. Use 'Ag$lshzxeKEtmdn'.") {
+		t.Fatal("config set invalid class fail")
+	}
+
+	output = ts.ProcessCommand("config", "set", "no-such-parameter", "1")
+	if !output.isErrorString("ERR Unknown option or number of arguments for CONFIG SET - 'no-such-parameter'") {
+		t.Fatal("config set unknown fail")
+	}
+
+	output = ts.ProcessCommand("config", "get", "no-such-*")
+	if !output.isMap(map[any]any{}) {
+		t.Fatal("config get unknown fail")
+	}
+}
+
+func TestKeyspaceEventsGeneric(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	listener := newKeyspaceListener(t, ts, "KEA")
+	defer listener.Close()
+
+	ts.ProcessCommand("set", "k", "v", "ex", "100")
+	expectNotifications(t, listener, "set ex",
+		"__keyspace@0__:k set", "__keyevent@0__:set k",
+		"__keyspace@0__:k expire", "__keyevent@0__:expire k")
+
+	ts.ProcessCommand("persist", "k")
+	expectNotifications(t, listener, "persist",
+		"__keyspace@0__:k persist", "__keyevent@0__:persist k")
+
+	ts.ProcessCommand("rename", "k", "k2")
+	expectNotifications(t, listener, "rename",
+		"__keyspace@0__:k rename_from", "__keyevent@0__:rename_from k",
+		"__keyspace@0__:k2 rename_to", "__keyevent@0__:rename_to k2")
+
+	ts.ProcessCommand("copy", "k2", "k3")
+	expectNotifications(t, listener, "copy",
+		"__keyspace@0__:k3 copy_to", "__keyevent@0__:copy_to k3")
+
+	ts.ProcessCommand("del", "k2", "k3", "missing")
+	expectNotifications(t, listener, "del",
+		"__keyspace@0__:k2 del", "__keyevent@0__:del k2",
+		"__keyspace@0__:k3 del", "__keyevent@0__:del k3")
+
+	ts.ProcessCommand("incrby", "n", "5")
+	ts.ProcessCommand("append", "n", "0")
+	expectNotifications(t, listener, "string",
+		"__keyspace@0__:n incrby", "__keyevent@0__:incrby n",
+		"__keyspace@0__:n append", "__keyevent@0__:append n")
+
+	// reads and failed writes don't notify
+	ts.ProcessCommand("get", "n")
+	ts.ProcessCommand("lpush", "n", "x")
+	ts.ProcessCommand("persist", "n")
+	expectNotifications(t, listener, "no-op")
+
+	// an expiration in the past deletes the key
+	ts.ProcessCommand("expireat", "n", "1")
+	expectNotifications(t, listener, "expire past",
+		"__keyspace@0__:n del", "__keyevent@0__:del n")
+}
+
+func TestKeyspaceEventsContainers(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	listener := newKeyspaceListener(t, ts, "E$lshzt")
+	defer listener.Close()
+
+	ts.ProcessCommand("rpush", "list", "a", "b")
+	ts.ProcessCommand("lmove", "list", "list2", "left", "right")
+	ts.ProcessCommand("lpop", "list")
+	expectNotifications(t, listener, "list",
+		"__keyevent@0__:rpush list",
+		"__keyevent@0__:rpush list2", "__keyevent@0__:lpop list",
+		"__keyevent@0__:lpop list")
+
+	ts.ProcessCommand("hset", "hash", "f1", "v1", "f2", "v2")
+	ts.ProcessCommand("hincrby", "hash", "n", "1")
+	ts.ProcessCommand("hdel", "hash", "f1", "f2", "n")
+	expectNotifications(t, listener, "hash",
+		"__keyevent@0__:hset hash", "__keyevent@0__:hincrby hash",
+		"__keyevent@0__:hdel hash")
+
+	ts.ProcessCommand("sadd", "set", "m1", "m2")
+	ts.ProcessCommand("sadd", "set", "m1")
+	ts.ProcessCommand("smove", "set", "set2", "m1")
+	ts.ProcessCommand("srem", "set", "m2")
+	expectNotifications(t, listener, "set",
+		"__keyevent@0__:sadd set",
+		"__keyevent@0__:sadd set2", "__keyevent@0__:srem set",
+		"__keyevent@0__:srem set")
+
+	ts.ProcessCommand("zadd", "z", "1", "a", "2", "b")
+	ts.ProcessCommand("zincrby", "z", "1", "a")
+	ts.ProcessCommand("zunionstore", "z2", "1", "z")
+	ts.ProcessCommand("zpopmin", "z", "2")
+	expectNotifications(t, listener, "zset",
+		"__keyevent@0__:zadd z", "__keyevent@0__:zincr z",
+		"__keyevent@0__:zunionstore z2", "__keyevent@0__:zpopmin z")
+
+	ts.ProcessCommand("xadd", "s", "*", "f", "v")
+	ts.ProcessCommand("xgroup", "create", "s", "g", "0")
+	expectNotifications(t, listener, "stream",
+		"__keyevent@0__:xadd s", "__keyevent@0__:xgroup-create s")
+}
+
+func TestKeyspaceEventsEmptiedKeys(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	listener := newKeyspaceListener(t, ts, "KA")
+	defer listener.Close()
+
+	ts.ProcessCommand("rpush", "list", "a")
+	ts.ProcessCommand("rpop", "list")
+	expectNotifications(t, listener, "list",
+		"__keyspace@0__:list rpush", "__keyspace@0__:list rpop", "__keyspace@0__:list del")
+
+	ts.ProcessCommand("sadd", "set", "a")
+	ts.ProcessCommand("srem", "set", "a")
+	expectNotifications(t, listener, "set",
+		"__keyspace@0__:set sadd", "__keyspace@0__:set srem", "__keyspace@0__:set del")
+
+	output := ts.ProcessCommand("exists", "set")
+	if !output.isInt(0) {
+		t.Fatal("emptied set exists fail")
+	}
+
+	ts.ProcessCommand("zadd", "z", "1", "a")
+	ts.ProcessCommand("zremrangebyscore", "z", "0", "5")
+	expectNotifications(t, listener, "zset",
+		"__keyspace@0__:z zadd", "__keyspace@0__:z zremrangebyscore", "__keyspace@0__:z del")
+
+	ts.ProcessCommand("sadd", "dest", "a")
+	ts.ProcessCommand("sinterstore", "dest", "set", "other")
+	expectNotifications(t, listener, "empty store",
+		"__keyspace@0__:dest sadd", "__keyspace@0__:dest del")
+}
+
+func TestKeyspaceEventsFilter(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	listener := newKeyspaceListener(t, ts, "K$")
+	defer listener.Close()
+
+	ts.ProcessCommand("set", "k", "v")
+	ts.ProcessCommand("lpush", "list", "a")
+	ts.ProcessCommand("del", "k")
+	expectNotifications(t, listener, "string only", "__keyspace@0__:k set")
+
+	// without K or E, nothing is published
+	ts.ProcessCommand("config", "set", "notify-keyspace-events", "A")
+	ts.ProcessCommand("set", "k", "v")
+	expectNotifications(t, listener, "no channel types")
+}
+
+func TestKeyspaceEventsMissAndNew(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	listener := newKeyspaceListener(t, ts, "Emn")
+	defer listener.Close()
+
+	ts.ProcessCommand("get", "missing")
+	ts.ProcessCommand("set", "k", "v")
+	ts.ProcessCommand("set", "k", "v2")
+	ts.ProcessCommand("get", "k")
+	expectNotifications(t, listener, "miss and new",
+		"__keyevent@0__:keymiss missing", "__keyevent@0__:new k")
+
+	// write commands don't report misses
+	ts.ProcessCommand("del", "missing")
+	expectNotifications(t, listener, "write miss")
+}
+
+func TestKeyspaceEventsExpired(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	listener := newKeyspaceListener(t, ts, "Exh")
+	defer listener.Close()
+
+	ts.ProcessCommand("set", "k", "v", "px", "10")
+	ts.ProcessCommand("hset", "h", "f1", "v1", "f2", "v2")
+	ts.ProcessCommand("hpexpire", "h", "10", "fields", "1", "f1")
+	expectNotifications(t, listener, "setup", "__keyevent@0__:hset h", "__keyevent@0__:hexpire h")
+
+	time.Sleep(20 * time.Millisecond)
+
+	// the first access of an expired key notifies
+	output := ts.ProcessCommand("get", "k")
+	if !output.isNull() {
+		t.Fatal("get expired fail")
+	}
+	ts.ProcessCommand("get", "k")
+	expectNotifications(t, listener, "lazy expired", "__keyevent@0__:expired k")
+
+	// the active expiration cycle notifies without an access
+	ts.ProcessCommand("set", "k2", "v", "px", "10")
+	expectNotifications(t, listener, "setup k2")
+	time.Sleep(20 * time.Millisecond)
+
+	if tc, ok := ts.(*testClient); ok {
+		tc.dss.activeExpire()
+	} else {
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	// the scan order of the keys is arbitrary
+	notifications := keyspaceNotifications(listener)
+	slices.Sort(notifications)
+	if !slices.Equal(notifications, []string{"__keyevent@0__:expired k2", "__keyevent@0__:hexpired h"}) {
+		t.Fatalf("active expired notifications: %s", strings.Join(notifications, ", "))
+	}
+
+	output = ts.ProcessCommand("hlen", "h")
+	if !output.isInt(1) {
+		t.Fatal("hexpired hlen fail")
+	}
+}
@@ -0,0 +1,426 @@
+package redisemu
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The latency monitor keeps, like redis, a time series of the spikes of
+// each event class that reach latency-monitor-threshold, one sample per
+// second. The command latency of latency-tracking is kept in histograms
+// with the buckets of the redis LATENCY HISTOGRAM reply: powers of two,
+// starting at 1024 nanoseconds.
+const (
+	latencyTsLen          = 160 // the samples of an event, like LATENCY_TS_LEN
+	latencyHistogramBase  = 1024
+	latencyHistogramSlots = 40
+	latencyGraphColumns   = 80
+	latencyGraphRows      = 4
+)
+
+type (
+	latencySample struct {
+		time    int64 // unix seconds, or zero when the slot is unused
+		latency int64 // milliseconds
+	}
+
+	latencyTimeSeries struct {
+		idx     int   // the next slot to fill
+		max     int64 // the all time maximum
+		samples [latencyTsLen]latencySample
+	}
+
+	// latencyHistogram counts the calls of a command by power of two bucket
+	latencyHistogram struct {
+		calls   int64
+		buckets [latencyHistogramSlots]int64
+	}
+
+	latencyMonitor struct {
+		mu         sync.Mutex
+		events     map[string]*latencyTimeSeries
+		histograms map[string]*latencyHistogram // by command token
+	}
+
+	// latencyStats is the analysis of an event for LATENCY DOCTOR
+	latencyStats struct {
+		samples int64
+		avg     int64
+		mad     int64 // mean absolute deviation
+		min     int64
+		max     int64
+		period  int64 // the seconds since the oldest sample
+	}
+)
+
+// records the latency of a command, in its histogram and as a "command" or
+// "fast-command" event
+func (dss *dataStoreSet) recordCommandLatency(cmdToken string, fast bool, duration time.Duration) {
+	if dss.config.get("latency-tracking") == "yes" {
+		lm := &dss.latency
+		lm.mu.Lock()
+		if lm.histograms == nil {
+			lm.histograms = map[string]*latencyHistogram{}
+		}
+		h := lm.histograms[cmdToken]
+		if h == nil {
+			h = &latencyHistogram{}
+			lm.histograms[cmdToken] = h
+		}
+		h.record(duration)
+		lm.mu.Unlock()
+	}
+
+	event := "command"
+	if fast {
+		event = "fast-command"
+	}
+	dss.addLatencySampleIfNeeded(event, duration)
+}
+
+// adds a sample to the time series of the event when the latency reaches
+// the latency-monitor-threshold
+func (dss *dataStoreSet) addLatencySampleIfNeeded(event string, duration time.Duration) {
+	threshold := dss.config.intValue("latency-monitor-threshold")
+	latency := duration.Milliseconds()
+	if threshold == 0 || latency < threshold {
+		return
+	}
+
+	lm := &dss.latency
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lm.events == nil {
+		lm.events = map[string]*latencyTimeSeries{}
+	}
+	ts := lm.events[event]
+	if ts == nil {
+		ts = &latencyTimeSeries{}
+		lm.events[event] = ts
+	}
+	ts.add(time.Now().Unix(), latency)
+}
+
+func (ts *latencyTimeSeries) add(now, latency int64) {
+	ts.max = max(ts.max, latency)
+
+	// samples within the same second are merged, keeping the highest
+	prev := &ts.samples[(ts.idx+latencyTsLen-1)%latencyTsLen]
+	if prev.time == now {
+		prev.latency = max(prev.latency, latency)
+		return
+	}
+
+	ts.samples[ts.idx] = latencySample{time: now, latency: latency}
+	ts.idx = (ts.idx + 1) % latencyTsLen
+}
+
+// provides the samples from oldest to newest
+func (ts *latencyTimeSeries) history() []latencySample {
+	samples := make([]latencySample, 0, latencyTsLen)
+	for j := 0; j < latencyTsLen; j++ {
+		sample := ts.samples[(ts.idx+j)%latencyTsLen]
+		if sample.time != 0 {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+func (ts *latencyTimeSeries) latest() latencySample {
+	return ts.samples[(ts.idx+latencyTsLen-1)%latencyTsLen]
+}
+
+// computes the statistics of LATENCY DOCTOR, as analyzeLatencyForEvent does
+func (ts *latencyTimeSeries) analyze(now int64) (ls latencyStats) {
+	samples := ts.history()
+	if len(samples) == 0 {
+		return
+	}
+
+	var sum int64
+	oldest := samples[0].time
+	ls.min, ls.max = samples[0].latency, samples[0].latency
+	for _, sample := range samples {
+		ls.min = min(ls.min, sample.latency)
+		ls.max = max(ls.max, sample.latency)
+		oldest = min(oldest, sample.time)
+		sum += sample.latency
+	}
+	ls.samples = int64(len(samples))
+	ls.avg = sum / ls.samples
+	ls.period = max(now-oldest, 1)
+
+	sum = 0
+	for _, sample := range samples {
+		delta := ls.avg - sample.latency
+		if delta < 0 {
+			delta = -delta
+		}
+		sum += delta
+	}
+	ls.mad = sum / ls.samples
+	return
+}
+
+// provides the event names that have samples, sorted
+func (dss *dataStoreSet) latencyEventNames() []string {
+	lm := &dss.latency
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	names := make([]string, 0, len(lm.events))
+	for name := range lm.events {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// provides a copy of the time series of an event
+func (dss *dataStoreSet) latencyEvent(event string) (ts latencyTimeSeries, exists bool) {
+	lm := &dss.latency
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	series, exists := lm.events[event]
+	if exists {
+		ts = *series
+	}
+	return
+}
+
+// removes the time series of the events, or of all events when none are
+// specified, and returns the number removed
+func (dss *dataStoreSet) resetLatencyEvents(events []string) (count int) {
+	lm := &dss.latency
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if len(events) == 0 {
+		count = len(lm.events)
+		lm.events = nil
+		return
+	}
+
+	for _, event := range events {
+		if _, exists := lm.events[event]; exists {
+			delete(lm.events, event)
+			count++
+		}
+	}
+	return
+}
+
+// provides a copy of the histogram of a command
+func (dss *dataStoreSet) latencyHistogram(cmdToken string) (h latencyHistogram, exists bool) {
+	lm := &dss.latency
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	histogram, exists := lm.histograms[cmdToken]
+	if exists {
+		h = *histogram
+	}
+	return
+}
+
+// provides the command tokens that have histograms, sorted
+func (dss *dataStoreSet) latencyHistogramCommands() []string {
+	lm := &dss.latency
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	names := make([]string, 0, len(lm.histograms))
+	for name := range lm.histograms {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// clears the command histograms, as CONFIG RESETSTAT does
+func (dss *dataStoreSet) resetLatencyHistograms() {
+	dss.latency.mu.Lock()
+	defer dss.latency.mu.Unlock()
+	dss.latency.histograms = nil
+}
+
+func (h *latencyHistogram) record(duration time.Duration) {
+	ns := max(duration.Nanoseconds(), 1)
+	slot := 0
+	for slot < latencyHistogramSlots-1 && ns > latencyHistogramBase<<slot {
+		slot++
+	}
+	h.buckets[slot]++
+	h.calls++
+}
+
+// the upper bound of a bucket in microseconds, as LATENCY HISTOGRAM reports it
+func latencyBucketMicros(slot int) int64 {
+	return (latencyHistogramBase << slot) / 1000
+}
+
+// provides the latency in microseconds that the percentile of the calls
+// don't exceed
+func (h *latencyHistogram) percentile(p float64) float64 {
+	target := max(int64(math.Ceil(p/100*float64(h.calls))), 1)
+	var cumulative int64
+	for slot, count := range h.buckets {
+		cumulative += count
+		if cumulative >= target {
+			return float64(int64(latencyHistogramBase)<<slot) / 1000
+		}
+	}
+	return 0
+}
+
+// makes the Latencystats lines of INFO, with the percentiles of
+// latency-tracking-info-percentiles
+func (dss *dataStoreSet) latencyStatsInfo() []string {
+	var percentiles []float64
+	for _, field := range strings.Fields(dss.config.get("latency-tracking-info-percentiles")) {
+		p, _ := strconv.ParseFloat(field, 64)
+		percentiles = append(percentiles, p)
+	}
+
+	lines := []string{}
+	for _, cmdToken := range dss.latencyHistogramCommands() {
+		h, _ := dss.latencyHistogram(cmdToken)
+		if h.calls == 0 {
+			continue
+		}
+
+		var sb strings.Builder
+		sb.WriteString("latency_percentiles_usec_" + cmdToken + ":")
+		for i, p := range percentiles {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(fmt.Sprintf("p%s=%.3f", strconv.FormatFloat(p, 'f', -1, 64), h.percentile(p)))
+		}
+		lines = append(lines, sb.String())
+	}
+	return lines
+}
+
+// parses the latency-tracking-info-percentiles value, a list of percentiles
+func normalizePercentiles(value string) (normalized string, errText string) {
+	fields := strings.Fields(value)
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		p, err := strconv.ParseFloat(field, 64)
+		if err != nil || p < 0 || p > 100 {
+			errText = "latency-tracking-info-percentiles parameter should be between 0.0 and 100.0"
+			return
+		}
+		parts = append(parts, strconv.FormatFloat(p, 'f', -1, 64))
+	}
+	normalized = strings.Join(parts, " ")
+	return
+}
+
+// draws the samples of an event for LATENCY GRAPH, like the sparkline of
+// redis: a line of up to 80 samples, four rows high, labeled vertically
+// with the age of each sample
+func latencyGraph(event string, ts *latencyTimeSeries, now int64) string {
+	samples := ts.history()
+
+	var low, high int64
+	values := make([]int64, 0, len(samples))
+	labels := make([]string, 0, len(samples))
+	for i, sample := range samples {
+		if i == 0 {
+			low, high = sample.latency, sample.latency
+		} else {
+			low = min(low, sample.latency)
+			high = max(high, sample.latency)
+		}
+		values = append(values, sample.latency)
+
+		elapsed := now - sample.time
+		switch {
+		case elapsed < 60:
+			labels = append(labels, fmt.Sprintf("%ds", elapsed))
+		case elapsed < 3600:
+			labels = append(labels, fmt.Sprintf("%dm", elapsed/60))
+		case elapsed < 3600*24:
+			labels = append(labels, fmt.Sprintf("%dh", elapsed/3600))
+		default:
+			labels = append(labels, fmt.Sprintf("%dd", elapsed/(3600*24)))
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s - high %d ms, low %d ms (all time high %d ms)\n", event, high, low, ts.max))
+	sb.WriteString(strings.Repeat("-", latencyGraphColumns))
+	sb.WriteByte('\n')
+
+	for offset := 0; offset < len(values); offset += latencyGraphColumns {
+		if offset != 0 {
+			sb.WriteByte('\n')
+		}
+		end := min(offset+latencyGraphColumns, len(values))
+		renderSparkline(&sb, values[offset:end], labels[offset:end], low, high)
+	}
+	return sb.String()
+}
+
+// renders a range of samples as redis sparklineRenderRange does, filling
+// below the top of each sample
+func renderSparkline(sb *strings.Builder, values []int64, labels []string, low, high int64) {
+	const charset = "_o#"
+	const labelMarginTop = 1
+
+	relMax := float64(high - low)
+	if relMax == 0 {
+		relMax = 1
+	}
+	steps := len(charset) * latencyGraphRows
+
+	chars := make([]byte, len(values))
+	for row := 0; ; row++ {
+		loop := false
+		for j := range chars {
+			chars[j] = ' '
+		}
+
+		for j, value := range values {
+			step := int(float64(value-low) * float64(steps) / relMax)
+			step = min(max(step, 0), steps-1)
+
+			if row < latencyGraphRows {
+				charIdx := step - (latencyGraphRows-row-1)*len(charset)
+				loop = true
+				if charIdx >= 0 && charIdx < len(charset) {
+					chars[j] = charset[charIdx]
+				} else if charIdx >= len(charset) {
+					chars[j] = '|'
+				}
+			} else {
+				// a blank line separates the labels
+				if row-latencyGraphRows < labelMarginTop {
+					loop = true
+					break
+				}
+				labelChar := row - latencyGraphRows - labelMarginTop
+				if labelChar < len(labels[j]) {
+					loop = true
+					chars[j] = labels[j][labelChar]
+				}
+			}
+		}
+
+		if !loop {
+			return
+		}
+		sb.Write(chars)
+		sb.WriteByte('\n')
+	}
+}
@@ -0,0 +1,271 @@
+package redisemu
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// like lua cjson, nesting is limited to prevent runaway recursion
+const luaJsonMaxDepth = 1000
+
+// makes the cjson library; cjson.null is a unique value that stands for a JSON null
+func (lr *luaRun) cjsonLib(L *lua.LState) *lua.LTable {
+	lr.jsonNull = L.NewUserData()
+
+	t := L.NewTable()
+	L.SetFuncs(t, map[string]lua.LGFunction{
+		"encode": func(L *lua.LState) int {
+			var sb strings.Builder
+			if errText := lr.jsonEncode(&sb, L.CheckAny(1), 0); errText != "" {
+				L.RaiseError("%s", errText)
+			}
+			L.Push(lua.LString(sb.String()))
+			return 1
+		},
+		"decode": func(L *lua.LState) int {
+			var v any
+			decoder := json.NewDecoder(strings.NewReader(L.CheckString(1)))
+			decoder.UseNumber()
+			if err := decoder.Decode(&v); err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			if decoder.More() {
+				L.RaiseError("Expected the end but found trailing data")
+			}
+			L.Push(lr.jsonToLua(L, v))
+			return 1
+		},
+	})
+	t.RawSetString("null", lr.jsonNull)
+	return t
+}
+
+func (lr *luaRun) jsonEncode(sb *strings.Builder, lv lua.LValue, depth int) (errText string) {
+	if depth > luaJsonMaxDepth {
+		return fmt.Sprintf("Cannot serialise, excessive nesting (%d)", depth)
+	}
+
+	switch v := lv.(type) {
+	case *lua.LNilType:
+		sb.WriteString("null")
+	case lua.LBool:
+		fmt.Fprintf(sb, "%t", bool(v))
+	case lua.LNumber:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return "Cannot serialise number: must not be NaN or Inf"
+		}
+		sb.WriteString(luaNumberString(v))
+	case lua.LString:
+		jsonQuote(sb, string(v))
+	case *lua.LUserData:
+		if v != lr.jsonNull {
+			return "Cannot serialise userdata: type not supported"
+		}
+		sb.WriteString("null")
+	case *lua.LTable:
+		return lr.jsonEncodeTable(sb, v, depth)
+	default:
+		return fmt.Sprintf("Cannot serialise %s: type not supported", lv.Type().String())
+	}
+	return
+}
+
+// encodes a table as a JSON array if its keys are 1..n, otherwise as an object
+func (lr *luaRun) jsonEncodeTable(sb *strings.Builder, t *lua.LTable, depth int) (errText string) {
+	count := 0
+	maxIndex := 0
+	names := map[string]lua.LValue{}
+	t.ForEach(func(k, v lua.LValue) {
+		count++
+		switch key := k.(type) {
+		case lua.LNumber:
+			if float64(key) == math.Trunc(float64(key)) && key > 0 {
+				maxIndex = max(maxIndex, int(key))
+			}
+			names[luaNumberString(key)] = v
+		case lua.LString:
+			names[string(key)] = v
+		default:
+			errText = "Cannot serialise table: table key must be a number or string"
+		}
+	})
+	if errText != "" {
+		return
+	}
+
+	if count > 0 && maxIndex == count {
+		sb.WriteByte('[')
+		for i := 1; i <= count; i++ {
+			if i > 1 {
+				sb.WriteByte(',')
+			}
+			if errText = lr.jsonEncode(sb, t.RawGetInt(i), depth+1); errText != "" {
+				return
+			}
+		}
+		sb.WriteByte(']')
+		return
+	}
+
+	// the key order of a lua table is arbitrary; sorting makes the output repeatable
+	keys := make([]string, 0, len(names))
+	for key := range names {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sb.WriteByte('{')
+	for idx, key := range keys {
+		if idx > 0 {
+			sb.WriteByte(',')
+		}
+		jsonQuote(sb, key)
+		sb.WriteByte(':')
+		if errText = lr.jsonEncode(sb, names[key], depth+1); errText != "" {
+			return
+		}
+	}
+	sb.WriteByte('}')
+	return
+}
+
+// quotes a string the way lua cjson does, which includes escaping '/'
+func jsonQuote(sb *strings.Builder, text string) {
+	sb.WriteByte('"')
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		switch ch {
+		case '"', '\\', '/':
+			sb.WriteByte('\\')
+			sb.WriteByte(ch)
+		case '\b':
+			sb.WriteString("\\b")
+		case '\f':
+			sb.WriteString("\\f")
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		default:
+			if ch < 0x20 || ch == 0x7f {
+				fmt.Fprintf(sb, "\\u%04x", ch)
+			} else {
+				sb.WriteByte(ch)
+			}
+		}
+	}
+	sb.WriteByte('"')
+}
+
+func (lr *luaRun) jsonToLua(L *lua.LState, v any) lua.LValue {
+	switch jv := v.(type) {
+	case nil:
+		return lr.jsonNull
+	case bool:
+		return lua.LBool(jv)
+	case json.Number:
+		f, _ := jv.Float64()
+		return lua.LNumber(f)
+	case string:
+		return lua.LString(jv)
+	case []any:
+		t := L.CreateTable(len(jv), 0)
+		for _, element := range jv {
+			t.Append(lr.jsonToLua(L, element))
+		}
+		return t
+	case map[string]any:
+		t := L.CreateTable(0, len(jv))
+		for key, element := range jv {
+			t.RawSetString(key, lr.jsonToLua(L, element))
+		}
+		return t
+	}
+	return lua.LNil
+}
+
+// makes the bit library (LuaBitOp), which operates on 32-bit signed integers
+func bitLib(L *lua.LState) *lua.LTable {
+	t := L.NewTable()
+	L.SetFuncs(t, map[string]lua.LGFunction{
+		"tobit": func(L *lua.LState) int {
+			return bitResult(L, bitArg(L, 1))
+		},
+		"bnot": func(L *lua.LState) int {
+			return bitResult(L, ^bitArg(L, 1))
+		},
+		"band": func(L *lua.LState) int {
+			return bitFold(L, func(a, b uint32) uint32 { return a & b })
+		},
+		"bor": func(L *lua.LState) int {
+			return bitFold(L, func(a, b uint32) uint32 { return a | b })
+		},
+		"bxor": func(L *lua.LState) int {
+			return bitFold(L, func(a, b uint32) uint32 { return a ^ b })
+		},
+		"lshift": func(L *lua.LState) int {
+			return bitResult(L, bitArg(L, 1)<<(bitArg(L, 2)&31))
+		},
+		"rshift": func(L *lua.LState) int {
+			return bitResult(L, bitArg(L, 1)>>(bitArg(L, 2)&31))
+		},
+		"arshift": func(L *lua.LState) int {
+			return bitResult(L, uint32(int32(bitArg(L, 1))>>(bitArg(L, 2)&31)))
+		},
+		"rol": func(L *lua.LState) int {
+			x, n := bitArg(L, 1), bitArg(L, 2)&31
+			return bitResult(L, x<<n|x>>((32-n)&31))
+		},
+		"ror": func(L *lua.LState) int {
+			x, n := bitArg(L, 1), bitArg(L, 2)&31
+			return bitResult(L, x>>n|x<<((32-n)&31))
+		},
+		"bswap": func(L *lua.LState) int {
+			x := bitArg(L, 1)
+			return bitResult(L, x>>24|(x>>8)&0xff00|(x&0xff00)<<8|x<<24)
+		},
+		"tohex": func(L *lua.LState) int {
+			x := bitArg(L, 1)
+			n := 8
+			if L.GetTop() >= 2 {
+				n = int(int32(bitArg(L, 2)))
+			}
+			format := "%08x"
+			if n < 0 {
+				format = "%08X"
+				n = -n
+			}
+			n = min(n, 8)
+			hexText := fmt.Sprintf(format, x)
+			L.Push(lua.LString(hexText[8-n:]))
+			return 1
+		},
+	})
+	return t
+}
+
+// normalizes a number argument to 32 bits
+func bitArg(L *lua.LState, n int) uint32 {
+	f := math.Mod(math.Floor(float64(L.CheckNumber(n))), 4294967296)
+	return uint32(int64(f))
+}
+
+func bitResult(L *lua.LState, x uint32) int {
+	L.Push(lua.LNumber(int32(x)))
+	return 1
+}
+
+func bitFold(L *lua.LState, op func(a, b uint32) uint32) int {
+	x := bitArg(L, 1)
+	for i := 2; i <= L.GetTop(); i++ {
+		x = op(x, bitArg(L, i))
+	}
+	return bitResult(L, x)
+}
@@ -0,0 +1,512 @@
+package redisemu
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+type (
+	// luaScript is a compiled script of the script cache
+	luaScript struct {
+		sha      string
+		body     string
+		proto    *lua.FunctionProto
+		noWrites bool // from the shebang flags
+	}
+
+	// luaRun is the state of one script execution
+	luaRun struct {
+		ctx         *cmdContext // the EVAL command
+		readOnly    bool
+		respVersion int // selected with redis.setresp()
+		jsonNull    *lua.LUserData
+	}
+)
+
+// the shebang flags redis accepts; only no-writes changes the behavior of the emulator
+var luaShebangFlags = map[string]bool{
+	"no-writes":             true,
+	"allow-oom":             true,
+	"allow-stale":           true,
+	"no-cluster":            true,
+	"allow-cross-slot-keys": true,
+}
+
+var luaClockStart = time.Now()
+
+func sha1Hex(text string) string {
+	sum := sha1.Sum([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// compiles a script body; like redis, the chunk is named user_script so
+// that error messages look the same
+func compileLuaScript(body string) (script *luaScript, errText respErrorString) {
+	script = &luaScript{sha: sha1Hex(body), body: body}
+
+	code := body
+	if strings.HasPrefix(code, "#!") {
+		shebang, rest, _ := strings.Cut(code, "\n")
+		if errText = script.parseShebang(shebang); errText != "" {
+			return
+		}
+		// keep the line numbers of the script intact
+		code = "\n" + rest
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(code), "user_script")
+	if err == nil {
+		script.proto, err = lua.Compile(chunk, "user_script")
+	}
+	if err != nil {
+		errText = respErrorString(fmt.Sprintf("ERR Error compiling script (new function): %s", strings.TrimSpace(err.Error())))
+	}
+	return
+}
+
+func (script *luaScript) parseShebang(shebang string) (errText respErrorString) {
+	fields := strings.Fields(shebang[2:])
+	if len(fields) == 0 || fields[0] != "lua" {
+		errText = "ERR Could not find engine in script shebang"
+		return
+	}
+
+	for _, field := range fields[1:] {
+		name, value, _ := strings.Cut(field, "=")
+		if name != "flags" {
+			errText = respErrorString(fmt.Sprintf("ERR Unknown lua shebang option: %s", name))
+			return
+		}
+		for _, flag := range strings.Split(value, ",") {
+			if flag == "" {
+				continue
+			}
+			if !luaShebangFlags[flag] {
+				errText = respErrorString(fmt.Sprintf("ERR Unexpected flag in script shebang: %s", flag))
+				return
+			}
+			if flag == "no-writes" {
+				script.noWrites = true
+			}
+		}
+	}
+	return
+}
+
+// runs a script; the caller holds the exclusive lock of the data store (see runScript)
+func runLuaScript(ctx *cmdContext, script *luaScript, keys, argv []string, readOnly bool) (output respValue) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+
+	// SCRIPT KILL cancels the context, which stops the lua VM
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	L.SetContext(runCtx)
+
+	lr := &luaRun{
+		ctx:         ctx,
+		readOnly:    readOnly || script.noWrites,
+		respVersion: 2,
+	}
+	lr.openLibs(L)
+	L.SetGlobal("KEYS", luaStringTable(L, keys))
+	L.SetGlobal("ARGV", luaStringTable(L, argv))
+	lr.protectGlobals(L)
+
+	L.Push(L.NewFunctionFromProto(script.proto))
+	ctx.cs.dss.startScript(ctx, cancel)
+	err := L.PCall(0, 1, nil)
+	killed := ctx.cs.dss.endScript(ctx)
+	if err != nil {
+		if killed {
+			output.data = errScriptKilled
+		} else {
+			output.data = luaErrorReply(err)
+		}
+		return
+	}
+
+	output = lr.luaToResp(L.Get(-1))
+	return
+}
+
+// makes the error reply for a script that raised an error
+func luaErrorReply(err error) respErrorString {
+	apiErr, valid := err.(*lua.ApiError)
+	if !valid {
+		return respErrorString("ERR " + err.Error())
+	}
+
+	// an error raised by redis.call() is an error reply table
+	if t, isTable := apiErr.Object.(*lua.LTable); isTable {
+		if text, isString := t.RawGetString("err").(lua.LString); isString {
+			return respErrorString(text)
+		}
+	}
+	return respErrorString("ERR " + apiErr.Object.String())
+}
+
+func luaStringTable(L *lua.LState, strs []string) *lua.LTable {
+	t := L.CreateTable(len(strs), 0)
+	for _, str := range strs {
+		t.Append(lua.LString(str))
+	}
+	return t
+}
+
+func luaErrorTable(L *lua.LState, text string) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString("err", lua.LString(text))
+	return t
+}
+
+func (lr *luaRun) openLibs(L *lua.LState) {
+	libs := []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	// like redis, scripts can't load code from the file system
+	L.SetGlobal("dofile", lua.LNil)
+	L.SetGlobal("loadfile", lua.LNil)
+
+	// only os.clock() is exposed
+	os := L.NewTable()
+	L.SetField(os, "clock", L.NewFunction(luaOsClock))
+	L.SetGlobal("os", os)
+
+	L.SetGlobal("redis", lr.redisLib(L))
+	L.SetGlobal("cjson", lr.cjsonLib(L))
+	L.SetGlobal("bit", bitLib(L))
+}
+
+// makes the global table read-only, so scripts can't leak state into
+// each other through globals
+func (lr *luaRun) protectGlobals(L *lua.LState) {
+	mt := L.NewTable()
+	L.SetField(mt, "__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to create global variable '%s'", L.Get(2).String())
+		return 0
+	}))
+	L.SetField(mt, "__index", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to access nonexistent global variable '%s'", L.Get(2).String())
+		return 0
+	}))
+	L.SetMetatable(L.G.Global, mt)
+}
+
+func (lr *luaRun) redisLib(L *lua.LState) *lua.LTable {
+	t := L.NewTable()
+	L.SetFuncs(t, map[string]lua.LGFunction{
+		"call":  func(L *lua.LState) int { return lr.call(L, true) },
+		"pcall": func(L *lua.LState) int { return lr.call(L, false) },
+		"error_reply": func(L *lua.LState) int {
+			L.Push(luaErrorTable(L, L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			status := L.NewTable()
+			status.RawSetString("ok", lua.LString(L.CheckString(1)))
+			L.Push(status)
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			if L.GetTop() != 1 {
+				L.RaiseError("wrong number of arguments")
+			}
+			L.Push(lua.LString(sha1Hex(L.ToString(1))))
+			return 1
+		},
+		"log": func(L *lua.LState) int {
+			if L.GetTop() < 2 {
+				L.RaiseError("redis.log() requires two arguments or more.")
+			}
+			parts := []string{}
+			for i := 2; i <= L.GetTop(); i++ {
+				parts = append(parts, L.ToString(i))
+			}
+			lr.ctx.l.Infof("script log: %s", strings.Join(parts, " "))
+			return 0
+		},
+		"setresp": func(L *lua.LState) int {
+			if L.GetTop() != 1 {
+				L.RaiseError("redis.setresp() requires one argument.")
+			}
+			version := L.CheckInt(1)
+			if version != 2 && version != 3 {
+				L.RaiseError("RESP version must be 2 or 3.")
+			}
+			lr.respVersion = version
+			return 0
+		},
+		"set_repl": func(L *lua.LState) int {
+			L.CheckInt(1)
+			return 0
+		},
+		"replicate_commands": func(L *lua.LState) int {
+			L.Push(lua.LTrue)
+			return 1
+		},
+		"breakpoint": func(L *lua.LState) int {
+			L.Push(lua.LFalse)
+			return 1
+		},
+		"debug": func(L *lua.LState) int {
+			return 0
+		},
+	})
+
+	constants := map[string]int{
+		"LOG_DEBUG":    0,
+		"LOG_VERBOSE":  1,
+		"LOG_NOTICE":   2,
+		"LOG_WARNING":  3,
+		"REPL_NONE":    0,
+		"REPL_AOF":     1,
+		"REPL_SLAVE":   2,
+		"REPL_REPLICA": 2,
+		"REPL_ALL":     3,
+	}
+	for name, value := range constants {
+		t.RawSetString(name, lua.LNumber(value))
+	}
+	t.RawSetString("REDIS_VERSION", lua.LString("7.0.5"))
+	t.RawSetString("REDIS_VERSION_NUM", lua.LNumber(0x070005))
+	return t
+}
+
+// os.clock() reports the seconds since the package started, rather than CPU time
+func luaOsClock(L *lua.LState) int {
+	L.Push(lua.LNumber(time.Since(luaClockStart).Seconds()))
+	return 1
+}
+
+// implements redis.call() and redis.pcall(); redis.call() raises an error
+// reply, while redis.pcall() returns it
+func (lr *luaRun) call(L *lua.LState, raise bool) int {
+	var output respValue
+
+	if L.GetTop() == 0 {
+		output.data = respErrorString("ERR Please specify at least one argument for this redis lib call")
+	} else {
+		args := make(respArray, 0, L.GetTop())
+		for i := 1; i <= L.GetTop(); i++ {
+			switch v := L.Get(i).(type) {
+			case lua.LString:
+				args = append(args, respValue{data: respBulkString(v)})
+			case lua.LNumber:
+				args = append(args, respValue{data: respBulkString(luaNumberString(v))})
+			}
+		}
+		if len(args) < L.GetTop() {
+			output.data = respErrorString("ERR Lua redis lib command arguments must be strings or integers")
+		} else {
+			output = dispatchFromScript(lr.ctx, args, lr.readOnly, lr.respVersion)
+		}
+	}
+
+	if errText, isError := output.data.(respErrorString); isError && raise {
+		L.Error(luaErrorTable(L, string(errText)), 1)
+		return 0
+	}
+
+	L.Push(lr.respToLua(L, output))
+	return 1
+}
+
+// converts a command reply to a lua value
+func (lr *luaRun) respToLua(L *lua.LState, value respValue) lua.LValue {
+	switch v := value.data.(type) {
+	case respInt:
+		return lua.LNumber(v)
+	case respBulkString:
+		return lua.LString(v)
+	case respSimpleString:
+		t := L.NewTable()
+		t.RawSetString("ok", lua.LString(v))
+		return t
+	case respErrorString:
+		return luaErrorTable(L, string(v))
+	case respBlobError:
+		return luaErrorTable(L, string(v))
+	case respArray:
+		return lr.respArrayToLua(L, v)
+	case respPush:
+		return lr.respArrayToLua(L, v.data)
+	case respPairs:
+		t := L.CreateTable(len(v), 0)
+		for _, pair := range v {
+			t.Append(lr.respArrayToLua(L, respArray{pair.key, pair.value}))
+		}
+		return t
+	case respMap:
+		m := L.NewTable()
+		for _, k := range v.order {
+			m.RawSet(lr.respToLua(L, k), lr.respToLua(L, v.m[k]))
+		}
+		t := L.NewTable()
+		t.RawSetString("map", m)
+		return t
+	case respAttributeMap:
+		m := L.NewTable()
+		for k, mv := range v {
+			m.RawSet(lr.respToLua(L, k), lr.respToLua(L, mv))
+		}
+		t := L.NewTable()
+		t.RawSetString("map", m)
+		return t
+	case respSet:
+		s := L.NewTable()
+		for member := range v {
+			s.RawSet(lr.respToLua(L, member), lua.LTrue)
+		}
+		t := L.NewTable()
+		t.RawSetString("set", s)
+		return t
+	case respBool:
+		return lua.LBool(v)
+	case respDouble:
+		t := L.NewTable()
+		t.RawSetString("double", lua.LNumber(v))
+		return t
+	case respBigNumber:
+		t := L.NewTable()
+		t.RawSetString("big_number", lua.LString(v.bn.String()))
+		return t
+	case respVerbatimString:
+		vs := L.NewTable()
+		vs.RawSetString("format", lua.LString(v.format))
+		vs.RawSetString("string", lua.LString(v.text))
+		t := L.NewTable()
+		t.RawSetString("verbatim_string", vs)
+		return t
+	case respNull:
+		return lua.LNil
+	case nil:
+		// a RESP2 null is false, a RESP3 null is nil
+		if lr.respVersion == 2 {
+			return lua.LFalse
+		}
+		return lua.LNil
+	}
+	return lua.LNil
+}
+
+func (lr *luaRun) respArrayToLua(L *lua.LState, a respArray) *lua.LTable {
+	t := L.CreateTable(len(a), 0)
+	for idx, element := range a {
+		t.RawSetInt(idx+1, lr.respToLua(L, element))
+	}
+	return t
+}
+
+// converts the lua value returned by a script to a reply
+func (lr *luaRun) luaToResp(lv lua.LValue) (value respValue) {
+	switch v := lv.(type) {
+	case lua.LString:
+		value.data = respBulkString(v)
+	case lua.LNumber:
+		value.data = respInt(int64(v))
+	case lua.LBool:
+		if lr.respVersion == 3 {
+			value.data = respBool(v)
+		} else if v {
+			value.data = respInt(1)
+		}
+	case *lua.LTable:
+		value = lr.luaTableToResp(v)
+	}
+	return
+}
+
+func (lr *luaRun) luaTableToResp(t *lua.LTable) (value respValue) {
+	if text, isString := t.RawGetString("err").(lua.LString); isString {
+		value.data = respErrorString(text)
+		return
+	}
+	if text, isString := t.RawGetString("ok").(lua.LString); isString {
+		value.data = respSimpleString(text)
+		return
+	}
+	if n, isNumber := t.RawGetString("double").(lua.LNumber); isNumber {
+		value.data = respDouble(n)
+		return
+	}
+	if text, isString := t.RawGetString("big_number").(lua.LString); isString {
+		bn, valid := new(big.Int).SetString(string(text), 10)
+		if valid {
+			value.data = respBigNumber{bn: bn}
+			return
+		}
+	}
+	if m, isTable := t.RawGetString("map").(*lua.LTable); isTable {
+		rm := newRespMap()
+		m.ForEach(func(k, v lua.LValue) {
+			if key, valid := lr.luaKeyToResp(k); valid {
+				rm.set(key, lr.luaToResp(v))
+			}
+		})
+		value.data = rm
+		return
+	}
+	if s, isTable := t.RawGetString("set").(*lua.LTable); isTable {
+		rs := respSet{}
+		s.ForEach(func(k, v lua.LValue) {
+			if key, valid := lr.luaKeyToResp(k); valid {
+				rs[key] = struct{}{}
+			}
+		})
+		value.data = rs
+		return
+	}
+
+	// an array ends at the first nil
+	a := respArray{}
+	for i := 1; ; i++ {
+		element := t.RawGetInt(i)
+		if element == lua.LNil {
+			break
+		}
+		a = append(a, lr.luaToResp(element))
+	}
+	value.data = a
+	return
+}
+
+// converts a table key of a map or set reply; only scalars can be keys
+func (lr *luaRun) luaKeyToResp(lv lua.LValue) (key respValue, valid bool) {
+	switch lv.(type) {
+	case lua.LString, lua.LNumber, lua.LBool:
+		key = lr.luaToResp(lv)
+		valid = key.data != nil
+	}
+	return
+}
+
+// formats a lua number the way lua converts a number to a string
+func luaNumberString(n lua.LNumber) string {
+	f := float64(n)
+	if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+		return fmt.Sprintf("%d", int64(f))
+	}
+	return fmt.Sprintf("%.14g", f)
+}
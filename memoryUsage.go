@@ -0,0 +1,484 @@
+package redisemu
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"sync/atomic"
+)
+
+// The emulator doesn't store values the way Redis does, so memory is an
+// estimate of what Redis would allocate for the encoding that OBJECT ENCODING
+// reports: the object headers, the sds strings, listpacks and hash tables,
+// each rounded up to a jemalloc size class. Each key caches its estimate,
+// which is updated by the keyspace change hook, and the data stores keep a
+// running total that feeds INFO memory and MEMORY STATS.
+const (
+	memStartup        = 862000 // the used memory of an empty server
+	memClientNormal   = 20480  // the buffers of an idle normal client
+	memObject         = 16     // robj
+	memDict           = 56     // dict
+	memDictEntry      = 24     // dictEntry
+	memQuicklist      = 40     // quicklist
+	memQuicklistNode  = 32     // quicklistNode
+	memSkiplist       = 32     // zskiplist
+	memSkiplistHeader = 640    // the zskiplistNode header with 32 levels
+	memSkiplistNode   = 48     // a zskiplistNode with the average level count
+	memZSet           = 16     // zset
+	memStream         = 104    // stream, with its rax
+	memStreamNode     = 32     // the rax node of a stream listpack
+	memStreamGroup    = 48     // streamCG, with its PEL rax
+	memStreamConsumer = 48     // streamConsumer, with its PEL rax
+	memStreamNack     = 48     // streamNACK, with its rax key
+	memListpackHeader = 7      // total bytes, element count and end marker
+
+	memSamplesDefault = 5 // MEMORY USAGE samples, also used for the running total
+	memDoctorMinimum  = 5 * 1024 * 1024
+)
+
+// the stream-node-max-bytes default; streams are also split into nodes of
+// streamNodeMaxEntries
+const streamNodeMaxBytes = 4096
+
+type (
+	// memoryStats is a snapshot of the estimated memory, in the terms of
+	// MEMORY STATS
+	memoryStats struct {
+		peak     int64
+		total    int64
+		startup  int64
+		clients  int64
+		overhead int64
+		dataset  int64
+		keys     int64
+		dbs      []dbMemoryOverhead
+	}
+
+	dbMemoryOverhead struct {
+		index   int
+		main    int64 // the hash table of the keys
+		expires int64 // the hash table that Redis keeps for the keys with a TTL
+	}
+
+	// memSampler extrapolates the size of an aggregate from some of its
+	// elements, as MEMORY USAGE SAMPLES does; a limit of 0 samples all
+	memSampler struct {
+		limit int
+		n     int
+		total int64
+	}
+)
+
+// rounds an allocation up to the jemalloc size class: 8 byte steps up to 64,
+// then four classes for each doubling
+func allocSize(n int64) int64 {
+	if n <= 8 {
+		return 8
+	}
+	if n <= 64 {
+		return (n + 7) &^ 7
+	}
+	spacing := int64(1) << (bits.Len64(uint64(n-1)) - 3)
+	return (n + spacing - 1) &^ (spacing - 1)
+}
+
+// the allocation of an sds string, which has a header sized by its length
+func sdsAllocSize(length int) int64 {
+	var header int64
+	switch {
+	case length == 0:
+		header = 3 // an empty string is made expandable
+	case length < 32:
+		header = 1
+	case length < 256:
+		header = 3
+	case length < 65536:
+		header = 5
+	case int64(length) < math.MaxUint32:
+		header = 9
+	default:
+		header = 17
+	}
+	return allocSize(header + int64(length) + 1)
+}
+
+// the size of the next power of two hash table that holds count entries
+func dictSlots(count int) int64 {
+	if count == 0 {
+		return 0
+	}
+	return int64(max(4, 1<<bits.Len(uint(count-1))))
+}
+
+// the size of a listpack entry, with its encoding byte(s) and back length
+func listpackEntrySize(value string) int64 {
+	var size int64
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && len(value) <= 20 && strconv.FormatInt(n, 10) == value {
+		switch {
+		case n >= 0 && n <= 127:
+			size = 1
+		case n >= -4096 && n <= 4095:
+			size = 2
+		case n >= math.MinInt16 && n <= math.MaxInt16:
+			size = 3
+		case n >= -(1<<23) && n < 1<<23:
+			size = 4
+		case n >= math.MinInt32 && n <= math.MaxInt32:
+			size = 5
+		default:
+			size = 9
+		}
+	} else {
+		switch length := int64(len(value)); {
+		case length < 64:
+			size = 1 + length
+		case length < 4096:
+			size = 2 + length
+		default:
+			size = 5 + length
+		}
+	}
+
+	switch {
+	case size < 128:
+		return size + 1
+	case size < 16384:
+		return size + 2
+	case size < 2097152:
+		return size + 3
+	case size < 268435456:
+		return size + 4
+	}
+	return size + 5
+}
+
+// adds the size of an element; returns false when enough have been sampled
+func (ms *memSampler) add(size int64) bool {
+	ms.total += size
+	ms.n++
+	return ms.limit == 0 || ms.n < ms.limit
+}
+
+func (ms *memSampler) estimate(count int) int64 {
+	if ms.n == 0 {
+		return 0
+	}
+	return ms.total * int64(count) / int64(ms.n)
+}
+
+// estimates the memory of a key as MEMORY USAGE reports it: the value, the
+// key name and its entry in the keyspace hash table
+func (ds *dataStore) keyMemoryUnlocked(keyName string, sk *storeKey, samples int) int64 {
+	return ds.valueMemoryUnlocked(sk, samples) + sdsAllocSize(len(keyName)) + memDictEntry
+}
+
+func (ds *dataStore) valueMemoryUnlocked(sk *storeKey, samples int) int64 {
+	if sk.payload == nil {
+		return memObject
+	}
+
+	encoding := ds.encodingNameUnlocked(sk)
+	ms := memSampler{limit: samples}
+
+	switch {
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_STRING):
+		value := sk.getStringBytes()
+		switch encoding {
+		case "int":
+			return memObject
+		case "embstr":
+			return allocSize(memObject + 3 + int64(len(value)) + 1)
+		}
+		return memObject + sdsAllocSize(len(value))
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_LIST):
+		list := sk.getList()
+		if encoding == "listpack" {
+			size := int64(memListpackHeader)
+			for item := list.head; item != nil; item = item.next {
+				size += listpackEntrySize(string(item.element))
+			}
+			return memObject + allocSize(size)
+		}
+
+		for item := list.head; item != nil; item = item.next {
+			if !ms.add(listpackEntrySize(string(item.element))) {
+				break
+			}
+		}
+		elements := ms.estimate(list.count)
+
+		// the elements are split into nodes by list-max-listpack-size
+		var nodes int64
+		if limit := ds.configInt("list-max-listpack-size"); limit > 0 {
+			nodes = (int64(list.count) + limit - 1) / limit
+		} else {
+			limit = listpackSizeLimits[min(max(-limit, 1), 5)-1]
+			nodes = (elements + limit - 1) / limit
+		}
+		nodes = max(nodes, 1)
+		return memObject + memQuicklist + nodes*(memQuicklistNode+allocSize(memListpackHeader+elements/nodes))
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_HASH_TABLE):
+		hash := sk.getHashTable()
+		iter := hash.createIterator()
+		if encoding != "hashtable" {
+			size := int64(memListpackHeader)
+			for iter.next() {
+				size += listpackEntrySize(iter.key) + listpackEntrySize(iter.value.(string))
+				if encoding == "listpackex" {
+					size += listpackEntrySize("0") // the field TTL
+				}
+			}
+			return memObject + allocSize(size)
+		}
+
+		for iter.next() {
+			if !ms.add(memDictEntry + sdsAllocSize(len(iter.key)) + sdsAllocSize(len(iter.value.(string)))) {
+				break
+			}
+		}
+		return memObject + memDict + 8*dictSlots(hash.count) + ms.estimate(hash.count)
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_SET):
+		set := sk.getSet()
+		iter := set.createIterator()
+		switch encoding {
+		case "intset":
+			width := int64(2)
+			for iter.next() {
+				n, _ := strconv.ParseInt(iter.key, 10, 64)
+				if n < math.MinInt32 || n > math.MaxInt32 {
+					width = 8
+					break
+				} else if n < math.MinInt16 || n > math.MaxInt16 {
+					width = 4
+				}
+			}
+			return memObject + allocSize(8+width*int64(set.count))
+
+		case "listpack":
+			size := int64(memListpackHeader)
+			for iter.next() {
+				size += listpackEntrySize(iter.key)
+			}
+			return memObject + allocSize(size)
+		}
+
+		for iter.next() {
+			if !ms.add(memDictEntry + sdsAllocSize(len(iter.key))) {
+				break
+			}
+		}
+		return memObject + memDict + 8*dictSlots(set.count) + ms.estimate(set.count)
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_ZSET):
+		z := sk.getZSet()
+		if encoding == "listpack" {
+			size := int64(memListpackHeader)
+			z.iterate(false, func(member string, score float64) bool {
+				size += listpackEntrySize(member) + listpackEntrySize(strconv.FormatFloat(score, 'g', 17, 64))
+				return true
+			})
+			return memObject + allocSize(size)
+		}
+
+		z.iterate(false, func(member string, score float64) bool {
+			return ms.add(memDictEntry + sdsAllocSize(len(member)) + memSkiplistNode)
+		})
+		return memObject + memZSet + memDict + 8*dictSlots(z.count()) +
+			memSkiplist + memSkiplistHeader + ms.estimate(z.count())
+
+	case flagHasOne(sk.flags, FLAG_KEY_TYPE_STREAM):
+		st := sk.getStream()
+		for _, entry := range st.entries {
+			// the id deltas and flags, then the fields and values
+			size := 3 * listpackEntrySize("0")
+			for _, field := range entry.fields {
+				size += listpackEntrySize(field)
+			}
+			if !ms.add(size) {
+				break
+			}
+		}
+		entries := ms.estimate(len(st.entries))
+
+		// the entries are split into listpack nodes by count and by size
+		nodes := max((int64(len(st.entries))+streamNodeMaxEntries-1)/streamNodeMaxEntries, (entries+streamNodeMaxBytes-1)/streamNodeMaxBytes)
+		size := int64(memObject + memStream)
+		if nodes > 0 {
+			size += nodes * (memStreamNode + allocSize(memListpackHeader+entries/nodes))
+		}
+
+		for _, group := range st.groups {
+			size += memStreamGroup + sdsAllocSize(len(group.name)) + int64(len(group.pending))*memStreamNack
+			for _, consumer := range group.consumers {
+				size += memStreamConsumer + sdsAllocSize(len(consumer.name))
+			}
+		}
+		return size
+	}
+
+	return memObject
+}
+
+// estimates the memory of a key for MEMORY USAGE, without counting as an
+// access; samples of 0 includes every element of an aggregate
+func (dsc *dataStoreCommand) memoryUsage(keyName string, samples int) (size int64, exists bool) {
+	dsc.lock()
+	defer dsc.unlock()
+
+	val, exists := dsc.ds.data.get(keyName)
+	if !exists {
+		return
+	}
+	sk := val.(*storeKey)
+	if sk.isExpiredUnlocked() {
+		exists = false
+		return
+	}
+
+	size = dsc.ds.keyMemoryUnlocked(keyName, sk, samples)
+	return
+}
+
+// stores a key in the keyspace, and accounts for its memory
+func (ds *dataStore) storeKeyUnlocked(keyName string, sk *storeKey) {
+	if val, exists := ds.data.get(keyName); exists {
+		ds.addMemoryUnlocked(-val.(*storeKey).memSize)
+		ds.unblockDeletedStreamUnlocked(keyName, val.(*storeKey))
+	}
+	ds.data.store(keyName, sk)
+
+	// a moved or copied key isn't counted yet
+	sk.memSize = 0
+	ds.accountKeyUnlocked(keyName, sk)
+}
+
+// removes a key from the keyspace, and its memory from the total
+func (ds *dataStore) removeKeyUnlocked(keyName string) (exists bool) {
+	if val, found := ds.data.get(keyName); found {
+		ds.addMemoryUnlocked(-val.(*storeKey).memSize)
+		ds.unblockDeletedStreamUnlocked(keyName, val.(*storeKey))
+	}
+	return ds.data.remove(keyName)
+}
+
+// updates the memory estimate of a key after it has changed
+func (ds *dataStore) accountKeyUnlocked(keyName string, sk *storeKey) {
+	size := ds.keyMemoryUnlocked(keyName, sk, memSamplesDefault)
+	ds.addMemoryUnlocked(size - sk.memSize)
+	sk.memSize = size
+}
+
+// recomputes the memory of every key, after the keyspace has been loaded
+func (ds *dataStore) accountAllKeysUnlocked() {
+	ds.addMemoryUnlocked(-ds.memory)
+
+	iter := ds.data.createIterator()
+	for iter.next() {
+		sk := iter.value.(*storeKey)
+		sk.memSize = 0
+		ds.accountKeyUnlocked(iter.key, sk)
+	}
+}
+
+func (ds *dataStore) addMemoryUnlocked(delta int64) {
+	ds.memory += delta
+	if ds.dss != nil {
+		ds.dss.addKeyMemory(delta)
+	}
+}
+
+// adjusts the total memory of the keys of all data stores, and the peak
+func (dss *dataStoreSet) addKeyMemory(delta int64) {
+	total := atomic.AddInt64(&dss.keyMemory, delta)
+	if delta > 0 {
+		dss.notePeakMemory(memStartup + total)
+	}
+}
+
+func (dss *dataStoreSet) notePeakMemory(used int64) {
+	for {
+		peak := atomic.LoadInt64(&dss.peakMemory)
+		if used <= peak || atomic.CompareAndSwapInt64(&dss.peakMemory, peak, used) {
+			return
+		}
+	}
+}
+
+// computes the hash table overheads of the keyspace, as Redis would have them
+func (ds *dataStore) memoryOverheadUnlocked() (overhead dbMemoryOverhead) {
+	count := ds.data.count
+	volatile := 0
+	iter := ds.data.createIterator()
+	for iter.next() {
+		if iter.value.(*storeKey).expiresAt.Before(maxTime) {
+			volatile++
+		}
+	}
+
+	overhead.index = ds.index
+	overhead.main = 8*dictSlots(count) + memDictEntry*int64(count)
+	overhead.expires = 8*dictSlots(volatile) + memDictEntry*int64(volatile)
+	return
+}
+
+// gathers the memory estimate of all of the data stores; the data store of
+// dsc may be owned by a transaction or script
+func (dss *dataStoreSet) memoryStats(dsc *dataStoreCommand) (stats memoryStats) {
+	stats.startup = memStartup
+	dss.processAllClients(func(id int64, cs *clientState) {
+		stats.clients += memClientNormal
+	})
+	stats.overhead = stats.startup + stats.clients
+
+	var keyMemory int64
+	for _, ds := range dss.sortedDbs() {
+		dbsc := dsc
+		if ds != dsc.ds {
+			dbsc = ds.newDataStoreCommand()
+		}
+		dbsc.lock()
+		if ds.data.count > 0 {
+			overhead := ds.memoryOverheadUnlocked()
+			stats.dbs = append(stats.dbs, overhead)
+			stats.overhead += overhead.main + overhead.expires
+			stats.keys += int64(ds.data.count)
+			keyMemory += ds.memory
+		}
+		dbsc.unlock()
+	}
+
+	// the dictEntry of each key is part of the overhead
+	stats.dataset = keyMemory - memDictEntry*stats.keys
+	stats.total = stats.overhead + stats.dataset
+
+	dss.notePeakMemory(stats.total)
+	stats.peak = atomic.LoadInt64(&dss.peakMemory)
+	return
+}
+
+// the share of the memory used by the keys, of the memory that isn't
+// used at startup
+func (stats *memoryStats) datasetPercentage() float64 {
+	net := stats.total - stats.startup
+	if net <= 0 {
+		return 0
+	}
+	return float64(stats.dataset) * 100 / float64(net)
+}
+
+func (stats *memoryStats) peakPercentage() float64 {
+	if stats.peak == 0 {
+		return 0
+	}
+	return float64(stats.total) * 100 / float64(stats.peak)
+}
+
+func (stats *memoryStats) bytesPerKey() int64 {
+	if stats.keys == 0 {
+		return 0
+	}
+	return (stats.total - stats.startup) / stats.keys
+}
@@ -0,0 +1,57 @@
+package redisemu
+
+import "encoding/binary"
+
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	// adapted from MurmurHash2, 64-bit version, by Austin Appleby; this is
+	// the little endian variant used by Redis for HyperLogLog
+
+	const m = uint64(0xc6a4a7935bd1e995)
+	const r = 47
+
+	length := len(data)
+	h := seed ^ (uint64(length) * m)
+
+	blocks := length / 8
+	for i := 0; i < blocks; i++ {
+		k := binary.LittleEndian.Uint64(data[i*8:])
+
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+	}
+
+	tail := data[blocks*8:]
+	switch len(tail) {
+	case 7:
+		h ^= uint64(tail[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(tail[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(tail[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(tail[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(tail[0])
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+
+	return h
+}
@@ -28,6 +28,7 @@ type (
 		terminated  bool
 		respBuffer  []byte
 		clockBiasUs int64
+		pushes      []respValue
 	}
 )
 
@@ -169,6 +170,13 @@ func (rrc *realRedisClient) issueCommand(cxn net.Conn, cmdName string, args ...a
 		value, length, valid := des.deserializeNext()
 		if valid {
 			rrc.respBuffer = rrc.respBuffer[length:]
+
+			// push messages can arrive ahead of the command response
+			if _, isPush := value.data.(respPush); isPush {
+				rrc.pushes = append(rrc.pushes, value)
+				continue
+			}
+
 			output = value
 
 			// capture no-evict change, because there is no way to get its status from redis
@@ -270,3 +278,33 @@ func (rrc *realRedisClient) ClientAddr() string {
 func (rrc *realRedisClient) ServerNow() time.Time {
 	return time.Now().Add(time.Microsecond * time.Duration(rrc.clockBiasUs))
 }
+
+func (rrc *realRedisClient) SendOutOfBand(output respValue) {
+	panic("unreachable")
+}
+
+func (rrc *realRedisClient) PendingPushes() []respValue {
+	// collect any push messages that arrived without a command response
+	packet := make([]byte, 4096)
+	for {
+		des := newRespDeserializer(rrc.l, rrc.respBuffer)
+		value, length, valid := des.deserializeNext()
+		if valid {
+			rrc.respBuffer = rrc.respBuffer[length:]
+			rrc.pushes = append(rrc.pushes, value)
+			continue
+		}
+
+		rrc.cxn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		n, err := rrc.cxn.Read(packet)
+		rrc.cxn.SetReadDeadline(time.Time{})
+		if err != nil || n == 0 {
+			break
+		}
+		rrc.respBuffer = append(rrc.respBuffer, packet[0:n]...)
+	}
+
+	pushes := rrc.pushes
+	rrc.pushes = nil
+	return pushes
+}
@@ -20,6 +20,7 @@ type (
 		ServerAddr() string
 		ClientAddr() string
 		ServerNow() time.Time
+		SendOutOfBand(output respValue)
 	}
 )
 
@@ -31,6 +32,13 @@ func fnEcho(ctx *cmdContext, args map[string]any) (output respValue, err error)
 
 func fnPing(ctx *cmdContext, args map[string]any) (output respValue, err error) {
 	message, exists := args["message"].(string)
+
+	// a RESP2 client with subscriptions can only receive pub/sub messages
+	if ctx.cs.respVersion == 2 && ctx.cs.isSubscribed() {
+		output = pubsubMessage("pong", respValue{data: respBulkString(message)})
+		return
+	}
+
 	if exists {
 		output.data = respBulkString(message)
 	} else {
@@ -39,14 +47,29 @@ func fnPing(ctx *cmdContext, args map[string]any) (output respValue, err error)
 	return
 }
 
+func fnReset(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	cs := ctx.cs
+
+	cs.cmdQueue = nil
+	cs.cmdQueueErr = false
+	cs.watches = map[watchKey]uint64{}
+	cs.disableTracking()
+	cs.unsubscribeAll()
+	cs.stopMonitoring()
+	cs.selectDb(0, true)
+	cs.respVersion = 2
+	cs.name = ""
+	cs.noEvict = false
+
+	output.data = respSimpleString("RESET")
+	return
+}
+
 func fnClientUnblock(ctx *cmdContext, args map[string]any) (output respValue, err error) {
 	id := args["client-id"].(int64)
 	_, isError := args["unblock-type.error"]
 
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
-
-	client, exists := clients[id]
+	client, exists := ctx.cs.dss.clientById(id)
 	if exists {
 		reason := ""
 		if isError {
@@ -159,7 +182,7 @@ func fnClientKill(ctx *cmdContext, args map[string]any) (output respValue, err e
 		return
 	}
 
-	processAllClients(func(id int64, cs *clientState) {
+	ctx.cs.dss.processAllClients(func(id int64, cs *clientState) {
 		shouldClose := cs.client.MatchFilter(filter)
 
 		if shouldClose {
@@ -176,7 +199,7 @@ func fnClientKill(ctx *cmdContext, args map[string]any) (output respValue, err e
 					case "id":
 						shouldClose = (v == fmt.Sprintf("%d", cs.id))
 					case "type":
-						shouldClose = strings.EqualFold(v, "normal")
+						shouldClose = strings.EqualFold(v, cs.clientType())
 					case "user":
 						shouldClose = (v == cs.user)
 					}
@@ -209,10 +232,11 @@ func fnClientKill(ctx *cmdContext, args map[string]any) (output respValue, err e
 }
 
 func fnClientList(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	_, hasNormal := args["client-type.normal"]
 	_, hasPubSub := args["client-type.pubsub"]
 	_, hasReplica := args["client-type.replica"]
 	_, hasMaster := args["client-type.master"]
-	if hasPubSub || hasReplica || hasMaster {
+	if hasReplica || hasMaster {
 		output.data = respBulkString("")
 		return
 	}
@@ -227,11 +251,15 @@ func fnClientList(ctx *cmdContext, args map[string]any) (output respValue, err e
 
 	var list strings.Builder
 
-	processAllClients(func(id int64, cs *clientState) {
+	ctx.cs.dss.processAllClients(func(id int64, cs *clientState) {
 		included := true
 		if len(ids) > 0 {
 			_, included = ids[cs.id]
 		}
+		if hasNormal || hasPubSub {
+			isPubSub := cs.clientType() == "pubsub"
+			included = included && isPubSub == hasPubSub
+		}
 		if included {
 			info := ctx.info(cs)
 			list.WriteString(info)
@@ -351,6 +351,101 @@ func TestRedisClientList(t *testing.T) {
 	}
 }
 
+func TestRedisClientIsolation(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	// a second emulator has its own clients, ids and counters
+	other := NewRedisTestClient(t)
+	defer other.Close()
+
+	output := other.ProcessCommand("client", "list")
+	str, _ := output.toString()
+	if countOccurences(str, "flags=") != 1 || !strings.Contains(str, "id=1 ") {
+		t.Fatal("isolated client list fail")
+	}
+
+	fields := infoFields(other.ProcessCommand("info", "clients"))
+	if fields["connected_clients"] != "1" {
+		t.Fatal("isolated connected clients fail")
+	}
+
+	output = other.ProcessCommand("client", "kill", "id", fmt.Sprintf("%d", ts2.ClientID()))
+	if !output.isInt(0) || ts2.IsCloseRequested() {
+		t.Fatal("isolated client kill fail")
+	}
+
+	output = ts.ProcessCommand("client", "kill", "addr", other.ClientAddr())
+	if !output.isInt(0) || other.IsCloseRequested() {
+		t.Fatal("isolated client kill addr fail")
+	}
+
+	fields = infoFields(ts.ProcessCommand("info", "clients"))
+	if fields["connected_clients"] != "2" {
+		t.Fatal("connected clients fail")
+	}
+}
+
+func TestRedisRegistryIsolation(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	monitor := ts.AdditionalClient()
+	defer monitor.Close()
+
+	// a second emulator has its own pub/sub, tracking and monitors
+	other := NewRedisTestClient(t)
+	defer other.Close()
+
+	ts.ProcessCommand("subscribe", "ch")
+	ts.ProcessCommand("psubscribe", "c*")
+	ts.ProcessCommand("client", "tracking", "on")
+	ts.ProcessCommand("get", "k")
+	monitor.ProcessCommand("monitor")
+	ts.PendingPushes()
+
+	output := other.ProcessCommand("publish", "ch", "hello")
+	if !output.isInt(0) {
+		t.Fatal("isolated publish fail")
+	}
+
+	output = other.ProcessCommand("pubsub", "channels")
+	if !output.isArray() {
+		t.Fatal("isolated pubsub channels fail")
+	}
+
+	output = other.ProcessCommand("pubsub", "numpat")
+	if !output.isInt(0) {
+		t.Fatal("isolated pubsub numpat fail")
+	}
+
+	other.ProcessCommand("set", "k", "v")
+	other.ProcessCommand("flushall")
+	if len(ts.PendingPushes()) != 0 {
+		t.Fatal("isolated messages fail")
+	}
+	if len(monitor.PendingPushes()) != 0 {
+		t.Fatal("isolated monitor fail")
+	}
+
+	output = ts.ProcessCommand("publish", "ch", "hello")
+	if !output.isInt(2) {
+		t.Fatal("publish fail")
+	}
+
+	ts.ProcessCommand("flushall")
+	pushes := ts.PendingPushes()
+	if len(pushes) != 3 || !isInvalidations(pushes[2:], nil) {
+		t.Fatal("flushall invalidation fail")
+	}
+
+	pushes = monitor.PendingPushes()
+	if len(pushes) != 2 {
+		t.Fatal("monitor fail")
+	}
+}
+
 func TestRedisClientNoEvict(t *testing.T) {
 	ts := NewRedisTestClient(t)
 	defer ts.Close()
@@ -0,0 +1,573 @@
+package redisemu
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	configString configKind = iota
+	configBool
+	configInt
+	configMemory
+	configEnum
+	configCustom
+)
+
+type (
+	configKind int
+
+	// configParam is a runtime configuration parameter of CONFIG GET and CONFIG SET
+	configParam struct {
+		kind      configKind
+		def       string   // the default value
+		min, max  int64    // the range of an integer or memory value
+		enum      []string // the values of an enum
+		immutable bool     // can only be set at startup
+
+		// normalizes a configCustom value
+		normalize func(value string) (normalized string, errText string)
+
+		// makes a new value take effect
+		apply func(dss *dataStoreSet, value string)
+	}
+
+	// serverConfig holds the values of the configuration parameters
+	serverConfig struct {
+		mu     sync.Mutex
+		values map[string]string // normalized values, by parameter name
+		file   string            // the redis.conf file written by CONFIG REWRITE
+	}
+)
+
+var configParams = map[string]*configParam{
+	"appendonly": {kind: configBool, def: "no"},
+	"databases":  {kind: configInt, def: "16", min: 1, max: math.MaxInt32, immutable: true},
+
+	// the thresholds of the compact encodings reported by OBJECT ENCODING
+	"hash-max-listpack-entries": {kind: configInt, def: "128", min: 0, max: math.MaxInt64},
+	"hash-max-listpack-value":   {kind: configInt, def: "64", min: 0, max: math.MaxInt64},
+	"list-max-listpack-size":    {kind: configInt, def: "-2", min: math.MinInt32, max: math.MaxInt32},
+	"set-max-intset-entries":    {kind: configInt, def: "512", min: 0, max: math.MaxInt64},
+	"set-max-listpack-entries":  {kind: configInt, def: "128", min: 0, max: math.MaxInt64},
+	"set-max-listpack-value":    {kind: configInt, def: "64", min: 0, max: math.MaxInt64},
+	"zset-max-listpack-entries": {kind: configInt, def: "128", min: 0, max: math.MaxInt64},
+	"zset-max-listpack-value":   {kind: configInt, def: "64", min: 0, max: math.MaxInt64},
+
+	// latency-monitor-threshold is in milliseconds, and zero disables the
+	// latency monitor
+	"latency-monitor-threshold": {kind: configInt, def: "0", min: 0, max: math.MaxInt64},
+	"latency-tracking":          {kind: configBool, def: "yes"},
+	"latency-tracking-info-percentiles": {
+		kind:      configCustom,
+		def:       "50 99 99.9",
+		normalize: normalizePercentiles,
+	},
+
+	"lfu-decay-time": {kind: configInt, def: "1", min: 0, max: math.MaxInt32},
+	"lfu-log-factor": {kind: configInt, def: "10", min: 0, max: math.MaxInt32},
+	"maxclients":     {kind: configInt, def: "10000", min: 1, max: math.MaxInt32},
+	"maxmemory":      {kind: configMemory, def: "0", min: 0, max: math.MaxInt64},
+	"maxmemory-policy": {
+		kind: configEnum,
+		def:  "noeviction",
+		enum: []string{"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl", "allkeys-lru", "allkeys-lfu", "allkeys-random", "noeviction"},
+	},
+	"notify-keyspace-events": {
+		kind: configCustom,
+		normalize: func(value string) (normalized string, errText string) {
+			flags, valid := parseKeyspaceEvents(value)
+			if !valid {
+				errText = "Invalid event class character. Use 'Ag$lshzxeKEtmdn'."
+				return
+			}
+			normalized = keyspaceEventsString(flags)
+			return
+		},
+		apply: func(dss *dataStoreSet, value string) {
+			flags, _ := parseKeyspaceEvents(value)
+			dss.setKeyspaceEventFlags(flags)
+		},
+	},
+	// pairs of <seconds> <changes>; the emulator saves every second that
+	// there are changes
+	"save": {
+		kind: configCustom,
+		def:  "1 1",
+		normalize: func(value string) (normalized string, errText string) {
+			rules, valid := parseSaveRules(value)
+			if !valid {
+				errText = "Invalid save parameters"
+				return
+			}
+			parts := make([]string, 0, len(rules)*2)
+			for _, rule := range rules {
+				parts = append(parts, strconv.FormatInt(rule.seconds, 10), strconv.FormatInt(rule.changes, 10))
+			}
+			normalized = strings.Join(parts, " ")
+			return
+		},
+	},
+	// the slow log records the commands that take at least the number of
+	// microseconds, or none when negative
+	"slowlog-log-slower-than": {kind: configInt, def: "10000", min: -1, max: math.MaxInt64},
+	"slowlog-max-len":         {kind: configInt, def: "128", min: 0, max: math.MaxInt32},
+	"timeout":                 {kind: configInt, def: "0", min: 0, max: math.MaxInt32},
+}
+
+func newServerConfig() *serverConfig {
+	sc := &serverConfig{
+		values: make(map[string]string, len(configParams)),
+	}
+	for name, param := range configParams {
+		sc.values[name] = param.def
+	}
+	return sc
+}
+
+// gets the normalized value of a parameter
+func (sc *serverConfig) get(name string) string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.values[name]
+}
+
+// gets the value of a configInt or configMemory parameter
+func (sc *serverConfig) intValue(name string) int64 {
+	n, _ := strconv.ParseInt(sc.get(name), 10, 64)
+	return n
+}
+
+// validates a parameter value and converts it to its normalized form
+func (param *configParam) parse(value string) (normalized string, errText string) {
+	switch param.kind {
+	case configBool:
+		switch strings.ToLower(value) {
+		case "yes":
+			normalized = "yes"
+		case "no":
+			normalized = "no"
+		default:
+			errText = "argument must be 'yes' or 'no'"
+		}
+
+	case configInt, configMemory:
+		var n int64
+		if param.kind == configInt {
+			var err error
+			if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+				errText = "argument couldn't be parsed into an integer"
+				return
+			}
+		} else {
+			var valid bool
+			if n, valid = parseMemory(value); !valid {
+				errText = "argument must be a memory value"
+				return
+			}
+		}
+		if n < param.min || n > param.max {
+			errText = fmt.Sprintf("argument must be between %d and %d inclusive", param.min, param.max)
+			return
+		}
+		normalized = strconv.FormatInt(n, 10)
+
+	case configEnum:
+		lower := strings.ToLower(value)
+		for _, option := range param.enum {
+			if lower == option {
+				normalized = option
+				return
+			}
+		}
+		errText = fmt.Sprintf("argument(s) must be one of the following: %s", strings.Join(param.enum, ", "))
+
+	case configCustom:
+		normalized, errText = param.normalize(value)
+
+	default:
+		normalized = value
+	}
+	return
+}
+
+// formats a value for a redis.conf file
+func (param *configParam) format(value string) string {
+	switch param.kind {
+	case configString:
+		return configQuote(value)
+	case configMemory:
+		n, _ := strconv.ParseInt(value, 10, 64)
+		return formatMemory(n)
+	case configCustom:
+		if value == "" {
+			return configQuote(value)
+		}
+	}
+	return value
+}
+
+// parses a memory value such as "100mb"; like redis, "k" is 1000 and "kb" is 1024
+func parseMemory(value string) (n int64, valid bool) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	lower := strings.ToLower(value)
+	mul := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = lower[:len(lower)-len(unit.suffix)]
+			mul = unit.mul
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mul {
+		return
+	}
+	return n * mul, true
+}
+
+func formatMemory(n int64) string {
+	switch {
+	case n == 0:
+		return "0"
+	case n%(1024*1024*1024) == 0:
+		return fmt.Sprintf("%dgb", n/(1024*1024*1024))
+	case n%(1024*1024) == 0:
+		return fmt.Sprintf("%dmb", n/(1024*1024))
+	case n%1024 == 0:
+		return fmt.Sprintf("%dkb", n/1024)
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// quotes a string value for a redis.conf file
+func configQuote(value string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		switch {
+		case ch == '"' || ch == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(ch)
+		case ch == '\n':
+			sb.WriteString(`\n`)
+		case ch == '\r':
+			sb.WriteString(`\r`)
+		case ch == '\t':
+			sb.WriteString(`\t`)
+		case ch < ' ' || ch > '~':
+			sb.WriteString(fmt.Sprintf(`\x%02x`, ch))
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// splits a line of a redis.conf file into its arguments, removing quotes
+func splitConfigLine(line string) (args []string, valid bool) {
+	pos := 0
+	for {
+		for pos < len(line) && (line[pos] == ' ' || line[pos] == '\t') {
+			pos++
+		}
+		if pos >= len(line) {
+			return args, true
+		}
+
+		var sb strings.Builder
+		quote := byte(0)
+		if line[pos] == '"' || line[pos] == '\'' {
+			quote = line[pos]
+			pos++
+		}
+
+		for {
+			if pos >= len(line) {
+				if quote != 0 {
+					return // unbalanced quotes
+				}
+				break
+			}
+			ch := line[pos]
+			if quote == 0 && (ch == ' ' || ch == '\t') {
+				break
+			}
+			if quote != 0 && ch == quote {
+				pos++
+				if pos < len(line) && line[pos] != ' ' && line[pos] != '\t' {
+					return // closing quote must be followed by a space
+				}
+				break
+			}
+			if quote == '"' && ch == '\\' && pos+1 < len(line) {
+				pos++
+				switch line[pos] {
+				case 'n':
+					ch = '\n'
+				case 'r':
+					ch = '\r'
+				case 't':
+					ch = '\t'
+				case 'x':
+					if pos+2 < len(line) {
+						if b, err := strconv.ParseUint(line[pos+1:pos+3], 16, 8); err == nil {
+							ch = byte(b)
+							pos += 2
+						}
+					}
+				default:
+					ch = line[pos]
+				}
+			}
+			sb.WriteByte(ch)
+			pos++
+		}
+		args = append(args, sb.String())
+	}
+}
+
+// sets a parameter, validating its value; immutable parameters can only be
+// set when force is true
+func (sc *serverConfig) set(name, value string, force bool) (errText string) {
+	param, exists := configParams[name]
+	if !exists {
+		return "Unknown option"
+	}
+	if param.immutable && !force {
+		return "can't set immutable config"
+	}
+
+	normalized, errText := param.parse(value)
+	if errText != "" {
+		return
+	}
+
+	sc.mu.Lock()
+	sc.values[name] = normalized
+	sc.mu.Unlock()
+	return
+}
+
+// makes the configuration values take effect in a new data store set
+func (sc *serverConfig) applyAll(dss *dataStoreSet) {
+	for name, param := range configParams {
+		if param.apply != nil {
+			param.apply(dss, sc.get(name))
+		}
+	}
+}
+
+// reads the directives of a redis.conf file, and makes the file the one
+// that CONFIG REWRITE writes
+func (sc *serverConfig) loadFile(fileName string) error {
+	content, err := os.ReadFile(fileName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	saveRules := []string{}
+	for idx, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		args, valid := splitConfigLine(line)
+		if !valid || len(args) < 2 {
+			return fmt.Errorf("%s line %d: Bad directive or wrong number of arguments", fileName, idx+1)
+		}
+
+		// directives the emulator doesn't implement are ignored, and are kept
+		// by CONFIG REWRITE
+		name := strings.ToLower(args[0])
+		if _, exists := configParams[name]; !exists {
+			continue
+		}
+
+		// save rules accumulate across lines
+		value := strings.Join(args[1:], " ")
+		if name == "save" {
+			saveRules = append(saveRules, value)
+			value = strings.TrimSpace(strings.Join(saveRules, " "))
+		}
+
+		if errText := sc.set(name, value, true); errText != "" {
+			return fmt.Errorf("%s line %d: %s", fileName, idx+1, errText)
+		}
+	}
+
+	sc.mu.Lock()
+	sc.file = fileName
+	sc.mu.Unlock()
+	return nil
+}
+
+// writes the configuration to the redis.conf file; lines of the file are
+// updated in place, and parameters that aren't at their defaults are appended
+func (sc *serverConfig) rewrite() (errText string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.file == "" {
+		return "ERR The server is running without a config file"
+	}
+
+	content, err := os.ReadFile(sc.file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Sprintf("ERR Rewriting config file: %s", err)
+	}
+
+	lines := []string{}
+	if len(content) > 0 {
+		lines = strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	}
+
+	written := map[string]struct{}{}
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		args, _ := splitConfigLine(strings.TrimSpace(line))
+		if len(args) == 0 || strings.HasPrefix(args[0], "#") {
+			out = append(out, line)
+			continue
+		}
+
+		name := strings.ToLower(args[0])
+		param, exists := configParams[name]
+		if !exists {
+			out = append(out, line)
+			continue
+		}
+		if _, dup := written[name]; dup {
+			continue
+		}
+		written[name] = struct{}{}
+		out = append(out, name+" "+param.format(sc.values[name]))
+	}
+
+	names := make([]string, 0, len(configParams))
+	for name := range configParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	generated := false
+	for _, name := range names {
+		param := configParams[name]
+		if _, exists := written[name]; exists || sc.values[name] == param.def {
+			continue
+		}
+		if !generated {
+			out = append(out, "# Generated by CONFIG REWRITE")
+			generated = true
+		}
+		out = append(out, name+" "+param.format(sc.values[name]))
+	}
+
+	if err = os.WriteFile(sc.file, []byte(strings.Join(out, "\n")+"\n"), 0644); err != nil {
+		return fmt.Sprintf("ERR Rewriting config file: %s", err)
+	}
+	return
+}
+
+func fnConfigGet(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	patterns := argStrings(args["parameter"])
+
+	names := []string{}
+	for name := range configParams {
+		for _, pattern := range patterns {
+			if redisGlob([]rune(strings.ToLower(pattern)), []rune(name)) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+
+	m := newRespMapSized(len(names))
+	for _, name := range names {
+		m.set(respValue{data: respBulkString(name)}, respValue{data: respBulkString(ctx.cs.dss.config.get(name))})
+	}
+	output.data = m
+	return
+}
+
+func fnConfigSet(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	pairs, _ := args["data"].([]any)
+	sc := ctx.cs.dss.config
+
+	// validate all of the parameters before changing any of them
+	names := make([]string, 0, len(pairs))
+	values := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		table := pair.(*orderedMap)
+		name := strings.ToLower(table.mustGet("parameter").(string))
+		param, exists := configParams[name]
+		if !exists {
+			output.data = respErrorString(fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", name))
+			return
+		}
+
+		errText := ""
+		if param.immutable {
+			errText = "can't set immutable config"
+		} else if slices.Contains(names, name) {
+			errText = "duplicate parameter"
+		} else {
+			_, errText = param.parse(table.mustGet("value").(string))
+		}
+		if errText != "" {
+			output.data = respErrorString(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", name, errText))
+			return
+		}
+
+		names = append(names, name)
+		values = append(values, table.mustGet("value").(string))
+	}
+
+	for idx, name := range names {
+		sc.set(name, values[idx], false)
+		if apply := configParams[name].apply; apply != nil {
+			apply(ctx.cs.dss, sc.get(name))
+		}
+	}
+
+	output.data = rstrOK
+	return
+}
+
+func fnConfigResetStat(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	ctx.cs.dss.info.resetStats()
+	ctx.cs.dss.resetLatencyHistograms()
+	ctx.cs.dss.resetServerStats()
+	output.data = rstrOK
+	return
+}
+
+func fnConfigRewrite(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	if errText := ctx.cs.dss.config.rewrite(); errText != "" {
+		output.data = respErrorString(errText)
+		return
+	}
+
+	output.data = rstrOK
+	return
+}
@@ -0,0 +1,216 @@
+package redisemu
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigGetSet(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("config", "get", "maxmemory", "databases", "time*")
+	if !output.isMap(map[any]any{"maxmemory": "0", "databases": "16", "timeout": "0"}) {
+		t.Fatal("config get fail")
+	}
+
+	output = ts.ProcessCommand("config", "set", "maxmemory", "100mb", "maxmemory-policy", "ALLKEYS-LRU")
+	if !output.isString("OK") {
+		t.Fatal("config set fail")
+	}
+
+	output = ts.ProcessCommand("config", "get", "maxmemory*")
+	if !output.isMap(map[any]any{"maxmemory": "104857600", "maxmemory-policy": "allkeys-lru"}) {
+		t.Fatal("config get after set fail")
+	}
+
+	ts.ProcessCommand("config", "set", "maxmemory", "1k")
+	output = ts.ProcessCommand("config", "get", "maxmemory")
+	if !output.isMap(map[any]any{"maxmemory": "1000"}) {
+		t.Fatal("config set memory units fail")
+	}
+
+	output = ts.ProcessCommand("config", "get", "nothing*")
+	if !output.isMap(map[any]any{}) {
+		t.Fatal("config get no match fail")
+	}
+}
+
+func TestConfigSetErrors(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("config", "set", "bogus", "1")
+	if !output.isErrorString("ERR Unknown option or number of arguments for CONFIG SET - 'bogus'") {
+		t.Fatal("config set unknown fail")
+	}
+
+	output = ts.ProcessCommand("config", "set", "databases", "4")
+	if !output.isErrorString("ERR CONFIG SET failed (possibly related to argument 'databases') - can't set immutable config") {
+		t.Fatal("config set immutable fail")
+	}
+
+	output = ts.ProcessCommand("config", "set", "timeout", "soon")
+	if !output.isErrorString("ERR CONFIG SET failed (possibly related to argument 'timeout') - argument couldn't be parsed into an integer") {
+		t.Fatal("config set int fail")
+	}
+
+	output = ts.ProcessCommand("config", "set", "timeout", "-1")
+	if !output.isErrorString("ERR CONFIG SET failed (possibly related to argument 'timeout') - argument must be between 0 and 2147483647 inclusive") {
+		t.Fatal("config set range fail")
+	}
+
+	output = ts.ProcessCommand("config", "set", "appendonly", "maybe")
+	if !output.isErrorString("ERR CONFIG SET failed (possibly related to argument 'appendonly') - argument must be 'yes' or 'no'") {
+		t.Fatal("config set bool fail")
+	}
+
+	output = ts.ProcessCommand("config", "set", "maxmemory-policy", "random")
+	if !output.isErrorType() || !strings.Contains(output.String(), "argument(s) must be one of the following") {
+		t.Fatal("config set enum fail")
+	}
+
+	output = ts.ProcessCommand("config", "set", "save", "60")
+	if !output.isErrorString("ERR CONFIG SET failed (possibly related to argument 'save') - Invalid save parameters") {
+		t.Fatal("config set save fail")
+	}
+
+	// nothing is set when any of the parameters are invalid
+	output = ts.ProcessCommand("config", "set", "timeout", "30", "maxmemory", "lots")
+	if !output.isErrorString("ERR CONFIG SET failed (possibly related to argument 'maxmemory') - argument must be a memory value") {
+		t.Fatal("config set multiple fail")
+	}
+	output = ts.ProcessCommand("config", "get", "timeout")
+	if !output.isMap(map[any]any{"timeout": "0"}) {
+		t.Fatal("config set multiple unchanged fail")
+	}
+
+	output = ts.ProcessCommand("config", "set", "timeout", "30", "timeout", "40")
+	if !output.isErrorString("ERR CONFIG SET failed (possibly related to argument 'timeout') - duplicate parameter") {
+		t.Fatal("config set duplicate fail")
+	}
+}
+
+func TestConfigDatabases(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	tc, isTestClient := ts.(*testClient)
+	if !isTestClient {
+		t.Skip("databases can't be changed on a running server")
+	}
+
+	output := ts.ProcessCommand("select", "15")
+	if !output.isString("OK") {
+		t.Fatal("select last db fail")
+	}
+
+	output = ts.ProcessCommand("select", "16")
+	if !output.isErrorString("ERR DB index is out of range") {
+		t.Fatal("select out of range fail")
+	}
+
+	tc.dss.config.set("databases", "4", true)
+	output = ts.ProcessCommand("select", "4")
+	if !output.isErrorString("ERR DB index is out of range") {
+		t.Fatal("select configured out of range fail")
+	}
+}
+
+func TestConfigResetStat(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	tc, isTestClient := ts.(*testClient)
+	if !isTestClient {
+		t.Skip("counters are internal to the emulator")
+	}
+
+	ri := &tc.dss.info
+	ri.mu.Lock()
+	ri.total_commands_processed = 10
+	ri.total_net_input_bytes = 100
+	ri.mu.Unlock()
+
+	output := ts.ProcessCommand("config", "resetstat")
+	if !output.isString("OK") {
+		t.Fatal("config resetstat fail")
+	}
+
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	if ri.total_commands_processed != 0 || ri.total_net_input_bytes != 0 {
+		t.Fatal("config resetstat counters fail")
+	}
+}
+
+func TestConfigRewrite(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	tc, isTestClient := ts.(*testClient)
+	if !isTestClient {
+		t.Skip("config file is set at startup")
+	}
+
+	output := ts.ProcessCommand("config", "rewrite")
+	if !output.isErrorString("ERR The server is running without a config file") {
+		t.Fatal("config rewrite without file fail")
+	}
+
+	fileName := filepath.Join(t.TempDir(), "redis.conf")
+	original := "# test config\ntimeout 10\nunknown-option keep\nsave 60 5\nsave 30 10\n"
+	if err := os.WriteFile(fileName, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.dss.config.loadFile(fileName); err != nil {
+		t.Fatal("config load file fail: ", err)
+	}
+
+	output = ts.ProcessCommand("config", "get", "timeout", "save")
+	if !output.isMap(map[any]any{"timeout": "10", "save": "60 5 30 10"}) {
+		t.Fatal("config load file values fail")
+	}
+
+	ts.ProcessCommand("config", "set", "timeout", "20", "maxmemory", "2gb", "notify-keyspace-events", "KEA")
+	output = ts.ProcessCommand("config", "rewrite")
+	if !output.isString("OK") {
+		t.Fatal("config rewrite fail")
+	}
+
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# test config\ntimeout 20\nunknown-option keep\nsave 60 5 30 10\n" +
+		"# Generated by CONFIG REWRITE\nmaxmemory 2gb\nnotify-keyspace-events AKE\n"
+	if string(content) != expected {
+		t.Fatalf("config rewrite content fail: %q", string(content))
+	}
+}
+
+func TestConfigParse(t *testing.T) {
+	args, valid := splitConfigLine(`dir "/tmp/with space" 'single' plain`)
+	if !valid || len(args) != 4 || args[1] != "/tmp/with space" || args[2] != "single" || args[3] != "plain" {
+		t.Fatal("split config line fail")
+	}
+
+	if _, valid = splitConfigLine(`dir "unbalanced`); valid {
+		t.Fatal("split config line unbalanced fail")
+	}
+
+	for text, expected := range map[string]int64{"0": 0, "5": 5, "1b": 1, "2k": 2000, "2kb": 2048, "3MB": 3 * 1024 * 1024, "1g": 1000 * 1000 * 1000} {
+		if n, valid := parseMemory(text); !valid || n != expected {
+			t.Fatalf("parse memory %s fail", text)
+		}
+	}
+	if _, valid := parseMemory("-1"); valid {
+		t.Fatal("parse negative memory fail")
+	}
+
+	if formatMemory(3*1024*1024) != "3mb" || formatMemory(1500) != "1500" {
+		t.Fatal("format memory fail")
+	}
+}
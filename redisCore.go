@@ -27,6 +27,29 @@ func fnCopy(ctx *cmdContext, args map[string]any) (output respValue, err error)
 	return
 }
 
+func fnMove(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	index := args["db"].(int64)
+
+	dds, valid := ctx.cs.dss.getDb(int(index), true)
+	if !valid {
+		output.data = respErrorString("ERR DB index is out of range")
+		return
+	}
+	if dds == ctx.dsc.ds {
+		output.data = respErrorString("ERR source and destination objects are the same")
+		return
+	}
+
+	result := ctx.dsc.move(keyName, keyName, dds, false)
+	if result == RESULT_COMPLETED {
+		output.data = respInt(1)
+	} else {
+		output.data = respInt(0)
+	}
+	return
+}
+
 func doDelete(ctx *cmdContext, args map[string]any, reclaim bool) (output respValue, err error) {
 	keyArray := args["key"].([]any)
 
@@ -263,6 +286,77 @@ func fnType(ctx *cmdContext, args map[string]any) (output respValue, err error)
 	return
 }
 
+var objectHelpText = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+func fnObjectEncoding(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	info, exists := ctx.dsc.objectInfo(args["key"].(string))
+	if exists {
+		output.data = respBulkString(info.encoding)
+	}
+	return
+}
+
+func fnObjectFreq(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	if !ctx.dsc.ds.isLfuPolicy() {
+		output.data = respErrorString("ERR An LFU maxmemory policy is not selected, access frequency not tracked. " +
+			"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		return
+	}
+
+	info, exists := ctx.dsc.objectInfo(args["key"].(string))
+	if exists {
+		output.data = respInt(info.freq)
+	}
+	return
+}
+
+func fnObjectHelp(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	a := make(respArray, 0, len(objectHelpText))
+	for _, line := range objectHelpText {
+		a = append(a, respValue{data: respSimpleString(line)})
+	}
+	output.data = a
+	return
+}
+
+func fnObjectIdleTime(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	if ctx.dsc.ds.isLfuPolicy() {
+		output.data = respErrorString("ERR An LRU maxmemory policy is not selected, access time not tracked. " +
+			"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		return
+	}
+
+	info, exists := ctx.dsc.objectInfo(args["key"].(string))
+	if exists {
+		output.data = respInt(int64(info.idle.Seconds()))
+	}
+	return
+}
+
+func fnObjectRefCount(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	info, exists := ctx.dsc.objectInfo(args["key"].(string))
+	if exists {
+		output.data = respInt(info.refCount)
+	}
+	return
+}
+
 func fnQuit(ctx *cmdContext, args map[string]any) (output respValue, err error) {
 	output.data = rstrOK
 	return
@@ -373,15 +467,25 @@ func fnSort(ctx *cmdContext, args map[string]any) (output respValue, err error)
 }
 
 func fnFlushAll(ctx *cmdContext, args map[string]any) (output respValue, err error) {
-	ctx.cs.dss.flushAll()
-	ctx.cs.selectDb(ctx.cs.selectedDb, true)
+	ctx.cs.dss.flushAll(ctx.dsc)
 	output.data = rstrOK
 	return
 }
 
 func fnFlushDb(ctx *cmdContext, args map[string]any) (output respValue, err error) {
-	ctx.cs.dss.flushDb(ctx.cs.selectedDb)
-	ctx.cs.selectDb(ctx.cs.selectedDb, true)
+	ctx.cs.dss.flushDb(ctx.dsc)
+	output.data = rstrOK
+	return
+}
+
+func fnSwapDb(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	index1 := args["index1"].(int64)
+	index2 := args["index2"].(int64)
+
+	if !ctx.cs.dss.swapDb(ctx.dsc, int(index1), int(index2)) {
+		output.data = respErrorString("ERR DB index is out of range")
+		return
+	}
 	output.data = rstrOK
 	return
 }
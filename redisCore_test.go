@@ -2,6 +2,7 @@ package redisemu
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -406,6 +407,55 @@ func TestRedisDumpRestore(t *testing.T) {
 	}
 }
 
+func TestRedisDumpRestoreZSet(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zadd", "z", "1", "a", "2.5", "b", "-3", "c")
+	if !output.isInt(3) {
+		t.Fatal("zset dump step 1 fail")
+	}
+
+	output = ts.ProcessCommand("dump", "z")
+	val, valid := output.toString()
+	if !valid {
+		t.Fatal("zset dump step 2 fail")
+	}
+
+	output = ts.ProcessCommand("restore", "z2", "0", val)
+	if !output.isString("OK") {
+		t.Fatal("zset restore fail")
+	}
+
+	output = ts.ProcessCommand("type", "z2")
+	if !output.isString("zset") {
+		t.Fatal("zset restore type fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z2", "0", "-1", "withscores")
+	if !isZPairs(output, "c", -3.0, "a", 1.0, "b", 2.5) {
+		t.Fatal("zset restore members fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z2", "4", "d")
+	if !output.isInt(1) {
+		t.Fatal("zset restore zadd fail")
+	}
+
+	// a payload of a non-string type must decode
+	content := append([]byte(val[:2]), 0, 0, 0, 4, 'x', 'y', 'z')
+	corrupt := string(append(content, simpleChecksum(content)...))
+	output = ts.ProcessCommand("restore", "z3", "0", corrupt)
+	if !output.isErrorType() {
+		t.Fatal("zset restore corrupt fail")
+	}
+
+	output = ts.ProcessCommand("exists", "z3")
+	if !output.isInt(0) {
+		t.Fatal("zset restore corrupt exists fail")
+	}
+}
+
 func TestRedisExpire(t *testing.T) {
 	ts := NewRedisTestClient(t)
 	defer ts.Close()
@@ -1649,3 +1699,372 @@ func TestRedisDbSize(t *testing.T) {
 		t.Fatal("dbsize 0 fail")
 	}
 }
+
+func TestRedisMove(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("set", "cat", "meow")
+	output := ts.ProcessCommand("move", "cat", "1")
+	if !output.isInt(1) {
+		t.Fatal("move fail")
+	}
+
+	output = ts.ProcessCommand("exists", "cat")
+	if !output.isInt(0) {
+		t.Fatal("move source fail")
+	}
+
+	output = ts.ProcessCommand("move", "cat", "1")
+	if !output.isInt(0) {
+		t.Fatal("move missing fail")
+	}
+
+	// the destination key isn't replaced
+	ts.ProcessCommand("set", "cat", "purr")
+	output = ts.ProcessCommand("move", "cat", "1")
+	if !output.isInt(0) {
+		t.Fatal("move existing fail")
+	}
+
+	output = ts.ProcessCommand("move", "cat", "0")
+	if !output.isErrorString("ERR source and destination objects are the same") {
+		t.Fatal("move same db fail")
+	}
+
+	output = ts.ProcessCommand("move", "cat", "16")
+	if !output.isErrorString("ERR DB index is out of range") {
+		t.Fatal("move out of range fail")
+	}
+
+	ts.ProcessCommand("select", "1")
+	output = ts.ProcessCommand("get", "cat")
+	if !output.isString("meow") {
+		t.Fatal("move destination fail")
+	}
+}
+
+func TestRedisSwapDb(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	ts.ProcessCommand("set", "cat", "meow")
+	ts2.ProcessCommand("select", "1")
+	ts2.ProcessCommand("set", "dog", "woof")
+
+	output := ts.ProcessCommand("swapdb", "0", "1")
+	if !output.isString("OK") {
+		t.Fatal("swapdb fail")
+	}
+
+	// each client keeps its index and sees the swapped keys
+	output = ts.ProcessCommand("get", "dog")
+	if !output.isString("woof") {
+		t.Fatal("swapdb db 0 fail")
+	}
+	output = ts2.ProcessCommand("get", "cat")
+	if !output.isString("meow") {
+		t.Fatal("swapdb db 1 fail")
+	}
+	output = ts2.ProcessCommand("exists", "dog")
+	if !output.isInt(0) {
+		t.Fatal("swapdb db 1 old key fail")
+	}
+
+	// a database that hasn't been used yet
+	output = ts.ProcessCommand("swapdb", "0", "5")
+	if !output.isString("OK") {
+		t.Fatal("swapdb unused fail")
+	}
+	output = ts.ProcessCommand("dbsize")
+	if !output.isInt(0) {
+		t.Fatal("swapdb unused dbsize fail")
+	}
+
+	output = ts.ProcessCommand("swapdb", "0", "0")
+	if !output.isString("OK") {
+		t.Fatal("swapdb same fail")
+	}
+
+	output = ts.ProcessCommand("swapdb", "0", "16")
+	if !output.isErrorString("ERR DB index is out of range") {
+		t.Fatal("swapdb out of range fail")
+	}
+}
+
+func TestRedisSwapDbUnblocks(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	ts2.ProcessCommand("select", "1")
+	ts2.ProcessCommand("rpush", "list1", "cat")
+
+	done := make(chan respValue)
+	go func() {
+		done <- ts.ProcessCommand("blpop", "list1", "2")
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	output := ts2.ProcessCommand("swapdb", "0", "1")
+	if !output.isString("OK") {
+		t.Fatal("swapdb fail")
+	}
+
+	select {
+	case output = <-done:
+		if !output.isValue([]any{"list1", "cat"}) {
+			t.Fatal("swapdb blpop fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("swapdb did not unblock")
+	}
+}
+
+func TestRedisObjectEncoding(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	encodingOf := func(key string) string {
+		output := ts.ProcessCommand("object", "encoding", key)
+		s, _ := output.data.(respBulkString)
+		return string(s)
+	}
+
+	ts.ProcessCommand("set", "str", "12345")
+	if encodingOf("str") != "int" {
+		t.Fatal("int encoding fail")
+	}
+	ts.ProcessCommand("set", "str", "012345")
+	if encodingOf("str") != "embstr" {
+		t.Fatal("embstr encoding fail")
+	}
+	ts.ProcessCommand("set", "str", strings.Repeat("x", 45))
+	if encodingOf("str") != "raw" {
+		t.Fatal("raw encoding fail")
+	}
+	ts.ProcessCommand("set", "str", "1")
+	ts.ProcessCommand("append", "str", "2")
+	if encodingOf("str") != "raw" {
+		t.Fatal("append raw encoding fail")
+	}
+	ts.ProcessCommand("incr", "str")
+	if encodingOf("str") != "int" {
+		t.Fatal("incr int encoding fail")
+	}
+	ts.ProcessCommand("incrbyfloat", "str", "1")
+	if encodingOf("str") != "embstr" {
+		t.Fatal("incrbyfloat encoding fail")
+	}
+
+	// a hash stays a hashtable once converted
+	ts.ProcessCommand("hset", "hash", "field1", "value")
+	if encodingOf("hash") != "listpack" {
+		t.Fatal("hash listpack encoding fail")
+	}
+	ts.ProcessCommand("hset", "hash", "field2", strings.Repeat("x", 65))
+	if encodingOf("hash") != "hashtable" {
+		t.Fatal("hash value hashtable encoding fail")
+	}
+	ts.ProcessCommand("hdel", "hash", "field2")
+	if encodingOf("hash") != "hashtable" {
+		t.Fatal("hash converted encoding fail")
+	}
+
+	ts.ProcessCommand("sadd", "set", "1", "2", "3")
+	if encodingOf("set") != "intset" {
+		t.Fatal("intset encoding fail")
+	}
+	ts.ProcessCommand("sadd", "set", "cat")
+	if encodingOf("set") != "listpack" {
+		t.Fatal("set listpack encoding fail")
+	}
+	for i := 0; i < 130; i++ {
+		ts.ProcessCommand("sadd", "set", fmt.Sprintf("member%d", i))
+	}
+	if encodingOf("set") != "hashtable" {
+		t.Fatal("set hashtable encoding fail")
+	}
+
+	ts.ProcessCommand("config", "set", "set-max-intset-entries", "2")
+	ts.ProcessCommand("sadd", "ints", "1", "2", "3")
+	if encodingOf("ints") != "listpack" {
+		t.Fatal("intset entries encoding fail")
+	}
+
+	ts.ProcessCommand("zadd", "zset", "1", "cat")
+	if encodingOf("zset") != "listpack" {
+		t.Fatal("zset listpack encoding fail")
+	}
+	ts.ProcessCommand("zadd", "zset", "2", strings.Repeat("x", 65))
+	if encodingOf("zset") != "skiplist" {
+		t.Fatal("zset skiplist encoding fail")
+	}
+
+	// a list converts back when it shrinks to half the limit
+	ts.ProcessCommand("config", "set", "list-max-listpack-size", "4")
+	ts.ProcessCommand("rpush", "list", "a", "b", "c", "d")
+	if encodingOf("list") != "listpack" {
+		t.Fatal("list listpack encoding fail")
+	}
+	ts.ProcessCommand("rpush", "list", "e")
+	if encodingOf("list") != "quicklist" {
+		t.Fatal("list quicklist encoding fail")
+	}
+	ts.ProcessCommand("rpop", "list", "2")
+	if encodingOf("list") != "quicklist" {
+		t.Fatal("list hysteresis encoding fail")
+	}
+	ts.ProcessCommand("rpop", "list")
+	if encodingOf("list") != "listpack" {
+		t.Fatal("list converted back encoding fail")
+	}
+
+	ts.ProcessCommand("xadd", "stream", "*", "field", "value")
+	if encodingOf("stream") != "stream" {
+		t.Fatal("stream encoding fail")
+	}
+
+	output := ts.ProcessCommand("object", "encoding", "missing")
+	if !output.isNull() {
+		t.Fatal("missing key encoding fail")
+	}
+}
+
+func TestRedisObjectAccess(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	tc, isTestClient := ts.(*testClient)
+	if !isTestClient {
+		t.Skip("access times are internal")
+	}
+
+	ts.ProcessCommand("set", "key1", "cat")
+	sk, _ := tc.cs.ds.data.get("key1")
+	sk.(*storeKey).lastAccess = time.Now().Add(-10 * time.Second)
+
+	output := ts.ProcessCommand("object", "idletime", "key1")
+	if !output.isInt(10) {
+		t.Fatal("idletime fail")
+	}
+
+	// OBJECT doesn't count as an access
+	output = ts.ProcessCommand("object", "idletime", "key1")
+	if !output.isInt(10) {
+		t.Fatal("idletime no touch fail")
+	}
+
+	ts.ProcessCommand("get", "key1")
+	output = ts.ProcessCommand("object", "idletime", "key1")
+	if !output.isInt(0) {
+		t.Fatal("idletime after access fail")
+	}
+
+	output = ts.ProcessCommand("object", "freq", "key1")
+	if !output.isErrorType() {
+		t.Fatal("freq without lfu policy fail")
+	}
+
+	ts.ProcessCommand("config", "set", "maxmemory-policy", "allkeys-lfu")
+	output = ts.ProcessCommand("object", "idletime", "key1")
+	if !output.isErrorType() {
+		t.Fatal("idletime with lfu policy fail")
+	}
+
+	output = ts.ProcessCommand("object", "freq", "key1")
+	freq, _ := output.data.(respInt)
+	if freq < lfuInitVal {
+		t.Fatal("freq initial fail")
+	}
+
+	for i := 0; i < 1000; i++ {
+		ts.ProcessCommand("get", "key1")
+	}
+	output = ts.ProcessCommand("object", "freq", "key1")
+	if more, _ := output.data.(respInt); more <= freq || more > 255 {
+		t.Fatal("freq after accesses fail")
+	}
+
+	// the counter decays by one per lfu-decay-time minutes
+	sk.(*storeKey).lfuDecayedAt = time.Now().Add(-3 * time.Minute)
+	before := sk.(*storeKey).lfuCounter
+	output = ts.ProcessCommand("object", "freq", "key1")
+	if !output.isInt(int(before) - 3) {
+		t.Fatal("freq decay fail")
+	}
+
+	ts.ProcessCommand("set", "key2", "100")
+	output = ts.ProcessCommand("object", "refcount", "key2")
+	if !output.isInt(2147483647) {
+		t.Fatal("refcount shared fail")
+	}
+	output = ts.ProcessCommand("object", "refcount", "key1")
+	if !output.isInt(1) {
+		t.Fatal("refcount fail")
+	}
+}
+
+func TestRedisCopyKeepsEncoding(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("sadd", "set1", "cat", "dog")
+	output := ts.ProcessCommand("copy", "set1", "set2")
+	if !output.isInt(1) {
+		t.Fatal("copy set fail")
+	}
+
+	output = ts.ProcessCommand("smembers", "set2")
+	if !output.isArraySet("cat", "dog") {
+		t.Fatal("copy set members fail")
+	}
+
+	output = ts.ProcessCommand("object", "encoding", "set2")
+	if !output.isString("listpack") {
+		t.Fatal("copy set encoding fail")
+	}
+}
+
+func TestRedisCommandGetKeys(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("command", "getkeys", "xread", "count", "2", "streams", "s1", "s2", "0", "0")
+	if !output.isArray("s1", "s2") {
+		t.Fatal("getkeys xread fail")
+	}
+
+	output = ts.ProcessCommand("command", "getkeys", "xreadgroup", "group", "g", "c", "count", "1", "streams", "s1", "s2", ">", ">")
+	if !output.isArray("s1", "s2") {
+		t.Fatal("getkeys xreadgroup fail")
+	}
+
+	output = ts.ProcessCommand("command", "getkeys", "georadius", "k", "1", "2", "3", "m", "store", "dst")
+	if !output.isArray("k", "dst") {
+		t.Fatal("getkeys georadius store fail")
+	}
+
+	output = ts.ProcessCommand("command", "getkeys", "georadius", "k", "1", "2", "3", "m", "count", "5", "storedist", "dst")
+	if !output.isArray("k", "dst") {
+		t.Fatal("getkeys georadius storedist fail")
+	}
+
+	output = ts.ProcessCommand("command", "getkeys", "sort", "k", "by", "w_*", "store", "dst")
+	if !output.isArray("k", "dst") {
+		t.Fatal("getkeys sort store fail")
+	}
+
+	output = ts.ProcessCommand("command", "getkeys", "sort", "k", "limit", "0", "5")
+	if !output.isArray("k") {
+		t.Fatal("getkeys sort fail")
+	}
+
+	output = ts.ProcessCommand("command", "getkeysandflags", "sort", "k", "store", "dst")
+	if !output.isValue([]any{[]any{"k", []any{"RO", "access"}}, []any{"dst", []any{"OW", "update"}}}) {
+		t.Fatal("getkeysandflags sort store fail")
+	}
+}
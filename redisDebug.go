@@ -0,0 +1,200 @@
+package redisemu
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type debugHandler func(ctx *cmdContext, params []string) (output respValue)
+
+// the DEBUG subcommands; the command spec doesn't describe them, so each
+// handler gets the arguments that follow the subcommand name, and replies
+// nothing when the number of arguments is wrong
+var debugHandlers = map[string]debugHandler{
+	"help":              debugHelp,
+	"object":            debugObject,
+	"populate":          debugPopulate,
+	"reload":            debugReload,
+	"set-active-expire": debugSetActiveExpire,
+	"sleep":             debugSleep,
+	"waittable":         debugWaitTable,
+}
+
+var debugHelpText = []string{
+	"DEBUG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"OBJECT <key>",
+	"    Show low level info about the `key` and associated value.",
+	"POPULATE <count> [<prefix>] [<size>]",
+	"    Create <count> string keys named key:<num>. If <prefix> is specified then",
+	"    it is used instead of the 'key' prefix.",
+	"RELOAD [option ...]",
+	"    Save the RDB on disk and reload it back to memory. Valid <option> values:",
+	"    * MERGE: Merge the content of the RDB with the current data.",
+	"    * NOFLUSH: Do not empty the current data before loading the RDB.",
+	"    * NOSAVE: Do not save the current data before loading the RDB.",
+	"SET-ACTIVE-EXPIRE <0|1>",
+	"    Setting it to 0 disables expiring keys in background when they are not",
+	"    accessed (otherwise the Redis behavior). Setting it to 1 reenables back the",
+	"    default.",
+	"SLEEP <seconds>",
+	"    Stop the server for <seconds>. Decimals allowed.",
+	"WAITTABLE",
+	"    Show the blocked clients and the keys they wait on (emulator only).",
+	"HELP",
+	"    Print this help.",
+}
+
+func fnDebug(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	if len(ctx.rawArgs) < 2 {
+		output.data = respErrorString("ERR wrong number of arguments for 'debug' command")
+		return
+	}
+
+	params := make([]string, 0, len(ctx.rawArgs)-1)
+	for _, arg := range ctx.rawArgs[1:] {
+		text, _ := arg.toString()
+		params = append(params, text)
+	}
+
+	handler, exists := debugHandlers[strings.ToLower(params[0])]
+	if exists {
+		output = handler(ctx, params[1:])
+	}
+	if !exists || output.data == nil {
+		output.data = respErrorString(fmt.Sprintf("ERR unknown subcommand '%s'. Try DEBUG HELP.", params[0]))
+	}
+	return
+}
+
+func debugHelp(ctx *cmdContext, params []string) (output respValue) {
+	if len(params) != 0 {
+		return
+	}
+
+	a := make(respArray, 0, len(debugHelpText))
+	for _, line := range debugHelpText {
+		a = append(a, respValue{data: respSimpleString(line)})
+	}
+	output.data = a
+	return
+}
+
+func debugObject(ctx *cmdContext, params []string) (output respValue) {
+	if len(params) != 1 {
+		return
+	}
+
+	text, exists := ctx.dsc.debugObject(params[0])
+	if exists {
+		output.data = respSimpleString(text)
+	} else {
+		output.data = respErrorString("ERR no such key")
+	}
+	return
+}
+
+func debugPopulate(ctx *cmdContext, params []string) (output respValue) {
+	if len(params) < 1 || len(params) > 3 {
+		return
+	}
+
+	count, err := strconv.ParseInt(params[0], 10, 64)
+	if err != nil || count < 0 {
+		output.data = respErrorString("ERR value is out of range, must be positive")
+		return
+	}
+
+	prefix := "key"
+	if len(params) > 1 {
+		prefix = params[1]
+	}
+
+	var size int64
+	if len(params) > 2 {
+		size, err = strconv.ParseInt(params[2], 10, 64)
+		if err != nil || size < 0 {
+			output.data = respErrorString("ERR value is out of range, must be positive")
+			return
+		}
+	}
+
+	ctx.dsc.populate(count, prefix, size)
+	output.data = rstrOK
+	return
+}
+
+func debugReload(ctx *cmdContext, params []string) (output respValue) {
+	save, flush, merge := true, true, false
+	for _, param := range params {
+		switch strings.ToLower(param) {
+		case "merge":
+			merge = true
+		case "noflush":
+			flush = false
+		case "nosave":
+			save = false
+		default:
+			output.data = respErrorString("ERR DEBUG RELOAD only supports the MERGE, NOFLUSH and NOSAVE options.")
+			return
+		}
+	}
+
+	if err := ctx.cs.dss.reload(ctx.cs.l, ctx.dsc, save, flush, merge); err != nil {
+		output.data = respErrorString("ERR Error trying to load the RDB dump, check server logs.")
+		return
+	}
+
+	ctx.cs.l.Infof("DB reloaded by DEBUG RELOAD")
+	output.data = rstrOK
+	return
+}
+
+func debugSetActiveExpire(ctx *cmdContext, params []string) (output respValue) {
+	if len(params) != 1 {
+		return
+	}
+
+	enabled, err := strconv.ParseInt(params[0], 10, 64)
+	if err != nil {
+		output.data = respErrorString("ERR value is not an integer or out of range")
+		return
+	}
+
+	if enabled != 0 {
+		atomic.StoreUint32(&ctx.cs.dss.noActiveExpire, 0)
+	} else {
+		atomic.StoreUint32(&ctx.cs.dss.noActiveExpire, 1)
+	}
+	output.data = rstrOK
+	return
+}
+
+func debugSleep(ctx *cmdContext, params []string) (output respValue) {
+	if len(params) != 1 {
+		return
+	}
+
+	seconds, err := strconv.ParseFloat(params[0], 64)
+	if err != nil {
+		output.data = respErrorString("ERR value is not a valid float")
+		return
+	}
+
+	// Redis is single threaded, so the whole server stops; the emulator
+	// stops the commands that access the data stores
+	ctx.cs.dss.stall(ctx.dsc, time.Duration(seconds*float64(time.Second)))
+	output.data = rstrOK
+	return
+}
+
+func debugWaitTable(ctx *cmdContext, params []string) (output respValue) {
+	if len(params) != 0 {
+		return
+	}
+
+	output.data = respVerbatimString{format: "txt", text: ctx.cs.dss.dumpWaitTables(ctx.dsc)}
+	return
+}
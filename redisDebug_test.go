@@ -0,0 +1,267 @@
+package redisemu
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugHelp(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("debug", "help")
+	if a, _ := output.toArray(); len(a) != len(debugHelpText) || !a[0].isString(debugHelpText[0]) {
+		t.Fatal("debug help fail")
+	}
+
+	output = ts.ProcessCommand("debug", "bogus")
+	if !output.isErrorString("ERR unknown subcommand 'bogus'. Try DEBUG HELP.") {
+		t.Fatal("debug unknown subcommand fail")
+	}
+
+	output = ts.ProcessCommand("debug", "sleep")
+	if !output.isErrorString("ERR unknown subcommand 'sleep'. Try DEBUG HELP.") {
+		t.Fatal("debug wrong arguments fail")
+	}
+
+	output = ts.ProcessCommand("debug")
+	if !output.isErrorString("ERR wrong number of arguments for 'debug' command") {
+		t.Fatal("debug no subcommand fail")
+	}
+}
+
+func TestDebugPopulateAndObject(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("set", "key:3", "keep")
+	output := ts.ProcessCommand("debug", "populate", "10")
+	if !output.isString("OK") {
+		t.Fatal("debug populate fail")
+	}
+
+	output = ts.ProcessCommand("dbsize")
+	if !output.isInt(10) {
+		t.Fatal("debug populate count fail")
+	}
+	output = ts.ProcessCommand("mget", "key:0", "key:3", "key:9")
+	if !output.isArray("value:0", "keep", "value:9") {
+		t.Fatal("debug populate values fail")
+	}
+
+	ts.ProcessCommand("debug", "populate", "2", "p", "3")
+	ts.ProcessCommand("debug", "populate", "2", "q", "10")
+	output = ts.ProcessCommand("mget", "p:1", "q:1")
+	if !output.isArray("val", "value:1\x00\x00\x00") {
+		t.Fatal("debug populate size fail")
+	}
+
+	output = ts.ProcessCommand("debug", "populate", "-1")
+	if !output.isErrorString("ERR value is out of range, must be positive") {
+		t.Fatal("debug populate negative fail")
+	}
+
+	ts.ProcessCommand("set", "n", "123")
+	output = ts.ProcessCommand("debug", "object", "n")
+	text, _ := output.toString()
+	if !strings.HasPrefix(text, "Value at:0x") || !strings.Contains(text, " refcount:2147483647 encoding:int serializedlength:") ||
+		!strings.Contains(text, " lru_seconds_idle:0") {
+		t.Fatalf("debug object fail: %s", text)
+	}
+
+	output = ts.ProcessCommand("debug", "object", "missing")
+	if !output.isErrorString("ERR no such key") {
+		t.Fatal("debug object missing fail")
+	}
+}
+
+func TestDebugReload(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("set", "str", "cat", "ex", "100")
+	ts.ProcessCommand("rpush", "list", "a", "b", "c")
+	ts.ProcessCommand("hset", "hash", "f", "v")
+	ts.ProcessCommand("sadd", "set", "x", "y")
+	ts.ProcessCommand("zadd", "zset", "1", "m")
+	ts.ProcessCommand("xadd", "stream", "1-1", "f", "v")
+	ts.ProcessCommand("select", "1")
+	ts.ProcessCommand("set", "other", "dog")
+	ts.ProcessCommand("select", "0")
+
+	output := ts.ProcessCommand("debug", "reload")
+	if !output.isString("OK") {
+		t.Fatal("debug reload fail")
+	}
+
+	output = ts.ProcessCommand("get", "str")
+	if !output.isString("cat") {
+		t.Fatal("debug reload string fail")
+	}
+	output = ts.ProcessCommand("ttl", "str")
+	if !output.isAtLeast(99) {
+		t.Fatal("debug reload ttl fail")
+	}
+	output = ts.ProcessCommand("lrange", "list", "0", "-1")
+	if !output.isArray("a", "b", "c") {
+		t.Fatal("debug reload list fail")
+	}
+	output = ts.ProcessCommand("hget", "hash", "f")
+	if !output.isString("v") {
+		t.Fatal("debug reload hash fail")
+	}
+	output = ts.ProcessCommand("smembers", "set")
+	if !output.isArraySet("x", "y") {
+		t.Fatal("debug reload set fail")
+	}
+	output = ts.ProcessCommand("zscore", "zset", "m")
+	if !output.isString("1") && !output.isFloat(1, 0) {
+		t.Fatal("debug reload zset fail")
+	}
+	output = ts.ProcessCommand("xlen", "stream")
+	if !output.isInt(1) {
+		t.Fatal("debug reload stream fail")
+	}
+	ts.ProcessCommand("select", "1")
+	output = ts.ProcessCommand("get", "other")
+	if !output.isString("dog") {
+		t.Fatal("debug reload other db fail")
+	}
+
+	output = ts.ProcessCommand("debug", "reload", "noflush")
+	if !output.isErrorString("ERR Error trying to load the RDB dump, check server logs.") {
+		t.Fatal("debug reload duplicate fail")
+	}
+	output = ts.ProcessCommand("debug", "reload", "noflush", "merge")
+	if !output.isString("OK") {
+		t.Fatal("debug reload merge fail")
+	}
+	output = ts.ProcessCommand("debug", "reload", "fast")
+	if !output.isErrorString("ERR DEBUG RELOAD only supports the MERGE, NOFLUSH and NOSAVE options.") {
+		t.Fatal("debug reload option fail")
+	}
+}
+
+func TestDebugReloadFile(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	tc, isTestClient := ts.(*testClient)
+	if !isTestClient {
+		t.Skip("persistence path is set at startup")
+	}
+	tc.dss.basePath = filepath.Join(t.TempDir(), "emu")
+
+	ts.ProcessCommand("set", "key1", "saved")
+	saves := tc.dss.persistenceStats().saves
+	output := ts.ProcessCommand("debug", "reload")
+	if !output.isString("OK") {
+		t.Fatal("debug reload fail")
+	}
+	if _, err := os.Stat(tc.dss.dataStoreFileName(0)); err != nil {
+		t.Fatal("debug reload file fail")
+	}
+	if tc.dss.persistenceStats().saves != saves+1 || tc.dss.changesSinceSave() != 0 {
+		t.Fatal("debug reload save stats fail")
+	}
+
+	// without a save, the keys go back to the saved ones
+	ts.ProcessCommand("set", "key1", "unsaved")
+	ts.ProcessCommand("set", "key2", "unsaved")
+	output = ts.ProcessCommand("debug", "reload", "nosave")
+	if !output.isString("OK") {
+		t.Fatal("debug reload nosave fail")
+	}
+	output = ts.ProcessCommand("mget", "key1", "key2")
+	if !output.isArray("saved", nil) {
+		t.Fatal("debug reload nosave keys fail")
+	}
+}
+
+func TestDebugSetActiveExpire(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	tc, isTestClient := ts.(*testClient)
+	if !isTestClient {
+		t.Skip("active expire is driven by the server")
+	}
+
+	ts.ProcessCommand("config", "set", "notify-keyspace-events", "KEA")
+	ts.ProcessCommand("set", "key1", "cat", "px", "1")
+	time.Sleep(5 * time.Millisecond)
+
+	output := ts.ProcessCommand("debug", "set-active-expire", "0")
+	if !output.isString("OK") {
+		t.Fatal("debug set-active-expire off fail")
+	}
+	tc.dss.activeExpire()
+	output = ts.ProcessCommand("dbsize")
+	if !output.isInt(1) {
+		t.Fatal("active expire not disabled")
+	}
+
+	ts.ProcessCommand("debug", "set-active-expire", "1")
+	tc.dss.activeExpire()
+	output = ts.ProcessCommand("dbsize")
+	if !output.isInt(0) {
+		t.Fatal("active expire not enabled")
+	}
+}
+
+func TestDebugSleep(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	start := time.Now()
+	var stalled time.Duration
+	done := make(chan struct{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		ts2.ProcessCommand("get", "key1")
+		stalled = time.Since(start)
+		close(done)
+	}()
+
+	output := ts.ProcessCommand("debug", "sleep", "0.1")
+	if !output.isString("OK") || time.Since(start) < 100*time.Millisecond {
+		t.Fatal("debug sleep fail")
+	}
+	<-done
+	if stalled < 100*time.Millisecond {
+		t.Fatal("debug sleep didn't stall other clients")
+	}
+}
+
+func TestDebugWaitTable(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	output := ts.ProcessCommand("debug", "waittable")
+	if text, _ := output.toString(); text != "Blocked clients:\n" {
+		t.Fatalf("debug waittable empty fail: %q", text)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ts2.ProcessCommand("blpop", "queue", "1")
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	output = ts.ProcessCommand("debug", "waittable")
+	text, _ := output.toString()
+	if !strings.Contains(text, " Client ") || !strings.Contains(text, "# db 0\n") || !strings.Contains(text, " Object queue list:\n") {
+		t.Fatalf("debug waittable fail: %q", text)
+	}
+
+	ts.ProcessCommand("rpush", "queue", "x")
+	<-done
+}
@@ -0,0 +1,160 @@
+package redisemu
+
+import (
+	"errors"
+	"fmt"
+)
+
+func fnFCall(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	name := args["function"].(string)
+	readOnly := ctx.cmdToken == "fcall_ro"
+
+	keys, argv, errText := scriptParams(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	function := ctx.cs.dss.functions.findFunction(name)
+	if function == nil {
+		output.data = respErrorString("ERR Function not found")
+		return
+	}
+	if readOnly && !function.NoWrites {
+		output.data = respErrorString("ERR Can not execute a script with write flag using *_ro command.")
+		return
+	}
+
+	fh := &functionHandle{ctx: ctx, readOnly: function.NoWrites}
+	output = runScript(ctx, func() (reply respValue) {
+		result, fnErr := function.Fn(fh, keys, argv)
+		if fnErr != nil {
+			var replyErr functionReplyError
+			if errors.As(fnErr, &replyErr) {
+				reply.data = respErrorString(replyErr)
+			} else {
+				reply.data = respErrorString("ERR " + fnErr.Error())
+			}
+			return
+		}
+		return nativeValueToResp(result)
+	})
+	return
+}
+
+func fnFunctionLoad(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	code := args["function-code"].(string)
+	_, replace := args["replace"]
+
+	name, errText := ctx.cs.dss.functions.load(code, replace)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output.data = respBulkString(name)
+	return
+}
+
+func fnFunctionDelete(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	name := args["library-name"].(string)
+
+	if !ctx.cs.dss.functions.delete(name) {
+		output.data = respErrorString("ERR Library not found")
+		return
+	}
+
+	output.data = rstrOK
+	return
+}
+
+func fnFunctionFlush(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	ctx.cs.dss.functions.flush()
+	output.data = rstrOK
+	return
+}
+
+func fnFunctionKill(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	// functions run to completion while holding the data store
+	output.data = respErrorString("NOTBUSY No scripts in execution right now.")
+	return
+}
+
+func fnFunctionList(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	pattern, _ := args["library-name-pattern"].(string)
+	_, withCode := args["withcode"]
+
+	libs := ctx.cs.dss.functions.list(pattern)
+
+	a := make(respArray, 0, len(libs))
+	for _, lib := range libs {
+		functions := make(respArray, 0, len(lib.functions))
+		for _, function := range lib.functions {
+			flags := respSet{}
+			if function.NoWrites {
+				flags[respValue{data: respBulkString("no-writes")}] = struct{}{}
+			}
+
+			fm := newRespMapSized(3)
+			fm.set(respValue{data: respBulkString("name")}, respValue{data: respBulkString(function.Name)})
+			fm.set(respValue{data: respBulkString("description")}, respValue{})
+			fm.set(respValue{data: respBulkString("flags")}, respValue{data: flags})
+			functions = append(functions, respValue{data: fm})
+		}
+
+		m := newRespMapSized(4)
+		m.set(respValue{data: respBulkString("library_name")}, respValue{data: respBulkString(lib.name)})
+		m.set(respValue{data: respBulkString("engine")}, respValue{data: respBulkString("LUA")})
+		m.set(respValue{data: respBulkString("functions")}, respValue{data: functions})
+		if withCode {
+			m.set(respValue{data: respBulkString("library_code")}, respValue{data: respBulkString(lib.code)})
+		}
+		a = append(a, respValue{data: m})
+	}
+
+	output.data = a
+	return
+}
+
+func fnFunctionStats(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	libraries, functions := ctx.cs.dss.functions.counts()
+
+	engine := newRespMapSized(2)
+	engine.set(respValue{data: respBulkString("libraries_count")}, respValue{data: respInt(libraries)})
+	engine.set(respValue{data: respBulkString("functions_count")}, respValue{data: respInt(functions)})
+
+	engines := newRespMapSized(1)
+	engines.set(respValue{data: respBulkString("LUA")}, respValue{data: engine})
+
+	// a function always runs to completion before another command is processed
+	m := newRespMapSized(2)
+	m.set(respValue{data: respBulkString("running_script")}, respValue{})
+	m.set(respValue{data: respBulkString("engines")}, respValue{data: engines})
+
+	output.data = m
+	return
+}
+
+func fnFunctionDump(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	output.data = respBulkString(ctx.cs.dss.functions.dump())
+	return
+}
+
+func fnFunctionRestore(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	payload := args["serialized-value"].(string)
+
+	policy := "append"
+	for _, option := range []string{"flush", "append", "replace"} {
+		if _, exists := args[fmt.Sprintf("policy.%s", option)]; exists {
+			policy = option
+		}
+	}
+
+	if errText := ctx.cs.dss.functions.restore(payload, policy); errText != "" {
+		output.data = errText
+		return
+	}
+
+	output.data = rstrOK
+	return
+}
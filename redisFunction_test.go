@@ -0,0 +1,301 @@
+package redisemu
+
+import (
+	"errors"
+	"testing"
+)
+
+// makes a test client with a "counters" library of Go functions
+func newFunctionTestClient(t *testing.T) RedisTestClient {
+	ts := NewRedisTestClient(t)
+	tc, isTestClient := ts.(*testClient)
+	if !isTestClient {
+		ts.Close()
+		t.Skip("go function libraries require the emulator")
+	}
+
+	err := tc.dss.functions.register("counters",
+		[]LibraryFunction{
+			{
+				Name: "add",
+				Fn: func(h FunctionHandle, keys, args []string) (result any, err error) {
+					return h.Call("incrby", keys[0], args[0])
+				},
+			},
+			{
+				Name:     "peek",
+				NoWrites: true,
+				Fn: func(h FunctionHandle, keys, args []string) (result any, err error) {
+					return h.Call("get", keys[0])
+				},
+			},
+			{
+				Name:     "sneaky",
+				NoWrites: true,
+				Fn: func(h FunctionHandle, keys, args []string) (result any, err error) {
+					return h.Call("set", keys[0], "x")
+				},
+			},
+			{
+				Name: "broken",
+				Fn: func(h FunctionHandle, keys, args []string) (result any, err error) {
+					return nil, errors.New("something went wrong")
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal("register library fail: ", err)
+	}
+	return ts
+}
+
+func TestFunctionRegister(t *testing.T) {
+	fr := newFunctionRegistry()
+	fn := func(h FunctionHandle, keys, args []string) (result any, err error) { return }
+
+	if err := fr.register("bad-name", []LibraryFunction{{Name: "f", Fn: fn}}); err == nil {
+		t.Fatal("register bad library name fail")
+	}
+	if err := fr.register("lib", []LibraryFunction{{Name: "f", Fn: fn}, {Name: "f", Fn: fn}}); err == nil {
+		t.Fatal("register duplicate function fail")
+	}
+	if err := fr.register("lib", []LibraryFunction{{Name: "f", Fn: fn}}); err != nil {
+		t.Fatal("register fail: ", err)
+	}
+	if err := fr.register("lib2", []LibraryFunction{{Name: "f", Fn: fn}}); err == nil {
+		t.Fatal("register conflicting function fail")
+	}
+	if err := fr.register("lib", []LibraryFunction{{Name: "g", Fn: fn}}); err != nil {
+		t.Fatal("register replace fail: ", err)
+	}
+	if fr.findFunction("f") != nil || fr.findFunction("g") == nil {
+		t.Fatal("register replaced functions fail")
+	}
+}
+
+func TestFCall(t *testing.T) {
+	ts := newFunctionTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("fcall", "add", "1", "n", "5")
+	if !output.isInt(5) {
+		t.Fatal("fcall add fail")
+	}
+
+	output = ts.ProcessCommand("fcall_ro", "peek", "1", "n")
+	if !output.isString("5") {
+		t.Fatal("fcall_ro peek fail")
+	}
+
+	output = ts.ProcessCommand("fcall_ro", "add", "1", "n", "5")
+	if !output.isErrorString("ERR Can not execute a script with write flag using *_ro command.") {
+		t.Fatal("fcall_ro write function fail")
+	}
+
+	output = ts.ProcessCommand("fcall", "sneaky", "1", "n")
+	if !output.isErrorString("ERR Write commands are not allowed from read-only scripts.") {
+		t.Fatal("fcall no-writes function fail")
+	}
+
+	output = ts.ProcessCommand("fcall", "broken", "0")
+	if !output.isErrorString("ERR something went wrong") {
+		t.Fatal("fcall function error fail")
+	}
+
+	ts.ProcessCommand("set", "s", "text")
+	output = ts.ProcessCommand("fcall", "add", "1", "s", "1")
+	if !output.isErrorString("ERR value is not an integer or out of range") {
+		t.Fatal("fcall command error fail")
+	}
+
+	output = ts.ProcessCommand("fcall", "missing", "0")
+	if !output.isErrorString("ERR Function not found") {
+		t.Fatal("fcall missing fail")
+	}
+
+	output = ts.ProcessCommand("fcall", "add", "2", "n")
+	if !output.isErrorString("ERR Number of keys can't be greater than number of args") {
+		t.Fatal("fcall numkeys fail")
+	}
+
+	// a function call within a transaction
+	ts.ProcessCommand("multi")
+	ts.ProcessCommand("fcall", "add", "1", "n", "1")
+	ts.ProcessCommand("get", "n")
+	output = ts.ProcessCommand("exec")
+	if !output.isArray(6, "6") {
+		t.Fatal("fcall exec fail")
+	}
+}
+
+func TestFunctionListAndStats(t *testing.T) {
+	ts := newFunctionTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("function", "list")
+	list, valid := output.data.(respArray)
+	if !valid || len(list) != 1 {
+		t.Fatal("function list fail")
+	}
+	lib, valid := list[0].data.(respMap)
+	if !valid {
+		t.Fatal("function list library fail")
+	}
+	if name := lib.mustGet(respValue{data: respBulkString("library_name")}); !name.isString("counters") {
+		t.Fatal("function list name fail")
+	}
+	if _, exists := lib.get(respValue{data: respBulkString("library_code")}); exists {
+		t.Fatal("function list without code fail")
+	}
+	functions, _ := lib.mustGet(respValue{data: respBulkString("functions")}).data.(respArray)
+	if len(functions) != 4 {
+		t.Fatal("function list functions fail")
+	}
+	peek, _ := functions[1].data.(respMap)
+	if name := peek.mustGet(respValue{data: respBulkString("name")}); !name.isString("peek") {
+		t.Fatal("function list function name fail")
+	}
+	if flags := peek.mustGet(respValue{data: respBulkString("flags")}); !flags.isSet("no-writes") {
+		t.Fatal("function list function flags fail")
+	}
+
+	output = ts.ProcessCommand("function", "list", "libraryname", "count*", "withcode")
+	list, valid = output.data.(respArray)
+	if !valid || len(list) != 1 {
+		t.Fatal("function list pattern fail")
+	}
+	lib, _ = list[0].data.(respMap)
+	if code := lib.mustGet(respValue{data: respBulkString("library_code")}); !code.isString("#!lua name=counters\n") {
+		t.Fatal("function list withcode fail")
+	}
+
+	output = ts.ProcessCommand("function", "list", "libraryname", "other*")
+	if !output.isArray() {
+		t.Fatal("function list no match fail")
+	}
+
+	output = ts.ProcessCommand("function", "stats")
+	if !output.isMap(map[any]any{
+		"running_script": nil,
+		"engines": map[any]any{
+			"LUA": map[any]any{"libraries_count": 1, "functions_count": 4},
+		},
+	}) {
+		t.Fatal("function stats fail")
+	}
+
+	output = ts.ProcessCommand("function", "kill")
+	if !output.isErrorString("NOTBUSY No scripts in execution right now.") {
+		t.Fatal("function kill fail")
+	}
+}
+
+func TestFunctionLoadAndDelete(t *testing.T) {
+	ts := newFunctionTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("function", "delete", "counters")
+	if !output.isString("OK") {
+		t.Fatal("function delete fail")
+	}
+
+	output = ts.ProcessCommand("function", "delete", "counters")
+	if !output.isErrorString("ERR Library not found") {
+		t.Fatal("function delete missing fail")
+	}
+
+	output = ts.ProcessCommand("fcall", "add", "1", "n", "1")
+	if !output.isErrorString("ERR Function not found") {
+		t.Fatal("fcall deleted fail")
+	}
+
+	// client code loads the library by name
+	code := "#!lua name=counters\nredis.register_function('add', function(keys, args) return 0 end)"
+	output = ts.ProcessCommand("function", "load", code)
+	if !output.isString("counters") {
+		t.Fatal("function load fail")
+	}
+
+	output = ts.ProcessCommand("function", "load", code)
+	if !output.isErrorString("ERR Library 'counters' already exists") {
+		t.Fatal("function load exists fail")
+	}
+
+	output = ts.ProcessCommand("function", "load", "replace", code)
+	if !output.isString("counters") {
+		t.Fatal("function load replace fail")
+	}
+
+	output = ts.ProcessCommand("fcall", "add", "1", "n", "1")
+	if !output.isInt(1) {
+		t.Fatal("fcall loaded fail")
+	}
+
+	output = ts.ProcessCommand("function", "load", "return 1")
+	if !output.isErrorString("ERR Missing library metadata") {
+		t.Fatal("function load no metadata fail")
+	}
+
+	output = ts.ProcessCommand("function", "load", "#!lua name=unknown\nreturn 1")
+	if !output.isErrorString("ERR Library 'unknown' does not have a registered Go implementation") {
+		t.Fatal("function load unknown fail")
+	}
+
+	output = ts.ProcessCommand("function", "flush")
+	if !output.isString("OK") {
+		t.Fatal("function flush fail")
+	}
+
+	output = ts.ProcessCommand("function", "list")
+	if !output.isArray() {
+		t.Fatal("function list after flush fail")
+	}
+}
+
+func TestFunctionDumpAndRestore(t *testing.T) {
+	ts := newFunctionTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("function", "dump")
+	payload, valid := output.toString()
+	if !valid {
+		t.Fatal("function dump fail")
+	}
+
+	output = ts.ProcessCommand("function", "restore", payload)
+	if !output.isErrorString("ERR Library 'counters' already exists") {
+		t.Fatal("function restore append fail")
+	}
+
+	output = ts.ProcessCommand("function", "restore", payload, "replace")
+	if !output.isString("OK") {
+		t.Fatal("function restore replace fail")
+	}
+
+	ts.ProcessCommand("function", "flush")
+	output = ts.ProcessCommand("function", "restore", payload)
+	if !output.isString("OK") {
+		t.Fatal("function restore fail")
+	}
+
+	output = ts.ProcessCommand("fcall", "add", "1", "n", "2")
+	if !output.isInt(2) {
+		t.Fatal("fcall restored fail")
+	}
+
+	output = ts.ProcessCommand("function", "restore", payload, "flush")
+	if !output.isString("OK") {
+		t.Fatal("function restore flush fail")
+	}
+
+	output = ts.ProcessCommand("function", "restore", payload[:len(payload)-1]+"x")
+	if !output.isErrorString("ERR payload version or checksum are wrong") {
+		t.Fatal("function restore checksum fail")
+	}
+
+	output = ts.ProcessCommand("function", "dump")
+	if !output.isString(payload) {
+		t.Fatal("function dump round trip fail")
+	}
+}
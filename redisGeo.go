@@ -0,0 +1,434 @@
+package redisemu
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+type (
+	geoSort int
+
+	geoPoint struct {
+		member    string
+		score     float64
+		longitude float64
+		latitude  float64
+		distance  float64 // meters from the center of the search
+	}
+
+	// a GEOSEARCH or GEORADIUS request
+	geoSearchSpec struct {
+		shape      geoShape
+		fromMember *string // the shape is centered on a member instead of a coordinate
+		sort       geoSort
+		count      int // 0 for no limit
+		any        bool
+		withCoord  bool
+		withDist   bool
+		withHash   bool
+	}
+)
+
+const (
+	GEO_SORT_NONE geoSort = iota
+	GEO_SORT_ASC
+	GEO_SORT_DESC
+)
+
+var geoUnits = []struct {
+	name   string
+	meters float64
+}{
+	{"m", 1},
+	{"km", 1000},
+	{"ft", 0.3048},
+	{"mi", 1609.34},
+}
+
+var errGeoMemberNotFound = respErrorString("ERR could not decode requested zset member")
+
+// gets the meters per unit of a "unit" oneof argument
+func parseGeoUnit(args map[string]any) float64 {
+	for _, unit := range geoUnits {
+		if _, exists := args["unit."+unit.name]; exists {
+			return unit.meters
+		}
+	}
+	return 1
+}
+
+func geoInvalidLongLat(longitude, latitude float64) respErrorString {
+	return respErrorString(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude))
+}
+
+// reads the sort, count and reply options common to GEOSEARCH and GEORADIUS
+func parseGeoSearchOptions(args map[string]any, spec *geoSearchSpec) (errText respErrorString) {
+	if _, asc := args["order.asc"]; asc {
+		spec.sort = GEO_SORT_ASC
+	} else if _, desc := args["order.desc"]; desc {
+		spec.sort = GEO_SORT_DESC
+	}
+
+	if countBlock, exists := args["count-block"].(*orderedMap); exists {
+		count := countBlock.mustGet("count").(int64)
+		if count <= 0 {
+			errText = respErrorString("ERR COUNT must be > 0")
+			return
+		}
+		spec.count = int(count)
+		_, spec.any = countBlock.get("any")
+	}
+
+	_, spec.withCoord = args["withcoord"]
+	_, spec.withDist = args["withdist"]
+	_, spec.withHash = args["withhash"]
+	return
+}
+
+// reads the FROMMEMBER/FROMLONLAT and BYRADIUS/BYBOX options of GEOSEARCH
+func parseGeoSearchShape(args map[string]any, spec *geoSearchSpec) (errText respErrorString) {
+	if member, exists := args["from.member"].(string); exists {
+		spec.fromMember = &member
+	} else {
+		fromLonLat := args["from.fromlonlat"].(*orderedMap)
+		spec.shape.longitude = fromLonLat.mustGet("longitude").(float64)
+		spec.shape.latitude = fromLonLat.mustGet("latitude").(float64)
+		if !geoLongLatValid(spec.shape.longitude, spec.shape.latitude) {
+			errText = geoInvalidLongLat(spec.shape.longitude, spec.shape.latitude)
+			return
+		}
+	}
+
+	if circle, exists := args["by.circle"].(*orderedMap); exists {
+		spec.shape.radius = circle.mustGet("radius").(float64)
+		spec.shape.conversion = parseGeoUnit(circle.toNative())
+		if spec.shape.radius < 0 {
+			errText = respErrorString("ERR radius cannot be negative")
+		}
+	} else {
+		box := args["by.box"].(*orderedMap)
+		spec.shape.byBox = true
+		spec.shape.width = box.mustGet("width").(float64)
+		spec.shape.height = box.mustGet("height").(float64)
+		spec.shape.conversion = parseGeoUnit(box.toNative())
+		if spec.shape.width < 0 || spec.shape.height < 0 {
+			errText = respErrorString("ERR height or width cannot be negative")
+		}
+	}
+	return
+}
+
+// reads the arguments of GEORADIUS and GEORADIUSBYMEMBER, and their read-only forms
+func parseGeoRadiusArgs(args map[string]any, byMember bool) (spec *geoSearchSpec, errText respErrorString) {
+	spec = &geoSearchSpec{}
+
+	if byMember {
+		member := args["member"].(string)
+		spec.fromMember = &member
+	} else {
+		spec.shape.longitude = args["longitude"].(float64)
+		spec.shape.latitude = args["latitude"].(float64)
+		if !geoLongLatValid(spec.shape.longitude, spec.shape.latitude) {
+			errText = geoInvalidLongLat(spec.shape.longitude, spec.shape.latitude)
+			return
+		}
+	}
+
+	spec.shape.radius = args["radius"].(float64)
+	spec.shape.conversion = parseGeoUnit(args)
+	if spec.shape.radius < 0 {
+		errText = respErrorString("ERR radius cannot be negative")
+		return
+	}
+
+	errText = parseGeoSearchOptions(args, spec)
+	return
+}
+
+// finds the members inside the shape, visiting the geohash boxes in the same
+// order as Redis; a limit stops the search as soon as enough are found
+func geoSearchZSet(z *zset, shape *geoShape, limit int) (points []geoPoint) {
+	radius := shape.areas()
+	boxes := radius.boxes()
+
+	lastProcessed := 0
+	for i, box := range boxes {
+		if box.isZero() {
+			continue
+		}
+
+		// with a huge radius, adjacent neighbors can be the same box
+		if lastProcessed != 0 && box == boxes[lastProcessed] {
+			continue
+		}
+
+		if limit > 0 && len(points) >= limit {
+			break
+		}
+
+		min, max := box.scoreRange()
+		r := zscoreRange{min: min, max: max, maxExclusive: true}
+		z.iterateScoreRange(&r, false, func(member string, score float64) bool {
+			longitude, latitude := geoScoreToLongLat(score)
+			if distance, inside := shape.contains(longitude, latitude); inside {
+				points = append(points, geoPoint{
+					member:    member,
+					score:     score,
+					longitude: longitude,
+					latitude:  latitude,
+					distance:  distance,
+				})
+			}
+			return limit == 0 || len(points) < limit
+		})
+
+		lastProcessed = i
+	}
+	return
+}
+
+// orders the points by distance and applies the count limit
+func (spec *geoSearchSpec) arrange(points []geoPoint) []geoPoint {
+	// COUNT needs sorting to return the closest members, unless ANY is specified
+	order := spec.sort
+	if spec.count != 0 && order == GEO_SORT_NONE && !spec.any {
+		order = GEO_SORT_ASC
+	}
+
+	switch order {
+	case GEO_SORT_ASC:
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].distance < points[j].distance
+		})
+	case GEO_SORT_DESC:
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].distance > points[j].distance
+		})
+	}
+
+	if spec.count != 0 && len(points) > spec.count {
+		points = points[:spec.count]
+	}
+	return points
+}
+
+func geoDistanceToResp(meters, conversion float64) respValue {
+	return respValue{data: respBulkString(strconv.FormatFloat(meters/conversion, 'f', 4, 64))}
+}
+
+func geoCoordToResp(longitude, latitude float64) respValue {
+	return respValue{data: respArray{
+		{data: respDouble(longitude)},
+		{data: respDouble(latitude)},
+	}}
+}
+
+func (spec *geoSearchSpec) pointsToResp(points []geoPoint) respValue {
+	a := make(respArray, 0, len(points))
+	for _, point := range points {
+		member := respValue{data: respBulkString(point.member)}
+		if !spec.withDist && !spec.withHash && !spec.withCoord {
+			a = append(a, member)
+			continue
+		}
+
+		item := respArray{member}
+		if spec.withDist {
+			item = append(item, geoDistanceToResp(point.distance, spec.shape.conversion))
+		}
+		if spec.withHash {
+			item = append(item, respValue{data: respInt(int64(point.score))})
+		}
+		if spec.withCoord {
+			item = append(item, geoCoordToResp(point.longitude, point.latitude))
+		}
+		a = append(a, respValue{data: item})
+	}
+	return respValue{data: a}
+}
+
+func fnGeoAdd(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	data := args["data"].([]any)
+
+	var flags bitflags
+	if _, nx := args["condition.nx"]; nx {
+		flags |= ZADD_NX
+	}
+	if _, xx := args["condition.xx"]; xx {
+		flags |= ZADD_XX
+	}
+	if _, ch := args["change"]; ch {
+		flags |= ZADD_CH
+	}
+
+	// validate every coordinate before adding any of them
+	members := make([]string, 0, len(data))
+	scores := make([]float64, 0, len(data))
+	for _, item := range data {
+		m := item.(*orderedMap)
+		longitude := m.mustGet("longitude").(float64)
+		latitude := m.mustGet("latitude").(float64)
+
+		score, valid := geoScore(longitude, latitude)
+		if !valid {
+			output.data = geoInvalidLongLat(longitude, latitude)
+			return
+		}
+
+		members = append(members, m.mustGet("member").(string))
+		scores = append(scores, score)
+	}
+
+	output = ctx.dsc.zadd(keyName, members, scores, flags)
+	return
+}
+
+func fnGeoDist(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	member1 := args["member1"].(string)
+	member2 := args["member2"].(string)
+
+	scores, errText := ctx.dsc.zscore(keyName, []string{member1, member2})
+	if errText != nil {
+		output.data = *errText
+		return
+	}
+	if scores[0] == nil || scores[1] == nil {
+		return
+	}
+
+	long1, lat1 := geoScoreToLongLat(scores[0].(float64))
+	long2, lat2 := geoScoreToLongLat(scores[1].(float64))
+	output = geoDistanceToResp(geoDistance(long1, lat1, long2, lat2), parseGeoUnit(args))
+	return
+}
+
+func fnGeoHash(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	membersAny, _ := args["member"].([]any)
+
+	members := make([]string, 0, len(membersAny))
+	for _, member := range membersAny {
+		members = append(members, member.(string))
+	}
+
+	scores, errText := ctx.dsc.zscore(keyName, members)
+	if errText != nil {
+		output.data = *errText
+		return
+	}
+
+	hashes := make(respArray, 0, len(scores))
+	for _, score := range scores {
+		if score == nil {
+			hashes = append(hashes, respValue{})
+		} else {
+			hashes = append(hashes, respValue{data: respBulkString(geoHashString(score.(float64)))})
+		}
+	}
+
+	output.data = hashes
+	return
+}
+
+func fnGeoPos(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	membersAny, _ := args["member"].([]any)
+
+	members := make([]string, 0, len(membersAny))
+	for _, member := range membersAny {
+		members = append(members, member.(string))
+	}
+
+	scores, errText := ctx.dsc.zscore(keyName, members)
+	if errText != nil {
+		output.data = *errText
+		return
+	}
+
+	positions := make(respArray, 0, len(scores))
+	for _, score := range scores {
+		if score == nil {
+			positions = append(positions, respValue{data: respNull{}})
+		} else {
+			positions = append(positions, geoCoordToResp(geoScoreToLongLat(score.(float64))))
+		}
+	}
+
+	output.data = positions
+	return
+}
+
+func fnGeoSearch(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	spec := &geoSearchSpec{}
+	if errText := parseGeoSearchShape(args, spec); errText != "" {
+		output.data = errText
+		return
+	}
+	if errText := parseGeoSearchOptions(args, spec); errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.geosearch(keyName, spec)
+	return
+}
+
+func fnGeoSearchStore(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	destKeyName := args["destination"].(string)
+	srcKeyName := args["source"].(string)
+	_, storeDist := args["storedist"]
+
+	spec := &geoSearchSpec{}
+	if errText := parseGeoSearchShape(args, spec); errText != "" {
+		output.data = errText
+		return
+	}
+	if errText := parseGeoSearchOptions(args, spec); errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.geosearchStore(destKeyName, srcKeyName, "geosearchstore", spec, storeDist)
+	return
+}
+
+func geoRadiusCommon(ctx *cmdContext, args map[string]any, byMember bool) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	spec, errText := parseGeoRadiusArgs(args, byMember)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	storeKeyName, store := args["store.storekey"].(string)
+	storeDistKeyName, storeDist := args["store.storedistkey"].(string)
+	if !store && !storeDist {
+		output = ctx.dsc.geosearch(keyName, spec)
+		return
+	}
+
+	if spec.withDist || spec.withHash || spec.withCoord {
+		output.data = respErrorString("ERR STORE option in GEORADIUS is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+		return
+	}
+
+	if storeDist {
+		storeKeyName = storeDistKeyName
+	}
+	output = ctx.dsc.geosearchStore(storeKeyName, keyName, "georadiusstore", spec, storeDist)
+	return
+}
+
+func fnGeoRadius(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	return geoRadiusCommon(ctx, args, false)
+}
+
+func fnGeoRadiusByMember(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	return geoRadiusCommon(ctx, args, true)
+}
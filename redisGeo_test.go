@@ -0,0 +1,344 @@
+package redisemu
+
+import (
+	"testing"
+)
+
+func addSicily(t *testing.T, ts RedisTestClient) {
+	output := ts.ProcessCommand("geoadd", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+	if !output.isInt(2) {
+		t.Fatal("geoadd sicily fail")
+	}
+}
+
+func TestGeoAdd(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	addSicily(t, ts)
+
+	output := ts.ProcessCommand("type", "Sicily")
+	if !output.isString("zset") {
+		t.Fatal("geoadd type fail")
+	}
+
+	output = ts.ProcessCommand("zscore", "Sicily", "Palermo")
+	if !output.isFloat(3479099956230698, 0) {
+		t.Fatal("geoadd zscore step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zscore", "Sicily", "Catania")
+	if !output.isFloat(3479447370796909, 0) {
+		t.Fatal("geoadd zscore step 2 fail")
+	}
+
+	output = ts.ProcessCommand("geoadd", "Sicily", "13.361389", "38.115556", "Palermo")
+	if !output.isInt(0) {
+		t.Fatal("geoadd existing fail")
+	}
+
+	output = ts.ProcessCommand("geoadd", "Sicily", "ch", "13.5", "38.1", "Palermo", "13.583333", "37.316667", "Agrigento")
+	if !output.isInt(2) {
+		t.Fatal("geoadd ch fail")
+	}
+
+	output = ts.ProcessCommand("geoadd", "Sicily", "nx", "13.361389", "38.115556", "Palermo")
+	if !output.isInt(0) {
+		t.Fatal("geoadd nx fail")
+	}
+
+	output = ts.ProcessCommand("geoadd", "Sicily", "xx", "13.361389", "38.115556", "Palermo", "14.5", "37.5", "Enna")
+	if !output.isInt(0) {
+		t.Fatal("geoadd xx step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zscore", "Sicily", "Palermo")
+	if !output.isFloat(3479099956230698, 0) {
+		t.Fatal("geoadd xx step 2 fail")
+	}
+
+	output = ts.ProcessCommand("zcard", "Sicily")
+	if !output.isInt(3) {
+		t.Fatal("geoadd xx step 3 fail")
+	}
+
+	// nothing is added when any pair is invalid
+	output = ts.ProcessCommand("geoadd", "Sicily", "14", "37", "Valid", "181", "37", "Invalid")
+	if !output.isErrorString("ERR invalid longitude,latitude pair 181.000000,37.000000") {
+		t.Fatal("geoadd invalid step 1 fail")
+	}
+
+	output = ts.ProcessCommand("geoadd", "Sicily", "14", "85.06", "Invalid")
+	if !output.isErrorString("ERR invalid longitude,latitude pair 14.000000,85.060000") {
+		t.Fatal("geoadd invalid step 2 fail")
+	}
+
+	output = ts.ProcessCommand("zcard", "Sicily")
+	if !output.isInt(3) {
+		t.Fatal("geoadd invalid step 3 fail")
+	}
+
+	ts.ProcessCommand("set", "str", "value")
+	output = ts.ProcessCommand("geoadd", "str", "14", "37", "member")
+	if !output.isErrorType() {
+		t.Fatal("geoadd wrong type fail")
+	}
+}
+
+func TestGeoDist(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	addSicily(t, ts)
+
+	output := ts.ProcessCommand("geodist", "Sicily", "Palermo", "Catania")
+	if !output.isString("166274.1516") {
+		t.Fatal("geodist m fail")
+	}
+
+	output = ts.ProcessCommand("geodist", "Sicily", "Palermo", "Catania", "km")
+	if !output.isString("166.2742") {
+		t.Fatal("geodist km fail")
+	}
+
+	output = ts.ProcessCommand("geodist", "Sicily", "Palermo", "Catania", "mi")
+	if !output.isString("103.3182") {
+		t.Fatal("geodist mi fail")
+	}
+
+	output = ts.ProcessCommand("geodist", "Sicily", "Palermo", "Palermo")
+	if !output.isString("0.0000") {
+		t.Fatal("geodist same fail")
+	}
+
+	output = ts.ProcessCommand("geodist", "Sicily", "Foo", "Bar")
+	if !output.isNull() {
+		t.Fatal("geodist missing member fail")
+	}
+
+	output = ts.ProcessCommand("geodist", "missing", "Palermo", "Catania")
+	if !output.isNull() {
+		t.Fatal("geodist missing key fail")
+	}
+}
+
+func TestGeoHash(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	addSicily(t, ts)
+
+	output := ts.ProcessCommand("geohash", "Sicily", "Palermo", "Catania", "Missing")
+	if !output.isArray("sqc8b49rny0", "sqdtr74hyu0", nil) {
+		t.Fatal("geohash fail")
+	}
+
+	output = ts.ProcessCommand("geohash", "missing", "Palermo")
+	if !output.isArray(nil) {
+		t.Fatal("geohash missing key fail")
+	}
+}
+
+func TestGeoPos(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	addSicily(t, ts)
+
+	output := ts.ProcessCommand("geopos", "Sicily", "Palermo", "Catania", "NonExisting")
+	a, valid := output.toArray()
+	if !valid || len(a) != 3 {
+		t.Fatal("geopos step 1 fail")
+	}
+
+	if !a[0].isArray(13.361389338970184, 38.1155563954963) {
+		t.Fatal("geopos step 2 fail")
+	}
+
+	if !a[1].isArray(15.087267458438873, 37.50266842333162) {
+		t.Fatal("geopos step 3 fail")
+	}
+
+	if !a[2].isNull() {
+		t.Fatal("geopos step 4 fail")
+	}
+
+	output = ts.ProcessCommand("geopos", "missing", "Palermo")
+	if !output.isArray(nil) {
+		t.Fatal("geopos missing key fail")
+	}
+}
+
+func TestGeoSearch(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	addSicily(t, ts)
+	ts.ProcessCommand("geoadd", "Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")
+
+	output := ts.ProcessCommand("geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "asc")
+	if !output.isArray("Catania", "Palermo") {
+		t.Fatal("geosearch byradius fail")
+	}
+
+	output = ts.ProcessCommand("geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "desc")
+	if !output.isArray("Palermo", "Catania") {
+		t.Fatal("geosearch desc fail")
+	}
+
+	output = ts.ProcessCommand("geosearch", "Sicily", "fromlonlat", "15", "37", "bybox", "400", "400", "km", "asc", "withcoord", "withdist")
+	a, valid := output.toArray()
+	if !valid || len(a) != 4 {
+		t.Fatal("geosearch bybox step 1 fail")
+	}
+
+	expected := []struct {
+		member string
+		dist   string
+	}{
+		{"Catania", "56.4413"},
+		{"Palermo", "190.4424"},
+		{"edge2", "279.7403"},
+		{"edge1", "279.7405"},
+	}
+	for i, e := range expected {
+		item, valid := a[i].toArray()
+		if !valid || len(item) != 3 || !item[0].isString(e.member) || !item[1].isString(e.dist) {
+			t.Fatalf("geosearch bybox step %d fail", i+2)
+		}
+		if coord, valid := item[2].toArray(); !valid || len(coord) != 2 {
+			t.Fatalf("geosearch bybox coord %d fail", i+2)
+		}
+	}
+
+	output = ts.ProcessCommand("geosearch", "Sicily", "frommember", "Palermo", "byradius", "200", "km", "asc", "withhash")
+	if !output.isArray([]any{"Palermo", int64(3479099956230698)}, []any{"edge1", int64(3479273021651468)}, []any{"Catania", int64(3479447370796909)}) {
+		t.Fatal("geosearch frommember fail")
+	}
+
+	output = ts.ProcessCommand("geosearch", "Sicily", "fromlonlat", "15", "37", "bybox", "400", "400", "km", "count", "1")
+	if !output.isArray("Catania") {
+		t.Fatal("geosearch count fail")
+	}
+
+	output = ts.ProcessCommand("geosearch", "Sicily", "fromlonlat", "15", "37", "bybox", "400", "400", "km", "count", "2", "any")
+	if a, valid := output.toArray(); !valid || len(a) != 2 {
+		t.Fatal("geosearch count any fail")
+	}
+
+	output = ts.ProcessCommand("geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "1", "m")
+	if !output.isArray() {
+		t.Fatal("geosearch nothing fail")
+	}
+
+	output = ts.ProcessCommand("geosearch", "missing", "fromlonlat", "15", "37", "byradius", "200", "km")
+	if !output.isArray() {
+		t.Fatal("geosearch missing key fail")
+	}
+
+	output = ts.ProcessCommand("geosearch", "Sicily", "frommember", "Missing", "byradius", "200", "km")
+	if !output.isErrorString("ERR could not decode requested zset member") {
+		t.Fatal("geosearch missing member fail")
+	}
+
+	output = ts.ProcessCommand("geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "-1", "km")
+	if !output.isErrorString("ERR radius cannot be negative") {
+		t.Fatal("geosearch negative radius fail")
+	}
+
+	output = ts.ProcessCommand("geosearch", "Sicily", "fromlonlat", "15", "37", "bybox", "-1", "1", "km")
+	if !output.isErrorString("ERR height or width cannot be negative") {
+		t.Fatal("geosearch negative box fail")
+	}
+
+	output = ts.ProcessCommand("geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "count", "0")
+	if !output.isErrorString("ERR COUNT must be > 0") {
+		t.Fatal("geosearch count zero fail")
+	}
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	addSicily(t, ts)
+
+	output := ts.ProcessCommand("geosearchstore", "dest", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km")
+	if !output.isInt(2) {
+		t.Fatal("geosearchstore step 1 fail")
+	}
+
+	output = ts.ProcessCommand("geohash", "dest", "Palermo", "Catania")
+	if !output.isArray("sqc8b49rny0", "sqdtr74hyu0") {
+		t.Fatal("geosearchstore step 2 fail")
+	}
+
+	output = ts.ProcessCommand("geosearchstore", "dest", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "asc", "count", "1", "storedist")
+	if !output.isInt(1) {
+		t.Fatal("geosearchstore storedist step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "dest", "0", "-1")
+	if !output.isArray("Catania") {
+		t.Fatal("geosearchstore storedist step 2 fail")
+	}
+
+	output = ts.ProcessCommand("zscore", "dest", "Catania")
+	if !output.isFloat(56.4413, 4) {
+		t.Fatal("geosearchstore storedist step 3 fail")
+	}
+
+	// no results deletes the destination
+	output = ts.ProcessCommand("geosearchstore", "dest", "Sicily", "fromlonlat", "15", "37", "byradius", "1", "m")
+	if !output.isInt(0) {
+		t.Fatal("geosearchstore empty step 1 fail")
+	}
+
+	output = ts.ProcessCommand("exists", "dest")
+	if !output.isInt(0) {
+		t.Fatal("geosearchstore empty step 2 fail")
+	}
+}
+
+func TestGeoRadius(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	addSicily(t, ts)
+
+	output := ts.ProcessCommand("georadius", "Sicily", "15", "37", "200", "km", "withdist", "asc")
+	if !output.isArray([]any{"Catania", "56.4413"}, []any{"Palermo", "190.4424"}) {
+		t.Fatal("georadius withdist fail")
+	}
+
+	output = ts.ProcessCommand("georadius_ro", "Sicily", "15", "37", "100", "km")
+	if !output.isArray("Catania") {
+		t.Fatal("georadius_ro fail")
+	}
+
+	output = ts.ProcessCommand("georadius", "Sicily", "15", "37", "200", "km", "store", "dest")
+	if !output.isInt(2) {
+		t.Fatal("georadius store step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zcard", "dest")
+	if !output.isInt(2) {
+		t.Fatal("georadius store step 2 fail")
+	}
+
+	output = ts.ProcessCommand("georadius", "Sicily", "15", "37", "200", "km", "withdist", "store", "dest")
+	if !output.isErrorString("ERR STORE option in GEORADIUS is not compatible with WITHDIST, WITHHASH and WITHCOORD options") {
+		t.Fatal("georadius store withdist fail")
+	}
+
+	ts.ProcessCommand("geoadd", "Sicily", "13.583333", "37.316667", "Agrigento")
+	output = ts.ProcessCommand("georadiusbymember", "Sicily", "Agrigento", "100", "km", "asc")
+	if !output.isArray("Agrigento", "Palermo") {
+		t.Fatal("georadiusbymember fail")
+	}
+
+	output = ts.ProcessCommand("georadiusbymember_ro", "Sicily", "Agrigento", "100", "km", "desc")
+	if !output.isArray("Palermo", "Agrigento") {
+		t.Fatal("georadiusbymember_ro fail")
+	}
+}
@@ -1,5 +1,12 @@
 package redisemu
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 func fnHGet(ctx *cmdContext, args map[string]any) (output respValue, err error) {
 	keyName := args["key"].(string)
 	fieldName := args["field"].(string)
@@ -163,16 +170,7 @@ func fnHMGet(ctx *cmdContext, args map[string]any) (output respValue, err error)
 		return
 	}
 
-	valArray := make([]any, 0, len(vals))
-	for _, val := range vals {
-		if val == nil {
-			valArray = append(valArray, nil)
-		} else {
-			valArray = append(valArray, *val)
-		}
-	}
-
-	output = nativeValueToResp(valArray)
+	output = hashFieldValuesToResp(vals)
 	return
 }
 
@@ -254,3 +252,278 @@ func fnHVals(ctx *cmdContext, args map[string]any) (output respValue, err error)
 	}
 	return
 }
+
+// the commands that end with a FIELDS block, with the number of arguments
+// of each field
+var hashFieldsCmdTable = map[string]int{
+	"hexpire":      1,
+	"hexpireat":    1,
+	"hexpiretime":  1,
+	"hgetdel":      1,
+	"hgetex":       1,
+	"hpersist":     1,
+	"hpexpire":     1,
+	"hpexpireat":   1,
+	"hpexpiretime": 1,
+	"hpttl":        1,
+	"hsetex":       2,
+	"httl":         1,
+}
+
+// the parser rejects a FIELDS block with a count that doesn't match the
+// fields, such as zero, before the handler runs; this examines the raw
+// arguments to provide the redis error instead
+func hashFieldsError(cmdArgs []string, argsPerField int) respErrorString {
+	for i := 1; i+1 < len(cmdArgs); i++ {
+		if !strings.EqualFold(cmdArgs[i], "fields") {
+			continue
+		}
+
+		numFields, err := strconv.ParseInt(cmdArgs[i+1], 10, 64)
+		if err != nil {
+			return ""
+		}
+		if numFields <= 0 {
+			return rstrNumFieldsZero
+		}
+		if int64(len(cmdArgs)-i-2) != numFields*int64(argsPerField) {
+			return rstrNumFieldsMismatch
+		}
+		return ""
+	}
+	return ""
+}
+
+// reads the FIELDS block of the hash field expiration commands
+func parseHashFields(args map[string]any) (fieldNames []string, errText respErrorString) {
+	block := args["fields"].(*orderedMap)
+	numFields := block.mustGet("numfields").(int64)
+	fields := block.mustGet("field").([]any)
+
+	if numFields <= 0 {
+		errText = rstrNumFieldsZero
+		return
+	}
+	if int(numFields) != len(fields) {
+		errText = rstrNumFieldsMismatch
+		return
+	}
+
+	fieldNames = make([]string, 0, len(fields))
+	for _, fieldName := range fields {
+		fieldNames = append(fieldNames, fieldName.(string))
+	}
+	return
+}
+
+func hexpireCommon(ctx *cmdContext, args map[string]any, expiration time.Time) (output respValue, err error) {
+	keyName := args["key"].(string)
+	_, nx := args["condition.nx"]
+	_, xx := args["condition.xx"]
+	_, gt := args["condition.gt"]
+	_, lt := args["condition.lt"]
+
+	fieldNames, errText := parseHashFields(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.hexpire(keyName, fieldNames, expiration, nx, xx, gt, lt)
+	return
+}
+
+func fnHExpire(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	ttl := args["seconds"].(int64)
+	return hexpireCommon(ctx, args, time.Now().Add(time.Duration(ttl)*time.Second))
+}
+
+func fnHExpireAt(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	ttl := args["unix-time-seconds"].(int64)
+	return hexpireCommon(ctx, args, time.Unix(ttl, 0))
+}
+
+func fnHPExpire(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	ttl := args["milliseconds"].(int64)
+	return hexpireCommon(ctx, args, time.Now().Add(time.Duration(ttl)*time.Millisecond))
+}
+
+func fnHPExpireAt(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	ttl := args["unix-time-milliseconds"].(int64)
+	return hexpireCommon(ctx, args, time.UnixMilli(ttl))
+}
+
+func hexpireTimeCommon(ctx *cmdContext, args map[string]any, convert func(expiration time.Time) int64) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	fieldNames, errText := parseHashFields(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.hexpireTime(keyName, fieldNames, convert)
+	return
+}
+
+func fnHExpireTime(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	return hexpireTimeCommon(ctx, args, func(expiration time.Time) int64 {
+		return expiration.Unix()
+	})
+}
+
+func fnHPExpireTime(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	return hexpireTimeCommon(ctx, args, func(expiration time.Time) int64 {
+		return expiration.UnixMilli()
+	})
+}
+
+func fnHTtl(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	return hexpireTimeCommon(ctx, args, func(expiration time.Time) int64 {
+		return expiration.Unix() - time.Now().Unix()
+	})
+}
+
+func fnHPTtl(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	return hexpireTimeCommon(ctx, args, func(expiration time.Time) int64 {
+		return expiration.UnixMilli() - time.Now().UnixMilli()
+	})
+}
+
+func fnHPersist(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	fieldNames, errText := parseHashFields(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.hpersist(keyName, fieldNames)
+	return
+}
+
+func fnHGetDel(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	fieldNames, errText := parseHashFields(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	vals, wrongType := ctx.dsc.hgetdel(keyName, fieldNames)
+	if wrongType {
+		output.data = wrongTypeError
+		return
+	}
+
+	output = hashFieldValuesToResp(vals)
+	return
+}
+
+func fnHGetEx(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	fieldNames, errText := parseHashFields(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	expiration, valid := parseArgsWithExpiration(args, nil)
+	if !valid {
+		output.data = respErrorString(fmt.Sprintf("ERR invalid expire time in '%s' command", ctx.cmdName))
+		return
+	}
+
+	// without an expiration option, the field TTLs are left alone
+	setTtl := false
+	persist := false
+	for name := range args {
+		switch name {
+		case "expiration.seconds", "expiration.milliseconds", "expiration.unix-time-seconds", "expiration.unix-time-milliseconds":
+			setTtl = true
+		case "expiration.persist":
+			persist = true
+		}
+	}
+
+	vals, wrongType := ctx.dsc.hgetex(keyName, fieldNames, expiration, setTtl, persist)
+	if wrongType {
+		output.data = wrongTypeError
+		return
+	}
+
+	output = hashFieldValuesToResp(vals)
+	return
+}
+
+func fnHSetEx(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	var keyName string
+	var block *orderedMap
+	options := bitflags(0)
+
+	expiration, valid := parseArgsWithExpiration(args, func(name string, arg any) {
+		switch name {
+		case "key":
+			keyName = arg.(string)
+		case "fields":
+			block = arg.(*orderedMap)
+		case "condition.fnx":
+			options |= SET_NOT_EXIST
+		case "condition.fxx":
+			options |= SET_EXISTS
+		case "expiration.keepttl":
+			options |= SET_KEEP_TTL
+		}
+	})
+
+	if !valid {
+		output.data = respErrorString(fmt.Sprintf("ERR invalid expire time in '%s' command", ctx.cmdName))
+		return
+	}
+
+	numFields := block.mustGet("numfields").(int64)
+	pairs := block.mustGet("data").([]any)
+	if numFields <= 0 {
+		output.data = rstrNumFieldsZero
+		return
+	}
+	if int(numFields) != len(pairs) {
+		output.data = rstrNumFieldsMismatch
+		return
+	}
+
+	fieldNames := make([]string, 0, len(pairs))
+	values := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		m := pair.(*orderedMap)
+		fieldNames = append(fieldNames, m.mustGet("field").(string))
+		values = append(values, m.mustGet("value").(string))
+	}
+
+	set, wrongType := ctx.dsc.hsetex(keyName, fieldNames, values, options, expiration)
+	if wrongType {
+		output.data = wrongTypeError
+	} else if set {
+		output.data = respInt(1)
+	} else {
+		output.data = respInt(0)
+	}
+	return
+}
+
+// converts field values to an array reply with nulls for missing fields
+func hashFieldValuesToResp(vals []*string) respValue {
+	valArray := make([]any, 0, len(vals))
+	for _, val := range vals {
+		if val == nil {
+			valArray = append(valArray, nil)
+		} else {
+			valArray = append(valArray, *val)
+		}
+	}
+
+	return nativeValueToResp(valArray)
+}
@@ -3,9 +3,11 @@ package redisemu
 import (
 	"fmt"
 	"math"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestHSet(t *testing.T) {
@@ -930,3 +932,489 @@ func TestHVals(t *testing.T) {
 		t.Fatal("hvals get from a list fail")
 	}
 }
+
+func TestHExpire(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("hexpire", "h", "100", "fields", "1", "f1")
+	if !output.isArray(-2) {
+		t.Fatal("hexpire missing key fail")
+	}
+
+	ts.ProcessCommand("hset", "h", "f1", "v1", "f2", "v2", "f3", "v3")
+
+	output = ts.ProcessCommand("hexpire", "h", "100", "fields", "2", "f1", "missing")
+	if !output.isArray(1, -2) {
+		t.Fatal("hexpire step 1 fail")
+	}
+
+	output = ts.ProcessCommand("hexpire", "h", "200", "nx", "fields", "2", "f1", "f2")
+	if !output.isArray(0, 1) {
+		t.Fatal("hexpire nx fail")
+	}
+
+	output = ts.ProcessCommand("hexpire", "h", "300", "xx", "fields", "2", "f1", "f3")
+	if !output.isArray(1, 0) {
+		t.Fatal("hexpire xx fail")
+	}
+
+	// a field without a TTL never expires, so GT fails and LT succeeds
+	output = ts.ProcessCommand("hexpire", "h", "50", "gt", "fields", "2", "f1", "f3")
+	if !output.isArray(0, 0) {
+		t.Fatal("hexpire gt fail")
+	}
+
+	output = ts.ProcessCommand("hexpire", "h", "50", "lt", "fields", "2", "f1", "f3")
+	if !output.isArray(1, 1) {
+		t.Fatal("hexpire lt fail")
+	}
+
+	output = ts.ProcessCommand("httl", "h", "fields", "4", "f1", "f2", "f3", "missing")
+	if !output.isArray(50, 200, 50, -2) {
+		t.Fatal("httl fail")
+	}
+
+	output = ts.ProcessCommand("hpttl", "h", "fields", "1", "f2")
+	ms, _ := output.toArray()
+	if len(ms) != 1 || !ms[0].isAtLeast(199000) {
+		t.Fatal("hpttl fail")
+	}
+
+	// an expiration in the past deletes the field
+	output = ts.ProcessCommand("hexpire", "h", "0", "fields", "1", "f3")
+	if !output.isArray(2) {
+		t.Fatal("hexpire delete step 1 fail")
+	}
+
+	output = ts.ProcessCommand("hgetall", "h")
+	if !output.isMap(map[any]any{"f1": "v1", "f2": "v2"}) {
+		t.Fatal("hexpire delete step 2 fail")
+	}
+
+	output = ts.ProcessCommand("hexpire", "h", "0", "fields", "2", "f1", "f2")
+	if !output.isArray(2, 2) {
+		t.Fatal("hexpire delete step 3 fail")
+	}
+
+	output = ts.ProcessCommand("exists", "h")
+	if !output.isInt(0) {
+		t.Fatal("hexpire delete step 4 fail")
+	}
+
+	ts.ProcessCommand("set", "str", "value")
+	output = ts.ProcessCommand("hexpire", "str", "100", "fields", "1", "f1")
+	if !output.isErrorType() {
+		t.Fatal("hexpire wrong type fail")
+	}
+
+	output = ts.ProcessCommand("hexpire", "h", "100", "fields", "2", "f1")
+	if !output.isErrorString("ERR The `numfields` parameter must match the number of arguments") {
+		t.Fatal("hexpire numfields mismatch fail")
+	}
+
+	output = ts.ProcessCommand("hexpire", "h", "100", "fields", "0", "f1")
+	if !output.isErrorString("ERR Parameter `numFields` should be greater than 0") {
+		t.Fatal("hexpire numfields zero fail")
+	}
+
+	// without fields, the count is checked before the arguments
+	output = ts.ProcessCommand("hexpire", "h", "100", "fields", "0")
+	if !output.isErrorString("ERR Parameter `numFields` should be greater than 0") {
+		t.Fatal("hexpire numfields zero no fields fail")
+	}
+
+	output = ts.ProcessCommand("hpexpire", "h", "100", "FIELDS", "0")
+	if !output.isErrorString("ERR Parameter `numFields` should be greater than 0") {
+		t.Fatal("hpexpire numfields zero no fields fail")
+	}
+
+	output = ts.ProcessCommand("httl", "h", "fields", "0")
+	if !output.isErrorString("ERR Parameter `numFields` should be greater than 0") {
+		t.Fatal("httl numfields zero no fields fail")
+	}
+
+	output = ts.ProcessCommand("hpersist", "h", "fields", "-1")
+	if !output.isErrorString("ERR Parameter `numFields` should be greater than 0") {
+		t.Fatal("hpersist numfields negative no fields fail")
+	}
+
+	output = ts.ProcessCommand("hsetex", "h", "fields", "0")
+	if !output.isErrorString("ERR Parameter `numFields` should be greater than 0") {
+		t.Fatal("hsetex numfields zero no fields fail")
+	}
+
+	output = ts.ProcessCommand("hsetex", "h", "fields", "2", "f1", "v1")
+	if !output.isErrorString("ERR The `numfields` parameter must match the number of arguments") {
+		t.Fatal("hsetex numfields mismatch fail")
+	}
+
+	output = ts.ProcessCommand("httl", "h", "fields")
+	if !output.isErrorString("ERR Incorrect or wrong number of arguments for 'httl'. Try COMMAND HELP.") {
+		t.Fatal("httl no numfields fail")
+	}
+}
+
+func TestHExpireAt(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("hset", "h", "f1", "v1", "f2", "v2")
+
+	at := time.Now().Add(time.Hour).Unix()
+	output := ts.ProcessCommand("hexpireat", "h", fmt.Sprintf("%d", at), "fields", "1", "f1")
+	if !output.isArray(1) {
+		t.Fatal("hexpireat fail")
+	}
+
+	output = ts.ProcessCommand("hexpiretime", "h", "fields", "3", "f1", "f2", "f3")
+	if !output.isArray(at, -1, -2) {
+		t.Fatal("hexpiretime fail")
+	}
+
+	atMs := time.Now().Add(time.Hour).UnixMilli()
+	output = ts.ProcessCommand("hpexpireat", "h", fmt.Sprintf("%d", atMs), "fields", "1", "f2")
+	if !output.isArray(1) {
+		t.Fatal("hpexpireat fail")
+	}
+
+	output = ts.ProcessCommand("hpexpiretime", "h", "fields", "1", "f2")
+	if !output.isArray(atMs) {
+		t.Fatal("hpexpiretime fail")
+	}
+
+	output = ts.ProcessCommand("hexpireat", "h", "1", "fields", "1", "f2")
+	if !output.isArray(2) {
+		t.Fatal("hexpireat past fail")
+	}
+}
+
+func TestHPersist(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("hpersist", "h", "fields", "1", "f1")
+	if !output.isArray(-2) {
+		t.Fatal("hpersist missing key fail")
+	}
+
+	ts.ProcessCommand("hset", "h", "f1", "v1", "f2", "v2")
+	ts.ProcessCommand("hexpire", "h", "100", "fields", "1", "f1")
+
+	output = ts.ProcessCommand("hpersist", "h", "fields", "3", "f1", "f2", "f3")
+	if !output.isArray(1, -1, -2) {
+		t.Fatal("hpersist step 1 fail")
+	}
+
+	output = ts.ProcessCommand("httl", "h", "fields", "1", "f1")
+	if !output.isArray(-1) {
+		t.Fatal("hpersist step 2 fail")
+	}
+
+	// overwriting a field clears its TTL, incrementing keeps it
+	ts.ProcessCommand("hset", "h", "n", "1")
+	ts.ProcessCommand("hexpire", "h", "100", "fields", "2", "f1", "n")
+	ts.ProcessCommand("hset", "h", "f1", "v")
+	ts.ProcessCommand("hincrby", "h", "n", "1")
+
+	output = ts.ProcessCommand("httl", "h", "fields", "2", "f1", "n")
+	if !output.isArray(-1, 100) {
+		t.Fatal("hpersist overwrite fail")
+	}
+}
+
+func TestHExpireLazy(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("hset", "h", "f1", "v1", "f2", "v2", "f3", "v3")
+
+	output := ts.ProcessCommand("hpexpire", "h", "20", "fields", "2", "f1", "f2")
+	if !output.isArray(1, 1) {
+		t.Fatal("hexpire lazy step 1 fail")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	output = ts.ProcessCommand("hget", "h", "f1")
+	if !output.isNull() {
+		t.Fatal("hexpire lazy hget fail")
+	}
+
+	output = ts.ProcessCommand("hlen", "h")
+	if !output.isInt(1) {
+		t.Fatal("hexpire lazy hlen fail")
+	}
+
+	output = ts.ProcessCommand("hgetall", "h")
+	if !output.isMap(map[any]any{"f3": "v3"}) {
+		t.Fatal("hexpire lazy hgetall fail")
+	}
+
+	output = ts.ProcessCommand("hscan", "h", "0")
+	if !output.isArray("0", []any{"f3", "v3"}) {
+		t.Fatal("hexpire lazy hscan fail")
+	}
+
+	// the key goes away with its last field
+	output = ts.ProcessCommand("hpexpire", "h", "20", "fields", "1", "f3")
+	if !output.isArray(1) {
+		t.Fatal("hexpire lazy step 2 fail")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	output = ts.ProcessCommand("exists", "h")
+	if !output.isInt(0) {
+		t.Fatal("hexpire lazy delete fail")
+	}
+}
+
+func TestHExpireCopy(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("hset", "h", "f1", "v1", "f2", "v2")
+	ts.ProcessCommand("hexpire", "h", "100", "fields", "1", "f1")
+
+	output := ts.ProcessCommand("copy", "h", "h2")
+	if !output.isInt(1) {
+		t.Fatal("hexpire copy step 1 fail")
+	}
+
+	ts.ProcessCommand("hset", "h", "f2", "changed")
+	ts.ProcessCommand("hpersist", "h", "fields", "1", "f1")
+
+	output = ts.ProcessCommand("hget", "h2", "f2")
+	if !output.isString("v2") {
+		t.Fatal("hexpire copy step 2 fail")
+	}
+
+	output = ts.ProcessCommand("httl", "h2", "fields", "2", "f1", "f2")
+	if !output.isArray(100, -1) {
+		t.Fatal("hexpire copy step 3 fail")
+	}
+}
+
+func TestHExpirePersist(t *testing.T) {
+	ds := newDataStore()
+	dsc := ds.newDataStoreCommand()
+
+	dsc.setHashTableFields("h", []string{"f1", "f2"}, []string{"v1", "v2"})
+	expiration := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	output := dsc.hexpire("h", []string{"f1"}, expiration, false, false, false, false)
+	if !output.isArray(1) {
+		t.Fatal("hexpire persist prepare fail")
+	}
+
+	fileName := filepath.Join(t.TempDir(), "hash.db0")
+	if err := ds.save(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	ds2 := newDataStore()
+	if err := ds2.load(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	dsc2 := ds2.newDataStoreCommand()
+	output = dsc2.hexpireTime("h", []string{"f1", "f2"}, func(expiration time.Time) int64 {
+		return expiration.UnixMilli()
+	})
+	if !output.isArray(expiration.UnixMilli(), -1) {
+		t.Fatal("hexpire persist fail")
+	}
+}
+
+func TestHGetDel(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("hgetdel", "h", "fields", "1", "f1")
+	if !output.isArray(nil) {
+		t.Fatal("hgetdel missing key fail")
+	}
+
+	ts.ProcessCommand("hset", "h", "f1", "v1", "f2", "v2", "f3", "v3")
+	ts.ProcessCommand("hexpire", "h", "100", "fields", "1", "f1")
+
+	output = ts.ProcessCommand("hgetdel", "h", "fields", "2", "f1", "missing")
+	if !output.isArray("v1", nil) {
+		t.Fatal("hgetdel step 1 fail")
+	}
+
+	output = ts.ProcessCommand("hgetall", "h")
+	if !output.isMap(map[any]any{"f2": "v2", "f3": "v3"}) {
+		t.Fatal("hgetdel step 2 fail")
+	}
+
+	// the key goes away with its last field
+	output = ts.ProcessCommand("hgetdel", "h", "fields", "2", "f2", "f3")
+	if !output.isArray("v2", "v3") {
+		t.Fatal("hgetdel step 3 fail")
+	}
+
+	output = ts.ProcessCommand("exists", "h")
+	if !output.isInt(0) {
+		t.Fatal("hgetdel delete fail")
+	}
+
+	ts.ProcessCommand("set", "str", "value")
+	output = ts.ProcessCommand("hgetdel", "str", "fields", "1", "f1")
+	if !output.isErrorType() {
+		t.Fatal("hgetdel wrong type fail")
+	}
+
+	output = ts.ProcessCommand("hgetdel", "h", "fields", "2", "f1")
+	if !output.isErrorString("ERR The `numfields` parameter must match the number of arguments") {
+		t.Fatal("hgetdel numfields mismatch fail")
+	}
+}
+
+func TestHGetEx(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("hgetex", "h", "ex", "100", "fields", "1", "f1")
+	if !output.isArray(nil) {
+		t.Fatal("hgetex missing key fail")
+	}
+
+	ts.ProcessCommand("hset", "h", "f1", "v1", "f2", "v2", "f3", "v3")
+
+	output = ts.ProcessCommand("hgetex", "h", "ex", "100", "fields", "2", "f1", "missing")
+	if !output.isArray("v1", nil) {
+		t.Fatal("hgetex ex fail")
+	}
+
+	output = ts.ProcessCommand("hgetex", "h", "fields", "2", "f1", "f2")
+	if !output.isArray("v1", "v2") {
+		t.Fatal("hgetex no expiration fail")
+	}
+
+	output = ts.ProcessCommand("httl", "h", "fields", "3", "f1", "f2", "missing")
+	if !output.isArray(100, -1, -2) {
+		t.Fatal("hgetex ttl fail")
+	}
+
+	output = ts.ProcessCommand("hgetex", "h", "persist", "fields", "1", "f1")
+	if !output.isArray("v1") {
+		t.Fatal("hgetex persist step 1 fail")
+	}
+
+	output = ts.ProcessCommand("httl", "h", "fields", "1", "f1")
+	if !output.isArray(-1) {
+		t.Fatal("hgetex persist step 2 fail")
+	}
+
+	atMs := time.Now().Add(time.Hour).UnixMilli()
+	output = ts.ProcessCommand("hgetex", "h", "pxat", fmt.Sprintf("%d", atMs), "fields", "1", "f2")
+	if !output.isArray("v2") {
+		t.Fatal("hgetex pxat step 1 fail")
+	}
+
+	output = ts.ProcessCommand("hpexpiretime", "h", "fields", "1", "f2")
+	if !output.isArray(atMs) {
+		t.Fatal("hgetex pxat step 2 fail")
+	}
+
+	// an expiration in the past deletes the field after reading it
+	output = ts.ProcessCommand("hgetex", "h", "exat", "1", "fields", "1", "f3")
+	if !output.isArray("v3") {
+		t.Fatal("hgetex past step 1 fail")
+	}
+
+	output = ts.ProcessCommand("hexists", "h", "f3")
+	if !output.isInt(0) {
+		t.Fatal("hgetex past step 2 fail")
+	}
+
+	output = ts.ProcessCommand("hgetex", "h", "ex", "0", "fields", "1", "f1")
+	if !output.isErrorString("ERR invalid expire time in 'hgetex' command") {
+		t.Fatal("hgetex invalid expiration fail")
+	}
+
+	ts.ProcessCommand("set", "str", "value")
+	output = ts.ProcessCommand("hgetex", "str", "fields", "1", "f1")
+	if !output.isErrorType() {
+		t.Fatal("hgetex wrong type fail")
+	}
+}
+
+func TestHSetEx(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("hsetex", "h", "fxx", "fields", "1", "f1", "v1")
+	if !output.isInt(0) {
+		t.Fatal("hsetex fxx missing key fail")
+	}
+
+	output = ts.ProcessCommand("exists", "h")
+	if !output.isInt(0) {
+		t.Fatal("hsetex fxx missing key exists fail")
+	}
+
+	output = ts.ProcessCommand("hsetex", "h", "fnx", "ex", "100", "fields", "2", "f1", "v1", "f2", "v2")
+	if !output.isInt(1) {
+		t.Fatal("hsetex fnx fail")
+	}
+
+	output = ts.ProcessCommand("httl", "h", "fields", "2", "f1", "f2")
+	if !output.isArray(100, 100) {
+		t.Fatal("hsetex ex fail")
+	}
+
+	// the conditions are all or nothing
+	output = ts.ProcessCommand("hsetex", "h", "fnx", "fields", "2", "f2", "x", "f3", "v3")
+	if !output.isInt(0) {
+		t.Fatal("hsetex fnx existing fail")
+	}
+
+	output = ts.ProcessCommand("hsetex", "h", "fxx", "fields", "2", "f2", "x", "f3", "v3")
+	if !output.isInt(0) {
+		t.Fatal("hsetex fxx missing field fail")
+	}
+
+	output = ts.ProcessCommand("hgetall", "h")
+	if !output.isMap(map[any]any{"f1": "v1", "f2": "v2"}) {
+		t.Fatal("hsetex unchanged fail")
+	}
+
+	output = ts.ProcessCommand("hsetex", "h", "fxx", "keepttl", "fields", "1", "f1", "new1")
+	if !output.isInt(1) {
+		t.Fatal("hsetex keepttl step 1 fail")
+	}
+
+	// without keepttl, setting a field clears its TTL
+	output = ts.ProcessCommand("hsetex", "h", "fields", "1", "f2", "new2")
+	if !output.isInt(1) {
+		t.Fatal("hsetex clear ttl step 1 fail")
+	}
+
+	output = ts.ProcessCommand("httl", "h", "fields", "2", "f1", "f2")
+	if !output.isArray(100, -1) {
+		t.Fatal("hsetex ttl fail")
+	}
+
+	output = ts.ProcessCommand("hmget", "h", "f1", "f2")
+	if !output.isArray("new1", "new2") {
+		t.Fatal("hsetex values fail")
+	}
+
+	output = ts.ProcessCommand("hsetex", "h", "px", "0", "fields", "1", "f1", "v1")
+	if !output.isErrorString("ERR invalid expire time in 'hsetex' command") {
+		t.Fatal("hsetex invalid expiration fail")
+	}
+
+	output = ts.ProcessCommand("hsetex", "h", "fields", "2", "f1", "v1")
+	if !output.isErrorString("ERR The `numfields` parameter must match the number of arguments") {
+		t.Fatal("hsetex numfields mismatch fail")
+	}
+
+	ts.ProcessCommand("set", "str", "value")
+	output = ts.ProcessCommand("hsetex", "str", "fields", "1", "f1", "v1")
+	if !output.isErrorType() {
+		t.Fatal("hsetex wrong type fail")
+	}
+}
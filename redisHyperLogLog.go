@@ -0,0 +1,39 @@
+package redisemu
+
+func fnPfAdd(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	elementsAny, _ := args["element"].([]any)
+
+	elements := make([]string, 0, len(elementsAny))
+	for _, element := range elementsAny {
+		elements = append(elements, element.(string))
+	}
+
+	output = ctx.dsc.pfadd(keyName, elements)
+	return
+}
+
+func fnPfCount(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyNamesAny := args["key"].([]any)
+
+	keyNames := make([]string, 0, len(keyNamesAny))
+	for _, keyName := range keyNamesAny {
+		keyNames = append(keyNames, keyName.(string))
+	}
+
+	output = ctx.dsc.pfcount(keyNames)
+	return
+}
+
+func fnPfMerge(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	destKeyName := args["destkey"].(string)
+	sourcesAny, _ := args["sourcekey"].([]any)
+
+	srcKeyNames := make([]string, 0, len(sourcesAny))
+	for _, keyName := range sourcesAny {
+		srcKeyNames = append(srcKeyNames, keyName.(string))
+	}
+
+	output = ctx.dsc.pfmerge(destKeyName, srcKeyNames)
+	return
+}
@@ -0,0 +1,461 @@
+package redisemu
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func pfaddRange(t *testing.T, ts RedisTestClient, keyName string, start, end int) {
+	args := []any{keyName}
+	for i := start; i < end; i++ {
+		args = append(args, fmt.Sprintf("%d", i))
+	}
+	output := ts.ProcessCommand("pfadd", args...)
+	if _, valid := output.toInt(); !valid {
+		t.Fatal("pfadd range fail")
+	}
+}
+
+func TestPfAdd(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	// no elements still creates the key
+	output := ts.ProcessCommand("pfadd", "hll")
+	if !output.isInt(1) {
+		t.Fatal("pfadd create fail")
+	}
+
+	output = ts.ProcessCommand("pfadd", "hll")
+	if !output.isInt(0) {
+		t.Fatal("pfadd no change fail")
+	}
+
+	output = ts.ProcessCommand("get", "hll")
+	if !output.isString("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xff") {
+		t.Fatal("pfadd empty encoding fail")
+	}
+
+	output = ts.ProcessCommand("pfcount", "hll")
+	if !output.isInt(0) {
+		t.Fatal("pfcount empty fail")
+	}
+
+	output = ts.ProcessCommand("type", "hll")
+	if !output.isString("string") {
+		t.Fatal("pfadd type fail")
+	}
+
+	output = ts.ProcessCommand("pfadd", "hll", "a", "b", "c")
+	if !output.isInt(1) {
+		t.Fatal("pfadd step 1 fail")
+	}
+
+	output = ts.ProcessCommand("pfadd", "hll", "b", "c", "a")
+	if !output.isInt(0) {
+		t.Fatal("pfadd step 2 fail")
+	}
+
+	output = ts.ProcessCommand("pfadd", "hll", "")
+	if !output.isInt(1) {
+		t.Fatal("pfadd empty string fail")
+	}
+
+	output = ts.ProcessCommand("pfcount", "hll")
+	if !output.isInt(4) {
+		t.Fatal("pfcount fail")
+	}
+
+	// an existing expiration is kept
+	output = ts.ProcessCommand("expire", "hll", "100")
+	if !output.isInt(1) {
+		t.Fatal("pfadd ttl step 1 fail")
+	}
+
+	output = ts.ProcessCommand("pfadd", "hll", "d")
+	if !output.isInt(1) {
+		t.Fatal("pfadd ttl step 2 fail")
+	}
+
+	output = ts.ProcessCommand("ttl", "hll")
+	if !output.isInt(100) {
+		t.Fatal("pfadd ttl step 3 fail")
+	}
+}
+
+func TestPfCount(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("pfcount", "missing")
+	if !output.isInt(0) {
+		t.Fatal("pfcount missing fail")
+	}
+
+	pfaddRange(t, ts, "hll", 1, 6)
+
+	output = ts.ProcessCommand("pfcount", "hll")
+	if !output.isInt(5) {
+		t.Fatal("pfcount step 1 fail")
+	}
+
+	pfaddRange(t, ts, "hll", 6, 11)
+
+	output = ts.ProcessCommand("pfcount", "hll")
+	if !output.isInt(10) {
+		t.Fatal("pfcount step 2 fail")
+	}
+
+	// the cardinality is cached in the header, until the next change
+	output = ts.ProcessCommand("getrange", "hll", "15", "15")
+	if !output.isString("\x00") {
+		t.Fatal("pfcount cache step 1 fail")
+	}
+
+	output = ts.ProcessCommand("pfadd", "hll", "1", "2")
+	if !output.isInt(0) {
+		t.Fatal("pfcount cache step 2 fail")
+	}
+
+	output = ts.ProcessCommand("getrange", "hll", "8", "15")
+	if !output.isString("\x0a\x00\x00\x00\x00\x00\x00\x00") {
+		t.Fatal("pfcount cache step 3 fail")
+	}
+
+	output = ts.ProcessCommand("pfadd", "hll", "11")
+	if !output.isInt(1) {
+		t.Fatal("pfcount cache step 4 fail")
+	}
+
+	output = ts.ProcessCommand("getrange", "hll", "15", "15")
+	if !output.isString("\x80") {
+		t.Fatal("pfcount cache step 5 fail")
+	}
+
+	// multiple keys count the union, without caching
+	pfaddRange(t, ts, "hll2", 8, 16)
+
+	output = ts.ProcessCommand("pfcount", "hll", "hll2", "missing")
+	if !output.isInt(15) {
+		t.Fatal("pfcount union step 1 fail")
+	}
+
+	output = ts.ProcessCommand("getrange", "hll2", "15", "15")
+	if !output.isString("\x80") {
+		t.Fatal("pfcount union step 2 fail")
+	}
+}
+
+func TestPfMerge(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("pfadd", "hla", "a", "b", "c")
+	if !output.isInt(1) {
+		t.Fatal("pfmerge prepare step 1 fail")
+	}
+	output = ts.ProcessCommand("pfadd", "hlb", "b", "c", "d")
+	if !output.isInt(1) {
+		t.Fatal("pfmerge prepare step 2 fail")
+	}
+	output = ts.ProcessCommand("pfadd", "hlc", "c", "e", "f")
+	if !output.isInt(1) {
+		t.Fatal("pfmerge prepare step 3 fail")
+	}
+
+	output = ts.ProcessCommand("pfmerge", "hlu", "hla", "hlb", "hlc", "missing")
+	if !output.isString("OK") {
+		t.Fatal("pfmerge fail")
+	}
+
+	output = ts.ProcessCommand("pfcount", "hlu")
+	if !output.isInt(6) {
+		t.Fatal("pfmerge count fail")
+	}
+
+	// the destination is included in the union
+	output = ts.ProcessCommand("pfadd", "hlu", "g")
+	if !output.isInt(1) {
+		t.Fatal("pfmerge destination step 1 fail")
+	}
+
+	output = ts.ProcessCommand("pfmerge", "hlu", "hla")
+	if !output.isString("OK") {
+		t.Fatal("pfmerge destination step 2 fail")
+	}
+
+	output = ts.ProcessCommand("pfcount", "hlu")
+	if !output.isInt(7) {
+		t.Fatal("pfmerge destination step 3 fail")
+	}
+
+	// sources can be omitted
+	output = ts.ProcessCommand("pfmerge", "hlnew")
+	if !output.isString("OK") {
+		t.Fatal("pfmerge no sources step 1 fail")
+	}
+
+	output = ts.ProcessCommand("pfcount", "hlnew")
+	if !output.isInt(0) {
+		t.Fatal("pfmerge no sources step 2 fail")
+	}
+
+	// a dense source makes the destination dense
+	pfaddRange(t, ts, "dense", 0, 5000)
+
+	output = ts.ProcessCommand("getrange", "dense", "4", "4")
+	if !output.isString("\x00") {
+		t.Fatal("pfmerge dense prepare fail")
+	}
+
+	output = ts.ProcessCommand("pfmerge", "hlu", "dense")
+	if !output.isString("OK") {
+		t.Fatal("pfmerge dense step 1 fail")
+	}
+
+	output = ts.ProcessCommand("getrange", "hlu", "4", "4")
+	if !output.isString("\x00") {
+		t.Fatal("pfmerge dense step 2 fail")
+	}
+
+	output = ts.ProcessCommand("pfcount", "hlu")
+	card, _ := output.toInt()
+	if math.Abs(float64(card)-5007) > 5007*0.05 {
+		t.Fatal("pfmerge dense step 3 fail")
+	}
+}
+
+func TestPfAccuracy(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	// the encoding changes from sparse to dense as registers are filled
+	rng := rand.New(rand.NewSource(1))
+	n := 0
+	for n < 20000 {
+		args := []any{"hll"}
+		for j := 0; j < 100; j++ {
+			args = append(args, fmt.Sprintf("%f", rng.Float64()))
+		}
+		n += 100
+
+		output := ts.ProcessCommand("pfadd", args...)
+		if !output.isInt(1) {
+			t.Fatal("pfadd accuracy step 1 fail")
+		}
+
+		output = ts.ProcessCommand("pfcount", "hll")
+		card, _ := output.toInt()
+		if math.Abs(float64(card-int64(n))) > float64(card)*0.05 {
+			t.Fatal("pfadd accuracy step 2 fail")
+		}
+
+		output = ts.ProcessCommand("getrange", "hll", "4", "4")
+		if n < 1000 && !output.isString("\x01") {
+			t.Fatal("pfadd accuracy sparse fail")
+		} else if n > 10000 && !output.isString("\x00") {
+			t.Fatal("pfadd accuracy dense fail")
+		}
+	}
+
+	output := ts.ProcessCommand("strlen", "hll")
+	if !output.isInt(hllDenseSize) {
+		t.Fatal("pfadd accuracy dense size fail")
+	}
+}
+
+func TestPfSparseConsistency(t *testing.T) {
+	// the sparse and dense encodings must always hold the same registers
+	rng := rand.New(rand.NewSource(2))
+	for round := 0; round < 10; round++ {
+		sparse := newHyperLogLog()
+		dense := newHyperLogLog()
+		if !dense.toDense() {
+			t.Fatal("toDense fail")
+		}
+
+		elements := rng.Intn(3000)
+		for i := 0; i < elements; i++ {
+			index := rng.Intn(hllRegisters)
+			count := uint8(rng.Intn(hllSparseValMaxValue) + 1)
+
+			updated1, valid1 := sparse.set(index, count)
+			updated2, valid2 := dense.set(index, count)
+			if !valid1 || !valid2 || updated1 != updated2 {
+				t.Fatal("set fail")
+			}
+		}
+
+		max1 := make([]uint8, hllRegisters)
+		max2 := make([]uint8, hllRegisters)
+		if !sparse.mergeInto(max1) || !dense.mergeInto(max2) {
+			t.Fatal("mergeInto fail")
+		}
+		for index := range max1 {
+			if max1[index] != max2[index] {
+				t.Fatal("register mismatch")
+			}
+		}
+
+		card1, _ := sparse.count()
+		card2, _ := dense.count()
+		if card1 != card2 || card1 != hllCountRegisters(max1) {
+			t.Fatal("count mismatch")
+		}
+	}
+}
+
+func TestPfCorrupt(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	// wrong kind of key
+	output := ts.ProcessCommand("rpush", "list", "a")
+	if !output.isInt(1) {
+		t.Fatal("pf prepare list fail")
+	}
+
+	output = ts.ProcessCommand("pfadd", "list", "a")
+	if !output.isErrorString("WRONGTYPE Operation against a key holding the wrong kind of value") {
+		t.Fatal("pfadd list fail")
+	}
+
+	// strings that aren't a HyperLogLog
+	output = ts.ProcessCommand("set", "foo", "bar")
+	if !output.isString("OK") {
+		t.Fatal("pf prepare string fail")
+	}
+
+	notHll := "WRONGTYPE Key is not a valid HyperLogLog string value."
+
+	output = ts.ProcessCommand("pfadd", "foo", "1")
+	if !output.isErrorString(notHll) {
+		t.Fatal("pfadd string fail")
+	}
+
+	output = ts.ProcessCommand("pfcount", "foo")
+	if !output.isErrorString(notHll) {
+		t.Fatal("pfcount string fail")
+	}
+
+	output = ts.ProcessCommand("pfmerge", "bar", "foo")
+	if !output.isErrorString(notHll) {
+		t.Fatal("pfmerge string source fail")
+	}
+
+	output = ts.ProcessCommand("pfmerge", "foo", "bar")
+	if !output.isErrorString(notHll) {
+		t.Fatal("pfmerge string destination fail")
+	}
+
+	// broken magic
+	output = ts.ProcessCommand("pfadd", "hll", "a", "b", "c")
+	if !output.isInt(1) {
+		t.Fatal("pf prepare magic fail")
+	}
+
+	output = ts.ProcessCommand("setrange", "hll", "0", "0123")
+	if _, valid := output.toInt(); !valid {
+		t.Fatal("pf corrupt magic step 1 fail")
+	}
+
+	output = ts.ProcessCommand("pfcount", "hll")
+	if !output.isErrorString(notHll) {
+		t.Fatal("pf corrupt magic step 2 fail")
+	}
+
+	// invalid encoding
+	output = ts.ProcessCommand("del", "hll")
+	if !output.isInt(1) {
+		t.Fatal("pf prepare encoding step 1 fail")
+	}
+
+	output = ts.ProcessCommand("pfadd", "hll", "a", "b", "c")
+	if !output.isInt(1) {
+		t.Fatal("pf prepare encoding step 2 fail")
+	}
+
+	output = ts.ProcessCommand("setrange", "hll", "4", "x")
+	if _, valid := output.toInt(); !valid {
+		t.Fatal("pf corrupt encoding step 1 fail")
+	}
+
+	output = ts.ProcessCommand("pfcount", "hll")
+	if !output.isErrorString(notHll) {
+		t.Fatal("pf corrupt encoding step 2 fail")
+	}
+
+	// dense with the wrong length
+	output = ts.ProcessCommand("setrange", "hll", "4", "\x00")
+	if _, valid := output.toInt(); !valid {
+		t.Fatal("pf corrupt dense step 1 fail")
+	}
+
+	output = ts.ProcessCommand("pfcount", "hll")
+	if !output.isErrorString(notHll) {
+		t.Fatal("pf corrupt dense step 2 fail")
+	}
+
+	// sparse with extra data
+	output = ts.ProcessCommand("del", "hll")
+	if !output.isInt(1) {
+		t.Fatal("pf prepare sparse step 1 fail")
+	}
+
+	output = ts.ProcessCommand("pfadd", "hll", "a", "b", "c")
+	if !output.isInt(1) {
+		t.Fatal("pf prepare sparse step 2 fail")
+	}
+
+	output = ts.ProcessCommand("append", "hll", "hello")
+	if _, valid := output.toInt(); !valid {
+		t.Fatal("pf corrupt sparse step 1 fail")
+	}
+
+	output = ts.ProcessCommand("pfcount", "hll")
+	if !output.isErrorString("INVALIDOBJ Corrupted HLL object detected") {
+		t.Fatal("pf corrupt sparse step 2 fail")
+	}
+}
+
+func TestPfDumpRestore(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	pfaddRange(t, ts, "hll", 0, 100)
+
+	output := ts.ProcessCommand("dump", "hll")
+	val, valid := output.toString()
+	if !valid {
+		t.Fatal("pf dump fail")
+	}
+
+	output = ts.ProcessCommand("restore", "hll2", "0", val)
+	if !output.isString("OK") {
+		t.Fatal("pf restore fail")
+	}
+
+	output = ts.ProcessCommand("pfcount", "hll2")
+	expected, _ := output.toInt()
+
+	output = ts.ProcessCommand("pfcount", "hll")
+	if !output.isInt(int(expected)) || math.Abs(float64(expected)-100) > 5 {
+		t.Fatal("pf restore count fail")
+	}
+
+	// the raw value can be copied to another key
+	output = ts.ProcessCommand("get", "hll")
+	raw, _ := output.toString()
+
+	output = ts.ProcessCommand("set", "hll3", raw)
+	if !output.isString("OK") {
+		t.Fatal("pf copy step 1 fail")
+	}
+
+	output = ts.ProcessCommand("pfadd", "hll3", "0", "1", "2")
+	if !output.isInt(0) {
+		t.Fatal("pf copy step 2 fail")
+	}
+}
@@ -0,0 +1,226 @@
+package redisemu
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var latencyHelpText = []string{
+	"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return a human readable latency analysis report.",
+	"GRAPH <event>",
+	"    Return an ASCII latency graph for the <event> class.",
+	"HISTORY <event>",
+	"    Return time-latency samples for the <event> class.",
+	"LATEST",
+	"    Return the latest latency samples for all events.",
+	"RESET [<event> ...]",
+	"    Reset latency data of one or more <event> classes.",
+	"    (default: reset all data for all event classes)",
+	"HISTOGRAM [COMMAND ...]",
+	"    Return a cumulative distribution of latencies in the format of a histogram for the specified command names.",
+	"    If no commands are specified then all histograms are replied.",
+	"HELP",
+	"    Print this help.",
+}
+
+func fnLatencyDoctor(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	dss := ctx.cs.dss
+	threshold := dss.config.intValue("latency-monitor-threshold")
+	events := dss.latencyEventNames()
+
+	var sb strings.Builder
+	if len(events) == 0 && threshold == 0 {
+		sb.WriteString("I'm sorry, Dave, I can't do that. Latency monitoring is disabled in this Redis instance. " +
+			"You may use \"CONFIG SET latency-monitor-threshold <milliseconds>.\" in order to enable it. " +
+			"If we weren't in a deep space mission I'd suggest to take a look at https://redis.io/topics/latency-monitor.\n")
+		output.data = respVerbatimString{format: "txt", text: sb.String()}
+		return
+	}
+
+	var adviseSlowLogEnabled, adviseSlowLogTuning, adviseSlowLogInspect, adviseLargeObjects, adviseScheduler bool
+	advices := 0
+	now := time.Now().Unix()
+	for i, event := range events {
+		ts, _ := dss.latencyEvent(event)
+		ls := ts.analyze(now)
+
+		if i == 0 {
+			sb.WriteString("Dave, I have observed latency spikes in this Redis instance. You don't mind talking about it, do you Dave?\n\n")
+		}
+		sb.WriteString(fmt.Sprintf("%d. %s: %d latency spikes (average %dms, mean deviation %dms, period %.2f sec). Worst all time event %dms.",
+			i+1, event, ls.samples, ls.avg, ls.mad, float64(ls.period)/float64(ls.samples), ts.max))
+
+		switch event {
+		case "command":
+			slowerThan := dss.config.intValue("slowlog-log-slower-than")
+			if slowerThan < 0 || dss.config.intValue("slowlog-max-len") == 0 {
+				adviseSlowLogEnabled = true
+				advices++
+			} else if slowerThan/1000 > threshold {
+				adviseSlowLogTuning = true
+				advices++
+			}
+			adviseSlowLogInspect = true
+			adviseLargeObjects = true
+			advices += 2
+		case "fast-command":
+			adviseScheduler = true
+			advices++
+		}
+		sb.WriteByte('\n')
+	}
+
+	switch {
+	case len(events) == 0:
+		sb.WriteString("Dave, no latency spike was observed during the lifetime of this Redis instance, not in the slightest bit. " +
+			"I honestly think you ought to sleep tonight.\n")
+	case advices == 0:
+		sb.WriteString("\nWhile there are latency events logged, I'm not able to suggest any easy fix. " +
+			"Please use the Redis community to get some help, providing this report in your help request.\n")
+	default:
+		sb.WriteString("\nI have a few advices for you:\n\n")
+		if adviseSlowLogEnabled {
+			sb.WriteString(fmt.Sprintf("- There are latency issues with potentially slow commands you are using. "+
+				"Try to enable the Slow Log Redis feature using the command 'CONFIG SET slowlog-log-slower-than %d'. "+
+				"If the Slow log is disabled Redis is not able to log slow commands execution for you.\n", threshold*1000))
+		}
+		if adviseSlowLogTuning {
+			sb.WriteString(fmt.Sprintf("- Your current Slow Log configuration only logs events that are slower than your configured latency monitor threshold. "+
+				"Please use 'CONFIG SET slowlog-log-slower-than %d'.\n", threshold*1000))
+		}
+		if adviseSlowLogInspect {
+			sb.WriteString("- Check your Slow Log to understand what are the commands you are running which are too slow to execute. " +
+				"Please check https://redis.io/commands/slowlog for more information.\n")
+		}
+		if adviseScheduler {
+			sb.WriteString("- The system is slow to execute Redis code paths not containing system calls. " +
+				"This usually means the system does not provide Redis CPU time to run for long periods. You should try to:\n" +
+				"  1) Lower the system load.\n" +
+				"  2) Use a computer / VM just for Redis if you are running other software in the same system.\n" +
+				"  3) Check if you have a \"noisy neighbour\" problem.\n" +
+				"  4) Check with 'redis-cli --intrinsic-latency 100' what is the intrinsic latency in your system.\n" +
+				"  5) Check if the problem is allocator-related by recompiling Redis with MALLOC=libc, if you are using Jemalloc. " +
+				"However this may create fragmentation problems.\n")
+		}
+		if adviseLargeObjects {
+			sb.WriteString("- Deleting, expiring or evicting (because of maxmemory policy) large objects is a blocking operation. " +
+				"If you have very large objects that are often deleted, expired, or evicted, try to fragment those objects into multiple smaller objects.\n")
+		}
+	}
+
+	output.data = respVerbatimString{format: "txt", text: sb.String()}
+	return
+}
+
+func fnLatencyGraph(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	event := args["event"].(string)
+
+	ts, exists := ctx.cs.dss.latencyEvent(event)
+	if !exists {
+		output.data = respErrorString(fmt.Sprintf("ERR No samples available for event '%s'", event))
+		return
+	}
+
+	output.data = respVerbatimString{format: "txt", text: latencyGraph(event, &ts, time.Now().Unix())}
+	return
+}
+
+func fnLatencyHelp(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	a := make(respArray, 0, len(latencyHelpText))
+	for _, line := range latencyHelpText {
+		a = append(a, respValue{data: respSimpleString(line)})
+	}
+	output.data = a
+	return
+}
+
+func fnLatencyHistogram(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	dss := ctx.cs.dss
+
+	var cmdTokens []string
+	if names, specified := args["command"]; specified {
+		// a command with subcommands reports each of its subcommands
+		for _, name := range argStrings(names) {
+			name = strings.ToLower(name)
+			cmd, exists := ctx.cd.active[name]
+			if !exists {
+				continue
+			}
+			if len(cmd.Subcommands) == 0 {
+				cmdTokens = append(cmdTokens, name)
+				continue
+			}
+			for _, subCmdToken := range dss.latencyHistogramCommands() {
+				if strings.HasPrefix(subCmdToken, name+"|") {
+					cmdTokens = append(cmdTokens, subCmdToken)
+				}
+			}
+		}
+	} else {
+		cmdTokens = dss.latencyHistogramCommands()
+	}
+
+	m := newRespMapSized(len(cmdTokens))
+	for _, cmdToken := range cmdTokens {
+		h, exists := dss.latencyHistogram(cmdToken)
+		if !exists || h.calls == 0 {
+			continue
+		}
+
+		// the buckets are cumulative, omitting the ones that add no calls
+		buckets := newRespMap()
+		var cumulative int64
+		for slot, count := range h.buckets {
+			if count == 0 {
+				continue
+			}
+			cumulative += count
+			buckets.set(respValue{data: respInt(latencyBucketMicros(slot))}, respValue{data: respInt(cumulative)})
+		}
+
+		cdf := newRespMapSized(2)
+		cdf.set(respValue{data: respBulkString("calls")}, respValue{data: respInt(h.calls)})
+		cdf.set(respValue{data: respBulkString("histogram_usec")}, respValue{data: buckets})
+		m.set(respValue{data: respBulkString(cmdToken)}, respValue{data: cdf})
+	}
+
+	output.data = m
+	return
+}
+
+func fnLatencyHistory(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	a := respArray{}
+	if ts, exists := ctx.cs.dss.latencyEvent(args["event"].(string)); exists {
+		for _, sample := range ts.history() {
+			a = append(a, respValue{data: respArray{{data: respInt(sample.time)}, {data: respInt(sample.latency)}}})
+		}
+	}
+	output.data = a
+	return
+}
+
+func fnLatencyLatest(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	dss := ctx.cs.dss
+
+	a := respArray{}
+	for _, event := range dss.latencyEventNames() {
+		ts, _ := dss.latencyEvent(event)
+		latest := ts.latest()
+		a = append(a, respValue{data: respArray{
+			{data: respBulkString(event)},
+			{data: respInt(latest.time)},
+			{data: respInt(latest.latency)},
+			{data: respInt(ts.max)},
+		}})
+	}
+	output.data = a
+	return
+}
+
+func fnLatencyReset(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	output.data = respInt(ctx.cs.dss.resetLatencyEvents(argStrings(args["event"])))
+	return
+}
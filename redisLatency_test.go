@@ -0,0 +1,185 @@
+package redisemu
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogramBuckets(t *testing.T) {
+	var h latencyHistogram
+	for _, duration := range []time.Duration{500, 1024, 1025, 3 * time.Microsecond, 100 * time.Microsecond, math.MaxInt64} {
+		h.record(duration)
+	}
+
+	expected := map[int]int64{0: 2, 1: 1, 2: 1, 7: 1, latencyHistogramSlots - 1: 1}
+	for slot, count := range h.buckets {
+		if count != expected[slot] {
+			t.Fatalf("bucket %d count %d", slot, count)
+		}
+	}
+	if h.calls != 6 || latencyBucketMicros(7) != 131 {
+		t.Fatal("histogram calls fail")
+	}
+	if h.percentile(50) != 2.048 || h.percentile(0) != 1.024 || h.percentile(100) != float64(int64(1024)<<(latencyHistogramSlots-1))/1000 {
+		t.Fatal("histogram percentile fail")
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("config", "resetstat")
+	ts.ProcessCommand("set", "key1", "cat")
+	ts.ProcessCommand("set", "key2", "dog")
+	ts.ProcessCommand("config", "get", "timeout")
+
+	// a command with subcommands reports each of them
+	output := ts.ProcessCommand("latency", "histogram", "set", "config", "bogus")
+	m, valid := output.toMap()
+	if !valid || len(m) != 3 {
+		t.Fatal("latency histogram fail")
+	}
+
+	setCdf := m[respValue{data: respBulkString("set")}]
+	set, _ := setCdf.toMap()
+	calls := set[respValue{data: respBulkString("calls")}]
+	if len(set) != 2 || !calls.isInt(2) {
+		t.Fatal("latency histogram set fail")
+	}
+
+	// each bucket is the cumulative count of the calls up to its latency
+	histogram := set[respValue{data: respBulkString("histogram_usec")}]
+	buckets, _ := histogram.toMap()
+	bounds := map[int64]bool{}
+	for slot := 0; slot < latencyHistogramSlots; slot++ {
+		bounds[latencyBucketMicros(slot)] = true
+	}
+	var last int64
+	for k, v := range buckets {
+		micros, _ := k.toInt()
+		count, _ := v.toInt()
+		if !bounds[micros] || count < 1 || count > 2 {
+			t.Fatal("latency histogram bucket fail")
+		}
+		last = max(last, count)
+	}
+	if last != 2 {
+		t.Fatal("latency histogram cumulative fail")
+	}
+
+	if _, exists := m[respValue{data: respBulkString("config|get")}]; !exists {
+		t.Fatal("latency histogram subcommand fail")
+	}
+
+	// the histograms are cleared by CONFIG RESETSTAT
+	ts.ProcessCommand("config", "resetstat")
+	output = ts.ProcessCommand("latency", "histogram", "set")
+	if m, _ := output.toMap(); len(m) != 0 {
+		t.Fatal("latency histogram reset fail")
+	}
+
+	output = ts.ProcessCommand("info", "latencystats")
+	if !strings.Contains(output.String(), "latency_percentiles_usec_config|resetstat:p50=") {
+		t.Fatal("info latencystats fail")
+	}
+
+	ts.ProcessCommand("config", "set", "latency-tracking-info-percentiles", "90")
+	ts.ProcessCommand("config", "set", "latency-tracking", "no")
+	ts.ProcessCommand("set", "key1", "cat")
+	output = ts.ProcessCommand("info", "latencystats")
+	if !strings.Contains(output.String(), "latency_percentiles_usec_config|set:p90=") || strings.Contains(output.String(), "usec_set:") {
+		t.Fatal("info latencystats config fail")
+	}
+
+	output = ts.ProcessCommand("config", "set", "latency-tracking-info-percentiles", "101")
+	if _, isError := output.data.(respErrorString); !isError {
+		t.Fatal("latency percentiles validation fail")
+	}
+}
+
+func TestLatencyEvents(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("latency", "doctor")
+	if report, _ := output.toString(); !strings.HasPrefix(report, "I'm sorry, Dave, I can't do that.") {
+		t.Fatal("latency doctor disabled fail")
+	}
+
+	ts.ProcessCommand("latency", "reset")
+	ts.ProcessCommand("config", "set", "latency-monitor-threshold", "20")
+	ts.ProcessCommand("debug", "sleep", "0.03")
+	ts.ProcessCommand("get", "key1")
+
+	output = ts.ProcessCommand("latency", "latest")
+	events, _ := output.toArray()
+	if len(events) != 1 {
+		t.Fatal("latency latest fail")
+	}
+	event, _ := events[0].toArray()
+	if len(event) != 4 || !event[0].isString("command") || !event[1].isAtLeast(int(time.Now().Unix())-1) ||
+		!event[2].isAtLeast(30) || !event[3].isAtLeast(30) {
+		t.Fatal("latency latest event fail")
+	}
+
+	output = ts.ProcessCommand("latency", "history", "command")
+	samples, _ := output.toArray()
+	sample, _ := samples[0].toArray()
+	if len(samples) != 1 || len(sample) != 2 || !sample[1].isAtLeast(30) {
+		t.Fatal("latency history fail")
+	}
+	output = ts.ProcessCommand("latency", "history", "fast-command")
+	if !output.isArray() {
+		t.Fatal("latency history no event fail")
+	}
+
+	output = ts.ProcessCommand("latency", "graph", "command")
+	graph, _ := output.toString()
+	if !strings.HasPrefix(graph, "command - high ") || !strings.Contains(graph, "\n"+strings.Repeat("-", 80)+"\n \n \n \n_\n \n0\ns\n") {
+		t.Fatalf("latency graph fail: %q", graph)
+	}
+	output = ts.ProcessCommand("latency", "graph", "fast-command")
+	if !output.isErrorString("ERR No samples available for event 'fast-command'") {
+		t.Fatal("latency graph no event fail")
+	}
+
+	output = ts.ProcessCommand("latency", "doctor")
+	report, _ := output.toString()
+	if !strings.HasPrefix(report, "Dave, I have observed latency spikes") || !strings.Contains(report, "1. command: 1 latency spikes") ||
+		!strings.Contains(report, "- Check your Slow Log") {
+		t.Fatalf("latency doctor fail: %s", report)
+	}
+
+	output = ts.ProcessCommand("latency", "reset", "fast-command", "command")
+	if !output.isInt(1) {
+		t.Fatal("latency reset fail")
+	}
+	output = ts.ProcessCommand("latency", "latest")
+	if !output.isArray() {
+		t.Fatal("latency latest after reset fail")
+	}
+}
+
+func TestLatencyTimeSeries(t *testing.T) {
+	var ts latencyTimeSeries
+	ts.add(100, 5)
+	ts.add(100, 7)
+	ts.add(101, 3)
+	for i := int64(0); i < latencyTsLen; i++ {
+		ts.add(200+i, 1)
+	}
+	ts.add(400, 9)
+
+	history := ts.history()
+	if len(history) != latencyTsLen || history[0].time != 201 || ts.latest() != (latencySample{time: 400, latency: 9}) || ts.max != 9 {
+		t.Fatal("latency time series fail")
+	}
+
+	ls := ts.analyze(410)
+	if ls.samples != latencyTsLen || ls.avg != 1 || ls.max != 9 || ls.period != 410-201 {
+		t.Fatalf("latency analysis fail %+v", ls)
+	}
+}
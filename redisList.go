@@ -319,7 +319,9 @@ func blockOnListChangeWorker(
 	}
 
 	ws := blockFn()
-	defer ctx.dsc.ds.leaveListBlock(ws)
+	defer func() {
+		ctx.dsc.ds.leaveListBlock(ws)
+	}()
 
 	// with notification registered, try operation again immediately
 	output = op()
@@ -338,6 +340,11 @@ func blockOnListChangeWorker(
 			unblockCh := ctx.cs.capture()
 			defer ctx.cs.releaseCapture()
 
+			waitStart := time.Now()
+			defer func() {
+				ctx.blocked += time.Since(waitStart)
+			}()
+
 			select {
 			case reason := <-unblockCh:
 				// abort this command - connectivity lost, or explicitly unblocked via another client
@@ -364,7 +371,17 @@ func blockOnListChangeWorker(
 		if output.data != nil {
 			return
 		}
-		// a different client obtained the list element before this client could, so try again
+
+		// a different client obtained the list element before this client could;
+		// the wake removed this client from the wait table, so get back in line
+		// and try again
+		ctx.dsc.ds.leaveListBlock(ws)
+		ws = blockFn()
+
+		output = op()
+		if output.data != nil {
+			return
+		}
 	}
 }
 
@@ -0,0 +1,132 @@
+package redisemu
+
+import (
+	"fmt"
+	"strings"
+)
+
+var memoryHelpText = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return memory problems reports.",
+	"MALLOC-STATS",
+	"    Return internal statistics report from the memory allocator.",
+	"PURGE",
+	"    Attempt to purge dirty pages for reclamation by the allocator.",
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value. Nested values are",
+	"    sampled up to <count> times (default: 5, 0 means sample all).",
+	"HELP",
+	"    Print this help.",
+}
+
+func fnMemoryDoctor(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	stats := ctx.cs.dss.memoryStats(ctx.dsc)
+
+	var report string
+	if stats.total < memDoctorMinimum {
+		report = "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. " +
+			"Please, leave for your mission on Earth and fill it with some data. " +
+			"The new Sam and I will be back to our programming as soon as I finished rebooting."
+	} else if float64(stats.peak) > float64(stats.total)*1.5 {
+		// the estimate has no fragmentation or client buffers to report, only
+		// the peak can be an issue
+		var sb strings.Builder
+		sb.WriteString("Sam, I detected a few issues in this Redis instance memory implants:\n\n")
+		sb.WriteString(" * Peak memory: In the past this instance used more than 150% the memory that is currently using. " +
+			"The allocator is normally not able to release memory after a peak, so you can expect to see a big fragmentation ratio, " +
+			"however this is actually harmless and is only due to the memory peak, and if the Redis instance Resident Set Size (RSS) " +
+			"is currently bigger than expected, the memory will be used as soon as you fill the Redis instance with more data. " +
+			"If the memory peak was only occasional and you want to try to reclaim memory, please try the MEMORY PURGE command, " +
+			"otherwise the only other option is to shutdown and restart the instance.\n\n")
+		sb.WriteString("I'm here to keep you safe, Sam. I want to help you.\n")
+		report = sb.String()
+	} else {
+		report = "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	}
+
+	output.data = respVerbatimString{format: "txt", text: report}
+	return
+}
+
+func fnMemoryHelp(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	a := make(respArray, 0, len(memoryHelpText))
+	for _, line := range memoryHelpText {
+		a = append(a, respValue{data: respSimpleString(line)})
+	}
+	output.data = a
+	return
+}
+
+func fnMemoryMallocStats(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	output.data = respVerbatimString{format: "txt", text: "Stats not supported for the current allocator"}
+	return
+}
+
+func fnMemoryPurge(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	output.data = rstrOK
+	return
+}
+
+func fnMemoryStats(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	stats := ctx.cs.dss.memoryStats(ctx.dsc)
+
+	result := newOrderedMap()
+	result.set("peak.allocated", stats.peak)
+	result.set("total.allocated", stats.total)
+	result.set("startup.allocated", stats.startup)
+	result.set("replication.backlog", 0)
+	result.set("clients.slaves", 0)
+	result.set("clients.normal", stats.clients)
+	result.set("cluster.links", 0)
+	result.set("aof.buffer", 0)
+	result.set("lua.caches", 0)
+	result.set("functions.caches", 0)
+	for _, db := range stats.dbs {
+		overhead := newOrderedMap()
+		overhead.set("overhead.hashtable.main", db.main)
+		overhead.set("overhead.hashtable.expires", db.expires)
+		result.set(fmt.Sprintf("db.%d", db.index), overhead)
+	}
+	result.set("overhead.total", stats.overhead)
+	result.set("keys.count", stats.keys)
+	result.set("keys.bytes-per-key", stats.bytesPerKey())
+	result.set("dataset.bytes", stats.dataset)
+	result.set("dataset.percentage", stats.datasetPercentage())
+	result.set("peak.percentage", stats.peakPercentage())
+
+	// the estimate is what the allocator would have, without fragmentation
+	result.set("allocator.allocated", stats.total)
+	result.set("allocator.active", stats.total)
+	result.set("allocator.resident", stats.total)
+	result.set("allocator-fragmentation.ratio", 1.0)
+	result.set("allocator-fragmentation.bytes", 0)
+	result.set("allocator.rss-ratio", 1.0)
+	result.set("allocator.rss-bytes", 0)
+	result.set("rss-overhead.ratio", 1.0)
+	result.set("rss-overhead.bytes", 0)
+	result.set("fragmentation", 1.0)
+	result.set("fragmentation.bytes", 0)
+
+	output = nativeValueToResp(result)
+	return
+}
+
+func fnMemoryUsage(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	samples := int64(memSamplesDefault)
+	if count, specified := args["count"].(int64); specified {
+		if count < 0 {
+			output.data = respErrorString("ERR syntax error")
+			return
+		}
+		samples = count
+	}
+
+	size, exists := ctx.dsc.memoryUsage(args["key"].(string), int(samples))
+	if exists {
+		output.data = respInt(size)
+	}
+	return
+}
@@ -0,0 +1,184 @@
+package redisemu
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMemoryAllocSizes(t *testing.T) {
+	for n, expected := range map[int64]int64{0: 8, 1: 8, 9: 16, 64: 64, 65: 80, 104: 112, 129: 160, 4097: 5120} {
+		if allocSize(n) != expected {
+			t.Fatalf("alloc size of %d fail: %d", n, allocSize(n))
+		}
+	}
+
+	for length, expected := range map[int]int64{0: 8, 3: 8, 31: 40, 32: 40, 300: 320} {
+		if sdsAllocSize(length) != expected {
+			t.Fatalf("sds size of %d fail: %d", length, sdsAllocSize(length))
+		}
+	}
+
+	for value, expected := range map[string]int64{"7": 2, "1000": 3, "-40000": 5, "a": 3, strings.Repeat("x", 100): 103} {
+		if listpackEntrySize(value) != expected {
+			t.Fatalf("listpack entry %q fail: %d", value, listpackEntrySize(value))
+		}
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("set", "", "")
+	output := ts.ProcessCommand("memory", "usage", "")
+	if !output.isInt(56) {
+		t.Fatal("memory usage embstr fail")
+	}
+
+	ts.ProcessCommand("set", "n", "12345")
+	output = ts.ProcessCommand("memory", "usage", "n")
+	if !output.isInt(48) {
+		t.Fatal("memory usage int fail")
+	}
+
+	ts.ProcessCommand("set", "r", strings.Repeat("x", 100))
+	output = ts.ProcessCommand("memory", "usage", "r")
+	if !output.isInt(160) {
+		t.Fatal("memory usage raw fail")
+	}
+
+	ts.ProcessCommand("sadd", "s", "1", "2", "3")
+	output = ts.ProcessCommand("memory", "usage", "s")
+	if !output.isInt(64) {
+		t.Fatal("memory usage intset fail")
+	}
+
+	ts.ProcessCommand("rpush", "l", "a", "b", "c")
+	output = ts.ProcessCommand("memory", "usage", "l")
+	if !output.isInt(64) {
+		t.Fatal("memory usage listpack fail")
+	}
+
+	output = ts.ProcessCommand("memory", "usage", "missing")
+	if !output.isNull() {
+		t.Fatal("memory usage missing fail")
+	}
+
+	output = ts.ProcessCommand("memory", "usage", "s", "samples", "-1")
+	if !output.isErrorString("ERR syntax error") {
+		t.Fatal("memory usage negative samples fail")
+	}
+}
+
+func TestMemoryUsageSamples(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	// 200 fields make a hashtable of 256 slots with 40 byte entries
+	args := []any{"h"}
+	for i := 0; i < 200; i++ {
+		args = append(args, fmt.Sprintf("f%03d", i), "v")
+	}
+	ts.ProcessCommand("hset", args...)
+
+	output := ts.ProcessCommand("memory", "usage", "h", "samples", "0")
+	if !output.isInt(16 + 56 + 8*256 + 200*40 + 8 + 24) {
+		t.Fatal("memory usage all samples fail")
+	}
+
+	// one long field changes the estimate when it is sampled
+	ts.ProcessCommand("hset", "h", "f000", strings.Repeat("v", 1000))
+	all := ts.ProcessCommand("memory", "usage", "h", "samples", "0")
+	if !all.isInt(16 + 56 + 8*256 + 199*40 + 24 + 8 + 1024 + 8 + 24) {
+		t.Fatal("memory usage long field fail")
+	}
+	output = ts.ProcessCommand("memory", "usage", "h")
+	if !output.isAtLeast(16 + 56 + 8*256 + 200*40 + 8 + 24) {
+		t.Fatal("memory usage default samples fail")
+	}
+}
+
+func TestMemoryStats(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	tc, isTestClient := ts.(*testClient)
+	if !isTestClient {
+		t.Skip("memory totals depend on the server")
+	}
+
+	before := tc.dss.memoryStats(tc.cs.ds.newDataStoreCommand())
+	if before.keys != 0 || before.dataset != 0 || before.total < memStartup {
+		t.Fatal("memory stats empty fail")
+	}
+
+	ts.ProcessCommand("set", "key1", strings.Repeat("x", 1000))
+	ts.ProcessCommand("set", "key2", "cat", "ex", "100")
+	stats := tc.dss.memoryStats(tc.cs.ds.newDataStoreCommand())
+	if stats.keys != 2 || stats.dataset != 1048+32 || stats.total != before.total+stats.dataset+(8*4+24*2)+(8*4+24) {
+		t.Fatalf("memory stats keys fail: %+v", stats)
+	}
+
+	output := ts.ProcessCommand("memory", "stats")
+	m, valid := output.toMap()
+	if !valid {
+		t.Fatal("memory stats reply fail")
+	}
+	for name, expected := range map[string]int64{"total.allocated": stats.total, "keys.count": 2, "dataset.bytes": stats.dataset, "overhead.total": stats.overhead} {
+		value := m[respValue{data: respBulkString(name)}]
+		if !value.isInt64(expected) {
+			t.Fatalf("memory stats %s fail", name)
+		}
+	}
+	db := m[respValue{data: respBulkString("db.0")}]
+	if !db.isMap(map[any]any{"overhead.hashtable.main": 8*4 + 24*2, "overhead.hashtable.expires": 8*4 + 24}) {
+		t.Fatal("memory stats db fail")
+	}
+
+	output = ts.ProcessCommand("info", "memory")
+	if !strings.Contains(output.String(), "used_memory:"+strconv.FormatInt(stats.total, 10)+"\r\n") ||
+		!strings.Contains(output.String(), "used_memory_dataset:"+strconv.FormatInt(stats.dataset, 10)+"\r\n") {
+		t.Fatal("info memory fail")
+	}
+
+	ts.ProcessCommand("del", "key1", "key2")
+	after := tc.dss.memoryStats(tc.cs.ds.newDataStoreCommand())
+	if after.total != before.total || after.dataset != 0 || after.peak < stats.total {
+		t.Fatal("memory stats after delete fail")
+	}
+
+	ts.ProcessCommand("set", "key1", "cat")
+	ts.ProcessCommand("flushall")
+	if tc.dss.memoryStats(tc.cs.ds.newDataStoreCommand()).total != before.total {
+		t.Fatal("memory stats after flush fail")
+	}
+}
+
+func TestMemoryDoctor(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	if _, isTestClient := ts.(*testClient); !isTestClient {
+		t.Skip("memory totals depend on the server")
+	}
+
+	output := ts.ProcessCommand("memory", "doctor")
+	if report, _ := output.toString(); !strings.HasPrefix(report, "Hi Sam, this instance is empty") {
+		t.Fatal("memory doctor empty fail")
+	}
+
+	ts.ProcessCommand("set", "key1", strings.Repeat("x", 5<<20))
+	output = ts.ProcessCommand("memory", "doctor")
+	if report, _ := output.toString(); !strings.HasPrefix(report, "Hi Sam, I can't find any memory issue") {
+		t.Fatal("memory doctor no issues fail")
+	}
+
+	ts.ProcessCommand("set", "key2", strings.Repeat("x", 4<<20))
+	ts.ProcessCommand("del", "key2")
+	output = ts.ProcessCommand("memory", "doctor")
+	if !strings.Contains(output.String(), " * Peak memory: ") {
+		t.Fatal("memory doctor peak fail")
+	}
+}
@@ -0,0 +1,114 @@
+package redisemu
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// monitorRegistry holds the monitors of an emulator, the clients that
+	// receive a line for each command that the server processes. Like
+	// pub/sub messages, the lines are written to the monitoring clients
+	// out-of-band.
+	monitorRegistry struct {
+		mu      sync.Mutex
+		clients map[*clientState]struct{}
+	}
+)
+
+func fnMonitor(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	if ctx.multi {
+		output.data = respErrorString("ERR MONITOR isn't allowed for DENY BLOCKING client")
+		return
+	}
+
+	mr := &ctx.cs.dss.monitors
+	mr.mu.Lock()
+	mr.clients[ctx.cs] = struct{}{}
+	mr.mu.Unlock()
+
+	output.data = rstrOK
+	return
+}
+
+func (cs *clientState) isMonitoring() bool {
+	mr := &cs.dss.monitors
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	_, exists := mr.clients[cs]
+	return exists
+}
+
+// detaches the client from the monitors, for RESET and a departing client
+func (cs *clientState) stopMonitoring() {
+	mr := &cs.dss.monitors
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	delete(mr.clients, cs)
+}
+
+// sends the command to the monitors of the server, in the format of
+// redis: the time, the database and client address, and the quoted
+// arguments; commands of a script show "lua" instead of an address
+func feedMonitors(ctx *cmdContext) {
+	mr := &ctx.cs.dss.monitors
+	mr.mu.Lock()
+	recipients := make([]*clientState, 0, len(mr.clients))
+	for cs := range mr.clients {
+		recipients = append(recipients, cs)
+	}
+	mr.mu.Unlock()
+
+	if len(recipients) == 0 {
+		return
+	}
+
+	source := "lua"
+	if !ctx.script {
+		source = ctx.cs.client.ClientAddr()
+	}
+
+	now := time.Now()
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%d.%06d [%d %s]", now.Unix(), now.Nanosecond()/1000, ctx.cs.selectedDb, source))
+	for _, arg := range monitorArgs(ctx.rawArgs) {
+		sb.WriteByte(' ')
+		sb.WriteString(configQuote(arg))
+	}
+	line := respValue{data: respSimpleString(sb.String())}
+
+	for _, cs := range recipients {
+		cs.sendMessage(line)
+	}
+}
+
+// provides the arguments of a command as a monitor shows them, hiding the
+// credentials of AUTH and HELLO
+func monitorArgs(rawArgs respArray) (args []string) {
+	args = make([]string, 0, len(rawArgs))
+	for _, rawArg := range rawArgs {
+		text, _ := rawArg.toString()
+		args = append(args, text)
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "auth":
+		for i := 1; i < len(args); i++ {
+			args[i] = "(redacted)"
+		}
+	case "hello":
+		for i := 1; i < len(args); i++ {
+			if strings.EqualFold(args[i], "auth") {
+				for j := i + 1; j < min(i+3, len(args)); j++ {
+					args[j] = "(redacted)"
+				}
+				break
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,145 @@
+package redisemu
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var monitorLinePrefix = regexp.MustCompile(`^[0-9]+\.[0-9]{6} \[`)
+
+// checks the monitor lines received, without the timestamps
+func isMonitorLines(pushes []respValue, expected ...string) bool {
+	if len(pushes) != len(expected) {
+		return false
+	}
+	for i, push := range pushes {
+		line, valid := push.data.(respSimpleString)
+		if !valid || !monitorLinePrefix.MatchString(string(line)) {
+			return false
+		}
+		if monitorLinePrefix.ReplaceAllString(string(line), "[") != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMonitor(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	output := ts2.ProcessCommand("monitor")
+	if !output.isString("OK") {
+		t.Fatal("monitor fail")
+	}
+
+	addr := ts.ClientAddr()
+	ts.ProcessCommand("set", "key1", "cat \"dog\"\n")
+	ts.ProcessCommand("select", "1")
+	ts.ProcessCommand("get", "key1")
+	ts.ProcessCommand("select", "0")
+	if !isMonitorLines(ts2.PendingPushes(),
+		"[0 "+addr+`] "set" "key1" "cat \"dog\"\n"`,
+		"[1 "+addr+`] "select" "1"`,
+		"[1 "+addr+`] "get" "key1"`,
+		"[0 "+addr+`] "select" "0"`) {
+		t.Fatal("monitor lines fail")
+	}
+
+	// admin commands aren't shown, and credentials are redacted
+	ts.ProcessCommand("config", "get", "timeout")
+	ts.ProcessCommand("hello", "2", "auth", "default", "secret", "setname", "x")
+	if !isMonitorLines(ts2.PendingPushes(),
+		"[0 "+addr+`] "hello" "2" "auth" "(redacted)" "(redacted)" "setname" "x"`) {
+		t.Fatal("monitor redaction fail")
+	}
+	args := monitorArgs(respArray{{data: respBulkString("AUTH")}, {data: respBulkString("user")}, {data: respBulkString("secret")}})
+	if strings.Join(args, " ") != "AUTH (redacted) (redacted)" {
+		t.Fatal("monitor auth redaction fail")
+	}
+
+	output = ts2.ProcessCommand("client", "info")
+	if !strings.Contains(output.String(), " flags=O ") {
+		t.Fatal("monitor client flag fail")
+	}
+}
+
+func TestMonitorTransaction(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	ts2.ProcessCommand("monitor")
+
+	addr := ts.ClientAddr()
+	ts.ProcessCommand("multi")
+	ts.ProcessCommand("set", "key1", "1")
+	ts.ProcessCommand("incr", "key1")
+	ts.ProcessCommand("exec")
+	if !isMonitorLines(ts2.PendingPushes(),
+		"[0 "+addr+`] "multi"`,
+		"[0 "+addr+`] "set" "key1" "1"`,
+		"[0 "+addr+`] "incr" "key1"`,
+		"[0 "+addr+`] "exec"`) {
+		t.Fatal("monitor transaction fail")
+	}
+
+	ts.ProcessCommand("multi")
+	ts.ProcessCommand("monitor")
+	output := ts.ProcessCommand("exec")
+	if a, _ := output.toArray(); len(a) != 1 || !a[0].isErrorString("ERR MONITOR isn't allowed for DENY BLOCKING client") {
+		t.Fatal("monitor in transaction fail")
+	}
+}
+
+func TestMonitorScript(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	ts2.ProcessCommand("monitor")
+
+	ts.ProcessCommand("eval", "return redis.call('get', KEYS[1])", "1", "key1")
+	if !isMonitorLines(ts2.PendingPushes(),
+		"[0 "+ts.ClientAddr()+`] "eval" "return redis.call('get', KEYS[1])" "1" "key1"`,
+		`[0 lua] "get" "key1"`) {
+		t.Fatal("monitor script fail")
+	}
+}
+
+func TestMonitorDetach(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	ts3 := ts.AdditionalClient()
+	defer ts3.Close()
+
+	ts2.ProcessCommand("monitor")
+	ts3.ProcessCommand("monitor")
+
+	output := ts3.ProcessCommand("reset")
+	if !output.isString("RESET") {
+		t.Fatal("monitor reset fail")
+	}
+	ts.ProcessCommand("ping")
+	if len(ts3.PendingPushes()) != 0 || !isMonitorLines(ts2.PendingPushes(),
+		"[0 "+ts3.ClientAddr()+`] "reset"`,
+		"[0 "+ts.ClientAddr()+`] "ping"`) {
+		t.Fatal("monitor reset detach fail")
+	}
+
+	if tc, isTestClient := ts2.(*testClient); isTestClient {
+		cs := tc.cs
+		ts2.Close()
+		if cs.isMonitoring() {
+			t.Fatal("monitor disconnect detach fail")
+		}
+	} else {
+		ts2.Close()
+	}
+}
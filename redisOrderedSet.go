@@ -1,23 +1,70 @@
 package redisemu
 
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+)
+
 type (
+	// a sorted set keeps a table of members for lookup by name, and an
+	// AVL tree of index keys (see zsetIndexKey) for ordered operations
 	zset struct {
-		keyRoot *znode
+		members *redisDict       // member -> float64 score
+		index   *avlTree[string] // score-ordered keys
 	}
 
-	znode struct {
-		key    string
+	zsetIterator func(member string, score float64) bool
+
+	// combines sorted sets for ZUNION, ZINTER and ZDIFF; a nil source is a missing key
+	zsetCombiner func(sources []*zset) *zset
+
+	// combines the weighted scores of a member found in more than one sorted set
+	zsetAggregator func(a, b float64) float64
+
+	zsetItem struct {
+		member string
 		score  float64
-		left   *znode
-		right  *znode
-		parent *znode
 	}
 
-	zaddFlags int
+	// selects the range of a ZRANGE style operation; the range is by rank
+	// unless scores or lex is specified
+	zrangeSpec struct {
+		start   int
+		stop    int
+		scores  *zscoreRange
+		lex     *zlexRange
+		reverse bool
+		offset  int
+		limit   int // -1 for no limit
+	}
+
+	zaddResult int
+
+	// score range as specified by ZRANGEBYSCORE, ZCOUNT, etc.
+	zscoreRange struct {
+		min          float64
+		max          float64
+		minExclusive bool
+		maxExclusive bool
+	}
+
+	// lexicographical range as specified by ZRANGEBYLEX, ZLEXCOUNT, etc.
+	zlexRange struct {
+		min zlexBound
+		max zlexBound
+	}
+
+	zlexBound struct {
+		value     string
+		exclusive bool
+		infinity  int // -1 for "-", 1 for "+", 0 for a value
+	}
 )
 
 const (
-	ZADD_NX zaddFlags = 1 << iota
+	ZADD_NX bitflags = 1 << iota
 	ZADD_XX
 	ZADD_GT
 	ZADD_LT
@@ -25,17 +72,533 @@ const (
 	ZADD_INCR
 )
 
-var _ = newZSet // not yet fully implemented
+const (
+	ZADD_RESULT_UNCHANGED zaddResult = iota
+	ZADD_RESULT_ADDED
+	ZADD_RESULT_UPDATED
+	ZADD_RESULT_SKIPPED
+	ZADD_RESULT_NAN
+)
+
+const zsetSignBit = uint64(1) << 63
+
 func newZSet() *zset {
-	zs := &zset{}
+	return &zset{
+		members: newRedisDict(),
+		index:   NewAvlTree[string](),
+	}
+}
+
+func newZSetFromScoreTable(m map[string]float64) *zset {
+	z := newZSet()
+	for member, score := range m {
+		z.set(member, score)
+	}
+	z.members.dirty = false
+	return z
+}
+
+// makes a string that sorts by score, then by member; the score is encoded
+// as 8 big endian bytes, with the bits adjusted so that memcmp order
+// matches numeric order
+func zsetIndexKey(member string, score float64) string {
+	bits := math.Float64bits(score)
+	if (bits & zsetSignBit) != 0 {
+		bits = ^bits
+	} else {
+		bits |= zsetSignBit
+	}
+
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], bits)
+	return string(b[:]) + member
+}
+
+func zsetIndexScore(key string) float64 {
+	bits := binary.BigEndian.Uint64([]byte(key[:8]))
+	if (bits & zsetSignBit) != 0 {
+		bits &^= zsetSignBit
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+func zsetIndexMember(key string) string {
+	return key[8:]
+}
+
+func (z *zset) count() int {
+	return z.members.count
+}
 
-	// temporary disable warnings about incomplete work
-	_ = zs.add
-	_ = zs.keyRoot
-	_ = znode{"", 0, nil, nil, nil}
-	return zs
+func (z *zset) score(member string) (score float64, exists bool) {
+	v, exists := z.members.get(member)
+	if exists {
+		score = v.(float64)
+	}
+	return
 }
 
-func (z *zset) add(key string, score float64, flags zaddFlags) (out respValue) {
+// unconditionally stores a member, returning true if the member is new
+func (z *zset) set(member string, score float64) (added bool) {
+	if score == 0 {
+		score = 0 // -0 and 0 are the same score
+	}
+
+	old, exists := z.score(member)
+	if exists {
+		if old == score {
+			return
+		}
+		z.index.Delete(zsetIndexKey(member, old))
+	} else {
+		added = true
+	}
+
+	z.members.store(member, score)
+	z.index.Add(zsetIndexKey(member, score))
 	return
 }
+
+func (z *zset) remove(member string) bool {
+	score, exists := z.score(member)
+	if !exists {
+		return false
+	}
+
+	z.members.remove(member)
+	z.index.Delete(zsetIndexKey(member, score))
+	return true
+}
+
+// implements the ZADD update rules for a single member
+func (z *zset) add(member string, score float64, flags bitflags) (newScore float64, result zaddResult) {
+	old, exists := z.score(member)
+	if exists {
+		if flagHasOne(flags, ZADD_NX) {
+			result = ZADD_RESULT_SKIPPED
+			return
+		}
+	} else if flagHasOne(flags, ZADD_XX) {
+		result = ZADD_RESULT_SKIPPED
+		return
+	}
+
+	if flagHasOne(flags, ZADD_INCR) && exists {
+		score += old
+		if math.IsNaN(score) {
+			result = ZADD_RESULT_NAN
+			return
+		}
+	}
+
+	if exists {
+		if (flagHasOne(flags, ZADD_GT) && score <= old) ||
+			(flagHasOne(flags, ZADD_LT) && score >= old) {
+			newScore = old
+			result = ZADD_RESULT_SKIPPED
+			return
+		}
+	}
+
+	newScore = score
+	if z.set(member, score) {
+		result = ZADD_RESULT_ADDED
+	} else if exists && old != score {
+		result = ZADD_RESULT_UPDATED
+	}
+	return
+}
+
+// returns the zero-based position of a member
+func (z *zset) rank(member string, reverse bool) (rank int, score float64, exists bool) {
+	score, exists = z.score(member)
+	if !exists {
+		return
+	}
+
+	rank, _ = z.index.Rank(zsetIndexKey(member, score))
+	if reverse {
+		rank = z.index.Count() - 1 - rank
+	}
+	return
+}
+
+func (z *zset) iterate(reverse bool, iter zsetIterator) {
+	fn := func(node *avlNode[string]) bool {
+		return iter(zsetIndexMember(node.key), zsetIndexScore(node.key))
+	}
+
+	if reverse {
+		z.index.IterateReverse(fn)
+	} else {
+		z.index.Iterate(fn)
+	}
+}
+
+func (z *zset) iterateScoreRange(r *zscoreRange, reverse bool, iter zsetIterator) {
+	if reverse {
+		z.index.IterateReverseFrom(
+			func(key string) bool { return r.lteMax(zsetIndexScore(key)) },
+			func(node *avlNode[string]) bool {
+				score := zsetIndexScore(node.key)
+				if !r.gteMin(score) {
+					return false
+				}
+				return iter(zsetIndexMember(node.key), score)
+			})
+	} else {
+		z.index.IterateFrom(
+			func(key string) bool { return r.gteMin(zsetIndexScore(key)) },
+			func(node *avlNode[string]) bool {
+				score := zsetIndexScore(node.key)
+				if !r.lteMax(score) {
+					return false
+				}
+				return iter(zsetIndexMember(node.key), score)
+			})
+	}
+}
+
+// like redis, lexicographical ranges assume all members have the same score
+func (z *zset) iterateLexRange(r *zlexRange, reverse bool, iter zsetIterator) {
+	if reverse {
+		z.index.IterateReverseFrom(
+			func(key string) bool { return r.lteMax(zsetIndexMember(key)) },
+			func(node *avlNode[string]) bool {
+				member := zsetIndexMember(node.key)
+				if !r.gteMin(member) {
+					return false
+				}
+				return iter(member, zsetIndexScore(node.key))
+			})
+	} else {
+		z.index.IterateFrom(
+			func(key string) bool { return r.gteMin(zsetIndexMember(key)) },
+			func(node *avlNode[string]) bool {
+				member := zsetIndexMember(node.key)
+				if !r.lteMax(member) {
+					return false
+				}
+				return iter(member, zsetIndexScore(node.key))
+			})
+	}
+}
+
+// visits the members selected by spec, in the order of the spec
+func (z *zset) iterateRange(spec *zrangeSpec, iter zsetIterator) {
+	if spec.scores == nil && spec.lex == nil {
+		count := z.count()
+		start := spec.start
+		stop := spec.stop
+
+		// convert negative indexes and enforce boundaries
+		if start < 0 {
+			start += count
+		}
+		if stop < 0 {
+			stop += count
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= count {
+			stop = count - 1
+		}
+		if start > stop {
+			return
+		}
+
+		// the index keeps subtree sizes, so iteration starts at the rank
+		remaining := stop - start + 1
+		fn := func(node *avlNode[string]) bool {
+			if !iter(zsetIndexMember(node.key), zsetIndexScore(node.key)) {
+				return false
+			}
+			remaining--
+			return remaining > 0
+		}
+		if spec.reverse {
+			z.index.IterateReverseFromRank(start, fn)
+		} else {
+			z.index.IterateFromRank(start, fn)
+		}
+		return
+	}
+
+	if spec.offset < 0 || spec.limit == 0 {
+		return
+	}
+
+	skip := spec.offset
+	limit := spec.limit
+	fn := func(member string, score float64) bool {
+		if skip > 0 {
+			skip--
+			return true
+		}
+		if !iter(member, score) {
+			return false
+		}
+		if limit > 0 {
+			limit--
+			return limit > 0
+		}
+		return true
+	}
+
+	if spec.scores != nil {
+		z.iterateScoreRange(spec.scores, spec.reverse, fn)
+	} else {
+		z.iterateLexRange(spec.lex, spec.reverse, fn)
+	}
+}
+
+// returns the members selected by spec, in the order of the spec
+func (z *zset) selectRange(spec *zrangeSpec) (items []zsetItem) {
+	items = []zsetItem{}
+	z.iterateRange(spec, func(member string, score float64) bool {
+		items = append(items, zsetItem{member, score})
+		return true
+	})
+	return
+}
+
+func (z *zset) clone() *zset {
+	newZ := newZSet()
+	for it := z.members.createIterator(); it.next(); {
+		newZ.set(it.key, it.value.(float64))
+	}
+	return newZ
+}
+
+func (z *zset) toScoreTable() map[string]float64 {
+	result := make(map[string]float64, z.members.count)
+	for it := z.members.createIterator(); it.next(); {
+		result[it.key] = it.value.(float64)
+	}
+	return result
+}
+
+// parses a score range bound such as "1.5", "(1.5", "-inf" or "+inf"
+func parseZScoreBound(text string) (score float64, exclusive bool, valid bool) {
+	if strings.HasPrefix(text, "(") {
+		exclusive = true
+		text = text[1:]
+	}
+
+	score, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(score) {
+		return
+	}
+
+	valid = true
+	return
+}
+
+func newZScoreRange(minText, maxText string) (r *zscoreRange, valid bool) {
+	r = &zscoreRange{}
+	if r.min, r.minExclusive, valid = parseZScoreBound(minText); !valid {
+		return
+	}
+	r.max, r.maxExclusive, valid = parseZScoreBound(maxText)
+	return
+}
+
+func (r *zscoreRange) gteMin(score float64) bool {
+	if r.minExclusive {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+func (r *zscoreRange) lteMax(score float64) bool {
+	if r.maxExclusive {
+		return score < r.max
+	}
+	return score <= r.max
+}
+
+// parses a lex range bound such as "[a", "(a", "-" or "+"
+func parseZLexBound(text string) (bound zlexBound, valid bool) {
+	if text == "-" {
+		bound.infinity = -1
+	} else if text == "+" {
+		bound.infinity = 1
+	} else if strings.HasPrefix(text, "[") {
+		bound.value = text[1:]
+	} else if strings.HasPrefix(text, "(") {
+		bound.value = text[1:]
+		bound.exclusive = true
+	} else {
+		return
+	}
+
+	valid = true
+	return
+}
+
+func newZLexRange(minText, maxText string) (r *zlexRange, valid bool) {
+	r = &zlexRange{}
+	if r.min, valid = parseZLexBound(minText); !valid {
+		return
+	}
+	r.max, valid = parseZLexBound(maxText)
+	return
+}
+
+func (r *zlexRange) gteMin(member string) bool {
+	switch r.min.infinity {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+
+	if r.min.exclusive {
+		return member > r.min.value
+	}
+	return member >= r.min.value
+}
+
+func (r *zlexRange) lteMax(member string) bool {
+	switch r.max.infinity {
+	case -1:
+		return false
+	case 1:
+		return true
+	}
+
+	if r.max.exclusive {
+		return member < r.max.value
+	}
+	return member <= r.max.value
+}
+
+// formats members for a reply; RESP3 gets an array of pairs when scores are included
+func zsetItemsToResp(items []zsetItem, withScores bool) (output respValue) {
+	if withScores {
+		pairs := make(respPairs, 0, len(items))
+		for _, item := range items {
+			pairs = append(pairs, respPair{
+				key:   respValue{data: respBulkString(item.member)},
+				value: respValue{data: respDouble(item.score)},
+			})
+		}
+		output.data = pairs
+	} else {
+		a := make(respArray, 0, len(items))
+		for _, item := range items {
+			a = append(a, respValue{data: respBulkString(item.member)})
+		}
+		output.data = a
+	}
+	return
+}
+
+func zsetAggregateSum(a, b float64) float64 {
+	sum := a + b
+	if math.IsNaN(sum) {
+		return 0 // inf + -inf
+	}
+	return sum
+}
+
+func zsetAggregateMin(a, b float64) float64 {
+	return math.Min(a, b)
+}
+
+func zsetAggregateMax(a, b float64) float64 {
+	return math.Max(a, b)
+}
+
+func zsetWeightedScore(score, weight float64) float64 {
+	weighted := score * weight
+	if math.IsNaN(weighted) {
+		return 0 // inf * 0
+	}
+	return weighted
+}
+
+func zsetUnion(sources []*zset, weights []float64, aggregate zsetAggregator) *zset {
+	result := newZSet()
+	for idx, z := range sources {
+		if z == nil {
+			continue
+		}
+
+		z.iterate(false, func(member string, score float64) bool {
+			score = zsetWeightedScore(score, weights[idx])
+			if prior, exists := result.score(member); exists {
+				score = aggregate(prior, score)
+			}
+			result.set(member, score)
+			return true
+		})
+	}
+	return result
+}
+
+func zsetIntersect(sources []*zset, weights []float64, aggregate zsetAggregator) *zset {
+	result := newZSet()
+	for _, z := range sources {
+		if z == nil {
+			return result
+		}
+	}
+
+	sources[0].iterate(false, func(member string, score float64) bool {
+		score = zsetWeightedScore(score, weights[0])
+		for idx := 1; idx < len(sources); idx++ {
+			other, exists := sources[idx].score(member)
+			if !exists {
+				return true
+			}
+			score = aggregate(score, zsetWeightedScore(other, weights[idx]))
+		}
+		result.set(member, score)
+		return true
+	})
+	return result
+}
+
+func zsetIntersectCount(sources []*zset, limit int) (count int) {
+	for _, z := range sources {
+		if z == nil {
+			return
+		}
+	}
+
+	sources[0].iterate(false, func(member string, score float64) bool {
+		for idx := 1; idx < len(sources); idx++ {
+			if _, exists := sources[idx].score(member); !exists {
+				return true
+			}
+		}
+		count++
+		return limit == 0 || count < limit
+	})
+	return
+}
+
+func zsetDiff(sources []*zset) *zset {
+	result := newZSet()
+	if sources[0] == nil {
+		return result
+	}
+
+	sources[0].iterate(false, func(member string, score float64) bool {
+		for idx := 1; idx < len(sources); idx++ {
+			if sources[idx] == nil {
+				continue
+			}
+			if _, exists := sources[idx].score(member); exists {
+				return true
+			}
+		}
+		result.set(member, score)
+		return true
+	})
+	return result
+}
@@ -0,0 +1,73 @@
+package redisemu
+
+func fnSave(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	if ctx.cs.dss.persistenceStats().bgSaveInProgress {
+		output.data = respErrorString("ERR Background save already in progress")
+		return
+	}
+
+	if saveErr := ctx.cs.dss.save(ctx.cs.l, ctx.dsc); saveErr != nil {
+		ctx.cs.l.Errorf("save failed: %s", saveErr)
+		output.data = respErrorString("ERR")
+		return
+	}
+
+	output.data = rstrOK
+	return
+}
+
+func fnBgSave(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	_, schedule := args["schedule"]
+
+	started, scheduled := ctx.cs.dss.backgroundSave(ctx.cs.l, schedule)
+	if started {
+		output.data = respSimpleString("Background saving started")
+	} else if scheduled {
+		output.data = respSimpleString("Background saving scheduled")
+	} else {
+		output.data = respErrorString("ERR Background save already in progress")
+	}
+	return
+}
+
+func fnLastSave(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	output.data = respInt(ctx.cs.dss.persistenceStats().lastSave.Unix())
+	return
+}
+
+func fnShutdown(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	// the emulator shuts down synchronously, so there's never a shutdown to abort
+	if _, abort := args["abort"]; abort {
+		output.data = respErrorString("ERR No shutdown in progress.")
+		return
+	}
+
+	_, force := args["force"]
+	_, nosave := args["save-selector.nosave"]
+	_, save := args["save-selector.save"]
+
+	// without a selector, a save is made if save points are configured
+	if !save && !nosave {
+		rules, _ := parseSaveRules(ctx.cs.dss.config.get("save"))
+		save = len(rules) > 0
+	}
+
+	if save {
+		// a background save in progress finishes before this save starts
+		if saveErr := ctx.cs.dss.save(ctx.cs.l, ctx.dsc); saveErr != nil {
+			ctx.cs.l.Errorf("shutdown save failed: %s", saveErr)
+			if !force {
+				output.data = respErrorString("ERR Errors trying to SHUTDOWN. Check logs.")
+				return
+			}
+		}
+	}
+
+	// like Redis, the connection closes without a reply
+	ctx.cs.l.Infof("client %d requested shutdown", ctx.cs.id)
+	ctx.cs.noReply = true
+	if ctx.cs.dss.shutdown != nil {
+		ctx.cs.dss.shutdown()
+	}
+	return
+}
@@ -0,0 +1,160 @@
+package redisemu
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLastSave(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	tc, isTestClient := ts.(*testClient)
+	if !isTestClient {
+		t.Skip("persistence state is internal")
+	}
+
+	before := tc.dss.persistenceStats().saves
+	ts.ProcessCommand("set", "key1", "cat")
+	if tc.dss.changesSinceSave() == 0 {
+		t.Fatal("changes not counted")
+	}
+
+	output := ts.ProcessCommand("save")
+	if !output.isString("OK") {
+		t.Fatal("save fail")
+	}
+	if tc.dss.persistenceStats().saves != before+1 || tc.dss.changesSinceSave() != 0 {
+		t.Fatal("save stats fail")
+	}
+
+	output = ts.ProcessCommand("lastsave")
+	if !output.isInt(int(tc.dss.persistenceStats().lastSave.Unix())) {
+		t.Fatal("lastsave fail")
+	}
+
+	output = ts.ProcessCommand("info", "persistence")
+	text := output.String()
+	if !strings.Contains(text, "rdb_changes_since_last_save:0\r\n") || !strings.Contains(text, "rdb_last_bgsave_time_sec:-1\r\n") {
+		t.Fatal("info persistence fail")
+	}
+}
+
+func TestSaveInMulti(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	tc, isTestClient := ts.(*testClient)
+	if !isTestClient {
+		t.Skip("persistence path is set at startup")
+	}
+	tc.dss.basePath = filepath.Join(t.TempDir(), "emu")
+
+	ts.ProcessCommand("set", "key1", "cat")
+	ts.ProcessCommand("multi")
+	output := ts.ProcessCommand("save")
+	if !output.isErrorString("ERR Command not allowed inside a transaction") {
+		t.Fatal("save in multi fail")
+	}
+
+	output = ts.ProcessCommand("exec")
+	if !output.isErrorString("EXECABORT Transaction discarded because of previous errors.") {
+		t.Fatal("save in multi exec fail")
+	}
+
+	ts.ProcessCommand("multi")
+	output = ts.ProcessCommand("shutdown", "save")
+	if !output.isErrorString("ERR Command not allowed inside a transaction") {
+		t.Fatal("shutdown in multi fail")
+	}
+	ts.ProcessCommand("discard")
+
+	// outside of a transaction, the save is made
+	output = ts.ProcessCommand("save")
+	if !output.isString("OK") || tc.dss.changesSinceSave() != 0 {
+		t.Fatal("save after multi fail")
+	}
+}
+
+func TestBgSave(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	tc, isTestClient := ts.(*testClient)
+	if !isTestClient {
+		t.Skip("persistence state is internal")
+	}
+
+	output := ts.ProcessCommand("bgsave")
+	if !output.isString("Background saving started") {
+		t.Fatal("bgsave fail")
+	}
+
+	for i := 0; i < 100 && tc.dss.persistenceStats().bgSaveInProgress; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := tc.dss.persistenceStats()
+	if stats.bgSaveInProgress || stats.lastBgSaveSec != 0 || stats.lastBgSaveFailed {
+		t.Fatal("bgsave completion fail")
+	}
+
+	// simulate a background save that is still running
+	tc.dss.mu.Lock()
+	tc.dss.persist.bgSaveInProgress = true
+	tc.dss.mu.Unlock()
+
+	output = ts.ProcessCommand("bgsave")
+	if !output.isErrorString("ERR Background save already in progress") {
+		t.Fatal("bgsave busy fail")
+	}
+
+	output = ts.ProcessCommand("save")
+	if !output.isErrorString("ERR Background save already in progress") {
+		t.Fatal("save busy fail")
+	}
+
+	output = ts.ProcessCommand("bgsave", "schedule")
+	if !output.isString("Background saving scheduled") {
+		t.Fatal("bgsave schedule fail")
+	}
+	if !tc.dss.persistenceStats().bgSaveScheduled {
+		t.Fatal("bgsave not scheduled")
+	}
+
+	output = ts.ProcessCommand("info", "persistence")
+	if !strings.Contains(output.String(), "rdb_bgsave_in_progress:1\r\n") {
+		t.Fatal("info bgsave in progress fail")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	tc, isTestClient := ts.(*testClient)
+	if !isTestClient {
+		t.Skip("can't shut down a real server")
+	}
+
+	output := ts.ProcessCommand("shutdown", "abort")
+	if !output.isErrorString("ERR No shutdown in progress.") {
+		t.Fatal("shutdown abort fail")
+	}
+
+	shutdowns := 0
+	tc.dss.shutdown = func() { shutdowns++ }
+
+	// the default save rules cause a final save
+	before := tc.dss.persistenceStats().saves
+	ts.ProcessCommand("shutdown")
+	if shutdowns != 1 || tc.dss.persistenceStats().saves != before+1 || !tc.cs.noReply {
+		t.Fatal("shutdown fail")
+	}
+
+	ts.ProcessCommand("shutdown", "nosave", "now")
+	if shutdowns != 2 || tc.dss.persistenceStats().saves != before+1 {
+		t.Fatal("shutdown nosave fail")
+	}
+}
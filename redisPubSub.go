@@ -0,0 +1,440 @@
+package redisemu
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// pubsubRegistry holds the subscriptions of the clients of an emulator.
+	// The tables are shared by the databases, because (like redis) channels
+	// are not associated with a database. Shard channels are a separate
+	// namespace from classic channels. All subscription state, including
+	// clientState.channels, clientState.patterns and
+	// clientState.shardChannels, is protected by mu.
+	pubsubRegistry struct {
+		mu            sync.Mutex
+		channels      map[string]map[*clientState]struct{}
+		patterns      map[string]map[*clientState]struct{}
+		shardChannels map[string]map[*clientState]struct{}
+	}
+)
+
+func newPubsubRegistry() pubsubRegistry {
+	return pubsubRegistry{
+		channels:      map[string]map[*clientState]struct{}{},
+		patterns:      map[string]map[*clientState]struct{}{},
+		shardChannels: map[string]map[*clientState]struct{}{},
+	}
+}
+
+// the commands a RESP2 client can issue while it has subscriptions
+var subscribedCmdTable = map[string]bool{
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+	"reset":        true,
+	"ssubscribe":   true,
+	"subscribe":    true,
+	"sunsubscribe": true,
+	"unsubscribe":  true,
+}
+
+func fnSubscribe(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	names := argStrings(args["channel"])
+
+	ctx.cs.dss.pubsub.mu.Lock()
+	replies := ctx.cs.subscribeUnlocked("subscribe", ctx.cs.dss.pubsub.channels, ctx.cs.channels, names, ctx.cs.subscriptionCountUnlocked)
+	ctx.cs.dss.pubsub.mu.Unlock()
+
+	output = ctx.cs.subscriptionReplies(replies)
+	return
+}
+
+func fnPSubscribe(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	names := argStrings(args["pattern"])
+
+	ctx.cs.dss.pubsub.mu.Lock()
+	replies := ctx.cs.subscribeUnlocked("psubscribe", ctx.cs.dss.pubsub.patterns, ctx.cs.patterns, names, ctx.cs.subscriptionCountUnlocked)
+	ctx.cs.dss.pubsub.mu.Unlock()
+
+	output = ctx.cs.subscriptionReplies(replies)
+	return
+}
+
+func fnUnsubscribe(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	names := argStrings(args["channel"])
+
+	ctx.cs.dss.pubsub.mu.Lock()
+	replies := ctx.cs.unsubscribeUnlocked("unsubscribe", ctx.cs.dss.pubsub.channels, ctx.cs.channels, names, ctx.cs.subscriptionCountUnlocked)
+	ctx.cs.dss.pubsub.mu.Unlock()
+
+	output = ctx.cs.subscriptionReplies(replies)
+	return
+}
+
+func fnPUnsubscribe(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	names := argStrings(args["pattern"])
+
+	ctx.cs.dss.pubsub.mu.Lock()
+	replies := ctx.cs.unsubscribeUnlocked("punsubscribe", ctx.cs.dss.pubsub.patterns, ctx.cs.patterns, names, ctx.cs.subscriptionCountUnlocked)
+	ctx.cs.dss.pubsub.mu.Unlock()
+
+	output = ctx.cs.subscriptionReplies(replies)
+	return
+}
+
+func fnSSubscribe(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	names, errText := shardChannelNames(ctx)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	ctx.cs.dss.pubsub.mu.Lock()
+	replies := ctx.cs.subscribeUnlocked("ssubscribe", ctx.cs.dss.pubsub.shardChannels, ctx.cs.shardChannels, names, ctx.cs.shardSubscriptionCountUnlocked)
+	ctx.cs.dss.pubsub.mu.Unlock()
+
+	output = ctx.cs.subscriptionReplies(replies)
+	return
+}
+
+func fnSUnsubscribe(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	names, errText := shardChannelNames(ctx)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	ctx.cs.dss.pubsub.mu.Lock()
+	replies := ctx.cs.unsubscribeUnlocked("sunsubscribe", ctx.cs.dss.pubsub.shardChannels, ctx.cs.shardChannels, names, ctx.cs.shardSubscriptionCountUnlocked)
+	ctx.cs.dss.pubsub.mu.Unlock()
+
+	output = ctx.cs.subscriptionReplies(replies)
+	return
+}
+
+func fnPublish(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	channel := args["channel"].(string)
+	message := args["message"].(string)
+
+	output.data = respInt(ctx.cs.dss.publishMessage(channel, message))
+	return
+}
+
+func fnSPublish(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	names, errText := shardChannelNames(ctx)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+	message := args["message"].(string)
+
+	output.data = respInt(ctx.cs.dss.publishShardMessage(names[0], message))
+	return
+}
+
+func fnPubSubChannels(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	pattern, _ := args["pattern"].(string)
+
+	ctx.cs.dss.pubsub.mu.Lock()
+	names := registryNames(ctx.cs.dss.pubsub.channels, pattern)
+	ctx.cs.dss.pubsub.mu.Unlock()
+
+	output.data = nativeStringArrayToResp(names)
+	return
+}
+
+func fnPubSubNumSub(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	names := argStrings(args["channel"])
+
+	ctx.cs.dss.pubsub.mu.Lock()
+	defer ctx.cs.dss.pubsub.mu.Unlock()
+
+	output.data = registryCounts(ctx.cs.dss.pubsub.channels, names)
+	return
+}
+
+func fnPubSubNumPat(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	ctx.cs.dss.pubsub.mu.Lock()
+	defer ctx.cs.dss.pubsub.mu.Unlock()
+
+	output.data = respInt(len(ctx.cs.dss.pubsub.patterns))
+	return
+}
+
+func fnPubSubShardChannels(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	pattern, _ := args["pattern"].(string)
+
+	ctx.cs.dss.pubsub.mu.Lock()
+	names := registryNames(ctx.cs.dss.pubsub.shardChannels, pattern)
+	ctx.cs.dss.pubsub.mu.Unlock()
+
+	output.data = nativeStringArrayToResp(names)
+	return
+}
+
+func fnPubSubShardNumSub(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	names := argStrings(args["shardchannel"])
+
+	ctx.cs.dss.pubsub.mu.Lock()
+	defer ctx.cs.dss.pubsub.mu.Unlock()
+
+	output.data = registryCounts(ctx.cs.dss.pubsub.shardChannels, names)
+	return
+}
+
+// provides the pub/sub counts of INFO
+func (dss *dataStoreSet) pubsubCounts() (channels, patterns, shardChannels int) {
+	dss.pubsub.mu.Lock()
+	defer dss.pubsub.mu.Unlock()
+
+	return len(dss.pubsub.channels), len(dss.pubsub.patterns), len(dss.pubsub.shardChannels)
+}
+
+// Shard channels are found with the key specs, the same way as COMMAND GETKEYS,
+// because in a cluster the channel name determines the slot.
+func shardChannelNames(ctx *cmdContext) (names []string, errText respErrorString) {
+	names, _, errText = ctx.cd.getKeysFromSpecs(ctx.rawArgs)
+	return
+}
+
+// converts an optional, multiple string argument to a string slice
+func argStrings(arg any) (strs []string) {
+	values, _ := arg.([]any)
+	strs = make([]string, 0, len(values))
+	for _, value := range values {
+		strs = append(strs, value.(string))
+	}
+	return
+}
+
+// makes a pub/sub message; it is a push for RESP3, and an array for RESP2
+func pubsubMessage(kind string, data ...respValue) respValue {
+	return respValue{data: respPush{kind: kind, data: data}}
+}
+
+// makes a subscribe or unsubscribe confirmation message; a nil name is
+// sent as a null
+func subscriptionMessage(kind string, name *string, count int) respValue {
+	nameValue := respValue{}
+	if name != nil {
+		nameValue.data = respBulkString(*name)
+	}
+	return pubsubMessage(kind, nameValue, respValue{data: respInt(count)})
+}
+
+// sends a pub/sub message to the client, in the form of its protocol version
+func (cs *clientState) sendMessage(msg respValue) {
+	if cs.respVersion < 3 {
+		msg = resp3To2(msg)
+	}
+	cs.client.SendOutOfBand(msg)
+}
+
+// A (un)subscribe command makes a confirmation for each channel, but a
+// command has a single response. The confirmations before the last are
+// sent out-of-band, and the last one is the command response.
+func (cs *clientState) subscriptionReplies(replies []respValue) (output respValue) {
+	for _, reply := range replies[:len(replies)-1] {
+		cs.sendMessage(reply)
+	}
+	output = replies[len(replies)-1]
+	return
+}
+
+// the number of channels and patterns the client is subscribed to
+func (cs *clientState) subscriptionCountUnlocked() int {
+	return len(cs.channels) + len(cs.patterns)
+}
+
+// the number of shard channels the client is subscribed to, which is
+// counted separately from the other subscriptions
+func (cs *clientState) shardSubscriptionCountUnlocked() int {
+	return len(cs.shardChannels)
+}
+
+func (cs *clientState) isSubscribed() bool {
+	cs.dss.pubsub.mu.Lock()
+	defer cs.dss.pubsub.mu.Unlock()
+
+	return cs.subscriptionCountUnlocked()+cs.shardSubscriptionCountUnlocked() > 0
+}
+
+func (cs *clientState) isSubscribedTo(channel string) bool {
+	cs.dss.pubsub.mu.Lock()
+	defer cs.dss.pubsub.mu.Unlock()
+
+	_, exists := cs.channels[channel]
+	return exists
+}
+
+// provides the number of each kind of subscription for CLIENT LIST
+func (cs *clientState) subscriptionCounts() (channels, patterns, shardChannels int) {
+	cs.dss.pubsub.mu.Lock()
+	defer cs.dss.pubsub.mu.Unlock()
+
+	return len(cs.channels), len(cs.patterns), len(cs.shardChannels)
+}
+
+// provides the client type of CLIENT LIST and CLIENT KILL filters
+func (cs *clientState) clientType() string {
+	if cs.isSubscribed() {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// adds the client to the registry entry of each name, and makes the
+// confirmation messages with the subscription count of the namespace
+func (cs *clientState) subscribeUnlocked(kind string, registry map[string]map[*clientState]struct{}, subscriptions map[string]struct{}, names []string, count func() int) (replies []respValue) {
+	replies = make([]respValue, 0, len(names))
+
+	for _, name := range names {
+		if _, exists := subscriptions[name]; !exists {
+			subscriptions[name] = struct{}{}
+
+			subscribers, exists := registry[name]
+			if !exists {
+				subscribers = map[*clientState]struct{}{}
+				registry[name] = subscribers
+			}
+			subscribers[cs] = struct{}{}
+		}
+
+		replies = append(replies, subscriptionMessage(kind, &name, count()))
+	}
+	return
+}
+
+// removes the client from the registry entry of each name, or from all of its
+// subscriptions when names is empty, and makes the confirmation messages
+func (cs *clientState) unsubscribeUnlocked(kind string, registry map[string]map[*clientState]struct{}, subscriptions map[string]struct{}, names []string, count func() int) (replies []respValue) {
+	if len(names) == 0 {
+		for name := range subscriptions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		if len(names) == 0 {
+			replies = append(replies, subscriptionMessage(kind, nil, count()))
+			return
+		}
+	}
+
+	replies = make([]respValue, 0, len(names))
+
+	for _, name := range names {
+		if _, exists := subscriptions[name]; exists {
+			delete(subscriptions, name)
+
+			subscribers := registry[name]
+			delete(subscribers, cs)
+			if len(subscribers) == 0 {
+				delete(registry, name)
+			}
+		}
+
+		replies = append(replies, subscriptionMessage(kind, &name, count()))
+	}
+	return
+}
+
+// drops all subscriptions without confirmation, for RESET and a departing client
+func (cs *clientState) unsubscribeAll() {
+	cs.dss.pubsub.mu.Lock()
+	defer cs.dss.pubsub.mu.Unlock()
+
+	cs.unsubscribeUnlocked("unsubscribe", cs.dss.pubsub.channels, cs.channels, nil, cs.subscriptionCountUnlocked)
+	cs.unsubscribeUnlocked("punsubscribe", cs.dss.pubsub.patterns, cs.patterns, nil, cs.subscriptionCountUnlocked)
+	cs.unsubscribeUnlocked("sunsubscribe", cs.dss.pubsub.shardChannels, cs.shardChannels, nil, cs.shardSubscriptionCountUnlocked)
+}
+
+// delivers a message to the subscribers of the channel and of the matching
+// patterns, returning the number of deliveries
+func (dss *dataStoreSet) publishMessage(channel, message string) int {
+	type delivery struct {
+		cs  *clientState
+		msg respValue
+	}
+
+	channelValue := respValue{data: respBulkString(channel)}
+	messageValue := respValue{data: respBulkString(message)}
+
+	dss.pubsub.mu.Lock()
+
+	deliveries := []delivery{}
+	for cs := range dss.pubsub.channels[channel] {
+		deliveries = append(deliveries, delivery{cs, pubsubMessage("message", channelValue, messageValue)})
+	}
+
+	channelRunes := []rune(channel)
+	for pattern, subscribers := range dss.pubsub.patterns {
+		if !redisGlob([]rune(pattern), channelRunes) {
+			continue
+		}
+
+		patternValue := respValue{data: respBulkString(pattern)}
+		for cs := range subscribers {
+			deliveries = append(deliveries, delivery{cs, pubsubMessage("pmessage", patternValue, channelValue, messageValue)})
+		}
+	}
+
+	dss.pubsub.mu.Unlock()
+
+	// the socket writes are made outside of the lock, so that a slow
+	// subscriber doesn't hold up the others
+	for _, d := range deliveries {
+		d.cs.sendMessage(d.msg)
+	}
+	return len(deliveries)
+}
+
+// delivers a message to the subscribers of the shard channel, returning the
+// number of deliveries
+func (dss *dataStoreSet) publishShardMessage(channel, message string) int {
+	msg := pubsubMessage("smessage", respValue{data: respBulkString(channel)}, respValue{data: respBulkString(message)})
+
+	dss.pubsub.mu.Lock()
+	subscribers := make([]*clientState, 0, len(dss.pubsub.shardChannels[channel]))
+	for cs := range dss.pubsub.shardChannels[channel] {
+		subscribers = append(subscribers, cs)
+	}
+	dss.pubsub.mu.Unlock()
+
+	for _, cs := range subscribers {
+		cs.sendMessage(msg)
+	}
+	return len(subscribers)
+}
+
+// lists the names in a registry, optionally filtered by a glob pattern
+func registryNames(registry map[string]map[*clientState]struct{}, pattern string) (names []string) {
+	pat := []rune(pattern)
+
+	names = make([]string, 0, len(registry))
+	for name := range registry {
+		if pattern == "" || redisGlob(pat, []rune(name)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// provides the subscriber count of each name in a registry
+func registryCounts(registry map[string]map[*clientState]struct{}, names []string) respMap {
+	m := newRespMapSized(len(names))
+	for _, name := range names {
+		m.set(respValue{data: respBulkString(name)}, respValue{data: respInt(len(registry[name]))})
+	}
+	return m
+}
+
+// the error for a command that isn't allowed while a RESP2 client has subscriptions
+func subscribedCmdError(cmdName string) respErrorString {
+	return respErrorString(fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(cmdName)))
+}
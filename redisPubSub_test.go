@@ -0,0 +1,391 @@
+package redisemu
+
+import (
+	"strings"
+	"testing"
+)
+
+// checks that a pub/sub message has the kind and data, in either RESP2 or RESP3 form
+func isPubSubMessage(msg respValue, kind string, data ...any) bool {
+	if p, valid := msg.data.(respPush); valid {
+		a := make(respArray, 0, len(p.data)+1)
+		a = append(a, respValue{data: respBulkString(p.kind)})
+		a = append(a, p.data...)
+		msg = respValue{data: a}
+	}
+
+	expected := make([]any, 0, len(data)+1)
+	expected = append(expected, kind)
+	expected = append(expected, data...)
+	return msg.isArray(expected...)
+}
+
+func TestPubSubSubscribe(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	// all but the last confirmation are sent out-of-band
+	output := ts.ProcessCommand("subscribe", "ch1", "ch2")
+	if !isPubSubMessage(output, "subscribe", "ch2", 2) {
+		t.Fatal("subscribe fail")
+	}
+
+	pushes := ts.PendingPushes()
+	if len(pushes) != 1 || !isPubSubMessage(pushes[0], "subscribe", "ch1", 1) {
+		t.Fatal("subscribe first confirmation fail")
+	}
+
+	output = ts2.ProcessCommand("publish", "ch1", "hello")
+	if !output.isInt(1) {
+		t.Fatal("publish ch1 fail")
+	}
+
+	output = ts2.ProcessCommand("publish", "other", "hello")
+	if !output.isInt(0) {
+		t.Fatal("publish other fail")
+	}
+
+	pushes = ts.PendingPushes()
+	if len(pushes) != 1 || !isPubSubMessage(pushes[0], "message", "ch1", "hello") {
+		t.Fatal("subscribe message fail")
+	}
+
+	// a RESP3 client can issue other commands while subscribed
+	output = ts.ProcessCommand("set", "k", "v")
+	if !output.isString("OK") {
+		t.Fatal("subscribed resp3 set fail")
+	}
+
+	output = ts.ProcessCommand("unsubscribe", "ch1")
+	if !isPubSubMessage(output, "unsubscribe", "ch1", 1) {
+		t.Fatal("unsubscribe ch1 fail")
+	}
+
+	output = ts2.ProcessCommand("publish", "ch1", "hello")
+	if !output.isInt(0) {
+		t.Fatal("publish after unsubscribe fail")
+	}
+
+	output = ts.ProcessCommand("unsubscribe")
+	if !isPubSubMessage(output, "unsubscribe", "ch2", 0) {
+		t.Fatal("unsubscribe all fail")
+	}
+
+	output = ts.ProcessCommand("unsubscribe")
+	if !isPubSubMessage(output, "unsubscribe", nil, 0) {
+		t.Fatal("unsubscribe none fail")
+	}
+
+	if len(ts.PendingPushes()) != 0 {
+		t.Fatal("unsubscribe pushes fail")
+	}
+}
+
+func TestPubSubPatterns(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	output := ts.ProcessCommand("psubscribe", "news.*")
+	if !isPubSubMessage(output, "psubscribe", "news.*", 1) {
+		t.Fatal("psubscribe fail")
+	}
+
+	output = ts.ProcessCommand("subscribe", "news.art")
+	if !isPubSubMessage(output, "subscribe", "news.art", 2) {
+		t.Fatal("psubscribe subscribe fail")
+	}
+
+	// the client receives the message once per matching subscription
+	output = ts2.ProcessCommand("publish", "news.art", "painting")
+	if !output.isInt(2) {
+		t.Fatal("publish news.art fail")
+	}
+
+	pushes := ts.PendingPushes()
+	if len(pushes) != 2 ||
+		!isPubSubMessage(pushes[0], "message", "news.art", "painting") ||
+		!isPubSubMessage(pushes[1], "pmessage", "news.*", "news.art", "painting") {
+		t.Fatal("psubscribe messages fail")
+	}
+
+	output = ts2.ProcessCommand("publish", "sports", "score")
+	if !output.isInt(0) {
+		t.Fatal("publish sports fail")
+	}
+
+	output = ts.ProcessCommand("punsubscribe", "news.*")
+	if !isPubSubMessage(output, "punsubscribe", "news.*", 1) {
+		t.Fatal("punsubscribe fail")
+	}
+
+	output = ts2.ProcessCommand("publish", "news.tech", "chips")
+	if !output.isInt(0) {
+		t.Fatal("publish after punsubscribe fail")
+	}
+
+	output = ts.ProcessCommand("punsubscribe")
+	if !isPubSubMessage(output, "punsubscribe", nil, 1) {
+		t.Fatal("punsubscribe none fail")
+	}
+}
+
+func TestPubSubResp2(t *testing.T) {
+	ts := NewRedisTestClientResp2(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	output := ts.ProcessCommand("subscribe", "ch")
+	if !output.isArray("subscribe", "ch", 1) {
+		t.Fatal("resp2 subscribe fail")
+	}
+
+	output = ts2.ProcessCommand("publish", "ch", "hello")
+	if !output.isInt(1) {
+		t.Fatal("resp2 publish fail")
+	}
+
+	pushes := ts.PendingPushes()
+	if len(pushes) != 1 || !pushes[0].isArray("message", "ch", "hello") {
+		t.Fatal("resp2 message fail")
+	}
+
+	// subscribed mode allows only subscription commands
+	output = ts.ProcessCommand("get", "k")
+	if !output.isErrorString("ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context") {
+		t.Fatal("resp2 subscribed get fail")
+	}
+
+	output = ts.ProcessCommand("ping")
+	if !output.isArray("pong", "") {
+		t.Fatal("resp2 subscribed ping fail")
+	}
+
+	output = ts.ProcessCommand("ping", "hi")
+	if !output.isArray("pong", "hi") {
+		t.Fatal("resp2 subscribed ping message fail")
+	}
+
+	output = ts.ProcessCommand("unsubscribe", "ch")
+	if !output.isArray("unsubscribe", "ch", 0) {
+		t.Fatal("resp2 unsubscribe fail")
+	}
+
+	output = ts.ProcessCommand("get", "k")
+	if !output.isNull() {
+		t.Fatal("resp2 unsubscribed get fail")
+	}
+
+	// RESET leaves subscribed mode
+	ts.ProcessCommand("psubscribe", "*")
+
+	output = ts.ProcessCommand("reset")
+	if !output.isString("RESET") {
+		t.Fatal("resp2 reset fail")
+	}
+
+	output = ts2.ProcessCommand("publish", "ch", "hello")
+	if !output.isInt(0) {
+		t.Fatal("resp2 publish after reset fail")
+	}
+
+	output = ts.ProcessCommand("ping")
+	if !output.isString("PONG") {
+		t.Fatal("resp2 ping after reset fail")
+	}
+}
+
+func TestPubSubIntrospection(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+
+	ts.ProcessCommand("subscribe", "news.art", "news.tech", "sports")
+	ts2.ProcessCommand("subscribe", "sports")
+	ts2.ProcessCommand("psubscribe", "news.*", "x*")
+
+	output := ts.ProcessCommand("pubsub", "channels")
+	if !output.isArray("news.art", "news.tech", "sports") {
+		t.Fatal("pubsub channels fail")
+	}
+
+	output = ts.ProcessCommand("pubsub", "channels", "news.*")
+	if !output.isArray("news.art", "news.tech") {
+		t.Fatal("pubsub channels pattern fail")
+	}
+
+	output = ts.ProcessCommand("pubsub", "numsub", "sports", "news.art", "missing")
+	if !output.isMap(map[any]any{"sports": 2, "news.art": 1, "missing": 0}) {
+		t.Fatal("pubsub numsub fail")
+	}
+
+	output = ts.ProcessCommand("pubsub", "numpat")
+	if !output.isInt(2) {
+		t.Fatal("pubsub numpat fail")
+	}
+
+	output = ts.ProcessCommand("client", "list", "id", ts2.ClientID())
+	list, _ := output.toString()
+	if !strings.Contains(list, " sub=1 psub=2 ") || !strings.Contains(list, "flags=P ") {
+		t.Fatal("pubsub client list fail")
+	}
+
+	output = ts.ProcessCommand("client", "list", "type", "pubsub")
+	list, _ = output.toString()
+	if strings.Count(list, "\n") != 2 {
+		t.Fatal("pubsub client list type fail")
+	}
+
+	// a departing client's subscriptions are removed
+	ts2.Close()
+
+	output = ts.ProcessCommand("pubsub", "numsub", "sports")
+	if !output.isMap(map[any]any{"sports": 1}) {
+		t.Fatal("pubsub numsub after close fail")
+	}
+
+	output = ts.ProcessCommand("pubsub", "numpat")
+	if !output.isInt(0) {
+		t.Fatal("pubsub numpat after close fail")
+	}
+}
+
+func TestPubSubTrackingRedirect(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	// a RESP2 redirect target receives invalidations through pub/sub
+	output := ts2.ProcessCommand("subscribe", "__redis__:invalidate")
+	if !output.isArray("subscribe", "__redis__:invalidate", 1) {
+		t.Fatal("tracking redirect subscribe fail")
+	}
+
+	output = ts.ProcessCommand("client", "tracking", "on", "redirect", ts2.ClientID())
+	if !output.isString("OK") {
+		t.Fatal("tracking redirect on fail")
+	}
+
+	ts.ProcessCommand("get", "k")
+	ts.ProcessCommand("set", "k", "v")
+
+	pushes := ts2.PendingPushes()
+	if len(pushes) != 1 || !pushes[0].isArray("message", "__redis__:invalidate", []any{"k"}) {
+		t.Fatal("tracking redirect message fail")
+	}
+}
+
+func TestPubSubSharded(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient() // an additional client starts with RESP2
+	defer ts2.Close()
+
+	output := ts.ProcessCommand("ssubscribe", "s1", "s2")
+	if !isPubSubMessage(output, "ssubscribe", "s2", 2) {
+		t.Fatal("ssubscribe fail")
+	}
+
+	pushes := ts.PendingPushes()
+	if len(pushes) != 1 || !isPubSubMessage(pushes[0], "ssubscribe", "s1", 1) {
+		t.Fatal("ssubscribe first confirmation fail")
+	}
+
+	// shard subscriptions are counted apart from the classic ones
+	output = ts.ProcessCommand("subscribe", "s1")
+	if !isPubSubMessage(output, "subscribe", "s1", 1) {
+		t.Fatal("ssubscribe classic subscribe fail")
+	}
+
+	output = ts2.ProcessCommand("ssubscribe", "s1")
+	if !output.isArray("ssubscribe", "s1", 1) {
+		t.Fatal("resp2 ssubscribe fail")
+	}
+
+	output = ts2.ProcessCommand("get", "k")
+	if !output.isErrorType() {
+		t.Fatal("resp2 ssubscribed get fail")
+	}
+
+	// the namespaces are separate
+	output = ts.ProcessCommand("spublish", "s1", "hello")
+	if !output.isInt(2) {
+		t.Fatal("spublish fail")
+	}
+
+	pushes = ts.PendingPushes()
+	if len(pushes) != 1 || !isPubSubMessage(pushes[0], "smessage", "s1", "hello") {
+		t.Fatal("spublish resp3 message fail")
+	}
+
+	pushes = ts2.PendingPushes()
+	if len(pushes) != 1 || !pushes[0].isArray("smessage", "s1", "hello") {
+		t.Fatal("spublish resp2 message fail")
+	}
+
+	output = ts.ProcessCommand("publish", "s2", "hello")
+	if !output.isInt(0) {
+		t.Fatal("publish to shard channel fail")
+	}
+
+	output = ts.ProcessCommand("pubsub", "shardchannels")
+	if !output.isArray("s1", "s2") {
+		t.Fatal("pubsub shardchannels fail")
+	}
+
+	output = ts.ProcessCommand("pubsub", "shardchannels", "*2")
+	if !output.isArray("s2") {
+		t.Fatal("pubsub shardchannels pattern fail")
+	}
+
+	output = ts.ProcessCommand("pubsub", "channels")
+	if !output.isArray("s1") {
+		t.Fatal("pubsub channels excludes shard channels fail")
+	}
+
+	output = ts.ProcessCommand("pubsub", "shardnumsub", "s1", "s2", "s3")
+	if !output.isMap(map[any]any{"s1": 2, "s2": 1, "s3": 0}) {
+		t.Fatal("pubsub shardnumsub fail")
+	}
+
+	output = ts.ProcessCommand("client", "list", "id", ts.ClientID())
+	list, _ := output.toString()
+	if !strings.Contains(list, " sub=1 psub=0 ssub=2 ") {
+		t.Fatal("sharded client list fail")
+	}
+
+	output = ts.ProcessCommand("command", "getkeys", "ssubscribe", "s1", "s2")
+	if !output.isArray("s1", "s2") {
+		t.Fatal("ssubscribe getkeys fail")
+	}
+
+	output = ts.ProcessCommand("sunsubscribe", "s1")
+	if !isPubSubMessage(output, "sunsubscribe", "s1", 1) {
+		t.Fatal("sunsubscribe fail")
+	}
+
+	output = ts.ProcessCommand("sunsubscribe")
+	if !isPubSubMessage(output, "sunsubscribe", "s2", 0) {
+		t.Fatal("sunsubscribe all fail")
+	}
+
+	output = ts.ProcessCommand("sunsubscribe")
+	if !isPubSubMessage(output, "sunsubscribe", nil, 0) {
+		t.Fatal("sunsubscribe none fail")
+	}
+
+	output = ts2.ProcessCommand("sunsubscribe")
+	if !output.isArray("sunsubscribe", "s1", 0) {
+		t.Fatal("resp2 sunsubscribe fail")
+	}
+
+	output = ts2.ProcessCommand("get", "k")
+	if !output.isNull() {
+		t.Fatal("resp2 sunsubscribed get fail")
+	}
+}
@@ -0,0 +1,263 @@
+package redisemu
+
+import (
+	"context"
+	"strings"
+)
+
+var errNoScript = respErrorString("NOSCRIPT No matching script. Please use EVAL.")
+var errScriptKilled = respErrorString("ERR Script killed by user with SCRIPT KILL...")
+
+type (
+	// scriptRun is a script in execution, which SCRIPT KILL can stop until
+	// the script calls a write command
+	scriptRun struct {
+		cancel context.CancelFunc
+		killed bool
+		wrote  bool
+	}
+)
+
+func fnEval(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	script, errText := ctx.cs.dss.loadScript(args["script"].(string))
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = evalScript(ctx, args, script, ctx.cmdToken == "eval_ro")
+	return
+}
+
+func fnEvalSha(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	script := ctx.cs.dss.getScript(args["sha1"].(string))
+	if script == nil {
+		output.data = errNoScript
+		return
+	}
+
+	output = evalScript(ctx, args, script, ctx.cmdToken == "evalsha_ro")
+	return
+}
+
+func evalScript(ctx *cmdContext, args map[string]any, script *luaScript, readOnly bool) (output respValue) {
+	keys, argv, errText := scriptParams(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = runScript(ctx, func() respValue {
+		return runLuaScript(ctx, script, keys, argv, readOnly)
+	})
+	return
+}
+
+// splits the keys from the args of EVAL or FCALL; the parser can't tell where
+// the keys end
+func scriptParams(args map[string]any) (keys, argv []string, errText respErrorString) {
+	numKeys := int(args["numkeys"].(int64))
+	params := append(argStrings(args["key"]), argStrings(args["arg"])...)
+	if numKeys < 0 {
+		errText = "ERR Number of keys can't be negative"
+		return
+	}
+	if numKeys > len(params) {
+		errText = rstrNumKeysGreater
+		return
+	}
+
+	keys = params[:numKeys]
+	argv = params[numKeys:]
+	return
+}
+
+// runs a script or function atomically
+func runScript(ctx *cmdContext, run func() respValue) respValue {
+	// within EXEC, the transaction already owns the data store; otherwise,
+	// because the commands of a script can't be known in advance, the lock
+	// for locking other data stores is taken first, as EXEC does; it makes
+	// scripts run one at a time, even in different databases
+	if !ctx.multi {
+		ctx.dsc.acquireMultiExclusive()
+		defer ctx.dsc.releaseMultiExclusive()
+		ctx.dsc.acquireExclusive()
+		defer ctx.dsc.releaseExclusive()
+	}
+
+	// a SELECT in the script doesn't change the database of the caller
+	cs := ctx.cs
+	selectedDb, ds := cs.selectedDb, cs.ds
+	defer func() {
+		cs.selectedDb, cs.ds = selectedDb, ds
+	}()
+
+	// the monitors see the script before the commands that it calls
+	feedMonitors(ctx)
+
+	return run()
+}
+
+// runs a command of a script or function with the client state of the caller
+func dispatchFromScript(scriptCtx *cmdContext, args respArray, readOnly bool, respVersion int) (output respValue) {
+	cd := scriptCtx.cd
+	cs := scriptCtx.cs
+
+	ctx, response := cd.newCmdContext(cs, respValue{data: args})
+	if response != nil {
+		if errText, isError := response.(respErrorString); isError {
+			cd.dss.recordRejectedCall(cd.rejectedCmdToken(respValue{data: args}), errText, true)
+		}
+		output.data = response
+		return
+	}
+
+	var errText respErrorString
+	if cd.hasFlag(ctx.cmdToken, "noscript") {
+		errText = "ERR This Redis command is not allowed from script"
+	} else if readOnly && cd.hasFlag(ctx.cmdToken, "write") {
+		errText = "ERR Write commands are not allowed from read-only scripts."
+	}
+	if errText != "" {
+		cd.dss.recordRejectedCall(ctx.cmdToken, errText, true)
+		output.data = errText
+		return
+	}
+
+	// like the commands of a transaction, use the id of the script command so
+	// that the command won't try to acquire the lock that the script owns,
+	// and don't allow blocking
+	ctx.multi = true
+	ctx.script = true
+	ctx.dsc.id = scriptCtx.dsc.id
+
+	if cd.hasFlag(ctx.cmdToken, "write") {
+		cd.dss.scriptWrote(scriptCtx)
+	}
+
+	// the reply is made in the protocol version of the script
+	clientRespVersion := cs.respVersion
+	cs.respVersion = respVersion
+	output = cd.dispatchHandler(ctx)
+	cs.respVersion = clientRespVersion
+	return
+}
+
+func fnScriptLoad(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	script, errText := ctx.cs.dss.loadScript(args["script"].(string))
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output.data = respBulkString(script.sha)
+	return
+}
+
+func fnScriptExists(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	shas := argStrings(args["sha1"])
+
+	a := make(respArray, 0, len(shas))
+	for _, sha := range shas {
+		exists := 0
+		if ctx.cs.dss.getScript(sha) != nil {
+			exists = 1
+		}
+		a = append(a, respValue{data: respInt(exists)})
+	}
+	output.data = a
+	return
+}
+
+func fnScriptFlush(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	ctx.cs.dss.flushScripts()
+	output.data = rstrOK
+	return
+}
+
+func fnScriptKill(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	if errText := ctx.cs.dss.killScripts(); errText != "" {
+		output.data = errText
+		return
+	}
+
+	output.data = rstrOK
+	return
+}
+
+// records a script in execution; cancel stops the lua state of the script
+func (dss *dataStoreSet) startScript(scriptCtx *cmdContext, cancel context.CancelFunc) {
+	dss.mu.Lock()
+	defer dss.mu.Unlock()
+	dss.running[scriptCtx] = &scriptRun{cancel: cancel}
+}
+
+// removes a script that finished, indicating if SCRIPT KILL stopped it
+func (dss *dataStoreSet) endScript(scriptCtx *cmdContext) (killed bool) {
+	dss.mu.Lock()
+	defer dss.mu.Unlock()
+	killed = dss.running[scriptCtx].killed
+	delete(dss.running, scriptCtx)
+	return
+}
+
+// marks a script as having called a write command, after which it can't be
+// killed; functions aren't recorded, so they are unaffected
+func (dss *dataStoreSet) scriptWrote(scriptCtx *cmdContext) {
+	dss.mu.Lock()
+	defer dss.mu.Unlock()
+	if run, exists := dss.running[scriptCtx]; exists {
+		run.wrote = true
+	}
+}
+
+// stops the script in execution, unless it has written; as in redis, only
+// one script runs at a time, because a script holds the multi data store
+// lock, which serializes scripts of all databases
+func (dss *dataStoreSet) killScripts() respErrorString {
+	dss.mu.Lock()
+	defer dss.mu.Unlock()
+
+	if len(dss.running) == 0 {
+		return "NOTBUSY No scripts in execution right now."
+	}
+	for _, run := range dss.running {
+		if run.wrote {
+			return "UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command."
+		}
+	}
+
+	for _, run := range dss.running {
+		run.killed = true
+		run.cancel()
+	}
+	return ""
+}
+
+// gets a script from the cache, compiling and caching it if necessary
+func (dss *dataStoreSet) loadScript(body string) (script *luaScript, errText respErrorString) {
+	if script = dss.getScript(sha1Hex(body)); script != nil {
+		return
+	}
+
+	if script, errText = compileLuaScript(body); errText != "" {
+		return
+	}
+
+	dss.mu.Lock()
+	defer dss.mu.Unlock()
+	dss.scripts[script.sha] = script
+	return
+}
+
+func (dss *dataStoreSet) getScript(sha string) *luaScript {
+	dss.mu.Lock()
+	defer dss.mu.Unlock()
+	return dss.scripts[strings.ToLower(sha)]
+}
+
+func (dss *dataStoreSet) flushScripts() {
+	dss.mu.Lock()
+	defer dss.mu.Unlock()
+	dss.scripts = map[string]*luaScript{}
+}
@@ -0,0 +1,363 @@
+package redisemu
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvalConversions(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("eval", "return 42", "0")
+	if !output.isInt(42) {
+		t.Fatal("eval number fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return 3.99", "0")
+	if !output.isInt(3) {
+		t.Fatal("eval truncated number fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return {KEYS[1], KEYS[2], ARGV[1]}", "2", "k1", "k2", "a1")
+	if !output.isArray("k1", "k2", "a1") {
+		t.Fatal("eval keys and args fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return {1, 'two', {3}, nil, 5}", "0")
+	if !output.isArray(1, "two", []any{3}) {
+		t.Fatal("eval nested array fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return true", "0")
+	if !output.isInt(1) {
+		t.Fatal("eval true fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return false", "0")
+	if !output.isNull() {
+		t.Fatal("eval false fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return redis.status_reply('FINE')", "0")
+	if !output.isString("FINE") || output.isErrorType() {
+		t.Fatal("eval status reply fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return redis.error_reply('MYERR broken')", "0")
+	if !output.isErrorString("MYERR broken") {
+		t.Fatal("eval error reply fail")
+	}
+}
+
+func TestEvalRedisCall(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("eval", "redis.call('set', KEYS[1], ARGV[1]); return redis.call('get', KEYS[1])", "1", "k", "v")
+	if !output.isString("v") {
+		t.Fatal("eval set and get fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return redis.call('incrby', 'n', 5)", "0")
+	if !output.isInt(5) {
+		t.Fatal("eval number arg fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return redis.call('get', 'missing') == false", "0")
+	if !output.isInt(1) {
+		t.Fatal("eval null reply fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return redis.call('set', 'k2', 'v')['ok']", "0")
+	if !output.isString("OK") {
+		t.Fatal("eval status reply table fail")
+	}
+
+	// redis.call() raises the error, redis.pcall() returns it
+	output = ts.ProcessCommand("eval", "redis.call('incr', 'k'); return 1", "0")
+	if !output.isErrorString("ERR value is not an integer or out of range") {
+		t.Fatal("eval call error fail")
+	}
+
+	output = ts.ProcessCommand("eval", "local r = redis.pcall('lpush', 'k', 'a'); return r['err']", "0")
+	if !output.isString("WRONGTYPE Operation against a key holding the wrong kind of value") {
+		t.Fatal("eval pcall error fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return redis.pcall()", "0")
+	if !output.isErrorString("ERR Please specify at least one argument for this redis lib call") {
+		t.Fatal("eval call without args fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return redis.call('eval', 'return 1', 0)", "0")
+	if !output.isErrorString("ERR This Redis command is not allowed from script") {
+		t.Fatal("eval noscript command fail")
+	}
+
+	output = ts.ProcessCommand("get", "n")
+	if !output.isString("5") {
+		t.Fatal("eval data store fail")
+	}
+}
+
+func TestEvalScriptCache(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	script := "return ARGV[1] .. '!'"
+	sha := sha1Hex(script)
+
+	output := ts.ProcessCommand("evalsha", sha, "0", "hi")
+	if !output.isErrorString("NOSCRIPT No matching script. Please use EVAL.") {
+		t.Fatal("evalsha missing fail")
+	}
+
+	output = ts.ProcessCommand("script", "load", script)
+	if !output.isString(sha) {
+		t.Fatal("script load fail")
+	}
+
+	output = ts.ProcessCommand("evalsha", strings.ToUpper(sha), "0", "hi")
+	if !output.isString("hi!") {
+		t.Fatal("evalsha fail")
+	}
+
+	// eval caches the script too
+	ts.ProcessCommand("eval", "return 1", "0")
+	output = ts.ProcessCommand("script", "exists", sha, sha1Hex("return 1"), sha1Hex("return 2"))
+	if !output.isArray(1, 1, 0) {
+		t.Fatal("script exists fail")
+	}
+
+	output = ts.ProcessCommand("script", "flush")
+	if !output.isString("OK") {
+		t.Fatal("script flush fail")
+	}
+
+	output = ts.ProcessCommand("script", "exists", sha)
+	if !output.isArray(0) {
+		t.Fatal("script exists after flush fail")
+	}
+
+	output = ts.ProcessCommand("script", "kill")
+	if !output.isErrorString("NOTBUSY No scripts in execution right now.") {
+		t.Fatal("script kill fail")
+	}
+}
+
+func TestEvalReadOnly(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("set", "k", "v")
+
+	output := ts.ProcessCommand("eval_ro", "return redis.call('get', KEYS[1])", "1", "k")
+	if !output.isString("v") {
+		t.Fatal("eval_ro read fail")
+	}
+
+	output = ts.ProcessCommand("eval_ro", "return redis.call('set', KEYS[1], 'x')", "1", "k")
+	if !output.isErrorString("ERR Write commands are not allowed from read-only scripts.") {
+		t.Fatal("eval_ro write fail")
+	}
+
+	script := "return redis.call('del', KEYS[1])"
+	ts.ProcessCommand("script", "load", script)
+	output = ts.ProcessCommand("evalsha_ro", sha1Hex(script), "1", "k")
+	if !output.isErrorString("ERR Write commands are not allowed from read-only scripts.") {
+		t.Fatal("evalsha_ro write fail")
+	}
+
+	output = ts.ProcessCommand("eval", "#!lua flags=no-writes\nreturn redis.call('del', KEYS[1])", "1", "k")
+	if !output.isErrorString("ERR Write commands are not allowed from read-only scripts.") {
+		t.Fatal("eval no-writes shebang fail")
+	}
+
+	output = ts.ProcessCommand("eval", "#!lua flags=bogus\nreturn 1", "0")
+	if !output.isErrorString("ERR Unexpected flag in script shebang: bogus") {
+		t.Fatal("eval bad shebang fail")
+	}
+
+	output = ts.ProcessCommand("get", "k")
+	if !output.isString("v") {
+		t.Fatal("eval_ro data store fail")
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("eval", "return (", "0")
+	if !output.isErrorType() || !strings.HasPrefix(output.String(), "ERR Error compiling script") {
+		t.Fatal("eval compile error fail")
+	}
+
+	output = ts.ProcessCommand("eval", "x = 1", "0")
+	if !output.isErrorType() || !strings.Contains(output.String(), "Script attempted to create global variable 'x'") {
+		t.Fatal("eval global create fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return y", "0")
+	if !output.isErrorType() || !strings.Contains(output.String(), "Script attempted to access nonexistent global variable 'y'") {
+		t.Fatal("eval global access fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return 1", "2", "k")
+	if !output.isErrorString("ERR Number of keys can't be greater than number of args") {
+		t.Fatal("eval numkeys greater fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return 1", "-1")
+	if !output.isErrorString("ERR Number of keys can't be negative") {
+		t.Fatal("eval numkeys negative fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return redis.setresp(4)", "0")
+	if !output.isErrorType() || !strings.Contains(output.String(), "RESP version must be 2 or 3.") {
+		t.Fatal("eval setresp fail")
+	}
+}
+
+func TestEvalResp3(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("hset", "h", "f", "v")
+
+	// RESP2 replies are the default, even for a RESP3 client
+	output := ts.ProcessCommand("eval", "return redis.call('hgetall', KEYS[1])", "1", "h")
+	if !output.isArray("f", "v") {
+		t.Fatal("eval resp2 hgetall fail")
+	}
+
+	output = ts.ProcessCommand("eval", "redis.setresp(3); return redis.call('hgetall', KEYS[1])", "1", "h")
+	if !output.isMap(map[any]any{"f": "v"}) {
+		t.Fatal("eval resp3 hgetall fail")
+	}
+
+	output = ts.ProcessCommand("eval", "redis.setresp(3); return redis.call('hgetall', KEYS[1])['map']['f']", "1", "h")
+	if !output.isString("v") {
+		t.Fatal("eval resp3 map field fail")
+	}
+
+	output = ts.ProcessCommand("eval", "redis.setresp(3); return redis.call('get', 'missing') == nil", "0")
+	if !output.isBool(true) {
+		t.Fatal("eval resp3 null fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return {double=3.5}", "0")
+	if !output.isFloat(3.5, -1) {
+		t.Fatal("eval double fail")
+	}
+}
+
+func TestEvalLibraries(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("eval", "return cjson.encode({a={1,2,'x/y'},b=true,c=cjson.null})", "0")
+	if !output.isString(`{"a":[1,2,"x\/y"],"b":true,"c":null}`) {
+		t.Fatal("cjson encode fail")
+	}
+
+	output = ts.ProcessCommand("eval", "local t = cjson.decode(ARGV[1]); return {t.name, t.list[2], tostring(t.none == cjson.null)}", "0", `{"name":"n","list":[10,20],"none":null}`)
+	if !output.isArray("n", 20, "true") {
+		t.Fatal("cjson decode fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return {bit.band(0xff, 0x0f), bit.bor(1, 2, 4), bit.bxor(5, 1), bit.lshift(1, 4), bit.bnot(0), bit.tohex(255, 4), bit.tobit(0xffffffff)}", "0")
+	if !output.isArray(15, 7, 4, 16, -1, "00ff", -1) {
+		t.Fatal("bit library fail")
+	}
+
+	output = ts.ProcessCommand("eval", "return redis.sha1hex('')", "0")
+	if !output.isString("da39a3ee5e6b4b0d3255bfef95601890afd80709") {
+		t.Fatal("sha1hex fail")
+	}
+}
+
+func TestEvalInTransaction(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("multi")
+	ts.ProcessCommand("set", "k", "1")
+	output := ts.ProcessCommand("eval", "return redis.call('incr', KEYS[1])", "1", "k")
+	if !output.isString(strQueued) {
+		t.Fatal("eval queued fail")
+	}
+
+	output = ts.ProcessCommand("exec")
+	if !output.isArray("OK", 2) {
+		t.Fatal("eval exec fail")
+	}
+}
+
+func TestEvalSelect(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("eval", "redis.call('select', 1); return redis.call('set', 'k', 'db1')", "0")
+	if !output.isString("OK") {
+		t.Fatal("eval select fail")
+	}
+
+	// the selected database of the client is unchanged
+	output = ts.ProcessCommand("exists", "k")
+	if !output.isInt(0) {
+		t.Fatal("eval select db 0 fail")
+	}
+
+	ts.ProcessCommand("select", "1")
+	output = ts.ProcessCommand("get", "k")
+	if !output.isString("db1") {
+		t.Fatal("eval select db 1 fail")
+	}
+}
+
+func TestScriptKill(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	done := make(chan respValue)
+	go func() {
+		done <- ts2.ProcessCommand("eval", "while true do end", "0")
+	}()
+	time.Sleep(40 * time.Millisecond)
+
+	output := ts.ProcessCommand("script", "kill")
+	if !output.isString("OK") {
+		t.Fatal("script kill fail")
+	}
+
+	output = <-done
+	if !output.isErrorString("ERR Script killed by user with SCRIPT KILL...") {
+		t.Fatal("script killed reply fail")
+	}
+
+	output = ts.ProcessCommand("script", "kill")
+	if !output.isErrorString("NOTBUSY No scripts in execution right now.") {
+		t.Fatal("script kill after kill fail")
+	}
+
+	// a script that has written can't be killed
+	go func() {
+		done <- ts2.ProcessCommand("eval", "redis.call('set', KEYS[1], 'v') local t = os.clock() + 0.2 while os.clock() < t do end return 1", "1", "k")
+	}()
+	time.Sleep(40 * time.Millisecond)
+
+	output = ts.ProcessCommand("script", "kill")
+	if !output.isErrorString("UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.") {
+		t.Fatal("script unkillable fail")
+	}
+
+	output = <-done
+	if !output.isInt(1) {
+		t.Fatal("script unkillable reply fail")
+	}
+}
@@ -0,0 +1,62 @@
+package redisemu
+
+var slowLogHelpText = []string{
+	"SLOWLOG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET [<count>]",
+	"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+	"    Entries are made of:",
+	"    id, timestamp, time in microseconds, arguments array, client IP and port,",
+	"    client name",
+	"LEN",
+	"    Return the length of the slowlog.",
+	"RESET",
+	"    Reset the slowlog.",
+	"HELP",
+	"    Print this help.",
+}
+
+func fnSlowLogGet(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	count := int64(10)
+	if n, specified := args["count"].(int64); specified {
+		if n < -1 {
+			output.data = respErrorString("ERR count should be greater than or equal to -1")
+			return
+		}
+		count = n
+	}
+
+	entries := ctx.cs.dss.slowLogEntries(int(count))
+	a := make(respArray, 0, len(entries))
+	for _, entry := range entries {
+		a = append(a, respValue{data: respArray{
+			{data: respInt(entry.Id)},
+			{data: respInt(entry.Time.Unix())},
+			{data: respInt(entry.Duration.Microseconds())},
+			{data: nativeStringArrayToResp(entry.Args)},
+			{data: respBulkString(entry.ClientAddr)},
+			{data: respBulkString(entry.ClientName)},
+		}})
+	}
+	output.data = a
+	return
+}
+
+func fnSlowLogHelp(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	a := make(respArray, 0, len(slowLogHelpText))
+	for _, line := range slowLogHelpText {
+		a = append(a, respValue{data: respSimpleString(line)})
+	}
+	output.data = a
+	return
+}
+
+func fnSlowLogLen(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	output.data = respInt(ctx.cs.dss.slowLogLen())
+	return
+}
+
+func fnSlowLogReset(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	ctx.cs.dss.resetSlowLog()
+	output.data = rstrOK
+	return
+}
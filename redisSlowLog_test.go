@@ -0,0 +1,130 @@
+package redisemu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowLog(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("slowlog", "reset")
+	ts.ProcessCommand("config", "set", "slowlog-log-slower-than", "0")
+	ts.ProcessCommand("client", "setname", "tester")
+	ts.ProcessCommand("set", "key1", "cat")
+
+	output := ts.ProcessCommand("slowlog", "len")
+	if !output.isInt(3) {
+		t.Fatal("slowlog len fail")
+	}
+
+	output = ts.ProcessCommand("slowlog", "get", "2")
+	entries, _ := output.toArray()
+	if len(entries) != 2 {
+		t.Fatal("slowlog get count fail")
+	}
+	entry, _ := entries[1].toArray()
+	if len(entry) != 6 || !entry[1].isAtLeast(int(time.Now().Unix())-1) || !entry[3].isArray("set", "key1", "cat") ||
+		!entry[4].isString(ts.ClientAddr()) || !entry[5].isString("tester") {
+		t.Fatal("slowlog get entry fail")
+	}
+
+	// the newest entries come first, and the ids keep increasing after a reset
+	output = ts.ProcessCommand("slowlog", "get")
+	entries, _ = output.toArray()
+	first, _ := entries[0].toArray()
+	last, _ := entries[len(entries)-1].toArray()
+	if len(entries) != 5 || !first[3].isArray("slowlog", "get", "2") || !last[3].isArray("config", "set", "slowlog-log-slower-than", "0") {
+		t.Fatal("slowlog get order fail")
+	}
+	id, _ := first[0].toInt()
+
+	output = ts.ProcessCommand("slowlog", "reset")
+	if !output.isString("OK") {
+		t.Fatal("slowlog reset fail")
+	}
+	output = ts.ProcessCommand("slowlog", "get", "-1")
+	entries, _ = output.toArray()
+	first, _ = entries[0].toArray()
+	if len(entries) != 1 || !first[0].isInt64(id+2) || !first[3].isArray("slowlog", "reset") {
+		t.Fatal("slowlog after reset fail")
+	}
+
+	output = ts.ProcessCommand("slowlog", "get", "-2")
+	if !output.isErrorString("ERR count should be greater than or equal to -1") {
+		t.Fatal("slowlog get negative fail")
+	}
+}
+
+func TestSlowLogTruncation(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("config", "set", "slowlog-log-slower-than", "0")
+
+	args := []any{"key1"}
+	for i := 0; i < 40; i++ {
+		args = append(args, fmt.Sprintf("%d", i))
+	}
+	ts.ProcessCommand("rpush", args...)
+	output := ts.ProcessCommand("slowlog", "get", "1")
+	entries, _ := output.toArray()
+	entry, _ := entries[0].toArray()
+	logged, _ := entry[3].toArray()
+	if len(logged) != 32 || !logged[30].isString("28") || !logged[31].isString("... (11 more arguments)") {
+		t.Fatal("slowlog argument count fail")
+	}
+
+	ts.ProcessCommand("set", "key2", strings.Repeat("x", 200))
+	output = ts.ProcessCommand("slowlog", "get", "1")
+	entries, _ = output.toArray()
+	entry, _ = entries[0].toArray()
+	if !entry[3].isArray("set", "key2", strings.Repeat("x", 128)+"... (72 more bytes)") {
+		t.Fatal("slowlog argument length fail")
+	}
+
+	// the log keeps only the newest entries
+	ts.ProcessCommand("config", "set", "slowlog-max-len", "2")
+	ts.ProcessCommand("ping")
+	output = ts.ProcessCommand("slowlog", "len")
+	if !output.isInt(2) {
+		t.Fatal("slowlog max len fail")
+	}
+}
+
+func TestSlowLogThreshold(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("config", "set", "slowlog-log-slower-than", "50000")
+	ts.ProcessCommand("slowlog", "reset")
+
+	// the time that a command is blocked doesn't count
+	ts.ProcessCommand("set", "key1", "cat")
+	ts.ProcessCommand("blpop", "list1", "0.1")
+	output := ts.ProcessCommand("slowlog", "len")
+	if !output.isInt(0) {
+		t.Fatal("slowlog fast commands fail")
+	}
+
+	ts.ProcessCommand("debug", "sleep", "0.06")
+	output = ts.ProcessCommand("slowlog", "get")
+	entries, _ := output.toArray()
+	if len(entries) != 1 {
+		t.Fatal("slowlog slow command fail")
+	}
+	entry, _ := entries[0].toArray()
+	if !entry[2].isAtLeast(60000) || !entry[3].isArray("debug", "sleep", "0.06") {
+		t.Fatal("slowlog slow command entry fail")
+	}
+
+	ts.ProcessCommand("config", "set", "slowlog-log-slower-than", "-1")
+	ts.ProcessCommand("debug", "sleep", "0.06")
+	output = ts.ProcessCommand("slowlog", "len")
+	if !output.isInt(1) {
+		t.Fatal("slowlog disabled fail")
+	}
+}
@@ -0,0 +1,773 @@
+package redisemu
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errNotFloat = respErrorString("ERR min or max is not a float")
+var errNotLexRange = respErrorString("ERR min or max not valid string range item")
+var errNotInteger = respErrorString("ERR value is not an integer or out of range")
+
+// makes a range selection from ZRANGE style arguments; start and stop are in
+// the order of iteration, so for a reverse range, start is the maximum
+func parseZRangeSpec(start, stop string, byScore, byLex, reverse bool, limit *orderedMap) (spec *zrangeSpec, errText respErrorString) {
+	spec = &zrangeSpec{
+		reverse: reverse,
+		limit:   -1,
+	}
+
+	if limit != nil {
+		if !byScore && !byLex {
+			errText = respErrorString("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+			return
+		}
+
+		spec.offset = int(limit.mustGet("offset").(int64))
+		count := int(limit.mustGet("count").(int64))
+		if count >= 0 {
+			spec.limit = count
+		}
+	}
+
+	minText, maxText := start, stop
+	if reverse {
+		minText, maxText = stop, start
+	}
+
+	if byScore {
+		r, valid := newZScoreRange(minText, maxText)
+		if !valid {
+			errText = errNotFloat
+			return
+		}
+		spec.scores = r
+	} else if byLex {
+		r, valid := newZLexRange(minText, maxText)
+		if !valid {
+			errText = errNotLexRange
+			return
+		}
+		spec.lex = r
+	} else {
+		startIndex, err := strconv.ParseInt(start, 10, 64)
+		if err != nil {
+			errText = errNotInteger
+			return
+		}
+		stopIndex, err := strconv.ParseInt(stop, 10, 64)
+		if err != nil {
+			errText = errNotInteger
+			return
+		}
+		spec.start = int(startIndex)
+		spec.stop = int(stopIndex)
+	}
+	return
+}
+
+// checks the combination of ZADD options, in the order redis does
+func zaddFlagsError(flags bitflags) respErrorString {
+	if flagHasAll(flags, ZADD_NX|ZADD_XX) {
+		return "ERR XX and NX options at the same time are not compatible"
+	}
+	if flagHasAll(flags, ZADD_GT|ZADD_LT) || (flagHasOne(flags, ZADD_NX) && flagHasOne(flags, ZADD_GT|ZADD_LT)) {
+		return "ERR GT, LT, and/or NX options at the same time are not compatible"
+	}
+	return ""
+}
+
+// the ZADD options, in the order of the command spec
+var zaddOptions = []string{"nx", "xx", "gt", "lt", "ch", "incr"}
+
+// the command spec orders the ZADD options, but redis accepts them in any
+// order, and repeated; this puts the options that follow the key in the spec
+// order, once each, so that the parser accepts them
+func zaddSortOptions(cmdArgs respArray) respArray {
+	if len(cmdArgs) < 2 {
+		return cmdArgs
+	}
+
+	found := map[string]respValue{}
+	end := 1
+	for ; end < len(cmdArgs); end++ {
+		str, _ := cmdArgs[end].toString()
+		opt := strings.ToLower(str)
+		if !slices.Contains(zaddOptions, opt) {
+			break
+		}
+		found[opt] = cmdArgs[end]
+	}
+
+	sorted := make(respArray, 0, len(cmdArgs))
+	sorted = append(sorted, cmdArgs[0])
+	for _, opt := range zaddOptions {
+		if arg, exists := found[opt]; exists {
+			sorted = append(sorted, arg)
+		}
+	}
+	return append(sorted, cmdArgs[end:]...)
+}
+
+// the command spec makes NX/XX and GT/LT choices, and requires float scores,
+// so the parser rejects both of a pair, or a score such as NaN, before the
+// handler runs; this examines the raw arguments that follow the key to
+// provide the redis error instead
+func zaddOptionsError(cmdArgs []string) respErrorString {
+	var flags bitflags
+	i := 1
+	for ; i < len(cmdArgs); i++ {
+		opt := strings.ToLower(cmdArgs[i])
+		if opt == "nx" {
+			flags |= ZADD_NX
+		} else if opt == "xx" {
+			flags |= ZADD_XX
+		} else if opt == "gt" {
+			flags |= ZADD_GT
+		} else if opt == "lt" {
+			flags |= ZADD_LT
+		} else if opt != "ch" && opt != "incr" {
+			break
+		}
+	}
+	if errText := zaddFlagsError(flags); errText != "" {
+		return errText
+	}
+
+	if (len(cmdArgs)-i)%2 == 0 {
+		for ; i < len(cmdArgs); i += 2 {
+			score, err := strconv.ParseFloat(cmdArgs[i], 64)
+			if err != nil || math.IsNaN(score) {
+				return "ERR value is not a valid float"
+			}
+		}
+	}
+	return ""
+}
+
+func zaddCommon(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	data := args["data"].([]any)
+
+	var flags bitflags
+	if _, nx := args["condition.nx"]; nx {
+		flags |= ZADD_NX
+	}
+	if _, xx := args["condition.xx"]; xx {
+		flags |= ZADD_XX
+	}
+	if _, gt := args["comparison.gt"]; gt {
+		flags |= ZADD_GT
+	}
+	if _, lt := args["comparison.lt"]; lt {
+		flags |= ZADD_LT
+	}
+	if _, ch := args["change"]; ch {
+		flags |= ZADD_CH
+	}
+	if _, incr := args["increment"]; incr {
+		flags |= ZADD_INCR
+	}
+
+	if errText := zaddFlagsError(flags); errText != "" {
+		output.data = errText
+		return
+	}
+
+	if flagHasOne(flags, ZADD_INCR) && len(data) > 1 {
+		output.data = respErrorString("ERR INCR option supports a single increment-element pair")
+		return
+	}
+
+	members := make([]string, 0, len(data))
+	scores := make([]float64, 0, len(data))
+	for _, pair := range data {
+		m := pair.(*orderedMap)
+		members = append(members, m.mustGet("member").(string))
+		scores = append(scores, m.mustGet("score").(float64))
+	}
+
+	output = ctx.dsc.zadd(keyName, members, scores, flags)
+	return
+}
+
+func fnZAdd(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	return zaddCommon(ctx, args)
+}
+
+func fnZCard(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	output = ctx.dsc.zcard(keyName)
+	return
+}
+
+func fnZCount(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	r, valid := newZScoreRange(args["min"].(string), args["max"].(string))
+	if !valid {
+		output.data = errNotFloat
+		return
+	}
+
+	output = ctx.dsc.zcount(keyName, &zrangeSpec{scores: r, limit: -1})
+	return
+}
+
+func fnZLexCount(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	r, valid := newZLexRange(args["min"].(string), args["max"].(string))
+	if !valid {
+		output.data = errNotLexRange
+		return
+	}
+
+	output = ctx.dsc.zcount(keyName, &zrangeSpec{lex: r, limit: -1})
+	return
+}
+
+func fnZIncrBy(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	delta := args["increment"].(float64)
+	member := args["member"].(string)
+
+	output = ctx.dsc.zadd(keyName, []string{member}, []float64{delta}, ZADD_INCR)
+	return
+}
+
+func fnZScore(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	member := args["member"].(string)
+
+	scores, errText := ctx.dsc.zscore(keyName, []string{member})
+	if errText != nil {
+		output.data = *errText
+		return
+	}
+
+	output = nativeValueToResp(scores[0])
+	return
+}
+
+func fnZMScore(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	members := args["member"].([]any)
+
+	memberNames := make([]string, 0, len(members))
+	for _, m := range members {
+		memberNames = append(memberNames, m.(string))
+	}
+
+	scores, errText := ctx.dsc.zscore(keyName, memberNames)
+	if errText != nil {
+		output.data = *errText
+		return
+	}
+
+	output = nativeValueToResp(scores)
+	return
+}
+
+func fnZRank(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	member := args["member"].(string)
+	_, withScore := args["withscore"]
+
+	output = ctx.dsc.zrank(keyName, member, false, withScore)
+	return
+}
+
+func fnZRevRank(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	member := args["member"].(string)
+	_, withScore := args["withscore"]
+
+	output = ctx.dsc.zrank(keyName, member, true, withScore)
+	return
+}
+
+func fnZRem(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	members := args["member"].([]any)
+
+	memberNames := make([]string, 0, len(members))
+	for _, m := range members {
+		memberNames = append(memberNames, m.(string))
+	}
+
+	output = ctx.dsc.zrem(keyName, memberNames)
+	return
+}
+
+func fnZRange(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	start := args["start"].(string)
+	stop := args["stop"].(string)
+	_, byScore := args["sortby.byscore"]
+	_, byLex := args["sortby.bylex"]
+	_, reverse := args["rev"]
+	limit, _ := args["limit"].(*orderedMap)
+	_, withScores := args["withscores"]
+
+	if byLex && withScores {
+		output.data = respErrorString("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+		return
+	}
+
+	spec, errText := parseZRangeSpec(start, stop, byScore, byLex, reverse, limit)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.zrange(keyName, spec, withScores)
+	return
+}
+
+func fnZRangeStore(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	destKeyName := args["dst"].(string)
+	srcKeyName := args["src"].(string)
+	start := args["min"].(string)
+	stop := args["max"].(string)
+	_, byScore := args["sortby.byscore"]
+	_, byLex := args["sortby.bylex"]
+	_, reverse := args["rev"]
+	limit, _ := args["limit"].(*orderedMap)
+
+	spec, errText := parseZRangeSpec(start, stop, byScore, byLex, reverse, limit)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.zrangeStore(destKeyName, srcKeyName, spec)
+	return
+}
+
+func fnZRangeByScore(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	limit, _ := args["limit"].(*orderedMap)
+	_, withScores := args["withscores"]
+
+	spec, errText := parseZRangeSpec(args["min"].(string), args["max"].(string), true, false, false, limit)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.zrange(keyName, spec, withScores)
+	return
+}
+
+func fnZRevRangeByScore(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	limit, _ := args["limit"].(*orderedMap)
+	_, withScores := args["withscores"]
+
+	spec, errText := parseZRangeSpec(args["max"].(string), args["min"].(string), true, false, true, limit)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.zrange(keyName, spec, withScores)
+	return
+}
+
+func fnZRangeByLex(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	limit, _ := args["limit"].(*orderedMap)
+
+	spec, errText := parseZRangeSpec(args["min"].(string), args["max"].(string), false, true, false, limit)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.zrange(keyName, spec, false)
+	return
+}
+
+func fnZRevRangeByLex(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	limit, _ := args["limit"].(*orderedMap)
+
+	spec, errText := parseZRangeSpec(args["max"].(string), args["min"].(string), false, true, true, limit)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.zrange(keyName, spec, false)
+	return
+}
+
+func fnZRevRange(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	start := args["start"].(int64)
+	stop := args["stop"].(int64)
+	_, withScores := args["withscores"]
+
+	spec := &zrangeSpec{
+		start:   int(start),
+		stop:    int(stop),
+		reverse: true,
+		limit:   -1,
+	}
+
+	output = ctx.dsc.zrange(keyName, spec, withScores)
+	return
+}
+
+func fnZRemRangeByRank(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	start := args["start"].(int64)
+	stop := args["stop"].(int64)
+
+	output = ctx.dsc.zremRange(keyName, &zrangeSpec{start: int(start), stop: int(stop), limit: -1})
+	return
+}
+
+func fnZRemRangeByScore(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	r, valid := newZScoreRange(args["min"].(string), args["max"].(string))
+	if !valid {
+		output.data = errNotFloat
+		return
+	}
+
+	output = ctx.dsc.zremRange(keyName, &zrangeSpec{scores: r, limit: -1})
+	return
+}
+
+func fnZRemRangeByLex(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	r, valid := newZLexRange(args["min"].(string), args["max"].(string))
+	if !valid {
+		output.data = errNotLexRange
+		return
+	}
+
+	output = ctx.dsc.zremRange(keyName, &zrangeSpec{lex: r, limit: -1})
+	return
+}
+
+func fnZRandMember(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	options, _ := args["options"].(*orderedMap)
+	var withScores bool
+	var c *int
+	var c32 int
+
+	if options != nil {
+		count, hasCount := options.mustGet("count").(int64)
+		if hasCount {
+			c32 = int(count)
+			c = &c32
+		}
+		_, withScores = options.get("withscores")
+	}
+	output = ctx.dsc.zrandMember(keyName, c, withScores)
+	return
+}
+
+func fnZScan(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	cursor := args["cursor"].(int64)
+	match, _ := args["pattern"].(string)
+	count, countSpecified := args["count"].(int64)
+
+	if countSpecified {
+		if count < 1 {
+			output.data = rstrSyntaxError
+			return
+		}
+	} else {
+		count = 10
+	}
+
+	output = ctx.dsc.zscan(keyName, uint32(cursor), match, int(count))
+	return
+}
+
+func zpopCommon(ctx *cmdContext, args map[string]any, max bool) (output respValue, err error) {
+	keyName := args["key"].(string)
+	count, countSpecified := args["count"].(int64)
+
+	if !countSpecified {
+		count = 1
+	} else if count < 0 {
+		output.data = respErrorString("ERR value is out of range, must be positive")
+		return
+	}
+
+	items, errText := ctx.dsc.zpop(keyName, int(count), max)
+	if errText != nil {
+		output.data = *errText
+		return
+	}
+
+	if countSpecified {
+		output = zsetItemsToResp(items, true)
+	} else {
+		// without a count, the member and score are a flat array
+		a := respArray{}
+		for _, item := range items {
+			a = append(a, respValue{data: respBulkString(item.member)}, respValue{data: respDouble(item.score)})
+		}
+		output.data = a
+	}
+	return
+}
+
+func fnZPopMin(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	return zpopCommon(ctx, args, false)
+}
+
+func fnZPopMax(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	return zpopCommon(ctx, args, true)
+}
+
+func fnZMPop(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	numkeys := args["numkeys"].(int64)
+	keyNames := args["key"].([]any)
+	_, max := args["where.max"]
+	count, hasCount := args["count"].(int64)
+
+	if len(keyNames) != int(numkeys) {
+		output.data = rstrSyntaxError
+		return
+	}
+
+	strKeyNames := make([]string, 0, len(keyNames))
+	for _, k := range keyNames {
+		strKeyNames = append(strKeyNames, k.(string))
+	}
+
+	if !hasCount {
+		count = 1
+	} else if count < 1 {
+		output.data = respErrorString("ERR count should be greater than 0")
+		return
+	}
+
+	output = ctx.dsc.zmpop(strKeyNames, max, int(count))
+	return
+}
+
+func bzpopCommon(ctx *cmdContext, args map[string]any, max bool) (output respValue, err error) {
+	timeout := args["timeout"].(float64)
+	keyNamesArg := args["key"].([]any)
+
+	if timeout < 0 {
+		output.data = respErrorString("ERR timeout is negative")
+		return
+	}
+
+	keyNames := make([]string, 0, len(keyNamesArg))
+	for _, keyName := range keyNamesArg {
+		keyNames = append(keyNames, keyName.(string))
+	}
+
+	timeoutNs := int64(timeout * float64(time.Second))
+	output = blockOnListChangeMultiKey(
+		ctx, keyNames, timeoutNs,
+		func() (output respValue) {
+			for _, keyName := range keyNames {
+				items, errText := ctx.dsc.zpop(keyName, 1, max)
+				if errText != nil {
+					output.data = *errText
+					return
+				} else if len(items) == 1 {
+					output.data = respArray{
+						respValue{data: respBulkString(keyName)},
+						respValue{data: respBulkString(items[0].member)},
+						respValue{data: respDouble(items[0].score)},
+					}
+					return
+				}
+			}
+			return
+		})
+	return
+}
+
+func fnBZPopMin(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	return bzpopCommon(ctx, args, false)
+}
+
+func fnBZPopMax(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	return bzpopCommon(ctx, args, true)
+}
+
+func fnBZMPop(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	timeout := args["timeout"].(float64)
+	keyNamesArg := args["key"].([]any)
+
+	if timeout < 0 {
+		output.data = respErrorString("ERR timeout is negative")
+		return
+	}
+
+	keyNames := make([]string, 0, len(keyNamesArg))
+	for _, keyName := range keyNamesArg {
+		keyNames = append(keyNames, keyName.(string))
+	}
+
+	timeoutNs := int64(timeout * float64(time.Second))
+	output = blockOnListChangeMultiKey(ctx, keyNames, timeoutNs, func() (output respValue) {
+		output, _ = fnZMPop(ctx, args)
+		return
+	})
+	return
+}
+
+// parses the common arguments of ZUNION, ZINTER, ZDIFF and their store variants
+func zsetOperationArgs(args map[string]any) (keyNames []string, weights []float64, aggregate zsetAggregator, errText respErrorString) {
+	numkeys := int(args["numkeys"].(int64))
+	keyNamesArg := args["key"].([]any)
+	weightsArg, hasWeights := args["weight"].([]any)
+
+	if numkeys < len(keyNamesArg) {
+		errText = rstrSyntaxError
+		return
+	}
+	if numkeys > len(keyNamesArg) {
+		errText = rstrNumKeysGreater
+		return
+	}
+
+	keyNames = make([]string, 0, len(keyNamesArg))
+	for _, keyName := range keyNamesArg {
+		keyNames = append(keyNames, keyName.(string))
+	}
+
+	weights = make([]float64, 0, len(keyNames))
+	if hasWeights {
+		if len(weightsArg) != len(keyNames) {
+			errText = rstrSyntaxError
+			return
+		}
+		for _, weight := range weightsArg {
+			weights = append(weights, weight.(float64))
+		}
+	} else {
+		for range keyNames {
+			weights = append(weights, 1)
+		}
+	}
+
+	if _, isMin := args["aggregate.min"]; isMin {
+		aggregate = zsetAggregateMin
+	} else if _, isMax := args["aggregate.max"]; isMax {
+		aggregate = zsetAggregateMax
+	} else {
+		aggregate = zsetAggregateSum
+	}
+	return
+}
+
+func fnZUnion(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyNames, weights, aggregate, errText := zsetOperationArgs(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+	_, withScores := args["withscores"]
+
+	output = ctx.dsc.zsetOperation(keyNames, func(sources []*zset) *zset {
+		return zsetUnion(sources, weights, aggregate)
+	}, withScores)
+	return
+}
+
+func fnZUnionStore(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	destination := args["destination"].(string)
+	keyNames, weights, aggregate, errText := zsetOperationArgs(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.zsetOperationStore(destination, "zunionstore", keyNames, func(sources []*zset) *zset {
+		return zsetUnion(sources, weights, aggregate)
+	})
+	return
+}
+
+func fnZInter(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyNames, weights, aggregate, errText := zsetOperationArgs(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+	_, withScores := args["withscores"]
+
+	output = ctx.dsc.zsetOperation(keyNames, func(sources []*zset) *zset {
+		return zsetIntersect(sources, weights, aggregate)
+	}, withScores)
+	return
+}
+
+func fnZInterStore(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	destination := args["destination"].(string)
+	keyNames, weights, aggregate, errText := zsetOperationArgs(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.zsetOperationStore(destination, "zinterstore", keyNames, func(sources []*zset) *zset {
+		return zsetIntersect(sources, weights, aggregate)
+	})
+	return
+}
+
+func fnZInterCard(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyNames, _, _, errText := zsetOperationArgs(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	limit, _ := args["limit"].(int64)
+	if limit < 0 {
+		output.data = respErrorString("ERR LIMIT can't be negative")
+		return
+	}
+
+	output = ctx.dsc.zinterCard(keyNames, int(limit))
+	return
+}
+
+func fnZDiff(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyNames, _, _, errText := zsetOperationArgs(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+	_, withScores := args["withscores"]
+
+	output = ctx.dsc.zsetOperation(keyNames, zsetDiff, withScores)
+	return
+}
+
+func fnZDiffStore(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	destination := args["destination"].(string)
+	keyNames, _, _, errText := zsetOperationArgs(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.zsetOperationStore(destination, "zdiffstore", keyNames, zsetDiff)
+	return
+}
@@ -0,0 +1,1131 @@
+package redisemu
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// checks an array of member/score pairs, provided as member1, score1, member2, score2, ...
+func isZPairs(output respValue, expected ...any) bool {
+	pairs, valid := output.toPairs()
+	if !valid || len(pairs)*2 != len(expected) {
+		return false
+	}
+
+	for idx, pair := range pairs {
+		if !pair.key.isValue(expected[idx*2]) || !pair.value.isValue(expected[idx*2+1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestZAdd(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	// add members
+	output := ts.ProcessCommand("zadd", "z", "1", "a", "2", "b", "3", "c")
+	if !output.isInt(3) {
+		t.Fatal("zadd step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "1", "a", "5", "b", "4", "d")
+	if !output.isInt(1) {
+		t.Fatal("zadd step 2 fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z", "0", "-1", "withscores")
+	if !isZPairs(output, "a", 1.0, "c", 3.0, "d", 4.0, "b", 5.0) {
+		t.Fatal("zadd step 3 fail")
+	}
+
+	// CH counts updates
+	output = ts.ProcessCommand("zadd", "z", "ch", "1", "a", "6", "b", "1", "e")
+	if !output.isInt(2) {
+		t.Fatal("zadd ch fail")
+	}
+
+	// NX only adds
+	output = ts.ProcessCommand("zadd", "z", "nx", "10", "a", "10", "f")
+	if !output.isInt(1) {
+		t.Fatal("zadd nx step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zscore", "z", "a")
+	if !output.isFloat(1, -1) {
+		t.Fatal("zadd nx step 2 fail")
+	}
+
+	// XX only updates
+	output = ts.ProcessCommand("zadd", "z", "xx", "ch", "10", "a", "10", "g")
+	if !output.isInt(1) {
+		t.Fatal("zadd xx step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zscore", "z", "g")
+	if !output.isNull() {
+		t.Fatal("zadd xx step 2 fail")
+	}
+
+	// GT and LT
+	output = ts.ProcessCommand("zadd", "z", "gt", "ch", "5", "a", "20", "b", "7", "h")
+	if !output.isInt(2) {
+		t.Fatal("zadd gt step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zmscore", "z", "a", "b", "h")
+	if !output.isArray(10.0, 20.0, 7.0) {
+		t.Fatal("zadd gt step 2 fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "lt", "ch", "5", "a", "30", "b")
+	if !output.isInt(1) {
+		t.Fatal("zadd lt step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zmscore", "z", "a", "b")
+	if !output.isArray(5.0, 20.0) {
+		t.Fatal("zadd lt step 2 fail")
+	}
+
+	// INCR
+	output = ts.ProcessCommand("zadd", "z", "incr", "2.5", "a")
+	if !output.isFloat(7.5, -1) {
+		t.Fatal("zadd incr step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "nx", "incr", "2.5", "a")
+	if !output.isNull() {
+		t.Fatal("zadd incr step 2 fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "incr", "1", "a", "2", "b")
+	if !output.isErrorType() {
+		t.Fatal("zadd incr step 3 fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "+inf", "inf")
+	if !output.isInt(1) {
+		t.Fatal("zadd incr step 4 fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "incr", "-inf", "inf")
+	if !output.isErrorType() {
+		t.Fatal("zadd incr nan fail")
+	}
+
+	// incompatible options
+	output = ts.ProcessCommand("zadd", "z", "nx", "gt", "1", "a")
+	if !output.isErrorType() {
+		t.Fatal("zadd nx gt fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "nx", "xx", "1", "a")
+	if !output.isErrorString("ERR XX and NX options at the same time are not compatible") {
+		t.Fatal("zadd nx xx fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "gt", "lt", "1", "a")
+	if !output.isErrorString("ERR GT, LT, and/or NX options at the same time are not compatible") {
+		t.Fatal("zadd gt lt fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "ch", "LT", "GT", "1", "a")
+	if !output.isErrorString("ERR GT, LT, and/or NX options at the same time are not compatible") {
+		t.Fatal("zadd lt gt fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "x", "a")
+	if !output.isErrorString("ERR value is not a valid float") {
+		t.Fatal("zadd bad score fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "nan", "a")
+	if !output.isErrorString("ERR value is not a valid float") {
+		t.Fatal("zadd nan score fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "xx", "1", "a", "NaN", "b")
+	if !output.isErrorString("ERR value is not a valid float") {
+		t.Fatal("zadd second nan score fail")
+	}
+
+	// options are accepted in any order, and repeated
+	output = ts.ProcessCommand("zadd", "z2", "ch", "nx", "1", "a")
+	if !output.isInt(1) {
+		t.Fatal("zadd ch nx fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z2", "incr", "gt", "5", "a")
+	if !output.isFloat(6, -1) {
+		t.Fatal("zadd incr gt fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z2", "ch", "gt", "xx", "ch", "7", "a", "1", "b")
+	if !output.isInt(1) {
+		t.Fatal("zadd ch gt xx fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z2", "0", "-1", "withscores")
+	if !isZPairs(output, "a", 7.0) {
+		t.Fatal("zadd reordered options fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z2", "xx", "ch", "nx", "1", "a")
+	if !output.isErrorString("ERR XX and NX options at the same time are not compatible") {
+		t.Fatal("zadd xx ch nx fail")
+	}
+
+	output = ts.ProcessCommand("command", "getkeys", "zadd", "z2", "ch", "nx", "1", "a")
+	if !output.isArray("z2") {
+		t.Fatal("zadd reordered getkeys fail")
+	}
+
+	// XX on a missing key doesn't create it
+	output = ts.ProcessCommand("zadd", "missing", "xx", "1", "a")
+	if !output.isInt(0) {
+		t.Fatal("zadd xx missing step 1 fail")
+	}
+
+	output = ts.ProcessCommand("exists", "missing")
+	if !output.isInt(0) {
+		t.Fatal("zadd xx missing step 2 fail")
+	}
+
+	// wrong type
+	output = ts.ProcessCommand("set", "str", "value")
+	if !output.isString("OK") {
+		t.Fatal("zadd prepare string fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "str", "1", "a")
+	if !output.isErrorType() {
+		t.Fatal("zadd wrong type fail")
+	}
+}
+
+func TestZCardZScore(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zcard", "z")
+	if !output.isInt(0) {
+		t.Fatal("zcard missing fail")
+	}
+
+	output = ts.ProcessCommand("zscore", "z", "a")
+	if !output.isNull() {
+		t.Fatal("zscore missing key fail")
+	}
+
+	output = ts.ProcessCommand("zmscore", "z", "a", "b")
+	if !output.isArray(nil, nil) {
+		t.Fatal("zmscore missing key fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "1.5", "a", "-2", "b")
+	if !output.isInt(2) {
+		t.Fatal("zcard prepare fail")
+	}
+
+	output = ts.ProcessCommand("zcard", "z")
+	if !output.isInt(2) {
+		t.Fatal("zcard fail")
+	}
+
+	output = ts.ProcessCommand("zscore", "z", "b")
+	if !output.isFloat(-2, -1) {
+		t.Fatal("zscore fail")
+	}
+
+	output = ts.ProcessCommand("zmscore", "z", "a", "x", "b")
+	if !output.isArray(1.5, nil, -2.0) {
+		t.Fatal("zmscore fail")
+	}
+
+	output = ts.ProcessCommand("rpush", "list", "a")
+	if !output.isInt(1) {
+		t.Fatal("zcard prepare list fail")
+	}
+
+	output = ts.ProcessCommand("zcard", "list")
+	if !output.isErrorType() {
+		t.Fatal("zcard wrong type fail")
+	}
+
+	output = ts.ProcessCommand("zscore", "list", "a")
+	if !output.isErrorType() {
+		t.Fatal("zscore wrong type fail")
+	}
+}
+
+func TestZIncrBy(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zincrby", "z", "2", "a")
+	if !output.isFloat(2, -1) {
+		t.Fatal("zincrby step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zincrby", "z", "-0.5", "a")
+	if !output.isFloat(1.5, -1) {
+		t.Fatal("zincrby step 2 fail")
+	}
+
+	output = ts.ProcessCommand("zincrby", "z", "inf", "a")
+	if !output.isFloat(math.Inf(1), -1) {
+		t.Fatal("zincrby step 3 fail")
+	}
+
+	output = ts.ProcessCommand("zincrby", "z", "-inf", "a")
+	if !output.isErrorType() {
+		t.Fatal("zincrby nan fail")
+	}
+}
+
+func TestZRankZRem(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zadd", "z", "1", "a", "2", "b", "3", "c")
+	if !output.isInt(3) {
+		t.Fatal("zrank prepare fail")
+	}
+
+	output = ts.ProcessCommand("zrank", "z", "c")
+	if !output.isInt(2) {
+		t.Fatal("zrank fail")
+	}
+
+	output = ts.ProcessCommand("zrevrank", "z", "c")
+	if !output.isInt(0) {
+		t.Fatal("zrevrank fail")
+	}
+
+	output = ts.ProcessCommand("zrank", "z", "b", "withscore")
+	if !output.isArray(1, 2.0) {
+		t.Fatal("zrank withscore fail")
+	}
+
+	output = ts.ProcessCommand("zrevrank", "z", "a", "withscore")
+	if !output.isArray(2, 1.0) {
+		t.Fatal("zrevrank withscore fail")
+	}
+
+	output = ts.ProcessCommand("zrank", "z", "x")
+	if !output.isNull() {
+		t.Fatal("zrank missing member fail")
+	}
+
+	output = ts.ProcessCommand("zrem", "z", "a", "x", "c")
+	if !output.isInt(2) {
+		t.Fatal("zrem step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zrank", "z", "b")
+	if !output.isInt(0) {
+		t.Fatal("zrem step 2 fail")
+	}
+
+	output = ts.ProcessCommand("zrem", "z", "b")
+	if !output.isInt(1) {
+		t.Fatal("zrem step 3 fail")
+	}
+
+	output = ts.ProcessCommand("exists", "z")
+	if !output.isInt(0) {
+		t.Fatal("zrem empty key removal fail")
+	}
+}
+
+func TestZRange(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zadd", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e")
+	if !output.isInt(5) {
+		t.Fatal("zrange prepare fail")
+	}
+
+	// by rank
+	output = ts.ProcessCommand("zrange", "z", "0", "-1")
+	if !output.isArray("a", "b", "c", "d", "e") {
+		t.Fatal("zrange all fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z", "1", "2", "withscores")
+	if !isZPairs(output, "b", 2.0, "c", 3.0) {
+		t.Fatal("zrange withscores fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z", "-2", "100")
+	if !output.isArray("d", "e") {
+		t.Fatal("zrange negative start fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z", "3", "1")
+	if !output.isArray() {
+		t.Fatal("zrange empty fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z", "0", "1", "rev")
+	if !output.isArray("e", "d") {
+		t.Fatal("zrange rev fail")
+	}
+
+	output = ts.ProcessCommand("zrevrange", "z", "0", "1", "withscores")
+	if !isZPairs(output, "e", 5.0, "d", 4.0) {
+		t.Fatal("zrevrange fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z", "0", "1", "limit", "0", "1")
+	if !output.isErrorType() {
+		t.Fatal("zrange rank limit fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z", "a", "1")
+	if !output.isErrorType() {
+		t.Fatal("zrange not an integer fail")
+	}
+
+	// by score
+	output = ts.ProcessCommand("zrange", "z", "2", "4", "byscore")
+	if !output.isArray("b", "c", "d") {
+		t.Fatal("zrange byscore fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z", "(2", "(4", "byscore")
+	if !output.isArray("c") {
+		t.Fatal("zrange byscore exclusive fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z", "-inf", "+inf", "byscore", "limit", "1", "2")
+	if !output.isArray("b", "c") {
+		t.Fatal("zrange byscore limit fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z", "+inf", "3", "byscore", "rev", "limit", "0", "2", "withscores")
+	if !isZPairs(output, "e", 5.0, "d", 4.0) {
+		t.Fatal("zrange byscore rev fail")
+	}
+
+	output = ts.ProcessCommand("zrangebyscore", "z", "(1", "3", "withscores")
+	if !isZPairs(output, "b", 2.0, "c", 3.0) {
+		t.Fatal("zrangebyscore fail")
+	}
+
+	output = ts.ProcessCommand("zrevrangebyscore", "z", "4", "(1", "limit", "1", "-1")
+	if !output.isArray("c", "b") {
+		t.Fatal("zrevrangebyscore fail")
+	}
+
+	output = ts.ProcessCommand("zrangebyscore", "z", "x", "3")
+	if !output.isErrorType() {
+		t.Fatal("zrangebyscore not a float fail")
+	}
+
+	// by lex
+	output = ts.ProcessCommand("zadd", "lex", "0", "apple", "0", "banana", "0", "cherry", "0", "date")
+	if !output.isInt(4) {
+		t.Fatal("zrange prepare lex fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "lex", "[b", "(d", "bylex")
+	if !output.isArray("banana", "cherry") {
+		t.Fatal("zrange bylex fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "lex", "+", "-", "bylex", "rev", "limit", "1", "2")
+	if !output.isArray("cherry", "banana") {
+		t.Fatal("zrange bylex rev fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "lex", "-", "+", "bylex", "withscores")
+	if !output.isErrorType() {
+		t.Fatal("zrange bylex withscores fail")
+	}
+
+	output = ts.ProcessCommand("zrangebylex", "lex", "(apple", "[cherry")
+	if !output.isArray("banana", "cherry") {
+		t.Fatal("zrangebylex fail")
+	}
+
+	output = ts.ProcessCommand("zrevrangebylex", "lex", "[cherry", "-", "limit", "0", "2")
+	if !output.isArray("cherry", "banana") {
+		t.Fatal("zrevrangebylex fail")
+	}
+
+	output = ts.ProcessCommand("zrangebylex", "lex", "apple", "+")
+	if !output.isErrorType() {
+		t.Fatal("zrangebylex invalid range fail")
+	}
+
+	// missing key
+	output = ts.ProcessCommand("zrange", "missing", "0", "-1")
+	if !output.isArray() {
+		t.Fatal("zrange missing key fail")
+	}
+}
+
+func TestZRangeStore(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zadd", "z", "1", "a", "2", "b", "3", "c")
+	if !output.isInt(3) {
+		t.Fatal("zrangestore prepare fail")
+	}
+
+	output = ts.ProcessCommand("zrangestore", "dest", "z", "(1", "+inf", "byscore")
+	if !output.isInt(2) {
+		t.Fatal("zrangestore step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "dest", "0", "-1", "withscores")
+	if !isZPairs(output, "b", 2.0, "c", 3.0) {
+		t.Fatal("zrangestore step 2 fail")
+	}
+
+	output = ts.ProcessCommand("zrangestore", "dest", "z", "5", "10")
+	if !output.isInt(0) {
+		t.Fatal("zrangestore step 3 fail")
+	}
+
+	output = ts.ProcessCommand("exists", "dest")
+	if !output.isInt(0) {
+		t.Fatal("zrangestore empty result fail")
+	}
+}
+
+func TestZCountZLexCount(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zadd", "z", "1", "a", "2", "b", "3", "c", "4", "d")
+	if !output.isInt(4) {
+		t.Fatal("zcount prepare fail")
+	}
+
+	output = ts.ProcessCommand("zcount", "z", "-inf", "+inf")
+	if !output.isInt(4) {
+		t.Fatal("zcount all fail")
+	}
+
+	output = ts.ProcessCommand("zcount", "z", "(1", "3")
+	if !output.isInt(2) {
+		t.Fatal("zcount range fail")
+	}
+
+	output = ts.ProcessCommand("zcount", "z", "3", "1")
+	if !output.isInt(0) {
+		t.Fatal("zcount inverted range fail")
+	}
+
+	output = ts.ProcessCommand("zcount", "z", "a", "1")
+	if !output.isErrorType() {
+		t.Fatal("zcount invalid range fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "lex", "0", "a", "0", "b", "0", "c", "0", "d")
+	if !output.isInt(4) {
+		t.Fatal("zlexcount prepare fail")
+	}
+
+	output = ts.ProcessCommand("zlexcount", "lex", "-", "+")
+	if !output.isInt(4) {
+		t.Fatal("zlexcount all fail")
+	}
+
+	output = ts.ProcessCommand("zlexcount", "lex", "[b", "(d")
+	if !output.isInt(2) {
+		t.Fatal("zlexcount range fail")
+	}
+
+	output = ts.ProcessCommand("zlexcount", "missing", "-", "+")
+	if !output.isInt(0) {
+		t.Fatal("zlexcount missing key fail")
+	}
+}
+
+func TestZRemRange(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zadd", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e")
+	if !output.isInt(5) {
+		t.Fatal("zremrange prepare fail")
+	}
+
+	output = ts.ProcessCommand("zremrangebyrank", "z", "0", "1")
+	if !output.isInt(2) {
+		t.Fatal("zremrangebyrank fail")
+	}
+
+	output = ts.ProcessCommand("zremrangebyscore", "z", "(3", "4")
+	if !output.isInt(1) {
+		t.Fatal("zremrangebyscore fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z", "0", "-1")
+	if !output.isArray("c", "e") {
+		t.Fatal("zremrange verify fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "lex", "0", "a", "0", "b", "0", "c")
+	if !output.isInt(3) {
+		t.Fatal("zremrangebylex prepare fail")
+	}
+
+	output = ts.ProcessCommand("zremrangebylex", "lex", "[a", "(c")
+	if !output.isInt(2) {
+		t.Fatal("zremrangebylex step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zremrangebylex", "lex", "-", "+")
+	if !output.isInt(1) {
+		t.Fatal("zremrangebylex step 2 fail")
+	}
+
+	output = ts.ProcessCommand("exists", "lex")
+	if !output.isInt(0) {
+		t.Fatal("zremrangebylex empty key removal fail")
+	}
+}
+
+func TestZPop(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zpopmin", "z")
+	if !output.isArray() {
+		t.Fatal("zpopmin missing key fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "1", "a", "2", "b", "3", "c", "4", "d")
+	if !output.isInt(4) {
+		t.Fatal("zpop prepare fail")
+	}
+
+	output = ts.ProcessCommand("zpopmin", "z")
+	if !output.isArray("a", 1.0) {
+		t.Fatal("zpopmin fail")
+	}
+
+	output = ts.ProcessCommand("zpopmax", "z", "2")
+	if !isZPairs(output, "d", 4.0, "c", 3.0) {
+		t.Fatal("zpopmax count fail")
+	}
+
+	output = ts.ProcessCommand("zpopmax", "z", "-1")
+	if !output.isErrorType() {
+		t.Fatal("zpopmax negative count fail")
+	}
+
+	output = ts.ProcessCommand("zpopmin", "z", "10")
+	if !isZPairs(output, "b", 2.0) {
+		t.Fatal("zpopmin last fail")
+	}
+
+	output = ts.ProcessCommand("exists", "z")
+	if !output.isInt(0) {
+		t.Fatal("zpopmin empty key removal fail")
+	}
+}
+
+func TestZMPop(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zmpop", "2", "z1", "z2", "min")
+	if !output.isNull() {
+		t.Fatal("zmpop missing keys fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z2", "1", "a", "2", "b", "3", "c")
+	if !output.isInt(3) {
+		t.Fatal("zmpop prepare fail")
+	}
+
+	output = ts.ProcessCommand("zmpop", "2", "z1", "z2", "max", "count", "2")
+	if !output.isValue([]any{"z2", []any{[]any{"c", 3.0}, []any{"b", 2.0}}}) {
+		t.Fatal("zmpop max fail")
+	}
+
+	output = ts.ProcessCommand("zmpop", "1", "z2", "min", "count", "5")
+	if !output.isValue([]any{"z2", []any{[]any{"a", 1.0}}}) {
+		t.Fatal("zmpop min fail")
+	}
+
+	output = ts.ProcessCommand("zmpop", "1", "z2", "min", "count", "0")
+	if !output.isErrorType() {
+		t.Fatal("zmpop zero count fail")
+	}
+
+	output = ts.ProcessCommand("zmpop", "2", "z2", "min")
+	if !output.isErrorType() {
+		t.Fatal("zmpop numkeys fail")
+	}
+}
+
+func TestBZPopMin(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	// timeout
+	output := ts.ProcessCommand("bzpopmin", "z1", "z2", "0.010")
+	if !output.isNull() {
+		t.Fatal("bzpopmin timeout fail")
+	}
+
+	output = ts.ProcessCommand("bzpopmin", "z1", "-1")
+	if !output.isErrorType() {
+		t.Fatal("bzpopmin negative timeout fail")
+	}
+
+	// immediate
+	output = ts.ProcessCommand("zadd", "z2", "1", "a", "2", "b")
+	if !output.isInt(2) {
+		t.Fatal("bzpopmin prepare fail")
+	}
+
+	output = ts.ProcessCommand("bzpopmax", "z1", "z2", "0")
+	if !output.isArray("z2", "b", 2.0) {
+		t.Fatal("bzpopmax immediate fail")
+	}
+
+	output = ts.ProcessCommand("bzpopmin", "z1", "z2", "0")
+	if !output.isArray("z2", "a", 1.0) {
+		t.Fatal("bzpopmin immediate fail")
+	}
+
+	// blocked until another client adds
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		output = ts.ProcessCommand("bzpopmin", "z1", "z2", "0")
+		wg.Done()
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+
+	output2 := ts2.ProcessCommand("zadd", "z1", "5", "x", "3", "y")
+	if !output2.isInt(2) {
+		t.Fatal("bzpopmin wake add fail")
+	}
+
+	wg.Wait()
+
+	if !output.isArray("z1", "y", 3.0) {
+		t.Fatal("bzpopmin wake fail")
+	}
+
+	// wrong type
+	output = ts.ProcessCommand("set", "str", "text")
+	if !output.isString("OK") {
+		t.Fatal("bzpopmin prepare string fail")
+	}
+
+	output = ts.ProcessCommand("bzpopmin", "str", "0")
+	if !output.isErrorType() {
+		t.Fatal("bzpopmin wrong type fail")
+	}
+}
+
+func TestBZMPop(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+
+	output := ts.ProcessCommand("bzmpop", "0.010", "1", "z", "min")
+	if !output.isNull() {
+		t.Fatal("bzmpop timeout fail")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		output = ts.ProcessCommand("bzmpop", "0", "2", "z1", "z2", "max", "count", "2")
+		wg.Done()
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+
+	output2 := ts2.ProcessCommand("zadd", "z2", "1", "a", "2", "b", "3", "c")
+	if !output2.isInt(3) {
+		t.Fatal("bzmpop wake add fail")
+	}
+
+	wg.Wait()
+
+	if !output.isValue([]any{"z2", []any{[]any{"c", 3.0}, []any{"b", 2.0}}}) {
+		t.Fatal("bzmpop wake fail")
+	}
+
+	// the blocked pop of another client is woken by a store operation
+	wg.Add(1)
+	go func() {
+		output = ts.ProcessCommand("bzpopmax", "dest", "0")
+		wg.Done()
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+
+	output2 = ts2.ProcessCommand("zunionstore", "dest", "1", "z2")
+	if !output2.isInt(1) {
+		t.Fatal("bzmpop store fail")
+	}
+
+	wg.Wait()
+
+	if !output.isArray("dest", "a", 1.0) {
+		t.Fatal("bzmpop wake by store fail")
+	}
+}
+
+func TestZRandMember(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zrandmember", "z")
+	if !output.isNull() {
+		t.Fatal("zrandmember missing key fail")
+	}
+
+	output = ts.ProcessCommand("zrandmember", "z", "2")
+	if !output.isArray() {
+		t.Fatal("zrandmember missing key count fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "1", "a", "2", "b", "3", "c")
+	if !output.isInt(3) {
+		t.Fatal("zrandmember prepare fail")
+	}
+
+	output = ts.ProcessCommand("zrandmember", "z")
+	if !output.isOneOf("a", "b", "c") {
+		t.Fatal("zrandmember single fail")
+	}
+
+	output = ts.ProcessCommand("zrandmember", "z", "5")
+	if !output.isArraySet("a", "b", "c") {
+		t.Fatal("zrandmember unique fail")
+	}
+
+	output = ts.ProcessCommand("zrandmember", "z", "-5")
+	a, _ := output.toArray()
+	if len(a) != 5 {
+		t.Fatal("zrandmember repeating fail")
+	}
+
+	output = ts.ProcessCommand("zrandmember", "z", "1", "withscores")
+	pairs, _ := output.toPairs()
+	if len(pairs) != 1 {
+		t.Fatal("zrandmember withscores step 1 fail")
+	}
+
+	member, _ := pairs[0].key.toString()
+	expected := map[string]float64{"a": 1, "b": 2, "c": 3}
+	if !pairs[0].value.isFloat(expected[member], -1) {
+		t.Fatal("zrandmember withscores step 2 fail")
+	}
+}
+
+func TestZScan(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zscan", "z", "0")
+	if !output.isValue([]any{"0", []any{}}) {
+		t.Fatal("zscan missing key fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z", "1", "apple", "2.5", "banana", "3", "cherry")
+	if !output.isInt(3) {
+		t.Fatal("zscan prepare fail")
+	}
+
+	output = ts.ProcessCommand("zscan", "z", "0", "match", "b*")
+	if !output.isValue([]any{"0", []any{"banana", "2.5"}}) {
+		t.Fatal("zscan match fail")
+	}
+
+	output = ts.ProcessCommand("zscan", "z", "0", "count", "100")
+	a, _ := output.toArray()
+	if len(a) != 2 {
+		t.Fatal("zscan all step 1 fail")
+	}
+	if !a[1].isArrayInMap(3, map[any]any{"apple": "1", "banana": "2.5", "cherry": "3"}) {
+		t.Fatal("zscan all step 2 fail")
+	}
+}
+
+func TestZSetOperations(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zadd", "z1", "1", "a", "2", "b", "3", "c")
+	if !output.isInt(3) {
+		t.Fatal("zset operations prepare step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z2", "10", "b", "20", "c", "30", "d")
+	if !output.isInt(3) {
+		t.Fatal("zset operations prepare step 2 fail")
+	}
+
+	output = ts.ProcessCommand("sadd", "s", "c", "d")
+	if !output.isInt(2) {
+		t.Fatal("zset operations prepare step 3 fail")
+	}
+
+	// union
+	output = ts.ProcessCommand("zunion", "2", "z1", "z2", "withscores")
+	if !isZPairs(output, "a", 1.0, "b", 12.0, "c", 23.0, "d", 30.0) {
+		t.Fatal("zunion fail")
+	}
+
+	output = ts.ProcessCommand("zunion", "2", "z1", "z2", "weights", "2", "0.5", "aggregate", "max", "withscores")
+	if !isZPairs(output, "a", 2.0, "b", 5.0, "c", 10.0, "d", 15.0) {
+		t.Fatal("zunion weights fail")
+	}
+
+	output = ts.ProcessCommand("zunionstore", "dest", "3", "z1", "s", "missing", "aggregate", "min")
+	if !output.isInt(4) {
+		t.Fatal("zunionstore step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "dest", "0", "-1", "withscores")
+	if !isZPairs(output, "a", 1.0, "c", 1.0, "d", 1.0, "b", 2.0) {
+		t.Fatal("zunionstore step 2 fail")
+	}
+
+	// intersection
+	output = ts.ProcessCommand("zinter", "2", "z1", "z2", "withscores")
+	if !isZPairs(output, "b", 12.0, "c", 23.0) {
+		t.Fatal("zinter fail")
+	}
+
+	output = ts.ProcessCommand("zinter", "3", "z1", "z2", "s")
+	if !output.isArray("c") {
+		t.Fatal("zinter with set fail")
+	}
+
+	output = ts.ProcessCommand("zinter", "2", "z1", "missing")
+	if !output.isArray() {
+		t.Fatal("zinter missing fail")
+	}
+
+	output = ts.ProcessCommand("zinterstore", "dest", "2", "z1", "z2", "aggregate", "min")
+	if !output.isInt(2) {
+		t.Fatal("zinterstore step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "dest", "0", "-1", "withscores")
+	if !isZPairs(output, "b", 2.0, "c", 3.0) {
+		t.Fatal("zinterstore step 2 fail")
+	}
+
+	output = ts.ProcessCommand("zintercard", "2", "z1", "z2")
+	if !output.isInt(2) {
+		t.Fatal("zintercard fail")
+	}
+
+	output = ts.ProcessCommand("zintercard", "2", "z1", "z2", "limit", "1")
+	if !output.isInt(1) {
+		t.Fatal("zintercard limit fail")
+	}
+
+	// difference
+	output = ts.ProcessCommand("zdiff", "2", "z1", "z2", "withscores")
+	if !isZPairs(output, "a", 1.0) {
+		t.Fatal("zdiff fail")
+	}
+
+	output = ts.ProcessCommand("zdiffstore", "dest", "2", "z2", "s")
+	if !output.isInt(1) {
+		t.Fatal("zdiffstore step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "dest", "0", "-1")
+	if !output.isArray("b") {
+		t.Fatal("zdiffstore step 2 fail")
+	}
+
+	output = ts.ProcessCommand("zdiffstore", "dest", "2", "z1", "z1")
+	if !output.isInt(0) {
+		t.Fatal("zdiffstore step 3 fail")
+	}
+
+	output = ts.ProcessCommand("exists", "dest")
+	if !output.isInt(0) {
+		t.Fatal("zdiffstore step 4 fail")
+	}
+
+	// argument errors
+	output = ts.ProcessCommand("zunion", "3", "z1", "z2")
+	if !output.isErrorType() {
+		t.Fatal("zunion numkeys fail")
+	}
+
+	output = ts.ProcessCommand("zunion", "2", "z1", "z2", "weights", "1")
+	if !output.isErrorType() {
+		t.Fatal("zunion weights count fail")
+	}
+
+	output = ts.ProcessCommand("set", "str", "value")
+	if !output.isString("OK") {
+		t.Fatal("zset operations prepare string fail")
+	}
+
+	output = ts.ProcessCommand("zunion", "2", "z1", "str")
+	if !output.isErrorType() {
+		t.Fatal("zunion wrong type fail")
+	}
+}
+
+func TestZSetKeyType(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("zadd", "z", "1", "a", "2", "b")
+	if !output.isInt(2) {
+		t.Fatal("zset type prepare step 1 fail")
+	}
+
+	output = ts.ProcessCommand("sadd", "s", "a")
+	if !output.isInt(1) {
+		t.Fatal("zset type prepare step 2 fail")
+	}
+
+	output = ts.ProcessCommand("type", "z")
+	if !output.isString("zset") {
+		t.Fatal("zset type fail")
+	}
+
+	output = ts.ProcessCommand("scan", "0", "type", "zset")
+	if !output.isValue([]any{"0", []any{"z"}}) {
+		t.Fatal("zset scan type fail")
+	}
+
+	output = ts.ProcessCommand("copy", "z", "z2")
+	if !output.isInt(1) {
+		t.Fatal("zset copy step 1 fail")
+	}
+
+	output = ts.ProcessCommand("zadd", "z2", "3", "c")
+	if !output.isInt(1) {
+		t.Fatal("zset copy step 2 fail")
+	}
+
+	output = ts.ProcessCommand("zcard", "z")
+	if !output.isInt(2) {
+		t.Fatal("zset copy step 3 fail")
+	}
+
+	output = ts.ProcessCommand("zrange", "z2", "0", "-1")
+	if !output.isArray("a", "b", "c") {
+		t.Fatal("zset copy step 4 fail")
+	}
+}
+
+func TestZSetPersist(t *testing.T) {
+	ds := newDataStore()
+	dsc := ds.newDataStoreCommand()
+
+	output := dsc.zadd("z", []string{"a", "b", "c"}, []float64{3, math.Inf(-1), 1.5}, 0)
+	if !output.isInt(3) {
+		t.Fatal("zset persist prepare fail")
+	}
+
+	fileName := filepath.Join(t.TempDir(), "zset.db0")
+	if err := ds.save(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	ds2 := newDataStore()
+	if err := ds2.load(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	dsc2 := ds2.newDataStoreCommand()
+	output = dsc2.zrange("z", &zrangeSpec{start: 0, stop: -1, limit: -1}, true)
+	if !isZPairs(output, "b", math.Inf(-1), "c", 1.5, "a", 3.0) {
+		t.Fatal("zset persist round trip fail")
+	}
+}
+
+func TestZSetOrderRandom(t *testing.T) {
+	z := newZSet()
+	expected := map[string]float64{}
+
+	for i := 0; i < 5000; i++ {
+		member := fmt.Sprintf("m%d", rand.Intn(500))
+		if rand.Intn(4) == 0 {
+			z.remove(member)
+			delete(expected, member)
+		} else {
+			score := float64(rand.Intn(200) - 100)
+			z.set(member, score)
+			expected[member] = score
+		}
+	}
+
+	items := make([]zsetItem, 0, len(expected))
+	for member, score := range expected {
+		items = append(items, zsetItem{member, score})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].score != items[j].score {
+			return items[i].score < items[j].score
+		}
+		return items[i].member < items[j].member
+	})
+
+	if z.count() != len(items) {
+		t.Fatal("zset random count fail")
+	}
+
+	idx := 0
+	z.iterate(false, func(member string, score float64) bool {
+		if items[idx].member != member || items[idx].score != score {
+			t.Fatalf("zset random order fail at %d", idx)
+		}
+		idx++
+		return true
+	})
+
+	// spot check ranks and score ranges
+	for i := 0; i < len(items); i += 37 {
+		rank, _, exists := z.rank(items[i].member, false)
+		if !exists || items[rank].member != items[i].member {
+			t.Fatal("zset random rank fail")
+		}
+
+		r := &zscoreRange{min: items[i].score, max: items[i].score + 10, minExclusive: true}
+		count := 0
+		for _, item := range items {
+			if r.gteMin(item.score) && r.lteMax(item.score) {
+				count++
+			}
+		}
+		found := 0
+		z.iterateScoreRange(r, false, func(member string, score float64) bool {
+			found++
+			return true
+		})
+		if found != count {
+			t.Fatal("zset random score range fail")
+		}
+	}
+}
@@ -0,0 +1,639 @@
+package redisemu
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var errInvalidStartId = respErrorString("ERR invalid start ID for the interval")
+var errInvalidEndId = respErrorString("ERR invalid end ID for the interval")
+
+// parses the trim block of XADD and XTRIM
+func parseStreamTrim(trimArgs *orderedMap) (spec *streamTrimSpec, errText respErrorString) {
+	spec = &streamTrimSpec{}
+	_, spec.byMinId = trimArgs.get("strategy.minid")
+	_, spec.approx = trimArgs.get("operator.approximately")
+	threshold := trimArgs.mustGet("threshold").(string)
+
+	limit, hasLimit := trimArgs.mustGet("count").(int64)
+	if hasLimit {
+		if !spec.approx {
+			errText = respErrorString("ERR syntax error, LIMIT cannot be used without the special ~ option")
+			return
+		}
+		if limit < 0 {
+			errText = respErrorString("ERR The LIMIT argument must be >= 0.")
+			return
+		}
+		spec.limit = limit
+	} else if spec.approx {
+		spec.limit = 100 * streamNodeMaxEntries
+	}
+
+	if spec.byMinId {
+		id, valid := parseStreamId(threshold, 0)
+		if !valid {
+			errText = errInvalidStreamId
+			return
+		}
+		spec.minId = id
+	} else {
+		maxLen, err := strconv.ParseInt(threshold, 10, 64)
+		if err != nil {
+			errText = errNotInteger
+			return
+		}
+		if maxLen < 0 {
+			errText = respErrorString("ERR The MAXLEN argument must be >= 0.")
+			return
+		}
+		spec.maxLen = maxLen
+	}
+	return
+}
+
+// parses a list of ids that must be complete, such as the ids given to XDEL or XACK
+func parseStreamIdList(idArgs []any) (ids []streamId, errText respErrorString) {
+	ids = make([]streamId, 0, len(idArgs))
+	for _, idArg := range idArgs {
+		id, valid := parseStreamId(idArg.(string), 0)
+		if !valid {
+			errText = errInvalidStreamId
+			return
+		}
+		ids = append(ids, id)
+	}
+	return
+}
+
+// parses an inclusive range from start and end arguments that can be
+// exclusive with a "(" prefix
+func parseStreamRange(startText, endText string) (start, end streamId, errText respErrorString) {
+	start, exclusive, valid := parseStreamRangeBound(startText, false)
+	if !valid {
+		errText = errInvalidStreamId
+		return
+	}
+	if exclusive {
+		if start, valid = start.next(); !valid {
+			errText = errInvalidStartId
+			return
+		}
+	}
+
+	end, exclusive, valid = parseStreamRangeBound(endText, true)
+	if !valid {
+		errText = errInvalidStreamId
+		return
+	}
+	if exclusive {
+		if end, valid = end.prev(); !valid {
+			errText = errInvalidEndId
+			return
+		}
+	}
+	return
+}
+
+// parses a min-idle-time argument of XCLAIM or XAUTOCLAIM
+func parseStreamMinIdle(text, cmdName string) (minIdle int64, errText respErrorString) {
+	minIdle, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		errText = respErrorString(fmt.Sprintf("ERR Invalid min-idle-time argument for %s", cmdName))
+		return
+	}
+	if minIdle < 0 {
+		minIdle = 0
+	}
+	return
+}
+
+// parses the STREAMS arguments of XREAD and XREADGROUP, which are the key
+// names followed by the same number of ids
+func parseStreamReads(args map[string]any, isGroup bool) (reqs []streamReadRequest, keyNames []string, errText respErrorString) {
+	streamArgs := args["streams"].(*orderedMap)
+	keyArgs := streamArgs.mustGet("key").([]any)
+	idArgs, _ := streamArgs.mustGet("id").([]any)
+
+	// the parser can't tell keys from ids, so it usually puts all of them
+	// in the key list; the split is made here
+	all := append(append([]any{}, keyArgs...), idArgs...)
+	if len(all)%2 != 0 {
+		cmdName, wildcard := "xread", "$"
+		if isGroup {
+			cmdName, wildcard = "xreadgroup", ">"
+		}
+		errText = respErrorString(fmt.Sprintf("ERR Unbalanced '%s' list of streams: for each stream key an ID or '%s' must be specified.", cmdName, wildcard))
+		return
+	}
+
+	half := len(all) / 2
+	reqs = make([]streamReadRequest, 0, half)
+	keyNames = make([]string, 0, half)
+	for i := 0; i < half; i++ {
+		req := streamReadRequest{keyName: all[i].(string)}
+		idText := all[half+i].(string)
+
+		switch idText {
+		case "$":
+			if isGroup {
+				errText = respErrorString("ERR The $ ID is meaningful only for XREAD command")
+				return
+			}
+			req.last = true
+		case ">":
+			if !isGroup {
+				errText = respErrorString("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+				return
+			}
+			req.newOnly = true
+		default:
+			id, valid := parseStreamId(idText, 0)
+			if !valid {
+				errText = errInvalidStreamId
+				return
+			}
+			req.id = id
+		}
+
+		reqs = append(reqs, req)
+		keyNames = append(keyNames, req.keyName)
+	}
+	return
+}
+
+// gets the COUNT and BLOCK options of XREAD and XREADGROUP; a count of 0 is
+// no limit, and a negative timeout is no blocking
+func parseStreamReadOptions(args map[string]any) (count int, timeoutNs int64, errText respErrorString) {
+	if c, exists := args["count"].(int64); exists && c > 0 {
+		count = int(c)
+	}
+
+	timeoutNs = -1
+	if ms, exists := args["milliseconds"].(int64); exists {
+		if ms < 0 {
+			errText = respErrorString("ERR timeout is negative")
+			return
+		}
+		timeoutNs = ms * int64(time.Millisecond)
+	}
+	return
+}
+
+func fnXAdd(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	_, noMkStream := args["nomkstream"]
+	data := args["data"].([]any)
+
+	idText, hasId := args["id-selector.id"].(string)
+	if !hasId {
+		idText = "*"
+	}
+	idSpec, valid := parseStreamAddId(idText)
+	if !valid {
+		output.data = errInvalidStreamId
+		return
+	}
+
+	var trim *streamTrimSpec
+	if trimArgs, hasTrim := args["trim"].(*orderedMap); hasTrim {
+		var errText respErrorString
+		if trim, errText = parseStreamTrim(trimArgs); errText != "" {
+			output.data = errText
+			return
+		}
+	}
+
+	fields := make([]string, 0, len(data)*2)
+	for _, pair := range data {
+		m := pair.(*orderedMap)
+		fields = append(fields, m.mustGet("field").(string), m.mustGet("value").(string))
+	}
+
+	output = ctx.dsc.xadd(keyName, idSpec, noMkStream, fields, trim)
+	return
+}
+
+func fnXLen(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	output = ctx.dsc.xlen(keyName)
+	return
+}
+
+func xrangeCommon(ctx *cmdContext, args map[string]any, reverse bool) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	start, end, errText := parseStreamRange(args["start"].(string), args["end"].(string))
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	count := -1
+	if c, exists := args["count"].(int64); exists {
+		if c <= 0 {
+			output.data = respArray{}
+			return
+		}
+		count = int(c)
+	}
+
+	output = ctx.dsc.xrange(keyName, start, end, count, reverse)
+	return
+}
+
+func fnXRange(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	return xrangeCommon(ctx, args, false)
+}
+
+func fnXRevRange(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	return xrangeCommon(ctx, args, true)
+}
+
+func fnXDel(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	ids, errText := parseStreamIdList(args["id"].([]any))
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.xdel(keyName, ids)
+	return
+}
+
+func fnXTrim(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	trim, errText := parseStreamTrim(args["trim"].(*orderedMap))
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.xtrim(keyName, trim)
+	return
+}
+
+func fnXSetId(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	lastId, valid := parseStreamId(args["last-id"].(string), 0)
+	if !valid {
+		output.data = errInvalidStreamId
+		return
+	}
+
+	var entriesAdded *int64
+	if n, exists := args["entries-added"].(int64); exists {
+		if n < 0 {
+			output.data = respErrorString("ERR entries_added must be positive")
+			return
+		}
+		entriesAdded = &n
+	}
+
+	var maxDeletedId *streamId
+	if text, exists := args["max-deleted-id"].(string); exists {
+		id, valid := parseStreamId(text, 0)
+		if !valid {
+			output.data = errInvalidStreamId
+			return
+		}
+		if lastId.compare(id) < 0 {
+			output.data = respErrorString("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+			return
+		}
+		maxDeletedId = &id
+	}
+
+	output = ctx.dsc.xsetid(keyName, lastId, entriesAdded, maxDeletedId)
+	return
+}
+
+func fnXRead(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	reqs, keyNames, errText := parseStreamReads(args, false)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	count, timeoutNs, errText := parseStreamReadOptions(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	// "$" refers to the last entry at the time of the call, not when unblocked
+	if fnErr := ctx.dsc.xreadResolve(reqs); fnErr != nil {
+		output.data = *fnErr
+		return
+	}
+
+	read := func() (output respValue) {
+		results, fnErr := ctx.dsc.xread(reqs, count)
+		if fnErr != nil {
+			output.data = *fnErr
+			return
+		}
+		output = streamReadResultsToResp(results, ctx.cs.respVersion)
+		return
+	}
+
+	if timeoutNs < 0 {
+		output = read()
+	} else {
+		output = blockOnListChangeMultiKey(ctx, keyNames, timeoutNs, read)
+	}
+	return
+}
+
+func fnXReadGroup(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	groupArgs := args["group-block"].(*orderedMap)
+	groupName := groupArgs.mustGet("group").(string)
+	consumerName := groupArgs.mustGet("consumer").(string)
+	_, noAck := args["noack"]
+
+	reqs, keyNames, errText := parseStreamReads(args, true)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	count, timeoutNs, errText := parseStreamReadOptions(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	read := func() (output respValue) {
+		results, errText := ctx.dsc.xreadgroup(groupName, consumerName, reqs, count, noAck)
+		if errText != "" {
+			output.data = errText
+			return
+		}
+		output = streamReadResultsToResp(results, ctx.cs.respVersion)
+		return
+	}
+
+	// a read of pending history always replies, so only reads of new entries block
+	if timeoutNs < 0 {
+		output = read()
+	} else {
+		output = blockOnListChangeMultiKey(ctx, keyNames, timeoutNs, read)
+	}
+	return
+}
+
+func fnXAck(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	groupName := args["group"].(string)
+
+	ids, errText := parseStreamIdList(args["id"].([]any))
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.xack(keyName, groupName, ids)
+	return
+}
+
+func fnXPending(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	groupName := args["group"].(string)
+
+	var filter *streamPendingFilter
+	if filters, exists := args["filters"].(*orderedMap); exists {
+		filter = &streamPendingFilter{}
+
+		var errText respErrorString
+		filter.start, filter.end, errText = parseStreamRange(filters.mustGet("start").(string), filters.mustGet("end").(string))
+		if errText != "" {
+			output.data = errText
+			return
+		}
+
+		if minIdle, exists := filters.mustGet("min-idle-time").(int64); exists {
+			filter.minIdle = minIdle
+		}
+		if count := filters.mustGet("count").(int64); count > 0 {
+			filter.count = int(count)
+		}
+		if consumer, exists := filters.mustGet("consumer").(string); exists {
+			filter.consumer = consumer
+		}
+	}
+
+	output = ctx.dsc.xpending(keyName, groupName, filter)
+	return
+}
+
+func fnXClaim(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	groupName := args["group"].(string)
+	consumerName := args["consumer"].(string)
+
+	minIdle, errText := parseStreamMinIdle(args["min-idle-time"].(string), "XCLAIM")
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	ids, errText := parseStreamIdList(args["id"].([]any))
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	opts := &streamClaimOptions{retryCount: -1}
+	if ms, exists := args["ms"].(int64); exists {
+		deliveryTime := time.Now().Add(-time.Duration(ms) * time.Millisecond)
+		opts.deliveryTime = &deliveryTime
+	}
+	if unixMs, exists := args["unix-time-milliseconds"].(int64); exists {
+		deliveryTime := time.UnixMilli(unixMs)
+		opts.deliveryTime = &deliveryTime
+	}
+	if count, exists := args["count"].(int64); exists {
+		opts.retryCount = count
+	}
+	_, opts.force = args["force"]
+	_, opts.justId = args["justid"]
+	if text, exists := args["lastid"].(string); exists {
+		id, valid := parseStreamId(text, 0)
+		if !valid {
+			output.data = errInvalidStreamId
+			return
+		}
+		opts.lastId = &id
+	}
+
+	output = ctx.dsc.xclaim(keyName, groupName, consumerName, minIdle, ids, opts)
+	return
+}
+
+func fnXAutoClaim(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	groupName := args["group"].(string)
+	consumerName := args["consumer"].(string)
+	_, justId := args["justid"]
+
+	minIdle, errText := parseStreamMinIdle(args["min-idle-time"].(string), "XAUTOCLAIM")
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	start, exclusive, valid := parseStreamRangeBound(args["start"].(string), false)
+	if !valid {
+		output.data = errInvalidStreamId
+		return
+	}
+	if exclusive {
+		if start, valid = start.next(); !valid {
+			output.data = errInvalidStartId
+			return
+		}
+	}
+
+	count := int64(100)
+	if c, exists := args["count"].(int64); exists {
+		if c < 1 {
+			output.data = respErrorString("ERR COUNT must be > 0")
+			return
+		}
+		count = c
+	}
+
+	output = ctx.dsc.xautoclaim(keyName, groupName, consumerName, minIdle, start, int(count), justId)
+	return
+}
+
+// parses the id argument of XGROUP CREATE and SETID; a nil id is "$"
+func parseStreamGroupId(args map[string]any) (id *streamId, errText respErrorString) {
+	idText, exists := args["id-selector.id"].(string)
+	if !exists || idText == "$" {
+		return
+	}
+
+	parsed, valid := parseStreamId(idText, 0)
+	if !valid {
+		errText = errInvalidStreamId
+		return
+	}
+	id = &parsed
+	return
+}
+
+// parses the ENTRIESREAD option of XGROUP CREATE and SETID
+func parseStreamEntriesRead(args map[string]any, name string) (entriesRead int64, errText respErrorString) {
+	entriesRead, exists := args[name].(int64)
+	if !exists {
+		entriesRead = streamEntriesReadInvalid
+	} else if entriesRead < 0 && entriesRead != streamEntriesReadInvalid {
+		errText = respErrorString("ERR value for ENTRIESREAD must be positive or -1")
+	}
+	return
+}
+
+func fnXGroupCreate(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	groupName := args["group"].(string)
+	_, mkStream := args["mkstream"]
+
+	id, errText := parseStreamGroupId(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	entriesRead, errText := parseStreamEntriesRead(args, "entries-read")
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.xgroupCreate(keyName, groupName, id, mkStream, entriesRead)
+	return
+}
+
+func fnXGroupSetId(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	groupName := args["group"].(string)
+
+	id, errText := parseStreamGroupId(args)
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	entriesRead, errText := parseStreamEntriesRead(args, "entriesread")
+	if errText != "" {
+		output.data = errText
+		return
+	}
+
+	output = ctx.dsc.xgroupSetId(keyName, groupName, id, entriesRead)
+	return
+}
+
+func fnXGroupDestroy(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	groupName := args["group"].(string)
+
+	output = ctx.dsc.xgroupDestroy(keyName, groupName)
+	return
+}
+
+func fnXGroupCreateConsumer(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	groupName := args["group"].(string)
+	consumerName := args["consumer"].(string)
+
+	output = ctx.dsc.xgroupCreateConsumer(keyName, groupName, consumerName)
+	return
+}
+
+func fnXGroupDelConsumer(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	groupName := args["group"].(string)
+	consumerName := args["consumer"].(string)
+
+	output = ctx.dsc.xgroupDelConsumer(keyName, groupName, consumerName)
+	return
+}
+
+func fnXInfoStream(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	full := false
+	count := 10
+	if fullArgs, exists := args["full-block"].(*orderedMap); exists {
+		full = true
+		if c, exists := fullArgs.mustGet("count").(int64); exists {
+			count = int(c)
+		}
+	}
+
+	output = ctx.dsc.xinfoStream(keyName, full, count)
+	return
+}
+
+func fnXInfoGroups(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+
+	output = ctx.dsc.xinfoGroups(keyName)
+	return
+}
+
+func fnXInfoConsumers(ctx *cmdContext, args map[string]any) (output respValue, err error) {
+	keyName := args["key"].(string)
+	groupName := args["group"].(string)
+
+	output = ctx.dsc.xinfoConsumers(keyName, groupName)
+	return
+}
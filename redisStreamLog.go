@@ -0,0 +1,793 @@
+package redisemu
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// a stream entry id; ms is the creation time and seq orders the entries
+	// created within the same millisecond
+	streamId struct {
+		ms  uint64
+		seq uint64
+	}
+
+	streamEntry struct {
+		id     streamId
+		fields []string // field, value, field, value, ...
+	}
+
+	// a stream keeps its entries in a slice ordered by id; new entries always
+	// have the greatest id, so adding is an append, and lookups are a binary search
+	stream struct {
+		entries      []*streamEntry
+		lastId       streamId
+		maxDeletedId streamId
+		entriesAdded int64
+		groups       map[string]*streamGroup
+	}
+
+	streamGroup struct {
+		name        string
+		lastId      streamId
+		entriesRead int64                 // streamEntriesReadInvalid when unknown
+		pending     []*streamPendingEntry // the pending entries list (PEL), ordered by id
+		consumers   map[string]*streamConsumer
+	}
+
+	// an entry delivered to a consumer that has not been acknowledged yet
+	streamPendingEntry struct {
+		id            streamId
+		consumer      *streamConsumer
+		deliveryTime  time.Time
+		deliveryCount int64
+	}
+
+	streamConsumer struct {
+		name       string
+		seenTime   time.Time // last attempted interaction
+		activeTime time.Time // last successful interaction, zero if never
+	}
+
+	// describes the XADD id argument
+	streamAddId struct {
+		auto    bool // "*"
+		autoSeq bool // "ms-*"
+		id      streamId
+	}
+
+	// trimming as specified by the MAXLEN or MINID options of XADD and XTRIM
+	streamTrimSpec struct {
+		byMinId bool
+		maxLen  int64
+		minId   streamId
+		approx  bool
+		limit   int64 // 0 for no limit
+	}
+
+	// one key of an XREAD or XREADGROUP request
+	streamReadRequest struct {
+		keyName string
+		id      streamId
+		last    bool // "$" - resolved to the last id before reading
+		newOnly bool // ">" - entries never delivered to the group
+	}
+
+	streamReadResult struct {
+		keyName string
+		entries respArray
+	}
+
+	// options of XCLAIM
+	streamClaimOptions struct {
+		deliveryTime *time.Time
+		retryCount   int64 // -1 when not specified
+		force        bool
+		justId       bool
+		lastId       *streamId
+	}
+
+	// filters of the extended form of XPENDING
+	streamPendingFilter struct {
+		minIdle  int64
+		start    streamId
+		end      streamId
+		count    int
+		consumer string
+	}
+
+	persistStream struct {
+		Entries      []persistStreamEntry
+		LastId       string
+		MaxDeletedId string
+		EntriesAdded int64
+		Groups       []persistStreamGroup
+	}
+
+	persistStreamEntry struct {
+		Id     string
+		Fields []string
+	}
+
+	persistStreamGroup struct {
+		Name        string
+		LastId      string
+		EntriesRead int64
+		Pending     []persistStreamPendingEntry
+		Consumers   []persistStreamConsumer
+	}
+
+	persistStreamPendingEntry struct {
+		Id            string
+		Consumer      string
+		DeliveryTime  time.Time
+		DeliveryCount int64
+	}
+
+	persistStreamConsumer struct {
+		Name       string
+		SeenTime   time.Time
+		ActiveTime time.Time
+	}
+)
+
+const (
+	// approximate trimming removes whole nodes of this many entries
+	streamNodeMaxEntries = 100
+
+	streamEntriesReadInvalid = int64(-1)
+)
+
+var (
+	streamIdMin = streamId{}
+	streamIdMax = streamId{math.MaxUint64, math.MaxUint64}
+)
+
+var errInvalidStreamId = respErrorString("ERR Invalid stream ID specified as stream command argument")
+
+func newStream() *stream {
+	return &stream{
+		groups: map[string]*streamGroup{},
+	}
+}
+
+func (id streamId) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id streamId) compare(other streamId) int {
+	if id.ms < other.ms {
+		return -1
+	} else if id.ms > other.ms {
+		return 1
+	} else if id.seq < other.seq {
+		return -1
+	} else if id.seq > other.seq {
+		return 1
+	}
+	return 0
+}
+
+func (id streamId) isZero() bool {
+	return id.ms == 0 && id.seq == 0
+}
+
+// the id that follows id, or false if id is the maximum
+func (id streamId) next() (streamId, bool) {
+	if id.seq < math.MaxUint64 {
+		return streamId{id.ms, id.seq + 1}, true
+	} else if id.ms < math.MaxUint64 {
+		return streamId{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// the id that precedes id, or false if id is 0-0
+func (id streamId) prev() (streamId, bool) {
+	if id.seq > 0 {
+		return streamId{id.ms, id.seq - 1}, true
+	} else if id.ms > 0 {
+		return streamId{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// parses "ms-seq", or "ms" alone with missingSeq as the sequence number
+func parseStreamId(text string, missingSeq uint64) (id streamId, valid bool) {
+	msText, seqText, hasSeq := strings.Cut(text, "-")
+
+	ms, err := strconv.ParseUint(msText, 10, 64)
+	if err != nil {
+		return
+	}
+
+	seq := missingSeq
+	if hasSeq {
+		seq, err = strconv.ParseUint(seqText, 10, 64)
+		if err != nil {
+			return
+		}
+	}
+
+	id = streamId{ms, seq}
+	valid = true
+	return
+}
+
+// parses a range bound of XRANGE, XPENDING, etc., which can be "-", "+",
+// or an id with an optional "(" prefix to make the bound exclusive
+func parseStreamRangeBound(text string, isEnd bool) (id streamId, exclusive bool, valid bool) {
+	if text == "-" {
+		return streamIdMin, false, true
+	} else if text == "+" {
+		return streamIdMax, false, true
+	}
+
+	if len(text) > 1 && text[0] == '(' {
+		exclusive = true
+		text = text[1:]
+	}
+
+	missingSeq := uint64(0)
+	if isEnd {
+		missingSeq = math.MaxUint64
+	}
+
+	id, valid = parseStreamId(text, missingSeq)
+	return
+}
+
+// parses the XADD id argument, which can be an id, "ms-*" or "*"
+func parseStreamAddId(text string) (spec streamAddId, valid bool) {
+	if text == "*" {
+		spec.auto = true
+		valid = true
+		return
+	}
+
+	msText, found := strings.CutSuffix(text, "-*")
+	if found {
+		ms, err := strconv.ParseUint(msText, 10, 64)
+		if err != nil {
+			return
+		}
+		spec.autoSeq = true
+		spec.id = streamId{ms: ms}
+		valid = true
+		return
+	}
+
+	spec.id, valid = parseStreamId(text, 0)
+	return
+}
+
+// computes the id for a new entry
+func (s *stream) newEntryId(spec streamAddId, now time.Time) (id streamId, errText respErrorString) {
+	if spec.auto {
+		ms := uint64(now.UnixMilli())
+		if ms > s.lastId.ms {
+			id = streamId{ms: ms}
+			return
+		}
+
+		var ok bool
+		id, ok = s.lastId.next()
+		if !ok {
+			errText = respErrorString("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return
+	}
+
+	if spec.autoSeq {
+		if spec.id.ms > s.lastId.ms {
+			id = streamId{ms: spec.id.ms}
+			return
+		} else if spec.id.ms == s.lastId.ms && s.lastId.seq < math.MaxUint64 {
+			id = streamId{spec.id.ms, s.lastId.seq + 1}
+			return
+		}
+	} else {
+		if spec.id.isZero() {
+			errText = respErrorString("ERR The ID specified in XADD must be greater than 0-0")
+			return
+		}
+		if spec.id.compare(s.lastId) > 0 {
+			id = spec.id
+			return
+		}
+	}
+
+	errText = respErrorString("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	return
+}
+
+func (s *stream) length() int {
+	return len(s.entries)
+}
+
+// finds the index of the first entry with an id >= id
+func (s *stream) search(id streamId) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].id.compare(id) >= 0
+	})
+}
+
+func (s *stream) find(id streamId) *streamEntry {
+	index := s.search(id)
+	if index < len(s.entries) && s.entries[index].id == id {
+		return s.entries[index]
+	}
+	return nil
+}
+
+func (s *stream) firstId() streamId {
+	if len(s.entries) == 0 {
+		return streamIdMin
+	}
+	return s.entries[0].id
+}
+
+// appends an entry; the caller is responsible for the id being greater than lastId
+func (s *stream) add(id streamId, fields []string) *streamEntry {
+	entry := &streamEntry{id: id, fields: fields}
+	s.entries = append(s.entries, entry)
+	s.lastId = id
+	s.entriesAdded++
+	return entry
+}
+
+func (s *stream) delete(ids []streamId) (deleted int) {
+	for _, id := range ids {
+		index := s.search(id)
+		if index < len(s.entries) && s.entries[index].id == id {
+			s.entries = append(s.entries[:index], s.entries[index+1:]...)
+			if id.compare(s.maxDeletedId) > 0 {
+				s.maxDeletedId = id
+			}
+			deleted++
+		}
+	}
+	return
+}
+
+// removes the oldest entries according to the trim spec, and returns the
+// number removed; approximate trimming only removes whole nodes
+func (s *stream) trim(spec *streamTrimSpec) (removed int) {
+	if spec.byMinId {
+		removed = s.search(spec.minId)
+	} else if int64(len(s.entries)) > spec.maxLen {
+		removed = len(s.entries) - int(spec.maxLen)
+	}
+
+	if spec.limit > 0 && int64(removed) > spec.limit {
+		removed = int(spec.limit)
+	}
+
+	if spec.approx {
+		removed -= removed % streamNodeMaxEntries
+	}
+
+	if removed > 0 {
+		s.entries = append([]*streamEntry{}, s.entries[removed:]...)
+	}
+	return
+}
+
+// calls iter for each entry between start and end inclusive, in id order or
+// reverse order, until iter returns false
+func (s *stream) iterateRange(start, end streamId, reverse bool, iter func(entry *streamEntry) bool) {
+	if start.compare(end) > 0 {
+		return
+	}
+
+	first := s.search(start)
+	last := s.search(end)
+	if last < len(s.entries) && s.entries[last].id == end {
+		last++
+	}
+
+	if reverse {
+		for i := last - 1; i >= first; i-- {
+			if !iter(s.entries[i]) {
+				return
+			}
+		}
+	} else {
+		for i := first; i < last; i++ {
+			if !iter(s.entries[i]) {
+				return
+			}
+		}
+	}
+}
+
+// gets up to count entries (all if count <= 0) between start and end inclusive
+func (s *stream) selectRange(start, end streamId, count int, reverse bool) (entries []*streamEntry) {
+	entries = []*streamEntry{}
+	s.iterateRange(start, end, reverse, func(entry *streamEntry) bool {
+		entries = append(entries, entry)
+		return count <= 0 || len(entries) < count
+	})
+	return
+}
+
+// gets up to count entries (all if count <= 0) that have an id greater than id
+func (s *stream) selectAfter(id streamId, count int) []*streamEntry {
+	start, ok := id.next()
+	if !ok {
+		return []*streamEntry{}
+	}
+	return s.selectRange(start, streamIdMax, count, false)
+}
+
+// determines if entries were deleted at or after start, making the logical
+// entry counter of a group unreliable
+func (s *stream) hasTombstones(start streamId) bool {
+	if len(s.entries) == 0 || s.maxDeletedId.isZero() {
+		return false
+	}
+	return start.compare(s.maxDeletedId) <= 0
+}
+
+// estimates the logical position of id counted from the first entry ever
+// added, or returns streamEntriesReadInvalid if that is not possible
+func (s *stream) estimateDistance(id streamId) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+
+	if len(s.entries) == 0 && id.compare(s.maxDeletedId) <= 0 {
+		return s.entriesAdded
+	}
+
+	cmpLast := id.compare(s.lastId)
+	if cmpLast == 0 {
+		return s.entriesAdded
+	} else if cmpLast > 0 {
+		return streamEntriesReadInvalid
+	}
+
+	first := s.firstId()
+	if s.maxDeletedId.isZero() || s.maxDeletedId.compare(first) < 0 {
+		// no fragmentation ahead
+		cmpFirst := id.compare(first)
+		if cmpFirst < 0 {
+			return s.entriesAdded - int64(len(s.entries))
+		} else if cmpFirst == 0 {
+			return s.entriesAdded - int64(len(s.entries)) + 1
+		}
+	}
+
+	return streamEntriesReadInvalid
+}
+
+// the number of entries that the group has yet to read, or false if
+// the number can't be determined
+func (s *stream) lag(g *streamGroup) (lag int64, valid bool) {
+	if s.entriesAdded == 0 {
+		return 0, true
+	}
+
+	if g.entriesRead != streamEntriesReadInvalid && !s.hasTombstones(g.lastId) {
+		return s.entriesAdded - g.entriesRead, true
+	}
+
+	entriesRead := s.estimateDistance(g.lastId)
+	if entriesRead != streamEntriesReadInvalid {
+		return s.entriesAdded - entriesRead, true
+	}
+	return
+}
+
+func (s *stream) createGroup(name string, lastId streamId, entriesRead int64) (g *streamGroup, created bool) {
+	if _, exists := s.groups[name]; exists {
+		return
+	}
+
+	g = &streamGroup{
+		name:        name,
+		lastId:      lastId,
+		entriesRead: entriesRead,
+		consumers:   map[string]*streamConsumer{},
+	}
+	s.groups[name] = g
+	created = true
+	return
+}
+
+// moves the group's last delivered id forward as an entry is delivered
+func (s *stream) advanceGroup(g *streamGroup, id streamId) {
+	if id.compare(g.lastId) <= 0 {
+		return
+	}
+
+	if g.entriesRead != streamEntriesReadInvalid && !s.hasTombstones(id) {
+		g.entriesRead++
+	} else if s.entriesAdded != 0 {
+		g.entriesRead = s.estimateDistance(id)
+	}
+	g.lastId = id
+}
+
+func (s *stream) sortedGroups() []*streamGroup {
+	groups := make([]*streamGroup, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].name < groups[j].name
+	})
+	return groups
+}
+
+// gets a consumer, creating it if necessary
+func (g *streamGroup) consumer(name string, now time.Time) (c *streamConsumer, created bool) {
+	c, exists := g.consumers[name]
+	if !exists {
+		c = &streamConsumer{
+			name:     name,
+			seenTime: now,
+		}
+		g.consumers[name] = c
+		created = true
+	}
+	return
+}
+
+func (g *streamGroup) sortedConsumers() []*streamConsumer {
+	consumers := make([]*streamConsumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		consumers = append(consumers, c)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].name < consumers[j].name
+	})
+	return consumers
+}
+
+// removes a consumer, along with its pending entries, returning the number
+// of pending entries it had
+func (g *streamGroup) deleteConsumer(name string) (pending int, exists bool) {
+	c, exists := g.consumers[name]
+	if !exists {
+		return
+	}
+
+	kept := g.pending[:0]
+	for _, pe := range g.pending {
+		if pe.consumer == c {
+			pending++
+		} else {
+			kept = append(kept, pe)
+		}
+	}
+	g.pending = kept
+
+	delete(g.consumers, name)
+	return
+}
+
+// finds the index of the first pending entry with an id >= id
+func (g *streamGroup) searchPending(id streamId) int {
+	return sort.Search(len(g.pending), func(i int) bool {
+		return g.pending[i].id.compare(id) >= 0
+	})
+}
+
+func (g *streamGroup) findPending(id streamId) *streamPendingEntry {
+	index := g.searchPending(id)
+	if index < len(g.pending) && g.pending[index].id == id {
+		return g.pending[index]
+	}
+	return nil
+}
+
+// adds an entry to the PEL, or if it is already there, assigns it
+// to the new consumer as a first delivery
+func (g *streamGroup) deliver(id streamId, c *streamConsumer, now time.Time) {
+	index := g.searchPending(id)
+	if index < len(g.pending) && g.pending[index].id == id {
+		pe := g.pending[index]
+		pe.consumer = c
+		pe.deliveryTime = now
+		pe.deliveryCount = 1
+		return
+	}
+
+	pe := &streamPendingEntry{
+		id:            id,
+		consumer:      c,
+		deliveryTime:  now,
+		deliveryCount: 1,
+	}
+	g.pending = append(g.pending, nil)
+	copy(g.pending[index+1:], g.pending[index:])
+	g.pending[index] = pe
+}
+
+func (g *streamGroup) ack(id streamId) bool {
+	index := g.searchPending(id)
+	if index < len(g.pending) && g.pending[index].id == id {
+		g.pending = append(g.pending[:index], g.pending[index+1:]...)
+		return true
+	}
+	return false
+}
+
+func (g *streamGroup) consumerPendingCount(c *streamConsumer) (count int) {
+	for _, pe := range g.pending {
+		if pe.consumer == c {
+			count++
+		}
+	}
+	return
+}
+
+// transfers a pending entry to consumer c as part of XCLAIM or XAUTOCLAIM
+func (g *streamGroup) claim(pe *streamPendingEntry, c *streamConsumer, deliveryTime time.Time, retryCount int64, justId bool, now time.Time) {
+	pe.consumer = c
+	pe.deliveryTime = deliveryTime
+	if retryCount >= 0 {
+		pe.deliveryCount = retryCount
+	} else if !justId {
+		pe.deliveryCount++
+	}
+	c.activeTime = now
+}
+
+func (s *stream) clone() *stream {
+	newS, err := newStreamFromPersist(s.toPersist())
+	if err != nil {
+		panic(err)
+	}
+	return newS
+}
+
+func (s *stream) toPersist() *persistStream {
+	ps := &persistStream{
+		Entries:      make([]persistStreamEntry, 0, len(s.entries)),
+		LastId:       s.lastId.String(),
+		MaxDeletedId: s.maxDeletedId.String(),
+		EntriesAdded: s.entriesAdded,
+		Groups:       make([]persistStreamGroup, 0, len(s.groups)),
+	}
+
+	for _, entry := range s.entries {
+		fields := make([]string, len(entry.fields))
+		copy(fields, entry.fields)
+		ps.Entries = append(ps.Entries, persistStreamEntry{Id: entry.id.String(), Fields: fields})
+	}
+
+	for _, g := range s.sortedGroups() {
+		pg := persistStreamGroup{
+			Name:        g.name,
+			LastId:      g.lastId.String(),
+			EntriesRead: g.entriesRead,
+			Pending:     make([]persistStreamPendingEntry, 0, len(g.pending)),
+			Consumers:   make([]persistStreamConsumer, 0, len(g.consumers)),
+		}
+		for _, pe := range g.pending {
+			pg.Pending = append(pg.Pending, persistStreamPendingEntry{
+				Id:            pe.id.String(),
+				Consumer:      pe.consumer.name,
+				DeliveryTime:  pe.deliveryTime,
+				DeliveryCount: pe.deliveryCount,
+			})
+		}
+		for _, c := range g.sortedConsumers() {
+			pg.Consumers = append(pg.Consumers, persistStreamConsumer{
+				Name:       c.name,
+				SeenTime:   c.seenTime,
+				ActiveTime: c.activeTime,
+			})
+		}
+		ps.Groups = append(ps.Groups, pg)
+	}
+
+	return ps
+}
+
+// makes a stream from its persisted form; the form comes from a file or a
+// RESTORE payload, so an invalid stream id is an error
+func newStreamFromPersist(ps *persistStream) (s *stream, err error) {
+	parseId := func(text string) streamId {
+		id, valid := parseStreamId(text, 0)
+		if !valid && err == nil {
+			err = fmt.Errorf("invalid persisted stream id %s", text)
+		}
+		return id
+	}
+
+	s = newStream()
+	s.lastId = parseId(ps.LastId)
+	s.maxDeletedId = parseId(ps.MaxDeletedId)
+	s.entriesAdded = ps.EntriesAdded
+
+	s.entries = make([]*streamEntry, 0, len(ps.Entries))
+	for _, pe := range ps.Entries {
+		fields := make([]string, len(pe.Fields))
+		copy(fields, pe.Fields)
+		s.entries = append(s.entries, &streamEntry{id: parseId(pe.Id), fields: fields})
+	}
+
+	for _, pg := range ps.Groups {
+		g, _ := s.createGroup(pg.Name, parseId(pg.LastId), pg.EntriesRead)
+		for _, pc := range pg.Consumers {
+			g.consumers[pc.Name] = &streamConsumer{
+				name:       pc.Name,
+				seenTime:   pc.SeenTime,
+				activeTime: pc.ActiveTime,
+			}
+		}
+		g.pending = make([]*streamPendingEntry, 0, len(pg.Pending))
+		for _, pp := range pg.Pending {
+			c, _ := g.consumer(pp.Consumer, pp.DeliveryTime)
+			g.pending = append(g.pending, &streamPendingEntry{
+				id:            parseId(pp.Id),
+				consumer:      c,
+				deliveryTime:  pp.DeliveryTime,
+				deliveryCount: pp.DeliveryCount,
+			})
+		}
+	}
+
+	if err != nil {
+		s = nil
+	}
+	return
+}
+
+func (entry *streamEntry) toResp() respValue {
+	fields := make(respArray, 0, len(entry.fields))
+	for _, field := range entry.fields {
+		fields = append(fields, respValue{data: respBulkString(field)})
+	}
+
+	return respValue{data: respArray{
+		respValue{data: respBulkString(entry.id.String())},
+		respValue{data: fields},
+	}}
+}
+
+func setRespMapField(m *respMap, name string, value respValue) {
+	m.set(respValue{data: respBulkString(name)}, value)
+}
+
+func streamEntriesToResp(entries []*streamEntry) respArray {
+	a := make(respArray, 0, len(entries))
+	for _, entry := range entries {
+		a = append(a, entry.toResp())
+	}
+	return a
+}
+
+// formats the result of XREAD or XREADGROUP; RESP3 gets a map of key to
+// entries, RESP2 gets an array of key and entries pairs
+func streamReadResultsToResp(results []streamReadResult, respVersion int) (output respValue) {
+	if len(results) == 0 {
+		return
+	}
+
+	if respVersion >= 3 {
+		m := newRespMapSized(len(results))
+		for _, result := range results {
+			m.set(respValue{data: respBulkString(result.keyName)}, respValue{data: result.entries})
+		}
+		output.data = m
+	} else {
+		a := make(respArray, 0, len(results))
+		for _, result := range results {
+			a = append(a, respValue{data: respArray{
+				respValue{data: respBulkString(result.keyName)},
+				respValue{data: result.entries},
+			}})
+		}
+		output.data = a
+	}
+	return
+}
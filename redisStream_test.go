@@ -0,0 +1,1479 @@
+package redisemu
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// makes an expected stream entry for isValue
+func xentry(id string, fields ...any) []any {
+	return []any{id, fields}
+}
+
+// looks up a field of a map reply
+func xfield(m map[respValue]respValue, name string) *respValue {
+	v := m[respValue{data: respBulkString(name)}]
+	return &v
+}
+
+func xaddMany(t *testing.T, ts RedisTestClient, keyName string, ids ...string) {
+	for _, id := range ids {
+		output := ts.ProcessCommand("xadd", keyName, id, "f", id)
+		if !output.isString(id) {
+			t.Fatalf("xadd %s %s prepare fail", keyName, id)
+		}
+	}
+}
+
+func TestXAdd(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("xadd", "s", "1-1", "a", "1")
+	if !output.isString("1-1") {
+		t.Fatal("xadd explicit id fail")
+	}
+
+	output = ts.ProcessCommand("xadd", "s", "1-*", "b", "2")
+	if !output.isString("1-2") {
+		t.Fatal("xadd auto sequence fail")
+	}
+
+	output = ts.ProcessCommand("xadd", "s", "1", "c", "3")
+	if !output.isErrorString("ERR The ID specified in XADD is equal or smaller than the target stream top item") {
+		t.Fatal("xadd smaller id fail")
+	}
+
+	output = ts.ProcessCommand("xadd", "s", "0-*", "c", "3")
+	if !output.isErrorType() {
+		t.Fatal("xadd smaller auto sequence fail")
+	}
+
+	output = ts.ProcessCommand("xadd", "s", "2", "c", "3")
+	if !output.isString("2-0") {
+		t.Fatal("xadd ms only id fail")
+	}
+
+	output = ts.ProcessCommand("xadd", "s", "*", "d", "4", "e", "5")
+	if !output.isStringType() {
+		t.Fatal("xadd auto id fail")
+	}
+	autoId, _ := output.toString()
+	id, valid := parseStreamId(autoId, 0)
+	if !valid || id.ms < uint64(time.Now().Add(-time.Minute).UnixMilli()) {
+		t.Fatal("xadd auto id value fail")
+	}
+
+	output = ts.ProcessCommand("xadd", "s", "bad", "f", "v")
+	if !output.isErrorString("ERR Invalid stream ID specified as stream command argument") {
+		t.Fatal("xadd invalid id fail")
+	}
+
+	output = ts.ProcessCommand("xlen", "s")
+	if !output.isInt(4) {
+		t.Fatal("xlen fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s", autoId, "+")
+	if !output.isValue([]any{xentry(autoId, "d", "4", "e", "5")}) {
+		t.Fatal("xadd auto id entry fail")
+	}
+
+	// auto ids increase within the same millisecond
+	prev := streamId{}
+	for i := 0; i < 20; i++ {
+		output = ts.ProcessCommand("xadd", "fast", "*", "f", "v")
+		text, _ := output.toString()
+		id, valid := parseStreamId(text, 0)
+		if !valid || id.compare(prev) <= 0 {
+			t.Fatal("xadd auto id order fail")
+		}
+		prev = id
+	}
+
+	// 0-0 can't be used, even in a new stream
+	output = ts.ProcessCommand("xadd", "new", "0-0", "f", "v")
+	if !output.isErrorString("ERR The ID specified in XADD must be greater than 0-0") {
+		t.Fatal("xadd 0-0 fail")
+	}
+
+	output = ts.ProcessCommand("xadd", "new", "0-*", "f", "v")
+	if !output.isString("0-1") {
+		t.Fatal("xadd 0-* fail")
+	}
+
+	// NOMKSTREAM
+	output = ts.ProcessCommand("xadd", "s2", "nomkstream", "*", "f", "v")
+	if !output.isNull() {
+		t.Fatal("xadd nomkstream step 1 fail")
+	}
+
+	output = ts.ProcessCommand("exists", "s2")
+	if !output.isInt(0) {
+		t.Fatal("xadd nomkstream step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xlen", "s2")
+	if !output.isInt(0) {
+		t.Fatal("xlen missing fail")
+	}
+
+	// wrong type
+	output = ts.ProcessCommand("set", "str", "text")
+	if !output.isString("OK") {
+		t.Fatal("xadd prepare string fail")
+	}
+
+	output = ts.ProcessCommand("xadd", "str", "*", "f", "v")
+	if !output.isErrorType() {
+		t.Fatal("xadd wrong type fail")
+	}
+
+	output = ts.ProcessCommand("xlen", "str")
+	if !output.isErrorType() {
+		t.Fatal("xlen wrong type fail")
+	}
+}
+
+func TestXTrim(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	xaddMany(t, ts, "s", "1-0", "2-0", "3-0", "4-0", "5-0", "6-0", "7-0", "8-0", "9-0", "10-0")
+
+	output := ts.ProcessCommand("xtrim", "s", "maxlen", "7")
+	if !output.isInt(3) {
+		t.Fatal("xtrim maxlen step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s", "-", "+", "count", "1")
+	if !output.isValue([]any{xentry("4-0", "f", "4-0")}) {
+		t.Fatal("xtrim maxlen step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xtrim", "s", "maxlen", "=", "7")
+	if !output.isInt(0) {
+		t.Fatal("xtrim maxlen no change fail")
+	}
+
+	// approximate trimming only removes whole nodes
+	output = ts.ProcessCommand("xtrim", "s", "maxlen", "~", "2")
+	if !output.isInt(0) {
+		t.Fatal("xtrim approximate maxlen fail")
+	}
+
+	output = ts.ProcessCommand("xtrim", "s", "minid", "6")
+	if !output.isInt(2) {
+		t.Fatal("xtrim minid step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xlen", "s")
+	if !output.isInt(5) {
+		t.Fatal("xtrim minid step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xtrim", "s", "minid", "~", "9")
+	if !output.isInt(0) {
+		t.Fatal("xtrim approximate minid fail")
+	}
+
+	// trimming as part of xadd
+	output = ts.ProcessCommand("xadd", "s", "maxlen", "3", "11", "f", "v")
+	if !output.isString("11-0") {
+		t.Fatal("xadd maxlen step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s", "-", "+")
+	if !output.isValue([]any{xentry("9-0", "f", "9-0"), xentry("10-0", "f", "10-0"), xentry("11-0", "f", "v")}) {
+		t.Fatal("xadd maxlen step 2 fail")
+	}
+
+	// argument errors
+	output = ts.ProcessCommand("xtrim", "s", "maxlen", "1", "limit", "1")
+	if !output.isErrorString("ERR syntax error, LIMIT cannot be used without the special ~ option") {
+		t.Fatal("xtrim limit without approximate fail")
+	}
+
+	output = ts.ProcessCommand("xtrim", "s", "maxlen", "-1")
+	if !output.isErrorType() {
+		t.Fatal("xtrim negative maxlen fail")
+	}
+
+	output = ts.ProcessCommand("xtrim", "s", "minid", "x")
+	if !output.isErrorType() {
+		t.Fatal("xtrim invalid minid fail")
+	}
+
+	output = ts.ProcessCommand("xtrim", "missing", "maxlen", "1")
+	if !output.isInt(0) {
+		t.Fatal("xtrim missing fail")
+	}
+
+	// approximate trimming of a large stream, with and without LIMIT
+	for i := 1; i <= 250; i++ {
+		output = ts.ProcessCommand("xadd", "big", "*", "n", fmt.Sprintf("%d", i))
+		if !output.isStringType() {
+			t.Fatal("xtrim big prepare fail")
+		}
+	}
+
+	output = ts.ProcessCommand("xtrim", "big", "maxlen", "~", "0", "limit", "150")
+	if !output.isInt(100) {
+		t.Fatal("xtrim approximate limit fail")
+	}
+
+	output = ts.ProcessCommand("xtrim", "big", "maxlen", "~", "10")
+	if !output.isInt(100) {
+		t.Fatal("xtrim approximate large step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xlen", "big")
+	if !output.isInt(50) {
+		t.Fatal("xtrim approximate large step 2 fail")
+	}
+}
+
+func TestXRange(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	xaddMany(t, ts, "s", "1-0", "1-1", "2-0", "3-0")
+
+	e10 := xentry("1-0", "f", "1-0")
+	e11 := xentry("1-1", "f", "1-1")
+	e20 := xentry("2-0", "f", "2-0")
+	e30 := xentry("3-0", "f", "3-0")
+
+	output := ts.ProcessCommand("xrange", "s", "-", "+")
+	if !output.isValue([]any{e10, e11, e20, e30}) {
+		t.Fatal("xrange all fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s", "1", "1")
+	if !output.isValue([]any{e10, e11}) {
+		t.Fatal("xrange ms only fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s", "(1-0", "+")
+	if !output.isValue([]any{e11, e20, e30}) {
+		t.Fatal("xrange exclusive start fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s", "-", "(3-0")
+	if !output.isValue([]any{e10, e11, e20}) {
+		t.Fatal("xrange exclusive end fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s", "-", "+", "count", "2")
+	if !output.isValue([]any{e10, e11}) {
+		t.Fatal("xrange count fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s", "-", "+", "count", "0")
+	if !output.isValue([]any{}) {
+		t.Fatal("xrange count 0 fail")
+	}
+
+	output = ts.ProcessCommand("xrevrange", "s", "+", "-", "count", "2")
+	if !output.isValue([]any{e30, e20}) {
+		t.Fatal("xrevrange count fail")
+	}
+
+	output = ts.ProcessCommand("xrevrange", "s", "2", "1")
+	if !output.isValue([]any{e20, e11, e10}) {
+		t.Fatal("xrevrange ms only fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s", "3", "1")
+	if !output.isValue([]any{}) {
+		t.Fatal("xrange inverted fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "missing", "-", "+")
+	if !output.isValue([]any{}) {
+		t.Fatal("xrange missing fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s", "bad", "+")
+	if !output.isErrorType() {
+		t.Fatal("xrange invalid start fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s", "(-", "+")
+	if !output.isErrorType() {
+		t.Fatal("xrange exclusive special id fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s", "-", "(0-0")
+	if !output.isErrorString("ERR invalid end ID for the interval") {
+		t.Fatal("xrange exclusive 0-0 end fail")
+	}
+}
+
+func TestXDel(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	xaddMany(t, ts, "s", "1-0", "2-0", "3-0")
+
+	output := ts.ProcessCommand("xdel", "s", "2-0", "9-9")
+	if !output.isInt(1) {
+		t.Fatal("xdel fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s", "-", "+")
+	if !output.isValue([]any{xentry("1-0", "f", "1-0"), xentry("3-0", "f", "3-0")}) {
+		t.Fatal("xdel range fail")
+	}
+
+	output = ts.ProcessCommand("xdel", "s", "bad")
+	if !output.isErrorType() {
+		t.Fatal("xdel invalid id fail")
+	}
+
+	output = ts.ProcessCommand("xdel", "missing", "1-0")
+	if !output.isInt(0) {
+		t.Fatal("xdel missing fail")
+	}
+
+	// the stream remains after its last entry is deleted
+	output = ts.ProcessCommand("xdel", "s", "1-0", "3-0")
+	if !output.isInt(2) {
+		t.Fatal("xdel all step 1 fail")
+	}
+
+	output = ts.ProcessCommand("type", "s")
+	if !output.isString("stream") {
+		t.Fatal("xdel all step 2 fail")
+	}
+
+	// the top id still applies
+	output = ts.ProcessCommand("xadd", "s", "3-0", "f", "v")
+	if !output.isErrorType() {
+		t.Fatal("xdel all step 3 fail")
+	}
+}
+
+func TestXSetId(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	xaddMany(t, ts, "s", "1-0", "2-0")
+
+	output := ts.ProcessCommand("xsetid", "s", "5-0")
+	if !output.isString("OK") {
+		t.Fatal("xsetid fail")
+	}
+
+	output = ts.ProcessCommand("xadd", "s", "3-0", "f", "v")
+	if !output.isErrorType() {
+		t.Fatal("xsetid add smaller fail")
+	}
+
+	output = ts.ProcessCommand("xadd", "s", "5-*", "f", "v")
+	if !output.isString("5-1") {
+		t.Fatal("xsetid add auto sequence fail")
+	}
+
+	output = ts.ProcessCommand("xsetid", "s", "1-0")
+	if !output.isErrorString("ERR The ID specified in XSETID is smaller than the target stream top item") {
+		t.Fatal("xsetid smaller fail")
+	}
+
+	output = ts.ProcessCommand("xsetid", "missing", "1-0")
+	if !output.isErrorString("ERR no such key") {
+		t.Fatal("xsetid missing fail")
+	}
+
+	output = ts.ProcessCommand("xsetid", "s", "10-0", "entriesadded", "1")
+	if !output.isErrorType() {
+		t.Fatal("xsetid entries added fail")
+	}
+
+	output = ts.ProcessCommand("xsetid", "s", "10-0", "entriesadded", "50", "maxdeletedid", "11-0")
+	if !output.isErrorType() {
+		t.Fatal("xsetid max deleted id fail")
+	}
+
+	output = ts.ProcessCommand("xsetid", "s", "10-0", "entriesadded", "50", "maxdeletedid", "4-0")
+	if !output.isString("OK") {
+		t.Fatal("xsetid all options fail")
+	}
+
+	output = ts.ProcessCommand("xinfo", "stream", "s")
+	m, _ := output.toMap()
+	lastId := xfield(m, "last-generated-id")
+	maxDeletedId := xfield(m, "max-deleted-entry-id")
+	entriesAdded := xfield(m, "entries-added")
+	if !lastId.isString("10-0") || !maxDeletedId.isString("4-0") || !entriesAdded.isInt(50) {
+		t.Fatal("xsetid info fail")
+	}
+}
+
+func TestXRead(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	xaddMany(t, ts, "a", "1-0", "2-0")
+	xaddMany(t, ts, "b", "1-0")
+
+	output := ts.ProcessCommand("xread", "streams", "a", "b", "0", "0")
+	if !output.isMap(map[any]any{
+		"a": []any{xentry("1-0", "f", "1-0"), xentry("2-0", "f", "2-0")},
+		"b": []any{xentry("1-0", "f", "1-0")},
+	}) {
+		t.Fatal("xread fail")
+	}
+
+	output = ts.ProcessCommand("xread", "count", "1", "streams", "a", "b", "1-0", "0")
+	if !output.isMap(map[any]any{
+		"a": []any{xentry("2-0", "f", "2-0")},
+		"b": []any{xentry("1-0", "f", "1-0")},
+	}) {
+		t.Fatal("xread count fail")
+	}
+
+	// streams without new entries are left out
+	output = ts.ProcessCommand("xread", "streams", "a", "b", "1", "1")
+	if !output.isMap(map[any]any{
+		"a": []any{xentry("2-0", "f", "2-0")},
+	}) {
+		t.Fatal("xread partial fail")
+	}
+
+	output = ts.ProcessCommand("xread", "streams", "a", "b", "2", "1")
+	if !output.isNull() {
+		t.Fatal("xread nothing new fail")
+	}
+
+	output = ts.ProcessCommand("xread", "streams", "a", "missing", "$", "0")
+	if !output.isNull() {
+		t.Fatal("xread last id fail")
+	}
+
+	// argument errors
+	output = ts.ProcessCommand("xread", "streams", "a", "b", "0")
+	if !output.isErrorString("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.") {
+		t.Fatal("xread unbalanced fail")
+	}
+
+	output = ts.ProcessCommand("xread", "streams", "a", ">")
+	if !output.isErrorType() {
+		t.Fatal("xread new only fail")
+	}
+
+	output = ts.ProcessCommand("xread", "streams", "a", "bad")
+	if !output.isErrorType() {
+		t.Fatal("xread invalid id fail")
+	}
+
+	output = ts.ProcessCommand("set", "str", "text")
+	if !output.isString("OK") {
+		t.Fatal("xread prepare string fail")
+	}
+
+	output = ts.ProcessCommand("xread", "streams", "a", "str", "0", "0")
+	if !output.isErrorType() {
+		t.Fatal("xread wrong type fail")
+	}
+
+	// RESP2 has an array of key and entries pairs
+	ts2 := NewRedisTestClientResp2(t)
+	defer ts2.Close()
+
+	xaddMany(t, ts2, "a", "1-0", "2-0")
+
+	output = ts2.ProcessCommand("xread", "streams", "a", "1")
+	if !output.isValue([]any{[]any{"a", []any{xentry("2-0", "f", "2-0")}}}) {
+		t.Fatal("xread resp2 fail")
+	}
+}
+
+func TestXReadBlock(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+	ts3 := ts.AdditionalClient()
+	defer ts3.Close()
+
+	xaddMany(t, ts, "a", "1-0")
+
+	// timeout
+	output := ts.ProcessCommand("xread", "block", "10", "streams", "a", "$")
+	if !output.isNull() {
+		t.Fatal("xread block timeout fail")
+	}
+
+	output = ts.ProcessCommand("xread", "block", "-1", "streams", "a", "$")
+	if !output.isErrorType() {
+		t.Fatal("xread block negative fail")
+	}
+
+	// existing entries are returned without blocking
+	output = ts.ProcessCommand("xread", "block", "0", "streams", "a", "0")
+	if !output.isMap(map[any]any{"a": []any{xentry("1-0", "f", "1-0")}}) {
+		t.Fatal("xread block immediate fail")
+	}
+
+	// every blocked reader gets the new entry
+	var wg sync.WaitGroup
+	var output3 respValue
+	wg.Add(2)
+	go func() {
+		output = ts.ProcessCommand("xread", "block", "0", "streams", "a", "$")
+		wg.Done()
+	}()
+	go func() {
+		output3 = ts3.ProcessCommand("xread", "block", "0", "streams", "missing", "a", "$", "$")
+		wg.Done()
+	}()
+
+	time.Sleep(time.Millisecond * 20)
+
+	xaddMany(t, ts2, "a", "2-0")
+
+	wg.Wait()
+
+	// the additional client uses RESP2
+	if !output.isMap(map[any]any{"a": []any{xentry("2-0", "f", "2-0")}}) ||
+		!output3.isValue([]any{[]any{"a", []any{xentry("2-0", "f", "2-0")}}}) {
+		t.Fatal("xread block wake fail")
+	}
+
+	// CLIENT UNBLOCK ends the wait with a timeout, or an error
+	wg.Add(1)
+	go func() {
+		output = ts.ProcessCommand("xread", "block", "0", "streams", "a", "$")
+		wg.Done()
+	}()
+
+	time.Sleep(time.Millisecond * 20)
+
+	output2 := ts2.ProcessCommand("client", "unblock", fmt.Sprintf("%d", ts.ClientID()))
+	if !output2.isInt(1) {
+		t.Fatal("xread client unblock step 1 fail")
+	}
+
+	wg.Wait()
+
+	if !output.isNull() {
+		t.Fatal("xread client unblock step 2 fail")
+	}
+
+	wg.Add(1)
+	go func() {
+		output = ts.ProcessCommand("xread", "block", "0", "streams", "a", "$")
+		wg.Done()
+	}()
+
+	time.Sleep(time.Millisecond * 20)
+
+	output2 = ts2.ProcessCommand("client", "unblock", fmt.Sprintf("%d", ts.ClientID()), "error")
+	if !output2.isInt(1) {
+		t.Fatal("xread client unblock error step 1 fail")
+	}
+
+	wg.Wait()
+
+	if !output.isErrorString("UNBLOCKED client unblocked via CLIENT UNBLOCK") {
+		t.Fatal("xread client unblock error step 2 fail")
+	}
+}
+
+func TestXReadGroup(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	xaddMany(t, ts, "s", "1-0", "2-0", "3-0")
+
+	output := ts.ProcessCommand("xgroup", "create", "s", "g", "0")
+	if !output.isString("OK") {
+		t.Fatal("xreadgroup prepare fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "count", "2", "streams", "s", ">")
+	if !output.isMap(map[any]any{"s": []any{xentry("1-0", "f", "1-0"), xentry("2-0", "f", "2-0")}}) {
+		t.Fatal("xreadgroup c1 fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c2", "streams", "s", ">")
+	if !output.isMap(map[any]any{"s": []any{xentry("3-0", "f", "3-0")}}) {
+		t.Fatal("xreadgroup c2 fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c2", "streams", "s", ">")
+	if !output.isNull() {
+		t.Fatal("xreadgroup nothing new fail")
+	}
+
+	// history of pending entries
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "streams", "s", "0")
+	if !output.isMap(map[any]any{"s": []any{xentry("1-0", "f", "1-0"), xentry("2-0", "f", "2-0")}}) {
+		t.Fatal("xreadgroup history fail")
+	}
+
+	output = ts.ProcessCommand("xack", "s", "g", "1-0")
+	if !output.isInt(1) {
+		t.Fatal("xack step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xack", "s", "g", "1-0", "9-0")
+	if !output.isInt(0) {
+		t.Fatal("xack step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "streams", "s", "0")
+	if !output.isMap(map[any]any{"s": []any{xentry("2-0", "f", "2-0")}}) {
+		t.Fatal("xreadgroup history after ack fail")
+	}
+
+	// a deleted entry remains pending, without its fields
+	output = ts.ProcessCommand("xdel", "s", "2-0")
+	if !output.isInt(1) {
+		t.Fatal("xreadgroup prepare delete fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "streams", "s", "0")
+	if !output.isMap(map[any]any{"s": []any{[]any{"2-0", nil}}}) {
+		t.Fatal("xreadgroup history deleted fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "streams", "s", "2-0")
+	if !output.isMap(map[any]any{"s": []any{}}) {
+		t.Fatal("xreadgroup history empty fail")
+	}
+
+	// NOACK doesn't add to the PEL
+	xaddMany(t, ts, "s", "4-0")
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "noack", "streams", "s", ">")
+	if !output.isMap(map[any]any{"s": []any{xentry("4-0", "f", "4-0")}}) {
+		t.Fatal("xreadgroup noack step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g")
+	if !output.isValue([]any{int64(2), "2-0", "3-0", []any{[]any{"c1", "1"}, []any{"c2", "1"}}}) {
+		t.Fatal("xreadgroup noack step 2 fail")
+	}
+
+	// the group counts what it has read
+	output = ts.ProcessCommand("xinfo", "groups", "s")
+	if !output.isValue([]any{map[any]any{
+		"name":              "g",
+		"consumers":         int64(2),
+		"pending":           int64(2),
+		"last-delivered-id": "4-0",
+		"entries-read":      int64(4),
+		"lag":               int64(0),
+	}}) {
+		t.Fatal("xreadgroup entries read fail")
+	}
+
+	// errors
+	output = ts.ProcessCommand("xreadgroup", "group", "nogroup", "c1", "streams", "s", ">")
+	if !output.isErrorString("NOGROUP No such key 's' or consumer group 'nogroup' in XREADGROUP with GROUP option") {
+		t.Fatal("xreadgroup no group fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "streams", "missing", ">")
+	if !output.isErrorType() {
+		t.Fatal("xreadgroup missing key fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "streams", "s", "$")
+	if !output.isErrorString("ERR The $ ID is meaningful only for XREAD command") {
+		t.Fatal("xreadgroup last id fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "streams", "s", "s2", ">")
+	if !output.isErrorString("ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified.") {
+		t.Fatal("xreadgroup unbalanced fail")
+	}
+}
+
+func TestXReadGroupBlock(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+	ts3 := ts.AdditionalClient()
+	defer ts3.Close()
+
+	output := ts.ProcessCommand("xgroup", "create", "s", "g", "$", "mkstream")
+	if !output.isString("OK") {
+		t.Fatal("xreadgroup block prepare fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "block", "10", "streams", "s", ">")
+	if !output.isNull() {
+		t.Fatal("xreadgroup block timeout fail")
+	}
+
+	// two consumers wait; each entry goes to only one of them, and the
+	// other continues to wait
+	var wg sync.WaitGroup
+	var output3 respValue
+	wg.Add(2)
+	go func() {
+		output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "block", "2000", "streams", "s", ">")
+		wg.Done()
+	}()
+	go func() {
+		output3 = ts3.ProcessCommand("xreadgroup", "group", "g", "c2", "block", "2000", "streams", "s", ">")
+		wg.Done()
+	}()
+
+	time.Sleep(time.Millisecond * 20)
+	xaddMany(t, ts2, "s", "1-0")
+	time.Sleep(time.Millisecond * 20)
+	xaddMany(t, ts2, "s", "2-0")
+
+	wg.Wait()
+
+	// the additional client uses RESP2
+	e1 := []any{xentry("1-0", "f", "1-0")}
+	e2 := []any{xentry("2-0", "f", "2-0")}
+	if !((output.isMap(map[any]any{"s": e1}) && output3.isValue([]any{[]any{"s", e2}})) ||
+		(output.isMap(map[any]any{"s": e2}) && output3.isValue([]any{[]any{"s", e1}}))) {
+		t.Fatal("xreadgroup block wake fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g")
+	if !output.isValue([]any{int64(2), "1-0", "2-0", []any{[]any{"c1", "1"}, []any{"c2", "1"}}}) {
+		t.Fatal("xreadgroup block pending fail")
+	}
+}
+
+func TestXReadGroupBlockDeleted(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+	ts2 := ts.AdditionalClient()
+	defer ts2.Close()
+	ts3 := ts.AdditionalClient()
+	defer ts3.Close()
+
+	output := ts.ProcessCommand("xgroup", "create", "s", "g", "$", "mkstream")
+	if !output.isString("OK") {
+		t.Fatal("xreadgroup deleted prepare fail")
+	}
+
+	// deleting the stream wakes XREADGROUP with an error, while XREAD
+	// continues to wait
+	var wg sync.WaitGroup
+	var output3 respValue
+	wg.Add(2)
+	go func() {
+		output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "block", "0", "streams", "s", ">")
+		wg.Done()
+	}()
+	go func() {
+		output3 = ts3.ProcessCommand("xread", "block", "200", "streams", "s", "$")
+		wg.Done()
+	}()
+
+	time.Sleep(time.Millisecond * 20)
+	output2 := ts2.ProcessCommand("del", "s")
+	if !output2.isInt(1) {
+		t.Fatal("xreadgroup deleted del fail")
+	}
+
+	wg.Wait()
+
+	if !output.isErrorType() {
+		t.Fatal("xreadgroup deleted wake fail")
+	}
+	if !output3.isNull() {
+		t.Fatal("xreadgroup deleted xread fail")
+	}
+
+	// replacing the stream also wakes XREADGROUP
+	output = ts.ProcessCommand("xgroup", "create", "s", "g", "$", "mkstream")
+	if !output.isString("OK") {
+		t.Fatal("xreadgroup replaced prepare fail")
+	}
+
+	wg.Add(1)
+	go func() {
+		output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "block", "0", "streams", "s", ">")
+		wg.Done()
+	}()
+
+	time.Sleep(time.Millisecond * 20)
+	output2 = ts2.ProcessCommand("set", "s", "v")
+	if !output2.isString("OK") {
+		t.Fatal("xreadgroup replaced set fail")
+	}
+
+	wg.Wait()
+
+	if !output.isErrorType() {
+		t.Fatal("xreadgroup replaced wake fail")
+	}
+
+	// and so does a flush
+	output = ts.ProcessCommand("del", "s")
+	if !output.isInt(1) {
+		t.Fatal("xreadgroup flushed prepare step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "create", "s", "g", "$", "mkstream")
+	if !output.isString("OK") {
+		t.Fatal("xreadgroup flushed prepare step 2 fail")
+	}
+
+	wg.Add(1)
+	go func() {
+		output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "block", "0", "streams", "s", ">")
+		wg.Done()
+	}()
+
+	time.Sleep(time.Millisecond * 20)
+	output2 = ts2.ProcessCommand("flushdb")
+	if !output2.isString("OK") {
+		t.Fatal("xreadgroup flushed flushdb fail")
+	}
+
+	wg.Wait()
+
+	if !output.isErrorType() {
+		t.Fatal("xreadgroup flushed wake fail")
+	}
+}
+
+func TestXPending(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	xaddMany(t, ts, "s", "1-0", "2-0", "3-0")
+
+	output := ts.ProcessCommand("xgroup", "create", "s", "g", "0")
+	if !output.isString("OK") {
+		t.Fatal("xpending prepare step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "count", "2", "streams", "s", ">")
+	if !output.isMap(map[any]any{"s": []any{xentry("1-0", "f", "1-0"), xentry("2-0", "f", "2-0")}}) {
+		t.Fatal("xpending prepare step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c2", "streams", "s", ">")
+	if !output.isMap(map[any]any{"s": []any{xentry("3-0", "f", "3-0")}}) {
+		t.Fatal("xpending prepare step 3 fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g")
+	if !output.isValue([]any{int64(3), "1-0", "3-0", []any{[]any{"c1", "2"}, []any{"c2", "1"}}}) {
+		t.Fatal("xpending summary fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g", "-", "+", "10")
+	rows, valid := output.toArray()
+	if !valid || len(rows) != 3 {
+		t.Fatal("xpending extended step 1 fail")
+	}
+	row, _ := rows[0].toArray()
+	if len(row) != 4 || !row[0].isString("1-0") || !row[1].isString("c1") || !row[3].isInt(1) {
+		t.Fatal("xpending extended step 2 fail")
+	}
+	if idle, valid := row[2].toInt(); !valid || idle < 0 || idle > 1000 {
+		t.Fatal("xpending extended step 3 fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g", "-", "+", "10", "c2")
+	rows, _ = output.toArray()
+	if len(rows) != 1 {
+		t.Fatal("xpending consumer step 1 fail")
+	}
+	row, _ = rows[0].toArray()
+	if !row[0].isString("3-0") || !row[1].isString("c2") {
+		t.Fatal("xpending consumer step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g", "(1-0", "+", "1")
+	rows, _ = output.toArray()
+	if len(rows) != 1 {
+		t.Fatal("xpending exclusive step 1 fail")
+	}
+	row, _ = rows[0].toArray()
+	if !row[0].isString("2-0") {
+		t.Fatal("xpending exclusive step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g", "idle", "3600000", "-", "+", "10")
+	if !output.isValue([]any{}) {
+		t.Fatal("xpending idle fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "create", "s", "g2", "$")
+	if !output.isString("OK") {
+		t.Fatal("xpending prepare empty fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g2")
+	if !output.isValue([]any{int64(0), nil, nil, nil}) {
+		t.Fatal("xpending empty fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "nogroup")
+	if !output.isErrorString("NOGROUP No such key 's' or consumer group 'nogroup'") {
+		t.Fatal("xpending no group fail")
+	}
+}
+
+func TestXClaim(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	xaddMany(t, ts, "s", "1-0", "2-0", "3-0")
+
+	output := ts.ProcessCommand("xgroup", "create", "s", "g", "0")
+	if !output.isString("OK") {
+		t.Fatal("xclaim prepare step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "streams", "s", ">")
+	if !output.isMap(map[any]any{"s": []any{xentry("1-0", "f", "1-0"), xentry("2-0", "f", "2-0"), xentry("3-0", "f", "3-0")}}) {
+		t.Fatal("xclaim prepare step 2 fail")
+	}
+
+	// not idle long enough
+	output = ts.ProcessCommand("xclaim", "s", "g", "c2", "3600000", "1-0")
+	if !output.isValue([]any{}) {
+		t.Fatal("xclaim min idle fail")
+	}
+
+	output = ts.ProcessCommand("xclaim", "s", "g", "c2", "0", "1-0")
+	if !output.isValue([]any{xentry("1-0", "f", "1-0")}) {
+		t.Fatal("xclaim step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g", "1-0", "1-0", "1")
+	rows, _ := output.toArray()
+	row, _ := rows[0].toArray()
+	if !row[1].isString("c2") || !row[3].isInt(2) {
+		t.Fatal("xclaim step 2 fail")
+	}
+
+	// JUSTID doesn't count a delivery
+	output = ts.ProcessCommand("xclaim", "s", "g", "c2", "0", "2-0", "justid")
+	if !output.isArray("2-0") {
+		t.Fatal("xclaim justid step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g", "2-0", "2-0", "1")
+	rows, _ = output.toArray()
+	row, _ = rows[0].toArray()
+	if !row[1].isString("c2") || !row[3].isInt(1) {
+		t.Fatal("xclaim justid step 2 fail")
+	}
+
+	// IDLE and RETRYCOUNT
+	output = ts.ProcessCommand("xclaim", "s", "g", "c3", "0", "3-0", "idle", "5000", "retrycount", "7")
+	if !output.isValue([]any{xentry("3-0", "f", "3-0")}) {
+		t.Fatal("xclaim idle step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g", "idle", "5000", "-", "+", "10")
+	rows, _ = output.toArray()
+	if len(rows) != 1 {
+		t.Fatal("xclaim idle step 2 fail")
+	}
+	row, _ = rows[0].toArray()
+	if !row[0].isString("3-0") || !row[1].isString("c3") || !row[3].isInt(7) {
+		t.Fatal("xclaim idle step 3 fail")
+	}
+
+	// a deleted entry is removed from the PEL
+	output = ts.ProcessCommand("xdel", "s", "1-0")
+	if !output.isInt(1) {
+		t.Fatal("xclaim deleted step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xclaim", "s", "g", "c3", "0", "1-0")
+	if !output.isValue([]any{}) {
+		t.Fatal("xclaim deleted step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g")
+	if !output.isValue([]any{int64(2), "2-0", "3-0", []any{[]any{"c2", "1"}, []any{"c3", "1"}}}) {
+		t.Fatal("xclaim deleted step 3 fail")
+	}
+
+	// FORCE creates a pending entry
+	xaddMany(t, ts, "s", "4-0")
+
+	output = ts.ProcessCommand("xclaim", "s", "g", "c3", "0", "4-0")
+	if !output.isValue([]any{}) {
+		t.Fatal("xclaim force step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xclaim", "s", "g", "c3", "0", "4-0", "force", "justid")
+	if !output.isArray("4-0") {
+		t.Fatal("xclaim force step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g")
+	if !output.isValue([]any{int64(3), "2-0", "4-0", []any{[]any{"c2", "1"}, []any{"c3", "2"}}}) {
+		t.Fatal("xclaim force step 3 fail")
+	}
+
+	// LASTID moves the group forward
+	output = ts.ProcessCommand("xclaim", "s", "g", "c3", "0", "9-0", "lastid", "10-0")
+	if !output.isValue([]any{}) {
+		t.Fatal("xclaim lastid step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c3", "streams", "s", ">")
+	if !output.isNull() {
+		t.Fatal("xclaim lastid step 2 fail")
+	}
+
+	// errors
+	output = ts.ProcessCommand("xclaim", "s", "g", "c3", "x", "1-0")
+	if !output.isErrorString("ERR Invalid min-idle-time argument for XCLAIM") {
+		t.Fatal("xclaim invalid min idle fail")
+	}
+
+	output = ts.ProcessCommand("xclaim", "s", "nogroup", "c3", "0", "1-0")
+	if !output.isErrorType() {
+		t.Fatal("xclaim no group fail")
+	}
+}
+
+func TestXAutoClaim(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	xaddMany(t, ts, "s", "1-0", "2-0", "3-0", "4-0", "5-0")
+
+	output := ts.ProcessCommand("xgroup", "create", "s", "g", "0")
+	if !output.isString("OK") {
+		t.Fatal("xautoclaim prepare step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "streams", "s", ">")
+	if !output.isMap(map[any]any{"s": []any{
+		xentry("1-0", "f", "1-0"),
+		xentry("2-0", "f", "2-0"),
+		xentry("3-0", "f", "3-0"),
+		xentry("4-0", "f", "4-0"),
+		xentry("5-0", "f", "5-0"),
+	}}) {
+		t.Fatal("xautoclaim prepare step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xautoclaim", "s", "g", "c2", "0", "0", "count", "2")
+	if !output.isValue([]any{"3-0", []any{xentry("1-0", "f", "1-0"), xentry("2-0", "f", "2-0")}, []any{}}) {
+		t.Fatal("xautoclaim count fail")
+	}
+
+	output = ts.ProcessCommand("xautoclaim", "s", "g", "c2", "0", "3-0", "justid")
+	if !output.isValue([]any{"0-0", []any{"3-0", "4-0", "5-0"}, []any{}}) {
+		t.Fatal("xautoclaim justid fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g")
+	if !output.isValue([]any{int64(5), "1-0", "5-0", []any{[]any{"c2", "5"}}}) {
+		t.Fatal("xautoclaim pending fail")
+	}
+
+	// deleted entries are reported and removed from the PEL
+	output = ts.ProcessCommand("xdel", "s", "2-0")
+	if !output.isInt(1) {
+		t.Fatal("xautoclaim deleted step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xautoclaim", "s", "g", "c3", "0", "-", "justid")
+	if !output.isValue([]any{"0-0", []any{"1-0", "3-0", "4-0", "5-0"}, []any{"2-0"}}) {
+		t.Fatal("xautoclaim deleted step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xautoclaim", "s", "g", "c2", "3600000", "-")
+	if !output.isValue([]any{"0-0", []any{}, []any{}}) {
+		t.Fatal("xautoclaim min idle fail")
+	}
+
+	// errors
+	output = ts.ProcessCommand("xautoclaim", "s", "g", "c2", "0", "-", "count", "0")
+	if !output.isErrorString("ERR COUNT must be > 0") {
+		t.Fatal("xautoclaim count 0 fail")
+	}
+
+	output = ts.ProcessCommand("xautoclaim", "s", "g", "c2", "x", "-")
+	if !output.isErrorString("ERR Invalid min-idle-time argument for XAUTOCLAIM") {
+		t.Fatal("xautoclaim invalid min idle fail")
+	}
+
+	output = ts.ProcessCommand("xautoclaim", "s", "nogroup", "c2", "0", "-")
+	if !output.isErrorType() {
+		t.Fatal("xautoclaim no group fail")
+	}
+}
+
+func TestXGroup(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("xgroup", "create", "s", "g", "$")
+	if !output.isErrorType() {
+		t.Fatal("xgroup create missing fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "create", "s", "g", "$", "mkstream")
+	if !output.isString("OK") {
+		t.Fatal("xgroup create mkstream step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xlen", "s")
+	if !output.isInt(0) {
+		t.Fatal("xgroup create mkstream step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "create", "s", "g", "$")
+	if !output.isErrorString("BUSYGROUP Consumer Group name already exists") {
+		t.Fatal("xgroup create busy fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "create", "s", "g2", "bad")
+	if !output.isErrorType() {
+		t.Fatal("xgroup create invalid id fail")
+	}
+
+	// consumers
+	output = ts.ProcessCommand("xgroup", "createconsumer", "s", "g", "c1")
+	if !output.isInt(1) {
+		t.Fatal("xgroup createconsumer step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "createconsumer", "s", "g", "c1")
+	if !output.isInt(0) {
+		t.Fatal("xgroup createconsumer step 2 fail")
+	}
+
+	xaddMany(t, ts, "s", "1-0", "2-0")
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c2", "streams", "s", ">")
+	if !output.isMap(map[any]any{"s": []any{xentry("1-0", "f", "1-0"), xentry("2-0", "f", "2-0")}}) {
+		t.Fatal("xgroup delconsumer prepare fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "delconsumer", "s", "g", "c2")
+	if !output.isInt(2) {
+		t.Fatal("xgroup delconsumer step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g")
+	if !output.isValue([]any{int64(0), nil, nil, nil}) {
+		t.Fatal("xgroup delconsumer step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "delconsumer", "s", "g", "c2")
+	if !output.isInt(0) {
+		t.Fatal("xgroup delconsumer step 3 fail")
+	}
+
+	// SETID rewinds the group
+	output = ts.ProcessCommand("xgroup", "setid", "s", "g", "1-0")
+	if !output.isString("OK") {
+		t.Fatal("xgroup setid step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c1", "streams", "s", ">")
+	if !output.isMap(map[any]any{"s": []any{xentry("2-0", "f", "2-0")}}) {
+		t.Fatal("xgroup setid step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "setid", "s", "g", "$", "entriesread", "-5")
+	if !output.isErrorType() {
+		t.Fatal("xgroup setid entries read fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "setid", "s", "nogroup", "$")
+	if !output.isErrorString("NOGROUP No such consumer group 'nogroup' for key name 's'") {
+		t.Fatal("xgroup setid no group fail")
+	}
+
+	// destroy
+	output = ts.ProcessCommand("xgroup", "destroy", "s", "g")
+	if !output.isInt(1) {
+		t.Fatal("xgroup destroy step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "destroy", "s", "g")
+	if !output.isInt(0) {
+		t.Fatal("xgroup destroy step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "destroy", "missing", "g")
+	if !output.isErrorType() {
+		t.Fatal("xgroup destroy missing fail")
+	}
+
+	// wrong type
+	output = ts.ProcessCommand("set", "str", "text")
+	if !output.isString("OK") {
+		t.Fatal("xgroup prepare string fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "create", "str", "g", "$")
+	if !output.isErrorType() {
+		t.Fatal("xgroup wrong type fail")
+	}
+}
+
+func TestXInfo(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	output := ts.ProcessCommand("xadd", "s", "1-0", "a", "1")
+	if !output.isString("1-0") {
+		t.Fatal("xinfo prepare step 1 fail")
+	}
+	output = ts.ProcessCommand("xadd", "s", "2-0", "b", "2")
+	if !output.isString("2-0") {
+		t.Fatal("xinfo prepare step 2 fail")
+	}
+	output = ts.ProcessCommand("xadd", "s", "3-0", "c", "3")
+	if !output.isString("3-0") {
+		t.Fatal("xinfo prepare step 3 fail")
+	}
+	output = ts.ProcessCommand("xdel", "s", "2-0")
+	if !output.isInt(1) {
+		t.Fatal("xinfo prepare step 4 fail")
+	}
+	output = ts.ProcessCommand("xgroup", "create", "s", "g", "$")
+	if !output.isString("OK") {
+		t.Fatal("xinfo prepare step 5 fail")
+	}
+	output = ts.ProcessCommand("xgroup", "create", "s", "g0", "0")
+	if !output.isString("OK") {
+		t.Fatal("xinfo prepare step 6 fail")
+	}
+
+	output = ts.ProcessCommand("xinfo", "stream", "s")
+	if !output.isMap(map[any]any{
+		"length":                  int64(2),
+		"radix-tree-keys":         int64(1),
+		"radix-tree-nodes":        int64(2),
+		"last-generated-id":       "3-0",
+		"max-deleted-entry-id":    "2-0",
+		"entries-added":           int64(3),
+		"recorded-first-entry-id": "1-0",
+		"groups":                  int64(2),
+		"first-entry":             xentry("1-0", "a", "1"),
+		"last-entry":              xentry("3-0", "c", "3"),
+	}) {
+		t.Fatal("xinfo stream fail")
+	}
+
+	// lag can't be known for g0 because of the deleted entry
+	output = ts.ProcessCommand("xinfo", "groups", "s")
+	if !output.isValue([]any{
+		map[any]any{
+			"name":              "g",
+			"consumers":         int64(0),
+			"pending":           int64(0),
+			"last-delivered-id": "3-0",
+			"entries-read":      int64(3),
+			"lag":               int64(0),
+		},
+		map[any]any{
+			"name":              "g0",
+			"consumers":         int64(0),
+			"pending":           int64(0),
+			"last-delivered-id": "0-0",
+			"entries-read":      nil,
+			"lag":               nil,
+		},
+	}) {
+		t.Fatal("xinfo groups fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g0", "c1", "count", "1", "streams", "s", ">")
+	if !output.isMap(map[any]any{"s": []any{xentry("1-0", "a", "1")}}) {
+		t.Fatal("xinfo prepare consumers fail")
+	}
+
+	output = ts.ProcessCommand("xinfo", "consumers", "s", "g0")
+	consumers, valid := output.toArray()
+	if !valid || len(consumers) != 1 {
+		t.Fatal("xinfo consumers step 1 fail")
+	}
+	m, _ := consumers[0].toMap()
+	if !xfield(m, "name").isString("c1") || !xfield(m, "pending").isInt(1) {
+		t.Fatal("xinfo consumers step 2 fail")
+	}
+	if inactive, valid := xfield(m, "inactive").toInt(); !valid || inactive < 0 || inactive > 1000 {
+		t.Fatal("xinfo consumers step 3 fail")
+	}
+
+	output = ts.ProcessCommand("xinfo", "stream", "s", "full", "count", "1")
+	m, valid = output.toMap()
+	if !valid {
+		t.Fatal("xinfo stream full step 1 fail")
+	}
+	if !xfield(m, "entries").isValue([]any{xentry("1-0", "a", "1")}) {
+		t.Fatal("xinfo stream full step 2 fail")
+	}
+	groups, _ := xfield(m, "groups").toArray()
+	if len(groups) != 2 {
+		t.Fatal("xinfo stream full step 3 fail")
+	}
+	gm, _ := groups[1].toMap()
+	if !xfield(gm, "name").isString("g0") || !xfield(gm, "pel-count").isInt(1) {
+		t.Fatal("xinfo stream full step 4 fail")
+	}
+
+	// errors
+	output = ts.ProcessCommand("xinfo", "stream", "missing")
+	if !output.isErrorString("ERR no such key") {
+		t.Fatal("xinfo stream missing fail")
+	}
+
+	output = ts.ProcessCommand("xinfo", "consumers", "s", "nogroup")
+	if !output.isErrorType() {
+		t.Fatal("xinfo consumers no group fail")
+	}
+}
+
+func TestStreamKeyType(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	xaddMany(t, ts, "s", "1-0")
+
+	output := ts.ProcessCommand("type", "s")
+	if !output.isString("stream") {
+		t.Fatal("stream type fail")
+	}
+
+	output = ts.ProcessCommand("scan", "0", "type", "stream")
+	if !output.isValue([]any{"0", []any{"s"}}) {
+		t.Fatal("stream scan type fail")
+	}
+
+	output = ts.ProcessCommand("xgroup", "create", "s", "g", "0")
+	if !output.isString("OK") {
+		t.Fatal("stream copy prepare fail")
+	}
+
+	output = ts.ProcessCommand("copy", "s", "s2")
+	if !output.isInt(1) {
+		t.Fatal("stream copy step 1 fail")
+	}
+
+	xaddMany(t, ts, "s2", "2-0")
+
+	output = ts.ProcessCommand("xlen", "s")
+	if !output.isInt(1) {
+		t.Fatal("stream copy step 2 fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c", "streams", "s2", ">")
+	if !output.isMap(map[any]any{"s2": []any{xentry("1-0", "f", "1-0"), xentry("2-0", "f", "2-0")}}) {
+		t.Fatal("stream copy step 3 fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s", "g")
+	if !output.isValue([]any{int64(0), nil, nil, nil}) {
+		t.Fatal("stream copy step 4 fail")
+	}
+}
+
+func TestStreamPersist(t *testing.T) {
+	ds := newDataStore()
+	dsc := ds.newDataStoreCommand()
+
+	output := dsc.xadd("s", streamAddId{id: streamId{1, 0}}, false, []string{"f", "v"}, nil)
+	if !output.isString("1-0") {
+		t.Fatal("stream persist prepare step 1 fail")
+	}
+
+	output = dsc.xgroupCreate("s", "g", &streamId{}, false, streamEntriesReadInvalid)
+	if !output.isString("OK") {
+		t.Fatal("stream persist prepare step 2 fail")
+	}
+
+	_, errText := dsc.xreadgroup("g", "c", []streamReadRequest{{keyName: "s", newOnly: true}}, 0, false)
+	if errText != "" {
+		t.Fatal("stream persist prepare step 3 fail")
+	}
+
+	fileName := filepath.Join(t.TempDir(), "stream.db0")
+	if err := ds.save(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	ds2 := newDataStore()
+	if err := ds2.load(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	dsc2 := ds2.newDataStoreCommand()
+	output = dsc2.xrange("s", streamIdMin, streamIdMax, -1, false)
+	if !output.isValue([]any{xentry("1-0", "f", "v")}) {
+		t.Fatal("stream persist entries fail")
+	}
+
+	output = dsc2.xpending("s", "g", nil)
+	if !output.isValue([]any{int64(1), "1-0", "1-0", []any{[]any{"c", "1"}}}) {
+		t.Fatal("stream persist group fail")
+	}
+}
+
+func TestStreamDumpRestore(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	xaddMany(t, ts, "s", "1-0", "2-0")
+
+	output := ts.ProcessCommand("xgroup", "create", "s", "g", "0")
+	if !output.isString("OK") {
+		t.Fatal("stream dump prepare step 1 fail")
+	}
+
+	output = ts.ProcessCommand("xreadgroup", "group", "g", "c", "count", "1", "streams", "s", ">")
+	if output.isErrorType() {
+		t.Fatal("stream dump prepare step 2 fail")
+	}
+
+	output = ts.ProcessCommand("dump", "s")
+	val, valid := output.toString()
+	if !valid {
+		t.Fatal("stream dump fail")
+	}
+
+	output = ts.ProcessCommand("restore", "s2", "0", val)
+	if !output.isString("OK") {
+		t.Fatal("stream restore fail")
+	}
+
+	output = ts.ProcessCommand("type", "s2")
+	if !output.isString("stream") {
+		t.Fatal("stream restore type fail")
+	}
+
+	output = ts.ProcessCommand("xrange", "s2", "-", "+")
+	if !output.isValue([]any{xentry("1-0", "f", "1-0"), xentry("2-0", "f", "2-0")}) {
+		t.Fatal("stream restore entries fail")
+	}
+
+	output = ts.ProcessCommand("xpending", "s2", "g")
+	if !output.isValue([]any{int64(1), "1-0", "1-0", []any{[]any{"c", "1"}}}) {
+		t.Fatal("stream restore group fail")
+	}
+
+	output = ts.ProcessCommand("xadd", "s2", "*", "f", "v")
+	if output.isErrorType() {
+		t.Fatal("stream restore xadd fail")
+	}
+
+	output = ts.ProcessCommand("xlen", "s2")
+	if !output.isInt(3) {
+		t.Fatal("stream restore xlen fail")
+	}
+}
@@ -60,6 +60,12 @@ type (
 
 		// returns "now" time according to the server clock (+/- a few ms)
 		ServerNow() time.Time
+
+		// receives an out-of-band message from the server
+		SendOutOfBand(output respValue)
+
+		// provides and clears the out-of-band messages (such as RESP3 pushes) received so far
+		PendingPushes() []respValue
 	}
 
 	testClient struct {
@@ -72,6 +78,7 @@ type (
 		terminated bool
 		addr       string
 		laddr      string
+		pushes     []respValue
 	}
 )
 
@@ -116,7 +123,7 @@ func NewRedisTestClientResp2(t *testing.T) RedisTestClient {
 
 	ts := &testClient{
 		started: time.Now(),
-		dss:     newDataStoreSet(l, "", nil),
+		dss:     newDataStoreSet(l, "", nil, newServerConfig()),
 		addr:    fmt.Sprintf("1.2.3.4:%d", port),
 		laddr:   "127.0.0.1:6379",
 	}
@@ -256,3 +263,19 @@ func (ts *testClient) ClientAddr() string {
 func (ts *testClient) ServerNow() time.Time {
 	return time.Now()
 }
+
+func (ts *testClient) SendOutOfBand(output respValue) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.pushes = append(ts.pushes, output)
+}
+
+func (ts *testClient) PendingPushes() []respValue {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	pushes := ts.pushes
+	ts.pushes = nil
+	return pushes
+}
@@ -1,5 +1,26 @@
 package redisemu
 
+import "strings"
+
+// the commands that lock data stores other than the data store of the
+// command; a transaction that queues one of them takes the multi data store lock
+// before its own data store, like the commands do when they run alone
+var multiDataStoreCmdTable = map[string]bool{
+	"copy":       true,
+	"debug":      true,
+	"eval":       true,
+	"eval_ro":    true,
+	"evalsha":    true,
+	"evalsha_ro": true,
+	"fcall":      true,
+	"fcall_ro":   true,
+	"flushall":   true,
+	"info":       true,
+	"memory":     true,
+	"move":       true,
+	"swapdb":     true,
+}
+
 func fnWatch(ctx *cmdContext, args map[string]any) (output respValue, err error) {
 	if ctx.multi {
 		output.data = respErrorString("ERR WATCH inside MULTI is not allowed")
@@ -38,14 +59,54 @@ func fnDiscard(ctx *cmdContext, args map[string]any) (output respValue, err erro
 	// clear out watch map and discard multi command queue
 	ctx.cs.watches = map[watchKey]uint64{}
 	ctx.cs.cmdQueue = nil
+	ctx.cs.cmdQueueErr = false
 	output.data = rstrOK
 	return
 }
 
-func isAbortedExecUnlocked(cs *clientState) bool {
+func isAbortedExecUnlocked(cs *clientState, dsc *dataStoreCommand) bool {
 	for watch, id := range cs.watches {
-		// caller holds exclusive lock, so go directly to the data store for this check
-		if watch.ds.hasChangedUnlocked(watch.key, id) {
+		if watch.ds == dsc.ds {
+			// caller holds exclusive lock, so go directly to the data store for this check
+			if watch.ds.hasChangedUnlocked(watch.key, id) {
+				return true
+			}
+			continue
+		}
+
+		// a key watched in another database needs the lock of that data store
+		if isChangedInOtherDataStore(dsc, watch, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func isChangedInOtherDataStore(dsc *dataStoreCommand, watch watchKey, id uint64) bool {
+	// to acquire two data store locks, the multi data store lock must be held, to prevent
+	// a deadlock from two conflicting multi-data store operations; EXEC took
+	// it before its own data store (see isMultiDataStoreExec)
+	dsc.lockMulti()
+	defer dsc.unlockMulti()
+
+	wdsc := watch.ds.newDataStoreCommand()
+	wdsc.lock()
+	defer wdsc.unlock()
+
+	return watch.ds.hasChangedUnlocked(watch.key, id)
+}
+
+// indicates if a transaction may lock data stores other than its own, for a
+// key watched in another database or a command of multiDataStoreCmdTable
+func isMultiDataStoreExec(ctx *cmdContext) bool {
+	for watch := range ctx.cs.watches {
+		if watch.ds != ctx.dsc.ds {
+			return true
+		}
+	}
+	for _, cc := range *ctx.cs.cmdQueue {
+		cmdName, _, _ := strings.Cut(cc.cmdToken, "|")
+		if multiDataStoreCmdTable[cmdName] {
 			return true
 		}
 	}
@@ -58,6 +119,22 @@ func fnExec(ctx *cmdContext, args map[string]any) (output respValue, err error)
 		return
 	}
 
+	// a command failed to queue, so none of the commands are run
+	if ctx.cs.cmdQueueErr {
+		ctx.cs.watches = map[watchKey]uint64{}
+		ctx.cs.cmdQueue = nil
+		ctx.cs.cmdQueueErr = false
+		output.data = respErrorString("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+
+	// the lock for locking other data stores is taken first, in the
+	// same order as the commands that run alone
+	if isMultiDataStoreExec(ctx) {
+		ctx.dsc.acquireMultiExclusive()
+		defer ctx.dsc.releaseMultiExclusive()
+	}
+
 	// take complete ownership of the data store
 	ctx.dsc.acquireExclusive()
 	defer ctx.dsc.releaseExclusive()
@@ -66,8 +143,10 @@ func fnExec(ctx *cmdContext, args map[string]any) (output respValue, err error)
 	ctx.cs.setMultiInProgress(true)
 	defer ctx.cs.setMultiInProgress(false)
 
-	// check the watches; if anything has changed, return null
-	if isAbortedExecUnlocked(ctx.cs) {
+	// check the watches; if anything has changed, end the transaction and return null
+	if isAbortedExecUnlocked(ctx.cs, ctx.dsc) {
+		ctx.cs.watches = map[watchKey]uint64{}
+		ctx.cs.cmdQueue = nil
 		return
 	}
 
@@ -93,6 +172,7 @@ func fnMulti(ctx *cmdContext, args map[string]any) (output respValue, err error)
 		output.data = respErrorString("ERR MULTI calls can not be nested")
 	} else {
 		ctx.cs.cmdQueue = &[]*cmdContext{}
+		ctx.cs.cmdQueueErr = false
 		output.data = rstrOK
 	}
 	return
@@ -1,6 +1,9 @@
 package redisemu
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestRedisMissingMulti(t *testing.T) {
 	ts := NewRedisTestClient(t)
@@ -262,3 +265,335 @@ func TestRedisAbortExec(t *testing.T) {
 		t.Fatal("abort exec step 6 fail")
 	}
 }
+
+func TestRedisExecAbortQueueError(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("multi")
+	output := ts.ProcessCommand("set", "key1", "cat")
+	if !output.isString(strQueued) {
+		t.Fatal("queue error set fail")
+	}
+
+	output = ts.ProcessCommand("notacommand", "key1")
+	if !output.isErrorType() {
+		t.Fatal("queue error unknown command fail")
+	}
+
+	output = ts.ProcessCommand("get")
+	if !output.isErrorType() {
+		t.Fatal("queue error arity fail")
+	}
+
+	output = ts.ProcessCommand("exec")
+	if !output.isErrorString("EXECABORT Transaction discarded because of previous errors.") {
+		t.Fatal("queue error exec fail")
+	}
+
+	// nothing ran, and the transaction is over
+	output = ts.ProcessCommand("exists", "key1")
+	if !output.isInt(0) {
+		t.Fatal("queue error discarded fail")
+	}
+
+	output = ts.ProcessCommand("exec")
+	if !output.isErrorString("ERR EXEC without MULTI") {
+		t.Fatal("queue error exec after abort fail")
+	}
+
+	// a new transaction starts clean
+	ts.ProcessCommand("multi")
+	ts.ProcessCommand("set", "key1", "cat")
+	output = ts.ProcessCommand("exec")
+	if !output.isArray("OK") {
+		t.Fatal("queue error next transaction fail")
+	}
+
+	// discard clears the error too
+	ts.ProcessCommand("multi")
+	ts.ProcessCommand("get")
+	ts.ProcessCommand("discard")
+	ts.ProcessCommand("multi")
+	ts.ProcessCommand("get", "key1")
+	output = ts.ProcessCommand("exec")
+	if !output.isArray("cat") {
+		t.Fatal("queue error after discard fail")
+	}
+}
+
+func TestRedisExecRuntimeErrors(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("set", "str", "text")
+
+	ts.ProcessCommand("multi")
+	ts.ProcessCommand("lpush", "str", "a")
+	ts.ProcessCommand("incr", "str")
+	ts.ProcessCommand("set", "key1", "cat")
+	ts.ProcessCommand("get", "key1")
+	output := ts.ProcessCommand("exec")
+
+	a, valid := output.data.(respArray)
+	if !valid || len(a) != 4 {
+		t.Fatal("runtime errors exec fail")
+	}
+	if !a[0].isErrorString("WRONGTYPE Operation against a key holding the wrong kind of value") {
+		t.Fatal("runtime errors wrongtype fail")
+	}
+	if !a[1].isErrorString("ERR value is not an integer or out of range") {
+		t.Fatal("runtime errors incr fail")
+	}
+	if !a[2].isString("OK") || !a[3].isString("cat") {
+		t.Fatal("runtime errors remaining commands fail")
+	}
+}
+
+func TestRedisWatchInvalidation(t *testing.T) {
+	ts1 := NewRedisTestClient(t)
+	defer ts1.Close()
+	ts2 := ts1.AdditionalClient()
+	defer ts2.Close()
+
+	ts1.ProcessCommand("set", "src", "new")
+	output := ts1.ProcessCommand("dump", "src")
+	payload, _ := output.toString()
+
+	tests := []struct {
+		name    string
+		setup   [][]any
+		change  [][]any
+		aborted bool
+	}{
+		{"flushdb", [][]any{{"set", "key1", "cat"}}, [][]any{{"flushdb"}}, true},
+		{"flushall", [][]any{{"set", "key1", "cat"}}, [][]any{{"select", "1"}, {"flushall"}}, true},
+		{"flushdb missing key", nil, [][]any{{"flushdb"}}, false},
+		{"flushdb other db", [][]any{{"set", "key1", "cat"}}, [][]any{{"select", "1"}, {"flushdb"}}, false},
+		{"rename onto key", [][]any{{"set", "key1", "cat"}, {"set", "src", "dog"}}, [][]any{{"rename", "src", "key1"}}, true},
+		{"restore replace", [][]any{{"set", "key1", "cat"}}, [][]any{{"restore", "key1", "0", payload, "replace"}}, true},
+		{"restore missing key", nil, [][]any{{"restore", "key1", "0", payload}}, true},
+		{"other key", [][]any{{"set", "key1", "cat"}}, [][]any{{"set", "key2", "dog"}}, false},
+	}
+
+	for _, test := range tests {
+		ts1.ProcessCommand("flushall")
+		ts2.ProcessCommand("select", "0")
+		for _, cmd := range test.setup {
+			ts1.ProcessCommand(cmd[0].(string), cmd[1:]...)
+		}
+
+		ts1.ProcessCommand("watch", "key1")
+		for _, cmd := range test.change {
+			ts2.ProcessCommand(cmd[0].(string), cmd[1:]...)
+		}
+
+		ts1.ProcessCommand("multi")
+		ts1.ProcessCommand("set", "key1", "cow")
+		output = ts1.ProcessCommand("exec")
+		if output.isNull() != test.aborted {
+			t.Fatalf("watch invalidation %s fail", test.name)
+		}
+
+		// the transaction is over either way
+		output = ts1.ProcessCommand("exec")
+		if !output.isErrorString("ERR EXEC without MULTI") {
+			t.Fatalf("watch invalidation %s transaction state fail", test.name)
+		}
+	}
+}
+
+func TestRedisWatchExpired(t *testing.T) {
+	ts := NewRedisTestClient(t)
+	defer ts.Close()
+
+	ts.ProcessCommand("set", "key1", "cat", "px", "20")
+	ts.ProcessCommand("watch", "key1")
+	time.Sleep(40 * time.Millisecond)
+
+	ts.ProcessCommand("multi")
+	ts.ProcessCommand("set", "key1", "dog")
+	output := ts.ProcessCommand("exec")
+	if !output.isNull() {
+		t.Fatal("watch expired exec fail")
+	}
+
+	// a key that is already expired when watched doesn't abort
+	ts.ProcessCommand("set", "key2", "cat", "px", "1")
+	time.Sleep(10 * time.Millisecond)
+	ts.ProcessCommand("watch", "key2")
+	ts.ProcessCommand("multi")
+	ts.ProcessCommand("set", "key2", "dog")
+	output = ts.ProcessCommand("exec")
+	if !output.isArray("OK") {
+		t.Fatal("watch expired before watch fail")
+	}
+}
+
+func TestRedisWatchOtherDb(t *testing.T) {
+	ts1 := NewRedisTestClient(t)
+	defer ts1.Close()
+	ts2 := ts1.AdditionalClient()
+	defer ts2.Close()
+
+	// a key watched in db 1, with the transaction in db 0
+	ts1.ProcessCommand("select", "1")
+	ts1.ProcessCommand("watch", "key1")
+	ts1.ProcessCommand("select", "0")
+
+	ts2.ProcessCommand("select", "1")
+	ts2.ProcessCommand("set", "key1", "dog")
+
+	ts1.ProcessCommand("multi")
+	ts1.ProcessCommand("set", "key1", "cat")
+	output := ts1.ProcessCommand("exec")
+	if !output.isNull() {
+		t.Fatal("watch other db exec fail")
+	}
+
+	// the retry succeeds
+	ts1.ProcessCommand("multi")
+	ts1.ProcessCommand("set", "key1", "cat")
+	output = ts1.ProcessCommand("exec")
+	if !output.isArray("OK") {
+		t.Fatal("watch other db retry fail")
+	}
+}
+
+func TestRedisWatchOtherDbConcurrentMove(t *testing.T) {
+	ts1 := NewRedisTestClient(t)
+	defer ts1.Close()
+	ts2 := ts1.AdditionalClient()
+	defer ts2.Close()
+
+	// EXEC checks the watch of db 1 while it owns db 0, and MOVE locks
+	// db 0 then db 1; the two must not deadlock
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			ts1.ProcessCommand("select", "1")
+			ts1.ProcessCommand("watch", "key1")
+			ts1.ProcessCommand("select", "0")
+			ts1.ProcessCommand("multi")
+			ts1.ProcessCommand("set", "key2", "cat")
+			ts1.ProcessCommand("exec")
+		}
+	}()
+
+	moved := make(chan struct{})
+	go func() {
+		defer close(moved)
+		for i := 0; i < 200; i++ {
+			ts2.ProcessCommand("set", "key1", "dog")
+			ts2.ProcessCommand("move", "key1", "1")
+			ts2.ProcessCommand("select", "1")
+			ts2.ProcessCommand("del", "key1")
+			ts2.ProcessCommand("select", "0")
+		}
+	}()
+
+	timeout := time.After(10 * time.Second)
+	for _, ch := range []chan struct{}{done, moved} {
+		select {
+		case <-ch:
+		case <-timeout:
+			t.Fatal("watch other db move deadlock")
+		}
+	}
+}
+
+func TestRedisFlushOtherClient(t *testing.T) {
+	ts1 := NewRedisTestClient(t)
+	defer ts1.Close()
+	ts2 := ts1.AdditionalClient()
+	defer ts2.Close()
+
+	ts2.ProcessCommand("set", "key1", "cat")
+	ts1.ProcessCommand("flushall")
+
+	// the other client sees the flushed database
+	output := ts2.ProcessCommand("get", "key1")
+	if !output.isNull() {
+		t.Fatal("flush other client get fail")
+	}
+
+	ts2.ProcessCommand("set", "key1", "dog")
+	output = ts1.ProcessCommand("get", "key1")
+	if !output.isString("dog") {
+		t.Fatal("flush other client set fail")
+	}
+}
+
+func TestRedisWatchSwapDb(t *testing.T) {
+	ts1 := NewRedisTestClient(t)
+	defer ts1.Close()
+	ts2 := ts1.AdditionalClient()
+	defer ts2.Close()
+
+	ts1.ProcessCommand("set", "key1", "cat")
+	ts1.ProcessCommand("watch", "key1")
+
+	ts2.ProcessCommand("select", "1")
+	ts2.ProcessCommand("set", "key1", "cat")
+	ts2.ProcessCommand("swapdb", "0", "1")
+
+	// the watched key is another key after the swap, even with the same value
+	ts1.ProcessCommand("multi")
+	ts1.ProcessCommand("set", "key1", "dog")
+	output := ts1.ProcessCommand("exec")
+	if !output.isNull() {
+		t.Fatal("watch swapdb exec fail")
+	}
+
+	// a key missing from both databases isn't changed by the swap
+	ts1.ProcessCommand("watch", "key2")
+	ts2.ProcessCommand("swapdb", "0", "1")
+	ts1.ProcessCommand("multi")
+	ts1.ProcessCommand("set", "key2", "dog")
+	output = ts1.ProcessCommand("exec")
+	if !output.isArray("OK") {
+		t.Fatal("watch swapdb missing key fail")
+	}
+}
+
+func TestRedisMultiSwapDbConcurrentMove(t *testing.T) {
+	ts1 := NewRedisTestClient(t)
+	defer ts1.Close()
+	ts2 := ts1.AdditionalClient()
+	defer ts2.Close()
+
+	// EXEC owns db 0 when the queued SWAPDB locks db 1, and MOVE locks
+	// db 0 then db 1; the two must not deadlock (the commands queued
+	// before SWAPDB let MOVE run in between)
+	swapped := make(chan struct{})
+	go func() {
+		defer close(swapped)
+		for i := 0; i < 200; i++ {
+			ts1.ProcessCommand("multi")
+			for j := 0; j < 20; j++ {
+				ts1.ProcessCommand("incr", "key2")
+			}
+			ts1.ProcessCommand("swapdb", "0", "1")
+			ts1.ProcessCommand("exec")
+		}
+	}()
+
+	moved := make(chan struct{})
+	go func() {
+		defer close(moved)
+		for i := 0; i < 200; i++ {
+			ts2.ProcessCommand("set", "key1", "dog")
+			ts2.ProcessCommand("move", "key1", "1")
+		}
+	}()
+
+	timeout := time.After(10 * time.Second)
+	for _, ch := range []chan struct{}{swapped, moved} {
+		select {
+		case <-ch:
+		case <-timeout:
+			t.Fatal("multi swapdb move deadlock")
+		}
+	}
+}
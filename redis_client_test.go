@@ -4,7 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jimsnab/go-lane"
 	"github.com/redis/go-redis/v9"
@@ -199,3 +205,535 @@ func TestRedisClientConnectHook(t *testing.T) {
 		t.Error("didn't get hook response value")
 	}
 }
+
+func TestRedisClientPubSub(t *testing.T) {
+	l := lane.NewTestingLane(context.Background())
+
+	emu, err := NewEmulator(
+		l,
+		kRedisTestPort, // test port
+		"",             // default interface
+		"",             // no persistence
+		nil,            // no keypress termination
+	)
+	if err != nil {
+		t.Fatal("Error creating redis emulator: ", err)
+	}
+
+	emu.Start()
+	defer func() {
+		emu.RequestTermination()
+		emu.WaitForTermination()
+	}()
+
+	for _, protocol := range []int{2, 3} {
+		redisTestUrl := fmt.Sprintf("redis://localhost:%d", kRedisTestPort)
+		opt, err := redis.ParseURL(redisTestUrl + "/0")
+		if err != nil {
+			t.Fatal("Error parsing redis emulator url: ", err)
+		}
+		opt.Protocol = protocol
+
+		testClient := redis.NewClient(opt)
+
+		sub := testClient.Subscribe(l, "ch1", "ch2")
+		if _, err = sub.Receive(l); err != nil {
+			t.Fatal("subscribe error: ", err)
+		}
+
+		if err = sub.PSubscribe(l, "news.*"); err != nil {
+			t.Fatal("psubscribe error: ", err)
+		}
+
+		n, err := testClient.Publish(l, "ch2", "hello").Result()
+		if err != nil {
+			t.Fatal("publish error: ", err)
+		}
+		if n != 1 {
+			t.Errorf("publish receivers %d", n)
+		}
+
+		if _, err = testClient.Publish(l, "news.art", "painting").Result(); err != nil {
+			t.Fatal("publish pattern error: ", err)
+		}
+
+		msg, err := sub.ReceiveMessage(l)
+		if err != nil {
+			t.Fatal("receive error: ", err)
+		}
+		if msg.Channel != "ch2" || msg.Payload != "hello" {
+			t.Errorf("unexpected message %v", msg)
+		}
+
+		msg, err = sub.ReceiveMessage(l)
+		if err != nil {
+			t.Fatal("receive pattern error: ", err)
+		}
+		if msg.Pattern != "news.*" || msg.Channel != "news.art" || msg.Payload != "painting" {
+			t.Errorf("unexpected pattern message %v", msg)
+		}
+
+		if err = sub.Ping(l); err != nil {
+			t.Fatal("subscribed ping error: ", err)
+		}
+
+		sub.Close()
+		testClient.Close()
+	}
+}
+
+func TestRedisClientShardedPubSub(t *testing.T) {
+	l := lane.NewTestingLane(context.Background())
+
+	emu, err := NewEmulator(
+		l,
+		kRedisTestPort, // test port
+		"",             // default interface
+		"",             // no persistence
+		nil,            // no keypress termination
+	)
+	if err != nil {
+		t.Fatal("Error creating redis emulator: ", err)
+	}
+
+	emu.Start()
+	defer func() {
+		emu.RequestTermination()
+		emu.WaitForTermination()
+	}()
+
+	for _, protocol := range []int{2, 3} {
+		redisTestUrl := fmt.Sprintf("redis://localhost:%d", kRedisTestPort)
+		opt, err := redis.ParseURL(redisTestUrl + "/0")
+		if err != nil {
+			t.Fatal("Error parsing redis emulator url: ", err)
+		}
+		opt.Protocol = protocol
+
+		testClient := redis.NewClient(opt)
+
+		sub := testClient.SSubscribe(l, "shard1")
+		if _, err = sub.Receive(l); err != nil {
+			t.Fatal("ssubscribe error: ", err)
+		}
+
+		n, err := testClient.SPublish(l, "shard1", "hello").Result()
+		if err != nil {
+			t.Fatal("spublish error: ", err)
+		}
+		if n != 1 {
+			t.Errorf("spublish receivers %d", n)
+		}
+
+		msg, err := sub.ReceiveMessage(l)
+		if err != nil {
+			t.Fatal("receive error: ", err)
+		}
+		if msg.Channel != "shard1" || msg.Payload != "hello" {
+			t.Errorf("unexpected message %v", msg)
+		}
+
+		if err = sub.SUnsubscribe(l, "shard1"); err != nil {
+			t.Fatal("sunsubscribe error: ", err)
+		}
+
+		sub.Close()
+		testClient.Close()
+	}
+}
+
+func TestRedisClientScript(t *testing.T) {
+	l := lane.NewTestingLane(context.Background())
+
+	emu, err := NewEmulator(
+		l,
+		kRedisTestPort, // test port
+		"",             // default interface
+		"",             // no persistence
+		nil,            // no keypress termination
+	)
+	if err != nil {
+		t.Fatal("Error creating redis emulator: ", err)
+	}
+
+	emu.Start()
+	defer func() {
+		emu.RequestTermination()
+		emu.WaitForTermination()
+	}()
+
+	// a fixed window rate limiter, in the style of client libraries
+	limiter := redis.NewScript(`
+local count = redis.call('incr', KEYS[1])
+if count == 1 then
+	redis.call('pexpire', KEYS[1], ARGV[2])
+end
+if count > tonumber(ARGV[1]) then
+	return 0
+end
+return 1
+`)
+
+	for _, protocol := range []int{2, 3} {
+		redisTestUrl := fmt.Sprintf("redis://localhost:%d", kRedisTestPort)
+		opt, err := redis.ParseURL(redisTestUrl + "/0")
+		if err != nil {
+			t.Fatal("Error parsing redis emulator url: ", err)
+		}
+		opt.Protocol = protocol
+
+		testClient := redis.NewClient(opt)
+
+		// the first run falls back from EVALSHA to EVAL
+		key := fmt.Sprintf("limit%d", protocol)
+		for i := 0; i < 3; i++ {
+			allowed, err := limiter.Run(l, testClient, []string{key}, 2, 60000).Int()
+			if err != nil {
+				t.Fatal("script error: ", err)
+			}
+			if (i < 2 && allowed != 1) || (i == 2 && allowed != 0) {
+				t.Errorf("rate limit %d: allowed %d", i, allowed)
+			}
+		}
+
+		ttl, err := testClient.PTTL(l, key).Result()
+		if err != nil || ttl <= 0 {
+			t.Errorf("rate limit ttl %v %v", ttl, err)
+		}
+
+		testClient.Close()
+	}
+}
+
+func TestRedisClientFunction(t *testing.T) {
+	l := lane.NewTestingLane(context.Background())
+
+	emu, err := NewEmulator(
+		l,
+		kRedisTestPort, // test port
+		"",             // default interface
+		"",             // no persistence
+		nil,            // no keypress termination
+	)
+	if err != nil {
+		t.Fatal("Error creating redis emulator: ", err)
+	}
+
+	err = emu.RegisterFunctionLibrary("greeter",
+		LibraryFunction{
+			Name:     "greet",
+			NoWrites: true,
+			Fn: func(h FunctionHandle, keys, args []string) (result any, err error) {
+				name, err := h.Call("get", keys[0])
+				if err != nil {
+					return
+				}
+				return fmt.Sprintf("%s, %v", args[0], name), nil
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal("Error registering function library: ", err)
+	}
+
+	emu.Start()
+	defer func() {
+		emu.RequestTermination()
+		emu.WaitForTermination()
+	}()
+
+	for _, protocol := range []int{2, 3} {
+		redisTestUrl := fmt.Sprintf("redis://localhost:%d", kRedisTestPort)
+		opt, err := redis.ParseURL(redisTestUrl + "/0")
+		if err != nil {
+			t.Fatal("Error parsing redis emulator url: ", err)
+		}
+		opt.Protocol = protocol
+
+		testClient := redis.NewClient(opt)
+
+		// client code loads its library, then calls it
+		name, err := testClient.FunctionLoadReplace(l, "#!lua name=greeter\nredis.register_function{function_name='greet', callback=function(keys, args) end, flags={'no-writes'}}").Result()
+		if err != nil || name != "greeter" {
+			t.Errorf("function load %v %v", name, err)
+		}
+
+		testClient.Set(l, "user", "world", 0)
+		greeting, err := testClient.FCallRo(l, "greet", []string{"user"}, "hello").Text()
+		if err != nil || greeting != "hello, world" {
+			t.Errorf("fcall_ro %v %v", greeting, err)
+		}
+
+		libs, err := testClient.FunctionList(l, redis.FunctionListQuery{WithCode: true}).Result()
+		if err != nil || len(libs) != 1 || libs[0].Name != "greeter" || len(libs[0].Functions) != 1 || libs[0].Functions[0].Name != "greet" {
+			t.Errorf("function list %v %v", libs, err)
+		}
+
+		dump, err := testClient.FunctionDump(l).Result()
+		if err != nil {
+			t.Fatal("function dump error: ", err)
+		}
+		testClient.FunctionFlush(l)
+		if _, err = testClient.FCall(l, "greet", []string{"user"}, "hello").Result(); err == nil {
+			t.Error("fcall after flush succeeded")
+		}
+		if err = testClient.FunctionRestore(l, dump).Err(); err != nil {
+			t.Error("function restore error: ", err)
+		}
+		greeting, err = testClient.FCall(l, "greet", []string{"user"}, "hi").Text()
+		if err != nil || greeting != "hi, world" {
+			t.Errorf("fcall %v %v", greeting, err)
+		}
+
+		testClient.Close()
+	}
+}
+
+func TestRedisClientConfig(t *testing.T) {
+	l := lane.NewTestingLane(context.Background())
+
+	basePath := filepath.Join(t.TempDir(), "emu")
+	emu, err := NewEmulator(
+		l,
+		kRedisTestPort, // test port
+		"",             // default interface
+		basePath,       // persistence in a temporary directory
+		nil,            // no keypress termination
+	)
+	if err != nil {
+		t.Fatal("Error creating redis emulator: ", err)
+	}
+
+	if err = emu.SetConfig("databases", "2"); err != nil {
+		t.Fatal("Error setting databases: ", err)
+	}
+	if err = emu.SetConfig("timeout", "soon"); err == nil {
+		t.Fatal("Invalid config value accepted")
+	}
+
+	emu.Start()
+	defer func() {
+		emu.RequestTermination()
+		emu.WaitForTermination()
+	}()
+
+	redisTestUrl := fmt.Sprintf("redis://localhost:%d", kRedisTestPort)
+	opt, err := redis.ParseURL(redisTestUrl + "/0")
+	if err != nil {
+		t.Fatal("Error parsing redis emulator url: ", err)
+	}
+
+	testClient := redis.NewClient(opt)
+	defer testClient.Close()
+
+	// startup queries of client libraries and ops code
+	config, err := testClient.ConfigGet(l, "*").Result()
+	if err != nil {
+		t.Fatal("config get error: ", err)
+	}
+	if config["databases"] != "2" || config["maxmemory"] != "0" || config["timeout"] != "0" || config["notify-keyspace-events"] != "" {
+		t.Errorf("config get values %v", config)
+	}
+
+	if err = testClient.Do(l, "select", "2").Err(); err == nil {
+		t.Error("select beyond databases succeeded")
+	}
+
+	// the save rule persists a change within a couple of seconds
+	if err = testClient.Set(l, "key1", "cat", 0).Err(); err != nil {
+		t.Fatal("set error: ", err)
+	}
+	saved := false
+	for i := 0; i < 30 && !saved; i++ {
+		time.Sleep(100 * time.Millisecond)
+		_, err = os.Stat(basePath + ".db0")
+		saved = err == nil
+	}
+	if !saved {
+		t.Error("save rule did not save")
+	}
+
+	// an idle client is disconnected after the timeout
+	if err = testClient.ConfigSet(l, "timeout", "1").Err(); err != nil {
+		t.Fatal("config set error: ", err)
+	}
+	cxn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", kRedisTestPort))
+	if err != nil {
+		t.Fatal("dial error: ", err)
+	}
+	defer cxn.Close()
+
+	cxn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	started := time.Now()
+	_, err = cxn.Read(make([]byte, 16))
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("idle client read %v", err)
+	}
+	if elapsed := time.Since(started); elapsed < 900*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("idle client closed after %v", elapsed)
+	}
+}
+
+func TestRedisClientPersistence(t *testing.T) {
+	l := lane.NewTestingLane(context.Background())
+
+	basePath := filepath.Join(t.TempDir(), "emu")
+	emu, err := NewEmulator(
+		l,
+		kRedisTestPort, // test port
+		"",             // default interface
+		basePath,       // persistence in a temporary directory
+		nil,            // no keypress termination
+	)
+	if err != nil {
+		t.Fatal("Error creating redis emulator: ", err)
+	}
+
+	// only explicit saves
+	if err = emu.SetConfig("save", ""); err != nil {
+		t.Fatal("Error setting save: ", err)
+	}
+
+	emu.Start()
+	defer func() {
+		emu.RequestTermination()
+		emu.WaitForTermination()
+	}()
+
+	redisTestUrl := fmt.Sprintf("redis://localhost:%d", kRedisTestPort)
+	opt, err := redis.ParseURL(redisTestUrl + "/0")
+	if err != nil {
+		t.Fatal("Error parsing redis emulator url: ", err)
+	}
+
+	// a retry of SHUTDOWN would hide the closed connection that confirms it
+	opt.MaxRetries = -1
+	testClient := redis.NewClient(opt)
+	defer testClient.Close()
+
+	if err = testClient.Set(l, "key1", "cat", 0).Err(); err != nil {
+		t.Fatal("set error: ", err)
+	}
+	if _, err = os.Stat(basePath + ".db0"); err == nil {
+		t.Fatal("saved without a save command")
+	}
+
+	if err = testClient.Save(l).Err(); err != nil {
+		t.Fatal("save error: ", err)
+	}
+	if _, err = os.Stat(basePath + ".db0"); err != nil {
+		t.Fatal("save did not write: ", err)
+	}
+
+	lastSave, err := testClient.LastSave(l).Result()
+	if err != nil {
+		t.Fatal("lastsave error: ", err)
+	}
+	if time.Since(time.Unix(lastSave, 0)) > 5*time.Second {
+		t.Errorf("lastsave time %d", lastSave)
+	}
+
+	info, err := testClient.Info(l, "persistence").Result()
+	if err != nil {
+		t.Fatal("info error: ", err)
+	}
+	if !strings.Contains(info, "rdb_saves:1\r\n") || !strings.Contains(info, fmt.Sprintf("rdb_last_save_time:%d\r\n", lastSave)) {
+		t.Errorf("info persistence %s", info)
+	}
+
+	status, err := testClient.BgSave(l).Result()
+	if err != nil || status != "Background saving started" {
+		t.Fatal("bgsave error: ", status, err)
+	}
+
+	// shutdown saves the change made after the last save, then ends the emulator
+	if err = testClient.Set(l, "key2", "dog", 0).Err(); err != nil {
+		t.Fatal("set error: ", err)
+	}
+	if err = testClient.ShutdownSave(l).Err(); err != nil {
+		t.Fatal("shutdown error: ", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		emu.WaitForTermination()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not terminate the emulator")
+	}
+
+	if err = testClient.Ping(l).Err(); err == nil {
+		t.Error("ping succeeded after shutdown")
+	}
+
+	// the data is loaded by a new emulator
+	emu2, err := NewEmulator(l, kRedisTestPort, "", basePath, nil)
+	if err != nil {
+		t.Fatal("Error creating redis emulator: ", err)
+	}
+	emu2.Start()
+	defer emu2.Close()
+
+	testClient2 := redis.NewClient(opt)
+	defer testClient2.Close()
+
+	value, err := testClient2.Get(l, "key2").Result()
+	if err != nil || value != "dog" {
+		t.Error("shutdown save not loaded: ", value, err)
+	}
+}
+
+func TestRedisClientSlowLog(t *testing.T) {
+	l := lane.NewTestingLane(context.Background())
+
+	emu, err := NewEmulator(
+		l,
+		kRedisTestPort, // test port
+		"",             // default interface
+		"",             // no persistence
+		nil,            // no keypress termination
+	)
+	if err != nil {
+		t.Fatal("Error creating redis emulator: ", err)
+	}
+
+	// log every command
+	if err = emu.SetConfig("slowlog-log-slower-than", "0"); err != nil {
+		t.Fatal("Error setting slowlog threshold: ", err)
+	}
+
+	emu.Start()
+	defer func() {
+		emu.RequestTermination()
+		emu.WaitForTermination()
+	}()
+
+	redisTestUrl := fmt.Sprintf("redis://localhost:%d", kRedisTestPort)
+	opt, err := redis.ParseURL(redisTestUrl + "/0")
+	if err != nil {
+		t.Fatal("Error parsing redis emulator url: ", err)
+	}
+
+	testClient := redis.NewClient(opt)
+	defer testClient.Close()
+
+	if err = testClient.Set(l, "key1", "cat", 0).Err(); err != nil {
+		t.Fatal("set error: ", err)
+	}
+
+	entries := emu.SlowLog()
+	if len(entries) == 0 || strings.Join(entries[0].Args, " ") != "set key1 cat" || entries[0].ClientAddr == "" {
+		t.Fatalf("slow log accessor %+v", entries)
+	}
+
+	logs, err := testClient.SlowLogGet(l, 1).Result()
+	if err != nil {
+		t.Fatal("slowlog get error: ", err)
+	}
+	if len(logs) != 1 || logs[0].ID != entries[0].Id || strings.Join(logs[0].Args, " ") != "set key1 cat" {
+		t.Errorf("slowlog get %+v", logs)
+	}
+}
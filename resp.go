@@ -345,6 +345,8 @@ func resp3To2(val3 respValue) (value respValue) {
 		value.data = resp3SetToResp2(v)
 	case respAttributeMap:
 		value.data = resp3AttributeMapToResp2(v)
+	case respPush:
+		value.data = resp3PushToResp2(v)
 	case respNull, nil:
 		value.data = nil
 	default:
@@ -418,8 +420,8 @@ func resp3PairsToResp2(val respPairs) (a respArray) {
 	a = make(respArray, 0, len(val)*2)
 
 	for _, pair := range val {
-		a = append(a, pair.key)
-		a = append(a, pair.value)
+		a = append(a, resp3To2(pair.key))
+		a = append(a, resp3To2(pair.value))
 	}
 	return
 }
@@ -452,6 +454,17 @@ func resp3ArrayToResp2(val respArray) (a respArray) {
 	return
 }
 
+// a RESP2 client receives a push message (such as a pub/sub message) as an
+// array that starts with the message kind
+func resp3PushToResp2(val respPush) (a respArray) {
+	a = make([]respValue, 0, len(val.data)+1)
+	a = append(a, respValue{data: respBulkString(val.kind)})
+	for _, e := range val.data {
+		a = append(a, resp3To2(e))
+	}
+	return
+}
+
 func resp3SetToResp2(val respSet) (a respArray) {
 	a = make([]respValue, 0, len(val))
 	for e := range val {
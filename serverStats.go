@@ -0,0 +1,223 @@
+package redisemu
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// like redis, the error codes beyond the first 128 aren't tracked by errorstats
+const errorStatsMax = 128
+
+type (
+	// commandStat holds the counters of a command for INFO commandstats
+	commandStat struct {
+		calls         int64
+		usec          int64
+		rejectedCalls int64 // refused before the handler ran, such as for arity
+		failedCalls   int64 // ran and replied with an error
+	}
+
+	// serverStats holds the counters of INFO that CONFIG RESETSTAT clears
+	serverStats struct {
+		mu           sync.Mutex
+		commands     map[string]*commandStat // by command token
+		errors       map[string]int64        // by error code, such as "ERR"
+		errorReplies int64
+
+		keyspaceHits   int64 // accessed atomically
+		keyspaceMisses int64 // accessed atomically
+		expiredKeys    int64 // accessed atomically
+	}
+)
+
+// provides the counters of a command, guarded by serverStats.mu
+func (st *serverStats) commandUnlocked(cmdToken string) *commandStat {
+	if st.commands == nil {
+		st.commands = map[string]*commandStat{}
+	}
+	stat, exists := st.commands[cmdToken]
+	if !exists {
+		stat = &commandStat{}
+		st.commands[cmdToken] = stat
+	}
+	return stat
+}
+
+// counts an error reply by its code, the first word of the error text,
+// guarded by serverStats.mu
+func (st *serverStats) errorReplyUnlocked(errText respErrorString) {
+	st.errorReplies++
+
+	code := "ERR"
+	text := string(errText)
+	if space := strings.IndexByte(text[:min(len(text), 32)], ' '); space > 0 {
+		code = text[:space]
+	}
+
+	if st.errors == nil {
+		st.errors = map[string]int64{}
+	}
+	if _, exists := st.errors[code]; exists || len(st.errors) < errorStatsMax {
+		st.errors[code]++
+	}
+}
+
+// counts a call of a command that ran; the reply of a command called by a
+// script only counts as an error reply if the script returns it
+func (dss *dataStoreSet) recordCommandCall(cmdToken string, duration time.Duration, reply respValue, script bool) {
+	st := &dss.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	stat := st.commandUnlocked(cmdToken)
+	stat.calls++
+	stat.usec += duration.Microseconds()
+
+	if errText, isError := reply.data.(respErrorString); isError {
+		stat.failedCalls++
+		if !script {
+			st.errorReplyUnlocked(errText)
+		}
+	}
+}
+
+// counts a command that was refused without running; cmdToken is empty
+// when the command isn't known
+func (dss *dataStoreSet) recordRejectedCall(cmdToken string, errText respErrorString, script bool) {
+	st := &dss.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if cmdToken != "" {
+		st.commandUnlocked(cmdToken).rejectedCalls++
+	}
+	if !script {
+		st.errorReplyUnlocked(errText)
+	}
+}
+
+// counts a lookup of a read-only command
+func (dss *dataStoreSet) recordKeyspaceLookup(hit bool) {
+	if hit {
+		atomic.AddInt64(&dss.stats.keyspaceHits, 1)
+	} else {
+		atomic.AddInt64(&dss.stats.keyspaceMisses, 1)
+	}
+}
+
+func (dss *dataStoreSet) recordExpiredKey() {
+	atomic.AddInt64(&dss.stats.expiredKeys, 1)
+}
+
+func (dss *dataStoreSet) resetServerStats() {
+	st := &dss.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.commands = nil
+	st.errors = nil
+	st.errorReplies = 0
+	atomic.StoreInt64(&st.keyspaceHits, 0)
+	atomic.StoreInt64(&st.keyspaceMisses, 0)
+	atomic.StoreInt64(&st.expiredKeys, 0)
+}
+
+func (dss *dataStoreSet) errorReplies() int64 {
+	dss.stats.mu.Lock()
+	defer dss.stats.mu.Unlock()
+	return dss.stats.errorReplies
+}
+
+// provides the cmdstat_ lines of INFO commandstats, in command order
+func (dss *dataStoreSet) commandStatsInfo() []string {
+	st := &dss.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	cmdTokens := make([]string, 0, len(st.commands))
+	for cmdToken := range st.commands {
+		cmdTokens = append(cmdTokens, cmdToken)
+	}
+	slices.Sort(cmdTokens)
+
+	lines := make([]string, 0, len(cmdTokens))
+	for _, cmdToken := range cmdTokens {
+		stat := st.commands[cmdToken]
+		perCall := 0.0
+		if stat.calls > 0 {
+			perCall = float64(stat.usec) / float64(stat.calls)
+		}
+		lines = append(lines, fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+			cmdToken, stat.calls, stat.usec, perCall, stat.rejectedCalls, stat.failedCalls))
+	}
+	return lines
+}
+
+// provides the errorstat_ lines of INFO errorstats, in error code order
+func (dss *dataStoreSet) errorStatsInfo() []string {
+	st := &dss.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	codes := make([]string, 0, len(st.errors))
+	for code := range st.errors {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+
+	lines := make([]string, 0, len(codes))
+	for _, code := range codes {
+		lines = append(lines, fmt.Sprintf("errorstat_%s:count=%d", code, st.errors[code]))
+	}
+	return lines
+}
+
+// provides the db lines of INFO keyspace, for the data stores that have keys;
+// avg_ttl is the average of the remaining time to live of the keys with an
+// expiration, in milliseconds; the data store of dsc may be owned by a
+// transaction or script
+func (dss *dataStoreSet) keyspaceInfo(dsc *dataStoreCommand) []string {
+	lines := []string{}
+	now := time.Now()
+	for _, ds := range dss.sortedDbs() {
+		dbsc := dsc
+		if ds != dsc.ds {
+			dbsc = ds.newDataStoreCommand()
+		}
+		dbsc.lock()
+		keys := ds.data.count
+		var expires, ttlSum int64
+		for it := ds.data.createIterator(); it.next(); {
+			sk := it.value.(*storeKey)
+			if sk.expiresAt.Before(maxTime) {
+				expires++
+				ttlSum += max(sk.expiresAt.Sub(now).Milliseconds(), 0)
+			}
+		}
+		dbsc.unlock()
+
+		if keys == 0 {
+			continue
+		}
+		var avgTtl int64
+		if expires > 0 {
+			avgTtl = ttlSum / expires
+		}
+		lines = append(lines, fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=%d", ds.index, keys, expires, avgTtl))
+	}
+	return lines
+}
+
+// counts the clients of this emulator that wait in a blocking command
+func (dss *dataStoreSet) blockedClients() (count int) {
+	dss.processAllClients(func(id int64, cs *clientState) {
+		if cs.isBlocked() {
+			count++
+		}
+	})
+	return
+}
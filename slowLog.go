@@ -0,0 +1,115 @@
+package redisemu
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	slowLogMaxArgs   = 32  // the arguments kept by an entry, like SLOWLOG_ENTRY_MAX_ARGC
+	slowLogMaxString = 128 // the bytes kept of an argument, like SLOWLOG_ENTRY_MAX_STRING
+)
+
+type (
+	// SlowLogEntry is a command that took longer than slowlog-log-slower-than
+	SlowLogEntry struct {
+		Id         int64
+		Time       time.Time     // when the command was processed
+		Duration   time.Duration // the execution time, without time spent blocked
+		Args       []string      // the command and arguments, truncated like redis
+		ClientAddr string
+		ClientName string
+	}
+
+	// slowLog is the bounded buffer of slow commands, oldest first
+	slowLog struct {
+		mu      sync.Mutex
+		nextId  int64
+		entries []SlowLogEntry
+	}
+)
+
+// adds a command to the log, if it is slow enough, dropping the oldest
+// entries beyond slowlog-max-len
+func (dss *dataStoreSet) logSlowCommand(ctx *cmdContext, started time.Time, duration time.Duration) {
+	threshold := dss.config.intValue("slowlog-log-slower-than")
+	if threshold < 0 || duration.Microseconds() < threshold {
+		return
+	}
+	maxLen := int(dss.config.intValue("slowlog-max-len"))
+
+	entry := SlowLogEntry{
+		Time:       started,
+		Duration:   duration,
+		Args:       slowLogArgs(ctx.rawArgs),
+		ClientAddr: ctx.cs.client.ClientAddr(),
+		ClientName: ctx.cs.name,
+	}
+
+	sl := &dss.slowLog
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	entry.Id = sl.nextId
+	sl.nextId++
+
+	sl.entries = append(sl.entries, entry)
+	if len(sl.entries) > maxLen {
+		sl.entries = slices.Clone(sl.entries[len(sl.entries)-maxLen:])
+	}
+}
+
+// provides the newest entries of the log; a negative count provides all
+func (dss *dataStoreSet) slowLogEntries(count int) []SlowLogEntry {
+	sl := &dss.slowLog
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if count < 0 || count > len(sl.entries) {
+		count = len(sl.entries)
+	}
+	entries := make([]SlowLogEntry, 0, count)
+	for i := len(sl.entries) - 1; len(entries) < count; i-- {
+		entries = append(entries, sl.entries[i])
+	}
+	return entries
+}
+
+func (dss *dataStoreSet) slowLogLen() int {
+	dss.slowLog.mu.Lock()
+	defer dss.slowLog.mu.Unlock()
+	return len(dss.slowLog.entries)
+}
+
+// empties the log; the entry ids keep increasing
+func (dss *dataStoreSet) resetSlowLog() {
+	dss.slowLog.mu.Lock()
+	defer dss.slowLog.mu.Unlock()
+	dss.slowLog.entries = nil
+}
+
+// provides the arguments of a command as the slow log keeps them: the
+// arguments beyond 32 are summarized in the last one, and long arguments
+// are cut at 128 bytes
+func slowLogArgs(rawArgs respArray) (args []string) {
+	count := len(rawArgs)
+	if count > slowLogMaxArgs {
+		count = slowLogMaxArgs - 1
+	}
+
+	args = make([]string, 0, min(len(rawArgs), slowLogMaxArgs))
+	for _, rawArg := range rawArgs[:count] {
+		text, _ := rawArg.toString()
+		if len(text) > slowLogMaxString {
+			text = fmt.Sprintf("%s... (%d more bytes)", text[:slowLogMaxString], len(text)-slowLogMaxString)
+		}
+		args = append(args, text)
+	}
+
+	if count < len(rawArgs) {
+		args = append(args, fmt.Sprintf("... (%d more arguments)", len(rawArgs)-count))
+	}
+	return
+}
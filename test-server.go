@@ -7,6 +7,7 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -23,11 +24,14 @@ type (
 		cancelFn context.CancelFunc
 		wg       sync.WaitGroup
 		hook     DispatchHook
+		funcs    *functionRegistry
+		config   *serverConfig
 
 		port            int
 		iface           string
 		persistBasePath string
 		quitSignal      chan struct{}
+		noFinalSave     bool // SHUTDOWN has already decided about the final save
 
 		disableClientSetInfo bool // special flag for redis client issue
 	}
@@ -43,6 +47,8 @@ func NewEmulator(l lane.Lane, port int, iface string, persistBasePath string, qu
 		iface:           iface,
 		persistBasePath: persistBasePath,
 		quitSignal:      quitSignal,
+		funcs:           newFunctionRegistry(),
+		config:          newServerConfig(),
 	}
 
 	return
@@ -66,7 +72,9 @@ func (eng *RedisEmu) Start() {
 		fmt.Printf("\r\n\r\nREDIS Emulator is now running\r\n\r\nPress any key to quit\r\n\r\n")
 	}
 
-	eng.dss = newDataStoreSet(eng.l, eng.persistBasePath, &eng.hook)
+	eng.dss = newDataStoreSet(eng.l, eng.persistBasePath, &eng.hook, eng.config)
+	eng.dss.functions = eng.funcs
+	eng.dss.shutdown = eng.shutdown
 
 	// launch termination monitiors
 	eng.killSignalMonitor()
@@ -78,6 +86,9 @@ func (eng *RedisEmu) Start() {
 	// launch periodic save goroutine
 	eng.periodicSave()
 
+	// launch the expiration cycle that delivers keyspace expiry events
+	eng.periodicExpire()
+
 	// start accepting connections and processing them
 	eng.startServer()
 }
@@ -98,6 +109,17 @@ func (eng *RedisEmu) RequestTermination() {
 	}
 }
 
+// terminates the server for the SHUTDOWN command, disconnecting its clients
+func (eng *RedisEmu) shutdown() {
+	eng.mu.Lock()
+	eng.noFinalSave = true
+	eng.mu.Unlock()
+
+	eng.RequestTermination()
+
+	eng.dss.requestAllCxnClose()
+}
+
 func (eng *RedisEmu) killSignalMonitor() {
 	// register a graceful termination handler
 	sigs := make(chan os.Signal, 10)
@@ -156,16 +178,42 @@ func (eng *RedisEmu) periodicSave() {
 				case <-eng.l.Done():
 					eng.l.Debug("saver loop canceled")
 					timer.Stop()
-					eng.dss.save(eng.l)
+					eng.mu.Lock()
+					noFinalSave := eng.noFinalSave
+					eng.mu.Unlock()
+					if !noFinalSave {
+						eng.dss.save(eng.l, nil)
+					}
 					return
 				case <-timer.C:
-					eng.dss.save(eng.l)
+					if eng.dss.isSaveDue() {
+						eng.dss.save(eng.l, nil)
+					}
 				}
 			}
 		}()
 	}
 }
 
+func (eng *RedisEmu) periodicExpire() {
+	eng.wg.Add(1)
+	go func() {
+		defer eng.wg.Done()
+
+		timer := time.NewTicker(100 * time.Millisecond)
+		for {
+			select {
+			case <-eng.l.Done():
+				eng.l.Debug("expire loop canceled")
+				timer.Stop()
+				return
+			case <-timer.C:
+				eng.dss.activeExpire()
+			}
+		}
+	}()
+}
+
 func (eng *RedisEmu) startServer() {
 	// establish socket service
 	var err error
@@ -226,6 +274,12 @@ func (eng *RedisEmu) startServer() {
 				}
 				break
 			}
+			if int64(eng.dss.clientCount()) >= eng.config.intValue("maxclients") {
+				eng.l.Infof("client rejected, maxclients reached: %s", connection.RemoteAddr().String())
+				connection.Write([]byte("-ERR max number of clients reached\r\n"))
+				connection.Close()
+				continue
+			}
 			eng.l.Infof("client connected: %s", connection.RemoteAddr().String())
 			newClientCxn(eng.l, connection, dispatcher)
 		}
@@ -244,9 +298,48 @@ func (eng *RedisEmu) Close() {
 	eng.WaitForTermination()
 }
 
+// Sets a configuration parameter, like CONFIG SET. Parameters that CONFIG SET
+// can't change, such as "databases", can be set before Start.
+func (eng *RedisEmu) SetConfig(name, value string) error {
+	name = strings.ToLower(name)
+	if errText := eng.config.set(name, value, true); errText != "" {
+		return fmt.Errorf("%s: %s", name, errText)
+	}
+
+	// a running emulator applies the value now
+	if apply := configParams[name].apply; apply != nil && eng.dss != nil {
+		apply(eng.dss, eng.config.get(name))
+	}
+	return nil
+}
+
+// Reads the directives of a redis.conf file, if it exists, and makes it the
+// file that CONFIG REWRITE writes. This must be called before Start.
+func (eng *RedisEmu) SetConfigFile(fileName string) error {
+	return eng.config.loadFile(fileName)
+}
+
 func (eng *RedisEmu) SetHook(hook DispatchHook) {
 	eng.mu.Lock()
 	defer eng.mu.Unlock()
 
 	eng.hook = hook
 }
+
+// Registers a library of Go functions for FCALL and FCALL_RO, and loads it,
+// replacing a loaded library of the same name. The library can be loaded
+// again after FUNCTION DELETE or FUNCTION FLUSH by FUNCTION LOAD or FUNCTION
+// RESTORE of library code that starts with "#!lua name=<libraryName>".
+func (eng *RedisEmu) RegisterFunctionLibrary(libraryName string, functions ...LibraryFunction) error {
+	return eng.funcs.register(libraryName, functions)
+}
+
+// Provides the entries of the slow log, newest first, like SLOWLOG GET -1.
+// The threshold and length of the log are the slowlog-log-slower-than and
+// slowlog-max-len parameters.
+func (eng *RedisEmu) SlowLog() []SlowLogEntry {
+	if eng.dss == nil {
+		return nil
+	}
+	return eng.dss.slowLogEntries(-1)
+}
@@ -2,8 +2,15 @@ package redisemu
 
 import (
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
 )
 
+// passed to unblock when every waiting client can consume the change
+const unblockAllWaiters = math.MaxInt
+
 type (
 	// wakeSignal corresponds to a client that is waiting
 	wakeSignal struct {
@@ -203,6 +210,12 @@ func (wt *waitTable) unblock(name string, elements int) {
 }
 
 func (wt *waitTable) dump() {
+	wt.dumpTo(os.Stdout)
+}
+
+// writes the waiting clients and the wait queues of each object, after
+// verifying that the links are consistent
+func (wt *waitTable) dumpTo(w io.Writer) {
 	signals := map[int]*wakeSignal{}
 
 	// go through all of the keys, and develop a list of waiting clients
@@ -236,29 +249,42 @@ func (wt *waitTable) dump() {
 		}
 	}
 
-	fmt.Printf("Waiting clients:\n")
-	for _, signal := range signals {
+	// the output is ordered, so that dumps can be compared
+	ids := make([]int, 0, len(signals))
+	for id := range signals {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	names := make([]string, 0, len(wt.table))
+	for name := range wt.table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "Waiting clients:\n")
+	for _, id := range ids {
+		signal := signals[id]
 		if signal.objectsHead == signal.objectsTail {
 			ref := signal.objectsHead
 			if ref.objectsNext != nil || ref.objectsPrev != nil {
 				panic("signal's list list is corrupt")
 			}
-			fmt.Printf(" Signal %d is waiting on object %s\n", signal.id, ref.waitList.name)
+			fmt.Fprintf(w, " Signal %d is waiting on object %s\n", signal.id, ref.waitList.name)
 		} else {
-			fmt.Printf(" Signal %d waits on multiple objects:\n", signal.id)
+			fmt.Fprintf(w, " Signal %d waits on multiple objects:\n", signal.id)
 			for ref := signal.objectsHead; ref != nil; ref = ref.objectsNext {
-				fmt.Printf("  waiting on object %s\n", ref.waitList.name)
+				fmt.Fprintf(w, "  waiting on object %s\n", ref.waitList.name)
 			}
 		}
 	}
-	fmt.Println()
+	fmt.Fprintln(w)
 
-	fmt.Printf("Object wait queues:\n")
-	for name, list := range wt.table {
-		fmt.Printf(" Object %s list:\n", name)
-		for w := list.queueHead; w != nil; w = w.queueNext {
-			fmt.Printf("  signal %d waiting\n", w.signal.id)
+	fmt.Fprintf(w, "Object wait queues:\n")
+	for _, name := range names {
+		fmt.Fprintf(w, " Object %s list:\n", name)
+		for ref := wt.table[name].queueHead; ref != nil; ref = ref.queueNext {
+			fmt.Fprintf(w, "  signal %d waiting\n", ref.signal.id)
 		}
 	}
-	fmt.Println("----")
+	fmt.Fprintln(w, "----")
 }